@@ -0,0 +1,173 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package arbnode
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync/atomic"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+	flag "github.com/spf13/pflag"
+)
+
+var (
+	compressionLevelGauge       = metrics.NewRegisteredGauge("arb/batchposter/compression/level", nil)
+	compressionCPUPercentGauge  = metrics.NewRegisteredGauge("arb/batchposter/compression/cpu_percent", nil)
+	compressionLevelChangeCount = metrics.NewRegisteredCounter("arb/batchposter/compression/level_changed", nil)
+)
+
+var errProcessCPUTimeUnsupported = errors.New("reading the process cpu time isn't supported on this platform")
+
+// The compression level is only raised again once the CPU usage dropped this far below the target,
+// and the sequencing latency this far below its maximum, so that the level doesn't oscillate
+const adaptiveCompressionHysteresis = 0.75
+
+type AdaptiveCompressionConfig struct {
+	Enable               bool          `koanf:"enable" reload:"hot"`
+	MinLevel             int           `koanf:"min-level" reload:"hot"`
+	MaxLevel             int           `koanf:"max-level" reload:"hot"`
+	TargetCPU            float64       `koanf:"target-cpu" reload:"hot"`
+	MaxSequencingLatency time.Duration `koanf:"max-sequencing-latency" reload:"hot"`
+	AdjustInterval       time.Duration `koanf:"adjust-interval" reload:"hot"`
+}
+
+var DefaultAdaptiveCompressionConfig = AdaptiveCompressionConfig{
+	Enable:               false,
+	MinLevel:             4,
+	MaxLevel:             brotli.BestCompression,
+	TargetCPU:            0.7,
+	MaxSequencingLatency: 50 * time.Millisecond,
+	AdjustInterval:       10 * time.Second,
+}
+
+func AdaptiveCompressionConfigAddOptions(prefix string, f *flag.FlagSet) {
+	f.Bool(prefix+".enable", DefaultAdaptiveCompressionConfig.Enable, "adjust the batch compression level between the min and max level to the CPU headroom and sequencing latency, instead of always using the compression-level")
+	f.Int(prefix+".min-level", DefaultAdaptiveCompressionConfig.MinLevel, "lowest compression level used while the node is short on CPU")
+	f.Int(prefix+".max-level", DefaultAdaptiveCompressionConfig.MaxLevel, "highest compression level used while the node has CPU headroom")
+	f.Float64(prefix+".target-cpu", DefaultAdaptiveCompressionConfig.TargetCPU, "fraction of the available CPUs the node may use before the compression level is lowered")
+	f.Duration(prefix+".max-sequencing-latency", DefaultAdaptiveCompressionConfig.MaxSequencingLatency, "average time to write a sequenced message above which the compression level is lowered (0 = ignore the sequencing latency)")
+	f.Duration(prefix+".adjust-interval", DefaultAdaptiveCompressionConfig.AdjustInterval, "interval between adjustments of the compression level")
+}
+
+func (c *AdaptiveCompressionConfig) Validate() error {
+	if !c.Enable {
+		return nil
+	}
+	if c.MinLevel < brotli.BestSpeed || c.MaxLevel > brotli.BestCompression || c.MinLevel > c.MaxLevel {
+		return fmt.Errorf("invalid adaptive compression levels [%d, %d], expected %d <= min-level <= max-level <= %d", c.MinLevel, c.MaxLevel, brotli.BestSpeed, brotli.BestCompression)
+	}
+	if c.TargetCPU <= 0 || c.TargetCPU > 1 {
+		return fmt.Errorf("invalid adaptive compression target cpu %v, expected a fraction in (0, 1]", c.TargetCPU)
+	}
+	if c.AdjustInterval <= 0 {
+		return errors.New("the adaptive compression adjust interval must be positive")
+	}
+	return nil
+}
+
+// compressionLevelController lowers the compression level one step at a time while the node's CPU usage or
+// the sequencing latency is above target, and raises it back while there's headroom. Higher levels shrink
+// batches, lowering posting costs, at the price of the CPU time that sequencing competes for.
+type compressionLevelController struct {
+	config BatchPosterConfigFetcher
+	// The process CPU time consumed so far, returns errProcessCPUTimeUnsupported where it can't be read
+	cpuTime func() (time.Duration, error)
+	// The recent average time to write a sequenced message, 0 if unknown
+	sequencingLatency func() time.Duration
+	// Written by the adjustment loop, read when a batch is started
+	level      atomic.Int64
+	lastCPU    time.Duration
+	lastSample time.Time
+}
+
+func newCompressionLevelController(config BatchPosterConfigFetcher, sequencingLatency func() time.Duration) *compressionLevelController {
+	c := &compressionLevelController{
+		config:            config,
+		cpuTime:           processCPUTime,
+		sequencingLatency: sequencingLatency,
+	}
+	c.level.Store(int64(config().AdaptiveCompression.MaxLevel))
+	return c
+}
+
+// compressionLevel returns the level new batches are compressed with
+func (c *compressionLevelController) compressionLevel() int {
+	config := c.config()
+	if !config.AdaptiveCompression.Enable {
+		return config.CompressionLevel
+	}
+	adaptive := &config.AdaptiveCompression
+	// The bounds may have been changed by a config reload since the last adjustment
+	return min(max(int(c.level.Load()), adaptive.MinLevel), adaptive.MaxLevel)
+}
+
+// cpuUsage returns the fraction of the available CPUs used by the process since the previous sample,
+// and false if it's unknown
+func (c *compressionLevelController) cpuUsage(now time.Time) (float64, bool) {
+	cpu, err := c.cpuTime()
+	if err != nil {
+		if !errors.Is(err, errProcessCPUTimeUnsupported) {
+			log.Warn("failed to read the process cpu time", "err", err)
+		}
+		return 0, false
+	}
+	lastCPU, lastSample := c.lastCPU, c.lastSample
+	c.lastCPU, c.lastSample = cpu, now
+	if lastSample.IsZero() || !now.After(lastSample) {
+		return 0, false
+	}
+	wall := now.Sub(lastSample) * time.Duration(runtime.GOMAXPROCS(0))
+	return float64(cpu-lastCPU) / float64(wall), true
+}
+
+// adjust moves the compression level one step according to the resource usage since the previous call
+func (c *compressionLevelController) adjust(now time.Time) int {
+	config := &c.config().AdaptiveCompression
+	level := c.compressionLevel()
+	usage, haveUsage := c.cpuUsage(now)
+	latency := c.sequencingLatency()
+	if haveUsage {
+		compressionCPUPercentGauge.Update(int64(usage * 100))
+	}
+	overloaded := haveUsage && usage > config.TargetCPU
+	idle := !haveUsage || usage < config.TargetCPU*adaptiveCompressionHysteresis
+	if config.MaxSequencingLatency > 0 {
+		if latency > config.MaxSequencingLatency {
+			overloaded = true
+		}
+		if float64(latency) >= float64(config.MaxSequencingLatency)*adaptiveCompressionHysteresis {
+			idle = false
+		}
+	}
+	newLevel := level
+	if overloaded && level > config.MinLevel {
+		newLevel = level - 1
+	} else if !overloaded && idle && level < config.MaxLevel {
+		newLevel = level + 1
+	}
+	if newLevel != level {
+		compressionLevelChangeCount.Inc(1)
+		log.Info("adjusted the batch compression level", "level", newLevel, "previous", level, "cpuUsage", usage, "sequencingLatency", latency)
+	}
+	c.level.Store(int64(newLevel))
+	compressionLevelGauge.Update(int64(newLevel))
+	return newLevel
+}
+
+func (c *compressionLevelController) adjustLoop(ctx context.Context) time.Duration {
+	config := &c.config().AdaptiveCompression
+	if !config.Enable {
+		// Keep sampling the CPU time, so that the first adjustment once enabled is accurate
+		c.cpuUsage(time.Now())
+		return DefaultAdaptiveCompressionConfig.AdjustInterval
+	}
+	c.adjust(time.Now())
+	return config.AdjustInterval
+}
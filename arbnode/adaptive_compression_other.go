@@ -0,0 +1,15 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+//go:build !unix
+
+package arbnode
+
+import (
+	"time"
+)
+
+// Without the process CPU time only the sequencing latency is taken into account
+func processCPUTime() (time.Duration, error) {
+	return 0, errProcessCPUTimeUnsupported
+}
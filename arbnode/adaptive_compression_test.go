@@ -0,0 +1,64 @@
+package arbnode
+
+import (
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestCompressionLevelController(t *testing.T) {
+	config := TestBatchPosterConfig
+	config.AdaptiveCompression = DefaultAdaptiveCompressionConfig
+	config.AdaptiveCompression.Enable = true
+	config.AdaptiveCompression.MinLevel = 4
+	config.AdaptiveCompression.MaxLevel = 6
+	Require(t, config.AdaptiveCompression.Validate())
+
+	var cpu, latency time.Duration
+	c := newCompressionLevelController(func() *BatchPosterConfig { return &config }, func() time.Duration { return latency })
+	c.cpuTime = func() (time.Duration, error) { return cpu, nil }
+	if level := c.compressionLevel(); level != 6 {
+		Fail(t, "unexpected initial level", level)
+	}
+
+	now := time.Unix(1000, 0)
+	procs := time.Duration(runtime.GOMAXPROCS(0))
+	// The first sample only sets the baseline
+	c.adjust(now)
+	step := func(usage float64) int {
+		now = now.Add(time.Second)
+		cpu += time.Duration(usage * float64(time.Second*procs))
+		return c.adjust(now)
+	}
+
+	for _, expected := range []int{5, 4, 4} {
+		if level := step(0.9); level != expected {
+			Fail(t, "unexpected level while overloaded", level, "expected", expected)
+		}
+	}
+	// Within the hysteresis band the level is kept
+	if level := step(0.6); level != 4 {
+		Fail(t, "level changed within the hysteresis band", level)
+	}
+	for _, expected := range []int{5, 6, 6} {
+		if level := step(0.1); level != expected {
+			Fail(t, "unexpected level while idle", level, "expected", expected)
+		}
+	}
+
+	latency = 2 * config.AdaptiveCompression.MaxSequencingLatency
+	if level := step(0.1); level != 5 {
+		Fail(t, "level not lowered for the sequencing latency", level)
+	}
+	latency = 0
+
+	// Reloaded bounds apply right away
+	config.AdaptiveCompression.MaxLevel = 4
+	if level := c.compressionLevel(); level != 4 {
+		Fail(t, "level not clamped to the reloaded bounds", level)
+	}
+	config.AdaptiveCompression.Enable = false
+	if level := c.compressionLevel(); level != config.CompressionLevel {
+		Fail(t, "disabled controller didn't use the static level", level)
+	}
+}
@@ -2,12 +2,17 @@ package arbnode
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rpc"
 	"github.com/offchainlabs/nitro/arbutil"
+	"github.com/offchainlabs/nitro/broadcastclients"
+	m "github.com/offchainlabs/nitro/broadcaster/message"
 	"github.com/offchainlabs/nitro/staker"
 	"github.com/offchainlabs/nitro/validator"
 )
@@ -54,3 +59,352 @@ func (a *BlockValidatorDebugAPI) ValidateMessageNumber(
 	result.Valid = valid
 	return result, err
 }
+
+type TransactionStreamerAPI struct {
+	streamer *TransactionStreamer
+}
+
+// ReorgHistory returns the most recent reorgs seen by the node, newest first.
+// If limit is not provided, all stored records are returned.
+func (a *TransactionStreamerAPI) ReorgHistory(ctx context.Context, limit *hexutil.Uint64) ([]ReorgRecord, error) {
+	var maxRecords uint64
+	if limit != nil {
+		maxRecords = uint64(*limit)
+	}
+	return a.streamer.GetReorgHistory(maxRecords)
+}
+
+// EspressoActivationPos returns the position of the first message sequenced through Espresso,
+// or nil if Espresso sequencing hasn't been activated yet.
+func (a *TransactionStreamerAPI) EspressoActivationPos(ctx context.Context) (*hexutil.Uint64, error) {
+	pos, err := a.streamer.GetEspressoActivationPos()
+	if err != nil || pos == nil {
+		return nil, err
+	}
+	res := hexutil.Uint64(*pos)
+	return &res, nil
+}
+
+// RecoveryReport inspects the streamer, inbox, coordinator and espresso state and returns the
+// detected inconsistencies, each with a suggested recovery action.
+func (a *TransactionStreamerAPI) RecoveryReport(ctx context.Context) (*RecoveryReport, error) {
+	return a.streamer.DiagnoseRecovery(ctx)
+}
+
+// ReorgPreview returns what a reorg to count would remove and resequence, without applying it.
+// At most limit messages are listed, all of them are counted.
+func (a *TransactionStreamerAPI) ReorgPreview(ctx context.Context, count hexutil.Uint64, limit *hexutil.Uint64) (*ReorgPreview, error) {
+	var maxMessages uint64
+	if limit != nil {
+		maxMessages = uint64(*limit)
+	}
+	return a.streamer.PreviewReorg(ctx, arbutil.MessageIndex(count), maxMessages)
+}
+
+// Checkpoint returns a consistent snapshot of the message count, the espresso submission state
+// and the broadcaster queue position, which can be restored with RestoreFromCheckpoint.
+func (a *TransactionStreamerAPI) Checkpoint(ctx context.Context) (*StreamerCheckpoint, error) {
+	return a.streamer.Checkpoint()
+}
+
+// ReplayEspresso re-derives the espresso block, namespace proof and justification of the messages in [from, to]
+// from a HotShot query node, and diffs them against the stored ones.
+func (a *TransactionStreamerAPI) ReplayEspresso(ctx context.Context, from hexutil.Uint64, to hexutil.Uint64) (*EspressoReplayReport, error) {
+	return a.streamer.ReplayEspresso(ctx, arbutil.MessageIndex(from), arbutil.MessageIndex(to))
+}
+
+// KillSwitch returns the last honored kill switch message, or nil if none was received
+func (a *TransactionStreamerAPI) KillSwitch(ctx context.Context) (*m.KillSwitchMessage, error) {
+	return a.streamer.KillSwitch(), nil
+}
+
+// SubmitKillSwitch honors a kill switch message signed by the chain owner and broadcasts it to the node's feed
+func (a *TransactionStreamerAPI) SubmitKillSwitch(ctx context.Context, killSwitch m.KillSwitchMessage) error {
+	return a.streamer.AddKillSwitchMessage(&killSwitch)
+}
+
+// FindMessageByTimestamp returns the position of the first message with a header timestamp at or after
+// timestamp, or nil if there's none.
+func (a *TransactionStreamerAPI) FindMessageByTimestamp(ctx context.Context, timestamp hexutil.Uint64) (*hexutil.Uint64, error) {
+	pos, err := a.streamer.FindMessageByTimestamp(uint64(timestamp))
+	if err != nil || pos == nil {
+		return nil, err
+	}
+	res := hexutil.Uint64(*pos)
+	return &res, nil
+}
+
+// FindMessagesByL1Block returns the positions of the messages with the L1 block number in their header
+func (a *TransactionStreamerAPI) FindMessagesByL1Block(ctx context.Context, blockNumber hexutil.Uint64) ([]hexutil.Uint64, error) {
+	positions, err := a.streamer.FindMessagesByL1Block(uint64(blockNumber))
+	if err != nil {
+		return nil, err
+	}
+	res := make([]hexutil.Uint64, 0, len(positions))
+	for _, pos := range positions {
+		res = append(res, hexutil.Uint64(pos))
+	}
+	return res, nil
+}
+
+// MessageData returns the RLP encoded message at pos, so that nodes with pruned history can use this node
+// as their message archive
+func (a *TransactionStreamerAPI) MessageData(ctx context.Context, pos hexutil.Uint64) (hexutil.Bytes, error) {
+	return a.streamer.GetEncodedMessage(arbutil.MessageIndex(pos))
+}
+
+// MessageTelemetry returns when each message in [from, to) reached the stages of its lifecycle, in unix milliseconds
+func (a *TransactionStreamerAPI) MessageTelemetry(ctx context.Context, from hexutil.Uint64, to hexutil.Uint64) ([]MessageTelemetry, error) {
+	return a.streamer.GetMessageTelemetry(ctx, arbutil.MessageIndex(from), arbutil.MessageIndex(to))
+}
+
+// MessageLatencyBreakdown summarizes the latencies between the lifecycle stages of the messages in [from, to)
+func (a *TransactionStreamerAPI) MessageLatencyBreakdown(ctx context.Context, from hexutil.Uint64, to hexutil.Uint64) (*MessageLatencyBreakdown, error) {
+	return a.streamer.MessageLatencyBreakdown(ctx, arbutil.MessageIndex(from), arbutil.MessageIndex(to))
+}
+
+// VerifyMessageChain recomputes the message hash chain over the messages in [from, to) and checks the stored
+// accumulators against it
+func (a *TransactionStreamerAPI) VerifyMessageChain(ctx context.Context, from hexutil.Uint64, to hexutil.Uint64) (*MessageChainVerification, error) {
+	return a.streamer.VerifyMessageChain(ctx, arbutil.MessageIndex(from), arbutil.MessageIndex(to))
+}
+
+// MessageChainAccumulator returns the stored accumulator of the message hash chain over the messages before count
+func (a *TransactionStreamerAPI) MessageChainAccumulator(ctx context.Context, count hexutil.Uint64) (*common.Hash, error) {
+	return a.streamer.GetMessageChainAccumulator(arbutil.MessageIndex(count))
+}
+
+// Messages creates a subscription streaming the canonical messages from position from on, with their block hash and
+// espresso finality. When a reorg changes messages already sent, a reorg event is sent and the stream continues from
+// the first changed message. If the messages can't be read, a last event holding the error ends the stream. The
+// same stream is served to gRPC clients when the message stream service is enabled, see message_stream.proto.
+func (a *TransactionStreamerAPI) Messages(ctx context.Context, from hexutil.Uint64) (*rpc.Subscription, error) {
+	notifier, supported := rpc.NotifierFromContext(ctx)
+	if !supported {
+		return &rpc.Subscription{}, rpc.ErrNotificationsUnsupported
+	}
+	streamerCtx, err := a.streamer.GetContextSafe()
+	if err != nil {
+		return nil, err
+	}
+	iter, err := a.streamer.MessageIterator(arbutil.MessageIndex(from))
+	if err != nil {
+		return nil, err
+	}
+	rpcSub := notifier.CreateSubscription()
+	go func() {
+		iterCtx, cancel := context.WithCancel(streamerCtx)
+		defer cancel()
+		go func() {
+			select {
+			case <-rpcSub.Err():
+				cancel()
+			case <-iterCtx.Done():
+			}
+		}()
+		for {
+			ev, err := iter.NextEvent(iterCtx)
+			if err != nil {
+				if iterCtx.Err() != nil && streamerCtx.Err() == nil {
+					// The subscriber went away
+					return
+				}
+				if streamerCtx.Err() != nil {
+					err = errors.New("the node is stopping")
+				} else {
+					log.Warn("failed to read the message stream", "pos", iter.Position(), "err", err)
+				}
+				// Tell the subscriber why the stream ended instead of leaving it waiting
+				_ = notifier.Notify(rpcSub.ID, &MessageStreamEvent{Position: iter.Position(), Error: err.Error()})
+				return
+			}
+			if err := notifier.Notify(rpcSub.ID, ev); err != nil {
+				return
+			}
+		}
+	}()
+	return rpcSub, nil
+}
+
+type BroadcastClientsAPI struct {
+	clients *broadcastclients.BroadcastClients
+}
+
+// FeedSourceSelections returns the past selections of the preferred feed source with the latency and
+// completeness of every source they were based on, oldest first.
+func (a *BroadcastClientsAPI) FeedSourceSelections(ctx context.Context) ([]broadcastclients.FeedSourceSelection, error) {
+	return a.clients.SourceSelectionHistory(), nil
+}
+
+type InboxTrackerAPI struct {
+	tracker *InboxTracker
+}
+
+// DelayedMessages creates a subscription that fires for every new delayed message read from the parent chain.
+// If kinds is non-empty, only delayed messages of those kinds are sent.
+func (a *InboxTrackerAPI) DelayedMessages(ctx context.Context, kinds []uint64) (*rpc.Subscription, error) {
+	notifier, supported := rpc.NotifierFromContext(ctx)
+	if !supported {
+		return &rpc.Subscription{}, rpc.ErrNotificationsUnsupported
+	}
+	wantKind := make(map[uint64]bool, len(kinds))
+	for _, kind := range kinds {
+		wantKind[kind] = true
+	}
+	rpcSub := notifier.CreateSubscription()
+	go func() {
+		events := make(chan DelayedMessageEvent, 128)
+		sub := a.tracker.SubscribeDelayedMessages(events)
+		defer sub.Unsubscribe()
+		for {
+			select {
+			case ev := <-events:
+				if len(wantKind) > 0 && !wantKind[uint64(ev.Kind)] {
+					continue
+				}
+				if err := notifier.Notify(rpcSub.ID, ev); err != nil {
+					return
+				}
+			case <-rpcSub.Err():
+				return
+			case err := <-sub.Err():
+				if err != nil {
+					log.Warn("delayed message subscription dropped", "id", rpcSub.ID, "err", err)
+				}
+				return
+			}
+		}
+	}()
+	return rpcSub, nil
+}
+
+type EspressoAPI struct {
+	streamer *TransactionStreamer
+}
+
+// Status returns the pending and submitted message positions, the in-flight transaction hash,
+// and the last HotShot block whose messages were confirmed.
+func (a *EspressoAPI) Status(ctx context.Context) (*EspressoStatus, error) {
+	return a.streamer.GetEspressoStatus()
+}
+
+// ShadowReport compares the espresso finality latency of the limit most recent messages (1000 if not provided)
+// against the latency of their posting to the parent chain.
+func (a *EspressoAPI) ShadowReport(ctx context.Context, limit *hexutil.Uint64) (*EspressoShadowReport, error) {
+	var maxMessages uint64
+	if limit != nil {
+		maxMessages = uint64(*limit)
+	}
+	return a.streamer.EspressoShadowReport(ctx, maxMessages)
+}
+
+// MaxFinalizedIndex returns the highest message position up to which every message sequenced through espresso
+// has a complete justification, or nil if there's none.
+func (a *EspressoAPI) MaxFinalizedIndex(ctx context.Context) (*hexutil.Uint64, error) {
+	index, err := a.streamer.MaxEspressoFinalizedIndex()
+	if err != nil || index == nil {
+		return nil, err
+	}
+	res := hexutil.Uint64(*index)
+	return &res, nil
+}
+
+// VerificationHalt returns the last halt on repeated failed verifications of the headers and proofs fetched from
+// the query service, or nil if the node never halted on them.
+func (a *EspressoAPI) VerificationHalt(ctx context.Context) (*EspressoVerificationHalt, error) {
+	return a.streamer.GetEspressoVerificationHalt()
+}
+
+// MessagesInBlock returns the positions of the messages finalized in the HotShot block at height
+func (a *EspressoAPI) MessagesInBlock(ctx context.Context, height hexutil.Uint64) ([]hexutil.Uint64, error) {
+	positions, err := a.streamer.GetMessagesInEspressoBlock(uint64(height))
+	if err != nil {
+		return nil, err
+	}
+	res := make([]hexutil.Uint64, 0, len(positions))
+	for _, pos := range positions {
+		res = append(res, hexutil.Uint64(pos))
+	}
+	return res, nil
+}
+
+type EspressoSubmissionRecordResult struct {
+	Status    string         `json:"status"`
+	TxHash    string         `json:"txHash,omitempty"`
+	QueuedAt  hexutil.Uint64 `json:"queuedAt"`
+	UpdatedAt hexutil.Uint64 `json:"updatedAt"`
+	// How long the message has been waiting for HotShot inclusion, if it isn't finalized yet
+	WaitingSeconds *hexutil.Uint64 `json:"waitingSeconds,omitempty"`
+}
+
+// SubmissionRecord returns the espresso submission state of the message at pos,
+// or nil if the message was never queued for submission.
+func (a *EspressoAPI) SubmissionRecord(ctx context.Context, pos hexutil.Uint64) (*EspressoSubmissionRecordResult, error) {
+	record, err := a.streamer.GetEspressoSubmissionRecord(arbutil.MessageIndex(pos))
+	if err != nil || record == nil {
+		return nil, err
+	}
+	res := &EspressoSubmissionRecordResult{
+		Status:    record.Status.String(),
+		TxHash:    record.TxHash,
+		QueuedAt:  hexutil.Uint64(record.QueuedAt),
+		UpdatedAt: hexutil.Uint64(record.UpdatedAt),
+	}
+	// #nosec G115
+	now := uint64(time.Now().Unix())
+	if record.Status != EspressoSubmissionFinalized && record.QueuedAt != 0 && now > record.QueuedAt {
+		waiting := hexutil.Uint64(now - record.QueuedAt)
+		res.WaitingSeconds = &waiting
+	}
+	return res, nil
+}
+
+// EspressoAdminAPI repairs the espresso submission state. It's only served on the authenticated rpc endpoint.
+type EspressoAdminAPI struct {
+	streamer *TransactionStreamer
+}
+
+// ListEspressoState returns the whole espresso submission state
+func (a *EspressoAdminAPI) ListEspressoState(ctx context.Context) (*EspressoStateListing, error) {
+	return a.streamer.ListEspressoState()
+}
+
+// ClearSubmittedTransaction forgets the in-flight espresso transaction and queues its messages again,
+// returning their positions
+func (a *EspressoAdminAPI) ClearSubmittedTransaction(ctx context.Context) ([]hexutil.Uint64, error) {
+	requeued, err := a.streamer.ClearEspressoSubmittedTransaction()
+	if err != nil {
+		return nil, err
+	}
+	res := make([]hexutil.Uint64, 0, len(requeued))
+	for _, pos := range requeued {
+		res = append(res, hexutil.Uint64(pos))
+	}
+	return res, nil
+}
+
+// RequeuePosition queues the message at pos for espresso submission again
+func (a *EspressoAdminAPI) RequeuePosition(ctx context.Context, pos hexutil.Uint64) error {
+	return a.streamer.RequeueEspressoPosition(arbutil.MessageIndex(pos))
+}
+
+// DropPendingPosition removes the message at pos from the espresso pending queue
+func (a *EspressoAdminAPI) DropPendingPosition(ctx context.Context, pos hexutil.Uint64) error {
+	return a.streamer.DropEspressoPendingPosition(arbutil.MessageIndex(pos))
+}
+
+// DeadLetters returns the messages moved out of the espresso pending queue after repeatedly failing submission
+func (a *EspressoAdminAPI) DeadLetters(ctx context.Context) ([]EspressoDeadLetter, error) {
+	return a.streamer.GetEspressoDeadLetters()
+}
+
+// RequeueDeadLetter queues the dead-lettered message at pos for espresso submission again
+func (a *EspressoAdminAPI) RequeueDeadLetter(ctx context.Context, pos hexutil.Uint64) error {
+	return a.streamer.RequeueEspressoDeadLetter(arbutil.MessageIndex(pos))
+}
+
+// ForceIncludeDelayed sequences the pending delayed messages until count delayed messages are read, and queues
+// them for espresso submission if it's enabled
+func (a *EspressoAdminAPI) ForceIncludeDelayed(ctx context.Context, count hexutil.Uint64) (*ForceIncludeResult, error) {
+	return a.streamer.ForceIncludeDelayed(ctx, uint64(count))
+}
@@ -34,7 +34,6 @@ import (
 	"github.com/ethereum/go-ethereum/rlp"
 	"github.com/ethereum/go-ethereum/rpc"
 
-	hotshotClient "github.com/EspressoSystems/espresso-sequencer-go/client"
 	lightclient "github.com/EspressoSystems/espresso-sequencer-go/light-client"
 
 	"github.com/offchainlabs/nitro/arbnode/dataposter"
@@ -76,6 +75,9 @@ var (
 
 	batchPosterFailureCounter = metrics.NewRegisteredCounter("arb/batchPoster/action/failure", nil)
 
+	batchPosterEspressoUnjustifiedGauge         = metrics.NewRegisteredGauge("arb/batchposter/espresso/unjustified", nil)
+	batchPosterEspressoPostedUnjustifiedCounter = metrics.NewRegisteredCounter("arb/batchposter/espresso/posted_unjustified", nil)
+
 	usableBytesInBlob    = big.NewInt(int64(len(kzg4844.Blob{}) * 31 / 32))
 	blobTxBlobGasPerBlob = big.NewInt(params.BlobTxBlobGasPerBlob)
 )
@@ -126,6 +128,8 @@ type BatchPoster struct {
 	postedFirstBatch     bool        // indicates if batch poster has posted the first batch
 
 	accessList func(SequencerInboxAccs, AfterDelayedMessagesRead uint64) types.AccessList
+
+	compression *compressionLevelController
 }
 
 type l1BlockBound int
@@ -140,6 +144,15 @@ const (
 	l1BlockBoundIgnore
 )
 
+// What to do when the candidate batch reaches a message that hasn't passed espresso verification yet
+type espressoUnjustifiedBehavior int
+
+const (
+	espressoUnjustifiedWait espressoUnjustifiedBehavior = iota + 1
+	espressoUnjustifiedPost
+	espressoUnjustifiedSplit
+)
+
 type BatchPosterDangerousConfig struct {
 	AllowPostingFirstBatchWhenSequencerMessageCountMismatch bool `koanf:"allow-posting-first-batch-when-sequencer-message-count-mismatch"`
 }
@@ -178,21 +191,39 @@ type BatchPosterConfig struct {
 	CheckBatchCorrectness          bool                        `koanf:"check-batch-correctness"`
 	gasRefunder                    common.Address
 	l1BlockBound                   l1BlockBound
+	// Adjusts the compression level to the CPU headroom and sequencing latency, overriding compression-level when enabled
+	AdaptiveCompression AdaptiveCompressionConfig `koanf:"adaptive-compression" reload:"hot"`
 	// Espresso specific flags
 	LightClientAddress           string        `koanf:"light-client-address"`
-	HotShotUrl                   string        `koanf:"hotshot-url"`
+	EspressoQueryUrl             string        `koanf:"espresso-query-url" reload:"hot"`
+	UseHotShotStream             bool          `koanf:"use-hotshot-stream"`
 	UseEscapeHatch               bool          `koanf:"use-escape-hatch"`
 	EspressoTxnsPollingInterval  time.Duration `koanf:"espresso-txns-polling-interval"`
 	EspressoSwitchDelayThreshold uint64        `koanf:"espresso-switch-delay-threshold"`
 	EspressoMaxTransactionSize   uint64        `koanf:"espresso-max-transaction-size"`
-	EspressoTEEVerifierAddress   string        `koanf:"espresso-tee-verifier-address"`
+	EspressoTEEVerifierAddress   string        `koanf:"espresso-tee-verifier-address" reload:"hot"`
+	EspressoUnjustifiedBehavior  string        `koanf:"espresso-unjustified-behavior" reload:"hot"`
+	espressoUnjustifiedBehavior  espressoUnjustifiedBehavior
+	// Only posts the messages whose espresso justification is stored, see TransactionStreamer.EspressoJustifiedWatermark
+	EspressoRequireJustification bool `koanf:"espresso-require-justification" reload:"hot"`
 }
 
 func (c *BatchPosterConfig) Validate() error {
-	if (c.LightClientAddress == "") != (c.HotShotUrl == "") {
-		return errors.New("light client address and hotshot URL must both be set together, or both left unset")
+	if (c.LightClientAddress == "") != (c.EspressoQueryUrl == "") {
+		return errors.New("light-client-address and espresso-query-url must both be set together, or both left unset")
 
 	}
+	if c.EspressoTEEVerifierAddress != "" {
+		if !common.IsHexAddress(c.EspressoTEEVerifierAddress) {
+			return fmt.Errorf("invalid espresso TEE verifier address \"%v\"", c.EspressoTEEVerifierAddress)
+		}
+		if c.EspressoQueryUrl == "" {
+			return errors.New("espresso-tee-verifier-address enables sequencing through espresso, which also requires espresso-query-url and light-client-address to be set")
+		}
+	}
+	if c.EspressoQueryUrl != "" && c.EspressoTxnsPollingInterval <= 0 {
+		return errors.New("espresso-txns-polling-interval must be positive when espresso-query-url is set")
+	}
 	if len(c.GasRefunderAddress) > 0 && !common.IsHexAddress(c.GasRefunderAddress) {
 		return fmt.Errorf("invalid gas refunder address \"%v\"", c.GasRefunderAddress)
 	}
@@ -213,6 +244,19 @@ func (c *BatchPosterConfig) Validate() error {
 	} else {
 		return fmt.Errorf("invalid L1 block bound tag \"%v\" (see --help for options)", c.L1BlockBound)
 	}
+	switch c.EspressoUnjustifiedBehavior {
+	case "", "wait":
+		c.espressoUnjustifiedBehavior = espressoUnjustifiedWait
+	case "post":
+		c.espressoUnjustifiedBehavior = espressoUnjustifiedPost
+	case "split":
+		c.espressoUnjustifiedBehavior = espressoUnjustifiedSplit
+	default:
+		return fmt.Errorf("invalid espresso unjustified behavior \"%v\" (see --help for options)", c.EspressoUnjustifiedBehavior)
+	}
+	if err := c.AdaptiveCompression.Validate(); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -237,7 +281,8 @@ func BatchPosterConfigAddOptions(prefix string, f *pflag.FlagSet) {
 	f.String(prefix+".l1-block-bound", DefaultBatchPosterConfig.L1BlockBound, "only post messages to batches when they're within the max future block/timestamp as of this L1 block tag (\"safe\", \"finalized\", \"latest\", or \"ignore\" to ignore this check)")
 	f.Duration(prefix+".l1-block-bound-bypass", DefaultBatchPosterConfig.L1BlockBoundBypass, "post batches even if not within the layer 1 future bounds if we're within this margin of the max delay")
 	f.Bool(prefix+".use-access-lists", DefaultBatchPosterConfig.UseAccessLists, "post batches with access lists to reduce gas usage (disabled for L3s)")
-	f.String(prefix+".hotshot-url", DefaultBatchPosterConfig.HotShotUrl, "specifies the hotshot url if we are batching in espresso mode")
+	f.String(prefix+".espresso-query-url", DefaultBatchPosterConfig.EspressoQueryUrl, "specifies the hotshot query service url if we are batching in espresso mode (a comma separated list of query node urls enables failover and load balancing)")
+	f.Bool(prefix+".use-hotshot-stream", DefaultBatchPosterConfig.UseHotShotStream, "if true, follow new hotshot blocks through the availability stream API over a websocket instead of only polling for transaction inclusion")
 	f.String(prefix+".light-client-address", DefaultBatchPosterConfig.LightClientAddress, "specifies the hotshot light client address if we are batching in espresso mode")
 	f.Uint64(prefix+".gas-estimate-base-fee-multiple-bips", uint64(DefaultBatchPosterConfig.GasEstimateBaseFeeMultipleBips), "for gas estimation, use this multiple of the basefee (measured in basis points) as the max fee per gas")
 	f.Duration(prefix+".reorg-resistance-margin", DefaultBatchPosterConfig.ReorgResistanceMargin, "do not post batch if its within this duration from layer 1 minimum bounds. Requires l1-block-bound option not be set to \"ignore\"")
@@ -246,10 +291,17 @@ func BatchPosterConfigAddOptions(prefix string, f *pflag.FlagSet) {
 	f.Duration(prefix+".espresso-txns-polling-interval", DefaultBatchPosterConfig.EspressoTxnsPollingInterval, "interval between polling for transactions to be included in the block")
 	f.Uint64(prefix+".espresso-switch-delay-threshold", DefaultBatchPosterConfig.EspressoSwitchDelayThreshold, "specifies the switch delay threshold used to determine hotshot liveness")
 	f.String(prefix+".espresso-tee-verifier-address", DefaultBatchPosterConfig.EspressoTEEVerifierAddress, "")
+	f.String(prefix+".espresso-unjustified-behavior", DefaultBatchPosterConfig.EspressoUnjustifiedBehavior, "what to do when the batch reaches a message that hasn't passed espresso verification (\"wait\" for the verification, \"post\" the message without it, or \"split\" the batch before the message)")
+	f.Bool(prefix+".espresso-require-justification", DefaultBatchPosterConfig.EspressoRequireJustification, "only post messages whose espresso justification, the block merkle proof of their hotshot block, is stored, so that no batch contains a message hotshot hasn't notarized; messages sequenced through the escape hatch are still posted")
+	AdaptiveCompressionConfigAddOptions(prefix+".adaptive-compression", f)
 	redislock.AddConfigOptions(prefix+".redis-lock", f)
 	dataposter.DataPosterConfigAddOptions(prefix+".data-poster", f, dataposter.DefaultDataPosterConfig)
 	genericconf.WalletConfigAddOptions(prefix+".parent-chain-wallet", f, DefaultBatchPosterConfig.ParentChainWallet.Pathname)
 	f.Uint64(prefix+".espresso-max-transaction-size", DefaultBatchPosterConfig.EspressoMaxTransactionSize, "specifies the max size of a espresso transasction")
+
+	// Flags renamed when the espresso flags were regrouped, kept working for existing deployments
+	f.String(prefix+".hotshot-url", DefaultBatchPosterConfig.EspressoQueryUrl, "")
+	genericconf.AddDeprecatedFlagAlias(f, prefix+".hotshot-url", prefix+".espresso-query-url")
 }
 
 var DefaultBatchPosterConfig = BatchPosterConfig{
@@ -264,6 +316,7 @@ var DefaultBatchPosterConfig = BatchPosterConfig{
 	MaxDelay:                       time.Hour,
 	WaitForMaxDelay:                false,
 	CompressionLevel:               brotli.BestCompression,
+	AdaptiveCompression:            DefaultAdaptiveCompressionConfig,
 	DASRetentionPeriod:             daprovider.DefaultDASRetentionPeriod,
 	GasRefunderAddress:             "",
 	ExtraBatchGas:                  50_000,
@@ -282,9 +335,10 @@ var DefaultBatchPosterConfig = BatchPosterConfig{
 	EspressoTxnsPollingInterval:    time.Millisecond * 500,
 	EspressoSwitchDelayThreshold:   350,
 	LightClientAddress:             "",
-	HotShotUrl:                     "",
+	EspressoQueryUrl:               "",
 	EspressoMaxTransactionSize:     900 * 1024,
 	EspressoTEEVerifierAddress:     "",
+	EspressoUnjustifiedBehavior:    "wait",
 }
 
 var DefaultBatchPosterL1WalletConfig = genericconf.WalletConfig{
@@ -304,6 +358,7 @@ var TestBatchPosterConfig = BatchPosterConfig{
 	MaxDelay:                       0,
 	WaitForMaxDelay:                false,
 	CompressionLevel:               2,
+	AdaptiveCompression:            DefaultAdaptiveCompressionConfig,
 	DASRetentionPeriod:             daprovider.DefaultDASRetentionPeriod,
 	GasRefunderAddress:             "",
 	ExtraBatchGas:                  10_000,
@@ -320,8 +375,9 @@ var TestBatchPosterConfig = BatchPosterConfig{
 	EspressoTxnsPollingInterval:    time.Millisecond * 500,
 	EspressoSwitchDelayThreshold:   10,
 	LightClientAddress:             "",
-	HotShotUrl:                     "",
+	EspressoQueryUrl:               "",
 	EspressoMaxTransactionSize:     900 * 1024,
+	EspressoUnjustifiedBehavior:    "wait",
 }
 
 type BatchPosterOpts struct {
@@ -369,12 +425,25 @@ func NewBatchPoster(ctx context.Context, opts *BatchPosterOpts) (*BatchPoster, e
 		return nil, err
 	}
 
-	hotShotUrl := opts.Config().HotShotUrl
+	hotShotUrl := opts.Config().EspressoQueryUrl
 	lightClientAddr := opts.Config().LightClientAddress
 
 	if hotShotUrl != "" {
-		hotShotClient := hotshotClient.NewClient(hotShotUrl)
-		opts.Streamer.espressoClient = hotShotClient
+		hotShotUrls := parseHotShotUrls(hotShotUrl)
+		hotShotClient, err := newEspressoMultiClient(hotShotUrls)
+		if err != nil {
+			return nil, err
+		}
+		opts.Streamer.setEspressoClient(hotShotClient)
+		opts.Streamer.espressoHotShotUrl = hotShotUrl
+		opts.Streamer.batchPosterConfig = opts.Config
+		if opts.Config().UseHotShotStream {
+			headerStream, err := newEspressoHeaderStream(hotShotUrls, hotShotClient.FetchLatestBlockHeight, opts.Streamer.notifyNewEspressoBlock)
+			if err != nil {
+				return nil, err
+			}
+			opts.Streamer.espressoHeaderStream = headerStream
+		}
 	}
 
 	if lightClientAddr != "" {
@@ -407,6 +476,11 @@ func NewBatchPoster(ctx context.Context, opts *BatchPosterOpts) (*BatchPoster, e
 		redisLock:          redisLock,
 		dapReaders:         opts.DAPReaders,
 	}
+	sequencingLatency := func() time.Duration { return 0 }
+	if opts.Streamer != nil {
+		sequencingLatency = opts.Streamer.SequencingLatency
+	}
+	b.compression = newCompressionLevelController(opts.Config, sequencingLatency)
 	b.messagesPerBatch, err = arbmath.NewMovingAverage[uint64](20)
 	if err != nil {
 		return nil, err
@@ -569,6 +643,16 @@ func (b *BatchPoster) checkEspressoValidation() error {
 		return nil
 	}
 
+	if b.streamer.espressoShadowMode() {
+		// The messages are submitted to espresso, but posted without waiting for their finality
+		return nil
+	}
+
+	if !b.streamer.isEspressoActiveAt(b.building.msgCount) {
+		// This message was sequenced before the migration to espresso
+		return nil
+	}
+
 	lastConfirmed, err := b.streamer.getLastConfirmedPos()
 	if err != nil {
 		log.Error("failed call to get last confirmed pos", "err", err)
@@ -580,6 +664,15 @@ func (b *BatchPoster) checkEspressoValidation() error {
 		return nil
 	}
 
+	// This message missed its espresso submission deadline, and falls back to the escape hatch
+	escaped, err := b.streamer.IsEscapeHatchMessage(b.building.msgCount)
+	if err != nil {
+		return err
+	}
+	if escaped {
+		return nil
+	}
+
 	if b.streamer.UseEscapeHatch {
 		skip, err := b.streamer.getSkipVerificationPos()
 		if err != nil {
@@ -591,14 +684,14 @@ func (b *BatchPoster) checkEspressoValidation() error {
 		if skip != nil {
 			if b.building.msgCount <= *skip {
 				log.Warn("skipped espresso verification due to hotshot failure", "pos", b.building.msgCount)
-				return nil
+				return b.streamer.recordEscapeHatchMessage(b.building.msgCount)
 			}
 		}
 	}
 
-	if b.streamer.HotshotDown && b.streamer.UseEscapeHatch {
+	if b.streamer.IsHotShotDown() && b.streamer.UseEscapeHatch {
 		log.Warn("skipped espresso verification due to hotshot failure", "pos", b.building.msgCount)
-		return nil
+		return b.streamer.recordEscapeHatchMessage(b.building.msgCount)
 	}
 
 	return fmt.Errorf("%w (height: %d)", EspressoFetchMerkleRootErr, b.building.msgCount)
@@ -847,7 +940,7 @@ type buildingBatch struct {
 	muxBackend        *simulatedMuxBackend
 }
 
-func newBatchSegments(firstDelayed uint64, config *BatchPosterConfig, backlog uint64, use4844 bool) *batchSegments {
+func newBatchSegments(firstDelayed uint64, config *BatchPosterConfig, compressionLevel int, backlog uint64, use4844 bool) *batchSegments {
 	maxSize := config.MaxSize
 	if use4844 {
 		maxSize = config.Max4844BatchSize
@@ -858,8 +951,7 @@ func newBatchSegments(firstDelayed uint64, config *BatchPosterConfig, backlog ui
 		maxSize -= 40
 	}
 	compressedBuffer := bytes.NewBuffer(make([]byte, 0, maxSize*2))
-	compressionLevel := config.CompressionLevel
-	recompressionLevel := config.CompressionLevel
+	recompressionLevel := compressionLevel
 	if backlog > 20 {
 		compressionLevel = arbmath.MinInt(compressionLevel, brotli.DefaultCompression)
 	}
@@ -1315,7 +1407,7 @@ func (b *BatchPoster) maybePostSequencerBatch(ctx context.Context) (bool, error)
 		}
 
 		b.building = &buildingBatch{
-			segments:      newBatchSegments(batchPosition.DelayedMessageCount, b.config(), b.GetBacklogEstimate(), use4844),
+			segments:      newBatchSegments(batchPosition.DelayedMessageCount, b.config(), b.compression.compressionLevel(), b.GetBacklogEstimate(), use4844),
 			msgCount:      batchPosition.MessageCount,
 			startMsgCount: batchPosition.MessageCount,
 			use4844:       use4844,
@@ -1430,6 +1522,21 @@ func (b *BatchPoster) maybePostSequencerBatch(ctx context.Context) (bool, error)
 		}
 	}
 
+	unjustified, err := b.streamer.CountUnjustifiedMessages(b.building.msgCount, msgCount)
+	if err != nil {
+		return false, err
+	}
+	justifiedWatermark := arbutil.MessageIndex(math.MaxUint64)
+	espressoEnabled := b.streamer.espressoClient != nil || b.streamer.lightClientReader != nil
+	if config.EspressoRequireJustification && espressoEnabled && !b.streamer.espressoShadowMode() {
+		justifiedWatermark, err = b.streamer.EspressoJustifiedWatermark()
+		if err != nil {
+			return false, err
+		}
+	}
+	// #nosec G115
+	batchPosterEspressoUnjustifiedGauge.Update(int64(unjustified))
+
 	for b.building.msgCount < msgCount {
 		msg, err := b.streamer.GetMessage(b.building.msgCount)
 		if err != nil {
@@ -1460,9 +1567,26 @@ func (b *BatchPoster) maybePostSequencerBatch(ctx context.Context) (bool, error)
 			break
 		}
 
+		if b.building.msgCount >= justifiedWatermark {
+			log.Debug("not posting more messages until their espresso justification is stored", "pos", b.building.msgCount)
+			break
+		}
 		err = b.checkEspressoValidation()
 		if err != nil {
-			return false, fmt.Errorf("error checking espresso valdiation: %w", err)
+			if !errors.Is(err, EspressoFetchMerkleRootErr) {
+				return false, fmt.Errorf("error checking espresso valdiation: %w", err)
+			}
+			behavior := config.espressoUnjustifiedBehavior
+			if behavior == espressoUnjustifiedSplit && b.building.haveUsefulMessage {
+				log.Info("splitting the batch at the first message without espresso verification", "pos", b.building.msgCount, "unjustified", unjustified)
+				forcePostBatch = true
+				break
+			}
+			if behavior != espressoUnjustifiedPost {
+				return false, fmt.Errorf("error checking espresso valdiation: %w", err)
+			}
+			log.Warn("posting message without espresso verification", "pos", b.building.msgCount)
+			batchPosterEspressoPostedUnjustifiedCounter.Inc(1)
 		}
 		isDelayed := msg.DelayedMessagesRead > b.building.segments.delayedMsg
 		success, err := b.building.segments.AddMessage(msg)
@@ -1670,6 +1794,10 @@ func (b *BatchPoster) maybePostSequencerBatch(ctx context.Context) (bool, error)
 		"numBlobs", len(kzgBlobs),
 	)
 
+	if err := b.streamer.recordPostedMessagesTelemetry(batchPosition.MessageCount, b.building.msgCount, time.Now()); err != nil {
+		log.Warn("failed to record the telemetry of posted messages", "from", batchPosition.MessageCount, "to", b.building.msgCount, "err", err)
+	}
+
 	recentlyHitL1Bounds := time.Since(b.lastHitL1Bounds) < config.PollInterval*3
 	postedMessages := b.building.msgCount - batchPosition.MessageCount
 	b.messagesPerBatch.Update(uint64(postedMessages))
@@ -1756,6 +1884,7 @@ func (b *BatchPoster) Start(ctxIn context.Context) {
 		accumulatorNotFoundEphemeralErrorHandler.Reset()
 		espressoEphemeralErrorHandler.Reset()
 	}
+	b.CallIteratively(b.compression.adjustLoop)
 	b.CallIteratively(func(ctx context.Context) time.Duration {
 		var err error
 		if common.HexToAddress(b.config().GasRefunderAddress) != (common.Address{}) {
@@ -0,0 +1,53 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package arbnode
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/rlp"
+
+	"github.com/offchainlabs/nitro/arbutil"
+	"github.com/offchainlabs/nitro/util/dbutil"
+)
+
+var blockHashRepairedCounter = metrics.NewRegisteredCounter("arb/streamer/blockhash/repaired", nil)
+
+// getStoredBlockHash returns the block hash stored along with the message at pos, or nil if there's none.
+// To keep it backwards compatible, since it is possible that a message related
+// to a sequence number exists in the database, but the block hash doesn't.
+func (s *TransactionStreamer) getStoredBlockHash(pos arbutil.MessageIndex) (*common.Hash, error) {
+	data, err := s.db.Get(dbKey(blockHashInputFeedPrefix, uint64(pos)))
+	if err != nil {
+		if dbutil.IsErrNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var blockHashDBVal blockHashDBValue
+	if err := rlp.DecodeBytes(data, &blockHashDBVal); err != nil {
+		return nil, err
+	}
+	return blockHashDBVal.BlockHash, nil
+}
+
+// repairBlockHash adds the block hash computed by execution for the message at pos to the batch if none is stored,
+// so that later feed broadcasts and queries of the message have it without a separate backfill. A stored block hash
+// that's only being ignored, e.g. an unjustified feed block hash of a trustless replica, is left to checkResult.
+// Returns whether the block hash was added.
+func (s *TransactionStreamer) repairBlockHash(pos arbutil.MessageIndex, blockHash common.Hash, batch ethdb.KeyValueWriter) (bool, error) {
+	stored, err := s.getStoredBlockHash(pos)
+	if err != nil || stored != nil {
+		return false, err
+	}
+	data, err := rlp.EncodeToBytes(blockHashDBValue{BlockHash: &blockHash})
+	if err != nil {
+		return false, err
+	}
+	if err := batch.Put(dbKey(blockHashInputFeedPrefix, uint64(pos)), data); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,47 @@
+package arbnode
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"github.com/offchainlabs/nitro/arbos/arbostypes"
+)
+
+func TestRepairBlockHash(t *testing.T) {
+	streamer := newTestImportStreamer(t)
+	msg := arbostypes.MessageWithMetadata{
+		Message:             &arbostypes.L1IncomingMessage{Header: &arbostypes.L1IncomingMessageHeader{}},
+		DelayedMessagesRead: 1,
+	}
+	Require(t, streamer.AddMessages(0, true, []arbostypes.MessageWithMetadata{msg, msg}))
+	feedHash := common.HexToHash("0x01")
+	batch := streamer.db.NewBatch()
+	Require(t, streamer.writeMessage(1, arbostypes.MessageWithMetadataAndBlockHash{MessageWithMeta: msg, BlockHash: &feedHash}, batch))
+	Require(t, batch.Write())
+
+	computed := common.HexToHash("0x02")
+	batch = streamer.db.NewBatch()
+	repaired, err := streamer.repairBlockHash(0, computed, batch)
+	Require(t, err)
+	if !repaired {
+		Fail(t, "missing block hash not repaired")
+	}
+	repaired, err = streamer.repairBlockHash(1, computed, batch)
+	Require(t, err)
+	if repaired {
+		Fail(t, "stored block hash overwritten")
+	}
+	Require(t, batch.Write())
+
+	stored, err := streamer.getStoredBlockHash(0)
+	Require(t, err)
+	if stored == nil || *stored != computed {
+		Fail(t, "unexpected repaired block hash", stored)
+	}
+	stored, err = streamer.getStoredBlockHash(1)
+	Require(t, err)
+	if stored == nil || *stored != feedHash {
+		Fail(t, "unexpected stored block hash", stored)
+	}
+}
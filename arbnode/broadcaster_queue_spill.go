@@ -0,0 +1,164 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package arbnode
+
+import (
+	"math"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/rlp"
+
+	"github.com/offchainlabs/nitro/arbos/arbostypes"
+	"github.com/offchainlabs/nitro/arbutil"
+)
+
+var (
+	broadcasterQueueSpilledGauge  = metrics.NewRegisteredGauge("arb/streamer/broadcaster_queue/spilled", nil)
+	broadcasterQueueSpillCounter  = metrics.NewRegisteredCounter("arb/streamer/broadcaster_queue/spill", nil)
+	broadcasterQueueRefillCounter = metrics.NewRegisteredCounter("arb/streamer/broadcaster_queue/refill", nil)
+)
+
+// The broadcaster queue keeps at most MaxBroadcasterQueueSize feed messages in memory. Messages arriving while
+// it's full are spilled to the database, keyed by their sequence number, and loaded back once the messages in
+// memory were added. The spilled messages always directly follow the messages in memory, so there are messages
+// in memory whenever some are spilled. The spill is only kept for the lifetime of the process, as the messages
+// in memory before it are lost on a restart.
+
+type spilledFeedMessage struct {
+	Message   arbostypes.MessageWithMetadata
+	BlockHash *common.Hash `rlp:"nil"`
+}
+
+func (s *TransactionStreamer) broadcasterQueueCapacity() int {
+	maxQueueSize := s.config().MaxBroadcasterQueueSize
+	if maxQueueSize <= 0 {
+		return math.MaxInt
+	}
+	return maxQueueSize
+}
+
+// broadcasterQueueLen returns the number of queued feed messages, including the spilled ones.
+// The caller must hold the insertionMutex.
+func (s *TransactionStreamer) broadcasterQueueLen() int {
+	// #nosec G115
+	return len(s.broadcasterQueuedMessages) + int(s.broadcasterQueueSpilled)
+}
+
+// resetBroadcasterQueue replaces the queued feed messages with messages starting at pos.
+// The caller must hold the insertionMutex.
+func (s *TransactionStreamer) resetBroadcasterQueue(pos arbutil.MessageIndex, messages []arbostypes.MessageWithMetadataAndBlockHash, activeReorg bool) error {
+	if err := s.clearBroadcasterQueueSpill(); err != nil {
+		return err
+	}
+	s.broadcasterQueuedMessages = nil
+	s.broadcasterQueuedMessagesPos.Store(uint64(pos))
+	s.broadcasterQueuedMessagesActiveReorg = activeReorg
+	return s.appendBroadcasterQueue(messages)
+}
+
+// appendBroadcasterQueue appends messages directly following the queued feed messages, spilling those that
+// don't fit in memory. The caller must hold the insertionMutex.
+func (s *TransactionStreamer) appendBroadcasterQueue(messages []arbostypes.MessageWithMetadataAndBlockHash) error {
+	if room := s.broadcasterQueueCapacity() - len(s.broadcasterQueuedMessages); s.broadcasterQueueSpilled == 0 && room > 0 {
+		kept := min(room, len(messages))
+		s.broadcasterQueuedMessages = append(s.broadcasterQueuedMessages, messages[:kept]...)
+		messages = messages[kept:]
+	}
+	if len(messages) == 0 {
+		return nil
+	}
+	// #nosec G115
+	spillPos := s.broadcasterQueuedMessagesPos.Load() + uint64(s.broadcasterQueueLen())
+	batch := s.db.NewBatch()
+	for i, message := range messages {
+		data, err := rlp.EncodeToBytes(spilledFeedMessage{Message: message.MessageWithMeta, BlockHash: message.BlockHash})
+		if err != nil {
+			return err
+		}
+		// #nosec G115
+		if err := batch.Put(dbKey(broadcasterSpillPrefix, spillPos+uint64(i)), data); err != nil {
+			return err
+		}
+	}
+	if err := batch.Write(); err != nil {
+		return err
+	}
+	if s.broadcasterQueueSpilled == 0 {
+		log.Warn("broadcaster queue is full, spilling feed messages to the database", "queuedMessages", len(s.broadcasterQueuedMessages), "pos", spillPos)
+	}
+	s.broadcasterQueueSpilled += uint64(len(messages))
+	broadcasterQueueSpillCounter.Inc(int64(len(messages)))
+	// #nosec G115
+	broadcasterQueueSpilledGauge.Update(int64(s.broadcasterQueueSpilled))
+	return nil
+}
+
+// refillBroadcasterQueue loads the spilled feed messages from pos back into memory, once the messages in memory
+// were added. spillPos is the position of the first spilled message, the spilled messages before pos were
+// superseded by the messages added and are dropped. The caller must hold the insertionMutex.
+func (s *TransactionStreamer) refillBroadcasterQueue(spillPos arbutil.MessageIndex, pos arbutil.MessageIndex) error {
+	// #nosec G115
+	spillEnd := spillPos + arbutil.MessageIndex(s.broadcasterQueueSpilled)
+	capacity := s.broadcasterQueueCapacity()
+	batch := s.db.NewBatch()
+	var loaded []arbostypes.MessageWithMetadataAndBlockHash
+	next := spillPos
+	for ; next < spillEnd && len(loaded) < capacity; next++ {
+		key := dbKey(broadcasterSpillPrefix, uint64(next))
+		if next >= pos {
+			data, err := s.db.Get(key)
+			if err != nil {
+				return err
+			}
+			var message spilledFeedMessage
+			if err := rlp.DecodeBytes(data, &message); err != nil {
+				return err
+			}
+			loaded = append(loaded, arbostypes.MessageWithMetadataAndBlockHash{
+				MessageWithMeta: message.Message,
+				BlockHash:       message.BlockHash,
+			})
+		}
+		if err := batch.Delete(key); err != nil {
+			return err
+		}
+	}
+	if err := batch.Write(); err != nil {
+		return err
+	}
+	s.broadcasterQueuedMessages = loaded
+	if len(loaded) > 0 {
+		s.broadcasterQueuedMessagesPos.Store(uint64(max(spillPos, pos)))
+	} else {
+		s.broadcasterQueuedMessagesPos.Store(0)
+	}
+	s.broadcasterQueueSpilled = uint64(spillEnd - next)
+	broadcasterQueueRefillCounter.Inc(int64(len(loaded)))
+	// #nosec G115
+	broadcasterQueueSpilledGauge.Update(int64(s.broadcasterQueueSpilled))
+	return nil
+}
+
+// clearBroadcasterQueueSpill drops the spilled feed messages. The caller must hold the insertionMutex.
+func (s *TransactionStreamer) clearBroadcasterQueueSpill() error {
+	if s.broadcasterQueueSpilled == 0 {
+		return nil
+	}
+	return s.deleteBroadcasterQueueSpill()
+}
+
+// deleteBroadcasterQueueSpill deletes every spilled feed message from the database, including those left over
+// by a previous run
+func (s *TransactionStreamer) deleteBroadcasterQueueSpill() error {
+	// Messages left behind if the deletion fails are overwritten when messages are spilled again
+	s.broadcasterQueueSpilled = 0
+	broadcasterQueueSpilledGauge.Update(0)
+	batch := s.db.NewBatch()
+	if err := deleteStartingAt(s.db, batch, broadcasterSpillPrefix, nil); err != nil {
+		return err
+	}
+	return batch.Write()
+}
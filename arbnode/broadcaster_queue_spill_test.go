@@ -0,0 +1,62 @@
+package arbnode
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/offchainlabs/nitro/arbos/arbostypes"
+	"github.com/offchainlabs/nitro/arbutil"
+	m "github.com/offchainlabs/nitro/broadcaster/message"
+)
+
+func TestBroadcasterQueueSpill(t *testing.T) {
+	streamer := newTestStreamer(t, nil, func(c *TransactionStreamerConfig) { c.MaxBroadcasterQueueSize = 2 })
+	message := func(pos arbutil.MessageIndex) arbostypes.MessageWithMetadata {
+		return arbostypes.MessageWithMetadata{
+			Message: &arbostypes.L1IncomingMessage{
+				Header: &arbostypes.L1IncomingMessageHeader{},
+				L2msg:  []byte(fmt.Sprintf("message %d", pos)),
+			},
+			DelayedMessagesRead: 1,
+		}
+	}
+	Require(t, streamer.AddMessages(0, true, []arbostypes.MessageWithMetadata{message(0)}))
+
+	// The feed is ahead of the confirmed messages, so its messages are queued
+	var feedMessages []*m.BroadcastFeedMessage
+	for pos := arbutil.MessageIndex(5); pos < 10; pos++ {
+		feedMessages = append(feedMessages, &m.BroadcastFeedMessage{SequenceNumber: pos, Message: message(pos)})
+	}
+	Require(t, streamer.AddBroadcastMessages(feedMessages))
+	if len(streamer.broadcasterQueuedMessages) != 2 || streamer.broadcasterQueueSpilled != 3 {
+		Fail(t, "unexpected broadcaster queue", len(streamer.broadcasterQueuedMessages), streamer.broadcasterQueueSpilled)
+	}
+	if pending := streamer.FeedPendingMessageCount(); pending != 10 {
+		Fail(t, "spilled messages not counted as pending", pending)
+	}
+
+	// Once the confirmed messages catch up, the queued and the spilled feed messages are all added
+	var confirmed []arbostypes.MessageWithMetadata
+	for pos := arbutil.MessageIndex(1); pos < 5; pos++ {
+		confirmed = append(confirmed, message(pos))
+	}
+	Require(t, streamer.AddMessages(1, true, confirmed))
+	count, err := streamer.GetMessageCount()
+	Require(t, err)
+	if count != 10 {
+		Fail(t, "spilled feed messages not added", count)
+	}
+	last, err := streamer.GetMessage(9)
+	Require(t, err)
+	if string(last.Message.L2msg) != "message 9" {
+		Fail(t, "unexpected message", string(last.Message.L2msg))
+	}
+	if streamer.broadcasterQueueSpilled != 0 || len(streamer.broadcasterQueuedMessages) != 0 {
+		Fail(t, "broadcaster queue not drained", len(streamer.broadcasterQueuedMessages), streamer.broadcasterQueueSpilled)
+	}
+	iter := streamer.db.NewIterator(broadcasterSpillPrefix, nil)
+	defer iter.Release()
+	if iter.Next() {
+		Fail(t, "spilled feed message left in the database", iter.Key())
+	}
+}
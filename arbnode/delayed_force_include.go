@@ -0,0 +1,154 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package arbnode
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+
+	"github.com/offchainlabs/nitro/arbutil"
+)
+
+var delayedForceIncludedCounter = metrics.NewRegisteredCounter("arb/streamer/delayed/force_included", nil)
+
+// How many messages are searched back from the message count for the one a delayed message was sequenced in
+const forceIncludeSearchLimit = 1024
+
+// ForceIncludeResult describes the delayed messages sequenced by ForceIncludeDelayed
+type ForceIncludeResult struct {
+	// The number of delayed messages read by the execution engine afterwards
+	DelayedMessagesRead uint64 `json:"delayedMessagesRead"`
+	// Positions of the messages the delayed messages were sequenced in
+	Positions []arbutil.MessageIndex `json:"positions"`
+	// Whether the messages were queued for espresso submission
+	EspressoQueued bool `json:"espressoQueued"`
+}
+
+// ForceIncludeDelayed sequences the delayed messages read by the inbox tracker but not by the execution engine yet,
+// until count delayed messages are read, without waiting for their finality like the delayed sequencer does. With
+// espresso enabled, the resulting messages are queued for submission right away, so that they don't wait for the
+// batch poster either. It's meant as a remedy for stuck delayed messages, and must be called on the chosen sequencer.
+func (s *TransactionStreamer) ForceIncludeDelayed(ctx context.Context, count uint64) (*ForceIncludeResult, error) {
+	if s.readOnly {
+		return nil, ErrReadOnly
+	}
+	if s.exec == nil {
+		return nil, ErrNoExecution
+	}
+	if s.inboxReader == nil {
+		return nil, errors.New("no inbox reader to read the delayed messages from")
+	}
+	if err := s.ExpectChosenSequencer(); err != nil {
+		return nil, err
+	}
+	tracker := s.inboxReader.Tracker()
+	delayedCount, err := tracker.GetDelayedCount()
+	if err != nil {
+		return nil, err
+	}
+	if count > delayedCount {
+		return nil, fmt.Errorf("delayed count %d is beyond the %d delayed messages read by the inbox tracker", count, delayedCount)
+	}
+	next, err := s.exec.NextDelayedMessageNumber()
+	if err != nil {
+		return nil, err
+	}
+	res := &ForceIncludeResult{DelayedMessagesRead: next}
+	if count <= next {
+		return res, nil
+	}
+	var lastAcc common.Hash
+	if next > 0 {
+		lastAcc, err = tracker.GetDelayedAcc(next - 1)
+		if err != nil {
+			return nil, err
+		}
+	}
+	queueForEspresso := s.espressoSubmissionEnabled()
+	for delayedSeqNum := next; delayedSeqNum < count; delayedSeqNum++ {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		msg, acc, parentChainBlockNumber, err := tracker.GetDelayedMessageAccumulatorAndParentChainBlockNumber(ctx, delayedSeqNum)
+		if err != nil {
+			return nil, err
+		}
+		// Ensure that the inbox tracker wasn't reorged meanwhile and this message follows the last
+		fullMsg := DelayedInboxMessage{
+			BeforeInboxAcc:         lastAcc,
+			Message:                msg,
+			ParentChainBlockNumber: parentChainBlockNumber,
+		}
+		if fullMsg.AfterInboxAcc() != acc {
+			return nil, fmt.Errorf("delayed message %d accumulator mismatch while force including", delayedSeqNum)
+		}
+		lastAcc = acc
+		if err := s.exec.SequenceDelayedMessage(msg, delayedSeqNum); err != nil {
+			if len(res.Positions) > 0 {
+				log.Warn("force included some of the delayed messages", "delayedMessagesRead", delayedSeqNum, "positions", res.Positions)
+			}
+			return nil, fmt.Errorf("failed to sequence delayed message %d: %w", delayedSeqNum, err)
+		}
+		delayedForceIncludedCounter.Inc(1)
+		// The sequenced message may be staged for a group commit, it's searched for in the database
+		head, err := s.exec.HeadMessageNumber()
+		if err != nil {
+			return nil, err
+		}
+		if err := s.commitStagedSequencerMessages(head); err != nil {
+			return nil, err
+		}
+		pos, err := s.findDelayedMessagePos(delayedSeqNum)
+		if err != nil {
+			return nil, err
+		}
+		res.Positions = append(res.Positions, pos)
+		res.DelayedMessagesRead = delayedSeqNum + 1
+		if !queueForEspresso {
+			continue
+		}
+		notSubmitted, err := s.HasNotSubmitted(pos)
+		if err != nil {
+			return nil, err
+		}
+		if notSubmitted {
+			if err := s.SubmitEspressoTransactionPos(pos, s.db.NewBatch()); err != nil {
+				return nil, fmt.Errorf("failed to queue the force included message %d for espresso submission: %w", pos, err)
+			}
+			res.EspressoQueued = true
+		}
+	}
+	log.Info("force included delayed messages", "delayedMessagesRead", res.DelayedMessagesRead, "positions", res.Positions, "espressoQueued", res.EspressoQueued)
+	return res, nil
+}
+
+// findDelayedMessagePos returns the position of the message the delayed message delayedSeqNum was sequenced in,
+// the first one having read it
+func (s *TransactionStreamer) findDelayedMessagePos(delayedSeqNum uint64) (arbutil.MessageIndex, error) {
+	count, err := s.GetMessageCount()
+	if err != nil {
+		return 0, err
+	}
+	for pos := count; pos > 0 && count-pos < forceIncludeSearchLimit; pos-- {
+		msg, err := s.GetMessage(pos - 1)
+		if err != nil {
+			return 0, err
+		}
+		if msg.DelayedMessagesRead <= delayedSeqNum {
+			if pos == count {
+				break
+			}
+			return pos, nil
+		}
+		if pos == 1 {
+			return 0, nil
+		}
+	}
+	return 0, fmt.Errorf("message reading delayed message %d not found in the last %d messages", delayedSeqNum, forceIncludeSearchLimit)
+}
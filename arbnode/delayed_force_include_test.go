@@ -0,0 +1,109 @@
+package arbnode
+
+import (
+	"context"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"github.com/offchainlabs/nitro/arbos/arbostypes"
+	"github.com/offchainlabs/nitro/arbutil"
+	"github.com/offchainlabs/nitro/execution"
+)
+
+// delayedExecution sequences delayed messages by writing them to the streamer right away
+type delayedExecution struct {
+	headOnlyExecution
+	streamer    *TransactionStreamer
+	nextDelayed uint64
+}
+
+func (e *delayedExecution) NextDelayedMessageNumber() (uint64, error) {
+	return e.nextDelayed, nil
+}
+
+func (e *delayedExecution) SequenceDelayedMessage(message *arbostypes.L1IncomingMessage, delayedSeqNum uint64) error {
+	pos := e.head + 1
+	msg := arbostypes.MessageWithMetadata{Message: message, DelayedMessagesRead: delayedSeqNum + 1}
+	if err := e.streamer.WriteMessageFromSequencer(pos, msg, execution.MessageResult{}); err != nil {
+		return err
+	}
+	e.nextDelayed = delayedSeqNum + 1
+	e.head = pos
+	return nil
+}
+
+func TestForceIncludeDelayed(t *testing.T) {
+	t.Run("direct", func(t *testing.T) { testForceIncludeDelayed(t, false) })
+	// The force included messages are staged by the sequencer, and must be committed to be found
+	t.Run("group commit", func(t *testing.T) { testForceIncludeDelayed(t, true) })
+}
+
+func testForceIncludeDelayed(t *testing.T, groupCommit bool) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	streamer := newTestImportStreamer(t)
+	if groupCommit {
+		streamer.config().SequencerGroupCommit = SequencerGroupCommitConfig{Enable: true, MaxDelay: time.Hour, MaxMessages: 64}
+	}
+	tracker, err := NewInboxTracker(streamer.db, streamer, nil, DefaultSnapSyncConfig)
+	Require(t, err)
+	Require(t, tracker.Initialize())
+	streamer.SetInboxReaders(&InboxReader{tracker: tracker}, nil)
+	exec := &delayedExecution{streamer: streamer, nextDelayed: 1}
+	streamer.exec = exec
+
+	var delayed []*DelayedInboxMessage
+	var acc common.Hash
+	for i := uint64(0); i < 3; i++ {
+		requestId := common.BigToHash(new(big.Int).SetUint64(i))
+		msg := &DelayedInboxMessage{
+			BeforeInboxAcc: acc,
+			Message: &arbostypes.L1IncomingMessage{
+				Header: &arbostypes.L1IncomingMessageHeader{
+					Kind:      arbostypes.L1MessageType_EndOfBlock,
+					Timestamp: i,
+					RequestId: &requestId,
+					L1BaseFee: common.Big0,
+				},
+			},
+		}
+		acc = msg.AfterInboxAcc()
+		delayed = append(delayed, msg)
+	}
+	Require(t, tracker.AddDelayedMessages(delayed, false))
+	// The first delayed message and a sequenced one were already read
+	Require(t, streamer.AddMessages(0, true, []arbostypes.MessageWithMetadata{
+		{Message: delayed[0].Message, DelayedMessagesRead: 1},
+		{Message: delayed[0].Message, DelayedMessagesRead: 1},
+	}))
+	exec.head = 1
+
+	if _, err := streamer.ForceIncludeDelayed(ctx, 4); err == nil {
+		Fail(t, "force included delayed messages the inbox tracker didn't read")
+	}
+	res, err := streamer.ForceIncludeDelayed(ctx, 3)
+	Require(t, err)
+	if res.DelayedMessagesRead != 3 || len(res.Positions) != 2 || res.Positions[0] != 2 || res.Positions[1] != 3 || res.EspressoQueued {
+		Fail(t, "unexpected force include result", res)
+	}
+	msg, err := streamer.GetMessage(3)
+	Require(t, err)
+	if msg.DelayedMessagesRead != 3 || msg.Message.Header.Timestamp != 2 {
+		Fail(t, "unexpected force included message", msg)
+	}
+
+	// Nothing is left to include
+	res, err = streamer.ForceIncludeDelayed(ctx, 3)
+	Require(t, err)
+	if len(res.Positions) != 0 {
+		Fail(t, "delayed messages included twice", res)
+	}
+	pos, err := streamer.findDelayedMessagePos(0)
+	Require(t, err)
+	if pos != arbutil.MessageIndex(0) {
+		Fail(t, "unexpected position of the first delayed message", pos)
+	}
+}
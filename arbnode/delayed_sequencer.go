@@ -13,6 +13,7 @@ import (
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
 	flag "github.com/spf13/pflag"
 
 	"github.com/offchainlabs/nitro/arbos/arbostypes"
@@ -21,6 +22,12 @@ import (
 	"github.com/offchainlabs/nitro/util/stopwaiter"
 )
 
+var (
+	delayedLatencyHistogram       = metrics.NewRegisteredHistogram("arb/sequencer/delayed/latency", nil, metrics.NewBoundedHistogramSample())
+	delayedOldestPendingAgeGauge  = metrics.NewRegisteredGauge("arb/sequencer/delayed/oldest_pending_age", nil)
+	delayedDeadlineSequencedCount = metrics.NewRegisteredCounter("arb/sequencer/delayed/deadline_sequenced", nil)
+)
+
 type DelayedSequencer struct {
 	stopwaiter.StopWaiter
 	l1Reader                 *headerreader.HeaderReader
@@ -30,8 +37,10 @@ type DelayedSequencer struct {
 	exec                     execution.ExecutionSequencer
 	coordinator              *SeqCoordinator
 	waitingForFinalizedBlock uint64
-	mutex                    sync.Mutex
-	config                   DelayedSequencerConfigFetcher
+	// Parent chain block of the oldest delayed message that isn't sequenced yet, 0 if there's none
+	oldestPendingBlock uint64
+	mutex              sync.Mutex
+	config             DelayedSequencerConfigFetcher
 }
 
 type DelayedSequencerConfig struct {
@@ -39,6 +48,10 @@ type DelayedSequencerConfig struct {
 	FinalizeDistance    int64 `koanf:"finalize-distance" reload:"hot"`
 	RequireFullFinality bool  `koanf:"require-full-finality" reload:"hot"`
 	UseMergeFinality    bool  `koanf:"use-merge-finality" reload:"hot"`
+	// Delayed messages must be sequenced before they can be force included, which matters most for a sovereign
+	// sequencer whose ordering comes from espresso instead of the sequencer inbox
+	InclusionDeadline       uint64 `koanf:"inclusion-deadline" reload:"hot"`
+	InclusionDeadlineMargin uint64 `koanf:"inclusion-deadline-margin" reload:"hot"`
 }
 
 type DelayedSequencerConfigFetcher func() *DelayedSequencerConfig
@@ -48,6 +61,28 @@ func DelayedSequencerConfigAddOptions(prefix string, f *flag.FlagSet) {
 	f.Int64(prefix+".finalize-distance", DefaultDelayedSequencerConfig.FinalizeDistance, "how many blocks in the past L1 block is considered final (ignored when using Merge finality)")
 	f.Bool(prefix+".require-full-finality", DefaultDelayedSequencerConfig.RequireFullFinality, "whether to wait for full finality before sequencing delayed messages")
 	f.Bool(prefix+".use-merge-finality", DefaultDelayedSequencerConfig.UseMergeFinality, "whether to use The Merge's notion of finality before sequencing delayed messages")
+	f.Uint64(prefix+".inclusion-deadline", DefaultDelayedSequencerConfig.InclusionDeadline, "number of parent chain blocks after which a delayed message can be force included, delayed messages are sequenced without waiting for finality when the deadline approaches (0 = disabled)")
+	f.Uint64(prefix+".inclusion-deadline-margin", DefaultDelayedSequencerConfig.InclusionDeadlineMargin, "number of parent chain blocks before the inclusion deadline at which delayed messages are sequenced regardless of finality")
+}
+
+func (c *DelayedSequencerConfig) Validate() error {
+	if c.InclusionDeadline != 0 && c.InclusionDeadlineMargin >= c.InclusionDeadline {
+		return fmt.Errorf("delayed sequencer inclusion-deadline-margin %d must be less than the inclusion-deadline %d", c.InclusionDeadlineMargin, c.InclusionDeadline)
+	}
+	return nil
+}
+
+// inclusionDueBlock returns the latest parent chain block whose delayed messages are close enough to their inclusion
+// deadline at currentBlock to be sequenced without waiting for finality
+func (c *DelayedSequencerConfig) inclusionDueBlock(currentBlock uint64) (uint64, bool) {
+	if c.InclusionDeadline == 0 || c.InclusionDeadlineMargin >= c.InclusionDeadline {
+		return 0, false
+	}
+	window := c.InclusionDeadline - c.InclusionDeadlineMargin
+	if currentBlock < window {
+		return 0, false
+	}
+	return currentBlock - window, true
 }
 
 var DefaultDelayedSequencerConfig = DelayedSequencerConfig{
@@ -55,6 +90,9 @@ var DefaultDelayedSequencerConfig = DelayedSequencerConfig{
 	FinalizeDistance:    20,
 	RequireFullFinality: false,
 	UseMergeFinality:    true,
+	InclusionDeadline:   0,
+	// Half a day of blocks before the inclusion deadline
+	InclusionDeadlineMargin: 3600,
 }
 
 var TestDelayedSequencerConfig = DelayedSequencerConfig{
@@ -125,6 +163,15 @@ func (d *DelayedSequencer) sequenceWithoutLockout(ctx context.Context, lastBlock
 		finalized = uint64(currentNum - config.FinalizeDistance)
 	}
 
+	// Delayed messages close to their inclusion deadline are sequenced even if they're not finalized yet
+	finalizedLimit := finalized
+	currentBlock := lastBlockHeader.Number.Uint64()
+	if due, ok := config.inclusionDueBlock(currentBlock); ok && due > finalized {
+		finalized = due
+		finalizedHash = common.Hash{}
+	}
+
+	d.updateOldestPendingAge(currentBlock)
 	if d.waitingForFinalizedBlock > finalized {
 		return nil
 	}
@@ -146,6 +193,8 @@ func (d *DelayedSequencer) sequenceWithoutLockout(ctx context.Context, lastBlock
 	pos := startPos
 	var lastDelayedAcc common.Hash
 	var messages []*arbostypes.L1IncomingMessage
+	var parentChainBlocks []uint64
+	d.oldestPendingBlock = 0
 	for pos < dbDelayedCount {
 		msg, acc, parentChainBlockNumber, err := d.inbox.GetDelayedMessageAccumulatorAndParentChainBlockNumber(ctx, pos)
 		if err != nil {
@@ -154,6 +203,7 @@ func (d *DelayedSequencer) sequenceWithoutLockout(ctx context.Context, lastBlock
 		if parentChainBlockNumber > finalized {
 			// Message isn't finalized yet; stop here
 			d.waitingForFinalizedBlock = parentChainBlockNumber
+			d.oldestPendingBlock = parentChainBlockNumber
 			break
 		}
 		if lastDelayedAcc != (common.Hash{}) {
@@ -176,8 +226,10 @@ func (d *DelayedSequencer) sequenceWithoutLockout(ctx context.Context, lastBlock
 			return err
 		}
 		messages = append(messages, msg)
+		parentChainBlocks = append(parentChainBlocks, parentChainBlockNumber)
 		pos++
 	}
+	d.updateOldestPendingAge(currentBlock)
 
 	// Sequence the delayed messages, if any
 	if len(messages) > 0 {
@@ -196,12 +248,35 @@ func (d *DelayedSequencer) sequenceWithoutLockout(ctx context.Context, lastBlock
 				return err
 			}
 		}
+		var deadlineSequenced int64
+		for _, parentChainBlock := range parentChainBlocks {
+			if currentBlock > parentChainBlock {
+				// #nosec G115
+				delayedLatencyHistogram.Update(int64(currentBlock - parentChainBlock))
+			}
+			if parentChainBlock > finalizedLimit {
+				deadlineSequenced++
+			}
+		}
+		if deadlineSequenced > 0 {
+			delayedDeadlineSequencedCount.Inc(deadlineSequenced)
+			log.Warn("DelayedSequencer: sequenced unfinalized delayed messages close to their inclusion deadline", "msgnum", deadlineSequenced, "finalized", finalizedLimit, "due", finalized)
+		}
 		log.Info("DelayedSequencer: Sequenced", "msgnum", len(messages), "startpos", startPos)
 	}
 
 	return nil
 }
 
+func (d *DelayedSequencer) updateOldestPendingAge(currentBlock uint64) {
+	var age uint64
+	if d.oldestPendingBlock != 0 && currentBlock > d.oldestPendingBlock {
+		age = currentBlock - d.oldestPendingBlock
+	}
+	// #nosec G115
+	delayedOldestPendingAgeGauge.Update(int64(age))
+}
+
 // Dangerous: bypasses lockout check!
 func (d *DelayedSequencer) ForceSequenceDelayed(ctx context.Context) error {
 	lastBlockHeader, err := d.l1Reader.LastHeader(ctx)
@@ -0,0 +1,28 @@
+package arbnode
+
+import (
+	"testing"
+)
+
+func TestDelayedSequencerInclusionDueBlock(t *testing.T) {
+	config := TestDelayedSequencerConfig
+	if _, ok := config.inclusionDueBlock(1000); ok {
+		Fail(t, "inclusion deadline enforced while disabled")
+	}
+
+	config.InclusionDeadline = 100
+	config.InclusionDeadlineMargin = 20
+	Require(t, config.Validate())
+	if _, ok := config.inclusionDueBlock(79); ok {
+		Fail(t, "delayed messages due before the chain reached the deadline window")
+	}
+	due, ok := config.inclusionDueBlock(1000)
+	if !ok || due != 920 {
+		Fail(t, "unexpected due block", due, ok)
+	}
+
+	config.InclusionDeadlineMargin = 100
+	if config.Validate() == nil {
+		Fail(t, "accepted a margin as large as the deadline")
+	}
+}
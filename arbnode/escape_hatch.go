@@ -0,0 +1,168 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package arbnode
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rlp"
+
+	"github.com/offchainlabs/nitro/arbutil"
+	"github.com/offchainlabs/nitro/util/dbutil"
+)
+
+// Every time HotShot is considered down a new escape hatch epoch begins. Messages that the
+// batch poster lets through without espresso verification are recorded with the epoch they
+// were posted in, so that validators and auditors can treat those ranges differently.
+
+// EscapeHatchRecord is persisted for each message sequenced without espresso confirmation.
+type EscapeHatchRecord struct {
+	Epoch     uint64 `json:"epoch"`
+	Timestamp uint64 `json:"timestamp"`
+}
+
+// EscapeHatchRange is a contiguous range [Start, End) of messages sequenced in the same escape hatch epoch.
+type EscapeHatchRange struct {
+	Start arbutil.MessageIndex `json:"start"`
+	End   arbutil.MessageIndex `json:"end"`
+	Epoch uint64               `json:"epoch"`
+}
+
+func (s *TransactionStreamer) getEscapeHatchEpoch() (uint64, error) {
+	data, err := s.db.Get(escapeHatchEpochKey)
+	if err != nil {
+		if dbutil.IsErrNotFound(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	var epoch uint64
+	err = rlp.DecodeBytes(data, &epoch)
+	if err != nil {
+		return 0, err
+	}
+	return epoch, nil
+}
+
+// beginEscapeHatchEpoch is called whenever HotShot switches from up to down.
+// Failures are only logged, as they must not prevent the escape hatch from being activated.
+func (s *TransactionStreamer) beginEscapeHatchEpoch() {
+	epoch, err := s.getEscapeHatchEpoch()
+	if err != nil {
+		log.Warn("failed to read the escape hatch epoch", "err", err)
+		return
+	}
+	epochBytes, err := rlp.EncodeToBytes(epoch + 1)
+	if err != nil {
+		log.Warn("failed to encode the escape hatch epoch", "err", err)
+		return
+	}
+	if err := s.db.Put(escapeHatchEpochKey, epochBytes); err != nil {
+		log.Warn("failed to write the escape hatch epoch", "err", err)
+		return
+	}
+	log.Info("escape hatch epoch started", "epoch", epoch+1)
+}
+
+// recordEscapeHatchMessage marks the message at pos as sequenced without espresso confirmation.
+// A message keeps the epoch it was first recorded with.
+func (s *TransactionStreamer) recordEscapeHatchMessage(pos arbutil.MessageIndex) error {
+	recorded, err := s.writeEscapeHatchRecord(s.db, pos)
+	if err != nil {
+		return err
+	}
+	if recorded {
+		s.updateEspressoJustifiedWatermark()
+	}
+	return nil
+}
+
+// writeEscapeHatchRecord writes the escape hatch record of the message at pos to batch, unless it's already
+// recorded. Returns true if the record was written.
+func (s *TransactionStreamer) writeEscapeHatchRecord(batch ethdb.KeyValueWriter, pos arbutil.MessageIndex) (bool, error) {
+	key := dbKey(escapeHatchPrefix, uint64(pos))
+	has, err := s.db.Has(key)
+	if err != nil || has {
+		return false, err
+	}
+	epoch, err := s.getEscapeHatchEpoch()
+	if err != nil {
+		return false, err
+	}
+	record := EscapeHatchRecord{
+		Epoch: epoch,
+		// #nosec G115
+		Timestamp: uint64(time.Now().Unix()),
+	}
+	recordBytes, err := rlp.EncodeToBytes(record)
+	if err != nil {
+		return false, err
+	}
+	return true, batch.Put(key, recordBytes)
+}
+
+// GetEscapeHatchRecord returns the escape hatch record of the message at pos,
+// or nil if the message was sequenced with espresso confirmation.
+func (s *TransactionStreamer) GetEscapeHatchRecord(pos arbutil.MessageIndex) (*EscapeHatchRecord, error) {
+	data, err := s.db.Get(dbKey(escapeHatchPrefix, uint64(pos)))
+	if err != nil {
+		if dbutil.IsErrNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var record EscapeHatchRecord
+	if err := rlp.DecodeBytes(data, &record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// IsEscapeHatchMessage returns true if the message at pos was sequenced without espresso confirmation.
+func (s *TransactionStreamer) IsEscapeHatchMessage(pos arbutil.MessageIndex) (bool, error) {
+	record, err := s.GetEscapeHatchRecord(pos)
+	if err != nil {
+		return false, err
+	}
+	return record != nil, nil
+}
+
+// GetEscapeHatchRanges returns the ranges of messages in [start, end) that were sequenced
+// without espresso confirmation, in ascending order.
+func (s *TransactionStreamer) GetEscapeHatchRanges(start arbutil.MessageIndex, end arbutil.MessageIndex) ([]EscapeHatchRange, error) {
+	if end < start {
+		return nil, fmt.Errorf("invalid escape hatch range [%d, %d)", start, end)
+	}
+	iter := s.db.NewIterator(escapeHatchPrefix, uint64ToKey(uint64(start)))
+	defer iter.Release()
+	var ranges []EscapeHatchRange
+	for iter.Next() {
+		pos := arbutil.MessageIndex(binary.BigEndian.Uint64(bytes.TrimPrefix(iter.Key(), escapeHatchPrefix)))
+		if pos >= end {
+			break
+		}
+		var record EscapeHatchRecord
+		if err := rlp.DecodeBytes(iter.Value(), &record); err != nil {
+			return nil, err
+		}
+		if len(ranges) > 0 {
+			last := &ranges[len(ranges)-1]
+			if last.End == pos && last.Epoch == record.Epoch {
+				last.End = pos + 1
+				continue
+			}
+		}
+		ranges = append(ranges, EscapeHatchRange{
+			Start: pos,
+			End:   pos + 1,
+			Epoch: record.Epoch,
+		})
+	}
+	return ranges, iter.Error()
+}
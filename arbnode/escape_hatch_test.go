@@ -0,0 +1,75 @@
+package arbnode
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/core/rawdb"
+
+	"github.com/offchainlabs/nitro/arbutil"
+)
+
+func TestEscapeHatchRanges(t *testing.T) {
+	streamer := &TransactionStreamer{
+		db: rawdb.NewMemoryDatabase(),
+	}
+	streamer.beginEscapeHatchEpoch()
+	for _, pos := range []arbutil.MessageIndex{2, 3, 4, 7} {
+		Require(t, streamer.recordEscapeHatchMessage(pos))
+	}
+	streamer.beginEscapeHatchEpoch()
+	for _, pos := range []arbutil.MessageIndex{7, 8, 9} {
+		Require(t, streamer.recordEscapeHatchMessage(pos))
+	}
+
+	isEscapeHatch, err := streamer.IsEscapeHatchMessage(5)
+	Require(t, err)
+	if isEscapeHatch {
+		Fail(t, "message 5 shouldn't be marked as an escape hatch message")
+	}
+	record, err := streamer.GetEscapeHatchRecord(7)
+	Require(t, err)
+	if record == nil || record.Epoch != 1 {
+		Fail(t, "message 7 should keep its first epoch", record)
+	}
+
+	ranges, err := streamer.GetEscapeHatchRanges(3, 9)
+	Require(t, err)
+	expected := []EscapeHatchRange{
+		{Start: 3, End: 5, Epoch: 1},
+		{Start: 7, End: 8, Epoch: 1},
+		{Start: 8, End: 9, Epoch: 2},
+	}
+	if !reflect.DeepEqual(ranges, expected) {
+		Fail(t, "unexpected escape hatch ranges", ranges)
+	}
+}
+
+func TestCountUnjustifiedMessages(t *testing.T) {
+	config := TestTransactionStreamerConfig
+	config.Espresso.MigrationActivationPos = 2
+	streamer := &TransactionStreamer{
+		db:             rawdb.NewMemoryDatabase(),
+		config:         func() *TransactionStreamerConfig { return &config },
+		UseEscapeHatch: true,
+	}
+	lastConfirmed := arbutil.MessageIndex(4)
+	skip := arbutil.MessageIndex(6)
+	batch := streamer.db.NewBatch()
+	Require(t, streamer.setEspressoLastConfirmedPos(batch, &lastConfirmed))
+	Require(t, streamer.setSkipVerificationPos(batch, &skip))
+	Require(t, batch.Write())
+	Require(t, streamer.recordEscapeHatchMessage(9))
+
+	// 0-1 are before the migration, 2-4 are confirmed, 5-6 may skip the verification and 9 was posted through the escape hatch
+	unjustified, err := streamer.CountUnjustifiedMessages(0, 12)
+	Require(t, err)
+	if unjustified != 4 {
+		Fail(t, "expected 4 unjustified messages, got", unjustified)
+	}
+	unjustified, err = streamer.CountUnjustifiedMessages(0, 5)
+	Require(t, err)
+	if unjustified != 0 {
+		Fail(t, "expected no unjustified messages, got", unjustified)
+	}
+}
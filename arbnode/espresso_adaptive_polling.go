@@ -0,0 +1,68 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package arbnode
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/metrics"
+	flag "github.com/spf13/pflag"
+)
+
+var espressoPollIntervalGauge = metrics.NewRegisteredGauge("arb/espresso/poll_interval_ms", nil)
+
+// EspressoAdaptivePollingConfig replaces the fixed espresso-txns-polling-interval of the espresso submission loop
+// with an interval adapted to the expected inclusion latency
+type EspressoAdaptivePollingConfig struct {
+	Enable      bool          `koanf:"enable" reload:"hot"`
+	MinInterval time.Duration `koanf:"min-interval" reload:"hot"`
+	MaxInterval time.Duration `koanf:"max-interval" reload:"hot"`
+}
+
+var DefaultEspressoAdaptivePollingConfig = EspressoAdaptivePollingConfig{
+	Enable:      false,
+	MinInterval: 100 * time.Millisecond,
+	MaxInterval: 5 * time.Second,
+}
+
+func EspressoAdaptivePollingConfigAddOptions(prefix string, f *flag.FlagSet) {
+	f.Bool(prefix+".enable", DefaultEspressoAdaptivePollingConfig.Enable, "adapt the polling interval of the espresso submission loop instead of polling on the espresso-txns-polling-interval: poll at the min-interval right after a submission, when the transaction is expected in one of the next hotshot blocks, and double the interval up to the max-interval while nothing happens; newly queued messages wake the loop up right away")
+	f.Duration(prefix+".min-interval", DefaultEspressoAdaptivePollingConfig.MinInterval, "polling interval of the espresso submission loop right after a submission")
+	f.Duration(prefix+".max-interval", DefaultEspressoAdaptivePollingConfig.MaxInterval, "maximum polling interval of the espresso submission loop during quiet periods")
+}
+
+func (c *EspressoAdaptivePollingConfig) Validate() error {
+	if !c.Enable {
+		return nil
+	}
+	if c.MinInterval <= 0 {
+		return errors.New("espresso adaptive-polling min-interval must be positive")
+	}
+	if c.MaxInterval < c.MinInterval {
+		return fmt.Errorf("espresso adaptive-polling max-interval %v is shorter than the min-interval %v", c.MaxInterval, c.MinInterval)
+	}
+	return nil
+}
+
+// espressoPollDelay returns how long the espresso loop waits before polling again while there's nothing else to do.
+// With adaptive polling, the delay starts at the minimum interval after a submission and doubles on every poll, up
+// to the maximum interval. Only called from the espresso loop.
+func (s *TransactionStreamer) espressoPollDelay() time.Duration {
+	config := &s.config().Espresso.AdaptivePolling
+	if !config.Enable {
+		return s.espressoTxnsPollingInterval
+	}
+	delay := min(max(s.espressoPollInterval, config.MinInterval), config.MaxInterval)
+	s.espressoPollInterval = min(2*delay, config.MaxInterval)
+	espressoPollIntervalGauge.Update(delay.Milliseconds())
+	return delay
+}
+
+// resetEspressoPollDelay makes the espresso loop poll at the minimum interval again, as a transaction was just
+// submitted and its inclusion is expected within a few HotShot blocks. Only called from the espresso loop.
+func (s *TransactionStreamer) resetEspressoPollDelay() {
+	s.espressoPollInterval = 0
+}
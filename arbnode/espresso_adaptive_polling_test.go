@@ -0,0 +1,38 @@
+package arbnode
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEspressoAdaptivePolling(t *testing.T) {
+	config := TestTransactionStreamerConfig
+	streamer := &TransactionStreamer{
+		config:                      func() *TransactionStreamerConfig { return &config },
+		espressoTxnsPollingInterval: 500 * time.Millisecond,
+	}
+	if delay := streamer.espressoPollDelay(); delay != 500*time.Millisecond {
+		Fail(t, "expected the fixed polling interval without adaptive polling, got", delay)
+	}
+
+	config.Espresso.AdaptivePolling = EspressoAdaptivePollingConfig{Enable: true, MinInterval: 100 * time.Millisecond, MaxInterval: 700 * time.Millisecond}
+	Require(t, config.Espresso.AdaptivePolling.Validate())
+	expectDelays := func(expected ...time.Duration) {
+		t.Helper()
+		for i, want := range expected {
+			if delay := streamer.espressoPollDelay(); delay != want {
+				Fail(t, "unexpected delay", i, "got", delay, "expected", want)
+			}
+		}
+	}
+	// Backs off exponentially during quiet periods, up to the maximum interval
+	expectDelays(100*time.Millisecond, 200*time.Millisecond, 400*time.Millisecond, 700*time.Millisecond, 700*time.Millisecond)
+	// Polls aggressively again after a submission
+	streamer.resetEspressoPollDelay()
+	expectDelays(100*time.Millisecond, 200*time.Millisecond)
+
+	config.Espresso.AdaptivePolling.MaxInterval = 50 * time.Millisecond
+	if err := config.Espresso.AdaptivePolling.Validate(); err == nil {
+		Fail(t, "max interval shorter than the min interval accepted")
+	}
+}
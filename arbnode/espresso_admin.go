@@ -0,0 +1,168 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package arbnode
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+
+	"github.com/offchainlabs/nitro/arbutil"
+)
+
+var espressoAdminEditCounter = metrics.NewRegisteredCounter("arb/espresso/admin/edits", nil)
+
+// The espresso admin operations let operators repair the submission state, e.g. a stale in-flight transaction
+// left by a query node bug, without editing the database by hand. They're performed under the
+// espressoTxnsStateInsertionMutex, so they don't interleave with the submission loop, and every edit is logged.
+
+// EspressoStateListing is the espresso submission state as seen by the admin operations
+type EspressoStateListing struct {
+	EspressoStatus
+	SkipVerificationPos *arbutil.MessageIndex `json:"skipVerificationPos"`
+	SubmittedPayloadLen int                   `json:"submittedPayloadLen"`
+}
+
+// ListEspressoState returns the whole espresso submission state
+func (s *TransactionStreamer) ListEspressoState() (*EspressoStateListing, error) {
+	s.espressoTxnsStateInsertionMutex.Lock()
+	defer s.espressoTxnsStateInsertionMutex.Unlock()
+	status, err := s.GetEspressoStatus()
+	if err != nil {
+		return nil, err
+	}
+	listing := &EspressoStateListing{EspressoStatus: *status}
+	listing.SkipVerificationPos, err = s.getSkipVerificationPos()
+	if err != nil {
+		return nil, err
+	}
+	payload, err := s.getEspressoSubmittedPayload()
+	if err != nil {
+		return nil, err
+	}
+	listing.SubmittedPayloadLen = len(payload)
+	return listing, nil
+}
+
+// ClearEspressoSubmittedTransaction forgets the in-flight espresso transaction and queues its messages for
+// submission again, in front of the pending ones. Returns the requeued positions.
+func (s *TransactionStreamer) ClearEspressoSubmittedTransaction() ([]arbutil.MessageIndex, error) {
+	if s.readOnly {
+		return nil, ErrReadOnly
+	}
+	s.espressoTxnsStateInsertionMutex.Lock()
+	defer s.espressoTxnsStateInsertionMutex.Unlock()
+	submittedPos, err := s.getEspressoSubmittedPos()
+	if err != nil {
+		return nil, err
+	}
+	submittedHash, err := s.getEspressoSubmittedHash()
+	if err != nil {
+		return nil, err
+	}
+	batch := s.newEspressoStateBatch()
+	if err := s.requeueEspressoSubmittedTxns(batch, submittedPos, EspressoSubmissionPending); err != nil {
+		return nil, err
+	}
+	if err := s.writeEspressoStateBatch(batch, espressoIntentRequeue); err != nil {
+		return nil, err
+	}
+	espressoAdminEditCounter.Inc(1)
+	log.Warn("espresso admin: cleared the submitted transaction", "hash", submittedHash, "requeued", submittedPos)
+	return submittedPos, nil
+}
+
+// RequeueEspressoPosition queues the message at pos for espresso submission again, e.g. after its transaction
+// was lost. The message must be sequenced through espresso, and neither pending nor in flight.
+func (s *TransactionStreamer) RequeueEspressoPosition(pos arbutil.MessageIndex) error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	msgCount, err := s.GetMessageCount()
+	if err != nil {
+		return err
+	}
+	if pos >= msgCount {
+		return fmt.Errorf("message %d doesn't exist, the message count is %d", pos, msgCount)
+	}
+	if !s.isEspressoActiveAt(pos) {
+		return fmt.Errorf("message %d was sequenced before the migration to espresso", pos)
+	}
+	s.espressoTxnsStateInsertionMutex.Lock()
+	defer s.espressoTxnsStateInsertionMutex.Unlock()
+	pendingPos, err := s.getEspressoPendingTxnsPos()
+	if err != nil {
+		return err
+	}
+	if slices.Contains(pendingPos, pos) {
+		return fmt.Errorf("message %d is already pending", pos)
+	}
+	submittedPos, err := s.getEspressoSubmittedPos()
+	if err != nil {
+		return err
+	}
+	if slices.Contains(submittedPos, pos) {
+		return fmt.Errorf("message %d is in the in-flight transaction, clear it instead", pos)
+	}
+	prev, err := s.GetEspressoSubmissionRecord(pos)
+	if err != nil {
+		return err
+	}
+	batch := s.db.NewBatch()
+	if err := s.setEspressoSubmissionStatus(batch, []arbutil.MessageIndex{pos}, EspressoSubmissionPending, nil); err != nil {
+		return err
+	}
+	if err := s.appendEspressoPendingTxnPos(batch, pos); err != nil {
+		return err
+	}
+	if err := batch.Delete(dbKey(espressoDeadLetterPrefix, uint64(pos))); err != nil {
+		return err
+	}
+	if err := batch.Write(); err != nil {
+		return err
+	}
+	espressoAdminEditCounter.Inc(1)
+	if prev != nil && prev.Status == EspressoSubmissionDeadLettered {
+		espressoDeadLetterRequeuedCounter.Inc(1)
+	}
+	var prevStatus string
+	if prev != nil {
+		prevStatus = prev.Status.String()
+	}
+	log.Warn("espresso admin: requeued message", "pos", pos, "previousStatus", prevStatus)
+	return nil
+}
+
+// DropEspressoPendingPosition removes the message at pos from the pending queue and marks it expired, as the drop
+// deadline fallback does, so that it isn't queued for submission to espresso again.
+func (s *TransactionStreamer) DropEspressoPendingPosition(pos arbutil.MessageIndex) error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	s.espressoTxnsStateInsertionMutex.Lock()
+	defer s.espressoTxnsStateInsertionMutex.Unlock()
+	pendingPos, err := s.getEspressoPendingTxnsPos()
+	if err != nil {
+		return err
+	}
+	index := slices.Index(pendingPos, pos)
+	if index < 0 {
+		return fmt.Errorf("message %d isn't pending", pos)
+	}
+	batch := s.db.NewBatch()
+	if err := s.setEspressoPendingTxnsPos(batch, slices.Delete(pendingPos, index, index+1)); err != nil {
+		return err
+	}
+	if err := s.setEspressoSubmissionStatus(batch, []arbutil.MessageIndex{pos}, EspressoSubmissionExpired, nil); err != nil {
+		return err
+	}
+	if err := batch.Write(); err != nil {
+		return err
+	}
+	espressoAdminEditCounter.Inc(1)
+	log.Warn("espresso admin: dropped pending message", "pos", pos)
+	return nil
+}
@@ -0,0 +1,73 @@
+package arbnode
+
+import (
+	"reflect"
+	"testing"
+
+	espressoTypes "github.com/EspressoSystems/espresso-sequencer-go/types"
+
+	"github.com/offchainlabs/nitro/arbos/arbostypes"
+	"github.com/offchainlabs/nitro/arbutil"
+)
+
+func TestEspressoAdminOperations(t *testing.T) {
+	streamer := newTestImportStreamer(t)
+	var messages []arbostypes.MessageWithMetadata
+	for i := uint64(0); i < 8; i++ {
+		messages = append(messages, arbostypes.MessageWithMetadata{
+			Message: &arbostypes.L1IncomingMessage{Header: &arbostypes.L1IncomingMessageHeader{Timestamp: i}},
+		})
+	}
+	Require(t, streamer.AddMessages(0, true, messages))
+
+	tx := espressoTypes.Transaction{Payload: []byte("payload"), Namespace: 412346}
+	hash, err := espressoTransactionHash(&tx)
+	Require(t, err)
+	submitted := []arbutil.MessageIndex{3, 4}
+	batch := streamer.db.NewBatch()
+	Require(t, streamer.setEspressoPendingTxnsPos(batch, []arbutil.MessageIndex{5, 6}))
+	Require(t, streamer.setEspressoSubmittedPos(batch, submitted))
+	Require(t, streamer.setEspressoSubmittedHash(batch, hash))
+	Require(t, streamer.setEspressoSubmittedPayload(batch, tx.Payload))
+	Require(t, batch.Write())
+
+	listing, err := streamer.ListEspressoState()
+	Require(t, err)
+	if listing.SubmittedTxHash == nil || *listing.SubmittedTxHash != hash.String() || listing.SubmittedPayloadLen != len(tx.Payload) {
+		Fail(t, "unexpected listing", listing)
+	}
+
+	requeued, err := streamer.ClearEspressoSubmittedTransaction()
+	Require(t, err)
+	if !reflect.DeepEqual(requeued, submitted) {
+		Fail(t, "unexpected requeued positions", requeued)
+	}
+	listing, err = streamer.ListEspressoState()
+	Require(t, err)
+	if listing.SubmittedTxHash != nil || len(listing.SubmittedPositions) != 0 || !reflect.DeepEqual(listing.PendingPositions, []arbutil.MessageIndex{3, 4, 5, 6}) {
+		Fail(t, "submitted transaction not cleared", listing)
+	}
+
+	Require(t, streamer.DropEspressoPendingPosition(5))
+	record, err := streamer.GetEspressoSubmissionRecord(5)
+	Require(t, err)
+	if record == nil || record.Status != EspressoSubmissionExpired {
+		Fail(t, "dropped message not marked expired", record)
+	}
+	if err := streamer.DropEspressoPendingPosition(5); err == nil {
+		Fail(t, "dropped a message that isn't pending")
+	}
+
+	Require(t, streamer.RequeueEspressoPosition(5))
+	if err := streamer.RequeueEspressoPosition(5); err == nil {
+		Fail(t, "requeued a pending message")
+	}
+	if err := streamer.RequeueEspressoPosition(8); err == nil {
+		Fail(t, "requeued a message that doesn't exist")
+	}
+	pending, err := streamer.getEspressoPendingTxnsPos()
+	Require(t, err)
+	if !reflect.DeepEqual(pending, []arbutil.MessageIndex{3, 4, 5, 6}) {
+		Fail(t, "unexpected pending queue", pending)
+	}
+}
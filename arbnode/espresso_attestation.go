@@ -0,0 +1,276 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package arbnode
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+	flag "github.com/spf13/pflag"
+
+	"github.com/offchainlabs/nitro/util/signature"
+)
+
+var (
+	ErrEspressoAttestationInvalid = errors.New("invalid espresso payload attestation")
+
+	espressoAttestationRejectedCounter = metrics.NewRegisteredCounter("arb/espresso/attestation/rejected", nil)
+)
+
+// EspressoAttestationKind identifies the scheme of an attestation in its envelope
+type EspressoAttestationKind byte
+
+const (
+	// A TEE quote over the keccak256 hash of the unsigned payload
+	EspressoAttestationTEE EspressoAttestationKind = 1
+	// An ECDSA signature over the keccak256 hash of the domain separated unsigned payload
+	EspressoAttestationECDSA EspressoAttestationKind = 2
+
+	// The legacy raw quote is attached to payloads as is
+	EspressoAttestationSchemeNone  = ""
+	EspressoAttestationSchemeTEE   = "tee"
+	EspressoAttestationSchemeECDSA = "ecdsa"
+)
+
+// Prefixes the attestations wrapped in an envelope, so that they're told apart from legacy raw quotes
+var espressoAttestationMagic = []byte("EATT")
+
+var espressoECDSAAttestationDomain = []byte("espresso-payload-attestation")
+
+// EspressoAttestationSigner attests the espresso payloads before they're submitted, e.g. from a TEE or with a
+// sequencer key
+type EspressoAttestationSigner interface {
+	Kind() EspressoAttestationKind
+	// Attest returns the attestation of the unsigned payload
+	Attest(unsigned []byte) ([]byte, error)
+}
+
+// EspressoAttestationVerifier verifies the attestation of a finalized espresso payload before its messages are
+// confirmed
+type EspressoAttestationVerifier interface {
+	VerifyAttestation(kind EspressoAttestationKind, unsigned []byte, attestation []byte) error
+}
+
+type EspressoAttestationConfig struct {
+	Scheme         string   `koanf:"scheme"`
+	AllowedSigners []string `koanf:"allowed-signers"`
+}
+
+var DefaultEspressoAttestationConfig = EspressoAttestationConfig{
+	Scheme: EspressoAttestationSchemeNone,
+}
+
+func EspressoAttestationConfigAddOptions(prefix string, f *flag.FlagSet) {
+	f.String(prefix+".scheme", DefaultEspressoAttestationConfig.Scheme, "attestation envelope wrapped around the submitted espresso payloads and verified before their messages are confirmed: \"tee\" for a quote from the configured attestation files, rejected unless a quote verifier is set, \"ecdsa\" for a signature with the sequencer's key (empty = the legacy raw quote, not verified)")
+	f.StringSlice(prefix+".allowed-signers", DefaultEspressoAttestationConfig.AllowedSigners, "addresses whose ecdsa attestations of espresso payloads are accepted")
+}
+
+func (c *EspressoAttestationConfig) Validate() error {
+	switch c.Scheme {
+	case EspressoAttestationSchemeNone, EspressoAttestationSchemeTEE:
+	case EspressoAttestationSchemeECDSA:
+		if len(c.AllowedSigners) == 0 {
+			return errors.New("espresso attestation allowed-signers must be set with the ecdsa scheme")
+		}
+		for _, signer := range c.AllowedSigners {
+			if !common.IsHexAddress(signer) {
+				return fmt.Errorf("espresso attestation allowed signer %q is not a valid address", signer)
+			}
+		}
+	default:
+		return fmt.Errorf("invalid espresso attestation scheme %q, expected %q or %q", c.Scheme, EspressoAttestationSchemeTEE, EspressoAttestationSchemeECDSA)
+	}
+	return nil
+}
+
+// wrapEspressoAttestation builds the envelope of an attestation
+func wrapEspressoAttestation(kind EspressoAttestationKind, attestation []byte) []byte {
+	envelope := make([]byte, 0, len(espressoAttestationMagic)+1+len(attestation))
+	envelope = append(envelope, espressoAttestationMagic...)
+	envelope = append(envelope, byte(kind))
+	return append(envelope, attestation...)
+}
+
+// unwrapEspressoAttestation returns the kind and the attestation of an envelope
+func unwrapEspressoAttestation(envelope []byte) (EspressoAttestationKind, []byte, error) {
+	if len(envelope) <= len(espressoAttestationMagic) || !bytes.HasPrefix(envelope, espressoAttestationMagic) {
+		return 0, nil, fmt.Errorf("%w: missing the attestation envelope", ErrEspressoAttestationInvalid)
+	}
+	return EspressoAttestationKind(envelope[len(espressoAttestationMagic)]), envelope[len(espressoAttestationMagic)+1:], nil
+}
+
+// splitHotShotPayloadSignature returns the signature and the unsigned part of a payload signed by
+// signHotShotPayload
+func splitHotShotPayloadSignature(payload []byte) ([]byte, []byte, error) {
+	if len(payload) < LEN_SIZE {
+		return nil, nil, errors.New("payload too short to parse signature size")
+	}
+	signatureSize := binary.BigEndian.Uint64(payload[:LEN_SIZE])
+	if uint64(len(payload)-LEN_SIZE) < signatureSize {
+		return nil, nil, errors.New("payload too short for signature")
+	}
+	// #nosec G115
+	end := LEN_SIZE + int(signatureSize)
+	return payload[LEN_SIZE:end], payload[end:], nil
+}
+
+func espressoECDSAAttestationHash(unsigned []byte) []byte {
+	return crypto.Keccak256(espressoECDSAAttestationDomain, unsigned)
+}
+
+// ecdsaAttestationSigner attests payloads with a sequencer key
+type ecdsaAttestationSigner struct {
+	signer signature.DataSignerFunc
+}
+
+// NewEspressoECDSAAttestationSigner attests the espresso payloads with the key behind signer
+func NewEspressoECDSAAttestationSigner(signer signature.DataSignerFunc) EspressoAttestationSigner {
+	return ecdsaAttestationSigner{signer: signer}
+}
+
+func (ecdsaAttestationSigner) Kind() EspressoAttestationKind {
+	return EspressoAttestationECDSA
+}
+
+func (a ecdsaAttestationSigner) Attest(unsigned []byte) ([]byte, error) {
+	return a.signer(espressoECDSAAttestationHash(unsigned))
+}
+
+// ecdsaAttestationVerifier accepts the ECDSA attestations of a set of signers
+type ecdsaAttestationVerifier struct {
+	allowed map[common.Address]struct{}
+}
+
+func newEspressoECDSAAttestationVerifier(signers []string) *ecdsaAttestationVerifier {
+	allowed := make(map[common.Address]struct{}, len(signers))
+	for _, signer := range signers {
+		allowed[common.HexToAddress(signer)] = struct{}{}
+	}
+	return &ecdsaAttestationVerifier{allowed: allowed}
+}
+
+func (v *ecdsaAttestationVerifier) VerifyAttestation(kind EspressoAttestationKind, unsigned []byte, attestation []byte) error {
+	if kind != EspressoAttestationECDSA {
+		return fmt.Errorf("%w: expected an ecdsa attestation, got kind %d", ErrEspressoAttestationInvalid, kind)
+	}
+	pubkey, err := crypto.SigToPub(espressoECDSAAttestationHash(unsigned), attestation)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrEspressoAttestationInvalid, err)
+	}
+	signer := crypto.PubkeyToAddress(*pubkey)
+	if _, ok := v.allowed[signer]; !ok {
+		return fmt.Errorf("%w: %v isn't an allowed signer", ErrEspressoAttestationInvalid, signer)
+	}
+	return nil
+}
+
+// teeAttestationSigner attests payloads with the quote of the configured TEE
+type teeAttestationSigner struct {
+	s *TransactionStreamer
+}
+
+func (teeAttestationSigner) Kind() EspressoAttestationKind {
+	return EspressoAttestationTEE
+}
+
+func (a teeAttestationSigner) Attest(unsigned []byte) ([]byte, error) {
+	return a.s.getAttestationQuote(unsigned)
+}
+
+// teeAttestationVerifier is used with the tee scheme until a verifier of the quotes against the TEE's collateral
+// is injected. It can't tell a genuine quote from a forged one, so it rejects every attestation.
+type teeAttestationVerifier struct{}
+
+func (teeAttestationVerifier) VerifyAttestation(kind EspressoAttestationKind, unsigned []byte, attestation []byte) error {
+	if kind != EspressoAttestationTEE {
+		return fmt.Errorf("%w: expected a tee attestation, got kind %d", ErrEspressoAttestationInvalid, kind)
+	}
+	return fmt.Errorf("%w: no tee quote verifier is set", ErrEspressoAttestationInvalid)
+}
+
+// SetEspressoAttestationSigner replaces the signer of the configured attestation scheme, e.g. to attest payloads
+// with a key held by a remote signer, it must be called before Start
+func (s *TransactionStreamer) SetEspressoAttestationSigner(signer EspressoAttestationSigner) {
+	if s.Started() {
+		panic("trying to set espresso attestation signer after start")
+	}
+	s.espressoAttestationSigner = signer
+}
+
+// SetEspressoAttestationVerifier replaces the verifier of the configured attestation scheme, e.g. to verify TEE
+// quotes against the platform's collateral, it must be called before Start
+func (s *TransactionStreamer) SetEspressoAttestationVerifier(verifier EspressoAttestationVerifier) {
+	if s.Started() {
+		panic("trying to set espresso attestation verifier after start")
+	}
+	s.espressoAttestationVerifier = verifier
+}
+
+// initEspressoAttestation sets up the signer and verifier of the configured attestation scheme, the injected
+// ones are kept
+func (s *TransactionStreamer) initEspressoAttestation() error {
+	config := &s.config().Espresso.Attestation
+	switch config.Scheme {
+	case EspressoAttestationSchemeTEE:
+		if s.espressoAttestationSigner == nil {
+			s.espressoAttestationSigner = teeAttestationSigner{s}
+		}
+		if s.espressoAttestationVerifier == nil {
+			log.Warn("no tee quote verifier is set, every espresso payload attestation is rejected")
+			s.espressoAttestationVerifier = teeAttestationVerifier{}
+		}
+	case EspressoAttestationSchemeECDSA:
+		if s.espressoAttestationSigner == nil {
+			return errors.New("the ecdsa espresso attestation scheme requires a signer")
+		}
+		if s.espressoAttestationVerifier == nil {
+			s.espressoAttestationVerifier = newEspressoECDSAAttestationVerifier(config.AllowedSigners)
+		}
+	}
+	return nil
+}
+
+// espressoPayloadSigner returns the function producing the signature slot of the submitted payloads
+func (s *TransactionStreamer) espressoPayloadSigner() func([]byte) ([]byte, error) {
+	signer := s.espressoAttestationSigner
+	if signer == nil {
+		return s.getAttestationQuote
+	}
+	return func(unsigned []byte) ([]byte, error) {
+		attestation, err := signer.Attest(unsigned)
+		if err != nil {
+			return nil, fmt.Errorf("failed to attest the espresso payload: %w", err)
+		}
+		return wrapEspressoAttestation(signer.Kind(), attestation), nil
+	}
+}
+
+// verifyEspressoAttestation verifies the attestation of a finalized payload, if an attestation scheme is
+// configured. The signature slot is expected where signHotShotPayload puts it.
+func (s *TransactionStreamer) verifyEspressoAttestation(payload []byte) error {
+	verifier := s.espressoAttestationVerifier
+	if verifier == nil {
+		return nil
+	}
+	envelope, unsigned, err := splitHotShotPayloadSignature(payload)
+	if err == nil {
+		var kind EspressoAttestationKind
+		var attestation []byte
+		kind, attestation, err = unwrapEspressoAttestation(envelope)
+		if err == nil {
+			err = verifier.VerifyAttestation(kind, unsigned, attestation)
+		}
+	}
+	if err != nil {
+		espressoAttestationRejectedCounter.Inc(1)
+		log.Error("rejected the attestation of a finalized espresso payload", "err", err)
+	}
+	return err
+}
@@ -0,0 +1,114 @@
+package arbnode
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+
+	"github.com/offchainlabs/nitro/util/signature"
+)
+
+func TestEspressoECDSAAttestation(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	Require(t, err)
+	otherKey, err := crypto.GenerateKey()
+	Require(t, err)
+
+	streamer := newTestImportStreamer(t)
+	streamer.config().Espresso.Attestation = EspressoAttestationConfig{
+		Scheme:         EspressoAttestationSchemeECDSA,
+		AllowedSigners: []string{crypto.PubkeyToAddress(key.PublicKey).Hex()},
+	}
+	if err := streamer.initEspressoAttestation(); err == nil {
+		Fail(t, "ecdsa scheme accepted without a signer")
+	}
+	streamer.SetEspressoAttestationSigner(NewEspressoECDSAAttestationSigner(signature.DataSignerFromPrivateKey(key)))
+	Require(t, streamer.initEspressoAttestation())
+
+	unsigned := []byte("unsigned payload")
+	payload, err := signHotShotPayload(unsigned, streamer.espressoPayloadSigner())
+	Require(t, err)
+	Require(t, streamer.verifyEspressoAttestation(payload))
+
+	// A payload attested by another key, without the envelope, or modified after it was attested is rejected
+	otherPayload, err := signHotShotPayload(unsigned, NewEspressoECDSAAttestationSigner(signature.DataSignerFromPrivateKey(otherKey)).Attest)
+	Require(t, err)
+	tampered := append(append([]byte{}, payload...), 0)
+	for _, invalid := range [][]byte{otherPayload, tampered} {
+		if err := streamer.verifyEspressoAttestation(invalid); !errors.Is(err, ErrEspressoAttestationInvalid) {
+			Fail(t, "invalid attestation accepted", err)
+		}
+	}
+	rejected := espressoAttestationRejectedCounter.Snapshot().Count()
+	if rejected < 2 {
+		Fail(t, "rejections not counted", rejected)
+	}
+}
+
+func TestEspressoTEEAttestationEnvelope(t *testing.T) {
+	streamer := newTestImportStreamer(t)
+	// Without an attestation scheme the legacy raw quote is attached and nothing is verified
+	payload, err := signHotShotPayload([]byte("unsigned"), streamer.espressoPayloadSigner())
+	Require(t, err)
+	Require(t, streamer.verifyEspressoAttestation(payload))
+
+	streamer.config().Espresso.Attestation.Scheme = EspressoAttestationSchemeTEE
+	Require(t, streamer.initEspressoAttestation())
+	// No quote is produced without the attestation files
+	payload, err = signHotShotPayload([]byte("unsigned"), streamer.espressoPayloadSigner())
+	Require(t, err)
+	if err := streamer.verifyEspressoAttestation(payload); !errors.Is(err, ErrEspressoAttestationInvalid) {
+		Fail(t, "empty quote accepted", err)
+	}
+	envelope := wrapEspressoAttestation(EspressoAttestationTEE, []byte("quote"))
+	kind, quote, err := unwrapEspressoAttestation(envelope)
+	Require(t, err)
+	if err := (teeAttestationVerifier{}).VerifyAttestation(kind, nil, quote); !errors.Is(err, ErrEspressoAttestationInvalid) {
+		Fail(t, "tee quote accepted without a quote verifier", err)
+	}
+}
+
+// quoteAttestationVerifier stands in for a verifier of the quotes against the TEE's collateral
+type quoteAttestationVerifier struct {
+	quote []byte
+}
+
+func (v quoteAttestationVerifier) VerifyAttestation(kind EspressoAttestationKind, unsigned []byte, attestation []byte) error {
+	if kind != EspressoAttestationTEE || !bytes.Equal(attestation, v.quote) {
+		return ErrEspressoAttestationInvalid
+	}
+	return nil
+}
+
+func TestEspressoTEEAttestationForged(t *testing.T) {
+	unsigned := []byte("unsigned payload")
+	forged, err := signHotShotPayload(unsigned, func([]byte) ([]byte, error) {
+		return wrapEspressoAttestation(EspressoAttestationTEE, []byte("forged quote")), nil
+	})
+	Require(t, err)
+	genuine, err := signHotShotPayload(unsigned, func([]byte) ([]byte, error) {
+		return wrapEspressoAttestation(EspressoAttestationTEE, []byte("genuine quote")), nil
+	})
+	Require(t, err)
+
+	// Without a quote verifier, any payload of a foreign submitter is rejected
+	streamer := newTestImportStreamer(t)
+	streamer.config().Espresso.Attestation.Scheme = EspressoAttestationSchemeTEE
+	Require(t, streamer.initEspressoAttestation())
+	for _, payload := range [][]byte{forged, genuine} {
+		if err := streamer.verifyEspressoAttestation(payload); !errors.Is(err, ErrEspressoAttestationInvalid) {
+			Fail(t, "tee attestation accepted without a quote verifier", err)
+		}
+	}
+
+	streamer = newTestImportStreamer(t)
+	streamer.config().Espresso.Attestation.Scheme = EspressoAttestationSchemeTEE
+	streamer.SetEspressoAttestationVerifier(quoteAttestationVerifier{quote: []byte("genuine quote")})
+	Require(t, streamer.initEspressoAttestation())
+	Require(t, streamer.verifyEspressoAttestation(genuine))
+	if err := streamer.verifyEspressoAttestation(forged); !errors.Is(err, ErrEspressoAttestationInvalid) {
+		Fail(t, "forged tee attestation accepted", err)
+	}
+}
@@ -0,0 +1,159 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package arbnode
+
+import (
+	"context"
+	"slices"
+	"sync"
+
+	espressoClient "github.com/EspressoSystems/espresso-sequencer-go/client"
+	espressoTypes "github.com/EspressoSystems/espresso-sequencer-go/types"
+
+	"github.com/offchainlabs/nitro/util/containers"
+)
+
+const (
+	espressoBlockCacheSize = 256
+	// Maximum span of a single header range request, so that a few far apart heights don't fetch every header between them
+	espressoMaxHeaderRange = 64
+)
+
+type espressoBlockKey struct {
+	height    uint64
+	namespace uint64
+}
+
+// espressoBlock is a HotShot block header together with the transactions, namespace proof
+// and VidCommon of a single namespace in it.
+type espressoBlock struct {
+	Header    espressoTypes.HeaderImpl
+	Namespace espressoClient.TransactionsInBlock
+}
+
+// espressoBlockCache caches HotShot headers and namespace data. HotShot blocks are final once they're
+// available from the query service, so cached entries are only evicted when they fail verification.
+// Headers are keyed by height alone, as they're the same for all namespaces. A nil cache caches nothing.
+type espressoBlockCache struct {
+	mutex      sync.Mutex
+	headers    *containers.LruCache[uint64, espressoTypes.HeaderImpl]
+	namespaces *containers.LruCache[espressoBlockKey, espressoClient.TransactionsInBlock]
+}
+
+func newEspressoBlockCache(size int) *espressoBlockCache {
+	return &espressoBlockCache{
+		headers:    containers.NewLruCache[uint64, espressoTypes.HeaderImpl](size),
+		namespaces: containers.NewLruCache[espressoBlockKey, espressoClient.TransactionsInBlock](size),
+	}
+}
+
+func (c *espressoBlockCache) getHeader(height uint64) (espressoTypes.HeaderImpl, bool) {
+	if c == nil {
+		return espressoTypes.HeaderImpl{}, false
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.headers.Get(height)
+}
+
+func (c *espressoBlockCache) addHeader(height uint64, header espressoTypes.HeaderImpl) {
+	if c == nil {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.headers.Add(height, header)
+}
+
+func (c *espressoBlockCache) getNamespace(height uint64, namespace uint64) (espressoClient.TransactionsInBlock, bool) {
+	if c == nil {
+		return espressoClient.TransactionsInBlock{}, false
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.namespaces.Get(espressoBlockKey{height, namespace})
+}
+
+func (c *espressoBlockCache) addNamespace(height uint64, namespace uint64, data espressoClient.TransactionsInBlock) {
+	if c == nil {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.namespaces.Add(espressoBlockKey{height, namespace}, data)
+}
+
+// removeBlock evicts the header and namespace data of a block that failed verification
+func (c *espressoBlockCache) removeBlock(height uint64, namespace uint64) {
+	if c == nil {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.headers.Remove(height)
+	c.namespaces.Remove(espressoBlockKey{height, namespace})
+}
+
+func (s *TransactionStreamer) fetchEspressoHeader(ctx context.Context, height uint64) (espressoTypes.HeaderImpl, error) {
+	if header, ok := s.espressoBlockCache.getHeader(height); ok {
+		return header, nil
+	}
+	header, err := s.espressoClient.FetchHeaderByHeight(ctx, height)
+	if err != nil {
+		return espressoTypes.HeaderImpl{}, err
+	}
+	s.espressoBlockCache.addHeader(height, header)
+	return header, nil
+}
+
+func (s *TransactionStreamer) fetchEspressoNamespace(ctx context.Context, height uint64, namespace uint64) (espressoClient.TransactionsInBlock, error) {
+	if data, ok := s.espressoBlockCache.getNamespace(height, namespace); ok {
+		return data, nil
+	}
+	data, err := s.espressoClient.FetchTransactionsInBlock(ctx, height, namespace)
+	if err != nil {
+		return espressoClient.TransactionsInBlock{}, err
+	}
+	s.espressoBlockCache.addNamespace(height, namespace, data)
+	return data, nil
+}
+
+// fetchEspressoBlocks returns the header and namespace data of every distinct height in heights, sorted by height.
+// Messages finalized in the same block share a single fetch, and headers missing from the cache are fetched
+// with one range request when they're close together.
+func (s *TransactionStreamer) fetchEspressoBlocks(ctx context.Context, heights []uint64, namespace uint64) ([]espressoBlock, error) {
+	heights = slices.Clone(heights)
+	slices.Sort(heights)
+	heights = slices.Compact(heights)
+
+	var missing []uint64
+	for _, height := range heights {
+		if _, ok := s.espressoBlockCache.getHeader(height); !ok {
+			missing = append(missing, height)
+		}
+	}
+	if len(missing) > 1 && missing[len(missing)-1]-missing[0] < espressoMaxHeaderRange {
+		headers, err := s.espressoClient.FetchHeadersByRange(ctx, missing[0], missing[len(missing)-1]+1)
+		if err != nil {
+			return nil, err
+		}
+		for _, header := range headers {
+			s.espressoBlockCache.addHeader(header.Header.GetBlockHeight(), header)
+		}
+	}
+
+	blocks := make([]espressoBlock, 0, len(heights))
+	for _, height := range heights {
+		header, err := s.fetchEspressoHeader(ctx, height)
+		if err != nil {
+			return nil, err
+		}
+		data, err := s.fetchEspressoNamespace(ctx, height, namespace)
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, espressoBlock{Header: header, Namespace: data})
+	}
+	return blocks, nil
+}
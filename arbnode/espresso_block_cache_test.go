@@ -0,0 +1,97 @@
+package arbnode
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	espressoClient "github.com/EspressoSystems/espresso-sequencer-go/client"
+	espressoTypes "github.com/EspressoSystems/espresso-sequencer-go/types"
+)
+
+type countingEspressoClient struct {
+	headerCalls     int
+	rangeCalls      int
+	namespaceCalls  int
+	namespaceHeight map[uint64]int
+}
+
+func (c *countingEspressoClient) header(height uint64) espressoTypes.HeaderImpl {
+	return espressoTypes.HeaderImpl{Header: &espressoTypes.Header0_1{Height: height}}
+}
+
+func (c *countingEspressoClient) FetchLatestBlockHeight(ctx context.Context) (uint64, error) {
+	return 0, errors.New("unsupported")
+}
+
+func (c *countingEspressoClient) FetchHeaderByHeight(ctx context.Context, blockHeight uint64) (espressoTypes.HeaderImpl, error) {
+	c.headerCalls++
+	return c.header(blockHeight), nil
+}
+
+func (c *countingEspressoClient) FetchHeadersByRange(ctx context.Context, from uint64, until uint64) ([]espressoTypes.HeaderImpl, error) {
+	c.rangeCalls++
+	var headers []espressoTypes.HeaderImpl
+	for height := from; height < until; height++ {
+		headers = append(headers, c.header(height))
+	}
+	return headers, nil
+}
+
+func (c *countingEspressoClient) FetchTransactionByHash(ctx context.Context, hash *espressoTypes.TaggedBase64) (espressoTypes.TransactionQueryData, error) {
+	return espressoTypes.TransactionQueryData{}, errors.New("unsupported")
+}
+
+func (c *countingEspressoClient) FetchBlockMerkleProof(ctx context.Context, rootHeight uint64, hotshotHeight uint64) (espressoTypes.HotShotBlockMerkleProof, error) {
+	return espressoTypes.HotShotBlockMerkleProof{}, errors.New("unsupported")
+}
+
+func (c *countingEspressoClient) FetchTransactionsInBlock(ctx context.Context, blockHeight uint64, namespace uint64) (espressoClient.TransactionsInBlock, error) {
+	c.namespaceCalls++
+	c.namespaceHeight[blockHeight]++
+	return espressoClient.TransactionsInBlock{Transactions: []espressoTypes.Bytes{[]byte{byte(blockHeight)}}}, nil
+}
+
+func (c *countingEspressoClient) SubmitTransaction(ctx context.Context, tx espressoTypes.Transaction) (*espressoTypes.TaggedBase64, error) {
+	return nil, errors.New("unsupported")
+}
+
+func TestEspressoBlockCache(t *testing.T) {
+	ctx := context.Background()
+	client := &countingEspressoClient{namespaceHeight: make(map[uint64]int)}
+	streamer := &TransactionStreamer{
+		espressoClient:     client,
+		espressoBlockCache: newEspressoBlockCache(espressoBlockCacheSize),
+	}
+
+	// Several messages finalized in the same blocks only fetch each block once
+	blocks, err := streamer.fetchEspressoBlocks(ctx, []uint64{12, 10, 12, 10, 11}, 1)
+	Require(t, err)
+	if len(blocks) != 3 {
+		Fail(t, "expected 3 blocks, got", len(blocks))
+	}
+	for i, block := range blocks {
+		if block.Header.Header.GetBlockHeight() != uint64(10+i) || block.Namespace.Transactions[0][0] != byte(10+i) {
+			Fail(t, "unexpected block", i, block)
+		}
+	}
+	if client.rangeCalls != 1 || client.headerCalls != 0 || client.namespaceCalls != 3 {
+		Fail(t, "unexpected fetches", client.rangeCalls, client.headerCalls, client.namespaceCalls)
+	}
+
+	// Polling the same block again is served from the cache
+	_, err = streamer.fetchEspressoBlocks(ctx, []uint64{11}, 1)
+	Require(t, err)
+	_, err = streamer.fetchEspressoHeader(ctx, 12)
+	Require(t, err)
+	if client.rangeCalls != 1 || client.headerCalls != 0 || client.namespaceCalls != 3 {
+		Fail(t, "unexpected fetches after polling again", client.rangeCalls, client.headerCalls, client.namespaceCalls)
+	}
+
+	// A different namespace of a cached block only fetches the namespace
+	_, err = streamer.fetchEspressoBlocks(ctx, []uint64{11}, 2)
+	Require(t, err)
+	if client.headerCalls != 0 || client.namespaceHeight[11] != 2 {
+		Fail(t, "unexpected fetches for another namespace", client.headerCalls, client.namespaceHeight[11])
+	}
+}
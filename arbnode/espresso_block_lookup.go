@@ -0,0 +1,55 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package arbnode
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/rlp"
+
+	"github.com/offchainlabs/nitro/arbutil"
+)
+
+// Messages are indexed by the height of the HotShot block they were finalized in whenever their justification is
+// written, with the same layout as the lookup indexes of message_lookup.go. Messages justified before the index was
+// kept aren't in it.
+
+// writeEspressoBlockLookup adds the message at pos to the index of the HotShot block it was finalized in
+func writeEspressoBlockLookup(batch ethdb.KeyValueWriter, pos arbutil.MessageIndex, height uint64) error {
+	return batch.Put(messageLookupKey(espressoBlockLookupPrefix, height, pos), []byte{})
+}
+
+func deleteEspressoBlockLookup(batch ethdb.KeyValueWriter, pos arbutil.MessageIndex, height uint64) error {
+	return batch.Delete(messageLookupKey(espressoBlockLookupPrefix, height, pos))
+}
+
+// deleteEspressoBlockLookupStartingAt removes the messages from count on from the HotShot block index, by the
+// justifications stored for them
+func (s *TransactionStreamer) deleteEspressoBlockLookupStartingAt(batch ethdb.KeyValueWriter, count arbutil.MessageIndex) error {
+	iter := s.db.NewIterator(espressoJustificationPrefix, uint64ToKey(uint64(count)))
+	defer iter.Release()
+	for iter.Next() {
+		key := bytes.TrimPrefix(iter.Key(), espressoJustificationPrefix)
+		if len(key) != 8 {
+			continue
+		}
+		var justification EspressoJustification
+		if err := rlp.DecodeBytes(iter.Value(), &justification); err != nil {
+			return err
+		}
+		pos := arbutil.MessageIndex(binary.BigEndian.Uint64(key))
+		if err := deleteEspressoBlockLookup(batch, pos, justification.HotShotHeight); err != nil {
+			return err
+		}
+	}
+	return iter.Error()
+}
+
+// GetMessagesInEspressoBlock returns the positions of the stored messages finalized in the HotShot block at height,
+// in increasing order
+func (s *TransactionStreamer) GetMessagesInEspressoBlock(height uint64) ([]arbutil.MessageIndex, error) {
+	return s.findMessageLookup(espressoBlockLookupPrefix, height, height, 0)
+}
@@ -0,0 +1,38 @@
+package arbnode
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/offchainlabs/nitro/arbutil"
+)
+
+func TestEspressoBlockLookup(t *testing.T) {
+	streamer := newTestStreamer(t, nil, nil)
+	Require(t, streamer.AddMessages(0, true, testChainMessages(0, 4)))
+
+	batch := streamer.db.NewBatch()
+	for pos, height := range map[arbutil.MessageIndex]uint64{1: 10, 2: 10, 3: 11} {
+		justification := &EspressoJustification{HotShotHeight: height, Header: []byte("{}"), RootHeight: 12, Proof: []byte("{}")}
+		Require(t, streamer.setEspressoJustification(batch, pos, justification))
+	}
+	Require(t, batch.Write())
+	expectMessages := func(height uint64, expected ...arbutil.MessageIndex) {
+		t.Helper()
+		positions, err := streamer.GetMessagesInEspressoBlock(height)
+		Require(t, err)
+		if !slices.Equal(positions, expected) {
+			Fail(t, "unexpected messages in espresso block", height, positions, "expected", expected)
+		}
+	}
+	expectMessages(10, 1, 2)
+	expectMessages(11, 3)
+	expectMessages(12)
+
+	// The reorged messages are removed from the index along with their justifications
+	batch = streamer.db.NewBatch()
+	Require(t, streamer.deleteEspressoBlockLookupStartingAt(batch, 2))
+	Require(t, batch.Write())
+	expectMessages(10, 1)
+	expectMessages(11)
+}
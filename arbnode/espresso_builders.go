@@ -0,0 +1,112 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package arbnode
+
+import (
+	"context"
+
+	espressoClient "github.com/EspressoSystems/espresso-sequencer-go/client"
+	espressoTypes "github.com/EspressoSystems/espresso-sequencer-go/types"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+var (
+	espressoBuilderSubmitFailedCounter = metrics.NewRegisteredCounter("arb/espresso/builders/submit_failed", nil)
+	espressoBuilderFirstCounter        = metrics.NewRegisteredCounter("arb/espresso/builders/first", nil)
+)
+
+// espressoSubmitter is the submit API of a HotShot query node or builder
+type espressoSubmitter interface {
+	SubmitTransaction(ctx context.Context, tx espressoTypes.Transaction) (*espressoTypes.TaggedBase64, error)
+}
+
+var _ espressoSubmitter = (*espressoClient.Client)(nil)
+
+type espressoBuilder struct {
+	url    string
+	client espressoSubmitter
+}
+
+// setBuilderUrls sets the builders every transaction is also submitted to. A builder is expected to serve the
+// same submit API as the query nodes.
+func (c *espressoMultiClient) setBuilderUrls(urls []string) {
+	builders := make([]espressoBuilder, 0, len(urls))
+	for _, url := range urls {
+		builders = append(builders, espressoBuilder{url: url, client: espressoClient.NewClient(url)})
+	}
+	c.nodesMutex.Lock()
+	defer c.nodesMutex.Unlock()
+	c.builders = builders
+}
+
+func (c *espressoMultiClient) getBuilders() []espressoBuilder {
+	c.nodesMutex.RLock()
+	defer c.nodesMutex.RUnlock()
+	return c.builders
+}
+
+type espressoSubmitResult struct {
+	url  string
+	hash *espressoTypes.TaggedBase64
+	err  error
+}
+
+// submitToBuilders submits tx to the query nodes and every builder simultaneously, and returns the hash of
+// whichever submission succeeds first. HotShot identifies transactions by their commitment, so the copies
+// landing later are the same transaction, and its finality is only polled once through its hash. The other
+// submissions keep going in the background until they complete.
+func (c *espressoMultiClient) submitToBuilders(ctx context.Context, tx espressoTypes.Transaction, builders []espressoBuilder) (*espressoTypes.TaggedBase64, error) {
+	results := make(chan espressoSubmitResult, len(builders)+1)
+	go func() {
+		hash, err := espressoMultiCall(ctx, c, "SubmitTransaction", func(client *espressoClient.Client) (*espressoTypes.TaggedBase64, error) {
+			return client.SubmitTransaction(ctx, tx)
+		})
+		results <- espressoSubmitResult{hash: hash, err: err}
+	}()
+	for _, builder := range builders {
+		builder := builder
+		go func() {
+			hash, err := builder.client.SubmitTransaction(ctx, tx)
+			results <- espressoSubmitResult{url: builder.url, hash: hash, err: err}
+		}()
+	}
+	var firstErr error
+	for i := 0; i < len(builders)+1; i++ {
+		res := <-results
+		if res.err != nil {
+			if res.url != "" {
+				espressoBuilderSubmitFailedCounter.Inc(1)
+				log.Warn("failed to submit the espresso transaction to a builder", "url", res.url, "err", res.err)
+			}
+			if firstErr == nil {
+				firstErr = res.err
+			}
+			continue
+		}
+		if res.url != "" {
+			espressoBuilderFirstCounter.Inc(1)
+		}
+		go logLateEspressoSubmissions(results, len(builders)-i, res.hash)
+		return res.hash, nil
+	}
+	return nil, firstErr
+}
+
+// logLateEspressoSubmissions reports the submissions completing after the first successful one
+func logLateEspressoSubmissions(results <-chan espressoSubmitResult, remaining int, hash *espressoTypes.TaggedBase64) {
+	for i := 0; i < remaining; i++ {
+		res := <-results
+		if res.err != nil {
+			if res.url != "" {
+				espressoBuilderSubmitFailedCounter.Inc(1)
+			}
+			log.Debug("late espresso submission failed", "url", res.url, "err", res.err)
+			continue
+		}
+		if res.hash != nil && hash != nil && res.hash.String() != hash.String() {
+			log.Warn("espresso builder returned another hash for the same transaction", "url", res.url, "expected", hash.String(), "got", res.hash.String())
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package arbnode
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	espressoTypes "github.com/EspressoSystems/espresso-sequencer-go/types"
+)
+
+type fakeEspressoBuilder struct {
+	delay time.Duration
+	err   error
+}
+
+func (b fakeEspressoBuilder) SubmitTransaction(ctx context.Context, tx espressoTypes.Transaction) (*espressoTypes.TaggedBase64, error) {
+	time.Sleep(b.delay)
+	if b.err != nil {
+		return nil, b.err
+	}
+	return espressoTransactionHash(&tx)
+}
+
+func TestEspressoSubmitToBuilders(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	// The query node is unreachable, so the transaction only lands through the builders
+	client, err := newEspressoMultiClient([]string{"http://127.0.0.1:1"})
+	Require(t, err)
+	tx := espressoTypes.Transaction{Payload: []byte("payload"), Namespace: 412346}
+	expected, err := espressoTransactionHash(&tx)
+	Require(t, err)
+
+	failed := espressoBuilderSubmitFailedCounter.Snapshot().Count()
+	client.builders = []espressoBuilder{
+		{url: "failing", client: fakeEspressoBuilder{err: errors.New("builder down")}},
+		{url: "slow", client: fakeEspressoBuilder{delay: 50 * time.Millisecond}},
+	}
+	hash, err := client.SubmitTransaction(ctx, tx)
+	Require(t, err)
+	if hash.String() != expected.String() {
+		Fail(t, "unexpected transaction hash", hash, expected)
+	}
+	if espressoBuilderSubmitFailedCounter.Snapshot().Count() < failed+1 {
+		Fail(t, "builder failure not counted")
+	}
+
+	client.builders = []espressoBuilder{{url: "failing", client: fakeEspressoBuilder{err: errors.New("builder down")}}}
+	if _, err := client.SubmitTransaction(ctx, tx); err == nil {
+		Fail(t, "submission succeeded without any endpoint accepting it")
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package arbnode
+
+import (
+	"context"
+	"math/big"
+	"time"
+
+	espressoTypes "github.com/EspressoSystems/espresso-sequencer-go/types"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+var (
+	espressoChainConfigChangedCounter = metrics.NewRegisteredCounter("arb/espresso/chainconfig/changed", nil)
+	espressoMaxBlockSizeGauge         = metrics.NewRegisteredGauge("arb/espresso/chainconfig/max_block_size", nil)
+	espressoBaseFeeGauge              = metrics.NewRegisteredGauge("arb/espresso/chainconfig/base_fee", nil)
+)
+
+const (
+	// How long to wait before checking again whether the chain config polling was enabled
+	espressoChainConfigDisabledInterval = time.Minute
+	// Room left in a HotShot block for the namespace table and the other transactions of the block
+	espressoBlockOverhead = 4 * 1024
+)
+
+// espressoChainParams are the parameters of the HotShot chain config that submissions depend on
+type espressoChainParams struct {
+	MaxBlockSize uint64
+	BaseFee      *big.Int
+}
+
+func newEspressoChainParams(maxBlockSize espressoTypes.U256Decimal, baseFee espressoTypes.U256Decimal) *espressoChainParams {
+	return &espressoChainParams{MaxBlockSize: maxBlockSize.Uint64(), BaseFee: new(big.Int).Set(&baseFee.Int)}
+}
+
+// espressoChainParamsFromHeader returns the chain config of a HotShot header, or nil if the header only
+// contains the commitment of the chain config
+func espressoChainParamsFromHeader(header espressoTypes.HeaderImpl) *espressoChainParams {
+	switch h := header.Header.(type) {
+	case *espressoTypes.Header0_1:
+		if h.ChainConfig != nil && h.ChainConfig.ChainConfig.Left != nil {
+			config := h.ChainConfig.ChainConfig.Left
+			return newEspressoChainParams(config.MaxBlockSize, config.BaseFee)
+		}
+	case *espressoTypes.Header0_2:
+		if h.ChainConfig != nil && h.ChainConfig.ChainConfig.Left != nil {
+			config := h.ChainConfig.ChainConfig.Left
+			return newEspressoChainParams(config.MaxBlockSize, config.BaseFee)
+		}
+	case *espressoTypes.Header0_3:
+		if h.ChainConfig != nil && h.ChainConfig.ChainConfig.Left != nil {
+			config := h.ChainConfig.ChainConfig.Left
+			return newEspressoChainParams(config.MaxBlockSize, config.BaseFee)
+		}
+	}
+	return nil
+}
+
+// espressoTransactionSizeLimit returns the maximum size of a submitted transaction: the configured maximum,
+// lowered to fit in a HotShot block if the chain config allows smaller blocks
+func (s *TransactionStreamer) espressoTransactionSizeLimit() uint64 {
+	limit := s.espressoMaxTransactionSize
+	if maxBlockSize := s.espressoMaxBlockSize.Load(); maxBlockSize > espressoBlockOverhead {
+		limit = min(limit, maxBlockSize-espressoBlockOverhead)
+	}
+	return limit
+}
+
+// pollEspressoChainConfig reads the chain config of the latest HotShot block, adapting the submission size
+// limit to it and alerting operators when it changes
+func (s *TransactionStreamer) pollEspressoChainConfig(ctx context.Context) time.Duration {
+	interval := s.config().Espresso.ChainConfigPollInterval
+	if interval == 0 {
+		return espressoChainConfigDisabledInterval
+	}
+	height, err := s.espressoClient.FetchLatestBlockHeight(ctx)
+	if err != nil {
+		log.Warn("failed to fetch the latest hotshot block height for the chain config", "err", err)
+		return interval
+	}
+	if height == 0 {
+		return interval
+	}
+	header, err := s.espressoClient.FetchHeaderByHeight(ctx, height-1)
+	if err != nil {
+		log.Warn("failed to fetch the hotshot header for the chain config", "height", height-1, "err", err)
+		return interval
+	}
+	params := espressoChainParamsFromHeader(header)
+	if params == nil {
+		log.Debug("hotshot header only contains the chain config commitment", "height", height-1)
+		return interval
+	}
+	s.updateEspressoChainParams(height-1, params)
+	return interval
+}
+
+func (s *TransactionStreamer) updateEspressoChainParams(height uint64, params *espressoChainParams) {
+	previous := s.espressoChainParams
+	s.espressoChainParams = params
+	s.espressoMaxBlockSize.Store(params.MaxBlockSize)
+	s.espressoBaseFee.Store(params.BaseFee)
+	// #nosec G115
+	espressoMaxBlockSizeGauge.Update(int64(params.MaxBlockSize))
+	if params.BaseFee.IsInt64() {
+		espressoBaseFeeGauge.Update(params.BaseFee.Int64())
+	}
+	if previous == nil {
+		log.Info("read hotshot chain config", "height", height, "maxBlockSize", params.MaxBlockSize, "baseFee", params.BaseFee, "transactionSizeLimit", s.espressoTransactionSizeLimit())
+		return
+	}
+	if previous.MaxBlockSize == params.MaxBlockSize && previous.BaseFee.Cmp(params.BaseFee) == 0 {
+		return
+	}
+	espressoChainConfigChangedCounter.Inc(1)
+	log.Warn(
+		"hotshot chain config changed, review the espresso submission limits",
+		"height", height,
+		"maxBlockSize", params.MaxBlockSize,
+		"previousMaxBlockSize", previous.MaxBlockSize,
+		"baseFee", params.BaseFee,
+		"previousBaseFee", previous.BaseFee,
+		"transactionSizeLimit", s.espressoTransactionSizeLimit(),
+	)
+}
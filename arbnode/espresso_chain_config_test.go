@@ -0,0 +1,63 @@
+package arbnode
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	espressoTypes "github.com/EspressoSystems/espresso-sequencer-go/types"
+)
+
+// chainConfigEspressoClient serves headers with a fixed chain config
+type chainConfigEspressoClient struct {
+	*countingEspressoClient
+	maxBlockSize int64
+	baseFee      int64
+}
+
+func (c *chainConfigEspressoClient) FetchLatestBlockHeight(ctx context.Context) (uint64, error) {
+	return 10, nil
+}
+
+func (c *chainConfigEspressoClient) FetchHeaderByHeight(ctx context.Context, blockHeight uint64) (espressoTypes.HeaderImpl, error) {
+	config := &espressoTypes.ChainConfig0_3{
+		MaxBlockSize: espressoTypes.U256Decimal{Int: *big.NewInt(c.maxBlockSize)},
+		BaseFee:      espressoTypes.U256Decimal{Int: *big.NewInt(c.baseFee)},
+	}
+	return espressoTypes.HeaderImpl{Header: &espressoTypes.Header0_3{
+		Height:      blockHeight,
+		ChainConfig: &espressoTypes.ResolvableChainConfig0_3{ChainConfig: espressoTypes.EitherChainConfig0_3{Left: config}},
+	}}, nil
+}
+
+func TestEspressoChainConfigPolling(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	config := TestTransactionStreamerConfig
+	config.Espresso.ChainConfigPollInterval = 1
+	client := &chainConfigEspressoClient{countingEspressoClient: &countingEspressoClient{}, maxBlockSize: 1024 * 1024, baseFee: 1}
+	streamer := &TransactionStreamer{
+		config:                     func() *TransactionStreamerConfig { return &config },
+		espressoClient:             client,
+		espressoMaxTransactionSize: 900 * 1024,
+	}
+
+	// The configured limit is kept until the chain config is read, and while blocks are larger
+	if limit := streamer.espressoTransactionSizeLimit(); limit != 900*1024 {
+		Fail(t, "unexpected size limit before reading the chain config", limit)
+	}
+	streamer.pollEspressoChainConfig(ctx)
+	if limit := streamer.espressoTransactionSizeLimit(); limit != 900*1024 {
+		Fail(t, "unexpected size limit with large blocks", limit)
+	}
+
+	client.maxBlockSize = 512 * 1024
+	client.baseFee = 2
+	streamer.pollEspressoChainConfig(ctx)
+	if limit := streamer.espressoTransactionSizeLimit(); limit != 512*1024-espressoBlockOverhead {
+		Fail(t, "size limit not lowered to fit the max block size", limit)
+	}
+	if streamer.espressoChainParams.BaseFee.Int64() != 2 {
+		Fail(t, "base fee change not detected", streamer.espressoChainParams.BaseFee)
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package arbnode
+
+import (
+	"encoding/json"
+
+	"github.com/offchainlabs/nitro/arbutil"
+)
+
+// EspressoChainParams are the espresso parameters of the chain, read from the "espresso" section of the chain
+// config stored in ArbOS. Unlike the node config, every node of the chain reads them the same way, anyone can read
+// them on-chain, and they're changed by the chain owner with ArbOwner.SetChainConfig.
+type EspressoChainParams struct {
+	// Compression of the messages submitted to espresso, "none", "brotli" or "zstd". It must only be activated once
+	// every node of the chain, including the nodes deriving it from espresso, runs a version that decompresses them.
+	Compression string `json:"compression,omitempty"`
+	// Position of the first message sequenced through espresso on a chain migrated from centralized sequencing,
+	// published before the migration so that every node can check it's configured with it
+	MigrationActivationPos *uint64 `json:"migrationActivationPos,omitempty"`
+}
+
+func parseEspressoChainParams(serializedChainConfig []byte) (*EspressoChainParams, error) {
+	var chainConfig struct {
+		Espresso EspressoChainParams `json:"espresso"`
+	}
+	if err := json.Unmarshal(serializedChainConfig, &chainConfig); err != nil {
+		return nil, err
+	}
+	return &chainConfig.Espresso, nil
+}
+
+// espressoChainParams returns the espresso parameters of the chain config at the head, empty without an execution
+func (s *TransactionStreamer) espressoChainParams() (*EspressoChainParams, error) {
+	if s.exec == nil {
+		return &EspressoChainParams{}, nil
+	}
+	serialized, err := s.exec.GetArbOSChainConfigJSONAtHeight(0)
+	if err != nil {
+		return nil, err
+	}
+	return parseEspressoChainParams(serialized)
+}
+
+// publishedEspressoActivationPos returns the migration activation position published in the chain config, or nil if
+// it isn't published
+func (s *TransactionStreamer) publishedEspressoActivationPos() (*arbutil.MessageIndex, error) {
+	params, err := s.espressoChainParams()
+	if err != nil {
+		return nil, err
+	}
+	if params.MigrationActivationPos == nil {
+		return nil, nil
+	}
+	pos := arbutil.MessageIndex(*params.MigrationActivationPos)
+	return &pos, nil
+}
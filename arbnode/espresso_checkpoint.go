@@ -0,0 +1,196 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package arbnode
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/rlp"
+
+	"github.com/offchainlabs/nitro/arbutil"
+	m "github.com/offchainlabs/nitro/broadcaster/message"
+)
+
+var (
+	espressoCheckpointBroadcastCounter = metrics.NewRegisteredCounter("arb/espresso/checkpoint/broadcast", nil)
+	espressoCheckpointVerifiedCounter  = metrics.NewRegisteredCounter("arb/espresso/checkpoint/verified", nil)
+	espressoCheckpointMismatchCounter  = metrics.NewRegisteredCounter("arb/espresso/checkpoint/mismatch", nil)
+)
+
+// How long to wait before checking again whether checkpoints were enabled
+const espressoCheckpointDisabledInterval = time.Minute
+
+// espressoJustificationHash returns the hash of the justification of the message at pos,
+// or the zero hash if it has none
+func (s *TransactionStreamer) espressoJustificationHash(pos arbutil.MessageIndex) (common.Hash, error) {
+	justification, err := s.GetEspressoJustification(pos)
+	if err != nil || justification == nil {
+		return common.Hash{}, err
+	}
+	data, err := rlp.EncodeToBytes(justification)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return crypto.Keccak256Hash(data), nil
+}
+
+// localCheckpoint returns the accumulator, block hash and justification hash of the node at the end of batch.
+// The bool is false if the messages of the batch weren't executed yet.
+func (s *TransactionStreamer) localCheckpoint(batch uint64) (arbutil.MessageIndex, *m.CheckpointMessage, bool, error) {
+	tracker := s.inboxReader.tracker
+	count, err := tracker.GetBatchMessageCount(batch)
+	if err != nil {
+		return 0, nil, false, err
+	}
+	acc, err := tracker.GetBatchAcc(batch)
+	if err != nil {
+		return 0, nil, false, err
+	}
+	processed, err := s.GetProcessedMessageCount()
+	if err != nil {
+		return 0, nil, false, err
+	}
+	if count == 0 || count > processed {
+		return count, nil, false, nil
+	}
+	result, err := s.ResultAtCount(count)
+	if err != nil {
+		return 0, nil, false, err
+	}
+	justificationHash, err := s.espressoJustificationHash(count - 1)
+	if err != nil {
+		return 0, nil, false, err
+	}
+	return count, &m.CheckpointMessage{
+		MessageCount:      count,
+		Accumulator:       acc,
+		BlockHash:         result.BlockHash,
+		JustificationHash: justificationHash,
+	}, true, nil
+}
+
+// AddBroadcastCheckpoint receives a checkpoint of the feed, whose signature was already verified. Checkpoints
+// beyond the local state are kept until the node catches up, superseding any earlier such checkpoint.
+func (s *TransactionStreamer) AddBroadcastCheckpoint(checkpoint *m.CheckpointMessage) error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	if s.config().Espresso.CheckpointInterval == 0 {
+		return nil
+	}
+	verified, err := s.verifyCheckpoint(checkpoint)
+	if err != nil {
+		return err
+	}
+	if !verified {
+		s.espressoCheckpointMutex.Lock()
+		s.espressoPendingCheckpoint = checkpoint
+		s.espressoCheckpointMutex.Unlock()
+	}
+	return nil
+}
+
+// verifyCheckpoint compares a peer's checkpoint with the local state, returning false if the local node
+// hasn't read or executed the batch ending at the checkpoint's message count yet
+func (s *TransactionStreamer) verifyCheckpoint(checkpoint *m.CheckpointMessage) (bool, error) {
+	if s.inboxReader == nil || checkpoint.MessageCount == 0 {
+		return true, nil
+	}
+	batch, found, err := s.inboxReader.tracker.FindInboxBatchContainingMessage(checkpoint.MessageCount - 1)
+	if err != nil || !found {
+		return false, err
+	}
+	count, local, executed, err := s.localCheckpoint(batch)
+	if err != nil {
+		return false, err
+	}
+	if count != checkpoint.MessageCount {
+		espressoCheckpointMismatchCounter.Inc(1)
+		log.Error("peer checkpoint doesn't end at a batch boundary", "messageCount", checkpoint.MessageCount, "batch", batch, "batchMessageCount", count)
+		return true, nil
+	}
+	if !executed {
+		return false, nil
+	}
+	if local.Accumulator != checkpoint.Accumulator || local.BlockHash != checkpoint.BlockHash {
+		espressoCheckpointMismatchCounter.Inc(1)
+		log.Error(
+			"peer checkpoint doesn't match local state",
+			"messageCount", checkpoint.MessageCount,
+			"accumulator", checkpoint.Accumulator,
+			"localAccumulator", local.Accumulator,
+			"blockHash", checkpoint.BlockHash,
+			"localBlockHash", local.BlockHash,
+		)
+		return true, nil
+	}
+	// Justifications may be missing on either side until they're backfilled, so only differing ones are compared
+	if local.JustificationHash != checkpoint.JustificationHash && local.JustificationHash != (common.Hash{}) && checkpoint.JustificationHash != (common.Hash{}) {
+		espressoCheckpointMismatchCounter.Inc(1)
+		log.Error("peer checkpoint justification doesn't match local justification", "messageCount", checkpoint.MessageCount, "justificationHash", checkpoint.JustificationHash, "localJustificationHash", local.JustificationHash)
+		return true, nil
+	}
+	espressoCheckpointVerifiedCounter.Inc(1)
+	return true, nil
+}
+
+// espressoCheckpoints broadcasts a signed checkpoint of the last executed batch on every iteration if the node
+// has a feed, and verifies the pending peer checkpoint once the local node caught up with it.
+func (s *TransactionStreamer) espressoCheckpoints(ctx context.Context) time.Duration {
+	interval := s.config().Espresso.CheckpointInterval
+	if interval == 0 || s.inboxReader == nil {
+		return espressoCheckpointDisabledInterval
+	}
+	if err := s.broadcastEspressoCheckpoint(); err != nil {
+		log.Warn("failed to broadcast checkpoint", "err", err)
+	}
+
+	s.espressoCheckpointMutex.Lock()
+	pending := s.espressoPendingCheckpoint
+	s.espressoCheckpointMutex.Unlock()
+	if pending == nil {
+		return interval
+	}
+	verified, err := s.verifyCheckpoint(pending)
+	if err != nil {
+		log.Warn("failed to verify peer checkpoint", "messageCount", pending.MessageCount, "err", err)
+		return interval
+	}
+	if verified {
+		s.espressoCheckpointMutex.Lock()
+		if s.espressoPendingCheckpoint == pending {
+			s.espressoPendingCheckpoint = nil
+		}
+		s.espressoCheckpointMutex.Unlock()
+	}
+	return interval
+}
+
+func (s *TransactionStreamer) broadcastEspressoCheckpoint() error {
+	if s.broadcastServer == nil {
+		return nil
+	}
+	batchCount, err := s.inboxReader.tracker.GetBatchCount()
+	if err != nil || batchCount == 0 {
+		return err
+	}
+	_, local, executed, err := s.localCheckpoint(batchCount - 1)
+	if err != nil || !executed || local.MessageCount <= s.espressoLastCheckpoint {
+		return err
+	}
+	checkpoint, err := s.broadcastServer.NewCheckpointMessage(local.MessageCount, local.Accumulator, local.BlockHash, local.JustificationHash)
+	if err != nil {
+		return fmt.Errorf("failed to sign checkpoint: %w", err)
+	}
+	s.broadcastServer.BroadcastCheckpoint(checkpoint)
+	s.espressoLastCheckpoint = local.MessageCount
+	espressoCheckpointBroadcastCounter.Inc(1)
+	return nil
+}
@@ -0,0 +1,140 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package arbnode
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+
+	espressocrypto "github.com/EspressoSystems/espresso-sequencer-go/espresso-crypto"
+	espressoTypes "github.com/EspressoSystems/espresso-sequencer-go/types"
+
+	"github.com/offchainlabs/nitro/arbutil"
+)
+
+// EspressoPayloadCodec converts messages to and from the payload of an Espresso transaction.
+// The pending, submitted and justified state of messages is tracked independently of the codec,
+// so chains embedding their own L2 message format only need to provide how messages are encoded
+// into and parsed from a transaction in the chain's namespace.
+type EspressoPayloadCodec interface {
+	// EncodeMessage returns the bytes to include in a payload for the message at pos.
+	// stored is the RLP encoded MessageWithMetadata as stored in the database.
+	EncodeMessage(pos arbutil.MessageIndex, stored []byte) ([]byte, error)
+	// BuildPayload encodes as many of the messages at positions as fit in maxSize into an unsigned
+	// payload, and returns it with the number of messages included.
+	BuildPayload(positions []arbutil.MessageIndex, fetcher func(arbutil.MessageIndex) ([]byte, error), maxSize uint64) ([]byte, int)
+	// SignPayload attaches the signature returned by signer to an unsigned payload
+	SignPayload(unsigned []byte, signer func([]byte) ([]byte, error)) ([]byte, error)
+	// ParsePayload returns the signature, and the positions and encoded messages of a signed payload
+	ParsePayload(payload []byte) (signature []byte, indices []uint64, messages [][]byte, err error)
+}
+
+// hotShotPayloadCodec is the default codec, which includes messages as stored in the database
+type hotShotPayloadCodec struct{}
+
+var _ EspressoPayloadCodec = hotShotPayloadCodec{}
+
+func (hotShotPayloadCodec) EncodeMessage(pos arbutil.MessageIndex, stored []byte) ([]byte, error) {
+	return stored, nil
+}
+
+func (hotShotPayloadCodec) BuildPayload(positions []arbutil.MessageIndex, fetcher func(arbutil.MessageIndex) ([]byte, error), maxSize uint64) ([]byte, int) {
+	return buildRawHotShotPayload(positions, fetcher, maxSize)
+}
+
+func (hotShotPayloadCodec) SignPayload(unsigned []byte, signer func([]byte) ([]byte, error)) ([]byte, error) {
+	return signHotShotPayload(unsigned, signer)
+}
+
+func (hotShotPayloadCodec) ParsePayload(payload []byte) ([]byte, []uint64, [][]byte, error) {
+	return ParseHotShotPayload(payload)
+}
+
+// SetEspressoPayloadCodec replaces the default codec of Espresso transactions, it must be called before Start
+func (s *TransactionStreamer) SetEspressoPayloadCodec(codec EspressoPayloadCodec) {
+	if s.Started() {
+		panic("trying to set espresso payload codec after start")
+	}
+	s.espressoCodec = codec
+}
+
+func (s *TransactionStreamer) espressoPayloadCodec() EspressoPayloadCodec {
+	if s.espressoCodec == nil {
+		return hotShotPayloadCodec{}
+	}
+	return s.espressoCodec
+}
+
+// EspressoJustificationCodec encodes the HotShot headers of one header version into justifications, and verifies their
+// block merkle proofs. A codec is registered per header version, so that the justifications of a new HotShot header
+// version can be supported without changing how they're fetched, stored and served.
+type EspressoJustificationCodec interface {
+	// EncodeHeader returns the header as included in a justification, which its block merkle proof is against
+	EncodeHeader(header espressoTypes.HeaderImpl) ([]byte, error)
+	// VerifyProof checks the block merkle proof of an encoded header against the block merkle root of the header at
+	// the root height, and the root the light client committed to at that height
+	VerifyProof(proof []byte, encodedHeader []byte, blockMerkleRoot espressoTypes.TaggedBase64, snapshotRoot espressoTypes.BlockMerkleRoot) bool
+}
+
+// hotShotJustificationCodec is the codec of the header versions HotShot shipped so far, which all prove the JSON
+// encoded header
+type hotShotJustificationCodec struct{}
+
+var _ EspressoJustificationCodec = hotShotJustificationCodec{}
+
+func (hotShotJustificationCodec) EncodeHeader(header espressoTypes.HeaderImpl) ([]byte, error) {
+	return json.Marshal(header)
+}
+
+func (hotShotJustificationCodec) VerifyProof(proof []byte, encodedHeader []byte, blockMerkleRoot espressoTypes.TaggedBase64, snapshotRoot espressoTypes.BlockMerkleRoot) bool {
+	return espressocrypto.VerifyMerkleProof(proof, encodedHeader, blockMerkleRoot, snapshotRoot)
+}
+
+// The HotShot header versions justifications are written and verified for by default. The header of each
+// justification is encoded and proven in its own version, so headers of several versions are handled side by side
+// while HotShot is being upgraded.
+var defaultEspressoJustificationCodecs = map[espressoTypes.Version]EspressoJustificationCodec{
+	{Major: 0, Minor: 1}: hotShotJustificationCodec{},
+	{Major: 0, Minor: 2}: hotShotJustificationCodec{},
+	{Major: 0, Minor: 3}: hotShotJustificationCodec{},
+}
+
+// SetEspressoJustificationCodec registers the codec of the justifications of HotShot headers of the given version,
+// replacing the default one if any, it must be called before Start
+func (s *TransactionStreamer) SetEspressoJustificationCodec(version espressoTypes.Version, codec EspressoJustificationCodec) {
+	if s.Started() {
+		panic("trying to set espresso justification codec after start")
+	}
+	if s.espressoJustificationCodecs == nil {
+		s.espressoJustificationCodecs = maps.Clone(defaultEspressoJustificationCodecs)
+	}
+	s.espressoJustificationCodecs[version] = codec
+}
+
+// espressoJustificationCodecsByVersion returns the registered justification codecs, keyed by header version
+func (s *TransactionStreamer) espressoJustificationCodecsByVersion() map[espressoTypes.Version]EspressoJustificationCodec {
+	if s.espressoJustificationCodecs == nil {
+		return defaultEspressoJustificationCodecs
+	}
+	return s.espressoJustificationCodecs
+}
+
+// espressoJustificationCodec returns the codec of the justifications of headers of the given version
+func (s *TransactionStreamer) espressoJustificationCodec(version espressoTypes.Version) (EspressoJustificationCodec, error) {
+	codec, ok := s.espressoJustificationCodecsByVersion()[version]
+	if !ok {
+		return nil, fmt.Errorf("%w %s", ErrEspressoHeaderVersionUnsupported, espressoHeaderVersionString(version))
+	}
+	return codec, nil
+}
+
+// espressoMessageBytes returns the bytes of the message at pos as included in an Espresso transaction
+func (s *TransactionStreamer) espressoMessageBytes(pos arbutil.MessageIndex) ([]byte, error) {
+	stored, err := s.db.Get(dbKey(messagePrefix, uint64(pos)))
+	if err != nil {
+		return nil, err
+	}
+	return s.espressoPayloadCodec().EncodeMessage(pos, stored)
+}
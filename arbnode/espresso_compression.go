@@ -0,0 +1,182 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package arbnode
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/andybalholm/brotli"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/klauspost/compress/zstd"
+	flag "github.com/spf13/pflag"
+)
+
+var (
+	espressoCompressionRatioGauge   = metrics.NewRegisteredGauge("arb/espresso/compression/ratio_percent", nil)
+	espressoCompressionSavedCounter = metrics.NewRegisteredCounter("arb/espresso/compression/saved_bytes", nil)
+)
+
+// Versions of the compression of an espresso payload, stored in its compression header
+const (
+	espressoCompressionBrotli byte = 1
+	espressoCompressionZstd   byte = 2
+)
+
+// espressoCompressionMagic starts the unsigned part of a compressed payload, followed by the compression version
+// and the uncompressed size. Like the chunk magic, it can't be mistaken for the position of a message.
+var espressoCompressionMagic = [INDEX_SIZE - 1]byte{0xfe, 'E', 'S', 'P', 'Z', 'I', 'P'}
+
+const espressoCompressionHeaderSize = INDEX_SIZE + LEN_SIZE
+
+// Upper bound on the uncompressed size of a payload, so that a malicious payload in the namespace can't exhaust memory
+const espressoMaxDecompressedSize = 64 * 1024 * 1024
+
+func (p *EspressoChainParams) compressionVersion() (byte, error) {
+	switch p.Compression {
+	case "", "none":
+		return 0, nil
+	case "brotli":
+		return espressoCompressionBrotli, nil
+	case "zstd":
+		return espressoCompressionZstd, nil
+	default:
+		return 0, fmt.Errorf("unknown espresso compression %q in the chain config", p.Compression)
+	}
+}
+
+type EspressoCompressionConfig struct {
+	Level int `koanf:"level" reload:"hot"`
+}
+
+var DefaultEspressoCompressionConfig = EspressoCompressionConfig{
+	Level: brotli.DefaultCompression,
+}
+
+func EspressoCompressionConfigAddOptions(prefix string, f *flag.FlagSet) {
+	f.Int(prefix+".level", DefaultEspressoCompressionConfig.Level, "compression level of the espresso payloads once the chain config activates their compression, brotli levels range from 0 to 11 and zstd levels from 1 to 22, beyond which they're capped")
+}
+
+func (c *EspressoCompressionConfig) Validate() error {
+	if c.Level < 0 || c.Level > 22 {
+		return fmt.Errorf("invalid espresso compression level %d", c.Level)
+	}
+	return nil
+}
+
+// compressionLevel returns the configured level, capped to the range of the compression version
+func (c *EspressoCompressionConfig) compressionLevel(version byte) int {
+	if version == espressoCompressionBrotli {
+		return min(c.Level, brotli.BestCompression)
+	}
+	return max(c.Level, 1)
+}
+
+func compressEspressoPayload(unsigned []byte, version byte, level int) ([]byte, error) {
+	header := make([]byte, espressoCompressionHeaderSize)
+	copy(header, espressoCompressionMagic[:])
+	header[len(espressoCompressionMagic)] = version
+	binary.BigEndian.PutUint64(header[INDEX_SIZE:], uint64(len(unsigned)))
+	switch version {
+	case espressoCompressionBrotli:
+		buf := bytes.NewBuffer(header)
+		writer := brotli.NewWriterLevel(buf, level)
+		if _, err := writer.Write(unsigned); err != nil {
+			return nil, err
+		}
+		if err := writer.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case espressoCompressionZstd:
+		encoder, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+		if err != nil {
+			return nil, err
+		}
+		defer encoder.Close()
+		return encoder.EncodeAll(unsigned, header), nil
+	default:
+		return nil, fmt.Errorf("unknown espresso compression version %d", version)
+	}
+}
+
+// decompressEspressoPayload returns the uncompressed unsigned part of a payload, or the payload itself if it isn't compressed
+func decompressEspressoPayload(unsigned []byte) ([]byte, error) {
+	if len(unsigned) < espressoCompressionHeaderSize || !bytes.Equal(unsigned[:len(espressoCompressionMagic)], espressoCompressionMagic[:]) {
+		return unsigned, nil
+	}
+	version := unsigned[len(espressoCompressionMagic)]
+	size := binary.BigEndian.Uint64(unsigned[INDEX_SIZE:espressoCompressionHeaderSize])
+	if size > espressoMaxDecompressedSize {
+		return nil, fmt.Errorf("compressed espresso payload of %d bytes exceeds the limit", size)
+	}
+	compressed := unsigned[espressoCompressionHeaderSize:]
+	var result []byte
+	switch version {
+	case espressoCompressionBrotli:
+		var err error
+		result, err = io.ReadAll(io.LimitReader(brotli.NewReader(bytes.NewReader(compressed)), int64(size)+1))
+		if err != nil {
+			return nil, err
+		}
+	case espressoCompressionZstd:
+		decoder, err := zstd.NewReader(nil, zstd.WithDecoderMaxMemory(size+1))
+		if err != nil {
+			return nil, err
+		}
+		defer decoder.Close()
+		result, err = decoder.DecodeAll(compressed, make([]byte, 0, size))
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown espresso compression version %d", version)
+	}
+	if uint64(len(result)) != size {
+		return nil, errors.New("decompressed espresso payload size mismatch")
+	}
+	return result, nil
+}
+
+// espressoCompressionVersion returns the compression of the espresso payloads activated by the chain config at the
+// head, 0 if it isn't
+func (s *TransactionStreamer) espressoCompressionVersion() (byte, error) {
+	params, err := s.espressoChainParams()
+	if err != nil {
+		return 0, err
+	}
+	return params.compressionVersion()
+}
+
+// compressEspressoPayload compresses an unsigned payload built by the default codec, if the chain config activates
+// it. The payload is only compressed if that makes it smaller, and the size limit it was built for still holds.
+// Payloads of custom codecs are left to the codec.
+func (s *TransactionStreamer) compressEspressoPayload(unsigned []byte) []byte {
+	if s.espressoCodec != nil {
+		return unsigned
+	}
+	version, err := s.espressoCompressionVersion()
+	if err != nil {
+		log.Warn("failed to read the espresso compression from the chain config, submitting the payload uncompressed", "err", err)
+		return unsigned
+	}
+	if version == 0 {
+		return unsigned
+	}
+	compressed, err := compressEspressoPayload(unsigned, version, s.config().Espresso.Compression.compressionLevel(version))
+	if err != nil {
+		log.Warn("failed to compress the espresso payload, submitting it uncompressed", "version", version, "err", err)
+		return unsigned
+	}
+	if len(compressed) >= len(unsigned) {
+		return unsigned
+	}
+	espressoCompressionRatioGauge.Update(int64(len(compressed) * 100 / len(unsigned)))
+	espressoCompressionSavedCounter.Inc(int64(len(unsigned) - len(compressed)))
+	return compressed
+}
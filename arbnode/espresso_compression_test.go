@@ -0,0 +1,81 @@
+package arbnode
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/offchainlabs/nitro/arbutil"
+)
+
+func TestEspressoPayloadCompression(t *testing.T) {
+	messages := map[arbutil.MessageIndex][]byte{
+		4: bytes.Repeat([]byte("compressible "), 100),
+		5: bytes.Repeat([]byte("message "), 50),
+	}
+	fetcher := func(pos arbutil.MessageIndex) ([]byte, error) { return messages[pos], nil }
+	unsigned, count := buildRawHotShotPayload([]arbutil.MessageIndex{4, 5}, fetcher, 1<<20)
+	if count != 2 {
+		Fail(t, "unexpected message count", count)
+	}
+	signer := func([]byte) ([]byte, error) { return []byte("quote"), nil }
+	for _, version := range []byte{espressoCompressionBrotli, espressoCompressionZstd} {
+		compressed, err := compressEspressoPayload(unsigned, version, 6)
+		Require(t, err)
+		if len(compressed) >= len(unsigned) {
+			Fail(t, "payload not compressed", version, len(compressed), len(unsigned))
+		}
+		signed, err := signHotShotPayload(compressed, signer)
+		Require(t, err)
+		signature, indices, parsed, err := ParseHotShotPayload(signed)
+		Require(t, err)
+		if !bytes.Equal(signature, []byte("quote")) || len(indices) != 2 || indices[0] != 4 || indices[1] != 5 {
+			Fail(t, "unexpected parsed payload", version, indices)
+		}
+		for i, index := range indices {
+			if !bytes.Equal(parsed[i], messages[arbutil.MessageIndex(index)]) {
+				Fail(t, "decompressed message mismatch", version, index)
+			}
+		}
+
+		// A lying uncompressed size is rejected
+		compressed[INDEX_SIZE+LEN_SIZE-1]++
+		signed, err = signHotShotPayload(compressed, signer)
+		Require(t, err)
+		if _, _, _, err := ParseHotShotPayload(signed); err == nil {
+			Fail(t, "payload with a wrong uncompressed size parsed", version)
+		}
+	}
+}
+
+func TestEspressoChainParamsCompression(t *testing.T) {
+	for serialized, expected := range map[string]byte{
+		`{"chainId":412346}`:                                                 0,
+		`{"chainId":412346,"espresso":{}}`:                                   0,
+		`{"chainId":412346,"espresso":{"compression":"none"}}`:               0,
+		`{"chainId":412346,"espresso":{"compression":"brotli"}}`:             espressoCompressionBrotli,
+		`{"chainId":412346,"espresso":{"compression":"zstd"}}`:               espressoCompressionZstd,
+		`{"chainId":412346,"arbitrum":{},"espresso":{"compression":"zstd"}}`: espressoCompressionZstd,
+	} {
+		params, err := parseEspressoChainParams([]byte(serialized))
+		Require(t, err)
+		version, err := params.compressionVersion()
+		Require(t, err)
+		if version != expected {
+			Fail(t, "unexpected compression version", serialized, version, expected)
+		}
+	}
+	params, err := parseEspressoChainParams([]byte(`{"espresso":{"compression":"lz4"}}`))
+	Require(t, err)
+	if _, err := params.compressionVersion(); err == nil {
+		Fail(t, "unknown compression accepted")
+	}
+
+	config := EspressoCompressionConfig{Level: 22}
+	if level := config.compressionLevel(espressoCompressionBrotli); level != 11 {
+		Fail(t, "brotli level not capped", level)
+	}
+	config.Level = 0
+	if level := config.compressionLevel(espressoCompressionZstd); level != 1 {
+		Fail(t, "zstd level not capped", level)
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package arbnode
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/rlp"
+
+	"github.com/offchainlabs/nitro/arbutil"
+	"github.com/offchainlabs/nitro/util/dbutil"
+)
+
+var (
+	espressoSubmissionFailureCounter   = metrics.NewRegisteredCounter("arb/espresso/submission/failures", nil)
+	espressoDeadLetterCounter          = metrics.NewRegisteredCounter("arb/espresso/deadletter/moved", nil)
+	espressoDeadLetterRequeuedCounter  = metrics.NewRegisteredCounter("arb/espresso/deadletter/requeued", nil)
+	espressoDeadLetterMoveErrorCounter = metrics.NewRegisteredCounter("arb/espresso/deadletter/errors", nil)
+)
+
+// A message whose transaction can't be built, e.g. because it can't be read or encoded, stays at the head of the
+// pending queue and is retried forever, holding back every message queued after it. Once the head failed the
+// configured number of consecutive times it's moved to the dead-letter table, and the queue continues past it.
+// Dead-lettered messages aren't confirmed, so they still go through the deadline fallback, and the justified
+// watermark stops before them until they're requeued.
+
+// EspressoDeadLetter is stored for a message moved out of the pending queue after repeatedly failing submission
+type EspressoDeadLetter struct {
+	Pos arbutil.MessageIndex `json:"pos"`
+	// Consecutive failures when the message was moved
+	Failures uint64 `json:"failures"`
+	// The last submission error
+	Error string `json:"error"`
+	// Unix time the message was moved
+	DeadLetteredAt uint64 `json:"deadLetteredAt"`
+}
+
+// resetEspressoSubmissionFailures forgets the failures of the queue head once a transaction was built, it's only
+// called from the espresso loop
+func (s *TransactionStreamer) resetEspressoSubmissionFailures() {
+	s.espressoHeadFailures = 0
+}
+
+// recordEspressoSubmissionFailure counts a failure to build the transaction of the message at the head of the
+// pending queue, and moves the message to the dead-letter table once it failed more than the configured number of
+// consecutive times. It's only called from the espresso loop. Returns true if the message was moved.
+func (s *TransactionStreamer) recordEspressoSubmissionFailure(pos arbutil.MessageIndex, err error) bool {
+	espressoSubmissionFailureCounter.Inc(1)
+	if s.espressoHeadFailures == 0 || s.espressoHeadFailurePos != pos {
+		s.espressoHeadFailurePos = pos
+		s.espressoHeadFailures = 0
+	}
+	s.espressoHeadFailures++
+	maxFailures := s.config().Espresso.MaxSubmissionFailures
+	if maxFailures == 0 || s.espressoHeadFailures <= maxFailures {
+		return false
+	}
+	if moveErr := s.deadLetterEspressoPosition(pos, s.espressoHeadFailures, err); moveErr != nil {
+		espressoDeadLetterMoveErrorCounter.Inc(1)
+		log.Error("failed to move the message to the espresso dead-letter table", "pos", pos, "err", moveErr)
+		return false
+	}
+	s.espressoHeadFailures = 0
+	return true
+}
+
+// deadLetterEspressoPosition moves the pending message at pos to the dead-letter table
+func (s *TransactionStreamer) deadLetterEspressoPosition(pos arbutil.MessageIndex, failures uint64, failure error) error {
+	s.espressoTxnsStateInsertionMutex.Lock()
+	defer s.espressoTxnsStateInsertionMutex.Unlock()
+	pendingPos, err := s.getEspressoPendingTxnsPos()
+	if err != nil {
+		return err
+	}
+	index := slices.Index(pendingPos, pos)
+	if index < 0 {
+		return fmt.Errorf("message %d isn't pending", pos)
+	}
+	deadLetter := EspressoDeadLetter{
+		Pos:      pos,
+		Failures: failures,
+		Error:    failure.Error(),
+		// #nosec G115
+		DeadLetteredAt: uint64(time.Now().Unix()),
+	}
+	data, err := rlp.EncodeToBytes(&deadLetter)
+	if err != nil {
+		return err
+	}
+	batch := s.db.NewBatch()
+	if err := s.setEspressoPendingTxnsPos(batch, slices.Delete(pendingPos, index, index+1)); err != nil {
+		return err
+	}
+	if err := s.setEspressoSubmissionStatus(batch, []arbutil.MessageIndex{pos}, EspressoSubmissionDeadLettered, nil); err != nil {
+		return err
+	}
+	if err := batch.Put(dbKey(espressoDeadLetterPrefix, uint64(pos)), data); err != nil {
+		return err
+	}
+	if err := batch.Write(); err != nil {
+		return err
+	}
+	espressoDeadLetterCounter.Inc(1)
+	log.Error("moved the espresso message to the dead-letter table after repeated submission failures", "pos", pos, "failures", failures, "err", failure)
+	return nil
+}
+
+// getEspressoDeadLetter returns the dead-letter entry of the message at pos, or nil if it isn't dead-lettered
+func (s *TransactionStreamer) getEspressoDeadLetter(pos arbutil.MessageIndex) (*EspressoDeadLetter, error) {
+	data, err := s.db.Get(dbKey(espressoDeadLetterPrefix, uint64(pos)))
+	if err != nil {
+		if dbutil.IsErrNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var deadLetter EspressoDeadLetter
+	if err := rlp.DecodeBytes(data, &deadLetter); err != nil {
+		return nil, err
+	}
+	return &deadLetter, nil
+}
+
+// GetEspressoDeadLetters returns the messages in the dead-letter table, in increasing position
+func (s *TransactionStreamer) GetEspressoDeadLetters() ([]EspressoDeadLetter, error) {
+	iter := s.db.NewIterator(espressoDeadLetterPrefix, nil)
+	defer iter.Release()
+	deadLetters := []EspressoDeadLetter{}
+	for iter.Next() {
+		var deadLetter EspressoDeadLetter
+		if err := rlp.DecodeBytes(iter.Value(), &deadLetter); err != nil {
+			return nil, err
+		}
+		deadLetters = append(deadLetters, deadLetter)
+	}
+	return deadLetters, iter.Error()
+}
+
+// RequeueEspressoDeadLetter queues the dead-lettered message at pos for espresso submission again, e.g. once the
+// cause of its failures was fixed
+func (s *TransactionStreamer) RequeueEspressoDeadLetter(pos arbutil.MessageIndex) error {
+	deadLetter, err := s.getEspressoDeadLetter(pos)
+	if err != nil {
+		return err
+	}
+	if deadLetter == nil {
+		return fmt.Errorf("message %d isn't dead-lettered", pos)
+	}
+	return s.RequeueEspressoPosition(pos)
+}
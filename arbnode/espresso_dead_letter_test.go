@@ -0,0 +1,62 @@
+package arbnode
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/offchainlabs/nitro/arbutil"
+)
+
+func TestEspressoDeadLetter(t *testing.T) {
+	streamer := newTestStreamer(t, nil, func(c *TransactionStreamerConfig) { c.Espresso.MaxSubmissionFailures = 2 })
+	Require(t, streamer.AddMessages(0, true, testChainMessages(0, 4)))
+	batch := streamer.db.NewBatch()
+	Require(t, streamer.setEspressoPendingTxnsPos(batch, []arbutil.MessageIndex{1, 2, 3}))
+	Require(t, batch.Write())
+	expectPending := func(expected ...arbutil.MessageIndex) {
+		t.Helper()
+		pending, err := streamer.getEspressoPendingTxnsPos()
+		Require(t, err)
+		if !reflect.DeepEqual(pending, expected) {
+			Fail(t, "unexpected pending positions", pending, "expected", expected)
+		}
+	}
+
+	failure := errors.New("unparsable message")
+	for i := 0; i < 2; i++ {
+		if streamer.recordEspressoSubmissionFailure(1, failure) {
+			Fail(t, "message moved before exceeding the max failures")
+		}
+	}
+	// A transaction built in between resets the failures
+	streamer.resetEspressoSubmissionFailures()
+	if streamer.recordEspressoSubmissionFailure(1, failure) || streamer.recordEspressoSubmissionFailure(1, failure) {
+		Fail(t, "failures not reset")
+	}
+	if !streamer.recordEspressoSubmissionFailure(1, failure) {
+		Fail(t, "message not moved after exceeding the max failures")
+	}
+	expectPending(2, 3)
+	deadLetters, err := streamer.GetEspressoDeadLetters()
+	Require(t, err)
+	if len(deadLetters) != 1 || deadLetters[0].Pos != 1 || deadLetters[0].Failures != 3 || deadLetters[0].Error != failure.Error() {
+		Fail(t, "unexpected dead letters", deadLetters)
+	}
+	record, err := streamer.GetEspressoSubmissionRecord(1)
+	Require(t, err)
+	if record == nil || record.Status != EspressoSubmissionDeadLettered {
+		Fail(t, "dead-lettered message not marked", record)
+	}
+
+	if err := streamer.RequeueEspressoDeadLetter(2); err == nil {
+		Fail(t, "requeued a message that isn't dead-lettered")
+	}
+	Require(t, streamer.RequeueEspressoDeadLetter(1))
+	expectPending(1, 2, 3)
+	deadLetters, err = streamer.GetEspressoDeadLetters()
+	Require(t, err)
+	if len(deadLetters) != 0 {
+		Fail(t, "requeued message still dead-lettered", deadLetters)
+	}
+}
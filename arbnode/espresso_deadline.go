@@ -0,0 +1,260 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package arbnode
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/rlp"
+
+	"github.com/offchainlabs/nitro/arbutil"
+	"github.com/offchainlabs/nitro/util/dbutil"
+)
+
+var espressoDeadlineExpiredCounter = metrics.NewRegisteredCounter("arb/espresso/deadline/expired", nil)
+
+const (
+	// The message is posted without espresso verification, as if sequenced through the escape hatch
+	EspressoDeadlineFallbackEscapeHatch = "escape-hatch"
+	// The message is no longer submitted to espresso, and subscribers are notified
+	EspressoDeadlineFallbackDrop = "drop"
+
+	espressoDeadlineCheckInterval = time.Second
+)
+
+// espressoDeadlineFallback returns the configured deadline fallback, drop if it isn't set
+func espressoDeadlineFallback(config *EspressoStreamerConfig) (string, error) {
+	switch config.DeadlineFallback {
+	case "":
+		return EspressoDeadlineFallbackDrop, nil
+	case EspressoDeadlineFallbackEscapeHatch, EspressoDeadlineFallbackDrop:
+		return config.DeadlineFallback, nil
+	default:
+		return "", fmt.Errorf("invalid espresso deadline fallback %q, expected %q or %q", config.DeadlineFallback, EspressoDeadlineFallbackEscapeHatch, EspressoDeadlineFallbackDrop)
+	}
+}
+
+// EspressoDeadlineEvent is sent when a message wasn't included in a HotShot block before its deadline
+type EspressoDeadlineEvent struct {
+	Pos      arbutil.MessageIndex `json:"pos"`
+	Deadline uint64               `json:"deadline"`
+	Fallback string               `json:"fallback"`
+}
+
+// SetEspressoSubmissionDeadline tags the message at pos with the time by which it must be included in a
+// HotShot block. Past the deadline the message isn't submitted anymore, and goes through the configured
+// fallback instead. Used by the sequencer for time-sensitive payloads, such as oracle updates.
+func (s *TransactionStreamer) SetEspressoSubmissionDeadline(pos arbutil.MessageIndex, deadline time.Time) error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	return s.setEspressoDeadline(s.db, pos, deadline)
+}
+
+func (s *TransactionStreamer) setEspressoDeadline(batch ethdb.KeyValueWriter, pos arbutil.MessageIndex, deadline time.Time) error {
+	// #nosec G115
+	data, err := rlp.EncodeToBytes(uint64(deadline.Unix()))
+	if err != nil {
+		return err
+	}
+	return batch.Put(dbKey(espressoDeadlinePrefix, uint64(pos)), data)
+}
+
+// GetEspressoSubmissionDeadline returns the deadline of the message at pos, or nil if it has none
+func (s *TransactionStreamer) GetEspressoSubmissionDeadline(pos arbutil.MessageIndex) (*time.Time, error) {
+	data, err := s.db.Get(dbKey(espressoDeadlinePrefix, uint64(pos)))
+	if err != nil {
+		if dbutil.IsErrNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var deadline uint64
+	if err := rlp.DecodeBytes(data, &deadline); err != nil {
+		return nil, err
+	}
+	// #nosec G115
+	res := time.Unix(int64(deadline), 0)
+	return &res, nil
+}
+
+// setDefaultEspressoDeadline tags a newly queued message with the configured default deadline,
+// unless the sequencer already set one
+func (s *TransactionStreamer) setDefaultEspressoDeadline(batch ethdb.KeyValueWriter, pos arbutil.MessageIndex) error {
+	deadline := s.config().Espresso.SubmissionDeadline
+	// In shadow mode the messages are posted regardless of espresso, there's no fallback to apply
+	if deadline == 0 || s.espressoShadowMode() {
+		return nil
+	}
+	has, err := s.db.Has(dbKey(espressoDeadlinePrefix, uint64(pos)))
+	if err != nil || has {
+		return err
+	}
+	return s.setEspressoDeadline(batch, pos, time.Now().Add(deadline))
+}
+
+func (s *TransactionStreamer) deleteEspressoDeadlines(batch ethdb.KeyValueWriter, positions []arbutil.MessageIndex) error {
+	for _, pos := range positions {
+		if err := batch.Delete(dbKey(espressoDeadlinePrefix, uint64(pos))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SubscribeEspressoDeadlines registers a channel that receives an event for every message that missed its
+// espresso submission deadline. Sending blocks until all subscribers have received the event.
+func (s *TransactionStreamer) SubscribeEspressoDeadlines(ch chan<- EspressoDeadlineEvent) event.Subscription {
+	return s.espressoDeadlineFeed.Subscribe(ch)
+}
+
+// expireEspressoDeadlines routes the messages whose deadline has passed without HotShot inclusion through
+// the fallback, taking them out of the pending queue. Messages of the in-flight transaction can't be taken
+// out of it: with the escape hatch fallback they're allowed to be posted without verification right away,
+// and otherwise they're expired if the transaction is requeued. It runs separately from the espresso submission loop, as the deadline matters most while
+// HotShot is unreachable.
+func (s *TransactionStreamer) expireEspressoDeadlines(ctx context.Context) time.Duration {
+	if s.espressoShadowMode() {
+		return espressoDeadlineCheckInterval
+	}
+	events, err := s.expireEspressoDeadlinesAt(time.Now())
+	if err != nil {
+		log.Error("failed to expire espresso submission deadlines", "err", err)
+	}
+	for _, ev := range events {
+		log.Warn("message not included in a hotshot block before its deadline", "pos", ev.Pos, "deadline", ev.Deadline, "fallback", ev.Fallback)
+		s.espressoDeadlineFeed.Send(ev)
+	}
+	return espressoDeadlineCheckInterval
+}
+
+func (s *TransactionStreamer) expiredEspressoDeadlines(now time.Time) (map[arbutil.MessageIndex]uint64, error) {
+	expired := make(map[arbutil.MessageIndex]uint64)
+	iter := s.db.NewIterator(espressoDeadlinePrefix, nil)
+	defer iter.Release()
+	for iter.Next() {
+		var deadline uint64
+		if err := rlp.DecodeBytes(iter.Value(), &deadline); err != nil {
+			return nil, err
+		}
+		// #nosec G115
+		if deadline <= uint64(now.Unix()) {
+			pos := arbutil.MessageIndex(binary.BigEndian.Uint64(bytes.TrimPrefix(iter.Key(), espressoDeadlinePrefix)))
+			expired[pos] = deadline
+		}
+	}
+	return expired, iter.Error()
+}
+
+// expireEspressoDeadlinesAt applies the fallback to the messages whose deadline passed before now,
+// and returns the events to send once the espresso state is unlocked
+func (s *TransactionStreamer) expireEspressoDeadlinesAt(now time.Time) ([]EspressoDeadlineEvent, error) {
+	fallback, err := espressoDeadlineFallback(&s.config().Espresso)
+	if err != nil {
+		return nil, err
+	}
+	expired, err := s.expiredEspressoDeadlines(now)
+	if err != nil || len(expired) == 0 {
+		return nil, err
+	}
+
+	s.espressoTxnsStateInsertionMutex.Lock()
+	defer s.espressoTxnsStateInsertionMutex.Unlock()
+	pendingPos, err := s.getEspressoPendingTxnsPos()
+	if err != nil {
+		return nil, err
+	}
+	submittedPos, err := s.getEspressoSubmittedPos()
+	if err != nil {
+		return nil, err
+	}
+	inFlight := make(map[arbutil.MessageIndex]bool, len(submittedPos))
+	for _, pos := range submittedPos {
+		inFlight[pos] = true
+	}
+	positions := make([]arbutil.MessageIndex, 0, len(expired))
+	for pos := range expired {
+		positions = append(positions, pos)
+	}
+	sort.Slice(positions, func(i, j int) bool { return positions[i] < positions[j] })
+
+	// The escape hatch records are written along with the rest of the expiry
+	batch := s.newEspressoStateBatch()
+	escapeHatchRecorded := false
+	recordEscapeHatch := func(pos arbutil.MessageIndex) error {
+		recorded, err := s.writeEscapeHatchRecord(batch, pos)
+		escapeHatchRecorded = escapeHatchRecorded || recorded
+		return err
+	}
+	var events []EspressoDeadlineEvent
+	var expiredPos, done []arbutil.MessageIndex
+	for _, pos := range positions {
+		if inFlight[pos] {
+			// Kept until the transaction is finalized or requeued
+			if fallback == EspressoDeadlineFallbackEscapeHatch {
+				if err := recordEscapeHatch(pos); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+		done = append(done, pos)
+		record, err := s.GetEspressoSubmissionRecord(pos)
+		if err != nil {
+			return nil, err
+		}
+		if record != nil && (record.Status == EspressoSubmissionFinalized || record.Status == EspressoSubmissionExpired) {
+			continue
+		}
+		// The message is queued, or the batch poster hasn't queued it yet
+		if fallback == EspressoDeadlineFallbackEscapeHatch {
+			if err := recordEscapeHatch(pos); err != nil {
+				return nil, err
+			}
+		}
+		expiredPos = append(expiredPos, pos)
+		events = append(events, EspressoDeadlineEvent{Pos: pos, Deadline: expired[pos], Fallback: fallback})
+	}
+	if err := s.deleteEspressoDeadlines(batch, done); err != nil {
+		return nil, err
+	}
+	if len(expiredPos) > 0 {
+		isExpired := make(map[arbutil.MessageIndex]bool, len(expiredPos))
+		for _, pos := range expiredPos {
+			isExpired[pos] = true
+		}
+		remaining := make([]arbutil.MessageIndex, 0, len(pendingPos))
+		for _, pos := range pendingPos {
+			if !isExpired[pos] {
+				remaining = append(remaining, pos)
+			}
+		}
+		if len(remaining) != len(pendingPos) {
+			if err := s.setEspressoPendingTxnsPos(batch, remaining); err != nil {
+				return nil, err
+			}
+		}
+		if err := s.setEspressoSubmissionStatus(batch, expiredPos, EspressoSubmissionExpired, nil); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.writeEspressoStateBatch(batch, espressoIntentExpire); err != nil {
+		return nil, err
+	}
+	if escapeHatchRecorded {
+		s.updateEspressoJustifiedWatermark()
+	}
+	// #nosec G115
+	espressoDeadlineExpiredCounter.Inc(int64(len(events)))
+	return events, nil
+}
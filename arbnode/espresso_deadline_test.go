@@ -0,0 +1,77 @@
+package arbnode
+
+import (
+	"testing"
+	"time"
+
+	"github.com/offchainlabs/nitro/arbos/arbostypes"
+	"github.com/offchainlabs/nitro/arbutil"
+)
+
+func TestExpireEspressoDeadlines(t *testing.T) {
+	streamer := newTestStreamer(t, nil, func(c *TransactionStreamerConfig) {
+		c.Espresso.DeadlineFallback = EspressoDeadlineFallbackEscapeHatch
+	})
+	var messages []arbostypes.MessageWithMetadata
+	for i := uint64(0); i < 4; i++ {
+		messages = append(messages, arbostypes.MessageWithMetadata{
+			Message: &arbostypes.L1IncomingMessage{Header: &arbostypes.L1IncomingMessageHeader{Timestamp: i}},
+		})
+	}
+	Require(t, streamer.AddMessages(0, true, messages))
+
+	now := time.Now()
+	for pos := arbutil.MessageIndex(0); pos < 3; pos++ {
+		Require(t, streamer.SubmitEspressoTransactionPos(pos, streamer.db.NewBatch()))
+	}
+	// Message 0 is in flight, 1 and 2 are queued and 3 isn't queued yet
+	batch := streamer.db.NewBatch()
+	Require(t, streamer.setEspressoSubmittedPos(batch, []arbutil.MessageIndex{0}))
+	Require(t, streamer.setEspressoPendingTxnsPos(batch, []arbutil.MessageIndex{1, 2}))
+	Require(t, batch.Write())
+	Require(t, streamer.SetEspressoSubmissionDeadline(0, now.Add(-time.Second)))
+	Require(t, streamer.SetEspressoSubmissionDeadline(1, now.Add(-time.Second)))
+	Require(t, streamer.SetEspressoSubmissionDeadline(2, now.Add(time.Hour)))
+	Require(t, streamer.SetEspressoSubmissionDeadline(3, now.Add(-time.Second)))
+
+	events, err := streamer.expireEspressoDeadlinesAt(now)
+	Require(t, err)
+	if len(events) != 2 || events[0].Pos != 1 || events[1].Pos != 3 {
+		Fail(t, "unexpected deadline events", events)
+	}
+	pending, err := streamer.getEspressoPendingTxnsPos()
+	Require(t, err)
+	if len(pending) != 1 || pending[0] != 2 {
+		Fail(t, "expired message still queued", pending)
+	}
+	for _, pos := range []arbutil.MessageIndex{0, 1, 3} {
+		escaped, err := streamer.IsEscapeHatchMessage(pos)
+		Require(t, err)
+		if !escaped {
+			Fail(t, "expired message not sent through the escape hatch", pos)
+		}
+	}
+	record, err := streamer.GetEspressoSubmissionRecord(1)
+	Require(t, err)
+	if record.Status != EspressoSubmissionExpired {
+		Fail(t, "unexpected status of an expired message", record.Status)
+	}
+	// The in-flight message keeps its deadline until its transaction is finalized or requeued
+	deadline, err := streamer.GetEspressoSubmissionDeadline(0)
+	Require(t, err)
+	if deadline == nil {
+		Fail(t, "deadline of the in-flight message dropped")
+	}
+	notSubmitted, err := streamer.HasNotSubmitted(3)
+	Require(t, err)
+	if notSubmitted {
+		Fail(t, "expired message would be queued again")
+	}
+
+	// Expiring again doesn't send duplicate events
+	events, err = streamer.expireEspressoDeadlinesAt(now)
+	Require(t, err)
+	if len(events) != 0 {
+		Fail(t, "duplicate deadline events", events)
+	}
+}
@@ -0,0 +1,327 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package arbnode
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	espressoTypes "github.com/EspressoSystems/espresso-sequencer-go/types"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/rlp"
+	flag "github.com/spf13/pflag"
+
+	"github.com/offchainlabs/nitro/arbos/arbostypes"
+	"github.com/offchainlabs/nitro/arbutil"
+	"github.com/offchainlabs/nitro/util/dbutil"
+)
+
+var (
+	ErrEspressoDerivation = errors.New("the chain is derived from espresso, messages from the feed or the sequencer aren't accepted")
+
+	espressoDerivedMessagesCounter   = metrics.NewRegisteredCounter("arb/espresso/derivation/messages", nil)
+	espressoDerivationSkippedCounter = metrics.NewRegisteredCounter("arb/espresso/derivation/skipped", nil)
+	espressoDerivationHeightGauge    = metrics.NewRegisteredGauge("arb/espresso/derivation/height", nil)
+)
+
+// EspressoDerivationConfig configures reading the chain from the HotShot namespace instead of the feed and the
+// sequencer. The node follows the sovereign sequencer without trusting anything but HotShot's light client and the
+// attestation of the payloads.
+type EspressoDerivationConfig struct {
+	Enable      bool          `koanf:"enable"`
+	StartHeight uint64        `koanf:"start-height"`
+	Interval    time.Duration `koanf:"interval" reload:"hot"`
+	MaxBlocks   uint64        `koanf:"max-blocks" reload:"hot"`
+}
+
+var DefaultEspressoDerivationConfig = EspressoDerivationConfig{
+	Enable:      false,
+	StartHeight: 0,
+	Interval:    time.Second,
+	MaxBlocks:   100,
+}
+
+func EspressoDerivationConfigAddOptions(prefix string, f *flag.FlagSet) {
+	f.Bool(prefix+".enable", DefaultEspressoDerivationConfig.Enable, "derive the chain from the transactions in the chain's hotshot namespace, verifying their namespace proofs, attestations and justifications, instead of accepting messages from the feed or the sequencer; requires an espresso attestation scheme")
+	f.Uint64(prefix+".start-height", DefaultEspressoDerivationConfig.StartHeight, "hotshot block height the derivation starts from the first time it's enabled, it continues from where it left off afterwards")
+	f.Duration(prefix+".interval", DefaultEspressoDerivationConfig.Interval, "interval between polls of hotshot for new blocks once the derivation caught up")
+	f.Uint64(prefix+".max-blocks", DefaultEspressoDerivationConfig.MaxBlocks, "maximum number of hotshot blocks derived in one iteration")
+}
+
+func (c *EspressoDerivationConfig) Validate() error {
+	if c.Enable && (c.Interval <= 0 || c.MaxBlocks == 0) {
+		return errors.New("espresso derivation interval and max-blocks must be positive")
+	}
+	return nil
+}
+
+// espressoDerivationState is where the derivation continues from
+type espressoDerivationState struct {
+	Height uint64
+	// Reassembly of the chunked message being derived, Assembled is empty if there's none
+	ChunkPos  uint64
+	ChunkHash common.Hash
+	Assembled []byte
+}
+
+func (s *TransactionStreamer) getEspressoDerivationState() (*espressoDerivationState, error) {
+	data, err := s.db.Get(espressoDerivationStateKey)
+	if err != nil {
+		if dbutil.IsErrNotFound(err) {
+			return &espressoDerivationState{Height: s.config().Espresso.Derivation.StartHeight}, nil
+		}
+		return nil, err
+	}
+	var state espressoDerivationState
+	if err := rlp.DecodeBytes(data, &state); err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+func setEspressoDerivationState(batch ethdb.KeyValueWriter, state *espressoDerivationState) error {
+	data, err := rlp.EncodeToBytes(state)
+	if err != nil {
+		return err
+	}
+	return batch.Put(espressoDerivationStateKey, data)
+}
+
+// deriveFromEspresso appends the messages of the HotShot blocks finalized since the last iteration, in order
+func (s *TransactionStreamer) deriveFromEspresso(ctx context.Context) time.Duration {
+	config := s.config().Espresso.Derivation
+	state, err := s.getEspressoDerivationState()
+	if err != nil {
+		log.Error("espresso derivation failed to read its state", "err", err)
+		return config.Interval
+	}
+	latest, err := s.espressoClient.FetchLatestBlockHeight(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Warn("espresso derivation failed to fetch the latest hotshot block height", "err", err)
+		}
+		return config.Interval
+	}
+	for derived := uint64(0); state.Height < latest && derived < config.MaxBlocks; derived++ {
+		if err := s.deriveEspressoBlock(ctx, state); err != nil {
+			if ctx.Err() == nil {
+				log.Warn("espresso derivation failed, will retry", "height", state.Height, "err", err)
+			}
+			return config.Interval
+		}
+		// #nosec G115
+		espressoDerivationHeightGauge.Update(int64(state.Height))
+	}
+	if state.Height < latest {
+		return 0
+	}
+	return config.Interval
+}
+
+// deriveEspressoBlock appends the messages finalized in the HotShot block at state.Height, along with their
+// justification, and advances state to the next block
+func (s *TransactionStreamer) deriveEspressoBlock(ctx context.Context, state *espressoDerivationState) error {
+	height := state.Height
+	namespace := s.chainConfig.ChainID.Uint64()
+	blocks, err := s.fetchEspressoBlocks(ctx, []uint64{height}, namespace)
+	if err != nil {
+		return fmt.Errorf("could not get the block (height: %d): %w", height, err)
+	}
+	block := blocks[0]
+	if err := s.verifyEspressoNamespace(height, namespace, block); err != nil {
+		return err
+	}
+	return s.applyEspressoDerivedBlock(state, block.Namespace.Transactions, func() (*EspressoJustification, error) {
+		return s.fetchEspressoJustification(ctx, height, block.Header)
+	})
+}
+
+// applyEspressoDerivedBlock appends the messages of the verified namespace transactions of the HotShot block at
+// state.Height, and advances state to the next block. Transactions whose attestation doesn't verify are skipped,
+// as anyone may submit to the namespace. The justification is only fetched if the block has new messages, which
+// are added as unconfirmed, as they're later posted to L1. Nothing is written and state is left as is on failure.
+func (s *TransactionStreamer) applyEspressoDerivedBlock(state *espressoDerivationState, transactions []espressoTypes.Bytes, justify func() (*EspressoJustification, error)) error {
+	height := state.Height
+	namespace := s.chainConfig.ChainID.Uint64()
+	count, err := s.GetMessageCount()
+	if err != nil {
+		return err
+	}
+	next := *state
+	next.Assembled = slices.Clone(state.Assembled)
+	batch := s.db.NewBatch()
+	var messages []arbostypes.MessageWithMetadata
+	for _, payload := range transactions {
+		if err := s.verifyEspressoAttestation(payload); err != nil {
+			espressoDerivationSkippedCounter.Inc(1)
+			continue
+		}
+		derived, err := s.deriveEspressoPayload(&next, payload, count, messages)
+		if err != nil {
+			return fmt.Errorf("%w (height: %d)", err, height)
+		}
+		if len(derived) == 0 {
+			continue
+		}
+		hash, err := espressoTransactionHash(&espressoTypes.Transaction{Payload: payload, Namespace: namespace})
+		if err != nil {
+			return err
+		}
+		positions := make([]arbutil.MessageIndex, 0, len(derived))
+		for i := range derived {
+			// #nosec G115
+			positions = append(positions, count+arbutil.MessageIndex(len(messages)+i))
+		}
+		if err := s.setEspressoSubmissionStatus(batch, positions, EspressoSubmissionFinalized, hash); err != nil {
+			return err
+		}
+		messages = append(messages, derived...)
+	}
+	if len(messages) > 0 {
+		justification, err := justify()
+		if err != nil {
+			return err
+		}
+		// #nosec G115
+		last := count + arbutil.MessageIndex(len(messages)) - 1
+		for pos := count; pos <= last; pos++ {
+			if err := s.setEspressoJustification(batch, pos, justification); err != nil {
+				return err
+			}
+		}
+		if err := s.setEspressoLastConfirmedPos(batch, &last); err != nil {
+			return err
+		}
+		if err := s.setEspressoLastFinalizedHeight(batch, height); err != nil {
+			return err
+		}
+	}
+	next.Height = height + 1
+	if err := setEspressoDerivationState(batch, &next); err != nil {
+		return err
+	}
+	if len(messages) == 0 {
+		if err := batch.Write(); err != nil {
+			return err
+		}
+	} else {
+		if err := s.AddMessagesAndEndBatch(count, false, messages, batch); err != nil {
+			return err
+		}
+		espressoDerivedMessagesCounter.Inc(int64(len(messages)))
+		log.Info("derived messages from espresso", "height", height, "pos", count, "messages", len(messages))
+		s.updateEspressoJustifiedWatermark()
+	}
+	*state = next
+	return nil
+}
+
+// deriveEspressoPayload returns the new messages of an attested payload, which must follow the count stored and
+// derived messages. Messages already stored or derived are skipped if they're identical, and a chunk is added to
+// the reassembly of its message in state, its message being returned once complete.
+func (s *TransactionStreamer) deriveEspressoPayload(state *espressoDerivationState, payload []byte, count arbutil.MessageIndex, derived []arbostypes.MessageWithMetadata) ([]arbostypes.MessageWithMetadata, error) {
+	// #nosec G115
+	next := count + arbutil.MessageIndex(len(derived))
+	if chunk, err := parseEspressoChunk(payload); err == nil {
+		if chunk.Pos < next {
+			return nil, nil
+		}
+		if chunk.Pos > next {
+			return nil, fmt.Errorf("espresso chunk of message %d skips messages after %d", chunk.Pos, next)
+		}
+		if state.ChunkPos != uint64(chunk.Pos) || state.ChunkHash != chunk.MessageHash {
+			state.ChunkPos, state.ChunkHash, state.Assembled = uint64(chunk.Pos), chunk.MessageHash, nil
+		}
+		if chunk.Offset != uint64(len(state.Assembled)) {
+			// A chunk finalized twice, e.g. after a resubmission
+			return nil, nil
+		}
+		state.Assembled = append(state.Assembled, chunk.Data...)
+		if !chunk.last() {
+			return nil, nil
+		}
+		assembled := state.Assembled
+		state.Assembled = nil
+		if crypto.Keccak256Hash(assembled) != chunk.MessageHash {
+			return nil, fmt.Errorf("reassembled espresso chunks of message %d don't match their hash", chunk.Pos)
+		}
+		var msg arbostypes.MessageWithMetadata
+		if err := rlp.DecodeBytes(assembled, &msg); err != nil {
+			return nil, fmt.Errorf("failed to decode the chunked message %d: %w", chunk.Pos, err)
+		}
+		return []arbostypes.MessageWithMetadata{msg}, nil
+	} else if !errors.Is(err, errEspressoNotChunk) {
+		return nil, err
+	}
+	_, indices, encoded, err := ParseHotShotPayload(payload)
+	if err != nil {
+		espressoDerivationSkippedCounter.Inc(1)
+		return nil, nil
+	}
+	var messages []arbostypes.MessageWithMetadata
+	for i, index := range indices {
+		pos := arbutil.MessageIndex(index)
+		if pos < next {
+			if err := s.checkEspressoDerivedDuplicate(pos, encoded[i], count, derived); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if pos > next {
+			return nil, fmt.Errorf("espresso payload with message %d skips messages after %d", pos, next)
+		}
+		var msg arbostypes.MessageWithMetadata
+		if err := rlp.DecodeBytes(encoded[i], &msg); err != nil {
+			return nil, fmt.Errorf("failed to decode message %d: %w", pos, err)
+		}
+		messages = append(messages, msg)
+		next++
+	}
+	return messages, nil
+}
+
+// checkEspressoDerivedDuplicate checks that a message finalized again, e.g. after a resubmission, is identical to
+// the one stored or derived at its position
+func (s *TransactionStreamer) checkEspressoDerivedDuplicate(pos arbutil.MessageIndex, encoded []byte, count arbutil.MessageIndex, derived []arbostypes.MessageWithMetadata) error {
+	var existing []byte
+	var err error
+	if pos < count {
+		existing, err = s.db.Get(dbKey(messagePrefix, uint64(pos)))
+		if dbutil.IsErrNotFound(err) {
+			// Pruned
+			return nil
+		}
+	} else {
+		existing, err = rlp.EncodeToBytes(&derived[pos-count])
+	}
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(existing, encoded) {
+		return fmt.Errorf("espresso finalized a message conflicting with the one at position %d", pos)
+	}
+	return nil
+}
+
+// startEspressoDerivation launches the derivation of the chain from espresso if it's enabled
+func (s *TransactionStreamer) startEspressoDerivation() error {
+	if !s.espressoDerivation {
+		return nil
+	}
+	if !s.espressoSubmissionEnabled() {
+		return errors.New("the espresso derivation requires the hotshot urls and the light client")
+	}
+	if s.espressoCodec != nil {
+		return errors.New("the espresso derivation requires the default espresso payload codec")
+	}
+	return s.CallIterativelySafe(s.deriveFromEspresso)
+}
@@ -0,0 +1,213 @@
+package arbnode
+
+import (
+	"crypto/ecdsa"
+	"errors"
+	"testing"
+
+	espressoTypes "github.com/EspressoSystems/espresso-sequencer-go/types"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/rlp"
+
+	"github.com/offchainlabs/nitro/arbos/arbostypes"
+	"github.com/offchainlabs/nitro/arbutil"
+	m "github.com/offchainlabs/nitro/broadcaster/message"
+	"github.com/offchainlabs/nitro/util/signature"
+)
+
+// newTestDerivationStreamer creates a streamer deriving the chain from espresso, accepting the payloads attested
+// by key
+func newTestDerivationStreamer(t *testing.T, db ethdb.Database, key *ecdsa.PrivateKey) *TransactionStreamer {
+	t.Helper()
+	streamer := newTestStreamer(t, db, func(config *TransactionStreamerConfig) {
+		config.Espresso.Derivation = DefaultEspressoDerivationConfig
+		config.Espresso.Derivation.Enable = true
+		config.Espresso.Attestation = EspressoAttestationConfig{
+			Scheme:         EspressoAttestationSchemeECDSA,
+			AllowedSigners: []string{crypto.PubkeyToAddress(key.PublicKey).Hex()},
+		}
+	})
+	streamer.SetEspressoAttestationSigner(NewEspressoECDSAAttestationSigner(signature.DataSignerFromPrivateKey(key)))
+	Require(t, streamer.initEspressoAttestation())
+	return streamer
+}
+
+func TestEspressoDerivePayload(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	Require(t, err)
+	streamer := newTestDerivationStreamer(t, rawdb.NewMemoryDatabase(), key)
+	Require(t, streamer.AddMessages(0, true, testChainMessages(0, 2)))
+
+	chainMessages := testChainMessages(0, 5)
+	payload := func(positions ...arbutil.MessageIndex) []byte {
+		t.Helper()
+		fetcher := func(pos arbutil.MessageIndex) ([]byte, error) {
+			return rlp.EncodeToBytes(&chainMessages[pos])
+		}
+		raw, cnt := buildRawHotShotPayload(positions, fetcher, 1024*1024)
+		if cnt != len(positions) {
+			Fail(t, "not all messages fit in the payload")
+		}
+		signed, err := signHotShotPayload(raw, func([]byte) ([]byte, error) { return []byte("attestation"), nil })
+		Require(t, err)
+		return signed
+	}
+
+	// Messages already stored are skipped, the new ones must follow them
+	state := &espressoDerivationState{}
+	derived, err := streamer.deriveEspressoPayload(state, payload(1, 2, 3), 2, nil)
+	Require(t, err)
+	if len(derived) != 2 || derived[0].Message.Header.Timestamp != chainMessages[2].Message.Header.Timestamp {
+		Fail(t, "unexpected derived messages", derived)
+	}
+	// A resubmission of the derived messages is skipped
+	again, err := streamer.deriveEspressoPayload(state, payload(3), 2, derived)
+	Require(t, err)
+	if len(again) != 0 {
+		Fail(t, "duplicate message derived again", again)
+	}
+	if _, err := streamer.deriveEspressoPayload(state, payload(4), 2, nil); err == nil {
+		Fail(t, "payload skipping messages accepted")
+	}
+	conflicting := testChainMessages(10, 11)
+	chainMessages[1] = conflicting[0]
+	if _, err := streamer.deriveEspressoPayload(state, payload(1), 2, nil); err == nil {
+		Fail(t, "payload conflicting with a stored message accepted")
+	}
+
+	// The chain is only read from espresso
+	err = streamer.AddBroadcastMessages([]*m.BroadcastFeedMessage{{SequenceNumber: 2, Message: arbostypes.EmptyTestMessageWithMetadata}})
+	if !errors.Is(err, ErrEspressoDerivation) {
+		Fail(t, "expected ErrEspressoDerivation adding feed messages, got", err)
+	}
+}
+
+func TestEspressoDeriveBlock(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	Require(t, err)
+	otherKey, err := crypto.GenerateKey()
+	Require(t, err)
+	db := rawdb.NewMemoryDatabase()
+	streamer := newTestDerivationStreamer(t, db, key)
+
+	chainMessages := testChainMessages(0, 3)
+	sign := func(unsigned []byte, signer EspressoAttestationSigner) []byte {
+		t.Helper()
+		signed, err := signHotShotPayload(unsigned, func(unsigned []byte) ([]byte, error) {
+			attestation, err := signer.Attest(unsigned)
+			return wrapEspressoAttestation(signer.Kind(), attestation), err
+		})
+		Require(t, err)
+		return signed
+	}
+	sequencer := NewEspressoECDSAAttestationSigner(signature.DataSignerFromPrivateKey(key))
+	justification := func(height uint64) func() (*EspressoJustification, error) {
+		return func() (*EspressoJustification, error) {
+			return &EspressoJustification{HotShotHeight: height, RootHeight: height + 1}, nil
+		}
+	}
+	noJustification := func() (*EspressoJustification, error) {
+		return nil, errors.New("not finalized by the light client")
+	}
+	expectState := func(height uint64, assembling bool) {
+		t.Helper()
+		stored, err := streamer.getEspressoDerivationState()
+		Require(t, err)
+		if stored.Height != height || (len(stored.Assembled) != 0) != assembling {
+			Fail(t, "unexpected stored derivation state", stored.Height, len(stored.Assembled), "expected", height, assembling)
+		}
+	}
+	expectDerived := func(pos arbutil.MessageIndex, height uint64, txPayload []byte) {
+		t.Helper()
+		msg, err := streamer.GetMessage(pos)
+		Require(t, err)
+		if msg.Message.Header.Timestamp != chainMessages[pos].Message.Header.Timestamp {
+			Fail(t, "unexpected message derived at", pos, msg.Message.Header.Timestamp)
+		}
+		record, err := streamer.GetEspressoSubmissionRecord(pos)
+		Require(t, err)
+		hash, err := espressoTransactionHash(&espressoTypes.Transaction{Payload: txPayload, Namespace: 412346})
+		Require(t, err)
+		if record == nil || record.Status != EspressoSubmissionFinalized || record.TxHash != hash.String() {
+			Fail(t, "unexpected submission record of derived message", pos, record)
+		}
+		stored, err := streamer.GetEspressoJustification(pos)
+		Require(t, err)
+		if stored == nil || stored.HotShotHeight != height {
+			Fail(t, "justification of derived message", pos, "not stored", stored)
+		}
+	}
+
+	// A payload attested by another key is skipped, the attested one is derived with its justification
+	state, err := streamer.getEspressoDerivationState()
+	Require(t, err)
+	raw, _ := buildRawHotShotPayload([]arbutil.MessageIndex{0, 1}, func(pos arbutil.MessageIndex) ([]byte, error) {
+		return rlp.EncodeToBytes(&chainMessages[pos])
+	}, 1024*1024)
+	attested := sign(raw, sequencer)
+	forged := sign(raw, NewEspressoECDSAAttestationSigner(signature.DataSignerFromPrivateKey(otherKey)))
+	Require(t, streamer.applyEspressoDerivedBlock(state, []espressoTypes.Bytes{forged, attested}, justification(0)))
+	count, err := streamer.GetMessageCount()
+	Require(t, err)
+	if count != 2 || state.Height != 1 {
+		Fail(t, "unexpected derivation after the first block", count, state.Height)
+	}
+	expectDerived(0, 0, attested)
+	expectDerived(1, 0, attested)
+	lastConfirmed, err := streamer.getLastConfirmedPos()
+	Require(t, err)
+	if lastConfirmed == nil || *lastConfirmed != 1 {
+		Fail(t, "unexpected last confirmed position", lastConfirmed)
+	}
+	finalizedHeight, err := streamer.getEspressoLastFinalizedHeight()
+	Require(t, err)
+	if finalizedHeight == nil || *finalizedHeight != 0 {
+		Fail(t, "unexpected last finalized height", finalizedHeight)
+	}
+	expectState(1, false)
+
+	// A block without new messages advances the state without a justification
+	Require(t, streamer.applyEspressoDerivedBlock(state, []espressoTypes.Bytes{forged, attested}, noJustification))
+	expectState(2, false)
+
+	// The first chunk of a message is kept in the stored state, so that a restarted node resumes the reassembly
+	encoded, err := rlp.EncodeToBytes(&chainMessages[2])
+	Require(t, err)
+	chunk := func(index uint32, data []byte, offset int) []byte {
+		return sign(encodeEspressoChunk(&espressoChunk{
+			Pos:         2,
+			Index:       index,
+			Count:       2,
+			Offset:      uint64(offset),
+			TotalSize:   uint64(len(encoded)),
+			MessageHash: crypto.Keccak256Hash(encoded),
+			Data:        data,
+		}), sequencer)
+	}
+	half := len(encoded) / 2
+	Require(t, streamer.applyEspressoDerivedBlock(state, []espressoTypes.Bytes{chunk(0, encoded[:half], 0)}, noJustification))
+	expectState(3, true)
+
+	streamer = newTestDerivationStreamer(t, db, key)
+	state, err = streamer.getEspressoDerivationState()
+	Require(t, err)
+	// Without a justification the block isn't derived, and it's derived again once the justification is fetched
+	lastChunk := chunk(1, encoded[half:], half)
+	if err := streamer.applyEspressoDerivedBlock(state, []espressoTypes.Bytes{lastChunk}, noJustification); err == nil {
+		Fail(t, "block derived without a justification")
+	}
+	if state.Height != 3 || len(state.Assembled) != half {
+		Fail(t, "derivation state changed by a failed block", state.Height, len(state.Assembled))
+	}
+	expectState(3, true)
+	count, err = streamer.GetMessageCount()
+	Require(t, err)
+	if count != 2 {
+		Fail(t, "message stored by a failed block", count)
+	}
+	Require(t, streamer.applyEspressoDerivedBlock(state, []espressoTypes.Bytes{lastChunk}, justification(3)))
+	expectDerived(2, 3, lastChunk)
+	expectState(4, false)
+}
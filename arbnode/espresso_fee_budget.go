@@ -0,0 +1,172 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package arbnode
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/rlp"
+	flag "github.com/spf13/pflag"
+
+	"github.com/offchainlabs/nitro/util/dbutil"
+)
+
+var (
+	ErrEspressoFeeTooHigh         = errors.New("espresso transaction fee exceeds the max-fee-per-tx")
+	ErrEspressoFeeBudgetExhausted = errors.New("espresso daily fee budget exhausted")
+
+	espressoFeeGauge           = metrics.NewRegisteredGauge("arb/espresso/fee/estimate", nil)
+	espressoFeeSpentGauge      = metrics.NewRegisteredGauge("arb/espresso/fee/spent_today", nil)
+	espressoFeePausedCounter   = metrics.NewRegisteredCounter("arb/espresso/fee/paused", nil)
+	espressoFeeOverBudgetGauge = metrics.NewRegisteredGauge("arb/espresso/fee/over_budget", nil)
+)
+
+const espressoFeeBudgetPeriod = 24 * time.Hour
+
+// EspressoFeeConfig limits what the espresso submissions may cost, fees are denominated in the smallest unit
+// of HotShot's fee token
+type EspressoFeeConfig struct {
+	MaxFeePerTx uint64 `koanf:"max-fee-per-tx" reload:"hot"`
+	DailyBudget uint64 `koanf:"daily-budget" reload:"hot"`
+}
+
+var DefaultEspressoFeeConfig = EspressoFeeConfig{
+	MaxFeePerTx: 0,
+	DailyBudget: 0,
+}
+
+func EspressoFeeConfigAddOptions(prefix string, f *flag.FlagSet) {
+	f.Uint64(prefix+".max-fee-per-tx", DefaultEspressoFeeConfig.MaxFeePerTx, "maximum estimated fee of a single espresso transaction, submissions are paused while the estimate is higher (0 = unlimited)")
+	f.Uint64(prefix+".daily-budget", DefaultEspressoFeeConfig.DailyBudget, "maximum estimated fees spent on espresso transactions per UTC day, submissions are paused until the next day once it's exhausted (0 = unlimited)")
+}
+
+func (c *EspressoFeeConfig) Validate() error {
+	if c.MaxFeePerTx != 0 && c.DailyBudget != 0 && c.MaxFeePerTx > c.DailyBudget {
+		return fmt.Errorf("espresso max-fee-per-tx %d is larger than the daily-budget %d", c.MaxFeePerTx, c.DailyBudget)
+	}
+	return nil
+}
+
+// EspressoFeeEstimator estimates the fee HotShot charges for a transaction with payload
+type EspressoFeeEstimator interface {
+	EstimateFee(ctx context.Context, payload []byte) (*big.Int, error)
+}
+
+// baseFeeEspressoFeeEstimator charges the base fee of the HotShot chain config per payload byte,
+// transactions are free until the chain config is read
+type baseFeeEspressoFeeEstimator struct {
+	streamer *TransactionStreamer
+}
+
+func (e baseFeeEspressoFeeEstimator) EstimateFee(ctx context.Context, payload []byte) (*big.Int, error) {
+	baseFee := e.streamer.espressoBaseFee.Load()
+	if baseFee == nil {
+		return new(big.Int), nil
+	}
+	return new(big.Int).Mul(baseFee, big.NewInt(int64(len(payload)))), nil
+}
+
+// SetEspressoFeeEstimator replaces the fee estimate based on the HotShot base fee, it must be called before Start
+func (s *TransactionStreamer) SetEspressoFeeEstimator(estimator EspressoFeeEstimator) {
+	if s.Started() {
+		panic("trying to set espresso fee estimator after start")
+	}
+	s.espressoFeeEstimator = estimator
+}
+
+func (s *TransactionStreamer) espressoFees() EspressoFeeEstimator {
+	if s.espressoFeeEstimator == nil {
+		return baseFeeEspressoFeeEstimator{streamer: s}
+	}
+	return s.espressoFeeEstimator
+}
+
+// espressoFeeSpend is the estimated fee spent on submissions during a budget period
+type espressoFeeSpend struct {
+	Period uint64
+	Spent  *big.Int
+}
+
+func espressoFeeBudgetPeriodOf(now time.Time) uint64 {
+	// #nosec G115
+	return uint64(now.Unix() / int64(espressoFeeBudgetPeriod/time.Second))
+}
+
+// getEspressoFeeSpent returns the fees spent in the budget period of now
+func (s *TransactionStreamer) getEspressoFeeSpent(now time.Time) (*big.Int, error) {
+	data, err := s.db.Get(espressoFeeSpendKey)
+	if err != nil {
+		if dbutil.IsErrNotFound(err) {
+			return new(big.Int), nil
+		}
+		return nil, err
+	}
+	var spend espressoFeeSpend
+	if err := rlp.DecodeBytes(data, &spend); err != nil {
+		return nil, err
+	}
+	if spend.Period != espressoFeeBudgetPeriodOf(now) || spend.Spent == nil {
+		return new(big.Int), nil
+	}
+	return spend.Spent, nil
+}
+
+func (s *TransactionStreamer) addEspressoFeeSpent(fee *big.Int, now time.Time) error {
+	spent, err := s.getEspressoFeeSpent(now)
+	if err != nil {
+		return err
+	}
+	spent = new(big.Int).Add(spent, fee)
+	data, err := rlp.EncodeToBytes(espressoFeeSpend{Period: espressoFeeBudgetPeriodOf(now), Spent: spent})
+	if err != nil {
+		return err
+	}
+	if err := s.db.Put(espressoFeeSpendKey, data); err != nil {
+		return err
+	}
+	if spent.IsInt64() {
+		espressoFeeSpentGauge.Update(spent.Int64())
+	}
+	return nil
+}
+
+// checkEspressoFee estimates the fee of submitting payload, and returns an error while the fee limits don't
+// allow submitting it. Submissions stay paused until the estimate or the budget period changes.
+func (s *TransactionStreamer) checkEspressoFee(ctx context.Context, payload []byte, now time.Time) (*big.Int, error) {
+	fee, err := s.espressoFees().EstimateFee(ctx, payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to estimate the espresso transaction fee: %w", err)
+	}
+	if fee.IsInt64() {
+		espressoFeeGauge.Update(fee.Int64())
+	}
+	config := &s.config().Espresso.Fees
+	if config.MaxFeePerTx != 0 && fee.Cmp(new(big.Int).SetUint64(config.MaxFeePerTx)) > 0 {
+		espressoFeePausedCounter.Inc(1)
+		log.Error("espresso submissions paused, the transaction fee is above the limit", "fee", fee, "maxFeePerTx", config.MaxFeePerTx)
+		return nil, fmt.Errorf("%w: fee %v, limit %d", ErrEspressoFeeTooHigh, fee, config.MaxFeePerTx)
+	}
+	if config.DailyBudget == 0 {
+		espressoFeeOverBudgetGauge.Update(0)
+		return fee, nil
+	}
+	spent, err := s.getEspressoFeeSpent(now)
+	if err != nil {
+		return nil, err
+	}
+	if new(big.Int).Add(spent, fee).Cmp(new(big.Int).SetUint64(config.DailyBudget)) > 0 {
+		espressoFeePausedCounter.Inc(1)
+		espressoFeeOverBudgetGauge.Update(1)
+		log.Error("espresso submissions paused until the next day, the daily fee budget is exhausted", "fee", fee, "spent", spent, "dailyBudget", config.DailyBudget)
+		return nil, fmt.Errorf("%w: spent %v, fee %v, budget %d", ErrEspressoFeeBudgetExhausted, spent, fee, config.DailyBudget)
+	}
+	espressoFeeOverBudgetGauge.Update(0)
+	return fee, nil
+}
@@ -0,0 +1,56 @@
+package arbnode
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/rawdb"
+)
+
+func TestEspressoFeeBudget(t *testing.T) {
+	ctx := context.Background()
+	config := TestTransactionStreamerConfig
+	config.Espresso.Fees = EspressoFeeConfig{MaxFeePerTx: 100, DailyBudget: 250}
+	streamer := &TransactionStreamer{
+		db:     rawdb.NewMemoryDatabase(),
+		config: func() *TransactionStreamerConfig { return &config },
+	}
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	// Transactions are free until the base fee is known
+	fee, err := streamer.checkEspressoFee(ctx, make([]byte, 1000), now)
+	Require(t, err)
+	if fee.Sign() != 0 {
+		Fail(t, "unexpected fee without a base fee", fee)
+	}
+
+	streamer.espressoBaseFee.Store(big.NewInt(2))
+	if _, err := streamer.checkEspressoFee(ctx, make([]byte, 51), now); !errors.Is(err, ErrEspressoFeeTooHigh) {
+		Fail(t, "fee above the max-fee-per-tx accepted", err)
+	}
+	for i := 0; i < 2; i++ {
+		fee, err = streamer.checkEspressoFee(ctx, make([]byte, 50), now)
+		Require(t, err)
+		if fee.Int64() != 100 {
+			Fail(t, "unexpected fee", fee)
+		}
+		Require(t, streamer.addEspressoFeeSpent(fee, now))
+	}
+	if _, err := streamer.checkEspressoFee(ctx, make([]byte, 50), now); !errors.Is(err, ErrEspressoFeeBudgetExhausted) {
+		Fail(t, "fee above the daily budget accepted", err)
+	}
+	_, err = streamer.checkEspressoFee(ctx, make([]byte, 25), now)
+	Require(t, err)
+
+	// The budget is reset the next day
+	_, err = streamer.checkEspressoFee(ctx, make([]byte, 50), now.Add(24*time.Hour))
+	Require(t, err)
+
+	config.Espresso.Fees = EspressoFeeConfig{MaxFeePerTx: 300, DailyBudget: 250}
+	if config.Espresso.Fees.Validate() == nil {
+		Fail(t, "accepted a max-fee-per-tx above the daily budget")
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package arbnode
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	tagged_base64 "github.com/EspressoSystems/espresso-sequencer-go/tagged-base64"
+	espressoTypes "github.com/EspressoSystems/espresso-sequencer-go/types"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+
+	m "github.com/offchainlabs/nitro/broadcaster/message"
+)
+
+var (
+	ErrEspressoFeedJustificationInvalid = errors.New("invalid espresso justification in the feed")
+
+	espressoFeedJustificationVerifiedCounter = metrics.NewRegisteredCounter("arb/espresso/feed_justification/verified", nil)
+	espressoFeedJustificationRejectedCounter = metrics.NewRegisteredCounter("arb/espresso/feed_justification/rejected", nil)
+	espressoFeedJustificationMissingCounter  = metrics.NewRegisteredCounter("arb/espresso/feed_justification/missing", nil)
+)
+
+// verifyFeedEspressoFinality verifies the justifications of the espresso finality the feed claims for its messages,
+// if enabled. The messages are rejected if a justification doesn't verify against the light client, so that the
+// feed operator isn't trusted with finality claims. Claims without a justification can't be verified, they're only
+// counted, and the messages are queued as before.
+func (s *TransactionStreamer) verifyFeedEspressoFinality(feedMessages []*m.BroadcastFeedMessage) error {
+	if !s.config().Espresso.FeedJustificationVerification {
+		return nil
+	}
+	// Messages finalized in the same HotShot block share a justification
+	verified := make(map[uint64]*m.EspressoFeedJustification)
+	for _, feedMessage := range feedMessages {
+		finality := feedMessage.EspressoFinality
+		if finality == nil {
+			continue
+		}
+		if finality.Justification == nil {
+			espressoFeedJustificationMissingCounter.Inc(1)
+			continue
+		}
+		if previous, ok := verified[finality.HotShotHeight]; ok && sameEspressoFeedJustification(previous, finality.Justification) {
+			continue
+		}
+		if err := s.verifyEspressoFeedJustification(finality); err != nil {
+			espressoFeedJustificationRejectedCounter.Inc(1)
+			log.Warn("rejecting feed messages with an invalid espresso justification", "pos", feedMessage.SequenceNumber, "height", finality.HotShotHeight, "err", err)
+			return fmt.Errorf("feed message %d: %w", feedMessage.SequenceNumber, err)
+		}
+		espressoFeedJustificationVerifiedCounter.Inc(1)
+		verified[finality.HotShotHeight] = finality.Justification
+	}
+	return nil
+}
+
+func sameEspressoFeedJustification(a *m.EspressoFeedJustification, b *m.EspressoFeedJustification) bool {
+	return a.RootHeight == b.RootHeight && a.BlockMerkleRoot == b.BlockMerkleRoot &&
+		bytes.Equal(a.Header, b.Header) && bytes.Equal(a.Proof, b.Proof)
+}
+
+// verifyEspressoFeedJustification checks that the header of the justification is at the claimed height, and that it's
+// proven against the block merkle root the light client committed to in its first snapshot covering the height,
+// which must be finalized on L1. The header isn't checked to include the message, only the block's finality is.
+func (s *TransactionStreamer) verifyEspressoFeedJustification(finality *m.EspressoFinality) error {
+	if namespace := s.chainConfig.ChainID.Uint64(); finality.Namespace != namespace {
+		return fmt.Errorf("%w: namespace %d isn't the chain's namespace %d", ErrEspressoFeedJustificationInvalid, finality.Namespace, namespace)
+	}
+	return s.verifyEspressoJustificationProof(finality.HotShotHeight, finality.Justification)
+}
+
+// verifyEspressoJustificationProof checks a justification of the block at height against the light client, see
+// verifyEspressoFeedJustification
+func (s *TransactionStreamer) verifyEspressoJustificationProof(height uint64, justification *m.EspressoFeedJustification) error {
+	var header espressoTypes.HeaderImpl
+	if err := json.Unmarshal(justification.Header, &header); err != nil {
+		return fmt.Errorf("%w: failed to decode the header (height: %d): %w", ErrEspressoFeedJustificationInvalid, height, err)
+	}
+	if err := s.checkEspressoHeaderVersions(header); err != nil {
+		return fmt.Errorf("%w: %w", ErrEspressoFeedJustificationInvalid, err)
+	}
+	root, err := tagged_base64.Parse(justification.BlockMerkleRoot)
+	if err != nil {
+		return fmt.Errorf("%w: invalid block merkle root (height: %d): %w", ErrEspressoFeedJustificationInvalid, height, err)
+	}
+	reader := s.espressoHeaderVerifierReader()
+	if reader == nil {
+		return errors.New("no light client to verify the espresso justifications of the feed against")
+	}
+	snapshot, err := reader.FetchMerkleRoot(height, nil)
+	if err != nil {
+		return fmt.Errorf("%w (height: %d): %w", EspressoFetchMerkleRootErr, height, err)
+	}
+	if snapshot.Height != justification.RootHeight {
+		return fmt.Errorf("%w: proven against root height %d, the light client's snapshot is at %d (height: %d)", ErrEspressoFeedJustificationInvalid, justification.RootHeight, snapshot.Height, height)
+	}
+	codec, err := s.espressoJustificationCodec(header.Header.Version())
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrEspressoFeedJustificationInvalid, err)
+	}
+	if !codec.VerifyProof(justification.Proof, justification.Header, *root, snapshot.Root) {
+		return fmt.Errorf("%w: invalid block merkle proof (height: %d, root height: %d)", ErrEspressoFeedJustificationInvalid, height, snapshot.Height)
+	}
+	return s.checkEspressoHeader(height, header, snapshot)
+}
@@ -0,0 +1,33 @@
+package arbnode
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/offchainlabs/nitro/arbos/arbostypes"
+	m "github.com/offchainlabs/nitro/broadcaster/message"
+)
+
+func TestFeedEspressoFinalityVerification(t *testing.T) {
+	streamer := newTestStreamer(t, nil, nil)
+	feedMessage := func(finality *m.EspressoFinality) *m.BroadcastFeedMessage {
+		return &m.BroadcastFeedMessage{
+			Message:          arbostypes.MessageWithMetadata{Message: &arbostypes.L1IncomingMessage{Header: &arbostypes.L1IncomingMessageHeader{}}},
+			EspressoFinality: finality,
+		}
+	}
+	foreign := feedMessage(&m.EspressoFinality{
+		HotShotHeight: 5,
+		Namespace:     1,
+		Justification: &m.EspressoFeedJustification{Header: []byte("{}"), RootHeight: 6, Proof: []byte("{}")},
+	})
+	unjustified := feedMessage(&m.EspressoFinality{HotShotHeight: 5, Namespace: 412346})
+
+	Require(t, streamer.verifyFeedEspressoFinality([]*m.BroadcastFeedMessage{foreign}))
+	streamer.config().Espresso.FeedJustificationVerification = true
+	Require(t, streamer.verifyFeedEspressoFinality([]*m.BroadcastFeedMessage{unjustified, feedMessage(nil)}))
+	err := streamer.verifyFeedEspressoFinality([]*m.BroadcastFeedMessage{unjustified, foreign})
+	if !errors.Is(err, ErrEspressoFeedJustificationInvalid) {
+		Fail(t, "justification for another namespace wasn't rejected", err)
+	}
+}
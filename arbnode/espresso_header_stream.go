@@ -0,0 +1,193 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package arbnode
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	espressoTypes "github.com/EspressoSystems/espresso-sequencer-go/types"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+)
+
+const (
+	espressoHeaderStreamDialTimeout = 10 * time.Second
+	// HotShot produces blocks every few seconds, a silent stream is considered broken
+	espressoHeaderStreamIdleTimeout  = time.Minute
+	espressoHeaderStreamRetryBackoff = 5 * time.Second
+)
+
+// espressoHeaderStream follows the HotShot availability stream API over a websocket,
+// so the streamer learns about new HotShot blocks push-style. While the stream is
+// connected, the finality check only queries HotShot when a new block has arrived.
+// Polling on an interval is kept as the fallback while the stream is disconnected.
+// With several query nodes, the next one is tried after a disconnect.
+type espressoHeaderStream struct {
+	urlsMutex   sync.Mutex
+	urls        []string
+	nextUrl     int
+	startHeight func(ctx context.Context) (uint64, error)
+	onHeader    func(height uint64)
+
+	connected    atomic.Bool
+	latestHeight atomic.Uint64
+}
+
+// espressoStreamUrl converts a HotShot query service url to the url of its header stream
+func espressoStreamUrl(hotShotUrl string) (string, error) {
+	url := hotShotUrl
+	switch {
+	case strings.HasPrefix(url, "https://"):
+		url = "wss://" + strings.TrimPrefix(url, "https://")
+	case strings.HasPrefix(url, "http://"):
+		url = "ws://" + strings.TrimPrefix(url, "http://")
+	case strings.HasPrefix(url, "wss://"), strings.HasPrefix(url, "ws://"):
+	default:
+		return "", fmt.Errorf("unsupported hotshot url scheme: %v", hotShotUrl)
+	}
+	if !strings.HasSuffix(url, "/") {
+		url += "/"
+	}
+	return url + "availability/stream/headers/", nil
+}
+
+func newEspressoHeaderStream(hotShotUrls []string, startHeight func(ctx context.Context) (uint64, error), onHeader func(height uint64)) (*espressoHeaderStream, error) {
+	stream := &espressoHeaderStream{
+		startHeight: startHeight,
+		onHeader:    onHeader,
+	}
+	if err := stream.setUrls(hotShotUrls); err != nil {
+		return nil, err
+	}
+	return stream, nil
+}
+
+// setUrls replaces the query nodes to stream from, the new urls are used on the next reconnect
+func (h *espressoHeaderStream) setUrls(hotShotUrls []string) error {
+	if len(hotShotUrls) == 0 {
+		return errors.New("no hotshot urls given")
+	}
+	urls := make([]string, 0, len(hotShotUrls))
+	for _, hotShotUrl := range hotShotUrls {
+		url, err := espressoStreamUrl(hotShotUrl)
+		if err != nil {
+			return err
+		}
+		urls = append(urls, url)
+	}
+	h.urlsMutex.Lock()
+	defer h.urlsMutex.Unlock()
+	h.urls = urls
+	h.nextUrl = 0
+	return nil
+}
+
+func (h *espressoHeaderStream) currentUrl() string {
+	h.urlsMutex.Lock()
+	defer h.urlsMutex.Unlock()
+	return h.urls[h.nextUrl]
+}
+
+func (h *espressoHeaderStream) rotateUrl() {
+	h.urlsMutex.Lock()
+	defer h.urlsMutex.Unlock()
+	h.nextUrl = (h.nextUrl + 1) % len(h.urls)
+}
+
+// Connected returns true if headers are currently being received from the stream
+func (h *espressoHeaderStream) Connected() bool {
+	return h.connected.Load()
+}
+
+// LatestHeight returns the height of the latest HotShot block received from the stream
+func (h *espressoHeaderStream) LatestHeight() uint64 {
+	return h.latestHeight.Load()
+}
+
+// run is called iteratively; it follows the stream until it breaks, then returns the reconnect delay.
+func (h *espressoHeaderStream) run(ctx context.Context) time.Duration {
+	err := h.follow(ctx)
+	h.connected.Store(false)
+	if ctx.Err() != nil {
+		return 0
+	}
+	log.Warn("hotshot header stream disconnected, falling back to polling", "url", h.currentUrl(), "err", err)
+	h.rotateUrl()
+	return espressoHeaderStreamRetryBackoff
+}
+
+func (h *espressoHeaderStream) follow(ctx context.Context) error {
+	from := h.latestHeight.Load()
+	if from == 0 {
+		height, err := h.startHeight(ctx)
+		if err != nil {
+			return err
+		}
+		from = height
+	} else {
+		from++
+	}
+	dialer := ws.Dialer{
+		Timeout: espressoHeaderStreamDialTimeout,
+		TLSConfig: &tls.Config{
+			MinVersion: tls.VersionTLS12,
+		},
+	}
+	conn, br, _, err := dialer.Dial(ctx, fmt.Sprintf("%s%d", h.currentUrl(), from))
+	if err != nil {
+		return fmt.Errorf("unable to connect to the hotshot header stream: %w", err)
+	}
+	defer conn.Close()
+	// Unblock reads on shutdown
+	stopped := make(chan struct{})
+	defer close(stopped)
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = conn.Close()
+		case <-stopped:
+		}
+	}()
+	var rw io.ReadWriter = conn
+	if br != nil {
+		// The buffered reader may already hold frames sent right after the handshake
+		rw = struct {
+			io.Reader
+			io.Writer
+		}{br, conn}
+	}
+	log.Info("connected to the hotshot header stream", "from", from)
+	return h.readHeaders(conn, rw)
+}
+
+func (h *espressoHeaderStream) readHeaders(conn net.Conn, rw io.ReadWriter) error {
+	for {
+		if err := conn.SetReadDeadline(time.Now().Add(espressoHeaderStreamIdleTimeout)); err != nil {
+			return err
+		}
+		data, err := wsutil.ReadServerText(rw)
+		if err != nil {
+			return err
+		}
+		var header espressoTypes.HeaderImpl
+		if err := json.Unmarshal(data, &header); err != nil {
+			return fmt.Errorf("failed to decode hotshot header: %w", err)
+		}
+		height := header.Header.GetBlockHeight()
+		h.latestHeight.Store(height)
+		h.connected.Store(true)
+		h.onHeader(height)
+	}
+}
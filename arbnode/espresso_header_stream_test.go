@@ -0,0 +1,23 @@
+package arbnode
+
+import (
+	"testing"
+)
+
+func TestEspressoStreamUrl(t *testing.T) {
+	cases := map[string]string{
+		"http://localhost:41000":          "ws://localhost:41000/availability/stream/headers/",
+		"https://query.espresso.network/": "wss://query.espresso.network/availability/stream/headers/",
+		"ws://localhost:41000/v0":         "ws://localhost:41000/v0/availability/stream/headers/",
+	}
+	for hotShotUrl, expected := range cases {
+		url, err := espressoStreamUrl(hotShotUrl)
+		Require(t, err)
+		if url != expected {
+			Fail(t, "unexpected stream url for", hotShotUrl, "got", url, "expected", expected)
+		}
+	}
+	if _, err := espressoStreamUrl("localhost:41000"); err == nil {
+		Fail(t, "expected an error for a url without a scheme")
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package arbnode
+
+import (
+	"errors"
+	"fmt"
+
+	lightclient "github.com/EspressoSystems/espresso-sequencer-go/light-client"
+	espressoTypes "github.com/EspressoSystems/espresso-sequencer-go/types"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/metrics"
+	flag "github.com/spf13/pflag"
+)
+
+var (
+	ErrEspressoHeaderNotFinalized = errors.New("espresso header not finalized by the light client")
+	ErrEspressoHeaderMismatch     = errors.New("espresso header doesn't match the light client")
+
+	espressoHeaderRejectedCounter = metrics.NewRegisteredCounter("arb/espresso/header/rejected", nil)
+)
+
+// EspressoHeaderVerificationConfig configures checking the HotShot headers fetched from the query service against
+// the HotShot light client contract before justifications are written. Without an address and url, the light client
+// the batch poster was configured with is used.
+type EspressoHeaderVerificationConfig struct {
+	Enable             bool   `koanf:"enable" reload:"hot"`
+	LightClientAddress string `koanf:"light-client-address"`
+	L1Url              string `koanf:"l1-url"`
+}
+
+var DefaultEspressoHeaderVerificationConfig = EspressoHeaderVerificationConfig{
+	Enable: false,
+}
+
+func EspressoHeaderVerificationConfigAddOptions(prefix string, f *flag.FlagSet) {
+	f.Bool(prefix+".enable", DefaultEspressoHeaderVerificationConfig.Enable, "verify hotshot headers against the light client contract before writing espresso justifications, rejecting headers that aren't finalized on L1 yet")
+	f.String(prefix+".light-client-address", DefaultEspressoHeaderVerificationConfig.LightClientAddress, "address of the hotshot light client contract to verify headers against (defaults to the batch poster's light client)")
+	f.String(prefix+".l1-url", DefaultEspressoHeaderVerificationConfig.L1Url, "url of the L1 node to read the light client contract from (defaults to the batch poster's L1 node)")
+}
+
+func (c *EspressoHeaderVerificationConfig) Validate() error {
+	if (c.LightClientAddress == "") != (c.L1Url == "") {
+		return errors.New("the light client address and L1 url of the espresso header verification must be set together")
+	}
+	if c.LightClientAddress != "" && !common.IsHexAddress(c.LightClientAddress) {
+		return fmt.Errorf("invalid light client address %q", c.LightClientAddress)
+	}
+	return nil
+}
+
+func newEspressoHeaderVerifier(config *EspressoHeaderVerificationConfig) (lightclient.LightClientReaderInterface, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	if config.L1Url == "" {
+		return nil, nil
+	}
+	l1Client, err := ethclient.Dial(config.L1Url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to the L1 node of the espresso header verification: %w", err)
+	}
+	return lightclient.NewLightClientReader(common.HexToAddress(config.LightClientAddress), l1Client)
+}
+
+func (s *TransactionStreamer) espressoHeaderVerifierReader() lightclient.LightClientReaderInterface {
+	if s.espressoHeaderVerifier != nil {
+		return s.espressoHeaderVerifier
+	}
+	return s.lightClientReader
+}
+
+// verifyEspressoHeader checks a header fetched from the query service against the light client contract before a
+// justification is written for it. The header must be at the expected height, and both it and the block merkle root
+// it's proven against must be finalized by the light client on L1.
+func (s *TransactionStreamer) verifyEspressoHeader(height uint64, header espressoTypes.HeaderImpl, snapshot espressoTypes.BlockMerkleSnapshot) error {
+	if !s.config().Espresso.HeaderVerification.Enable {
+		return nil
+	}
+	err := s.checkEspressoHeader(height, header, snapshot)
+	if err != nil {
+		espressoHeaderRejectedCounter.Inc(1)
+	}
+	if errors.Is(err, ErrEspressoHeaderMismatch) {
+		s.recordEspressoVerificationFailure(espressoCheckHeader, err)
+	}
+	return err
+}
+
+func (s *TransactionStreamer) checkEspressoHeader(height uint64, header espressoTypes.HeaderImpl, snapshot espressoTypes.BlockMerkleSnapshot) error {
+	if header.Header == nil || header.Header.GetBlockHeight() != height {
+		return fmt.Errorf("%w: the query service returned a header for the wrong height (height: %d)", ErrEspressoHeaderMismatch, height)
+	}
+	reader := s.espressoHeaderVerifierReader()
+	if reader == nil {
+		return errors.New("no light client to verify espresso headers against")
+	}
+	validatedHeight, l1Height, err := reader.ValidatedHeight()
+	if err != nil {
+		return fmt.Errorf("failed to read the light client's finalized height: %w", err)
+	}
+	if snapshot.Height > validatedHeight {
+		return fmt.Errorf("%w (height: %d, root height: %d, finalized height: %d, l1 height: %d)", ErrEspressoHeaderNotFinalized, height, snapshot.Height, validatedHeight, l1Height)
+	}
+	if s.espressoHeaderVerifier == nil {
+		// The block merkle root was read from the same light client
+		return nil
+	}
+	expected, err := s.espressoHeaderVerifier.FetchMerkleRoot(height, nil)
+	if err != nil {
+		return fmt.Errorf("%w (height: %d): %w", EspressoFetchMerkleRootErr, height, err)
+	}
+	if expected.Height != snapshot.Height || !expected.Root.Equals(snapshot.Root) {
+		return fmt.Errorf("%w: block merkle root mismatch (height: %d, root height: %d, light client root height: %d)", ErrEspressoHeaderMismatch, height, snapshot.Height, expected.Height)
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package arbnode
+
+import (
+	"errors"
+	"testing"
+
+	espressoTypes "github.com/EspressoSystems/espresso-sequencer-go/types"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+)
+
+type mockLightClientReader struct {
+	validatedHeight uint64
+	snapshot        espressoTypes.BlockMerkleSnapshot
+}
+
+func (m *mockLightClientReader) ValidatedHeight() (uint64, uint64, error) {
+	return m.validatedHeight, 100, nil
+}
+
+func (m *mockLightClientReader) FetchMerkleRoot(hotShotHeight uint64, opts *bind.CallOpts) (espressoTypes.BlockMerkleSnapshot, error) {
+	return m.snapshot, nil
+}
+
+func (m *mockLightClientReader) IsHotShotLive(delayThreshold uint64) (bool, error) {
+	return true, nil
+}
+
+func (m *mockLightClientReader) IsHotShotLiveAtHeight(height, delayThreshold uint64) (bool, error) {
+	return true, nil
+}
+
+func TestVerifyEspressoHeader(t *testing.T) {
+	config := TestTransactionStreamerConfig
+	config.Espresso.HeaderVerification.Enable = true
+	reader := &mockLightClientReader{validatedHeight: 20}
+	streamer := &TransactionStreamer{
+		config:            func() *TransactionStreamerConfig { return &config },
+		lightClientReader: reader,
+	}
+	header := espressoTypes.HeaderImpl{Header: &espressoTypes.Header0_1{Height: 10}}
+	snapshot := espressoTypes.BlockMerkleSnapshot{Root: espressoTypes.Commitment{1}, Height: 15}
+
+	Require(t, streamer.verifyEspressoHeader(10, header, snapshot))
+	if err := streamer.verifyEspressoHeader(11, header, snapshot); !errors.Is(err, ErrEspressoHeaderMismatch) {
+		Fail(t, "expected a header mismatch, got", err)
+	}
+
+	// A root the light client hasn't finalized yet
+	reader.validatedHeight = 14
+	if err := streamer.verifyEspressoHeader(10, header, snapshot); !errors.Is(err, ErrEspressoHeaderNotFinalized) {
+		Fail(t, "expected an unfinalized header, got", err)
+	}
+
+	// A separately configured light client must agree on the block merkle root
+	verifier := &mockLightClientReader{validatedHeight: 20, snapshot: snapshot}
+	streamer.espressoHeaderVerifier = verifier
+	Require(t, streamer.verifyEspressoHeader(10, header, snapshot))
+	verifier.snapshot.Root = espressoTypes.Commitment{2}
+	if err := streamer.verifyEspressoHeader(10, header, snapshot); !errors.Is(err, ErrEspressoHeaderMismatch) {
+		Fail(t, "expected a root mismatch, got", err)
+	}
+
+	config.Espresso.HeaderVerification.Enable = false
+	Require(t, streamer.verifyEspressoHeader(11, header, snapshot))
+}
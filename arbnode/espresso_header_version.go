@@ -0,0 +1,97 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package arbnode
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	espressoTypes "github.com/EspressoSystems/espresso-sequencer-go/types"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+var (
+	ErrEspressoHeaderVersionUnsupported = errors.New("unsupported espresso header version")
+
+	// The version of the last header justified, as major<<16 | minor
+	espressoHeaderVersionGauge = metrics.NewRegisteredGauge("arb/espresso/header/version", nil)
+)
+
+func espressoHeaderVersionString(version espressoTypes.Version) string {
+	return fmt.Sprintf("%d.%d", version.Major, version.Minor)
+}
+
+func packEspressoHeaderVersion(version espressoTypes.Version) uint32 {
+	return uint32(version.Major)<<16 | uint32(version.Minor)
+}
+
+// parseEspressoHeaderVersion parses a "major.minor" header version, "" being the zero version
+func parseEspressoHeaderVersion(s string) (espressoTypes.Version, error) {
+	if s == "" {
+		return espressoTypes.Version{}, nil
+	}
+	majorStr, minorStr, ok := strings.Cut(s, ".")
+	if !ok {
+		return espressoTypes.Version{}, fmt.Errorf("invalid espresso header version %q, expected major.minor", s)
+	}
+	major, err := strconv.ParseUint(majorStr, 10, 16)
+	if err != nil {
+		return espressoTypes.Version{}, fmt.Errorf("invalid espresso header major version %q: %w", majorStr, err)
+	}
+	minor, err := strconv.ParseUint(minorStr, 10, 16)
+	if err != nil {
+		return espressoTypes.Version{}, fmt.Errorf("invalid espresso header minor version %q: %w", minorStr, err)
+	}
+	// #nosec G115
+	return espressoTypes.Version{Major: uint16(major), Minor: uint16(minor)}, nil
+}
+
+// checkEspressoHeaderVersion checks that a header of the given version has a justification codec, and isn't older
+// than the minimum accepted version
+func checkEspressoHeaderVersion(codecs map[espressoTypes.Version]EspressoJustificationCodec, version espressoTypes.Version, minVersion espressoTypes.Version) error {
+	if _, ok := codecs[version]; !ok {
+		return fmt.Errorf("%w %s", ErrEspressoHeaderVersionUnsupported, espressoHeaderVersionString(version))
+	}
+	if packEspressoHeaderVersion(version) < packEspressoHeaderVersion(minVersion) {
+		return fmt.Errorf("%w %s, the minimum accepted version is %s", ErrEspressoHeaderVersionUnsupported, espressoHeaderVersionString(version), espressoHeaderVersionString(minVersion))
+	}
+	return nil
+}
+
+// checkEspressoHeaderVersions checks the versions of the headers a justification is built from, as detected from the
+// headers returned by the query service, and logs HotShot upgrades to a new header version
+func (s *TransactionStreamer) checkEspressoHeaderVersions(headers ...espressoTypes.HeaderImpl) error {
+	// Validated with the config
+	minVersion, _ := parseEspressoHeaderVersion(s.config().Espresso.MinHeaderVersion)
+	codecs := s.espressoJustificationCodecsByVersion()
+	for _, header := range headers {
+		if header.Header == nil {
+			return fmt.Errorf("%w: missing header", ErrEspressoHeaderVersionUnsupported)
+		}
+		if err := checkEspressoHeaderVersion(codecs, header.Header.Version(), minVersion); err != nil {
+			return fmt.Errorf("%w (height: %d)", err, header.Header.GetBlockHeight())
+		}
+	}
+	version := headers[0].Header.Version()
+	packed := packEspressoHeaderVersion(version)
+	if previous := s.espressoHeaderVersion.Swap(packed); previous != 0 && previous != packed {
+		// #nosec G115
+		from := espressoTypes.Version{Major: uint16(previous >> 16), Minor: uint16(previous)}
+		log.Info("espresso header version changed", "from", espressoHeaderVersionString(from), "to", espressoHeaderVersionString(version))
+	}
+	espressoHeaderVersionGauge.Update(int64(packed))
+	return nil
+}
+
+// HeaderVersion returns the version of the justification's header, false for justifications written before the
+// version was kept
+func (j *EspressoJustification) HeaderVersion() (espressoTypes.Version, bool) {
+	if j.HeaderMajorVersion == 0 && j.HeaderMinorVersion == 0 {
+		return espressoTypes.Version{}, false
+	}
+	return espressoTypes.Version{Major: j.HeaderMajorVersion, Minor: j.HeaderMinorVersion}, true
+}
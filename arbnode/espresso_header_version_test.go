@@ -0,0 +1,85 @@
+package arbnode
+
+import (
+	"errors"
+	"testing"
+
+	espressoTypes "github.com/EspressoSystems/espresso-sequencer-go/types"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+func TestEspressoHeaderVersionCheck(t *testing.T) {
+	minVersion, err := parseEspressoHeaderVersion("0.2")
+	Require(t, err)
+	if minVersion != (espressoTypes.Version{Major: 0, Minor: 2}) {
+		Fail(t, "unexpected parsed version", minVersion)
+	}
+	for _, invalid := range []string{"1", "a.b", "0.70000"} {
+		if _, err := parseEspressoHeaderVersion(invalid); err == nil {
+			Fail(t, "invalid version accepted", invalid)
+		}
+	}
+
+	// Headers of every supported version from the minimum one are accepted side by side
+	Require(t, checkEspressoHeaderVersion(defaultEspressoJustificationCodecs, espressoTypes.Version{Major: 0, Minor: 2}, minVersion))
+	Require(t, checkEspressoHeaderVersion(defaultEspressoJustificationCodecs, espressoTypes.Version{Major: 0, Minor: 3}, minVersion))
+	Require(t, checkEspressoHeaderVersion(defaultEspressoJustificationCodecs, espressoTypes.Version{Major: 0, Minor: 1}, espressoTypes.Version{}))
+	for _, version := range []espressoTypes.Version{{Major: 0, Minor: 1}, {Major: 0, Minor: 4}, {Major: 1, Minor: 0}} {
+		if err := checkEspressoHeaderVersion(defaultEspressoJustificationCodecs, version, minVersion); !errors.Is(err, ErrEspressoHeaderVersionUnsupported) {
+			Fail(t, "expected ErrEspressoHeaderVersionUnsupported for version", version, "got", err)
+		}
+	}
+}
+
+func TestEspressoJustificationHeaderVersion(t *testing.T) {
+	legacy := struct {
+		HotShotHeight   uint64
+		Header          []byte
+		RootHeight      uint64
+		Proof           []byte
+		BlockMerkleRoot string
+	}{HotShotHeight: 10, Header: []byte("{}"), RootHeight: 11, Proof: []byte("{}"), BlockMerkleRoot: "root"}
+	data, err := rlp.EncodeToBytes(&legacy)
+	Require(t, err)
+	var justification EspressoJustification
+	Require(t, rlp.DecodeBytes(data, &justification))
+	if _, ok := justification.HeaderVersion(); ok || justification.BlockMerkleRoot != "root" {
+		Fail(t, "unexpected legacy justification", justification)
+	}
+
+	justification.HeaderMinorVersion = 3
+	data, err = rlp.EncodeToBytes(&justification)
+	Require(t, err)
+	var decoded EspressoJustification
+	Require(t, rlp.DecodeBytes(data, &decoded))
+	if version, ok := decoded.HeaderVersion(); !ok || version != (espressoTypes.Version{Major: 0, Minor: 3}) {
+		Fail(t, "unexpected header version", version, ok)
+	}
+}
+
+type testJustificationCodec struct {
+	hotShotJustificationCodec
+}
+
+func TestEspressoJustificationCodecRegistry(t *testing.T) {
+	streamer := newTestImportStreamer(t)
+	next := espressoTypes.Version{Major: 0, Minor: 4}
+	if _, err := streamer.espressoJustificationCodec(next); !errors.Is(err, ErrEspressoHeaderVersionUnsupported) {
+		Fail(t, "expected ErrEspressoHeaderVersionUnsupported for an unregistered version, got", err)
+	}
+
+	// Registering the codec of a new header version supports it next to the default ones
+	streamer.SetEspressoJustificationCodec(next, testJustificationCodec{})
+	codecs := streamer.espressoJustificationCodecsByVersion()
+	Require(t, checkEspressoHeaderVersion(codecs, next, espressoTypes.Version{}))
+	Require(t, checkEspressoHeaderVersion(codecs, espressoTypes.Version{Major: 0, Minor: 3}, espressoTypes.Version{}))
+	codec, err := streamer.espressoJustificationCodec(next)
+	Require(t, err)
+	if _, ok := codec.(testJustificationCodec); !ok {
+		Fail(t, "registered codec not used", codec)
+	}
+	// The defaults shared by every streamer are left unchanged
+	if _, ok := defaultEspressoJustificationCodecs[next]; ok {
+		Fail(t, "registering a codec changed the default codecs")
+	}
+}
@@ -0,0 +1,145 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package arbnode
+
+import (
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/rlp"
+
+	"github.com/offchainlabs/nitro/util/dbutil"
+)
+
+var (
+	espressoJournalReplayedCounter   = metrics.NewRegisteredCounter("arb/espresso/journal/replayed", nil)
+	espressoJournalRolledBackCounter = metrics.NewRegisteredCounter("arb/espresso/journal/rolled_back", nil)
+)
+
+// The transitions of the espresso submission state machine that are journaled. Each one updates several of the
+// submitted positions, hash and payload, the pending queue, the last confirmed position, the submission records,
+// the deadlines and the escape hatch records. A reorg drops the espresso state of the reorged messages in the batch
+// removing them, which is applied atomically along with the rest of the reorg and isn't journaled.
+const (
+	espressoIntentSubmit   = "submit"
+	espressoIntentFinalize = "finalize"
+	espressoIntentRequeue  = "requeue"
+	espressoIntentRehash   = "rehash"
+	espressoIntentExpire   = "expire"
+)
+
+func isEspressoIntent(intent string) bool {
+	switch intent {
+	case espressoIntentSubmit, espressoIntentFinalize, espressoIntentRequeue, espressoIntentRehash, espressoIntentExpire:
+		return true
+	default:
+		return false
+	}
+}
+
+type espressoJournalOp struct {
+	Key    []byte
+	Value  []byte
+	Delete bool
+}
+
+// espressoJournalRecord is the intent record written before a multi-key transition of the espresso state
+type espressoJournalRecord struct {
+	Intent string
+	Ops    []espressoJournalOp
+}
+
+// espressoJournalBatch records the writes of a transition, so that they can be journaled before being applied
+type espressoJournalBatch struct {
+	ethdb.Batch
+	ops []espressoJournalOp
+}
+
+func (b *espressoJournalBatch) Put(key []byte, value []byte) error {
+	b.ops = append(b.ops, espressoJournalOp{Key: common.CopyBytes(key), Value: common.CopyBytes(value)})
+	return b.Batch.Put(key, value)
+}
+
+func (b *espressoJournalBatch) Delete(key []byte) error {
+	b.ops = append(b.ops, espressoJournalOp{Key: common.CopyBytes(key), Delete: true})
+	return b.Batch.Delete(key)
+}
+
+func (b *espressoJournalBatch) Reset() {
+	b.ops = nil
+	b.Batch.Reset()
+}
+
+func (s *TransactionStreamer) newEspressoStateBatch() *espressoJournalBatch {
+	return &espressoJournalBatch{Batch: s.db.NewBatch()}
+}
+
+// writeEspressoStateBatch journals the writes of batch as an intent record before applying them, and deletes the
+// record along with them. A record left behind by a crash is replayed on startup, so the transition is never
+// partially applied, even if the keys it touches are written separately by other flows meanwhile. If the writes
+// fail to be applied, the transition is abandoned and its record is rolled back, so that it isn't replayed after
+// the caller went on without it.
+// Must be called while holding the espressoTxnsStateInsertionMutex.
+func (s *TransactionStreamer) writeEspressoStateBatch(batch *espressoJournalBatch, intent string) error {
+	record, err := rlp.EncodeToBytes(espressoJournalRecord{Intent: intent, Ops: batch.ops})
+	if err != nil {
+		return err
+	}
+	if err := s.db.Put(espressoStateJournalKey, record); err != nil {
+		return fmt.Errorf("failed to journal the espresso %s transition: %w", intent, err)
+	}
+	if err := batch.Batch.Delete(espressoStateJournalKey); err != nil {
+		return err
+	}
+	if err := batch.Batch.Write(); err != nil {
+		espressoJournalRolledBackCounter.Inc(1)
+		if rollbackErr := s.db.Delete(espressoStateJournalKey); rollbackErr != nil {
+			return fmt.Errorf("failed to apply the espresso %s transition: %w, and to roll back its journal: %w", intent, err, rollbackErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// replayEspressoStateJournal completes the espresso state transition that was journaled but possibly not applied
+// before the node stopped. A record that can't be decoded or has an unknown intent is rolled back instead, which
+// leaves the state as it was before the transition, as the writes of a transition are applied atomically.
+func (s *TransactionStreamer) replayEspressoStateJournal() error {
+	data, err := s.db.Get(espressoStateJournalKey)
+	if err != nil {
+		if dbutil.IsErrNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	batch := s.db.NewBatch()
+	var record espressoJournalRecord
+	if err := rlp.DecodeBytes(data, &record); err != nil || !isEspressoIntent(record.Intent) {
+		log.Error("rolling back an unknown espresso state transition", "intent", record.Intent, "err", err)
+		espressoJournalRolledBackCounter.Inc(1)
+		return s.db.Delete(espressoStateJournalKey)
+	}
+	for _, op := range record.Ops {
+		if op.Delete {
+			err = batch.Delete(op.Key)
+		} else {
+			err = batch.Put(op.Key, op.Value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := batch.Delete(espressoStateJournalKey); err != nil {
+		return err
+	}
+	if err := batch.Write(); err != nil {
+		return err
+	}
+	espressoJournalReplayedCounter.Inc(1)
+	log.Warn("replayed an interrupted espresso state transition", "intent", record.Intent, "writes", len(record.Ops))
+	return nil
+}
@@ -0,0 +1,74 @@
+package arbnode
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/rlp"
+
+	"github.com/offchainlabs/nitro/arbutil"
+)
+
+func TestEspressoStateJournal(t *testing.T) {
+	streamer := newTestImportStreamer(t)
+	submitted := []arbutil.MessageIndex{3, 4}
+	confirmed := arbutil.MessageIndex(2)
+	journaled := func(intent string) []byte {
+		batch := streamer.newEspressoStateBatch()
+		Require(t, streamer.setEspressoSubmittedPos(batch, submitted))
+		Require(t, streamer.setEspressoLastConfirmedPos(batch, &confirmed))
+		record, err := rlp.EncodeToBytes(espressoJournalRecord{Intent: intent, Ops: batch.ops})
+		Require(t, err)
+		return record
+	}
+	checkState := func(applied bool) {
+		t.Helper()
+		pos, err := streamer.getEspressoSubmittedPos()
+		Require(t, err)
+		lastConfirmed, err := streamer.getLastConfirmedPos()
+		Require(t, err)
+		if applied != (len(pos) == 2 && lastConfirmed != nil && *lastConfirmed == confirmed) {
+			Fail(t, "unexpected espresso state, transition applied:", applied, pos, lastConfirmed)
+		}
+		if has, err := streamer.db.Has(espressoStateJournalKey); err != nil || has {
+			Fail(t, "journal record left behind", err)
+		}
+	}
+
+	// The node stopped after journaling the transition but before applying it
+	Require(t, streamer.db.Put(espressoStateJournalKey, journaled("unknown")))
+	Require(t, streamer.replayEspressoStateJournal())
+	checkState(false)
+	Require(t, streamer.db.Put(espressoStateJournalKey, journaled(espressoIntentSubmit)))
+	Require(t, streamer.replayEspressoStateJournal())
+	checkState(true)
+
+	// Replaying is a no-op without a journal record
+	Require(t, streamer.replayEspressoStateJournal())
+	checkState(true)
+
+	batch := streamer.newEspressoStateBatch()
+	Require(t, streamer.setEspressoSubmittedPos(batch, nil))
+	Require(t, streamer.writeEspressoStateBatch(batch, espressoIntentFinalize))
+	pos, err := streamer.getEspressoSubmittedPos()
+	Require(t, err)
+	if len(pos) != 0 {
+		Fail(t, "journaled transition not applied", pos)
+	}
+	if has, err := streamer.db.Has(espressoStateJournalKey); err != nil || has {
+		Fail(t, "journal record not deleted with the transition", err)
+	}
+
+	// A transition that fails to be applied is abandoned, it's not replayed on the next startup
+	db := streamer.db
+	streamer.db = failingBatchDatabase{db}
+	batch = streamer.newEspressoStateBatch()
+	Require(t, streamer.setEspressoSubmittedPos(batch, submitted))
+	if err := streamer.writeEspressoStateBatch(batch, espressoIntentSubmit); !errors.Is(err, errTestBatchWrite) {
+		Fail(t, "failed transition not returned", err)
+	}
+	streamer.db = db
+	if has, err := streamer.db.Has(espressoStateJournalKey); err != nil || has {
+		Fail(t, "journal record of an abandoned transition not rolled back", err)
+	}
+}
@@ -0,0 +1,262 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package arbnode
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	tagged_base64 "github.com/EspressoSystems/espresso-sequencer-go/tagged-base64"
+	espressoTypes "github.com/EspressoSystems/espresso-sequencer-go/types"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/rlp"
+
+	"github.com/offchainlabs/nitro/arbutil"
+	"github.com/offchainlabs/nitro/util/dbutil"
+)
+
+var espressoJustificationBackfillGauge = metrics.NewRegisteredGauge("arb/espresso/justification/backfill_pos", nil)
+
+const (
+	// Maximum number of messages looked at in one iteration of the justification backfill
+	espressoJustificationBackfillBatch = 100
+	// How long to wait before checking again whether the justification backfill was enabled
+	espressoJustificationBackfillDisabledInterval = time.Minute
+)
+
+// EspressoJustification proves that a message was finalized by HotShot: the header of the HotShot block the
+// message was included in, and a block merkle proof of that header against the light client's block merkle
+// root at RootHeight.
+type EspressoJustification struct {
+	HotShotHeight uint64
+	Header        []byte // JSON encoded HotShot header
+	RootHeight    uint64
+	Proof         []byte // JSON encoded block merkle proof
+	// Tagged base64 block merkle root of the header at RootHeight, empty in justifications written before it was kept
+	BlockMerkleRoot string `rlp:"optional"`
+	// Version of the header, zero in justifications written before it was kept
+	HeaderMajorVersion uint16 `rlp:"optional"`
+	HeaderMinorVersion uint16 `rlp:"optional"`
+}
+
+func (s *TransactionStreamer) setEspressoJustification(batch ethdb.KeyValueWriter, pos arbutil.MessageIndex, justification *EspressoJustification) error {
+	data, err := rlp.EncodeToBytes(justification)
+	if err != nil {
+		return err
+	}
+	if err := batch.Put(dbKey(espressoJustificationPrefix, uint64(pos)), data); err != nil {
+		return err
+	}
+	return writeEspressoBlockLookup(batch, pos, justification.HotShotHeight)
+}
+
+// GetEspressoJustification returns the justification of the message at pos, or nil if it has none.
+// Messages sequenced through the escape hatch or before the migration to espresso never have one.
+func (s *TransactionStreamer) GetEspressoJustification(pos arbutil.MessageIndex) (*EspressoJustification, error) {
+	data, err := s.db.Get(dbKey(espressoJustificationPrefix, uint64(pos)))
+	if err != nil {
+		if dbutil.IsErrNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var justification EspressoJustification
+	if err := rlp.DecodeBytes(data, &justification); err != nil {
+		return nil, err
+	}
+	return &justification, nil
+}
+
+// fetchEspressoJustification fetches the block merkle proof of the HotShot block at height, and verifies it
+// against the light client's block merkle root.
+func (s *TransactionStreamer) fetchEspressoJustification(ctx context.Context, height uint64, header espressoTypes.HeaderImpl) (*EspressoJustification, error) {
+	snapshot, err := s.lightClientReader.FetchMerkleRoot(height, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w (height: %d): %w", EspressoFetchMerkleRootErr, height, err)
+	}
+
+	if snapshot.Height <= height {
+		return nil, errors.New("snapshot height is less than or equal to transaction height")
+	}
+
+	nextHeader, err := s.fetchEspressoHeader(ctx, snapshot.Height)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching the snapshot header (height: %d): %w", snapshot.Height, err)
+	}
+	// The headers may be of different versions while HotShot is upgraded
+	if err := s.checkEspressoHeaderVersions(header, nextHeader); err != nil {
+		return nil, err
+	}
+
+	proof, err := s.espressoClient.FetchBlockMerkleProof(ctx, snapshot.Height, height)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching the block merkle proof (height: %d, root height: %d): %w", height, snapshot.Height, err)
+	}
+
+	// The header is encoded and proven in its own version
+	version := header.Header.Version()
+	codec, err := s.espressoJustificationCodec(version)
+	if err != nil {
+		return nil, err
+	}
+	blockMerkleTreeRoot := nextHeader.Header.GetBlockMerkleTreeRoot()
+	jstHeader, err := codec.EncodeHeader(header)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode the header: %w", err)
+	}
+
+	if !codec.VerifyProof(proof.Proof, jstHeader, *blockMerkleTreeRoot, snapshot.Root) {
+		err := fmt.Errorf("%w (height: %d, snapshot height: %d)", ErrEspressoMerkleProofInvalid, height, snapshot.Height)
+		s.recordEspressoVerificationFailure(espressoCheckMerkleProof, err)
+		return nil, err
+	}
+	if err := s.verifyEspressoHeader(height, header, snapshot); err != nil {
+		return nil, err
+	}
+	s.recordEspressoVerificationSuccess()
+	return &EspressoJustification{
+		HotShotHeight: height,
+		Header:        jstHeader,
+		RootHeight:    snapshot.Height,
+		Proof:         proof.Proof,
+		// The light client commits to the root, so the justification can be verified without a query service
+		BlockMerkleRoot:    blockMerkleTreeRoot.String(),
+		HeaderMajorVersion: version.Major,
+		HeaderMinorVersion: version.Minor,
+	}, nil
+}
+
+func (s *TransactionStreamer) fetchEspressoJustificationForTx(ctx context.Context, txHash string) (*EspressoJustification, error) {
+	hash, err := tagged_base64.Parse(txHash)
+	if err != nil {
+		return nil, err
+	}
+	data, err := s.espressoClient.FetchTransactionByHash(ctx, hash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch the transaction (hash: %s): %w", txHash, err)
+	}
+	header, err := s.fetchEspressoHeader(ctx, data.BlockHeight)
+	if err != nil {
+		return nil, fmt.Errorf("could not get the header (height: %d): %w", data.BlockHeight, err)
+	}
+	return s.fetchEspressoJustification(ctx, data.BlockHeight, header)
+}
+
+// getEspressoJustificationBackfillPos returns the position the justification backfill continues from
+func (s *TransactionStreamer) getEspressoJustificationBackfillPos() (arbutil.MessageIndex, error) {
+	data, err := s.db.Get(espressoBackfillPos)
+	if err != nil {
+		if dbutil.IsErrNotFound(err) {
+			return s.espressoMigrationActivationPos(), nil
+		}
+		return 0, err
+	}
+	var pos arbutil.MessageIndex
+	if err := rlp.DecodeBytes(data, &pos); err != nil {
+		return 0, err
+	}
+	return pos, nil
+}
+
+func (s *TransactionStreamer) setEspressoJustificationBackfillPos(batch ethdb.KeyValueWriter, pos arbutil.MessageIndex) error {
+	data, err := rlp.EncodeToBytes(pos)
+	if err != nil {
+		return err
+	}
+	return batch.Put(espressoBackfillPos, data)
+}
+
+// backfillEspressoJustifications stores justifications for confirmed messages that were finalized before
+// justifications were kept. Messages are looked at in order, and the next position is checkpointed after
+// every iteration, so the backfill continues where it left off after a restart.
+func (s *TransactionStreamer) backfillEspressoJustifications(ctx context.Context) time.Duration {
+	interval := s.config().Espresso.JustificationBackfillInterval
+	if interval == 0 {
+		return espressoJustificationBackfillDisabledInterval
+	}
+	lastConfirmed, err := s.getLastConfirmedPos()
+	if err != nil {
+		log.Warn("justification backfill failed to get the last confirmed position", "err", err)
+		return interval
+	}
+	if lastConfirmed == nil {
+		return interval
+	}
+	start, err := s.getEspressoJustificationBackfillPos()
+	if err != nil {
+		log.Warn("justification backfill failed to get its checkpoint", "err", err)
+		return interval
+	}
+	if start > *lastConfirmed {
+		return interval
+	}
+	end := min(*lastConfirmed+1, start+espressoJustificationBackfillBatch)
+
+	// Messages submitted in the same transaction share a justification
+	justifications := make(map[string]*EspressoJustification)
+	batch := s.db.NewBatch()
+	backfilled := 0
+	pos := start
+	for ; pos < end; pos++ {
+		has, err := s.db.Has(dbKey(espressoJustificationPrefix, uint64(pos)))
+		if err != nil {
+			log.Warn("justification backfill failed to read the justification", "pos", pos, "err", err)
+			break
+		}
+		if has {
+			continue
+		}
+		record, err := s.GetEspressoSubmissionRecord(pos)
+		if err != nil {
+			log.Warn("justification backfill failed to read the submission record", "pos", pos, "err", err)
+			break
+		}
+		if record == nil || record.Status != EspressoSubmissionFinalized || record.TxHash == "" {
+			// Sequenced through the escape hatch, or confirmed before submission records were kept
+			continue
+		}
+		justification, ok := justifications[record.TxHash]
+		if !ok {
+			justification, err = s.fetchEspressoJustificationForTx(ctx, record.TxHash)
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Warn("justification backfill failed to fetch the justification", "pos", pos, "err", err)
+				}
+				break
+			}
+			justifications[record.TxHash] = justification
+		}
+		if err := s.setEspressoJustification(batch, pos, justification); err != nil {
+			log.Warn("justification backfill failed to store the justification", "pos", pos, "err", err)
+			break
+		}
+		backfilled++
+	}
+	if pos == start {
+		return interval
+	}
+	if err := s.setEspressoJustificationBackfillPos(batch, pos); err != nil {
+		log.Warn("justification backfill failed to store its checkpoint", "err", err)
+		return interval
+	}
+	if err := batch.Write(); err != nil {
+		log.Warn("justification backfill failed to write to db", "err", err)
+		return interval
+	}
+	// #nosec G115
+	espressoJustificationBackfillGauge.Update(int64(pos))
+	if backfilled > 0 {
+		log.Info("backfilled espresso justifications", "from", start, "to", pos, "count", backfilled)
+		s.updateEspressoJustifiedWatermark()
+	}
+	if pos < end {
+		// Stopped on an error
+		return interval
+	}
+	return 0
+}
@@ -0,0 +1,170 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package arbnode
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/rlp"
+
+	"github.com/offchainlabs/nitro/arbutil"
+	m "github.com/offchainlabs/nitro/broadcaster/message"
+	"github.com/offchainlabs/nitro/util/dbutil"
+)
+
+var (
+	espressoJustificationRevalidationGauge = metrics.NewRegisteredGauge("arb/espresso/justification/revalidation_pos", nil)
+	espressoJustificationRewrittenCounter  = metrics.NewRegisteredCounter("arb/espresso/justification/rewritten", nil)
+)
+
+// Maximum number of messages looked at in one iteration of the justification revalidation
+const espressoJustificationRevalidationBatch = 100
+
+// getEspressoRevalidationPos returns the position below which every justification was validated against a block
+// merkle root finalized on L1
+func (s *TransactionStreamer) getEspressoRevalidationPos() (arbutil.MessageIndex, error) {
+	data, err := s.db.Get(espressoRevalidationPos)
+	if err != nil {
+		if dbutil.IsErrNotFound(err) {
+			return s.espressoMigrationActivationPos(), nil
+		}
+		return 0, err
+	}
+	var pos arbutil.MessageIndex
+	if err := rlp.DecodeBytes(data, &pos); err != nil {
+		return 0, err
+	}
+	return pos, nil
+}
+
+func (s *TransactionStreamer) setEspressoRevalidationPos(batch ethdb.KeyValueWriter, pos arbutil.MessageIndex) error {
+	data, err := rlp.EncodeToBytes(pos)
+	if err != nil {
+		return err
+	}
+	return batch.Put(espressoRevalidationPos, data)
+}
+
+// revalidateEspressoJustifications checks the stored justifications again once the light client root they're
+// proven against is finalized on L1. A justification written while HotShot's light client state wasn't finalized
+// may be invalidated by a HotShot reorg, in which case it's fetched again and rewritten. Messages are looked at in
+// order, and the position up to which every justification was validated is checkpointed.
+func (s *TransactionStreamer) revalidateEspressoJustifications(ctx context.Context) time.Duration {
+	interval := s.config().Espresso.JustificationRevalidationInterval
+	if interval == 0 {
+		return espressoJustificationBackfillDisabledInterval
+	}
+	lastConfirmed, err := s.getLastConfirmedPos()
+	if err != nil {
+		log.Warn("justification revalidation failed to get the last confirmed position", "err", err)
+		return interval
+	}
+	if lastConfirmed == nil {
+		return interval
+	}
+	start, err := s.getEspressoRevalidationPos()
+	if err != nil {
+		log.Warn("justification revalidation failed to get its checkpoint", "err", err)
+		return interval
+	}
+	if start > *lastConfirmed {
+		return interval
+	}
+	end := min(*lastConfirmed+1, start+espressoJustificationRevalidationBatch)
+
+	validated := make(map[uint64]bool)
+	batch := s.db.NewBatch()
+	// The justification invalidated by a reorg, shared by the messages submitted in the same transaction, and the
+	// one it's replaced with
+	var invalidated, replacement *EspressoJustification
+	rewritten := 0
+	pos := start
+	for ; pos < end; pos++ {
+		justification, err := s.GetEspressoJustification(pos)
+		if err != nil {
+			log.Warn("justification revalidation failed to read the justification", "pos", pos, "err", err)
+			break
+		}
+		if invalidated != nil {
+			if justification == nil || justification.HotShotHeight != invalidated.HotShotHeight || justification.RootHeight != invalidated.RootHeight {
+				break
+			}
+		} else {
+			// Justifications written before the block merkle root was kept can't be verified on their own
+			if justification == nil || justification.BlockMerkleRoot == "" || validated[justification.HotShotHeight] {
+				continue
+			}
+			err = s.verifyEspressoJustificationProof(justification.HotShotHeight, &m.EspressoFeedJustification{
+				Header:          justification.Header,
+				RootHeight:      justification.RootHeight,
+				BlockMerkleRoot: justification.BlockMerkleRoot,
+				Proof:           justification.Proof,
+			})
+			if err == nil {
+				validated[justification.HotShotHeight] = true
+				continue
+			}
+			if errors.Is(err, ErrEspressoHeaderNotFinalized) {
+				// Revalidated once the light client finalized the root on L1
+				break
+			}
+			if !errors.Is(err, ErrEspressoFeedJustificationInvalid) && !errors.Is(err, ErrEspressoHeaderMismatch) {
+				log.Warn("justification revalidation failed to verify the justification", "pos", pos, "err", err)
+				break
+			}
+			record, err := s.GetEspressoSubmissionRecord(pos)
+			if err != nil || record == nil || record.TxHash == "" {
+				log.Error("justification invalidated by a hotshot reorg, but the transaction of the message is unknown", "pos", pos, "height", justification.HotShotHeight, "err", err)
+				break
+			}
+			replacement, err = s.fetchEspressoJustificationForTx(ctx, record.TxHash)
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Error("justification invalidated by a hotshot reorg, failed to fetch it again", "pos", pos, "height", justification.HotShotHeight, "txHash", record.TxHash, "err", err)
+				}
+				break
+			}
+			invalidated = justification
+			log.Warn("rewriting the espresso justification invalidated by a hotshot reorg", "pos", pos, "height", justification.HotShotHeight, "newHeight", replacement.HotShotHeight)
+		}
+		if err := deleteEspressoBlockLookup(batch, pos, invalidated.HotShotHeight); err != nil {
+			log.Warn("justification revalidation failed to update the espresso block index", "pos", pos, "err", err)
+			break
+		}
+		if err := s.setEspressoJustification(batch, pos, replacement); err != nil {
+			log.Warn("justification revalidation failed to store the justification", "pos", pos, "err", err)
+			break
+		}
+		rewritten++
+	}
+	if rewritten > 0 {
+		// The rewritten justifications are validated in a later iteration, once their root is finalized
+		// #nosec G115
+		pos -= arbutil.MessageIndex(rewritten)
+	}
+	if pos == start && rewritten == 0 {
+		return interval
+	}
+	if err := s.setEspressoRevalidationPos(batch, pos); err != nil {
+		log.Warn("justification revalidation failed to store its checkpoint", "err", err)
+		return interval
+	}
+	if err := batch.Write(); err != nil {
+		log.Warn("justification revalidation failed to write to db", "err", err)
+		return interval
+	}
+	espressoJustificationRewrittenCounter.Inc(int64(rewritten))
+	// #nosec G115
+	espressoJustificationRevalidationGauge.Update(int64(pos))
+	if pos < end {
+		// Waiting for a root to be finalized, or stopped on an error
+		return interval
+	}
+	return 0
+}
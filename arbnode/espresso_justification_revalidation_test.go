@@ -0,0 +1,75 @@
+package arbnode
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/rlp"
+
+	"github.com/offchainlabs/nitro/arbutil"
+)
+
+func TestEspressoJustificationRevalidationCheckpoint(t *testing.T) {
+	ctx := context.Background()
+	config := TestTransactionStreamerConfig
+	config.Espresso.JustificationRevalidationInterval = time.Second
+	streamer := &TransactionStreamer{
+		db:                rawdb.NewMemoryDatabase(),
+		config:            func() *TransactionStreamerConfig { return &config },
+		lightClientReader: &mockLightClientReader{validatedHeight: 100},
+	}
+	setLastConfirmed := func(pos arbutil.MessageIndex) {
+		batch := streamer.db.NewBatch()
+		Require(t, streamer.setEspressoLastConfirmedPos(batch, &pos))
+		Require(t, batch.Write())
+	}
+	expectCheckpoint := func(expected arbutil.MessageIndex) {
+		t.Helper()
+		pos, err := streamer.getEspressoRevalidationPos()
+		Require(t, err)
+		if pos != expected {
+			Fail(t, "unexpected revalidation checkpoint", pos, "expected", expected)
+		}
+	}
+
+	// Justifications written before the block merkle root was kept are skipped
+	legacy := &EspressoJustification{HotShotHeight: 10, Header: []byte("{}"), RootHeight: 11, Proof: []byte("{}")}
+	batch := streamer.db.NewBatch()
+	Require(t, streamer.setEspressoJustification(batch, 2, legacy))
+	Require(t, batch.Write())
+	setLastConfirmed(5)
+	if delay := streamer.revalidateEspressoJustifications(ctx); delay != 0 {
+		Fail(t, "expected the revalidation to continue immediately, got", delay)
+	}
+	expectCheckpoint(6)
+	if delay := streamer.revalidateEspressoJustifications(ctx); delay != time.Second {
+		Fail(t, "expected the revalidation to wait for new confirmed messages, got", delay)
+	}
+
+	// An invalidated justification that can't be fetched again keeps the checkpoint in place, and isn't rewritten
+	invalid := &EspressoJustification{HotShotHeight: 20, Header: []byte("{}"), RootHeight: 21, Proof: []byte("{}"), BlockMerkleRoot: "reorged"}
+	batch = streamer.db.NewBatch()
+	Require(t, streamer.setEspressoJustification(batch, 6, invalid))
+	Require(t, batch.Write())
+	record, err := rlp.EncodeToBytes(EspressoSubmissionRecord{Status: EspressoSubmissionFinalized, TxHash: "invalid"})
+	Require(t, err)
+	Require(t, streamer.db.Put(dbKey(espressoSubmissionPrefix, 6), record))
+	setLastConfirmed(7)
+	if delay := streamer.revalidateEspressoJustifications(ctx); delay != time.Second {
+		Fail(t, "expected the revalidation to retry later, got", delay)
+	}
+	expectCheckpoint(6)
+	stored, err := streamer.GetEspressoJustification(6)
+	Require(t, err)
+	if stored == nil || stored.HotShotHeight != 20 || !bytes.Equal(stored.Header, invalid.Header) {
+		Fail(t, "unexpected justification", stored)
+	}
+
+	config.Espresso.JustificationRevalidationInterval = 0
+	if delay := streamer.revalidateEspressoJustifications(ctx); delay != espressoJustificationBackfillDisabledInterval {
+		Fail(t, "expected the disabled revalidation to wait, got", delay)
+	}
+}
@@ -0,0 +1,65 @@
+package arbnode
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/rlp"
+
+	"github.com/offchainlabs/nitro/arbutil"
+)
+
+func TestEspressoJustificationBackfillCheckpoint(t *testing.T) {
+	ctx := context.Background()
+	config := TestTransactionStreamerConfig
+	config.Espresso.JustificationBackfillInterval = time.Second
+	streamer := &TransactionStreamer{
+		db:     rawdb.NewMemoryDatabase(),
+		config: func() *TransactionStreamerConfig { return &config },
+	}
+	setLastConfirmed := func(pos arbutil.MessageIndex) {
+		batch := streamer.db.NewBatch()
+		Require(t, streamer.setEspressoLastConfirmedPos(batch, &pos))
+		Require(t, batch.Write())
+	}
+
+	// Messages that already have a justification, or were never finalized through espresso, are skipped
+	// without fetching anything from HotShot
+	justification := &EspressoJustification{HotShotHeight: 10, Header: []byte("{}"), RootHeight: 11, Proof: []byte("{}")}
+	batch := streamer.db.NewBatch()
+	Require(t, streamer.setEspressoJustification(batch, 2, justification))
+	Require(t, batch.Write())
+	setLastConfirmed(5)
+	if delay := streamer.backfillEspressoJustifications(ctx); delay != 0 {
+		Fail(t, "expected the backfill to continue immediately, got", delay)
+	}
+	pos, err := streamer.getEspressoJustificationBackfillPos()
+	Require(t, err)
+	if pos != 6 {
+		Fail(t, "unexpected backfill checkpoint", pos)
+	}
+	if delay := streamer.backfillEspressoJustifications(ctx); delay != time.Second {
+		Fail(t, "expected the backfill to wait for new confirmed messages, got", delay)
+	}
+	stored, err := streamer.GetEspressoJustification(2)
+	Require(t, err)
+	if stored == nil || stored.HotShotHeight != 10 || stored.RootHeight != 11 {
+		Fail(t, "unexpected justification", stored)
+	}
+
+	// A finalized message whose justification can't be fetched keeps the checkpoint in place
+	record, err := rlp.EncodeToBytes(EspressoSubmissionRecord{Status: EspressoSubmissionFinalized, TxHash: "invalid"})
+	Require(t, err)
+	Require(t, streamer.db.Put(dbKey(espressoSubmissionPrefix, 6), record))
+	setLastConfirmed(7)
+	if delay := streamer.backfillEspressoJustifications(ctx); delay != time.Second {
+		Fail(t, "expected the backfill to retry later, got", delay)
+	}
+	pos, err = streamer.getEspressoJustificationBackfillPos()
+	Require(t, err)
+	if pos != 6 {
+		Fail(t, "backfill checkpoint moved past a missing justification", pos)
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package arbnode
+
+import (
+	"context"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+
+	"github.com/offchainlabs/nitro/arbutil"
+)
+
+var espressoJustifiedWatermarkGauge = metrics.NewRegisteredGauge("arb/espresso/justified_watermark", nil)
+
+const (
+	// Maximum number of messages the justified watermark is advanced by in one step
+	espressoJustifiedWatermarkScanBatch = 1000
+	// Interval between the steps catching the watermark up on startup, after an error
+	espressoJustifiedWatermarkRetryInterval = time.Second
+)
+
+// The justified watermark is the position of the first message sequenced through espresso without a complete
+// justification: every message before it was either sequenced before the migration to espresso, through the
+// escape hatch, or has the block merkle proof of its HotShot block stored. It's kept in memory, caught up from
+// the migration activation position or the snap sync position on startup, advanced when justifications or
+// escape hatch records are written, and moved back by reorgs.
+
+// espressoJustifiedWatermarkBase returns the lowest the watermark can be with count messages: the messages
+// sequenced before the migration to espresso, or before the snapshot the node was synced from, don't need a
+// justification. The caller must hold the espressoWatermarkMutex.
+func (s *TransactionStreamer) espressoJustifiedWatermarkBase(count arbutil.MessageIndex) arbutil.MessageIndex {
+	return min(max(s.espressoMigrationActivationPos(), s.espressoSnapSyncPos), count)
+}
+
+// advanceEspressoJustifiedWatermark advances the watermark from where it was left, by at most
+// espressoJustifiedWatermarkScanBatch messages, and returns it with whether it may advance further
+func (s *TransactionStreamer) advanceEspressoJustifiedWatermark() (arbutil.MessageIndex, bool, error) {
+	s.espressoWatermarkMutex.Lock()
+	defer s.espressoWatermarkMutex.Unlock()
+	count, err := s.GetMessageCount()
+	if err != nil {
+		return 0, false, err
+	}
+	pos := max(s.espressoWatermark, s.espressoJustifiedWatermarkBase(count))
+	end := min(count, pos+espressoJustifiedWatermarkScanBatch)
+	for ; pos < end; pos++ {
+		justified, err := s.db.Has(dbKey(espressoJustificationPrefix, uint64(pos)))
+		if err != nil {
+			return 0, false, err
+		}
+		if justified {
+			continue
+		}
+		escaped, err := s.IsEscapeHatchMessage(pos)
+		if err != nil {
+			return 0, false, err
+		}
+		if !escaped {
+			break
+		}
+	}
+	s.espressoWatermark = pos
+	// #nosec G115
+	espressoJustifiedWatermarkGauge.Update(int64(pos))
+	return pos, pos == end && pos < count, nil
+}
+
+// updateEspressoJustifiedWatermark advances the watermark after justifications or escape hatch records were written
+func (s *TransactionStreamer) updateEspressoJustifiedWatermark() {
+	for {
+		_, more, err := s.advanceEspressoJustifiedWatermark()
+		if err != nil {
+			log.Warn("failed to advance the espresso justified watermark", "err", err)
+			return
+		}
+		if !more {
+			return
+		}
+	}
+}
+
+// catchUpEspressoJustifiedWatermark advances the watermark over the messages stored before startup, a step at a time
+func (s *TransactionStreamer) catchUpEspressoJustifiedWatermark(ctx context.Context) {
+	for ctx.Err() == nil {
+		_, more, err := s.advanceEspressoJustifiedWatermark()
+		if err != nil {
+			log.Warn("failed to catch the espresso justified watermark up", "err", err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(espressoJustifiedWatermarkRetryInterval):
+			}
+			continue
+		}
+		if !more {
+			return
+		}
+	}
+}
+
+// EspressoJustifiedWatermark returns the justified watermark, advancing it over the messages written since it was
+// last updated
+func (s *TransactionStreamer) EspressoJustifiedWatermark() (arbutil.MessageIndex, error) {
+	watermark, _, err := s.advanceEspressoJustifiedWatermark()
+	return watermark, err
+}
+
+// MaxEspressoFinalizedIndex returns the highest position up to which every message sequenced through espresso has
+// a complete justification, or nil if there's none. It's read from memory, without looking at the messages.
+func (s *TransactionStreamer) MaxEspressoFinalizedIndex() (*arbutil.MessageIndex, error) {
+	count, err := s.GetMessageCount()
+	if err != nil {
+		return nil, err
+	}
+	s.espressoWatermarkMutex.Lock()
+	watermark := max(s.espressoWatermark, s.espressoJustifiedWatermarkBase(count))
+	s.espressoWatermarkMutex.Unlock()
+	if watermark == 0 {
+		return nil, nil
+	}
+	index := watermark - 1
+	return &index, nil
+}
+
+// resetEspressoJustifiedWatermark moves the watermark back to count after a reorg to count was written,
+// as the messages from count were replaced
+func (s *TransactionStreamer) resetEspressoJustifiedWatermark(count arbutil.MessageIndex) {
+	s.espressoWatermarkMutex.Lock()
+	defer s.espressoWatermarkMutex.Unlock()
+	s.espressoWatermark = min(s.espressoWatermark, count)
+}
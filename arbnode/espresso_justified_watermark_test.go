@@ -0,0 +1,80 @@
+package arbnode
+
+import (
+	"testing"
+
+	"github.com/offchainlabs/nitro/arbos/arbostypes"
+	"github.com/offchainlabs/nitro/arbutil"
+)
+
+func TestEspressoJustifiedWatermark(t *testing.T) {
+	streamer := newTestImportStreamer(t)
+	streamer.config().Espresso.MigrationActivationPos = 1
+	var messages []arbostypes.MessageWithMetadata
+	for i := uint64(0); i < 6; i++ {
+		messages = append(messages, arbostypes.MessageWithMetadata{
+			Message: &arbostypes.L1IncomingMessage{Header: &arbostypes.L1IncomingMessageHeader{Timestamp: i}},
+		})
+	}
+	Require(t, streamer.AddMessages(0, true, messages))
+	expectWatermark := func(expected arbutil.MessageIndex) {
+		t.Helper()
+		watermark, err := streamer.EspressoJustifiedWatermark()
+		Require(t, err)
+		if watermark != expected {
+			Fail(t, "unexpected justified watermark", watermark, "expected", expected)
+		}
+	}
+	// Messages before the migration don't need a justification
+	expectWatermark(1)
+
+	justification := &EspressoJustification{HotShotHeight: 10, Header: []byte("{}"), RootHeight: 11, Proof: []byte("{}")}
+	batch := streamer.db.NewBatch()
+	Require(t, streamer.setEspressoJustification(batch, 1, justification))
+	Require(t, streamer.setEspressoJustification(batch, 3, justification))
+	Require(t, batch.Write())
+	expectWatermark(2)
+	// Messages sequenced through the escape hatch are let through
+	Require(t, streamer.recordEscapeHatchMessage(2))
+	expectWatermark(4)
+	Require(t, streamer.setEspressoJustification(streamer.db, 4, justification))
+	Require(t, streamer.setEspressoJustification(streamer.db, 5, justification))
+	expectWatermark(6)
+
+	streamer.resetEspressoJustifiedWatermark(3)
+	Require(t, streamer.db.Delete(dbKey(espressoJustificationPrefix, 4)))
+	expectWatermark(4)
+}
+
+func TestMaxEspressoFinalizedIndex(t *testing.T) {
+	streamer := newTestImportStreamer(t)
+	expectIndex := func(expected *arbutil.MessageIndex) {
+		t.Helper()
+		index, err := streamer.MaxEspressoFinalizedIndex()
+		Require(t, err)
+		if (index == nil) != (expected == nil) || (index != nil && *index != *expected) {
+			Fail(t, "unexpected max espresso finalized index", index, "expected", expected)
+		}
+	}
+	expectIndex(nil)
+	var messages []arbostypes.MessageWithMetadata
+	for i := uint64(0); i < 4; i++ {
+		messages = append(messages, arbostypes.MessageWithMetadata{
+			Message: &arbostypes.L1IncomingMessage{Header: &arbostypes.L1IncomingMessageHeader{Timestamp: i}},
+		})
+	}
+	Require(t, streamer.AddMessages(0, true, messages))
+	expectIndex(nil)
+
+	// The index follows the escape hatch records and justifications as they're written, without being queried
+	Require(t, streamer.recordEscapeHatchMessage(0))
+	zero := arbutil.MessageIndex(0)
+	expectIndex(&zero)
+	justification := &EspressoJustification{HotShotHeight: 10, Header: []byte("{}"), RootHeight: 11, Proof: []byte("{}")}
+	Require(t, streamer.setEspressoJustification(streamer.db, 1, justification))
+	Require(t, streamer.setEspressoJustification(streamer.db, 2, justification))
+	expectIndex(&zero)
+	streamer.updateEspressoJustifiedWatermark()
+	two := arbutil.MessageIndex(2)
+	expectIndex(&two)
+}
@@ -0,0 +1,152 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package arbnode
+
+import (
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rlp"
+
+	"github.com/offchainlabs/nitro/arbutil"
+	"github.com/offchainlabs/nitro/util/dbutil"
+)
+
+// A chain migrating from centralized sequencing keeps its legacy behavior for all
+// messages before the activation position. Starting at that position, messages are
+// submitted to Espresso and must pass Espresso verification before being batched.
+// The chain owner publishes the activation position in the "espresso" section of the
+// chain config stored in ArbOS before the migration, see EspressoChainParams, so that
+// anyone can read it on-chain. Nodes use the published position over their configured
+// one, and don't start if they're configured with a different position. The node
+// submitting to Espresso doesn't start a migration that isn't published. The position
+// is also recorded in the node's database the first time a message is queued for
+// Espresso submission, and returned by the arb_espressoActivationPos rpc.
+
+func (s *TransactionStreamer) espressoMigrationActivationPos() arbutil.MessageIndex {
+	if published := s.espressoPublishedActivationPos.Load(); published != nil {
+		return *published
+	}
+	return arbutil.MessageIndex(s.config().Espresso.MigrationActivationPos)
+}
+
+// loadPublishedEspressoActivationPos reads the activation position published in the chain config, and checks the
+// configured position against it. A configured position of 0, the default, follows the published one.
+func (s *TransactionStreamer) loadPublishedEspressoActivationPos() error {
+	published, err := s.publishedEspressoActivationPos()
+	if err != nil {
+		return fmt.Errorf("failed to read the espresso activation position from the chain config: %w", err)
+	}
+	if published == nil {
+		return nil
+	}
+	configured := arbutil.MessageIndex(s.config().Espresso.MigrationActivationPos)
+	if configured != 0 && configured != *published {
+		return fmt.Errorf("configured espresso migration activation position %d doesn't match the position %d published in the chain config", configured, *published)
+	}
+	s.espressoPublishedActivationPos.Store(published)
+	return nil
+}
+
+// isEspressoActiveAt returns true if the message at pos is sequenced through Espresso
+func (s *TransactionStreamer) isEspressoActiveAt(pos arbutil.MessageIndex) bool {
+	return pos >= s.espressoMigrationActivationPos()
+}
+
+func (s *TransactionStreamer) getEspressoActivationPos() (*arbutil.MessageIndex, error) {
+	data, err := s.db.Get(espressoActivationPos)
+	if err != nil {
+		if dbutil.IsErrNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var pos arbutil.MessageIndex
+	err = rlp.DecodeBytes(data, &pos)
+	if err != nil {
+		return nil, err
+	}
+	return &pos, nil
+}
+
+func (s *TransactionStreamer) setEspressoActivationPos(batch ethdb.KeyValueWriter, pos arbutil.MessageIndex) error {
+	posBytes, err := rlp.EncodeToBytes(pos)
+	if err != nil {
+		return err
+	}
+	return batch.Put(espressoActivationPos, posBytes)
+}
+
+// recordEspressoActivationIfNeeded persists the activation position the first time a message
+// is queued for Espresso submission, so that it stays fixed for the lifetime of the chain.
+func (s *TransactionStreamer) recordEspressoActivationIfNeeded(batch ethdb.KeyValueWriter) error {
+	recorded, err := s.getEspressoActivationPos()
+	if err != nil {
+		return err
+	}
+	if recorded != nil {
+		return nil
+	}
+	activationPos := s.espressoMigrationActivationPos()
+	log.Info("activating espresso sequencing", "pos", activationPos)
+	return s.setEspressoActivationPos(batch, activationPos)
+}
+
+// GetEspressoActivationPos returns the recorded position from which messages are sequenced through Espresso,
+// or nil if Espresso sequencing hasn't been activated yet.
+func (s *TransactionStreamer) GetEspressoActivationPos() (*arbutil.MessageIndex, error) {
+	return s.getEspressoActivationPos()
+}
+
+// validateEspressoMigration checks that the activation position is published and matches the recorded
+// one, and that no Espresso state exists for messages before it.
+func (s *TransactionStreamer) validateEspressoMigration() error {
+	activationPos := s.espressoMigrationActivationPos()
+	if activationPos > 0 && s.exec != nil && s.espressoPublishedActivationPos.Load() == nil {
+		return fmt.Errorf("espresso migration activation position %d isn't published, the chain owner must set espresso.migrationActivationPos in the chain config first", activationPos)
+	}
+	recorded, err := s.getEspressoActivationPos()
+	if err != nil {
+		return err
+	}
+	if recorded != nil && *recorded != activationPos {
+		return fmt.Errorf("configured espresso migration activation position %d doesn't match the recorded activation position %d", activationPos, *recorded)
+	}
+
+	checkPos := func(name string, pos arbutil.MessageIndex) error {
+		if pos < activationPos {
+			return fmt.Errorf("found espresso %s at position %d before the migration activation position %d", name, pos, activationPos)
+		}
+		return nil
+	}
+	lastConfirmed, err := s.getLastConfirmedPos()
+	if err != nil {
+		return err
+	}
+	if lastConfirmed != nil {
+		if err := checkPos("confirmed message", *lastConfirmed); err != nil {
+			return err
+		}
+	}
+	submitted, err := s.getEspressoSubmittedPos()
+	if err != nil {
+		return err
+	}
+	if len(submitted) > 0 {
+		if err := checkPos("submitted message", submitted[0]); err != nil {
+			return err
+		}
+	}
+	pending, err := s.getEspressoPendingTxnsPos()
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		if err := checkPos("pending message", pending[0]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
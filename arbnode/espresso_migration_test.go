@@ -0,0 +1,99 @@
+package arbnode
+
+import (
+	"testing"
+
+	"github.com/offchainlabs/nitro/arbutil"
+	"github.com/offchainlabs/nitro/execution"
+)
+
+func TestEspressoActivationPosPersisted(t *testing.T) {
+	streamer := newTestImportStreamer(t)
+	streamer.config().Espresso.MigrationActivationPos = 2
+	expectActivation := func(streamer *TransactionStreamer, expected *arbutil.MessageIndex) {
+		t.Helper()
+		pos, err := streamer.GetEspressoActivationPos()
+		Require(t, err)
+		if (pos == nil) != (expected == nil) || (pos != nil && *pos != *expected) {
+			Fail(t, "unexpected espresso activation position", pos, "expected", expected)
+		}
+	}
+
+	// The activation position is recorded when the first message is queued
+	expectActivation(streamer, nil)
+	Require(t, streamer.SubmitEspressoTransactionPos(2, streamer.db.NewBatch()))
+	activation := arbutil.MessageIndex(2)
+	expectActivation(streamer, &activation)
+	// And stays fixed afterwards
+	streamer.config().Espresso.MigrationActivationPos = 3
+	Require(t, streamer.SubmitEspressoTransactionPos(3, streamer.db.NewBatch()))
+	expectActivation(streamer, &activation)
+
+	// It's reloaded by a node restarted on the same database, which must be configured with it
+	reloaded := newTestStreamer(t, streamer.db, func(config *TransactionStreamerConfig) {
+		config.Espresso.MigrationActivationPos = 2
+	})
+	expectActivation(reloaded, &activation)
+	Require(t, reloaded.validateEspressoMigration())
+	reloaded.config().Espresso.MigrationActivationPos = 3
+	if err := reloaded.validateEspressoMigration(); err == nil {
+		Fail(t, "configured activation position not checked against the recorded one")
+	}
+}
+
+func TestEspressoMigrationRejectsEarlierState(t *testing.T) {
+	streamer := newTestImportStreamer(t)
+	Require(t, streamer.SubmitEspressoTransactionPos(1, streamer.db.NewBatch()))
+	Require(t, streamer.db.Delete(espressoActivationPos))
+
+	// A message queued before the activation position is found on startup
+	streamer.config().Espresso.MigrationActivationPos = 2
+	if err := streamer.validateEspressoMigration(); err == nil {
+		Fail(t, "pending message before the activation position not detected")
+	}
+	streamer.config().Espresso.MigrationActivationPos = 1
+	Require(t, streamer.validateEspressoMigration())
+}
+
+// chainConfigExecution serves the serialized chain config stored in ArbOS
+type chainConfigExecution struct {
+	execution.ExecutionSequencer
+	chainConfig string
+}
+
+func (e *chainConfigExecution) GetArbOSChainConfigJSONAtHeight(height uint64) ([]byte, error) {
+	return []byte(e.chainConfig), nil
+}
+
+func TestEspressoActivationPosPublished(t *testing.T) {
+	streamer := newTestImportStreamer(t)
+	exec := &chainConfigExecution{chainConfig: `{"chainId":412346}`}
+	streamer.exec = exec
+
+	// A migration that isn't published isn't started
+	streamer.config().Espresso.MigrationActivationPos = 2
+	Require(t, streamer.loadPublishedEspressoActivationPos())
+	if err := streamer.validateEspressoMigration(); err == nil {
+		Fail(t, "unpublished migration activation position accepted")
+	}
+
+	// A configured position different from the published one is rejected
+	exec.chainConfig = `{"chainId":412346,"espresso":{"migrationActivationPos":3}}`
+	if err := streamer.loadPublishedEspressoActivationPos(); err == nil {
+		Fail(t, "configured activation position not checked against the published one")
+	}
+
+	// A node configured with the default follows the published position
+	streamer.config().Espresso.MigrationActivationPos = 0
+	Require(t, streamer.loadPublishedEspressoActivationPos())
+	Require(t, streamer.validateEspressoMigration())
+	if streamer.isEspressoActiveAt(2) || !streamer.isEspressoActiveAt(3) {
+		Fail(t, "published activation position not used")
+	}
+	Require(t, streamer.SubmitEspressoTransactionPos(3, streamer.db.NewBatch()))
+	recorded, err := streamer.GetEspressoActivationPos()
+	Require(t, err)
+	if recorded == nil || *recorded != 3 {
+		Fail(t, "published activation position not recorded", recorded)
+	}
+}
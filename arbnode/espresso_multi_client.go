@@ -0,0 +1,213 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package arbnode
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	espressoClient "github.com/EspressoSystems/espresso-sequencer-go/client"
+	espressoTypes "github.com/EspressoSystems/espresso-sequencer-go/types"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+const (
+	espressoHealthCheckInterval = 10 * time.Second
+	espressoHealthCheckTimeout  = 5 * time.Second
+)
+
+// espressoQueryClient is the subset of the HotShot query and submit APIs used by the streamer
+type espressoQueryClient interface {
+	FetchLatestBlockHeight(ctx context.Context) (uint64, error)
+	FetchHeaderByHeight(ctx context.Context, blockHeight uint64) (espressoTypes.HeaderImpl, error)
+	FetchHeadersByRange(ctx context.Context, from uint64, until uint64) ([]espressoTypes.HeaderImpl, error)
+	FetchTransactionByHash(ctx context.Context, hash *espressoTypes.TaggedBase64) (espressoTypes.TransactionQueryData, error)
+	FetchBlockMerkleProof(ctx context.Context, rootHeight uint64, hotshotHeight uint64) (espressoTypes.HotShotBlockMerkleProof, error)
+	FetchTransactionsInBlock(ctx context.Context, blockHeight uint64, namespace uint64) (espressoClient.TransactionsInBlock, error)
+	SubmitTransaction(ctx context.Context, tx espressoTypes.Transaction) (*espressoTypes.TaggedBase64, error)
+}
+
+var _ espressoQueryClient = (*espressoClient.Client)(nil)
+var _ espressoQueryClient = (*espressoMultiClient)(nil)
+
+// parseHotShotUrls splits a comma separated list of HotShot query service urls
+func parseHotShotUrls(urls string) []string {
+	var parsed []string
+	for _, url := range strings.Split(urls, ",") {
+		url = strings.TrimSpace(url)
+		if url != "" {
+			parsed = append(parsed, url)
+		}
+	}
+	return parsed
+}
+
+type espressoQueryNode struct {
+	url       string
+	client    *espressoClient.Client
+	unhealthy atomic.Bool
+}
+
+// espressoMultiClient spreads requests over several HotShot query nodes in round-robin order.
+// A request that fails is retried on the next node, and nodes failing the periodic health
+// check are skipped until they recover, so a single query node outage doesn't stall the streamer.
+// Transactions are also submitted to the builders, if any, see submitToBuilders.
+type espressoMultiClient struct {
+	nodesMutex sync.RWMutex
+	nodes      []*espressoQueryNode
+	builders   []espressoBuilder
+	next       atomic.Uint64
+}
+
+func newEspressoMultiClient(urls []string) (*espressoMultiClient, error) {
+	client := &espressoMultiClient{}
+	if err := client.setUrls(urls); err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// setUrls replaces the query nodes, nodes that are kept keep their health
+func (c *espressoMultiClient) setUrls(urls []string) error {
+	if len(urls) == 0 {
+		return errors.New("no hotshot urls given")
+	}
+	c.nodesMutex.Lock()
+	defer c.nodesMutex.Unlock()
+	existing := make(map[string]*espressoQueryNode, len(c.nodes))
+	for _, node := range c.nodes {
+		existing[node.url] = node
+	}
+	nodes := make([]*espressoQueryNode, 0, len(urls))
+	for _, url := range urls {
+		node, ok := existing[url]
+		if !ok {
+			node = &espressoQueryNode{
+				url:    url,
+				client: espressoClient.NewClient(url),
+			}
+		}
+		nodes = append(nodes, node)
+	}
+	c.nodes = nodes
+	return nil
+}
+
+func (c *espressoMultiClient) getNodes() []*espressoQueryNode {
+	c.nodesMutex.RLock()
+	defer c.nodesMutex.RUnlock()
+	return c.nodes
+}
+
+// candidates returns the nodes to try for the next request: healthy nodes first, starting at
+// the round-robin position, followed by the unhealthy ones as a last resort.
+func (c *espressoMultiClient) candidates() []*espressoQueryNode {
+	nodes := c.getNodes()
+	start := c.next.Add(1) - 1
+	healthy := make([]*espressoQueryNode, 0, len(nodes))
+	var unhealthy []*espressoQueryNode
+	for i := range nodes {
+		node := nodes[(start+uint64(i))%uint64(len(nodes))]
+		if node.unhealthy.Load() {
+			unhealthy = append(unhealthy, node)
+		} else {
+			healthy = append(healthy, node)
+		}
+	}
+	return append(healthy, unhealthy...)
+}
+
+func espressoMultiCall[T any](ctx context.Context, c *espressoMultiClient, method string, call func(*espressoClient.Client) (T, error)) (T, error) {
+	var lastErr error
+	candidates := c.candidates()
+	for _, node := range candidates {
+		res, err := call(node.client)
+		if err == nil {
+			return res, nil
+		}
+		lastErr = err
+		if ctx.Err() != nil {
+			break
+		}
+		if len(candidates) > 1 {
+			log.Debug("hotshot query node request failed, trying the next node", "method", method, "url", node.url, "err", err)
+		}
+	}
+	var zero T
+	return zero, lastErr
+}
+
+func (c *espressoMultiClient) FetchLatestBlockHeight(ctx context.Context) (uint64, error) {
+	return espressoMultiCall(ctx, c, "FetchLatestBlockHeight", func(client *espressoClient.Client) (uint64, error) {
+		return client.FetchLatestBlockHeight(ctx)
+	})
+}
+
+func (c *espressoMultiClient) FetchHeaderByHeight(ctx context.Context, blockHeight uint64) (espressoTypes.HeaderImpl, error) {
+	return espressoMultiCall(ctx, c, "FetchHeaderByHeight", func(client *espressoClient.Client) (espressoTypes.HeaderImpl, error) {
+		return client.FetchHeaderByHeight(ctx, blockHeight)
+	})
+}
+
+func (c *espressoMultiClient) FetchHeadersByRange(ctx context.Context, from uint64, until uint64) ([]espressoTypes.HeaderImpl, error) {
+	return espressoMultiCall(ctx, c, "FetchHeadersByRange", func(client *espressoClient.Client) ([]espressoTypes.HeaderImpl, error) {
+		return client.FetchHeadersByRange(ctx, from, until)
+	})
+}
+
+func (c *espressoMultiClient) FetchTransactionByHash(ctx context.Context, hash *espressoTypes.TaggedBase64) (espressoTypes.TransactionQueryData, error) {
+	return espressoMultiCall(ctx, c, "FetchTransactionByHash", func(client *espressoClient.Client) (espressoTypes.TransactionQueryData, error) {
+		return client.FetchTransactionByHash(ctx, hash)
+	})
+}
+
+func (c *espressoMultiClient) FetchBlockMerkleProof(ctx context.Context, rootHeight uint64, hotshotHeight uint64) (espressoTypes.HotShotBlockMerkleProof, error) {
+	return espressoMultiCall(ctx, c, "FetchBlockMerkleProof", func(client *espressoClient.Client) (espressoTypes.HotShotBlockMerkleProof, error) {
+		return client.FetchBlockMerkleProof(ctx, rootHeight, hotshotHeight)
+	})
+}
+
+func (c *espressoMultiClient) FetchTransactionsInBlock(ctx context.Context, blockHeight uint64, namespace uint64) (espressoClient.TransactionsInBlock, error) {
+	return espressoMultiCall(ctx, c, "FetchTransactionsInBlock", func(client *espressoClient.Client) (espressoClient.TransactionsInBlock, error) {
+		return client.FetchTransactionsInBlock(ctx, blockHeight, namespace)
+	})
+}
+
+// SubmitTransaction may safely be retried on another node, as HotShot identifies transactions by their commitment
+func (c *espressoMultiClient) SubmitTransaction(ctx context.Context, tx espressoTypes.Transaction) (*espressoTypes.TaggedBase64, error) {
+	if builders := c.getBuilders(); len(builders) > 0 {
+		return c.submitToBuilders(ctx, tx, builders)
+	}
+	return espressoMultiCall(ctx, c, "SubmitTransaction", func(client *espressoClient.Client) (*espressoTypes.TaggedBase64, error) {
+		return client.SubmitTransaction(ctx, tx)
+	})
+}
+
+// healthCheck probes every query node and updates its health, it's meant to be called iteratively.
+// With a single node there's nothing to fail over to, so it isn't probed.
+func (c *espressoMultiClient) healthCheck(ctx context.Context) time.Duration {
+	nodes := c.getNodes()
+	if len(nodes) <= 1 {
+		return espressoHealthCheckInterval
+	}
+	for _, node := range nodes {
+		probeCtx, cancel := context.WithTimeout(ctx, espressoHealthCheckTimeout)
+		_, err := node.client.FetchLatestBlockHeight(probeCtx)
+		cancel()
+		if ctx.Err() != nil {
+			return 0
+		}
+		wasUnhealthy := node.unhealthy.Swap(err != nil)
+		if err != nil && !wasUnhealthy {
+			log.Warn("hotshot query node is unhealthy", "url", node.url, "err", err)
+		} else if err == nil && wasUnhealthy {
+			log.Info("hotshot query node is healthy again", "url", node.url)
+		}
+	}
+	return espressoHealthCheckInterval
+}
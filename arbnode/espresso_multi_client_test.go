@@ -0,0 +1,76 @@
+package arbnode
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestEspressoMultiClientCandidates(t *testing.T) {
+	urls := parseHotShotUrls(" http://a:1, http://b:2,,http://c:3 ")
+	if !reflect.DeepEqual(urls, []string{"http://a:1", "http://b:2", "http://c:3"}) {
+		Fail(t, "unexpected parsed urls", urls)
+	}
+	client, err := newEspressoMultiClient(urls)
+	Require(t, err)
+	client.nodes[1].unhealthy.Store(true)
+
+	candidateUrls := func() []string {
+		var res []string
+		for _, node := range client.candidates() {
+			res = append(res, node.url)
+		}
+		return res
+	}
+	// Healthy nodes are tried in round-robin order, the unhealthy one last
+	expected := [][]string{
+		{"http://a:1", "http://c:3", "http://b:2"},
+		{"http://c:3", "http://a:1", "http://b:2"},
+		{"http://c:3", "http://a:1", "http://b:2"},
+		{"http://a:1", "http://c:3", "http://b:2"},
+	}
+	for i, want := range expected {
+		if got := candidateUrls(); !reflect.DeepEqual(got, want) {
+			Fail(t, "unexpected candidates for request", i, "got", got, "expected", want)
+		}
+	}
+}
+
+func TestEspressoConfigHotReload(t *testing.T) {
+	client, err := newEspressoMultiClient([]string{"http://a:1"})
+	Require(t, err)
+	client.nodes[0].unhealthy.Store(true)
+	config := DefaultBatchPosterConfig
+	config.EspressoQueryUrl = "http://a:1"
+	streamer := &TransactionStreamer{
+		espressoClient:               client,
+		espressoHotShotUrl:           config.EspressoQueryUrl,
+		espressoSubmissionReconciled: true,
+		batchPosterConfig:            func() *BatchPosterConfig { return &config },
+	}
+
+	config.EspressoQueryUrl = "http://a:1,http://b:2"
+	config.EspressoTEEVerifierAddress = "0x0000000000000000000000000000000000000001"
+	streamer.reloadEspressoConfig()
+	nodes := client.getNodes()
+	if len(nodes) != 2 || nodes[0].url != "http://a:1" || nodes[1].url != "http://b:2" {
+		Fail(t, "query nodes not replaced", nodes)
+	}
+	if !nodes[0].unhealthy.Load() {
+		Fail(t, "kept node lost its health")
+	}
+	if streamer.espressoSubmissionReconciled {
+		Fail(t, "in-flight submission not reconciled with the new query nodes")
+	}
+	if streamer.espressoTEEVerifierAddress != common.HexToAddress(config.EspressoTEEVerifierAddress) {
+		Fail(t, "tee verifier address not reloaded", streamer.espressoTEEVerifierAddress)
+	}
+
+	// An invalid url list keeps the previous nodes
+	config.EspressoQueryUrl = " , "
+	streamer.reloadEspressoConfig()
+	if len(client.getNodes()) != 2 || streamer.espressoHotShotUrl != "http://a:1,http://b:2" {
+		Fail(t, "invalid hotshot urls applied")
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package arbnode
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	espressoTypes "github.com/EspressoSystems/espresso-sequencer-go/types"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+
+	"github.com/offchainlabs/nitro/arbutil"
+	"github.com/offchainlabs/nitro/util/dbutil"
+)
+
+var (
+	espressoForeignTxCounter         = metrics.NewRegisteredCounter("arb/espresso/namespace/foreign", nil)
+	espressoNamespaceScanHeightGauge = metrics.NewRegisteredGauge("arb/espresso/namespace/scanned_height", nil)
+)
+
+// How long to wait before checking again whether the namespace scanner was enabled
+const espressoNamespaceScanDisabledInterval = time.Minute
+
+// checkEspressoNamespaceTransaction returns an empty string if the transaction was submitted by this node,
+// and otherwise the reason it's considered foreign. A transaction is ours if its hash was recorded when
+// submitting its messages, or if all its messages match the messages stored at their positions. A chunk of
+// a large message is ours if it matches the part of the message stored at its position.
+func (s *TransactionStreamer) checkEspressoNamespaceTransaction(payload []byte) (string, error) {
+	if chunk, err := parseEspressoChunk(payload); err == nil {
+		return s.checkEspressoNamespaceChunk(chunk)
+	}
+	_, indices, messages, err := s.espressoPayloadCodec().ParsePayload(payload)
+	if err != nil || len(indices) == 0 {
+		return "unparseable payload", nil
+	}
+	hash, err := espressoTransactionHash(&espressoTypes.Transaction{
+		Payload:   payload,
+		Namespace: s.chainConfig.ChainID.Uint64(),
+	})
+	if err != nil {
+		return "", err
+	}
+	record, err := s.GetEspressoSubmissionRecord(arbutil.MessageIndex(indices[0]))
+	if err != nil {
+		return "", err
+	}
+	if record != nil && record.TxHash == hash.String() {
+		return "", nil
+	}
+	for i, index := range indices {
+		ours, err := s.espressoMessageBytes(arbutil.MessageIndex(index))
+		if err != nil {
+			if dbutil.IsErrNotFound(err) {
+				return fmt.Sprintf("unknown message position %d", index), nil
+			}
+			return "", err
+		}
+		if !bytes.Equal(ours, messages[i]) {
+			return fmt.Sprintf("message mismatch at position %d", index), nil
+		}
+	}
+	return "", nil
+}
+
+// scanEspressoNamespace looks for transactions in the chain's namespace that weren't submitted by this node,
+// which indicates namespace squatting or a second sequencer submitting for the chain.
+func (s *TransactionStreamer) scanEspressoNamespace(ctx context.Context) time.Duration {
+	config := s.config().Espresso
+	if config.NamespaceScanInterval == 0 {
+		return espressoNamespaceScanDisabledInterval
+	}
+	latest, err := s.espressoClient.FetchLatestBlockHeight(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Warn("namespace scan failed to fetch the latest hotshot block height", "err", err)
+		}
+		return config.NamespaceScanInterval
+	}
+	from := s.espressoNamespaceScanHeight
+	if latest > config.NamespaceScanMaxBlocks && from < latest-config.NamespaceScanMaxBlocks {
+		from = latest - config.NamespaceScanMaxBlocks
+	}
+	namespace := s.chainConfig.ChainID.Uint64()
+	for height := from; height < latest; height++ {
+		resp, err := s.fetchEspressoNamespace(ctx, height, namespace)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Warn("namespace scan failed to fetch the transactions in block", "height", height, "err", err)
+			}
+			break
+		}
+		for _, payload := range resp.Transactions {
+			reason, err := s.checkEspressoNamespaceTransaction(payload)
+			if err != nil {
+				log.Warn("namespace scan failed to check a transaction", "height", height, "err", err)
+				return config.NamespaceScanInterval
+			}
+			if reason != "" {
+				espressoForeignTxCounter.Inc(1)
+				log.Error("found a transaction in the chain's namespace that wasn't submitted by this node", "height", height, "reason", reason, "size", len(payload))
+			}
+		}
+		s.espressoNamespaceScanHeight = height + 1
+		// #nosec G115
+		espressoNamespaceScanHeightGauge.Update(int64(height))
+	}
+	return config.NamespaceScanInterval
+}
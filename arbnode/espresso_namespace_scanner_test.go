@@ -0,0 +1,103 @@
+package arbnode
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/params"
+
+	"github.com/offchainlabs/nitro/arbutil"
+)
+
+func TestCheckEspressoNamespaceTransaction(t *testing.T) {
+	streamer := &TransactionStreamer{
+		db:          rawdb.NewMemoryDatabase(),
+		chainConfig: &params.ChainConfig{ChainID: big.NewInt(412346)},
+	}
+	Require(t, streamer.db.Put(dbKey(messagePrefix, 3), []byte("message3")))
+	Require(t, streamer.db.Put(dbKey(messagePrefix, 4), []byte("message4")))
+	noSignature := func([]byte) ([]byte, error) { return nil, nil }
+
+	buildPayload := func(positions []arbutil.MessageIndex, fetcher func(arbutil.MessageIndex) ([]byte, error)) []byte {
+		raw, _ := buildRawHotShotPayload(positions, fetcher, 200*1024)
+		payload, err := signHotShotPayload(raw, noSignature)
+		Require(t, err)
+		return payload
+	}
+	ours := buildPayload([]arbutil.MessageIndex{3, 4}, func(pos arbutil.MessageIndex) ([]byte, error) {
+		return streamer.db.Get(dbKey(messagePrefix, uint64(pos)))
+	})
+	reason, err := streamer.checkEspressoNamespaceTransaction(ours)
+	Require(t, err)
+	if reason != "" {
+		Fail(t, "our own transaction was flagged as foreign:", reason)
+	}
+
+	foreign := buildPayload([]arbutil.MessageIndex{3, 4}, mockMsgFetcher)
+	reason, err = streamer.checkEspressoNamespaceTransaction(foreign)
+	Require(t, err)
+	if reason == "" {
+		Fail(t, "transaction with different messages wasn't flagged")
+	}
+	unknown := buildPayload([]arbutil.MessageIndex{5}, mockMsgFetcher)
+	reason, err = streamer.checkEspressoNamespaceTransaction(unknown)
+	Require(t, err)
+	if reason == "" {
+		Fail(t, "transaction for an unknown position wasn't flagged")
+	}
+	reason, err = streamer.checkEspressoNamespaceTransaction([]byte{1, 2, 3})
+	Require(t, err)
+	if reason == "" {
+		Fail(t, "unparseable transaction wasn't flagged")
+	}
+}
+
+// prefixCodec includes messages with a prefix, like a chain with its own message format would
+type prefixCodec struct {
+	hotShotPayloadCodec
+}
+
+func (prefixCodec) EncodeMessage(pos arbutil.MessageIndex, stored []byte) ([]byte, error) {
+	return append([]byte("custom:"), stored...), nil
+}
+
+func TestCheckEspressoNamespaceTransactionWithCodec(t *testing.T) {
+	streamer := &TransactionStreamer{
+		db:            rawdb.NewMemoryDatabase(),
+		chainConfig:   &params.ChainConfig{ChainID: big.NewInt(412346)},
+		espressoCodec: prefixCodec{},
+	}
+	Require(t, streamer.db.Put(dbKey(messagePrefix, 3), []byte("message3")))
+	noSignature := func([]byte) ([]byte, error) { return nil, nil }
+	codec := streamer.espressoPayloadCodec()
+
+	raw, cnt := codec.BuildPayload([]arbutil.MessageIndex{3}, streamer.espressoMessageBytes, 200*1024)
+	if cnt != 1 {
+		Fail(t, "expected 1 message in the payload, got", cnt)
+	}
+	ours, err := codec.SignPayload(raw, noSignature)
+	Require(t, err)
+	_, _, messages, err := codec.ParsePayload(ours)
+	Require(t, err)
+	if string(messages[0]) != "custom:message3" {
+		Fail(t, "message not encoded by the codec", string(messages[0]))
+	}
+	reason, err := streamer.checkEspressoNamespaceTransaction(ours)
+	Require(t, err)
+	if reason != "" {
+		Fail(t, "our own transaction was flagged as foreign:", reason)
+	}
+
+	// The stored bytes without the codec's encoding don't match
+	plain, _ := buildRawHotShotPayload([]arbutil.MessageIndex{3}, func(pos arbutil.MessageIndex) ([]byte, error) {
+		return streamer.db.Get(dbKey(messagePrefix, uint64(pos)))
+	}, 200*1024)
+	unencoded, err := signHotShotPayload(plain, noSignature)
+	Require(t, err)
+	reason, err = streamer.checkEspressoNamespaceTransaction(unencoded)
+	Require(t, err)
+	if reason == "" {
+		Fail(t, "transaction without the codec's encoding wasn't flagged")
+	}
+}
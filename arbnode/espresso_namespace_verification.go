@@ -0,0 +1,61 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package arbnode
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+
+	"github.com/offchainlabs/nitro/espressocrypto"
+)
+
+var (
+	ErrEspressoNamespaceProofInvalid = errors.New("espresso namespace proof doesn't commit to the returned transactions")
+
+	espressoNamespaceRejectedCounter = metrics.NewRegisteredCounter("arb/espresso/namespace/rejected", nil)
+)
+
+// verifyEspressoNamespace checks the namespace data returned by the query service for a block before its finality
+// is accepted. The namespace proof must commit to the returned transactions under the payload commitment and
+// namespace table of the block's header, the header itself is proven by the justification. Rejected data is
+// evicted from the block cache, so that the block is fetched again on the next poll instead of being trusted.
+func (s *TransactionStreamer) verifyEspressoNamespace(height uint64, namespace uint64, block espressoBlock) error {
+	err := checkEspressoNamespace(height, namespace, block)
+	if err != nil {
+		espressoNamespaceRejectedCounter.Inc(1)
+		s.espressoBlockCache.removeBlock(height, namespace)
+		log.Warn("rejected espresso namespace data from the query service", "height", height, "namespace", namespace, "err", err)
+		if errors.Is(err, ErrEspressoNamespaceProofInvalid) {
+			s.recordEspressoVerificationFailure(espressoCheckNamespaceProof, err)
+		}
+	}
+	return err
+}
+
+func checkEspressoNamespace(height uint64, namespace uint64, block espressoBlock) error {
+	header := block.Header.Header
+	if header == nil || header.GetBlockHeight() != height {
+		return fmt.Errorf("%w: the query service returned a header for the wrong height (height: %d)", ErrEspressoNamespaceProofInvalid, height)
+	}
+	// The transactions commitment only covers the lower 32 bits of the namespace
+	if namespace > math.MaxUint32 {
+		return fmt.Errorf("namespace %d doesn't fit the transactions commitment", namespace)
+	}
+	payloadCommitment, nsTable := header.GetPayloadCommitment(), header.GetNsTable()
+	if payloadCommitment == nil || nsTable == nil {
+		return fmt.Errorf("%w: header without payload commitment or namespace table (height: %d)", ErrEspressoNamespaceProofInvalid, height)
+	}
+	data := block.Namespace
+	if len(data.Proof) == 0 || len(data.VidCommon) == 0 {
+		return fmt.Errorf("%w: missing namespace proof or vid common (height: %d)", ErrEspressoNamespaceProofInvalid, height)
+	}
+	if !espressocrypto.VerifyNamespace(namespace, data.Proof, *payloadCommitment, *nsTable, data.Transactions, data.VidCommon) {
+		return fmt.Errorf("%w (height: %d)", ErrEspressoNamespaceProofInvalid, height)
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package arbnode
+
+import (
+	"errors"
+	"testing"
+
+	espressoClient "github.com/EspressoSystems/espresso-sequencer-go/client"
+	tagged_base64 "github.com/EspressoSystems/espresso-sequencer-go/tagged-base64"
+	espressoTypes "github.com/EspressoSystems/espresso-sequencer-go/types"
+)
+
+func TestEspressoNamespaceVerificationRejects(t *testing.T) {
+	streamer := &TransactionStreamer{espressoBlockCache: newEspressoBlockCache(espressoBlockCacheSize)}
+	commitment, err := tagged_base64.New("HASH", []byte{1, 2, 3})
+	Require(t, err)
+	block := func() espressoBlock {
+		return espressoBlock{
+			Header: espressoTypes.HeaderImpl{Header: &espressoTypes.Header0_1{
+				Height:            5,
+				PayloadCommitment: commitment,
+				NsTable:           &espressoTypes.NsTable{Bytes: []byte{0}},
+			}},
+			Namespace: espressoClient.TransactionsInBlock{
+				Transactions: []espressoTypes.Bytes{[]byte("payload")},
+				Proof:        []byte(`"proof"`),
+				VidCommon:    []byte(`"common"`),
+			},
+		}
+	}
+	cases := map[string]func(*espressoBlock) uint64{
+		"wrong height": func(b *espressoBlock) uint64 {
+			b.Header.Header.(*espressoTypes.Header0_1).Height = 6
+			return 412346
+		},
+		"missing commitment": func(b *espressoBlock) uint64 {
+			b.Header.Header.(*espressoTypes.Header0_1).PayloadCommitment = nil
+			return 412346
+		},
+		"missing proof": func(b *espressoBlock) uint64 {
+			b.Namespace.Proof = nil
+			return 412346
+		},
+		"missing vid common": func(b *espressoBlock) uint64 {
+			b.Namespace.VidCommon = nil
+			return 412346
+		},
+		"namespace overflow": func(b *espressoBlock) uint64 {
+			return 1 << 32
+		},
+	}
+	for name, modify := range cases {
+		b := block()
+		namespace := modify(&b)
+		streamer.espressoBlockCache.addHeader(5, b.Header)
+		streamer.espressoBlockCache.addNamespace(5, namespace, b.Namespace)
+		err := streamer.verifyEspressoNamespace(5, namespace, b)
+		if err == nil {
+			Fail(t, name, "accepted")
+		}
+		if name != "namespace overflow" && !errors.Is(err, ErrEspressoNamespaceProofInvalid) {
+			Fail(t, name, "unexpected error", err)
+		}
+		if _, ok := streamer.espressoBlockCache.getHeader(5); ok {
+			Fail(t, name, "rejected header kept in the cache")
+		}
+		if _, ok := streamer.espressoBlockCache.getNamespace(5, namespace); ok {
+			Fail(t, name, "rejected namespace data kept in the cache")
+		}
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package arbnode
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+
+	espressoTypes "github.com/EspressoSystems/espresso-sequencer-go/types"
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+var (
+	espressoPartialProofCounter         = metrics.NewRegisteredCounter("arb/espresso/namespace/partial_proofs", nil)
+	espressoPartialProofRejectedCounter = metrics.NewRegisteredCounter("arb/espresso/namespace/partial_proofs_rejected", nil)
+	espressoFullNamespaceFetchCounter   = metrics.NewRegisteredCounter("arb/espresso/namespace/full_fetches", nil)
+	espressoNamespaceSizeGauge          = metrics.NewRegisteredGauge("arb/espresso/namespace/size", nil)
+)
+
+// EspressoTransactionProofVerifier verifies the proof the query service returns along with a transaction, which
+// proves the inclusion of only that transaction under the payload commitment of the block's header
+type EspressoTransactionProofVerifier interface {
+	VerifyTransaction(namespace uint64, header espressoTypes.HeaderImpl, data espressoTypes.TransactionQueryData) error
+}
+
+// SetEspressoTransactionProofVerifier enables proving the finality of a transaction in a large namespace with the
+// transaction's own proof instead of the whole namespace, it must be called before Start
+func (s *TransactionStreamer) SetEspressoTransactionProofVerifier(verifier EspressoTransactionProofVerifier) {
+	if s.Started() {
+		panic("trying to set espresso transaction proof verifier after start")
+	}
+	s.espressoTxProofVerifier = verifier
+}
+
+// espressoNamespaceSize returns the size in bytes of namespace in the payload of a block with nsTable, which
+// lists the id and end offset of every namespace as little endian uint32s after the number of namespaces
+func espressoNamespaceSize(nsTable []byte, namespace uint64) (uint64, bool) {
+	const wordSize = 4
+	if len(nsTable) < wordSize {
+		return 0, false
+	}
+	count := uint64(binary.LittleEndian.Uint32(nsTable))
+	if uint64(len(nsTable)) < wordSize+count*2*wordSize {
+		return 0, false
+	}
+	var start uint64
+	for i := uint64(0); i < count; i++ {
+		entry := nsTable[wordSize+i*2*wordSize:]
+		id := uint64(binary.LittleEndian.Uint32(entry))
+		end := uint64(binary.LittleEndian.Uint32(entry[wordSize:]))
+		if id == namespace {
+			if end < start {
+				return 0, false
+			}
+			return end - start, true
+		}
+		start = end
+	}
+	return 0, false
+}
+
+// usePartialEspressoProof returns whether the finality of the transaction in the block with header is proven with
+// the transaction's own proof. That's the case when a verifier is set, the query service returned a proof with the
+// transaction, and the chain's namespace in the block is at least as large as the configured minimum.
+func (s *TransactionStreamer) usePartialEspressoProof(namespace uint64, header espressoTypes.HeaderImpl, data espressoTypes.TransactionQueryData) bool {
+	minSize := s.config().Espresso.PartialProofMinSize
+	if minSize == 0 || s.espressoTxProofVerifier == nil || len(data.Proof) == 0 || header.Header == nil {
+		return false
+	}
+	nsTable := header.Header.GetNsTable()
+	if nsTable == nil {
+		return false
+	}
+	size, ok := espressoNamespaceSize(nsTable.Bytes, namespace)
+	if !ok {
+		return false
+	}
+	// #nosec G115
+	espressoNamespaceSizeGauge.Update(int64(size))
+	return size >= minSize
+}
+
+// partialEspressoFinality proves the finality of the transaction data was returned for with the transaction's own
+// proof, without fetching the rest of the namespace
+func (s *TransactionStreamer) partialEspressoFinality(ctx context.Context, namespace uint64, header espressoTypes.HeaderImpl, data espressoTypes.TransactionQueryData, payload []byte) (*Finality, error) {
+	height := data.BlockHeight
+	if header.Header.GetBlockHeight() != height || data.Transaction.Namespace != namespace {
+		espressoPartialProofRejectedCounter.Inc(1)
+		err := fmt.Errorf("%w: transaction data for the wrong height or namespace (height: %d)", ErrEspressoNamespaceProofInvalid, height)
+		s.recordEspressoVerificationFailure(espressoCheckNamespaceProof, err)
+		return nil, err
+	}
+	if err := s.espressoTxProofVerifier.VerifyTransaction(namespace, header, data); err != nil {
+		espressoPartialProofRejectedCounter.Inc(1)
+		s.espressoBlockCache.removeBlock(height, namespace)
+		err = fmt.Errorf("%w (height: %d): %w", ErrEspressoNamespaceProofInvalid, height, err)
+		s.recordEspressoVerificationFailure(espressoCheckNamespaceProof, err)
+		return nil, err
+	}
+	justification, err := s.fetchEspressoJustification(ctx, height, header)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(data.Transaction.Payload, payload) {
+		return nil, fmt.Errorf("%w (height: %d)", ErrFinalityPayloadMismatch, height)
+	}
+	espressoPartialProofCounter.Inc(1)
+	return &Finality{Height: height, Justification: justification}, nil
+}
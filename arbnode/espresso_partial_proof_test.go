@@ -0,0 +1,27 @@
+package arbnode
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestEspressoNamespaceSize(t *testing.T) {
+	entries := [][2]uint32{{7, 100}, {42, 1100}, {9, 1150}}
+	nsTable := binary.LittleEndian.AppendUint32(nil, uint32(len(entries)))
+	for _, entry := range entries {
+		nsTable = binary.LittleEndian.AppendUint32(nsTable, entry[0])
+		nsTable = binary.LittleEndian.AppendUint32(nsTable, entry[1])
+	}
+	for namespace, expected := range map[uint64]uint64{7: 100, 42: 1000, 9: 50} {
+		size, ok := espressoNamespaceSize(nsTable, namespace)
+		if !ok || size != expected {
+			Fail(t, "unexpected namespace size", namespace, size, ok)
+		}
+	}
+	if _, ok := espressoNamespaceSize(nsTable, 8); ok {
+		Fail(t, "size of a namespace missing from the table")
+	}
+	if _, ok := espressoNamespaceSize(nsTable[:len(nsTable)-1], 9); ok {
+		Fail(t, "size read from a truncated table")
+	}
+}
@@ -0,0 +1,254 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package arbnode
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/rlp"
+
+	"github.com/offchainlabs/nitro/arbutil"
+	"github.com/offchainlabs/nitro/util/dbutil"
+)
+
+var (
+	errEspressoNotChunk = errors.New("not an espresso chunk payload")
+
+	espressoChunkSubmittedCounter   = metrics.NewRegisteredCounter("arb/espresso/chunk/submitted", nil)
+	espressoChunkReassembledCounter = metrics.NewRegisteredCounter("arb/espresso/chunk/reassembled", nil)
+	espressoChunkMismatchCounter    = metrics.NewRegisteredCounter("arb/espresso/chunk/mismatch", nil)
+)
+
+// espressoChunkMagic starts the unsigned part of a chunk payload. Where a regular payload starts with the
+// position of its first message, so regular payloads can't be mistaken for chunks and parsers unaware of
+// chunks reject them.
+var espressoChunkMagic = [INDEX_SIZE]byte{0xff, 'E', 'S', 'P', 'C', 'H', 'N', 'K'}
+
+// Size of the chunk header: magic, position, chunk index and count, offset, total size and message hash
+const espressoChunkHeaderSize = INDEX_SIZE + INDEX_SIZE + 4 + 4 + LEN_SIZE + LEN_SIZE + common.HashLength
+
+// espressoChunk is a part of a message too large for a single espresso transaction
+type espressoChunk struct {
+	Pos   arbutil.MessageIndex
+	Index uint32
+	Count uint32
+	// Offset of the chunk's data in the message
+	Offset uint64
+	// Size and hash of the whole message, checked once it's reassembled
+	TotalSize   uint64
+	MessageHash common.Hash
+	Data        []byte
+}
+
+func (c *espressoChunk) last() bool {
+	return c.Offset+uint64(len(c.Data)) >= c.TotalSize
+}
+
+func encodeEspressoChunk(chunk *espressoChunk) []byte {
+	payload := make([]byte, espressoChunkHeaderSize, espressoChunkHeaderSize+len(chunk.Data))
+	copy(payload, espressoChunkMagic[:])
+	offset := INDEX_SIZE
+	binary.BigEndian.PutUint64(payload[offset:], uint64(chunk.Pos))
+	offset += INDEX_SIZE
+	binary.BigEndian.PutUint32(payload[offset:], chunk.Index)
+	offset += 4
+	binary.BigEndian.PutUint32(payload[offset:], chunk.Count)
+	offset += 4
+	binary.BigEndian.PutUint64(payload[offset:], chunk.Offset)
+	offset += LEN_SIZE
+	binary.BigEndian.PutUint64(payload[offset:], chunk.TotalSize)
+	offset += LEN_SIZE
+	copy(payload[offset:], chunk.MessageHash[:])
+	return append(payload, chunk.Data...)
+}
+
+// parseEspressoChunk parses a signed chunk payload, and returns errEspressoNotChunk for any other payload
+func parseEspressoChunk(payload []byte) (*espressoChunk, error) {
+	if len(payload) < LEN_SIZE {
+		return nil, errEspressoNotChunk
+	}
+	signatureSize := binary.BigEndian.Uint64(payload[:LEN_SIZE])
+	if uint64(len(payload[LEN_SIZE:])) < signatureSize {
+		return nil, errEspressoNotChunk
+	}
+	unsigned := payload[LEN_SIZE+int(signatureSize):]
+	if len(unsigned) < espressoChunkHeaderSize || !bytes.Equal(unsigned[:INDEX_SIZE], espressoChunkMagic[:]) {
+		return nil, errEspressoNotChunk
+	}
+	offset := INDEX_SIZE
+	chunk := &espressoChunk{}
+	chunk.Pos = arbutil.MessageIndex(binary.BigEndian.Uint64(unsigned[offset:]))
+	offset += INDEX_SIZE
+	chunk.Index = binary.BigEndian.Uint32(unsigned[offset:])
+	offset += 4
+	chunk.Count = binary.BigEndian.Uint32(unsigned[offset:])
+	offset += 4
+	chunk.Offset = binary.BigEndian.Uint64(unsigned[offset:])
+	offset += LEN_SIZE
+	chunk.TotalSize = binary.BigEndian.Uint64(unsigned[offset:])
+	offset += LEN_SIZE
+	chunk.MessageHash = common.BytesToHash(unsigned[offset : offset+common.HashLength])
+	chunk.Data = unsigned[espressoChunkHeaderSize:]
+	if chunk.Index >= chunk.Count || chunk.Offset+uint64(len(chunk.Data)) > chunk.TotalSize {
+		return nil, fmt.Errorf("invalid espresso chunk %d of %d at offset %d of %d bytes", chunk.Index, chunk.Count, chunk.Offset, chunk.TotalSize)
+	}
+	return chunk, nil
+}
+
+// espressoChunkProgress is the reassembly of a chunked message from its finalized chunks
+type espressoChunkProgress struct {
+	Pos         uint64
+	MessageHash common.Hash
+	Count       uint32
+	ChunkSize   uint64
+	Assembled   []byte
+}
+
+func (s *TransactionStreamer) getEspressoChunkProgress() (*espressoChunkProgress, error) {
+	data, err := s.db.Get(espressoChunkProgressKey)
+	if err != nil {
+		if dbutil.IsErrNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var progress espressoChunkProgress
+	if err := rlp.DecodeBytes(data, &progress); err != nil {
+		return nil, err
+	}
+	return &progress, nil
+}
+
+func (s *TransactionStreamer) setEspressoChunkProgress(batch ethdb.KeyValueWriter, progress *espressoChunkProgress) error {
+	if progress == nil {
+		return batch.Delete(espressoChunkProgressKey)
+	}
+	data, err := rlp.EncodeToBytes(progress)
+	if err != nil {
+		return err
+	}
+	return batch.Put(espressoChunkProgressKey, data)
+}
+
+// buildEspressoChunkPayload returns the unsigned payload of the next chunk of the message at pos, which is too
+// large to fit in a single transaction of sizeLimit bytes. Chunks are submitted one at a time, the next chunk
+// is built once the previous one is finalized.
+func (s *TransactionStreamer) buildEspressoChunkPayload(pos arbutil.MessageIndex, sizeLimit uint64) ([]byte, error) {
+	message, err := s.espressoMessageBytes(pos)
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(message)+LEN_SIZE+INDEX_SIZE+MAX_ATTESTATION_QUOTE_SIZE) <= sizeLimit {
+		return nil, fmt.Errorf("message at position %d of %d bytes fits in a single transaction", pos, len(message))
+	}
+	if sizeLimit <= uint64(espressoChunkHeaderSize+MAX_ATTESTATION_QUOTE_SIZE) {
+		return nil, fmt.Errorf("size limit %d too small for an espresso chunk", sizeLimit)
+	}
+	messageHash := crypto.Keccak256Hash(message)
+	progress, err := s.getEspressoChunkProgress()
+	if err != nil {
+		return nil, err
+	}
+	if progress == nil || progress.Pos != uint64(pos) || progress.MessageHash != messageHash {
+		// The chunk size is fixed for the whole message, even if the size limit changes while it's submitted
+		chunkSize := sizeLimit - uint64(espressoChunkHeaderSize+MAX_ATTESTATION_QUOTE_SIZE)
+		count := (uint64(len(message)) + chunkSize - 1) / chunkSize
+		if count > uint64(^uint32(0)) {
+			return nil, fmt.Errorf("message at position %d of %d bytes needs too many chunks", pos, len(message))
+		}
+		progress = &espressoChunkProgress{
+			Pos:         uint64(pos),
+			MessageHash: messageHash,
+			// #nosec G115
+			Count:     uint32(count),
+			ChunkSize: chunkSize,
+		}
+	}
+	offset := uint64(len(progress.Assembled))
+	end := min(offset+progress.ChunkSize, uint64(len(message)))
+	chunk := &espressoChunk{
+		Pos: pos,
+		// #nosec G115
+		Index:       uint32(offset / progress.ChunkSize),
+		Count:       progress.Count,
+		Offset:      offset,
+		TotalSize:   uint64(len(message)),
+		MessageHash: messageHash,
+		Data:        message[offset:end],
+	}
+	espressoChunkSubmittedCounter.Inc(1)
+	log.Info("submitting a chunk of a large message to hotshot", "pos", pos, "chunk", chunk.Index, "count", chunk.Count, "size", len(message))
+	return encodeEspressoChunk(chunk), nil
+}
+
+// finalizeEspressoChunk adds a finalized chunk to the reassembly of its message. It returns true once the
+// message is complete and matches the stored message, and false while chunks are missing, in which case the
+// message is queued again for its next chunk. The caller must hold espressoTxnsStateInsertionMutex.
+func (s *TransactionStreamer) finalizeEspressoChunk(batch ethdb.Batch, chunk *espressoChunk) (bool, error) {
+	progress, err := s.getEspressoChunkProgress()
+	if err != nil {
+		return false, err
+	}
+	if progress == nil || progress.Pos != uint64(chunk.Pos) || progress.MessageHash != chunk.MessageHash {
+		progress = &espressoChunkProgress{
+			Pos:         uint64(chunk.Pos),
+			MessageHash: chunk.MessageHash,
+			Count:       chunk.Count,
+			ChunkSize:   uint64(len(chunk.Data)),
+		}
+	}
+	positions := []arbutil.MessageIndex{chunk.Pos}
+	if chunk.Offset != uint64(len(progress.Assembled)) {
+		// A chunk finalized twice, e.g. after a resubmission, is only added once
+		log.Warn("ignoring an espresso chunk out of order", "pos", chunk.Pos, "chunk", chunk.Index, "offset", chunk.Offset, "assembled", len(progress.Assembled))
+		return false, s.requeueEspressoSubmittedTxns(batch, positions, EspressoSubmissionPending)
+	}
+	progress.Assembled = append(progress.Assembled, chunk.Data...)
+	if !chunk.last() {
+		if err := s.setEspressoChunkProgress(batch, progress); err != nil {
+			return false, err
+		}
+		return false, s.requeueEspressoSubmittedTxns(batch, positions, EspressoSubmissionPending)
+	}
+
+	if err := s.setEspressoChunkProgress(batch, nil); err != nil {
+		return false, err
+	}
+	message, err := s.espressoMessageBytes(chunk.Pos)
+	if err != nil {
+		return false, err
+	}
+	if crypto.Keccak256Hash(progress.Assembled) != chunk.MessageHash || !bytes.Equal(progress.Assembled, message) {
+		espressoChunkMismatchCounter.Inc(1)
+		log.Error("reassembled espresso chunks don't match the message, submitting it again", "pos", chunk.Pos, "count", chunk.Count, "size", len(progress.Assembled))
+		return false, s.requeueEspressoSubmittedTxns(batch, positions, EspressoSubmissionFailed)
+	}
+	espressoChunkReassembledCounter.Inc(1)
+	return true, nil
+}
+
+// checkEspressoNamespaceChunk returns an empty string if the chunk is a part of the message stored at its
+// position, and otherwise the reason it's considered foreign
+func (s *TransactionStreamer) checkEspressoNamespaceChunk(chunk *espressoChunk) (string, error) {
+	ours, err := s.espressoMessageBytes(chunk.Pos)
+	if err != nil {
+		if dbutil.IsErrNotFound(err) {
+			return fmt.Sprintf("unknown message position %d", chunk.Pos), nil
+		}
+		return "", err
+	}
+	if uint64(len(ours)) != chunk.TotalSize || crypto.Keccak256Hash(ours) != chunk.MessageHash ||
+		!bytes.Equal(ours[chunk.Offset:chunk.Offset+uint64(len(chunk.Data))], chunk.Data) {
+		return fmt.Sprintf("chunk mismatch at position %d", chunk.Pos), nil
+	}
+	return "", nil
+}
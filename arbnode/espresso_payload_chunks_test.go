@@ -0,0 +1,84 @@
+package arbnode
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/offchainlabs/nitro/arbutil"
+)
+
+func TestEspressoChunkEncoding(t *testing.T) {
+	chunk := &espressoChunk{Pos: 5, Index: 1, Count: 3, Offset: 10, TotalSize: 25, Data: []byte("0123456789")}
+	signed, err := signHotShotPayload(encodeEspressoChunk(chunk), func([]byte) ([]byte, error) { return []byte("quote"), nil })
+	Require(t, err)
+	parsed, err := parseEspressoChunk(signed)
+	Require(t, err)
+	if parsed.Pos != 5 || parsed.Index != 1 || parsed.Count != 3 || parsed.Offset != 10 || parsed.TotalSize != 25 || !bytes.Equal(parsed.Data, chunk.Data) {
+		Fail(t, "unexpected parsed chunk", parsed)
+	}
+	if parsed.last() {
+		Fail(t, "chunk in the middle parsed as the last one")
+	}
+	if _, _, _, err := ParseHotShotPayload(signed); err == nil {
+		Fail(t, "chunk parsed as a regular payload")
+	}
+
+	unsigned, _ := buildRawHotShotPayload([]arbutil.MessageIndex{1}, func(arbutil.MessageIndex) ([]byte, error) { return []byte("msg"), nil }, 1<<20)
+	regular, err := signHotShotPayload(unsigned, func([]byte) ([]byte, error) { return []byte("quote"), nil })
+	Require(t, err)
+	if _, err := parseEspressoChunk(regular); !errors.Is(err, errEspressoNotChunk) {
+		Fail(t, "regular payload parsed as a chunk", err)
+	}
+}
+
+func TestEspressoChunkedSubmission(t *testing.T) {
+	ctx := context.Background()
+	streamer := newTestImportStreamer(t)
+	provider := &testFinalityProvider{finality: &Finality{Height: 9}}
+	streamer.SetFinalityProvider(provider)
+
+	pos := arbutil.MessageIndex(2)
+	message := bytes.Repeat([]byte("large message "), 1000)
+	Require(t, streamer.db.Put(dbKey(messagePrefix, uint64(pos)), message))
+	Require(t, streamer.setEspressoPendingTxnsPos(streamer.db, []arbutil.MessageIndex{pos}))
+	sizeLimit := uint64(MAX_ATTESTATION_QUOTE_SIZE + espressoChunkHeaderSize + 4000)
+
+	chunks := 0
+	for ; chunks < 10; chunks++ {
+		pending, err := streamer.getEspressoPendingTxnsPos()
+		Require(t, err)
+		if len(pending) == 0 {
+			break
+		}
+		unsigned, err := streamer.buildEspressoChunkPayload(pos, sizeLimit)
+		Require(t, err)
+		payload, err := signHotShotPayload(unsigned, func([]byte) ([]byte, error) { return []byte("quote"), nil })
+		Require(t, err)
+		hash, err := provider.TransactionHash(payload)
+		Require(t, err)
+		Require(t, streamer.persistEspressoSubmission([]arbutil.MessageIndex{pos}, hash, payload))
+		Require(t, streamer.pollSubmittedTransactionForFinality(ctx))
+		if chunks < 3 {
+			lastConfirmed, err := streamer.getLastConfirmedPos()
+			Require(t, err)
+			if lastConfirmed != nil {
+				Fail(t, "message confirmed before all its chunks were finalized", chunks)
+			}
+		}
+	}
+	if chunks != 4 {
+		Fail(t, "unexpected number of chunks", chunks)
+	}
+	lastConfirmed, err := streamer.getLastConfirmedPos()
+	Require(t, err)
+	if lastConfirmed == nil || *lastConfirmed != pos {
+		Fail(t, "unexpected last confirmed position", lastConfirmed)
+	}
+	progress, err := streamer.getEspressoChunkProgress()
+	Require(t, err)
+	if progress != nil {
+		Fail(t, "chunk progress kept after the message was reassembled")
+	}
+}
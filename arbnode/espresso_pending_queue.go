@@ -0,0 +1,201 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package arbnode
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/rlp"
+
+	"github.com/offchainlabs/nitro/arbutil"
+	"github.com/offchainlabs/nitro/util/dbutil"
+)
+
+var (
+	ErrEspressoPendingQueueFull = errors.New("espresso pending queue is full")
+
+	espressoPendingShadowMismatchCounter = metrics.NewRegisteredCounter("arb/espresso/pending/shadow_mismatch", nil)
+)
+
+// The pending espresso queue is stored with one key per position, so that queueing a message doesn't
+// rewrite the whole queue. Positions are queued in increasing order and requeued positions always
+// precede the remaining ones, so iterating the keys returns the queue in order.
+
+func (s *TransactionStreamer) getEspressoPendingTxnsPos() ([]arbutil.MessageIndex, error) {
+	pending, err := s.readEspressoPendingTxnsPos()
+	if err != nil {
+		return nil, err
+	}
+	if s.espressoPendingShadowRead {
+		s.compareEspressoPendingShadow(pending)
+	}
+	return pending, nil
+}
+
+func (s *TransactionStreamer) readEspressoPendingTxnsPos() ([]arbutil.MessageIndex, error) {
+	iter := s.db.NewIterator(espressoPendingPrefix, nil)
+	defer iter.Release()
+	var pendingTxnsPos []arbutil.MessageIndex
+	for iter.Next() {
+		pos := binary.BigEndian.Uint64(bytes.TrimPrefix(iter.Key(), espressoPendingPrefix))
+		pendingTxnsPos = append(pendingTxnsPos, arbutil.MessageIndex(pos))
+	}
+	return pendingTxnsPos, iter.Error()
+}
+
+// setEspressoPendingTxnsPos replaces the pending queue with pos
+func (s *TransactionStreamer) setEspressoPendingTxnsPos(batch ethdb.KeyValueWriter, pos []arbutil.MessageIndex) error {
+	current, err := s.getEspressoPendingTxnsPos()
+	if err != nil {
+		return err
+	}
+	keep := make(map[arbutil.MessageIndex]struct{}, len(pos))
+	for _, p := range pos {
+		keep[p] = struct{}{}
+	}
+	for _, p := range current {
+		if _, ok := keep[p]; ok {
+			delete(keep, p)
+			continue
+		}
+		if err := batch.Delete(dbKey(espressoPendingPrefix, uint64(p))); err != nil {
+			return err
+		}
+	}
+	for p := range keep {
+		if err := batch.Put(dbKey(espressoPendingPrefix, uint64(p)), []byte{}); err != nil {
+			return err
+		}
+	}
+	s.espressoPendingCount.Store(int64(len(pos)))
+	if s.espressoPendingShadowRead {
+		s.espressoPendingShadow = append([]arbutil.MessageIndex(nil), pos...)
+		return s.writeEspressoPendingShadow(batch)
+	}
+	return nil
+}
+
+func (s *TransactionStreamer) appendEspressoPendingTxnPos(batch ethdb.KeyValueWriter, pos arbutil.MessageIndex) error {
+	if err := batch.Put(dbKey(espressoPendingPrefix, uint64(pos)), []byte{}); err != nil {
+		return err
+	}
+	s.espressoPendingCount.Add(1)
+	if s.espressoPendingShadowRead {
+		s.espressoPendingShadow = append(s.espressoPendingShadow, pos)
+		return s.writeEspressoPendingShadow(batch)
+	}
+	return nil
+}
+
+// espressoPendingQueueFull returns true if no more messages may be queued for espresso submission
+func (s *TransactionStreamer) espressoPendingQueueFull() bool {
+	maxPending := s.config().Espresso.MaxPendingMessages
+	// #nosec G115
+	return maxPending != 0 && uint64(s.espressoPendingCount.Load()) >= maxPending
+}
+
+// While the shadow read is enabled, the pending queue is also written in the legacy layout of a single list,
+// and both layouts are compared on every read of the queue. The per-position keys stay authoritative, and
+// mismatches are only logged, so that the legacy layout can be dropped once a soak period saw none.
+
+// writeEspressoPendingShadow writes the queue in the legacy layout, the whole list is written every time,
+// so that the last write to a batch holds all the positions queued in it
+func (s *TransactionStreamer) writeEspressoPendingShadow(batch ethdb.KeyValueWriter) error {
+	data, err := rlp.EncodeToBytes(s.espressoPendingShadow)
+	if err != nil {
+		return err
+	}
+	return batch.Put(espressoPendingTxnsPositions, data)
+}
+
+func (s *TransactionStreamer) readLegacyEspressoPendingTxnsPos() ([]arbutil.MessageIndex, bool, error) {
+	data, err := s.db.Get(espressoPendingTxnsPositions)
+	if err != nil {
+		if dbutil.IsErrNotFound(err) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	var legacy []arbutil.MessageIndex
+	if err := rlp.DecodeBytes(data, &legacy); err != nil {
+		return nil, false, err
+	}
+	return legacy, true, nil
+}
+
+// compareEspressoPendingShadow compares the queue read from the per-position keys with the legacy layout
+func (s *TransactionStreamer) compareEspressoPendingShadow(pending []arbutil.MessageIndex) {
+	legacy, found, err := s.readLegacyEspressoPendingTxnsPos()
+	if err != nil {
+		espressoPendingShadowMismatchCounter.Inc(1)
+		log.Error("failed to read the legacy pending espresso queue", "err", err)
+		return
+	}
+	if !found {
+		espressoPendingShadowMismatchCounter.Inc(1)
+		log.Error("legacy pending espresso queue is missing while shadow reads are enabled", "positions", len(pending))
+		return
+	}
+	for i := 0; i < len(pending) || i < len(legacy); i++ {
+		if i < len(pending) && i < len(legacy) && pending[i] == legacy[i] {
+			continue
+		}
+		espressoPendingShadowMismatchCounter.Inc(1)
+		log.Error("pending espresso queue layouts differ", "positions", len(pending), "legacyPositions", len(legacy), "firstDifference", i)
+		return
+	}
+}
+
+// migrateEspressoPendingTxnsPos moves a pending queue stored as a single list to per-position keys,
+// and initializes the in-memory queue length. The single list is only dropped once shadow reads are disabled.
+func (s *TransactionStreamer) migrateEspressoPendingTxnsPos() error {
+	s.espressoPendingShadowRead = s.config().Espresso.PendingQueueShadowRead
+	legacy, found, err := s.readLegacyEspressoPendingTxnsPos()
+	if err != nil {
+		return err
+	}
+	pending, err := s.readEspressoPendingTxnsPos()
+	if err != nil {
+		return err
+	}
+	batch := s.db.NewBatch()
+	if found && len(pending) == 0 {
+		for _, pos := range legacy {
+			if err := batch.Put(dbKey(espressoPendingPrefix, uint64(pos)), []byte{}); err != nil {
+				return err
+			}
+		}
+		pending = legacy
+		log.Info("migrated the pending espresso queue to per-position keys", "positions", len(legacy))
+	}
+	if s.espressoPendingShadowRead {
+		// The legacy layout keeps evolving from its own contents, so that a divergence isn't masked
+		if found {
+			s.espressoPendingShadow = legacy
+		} else {
+			s.espressoPendingShadow = pending
+			if err := s.writeEspressoPendingShadow(batch); err != nil {
+				return err
+			}
+		}
+		log.Info("shadow reads of the legacy pending espresso queue are enabled", "positions", len(pending))
+	} else if found {
+		if err := batch.Delete(espressoPendingTxnsPositions); err != nil {
+			return err
+		}
+	}
+	if err := batch.Write(); err != nil {
+		return err
+	}
+	s.espressoPendingCount.Store(int64(len(pending)))
+	if s.espressoPendingShadowRead {
+		s.compareEspressoPendingShadow(pending)
+	}
+	return nil
+}
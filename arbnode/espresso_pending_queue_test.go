@@ -0,0 +1,111 @@
+package arbnode
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/rlp"
+
+	"github.com/offchainlabs/nitro/arbutil"
+)
+
+func TestEspressoPendingQueue(t *testing.T) {
+	config := TestTransactionStreamerConfig
+	config.Espresso.MaxPendingMessages = 3
+	streamer := &TransactionStreamer{
+		db:                     rawdb.NewMemoryDatabase(),
+		config:                 func() *TransactionStreamerConfig { return &config },
+		newSovereignTxNotifier: make(chan struct{}, 1),
+	}
+
+	// A queue stored by an older version is migrated to per-position keys
+	legacy, err := rlp.EncodeToBytes([]arbutil.MessageIndex{4, 5})
+	Require(t, err)
+	Require(t, streamer.db.Put(espressoPendingTxnsPositions, legacy))
+	Require(t, streamer.migrateEspressoPendingTxnsPos())
+	has, err := streamer.db.Has(espressoPendingTxnsPositions)
+	Require(t, err)
+	if has {
+		Fail(t, "legacy pending queue not deleted")
+	}
+
+	Require(t, streamer.SubmitEspressoTransactionPos(6, streamer.db.NewBatch()))
+	err = streamer.SubmitEspressoTransactionPos(7, streamer.db.NewBatch())
+	if !errors.Is(err, ErrEspressoPendingQueueFull) {
+		Fail(t, "expected a full queue, got", err)
+	}
+	pending, err := streamer.getEspressoPendingTxnsPos()
+	Require(t, err)
+	if !reflect.DeepEqual(pending, []arbutil.MessageIndex{4, 5, 6}) {
+		Fail(t, "unexpected pending positions", pending)
+	}
+
+	// Submitting the head of the queue makes room again
+	batch := streamer.db.NewBatch()
+	Require(t, streamer.setEspressoPendingTxnsPos(batch, pending[2:]))
+	Require(t, batch.Write())
+	Require(t, streamer.SubmitEspressoTransactionPos(7, streamer.db.NewBatch()))
+	pending, err = streamer.getEspressoPendingTxnsPos()
+	Require(t, err)
+	if !reflect.DeepEqual(pending, []arbutil.MessageIndex{6, 7}) {
+		Fail(t, "unexpected pending positions", pending)
+	}
+	if streamer.espressoPendingCount.Load() != 2 {
+		Fail(t, "unexpected pending count", streamer.espressoPendingCount.Load())
+	}
+}
+
+func TestEspressoPendingQueueShadowRead(t *testing.T) {
+	config := TestTransactionStreamerConfig
+	config.Espresso.PendingQueueShadowRead = true
+	streamer := &TransactionStreamer{
+		db:                     rawdb.NewMemoryDatabase(),
+		config:                 func() *TransactionStreamerConfig { return &config },
+		newSovereignTxNotifier: make(chan struct{}, 1),
+	}
+	legacy, err := rlp.EncodeToBytes([]arbutil.MessageIndex{4, 5})
+	Require(t, err)
+	Require(t, streamer.db.Put(espressoPendingTxnsPositions, legacy))
+	Require(t, streamer.migrateEspressoPendingTxnsPos())
+
+	// Both layouts are written while the shadow read is enabled
+	Require(t, streamer.SubmitEspressoTransactionPos(6, streamer.db.NewBatch()))
+	batch := streamer.db.NewBatch()
+	Require(t, streamer.setEspressoPendingTxnsPos(batch, []arbutil.MessageIndex{5, 6}))
+	Require(t, batch.Write())
+	mismatches := espressoPendingShadowMismatchCounter.Count()
+	pending, err := streamer.getEspressoPendingTxnsPos()
+	Require(t, err)
+	stored, found, err := streamer.readLegacyEspressoPendingTxnsPos()
+	Require(t, err)
+	if !found || !reflect.DeepEqual(stored, pending) || !reflect.DeepEqual(pending, []arbutil.MessageIndex{5, 6}) {
+		Fail(t, "layouts differ", pending, stored)
+	}
+	if espressoPendingShadowMismatchCounter.Count() != mismatches {
+		Fail(t, "mismatch counted for equal layouts")
+	}
+
+	// A divergence of the layouts is detected on the next read, and the per-position keys stay authoritative
+	Require(t, streamer.db.Delete(dbKey(espressoPendingPrefix, 5)))
+	pending, err = streamer.getEspressoPendingTxnsPos()
+	Require(t, err)
+	if !reflect.DeepEqual(pending, []arbutil.MessageIndex{6}) || espressoPendingShadowMismatchCounter.Count() != mismatches+1 {
+		Fail(t, "divergence not detected", pending)
+	}
+
+	// The legacy layout is dropped once the shadow read is disabled
+	config.Espresso.PendingQueueShadowRead = false
+	Require(t, streamer.migrateEspressoPendingTxnsPos())
+	has, err := streamer.db.Has(espressoPendingTxnsPositions)
+	Require(t, err)
+	if has {
+		Fail(t, "legacy pending queue not deleted")
+	}
+	pending, err = streamer.getEspressoPendingTxnsPos()
+	Require(t, err)
+	if !reflect.DeepEqual(pending, []arbutil.MessageIndex{6}) {
+		Fail(t, "unexpected pending positions after dropping the legacy layout", pending)
+	}
+}
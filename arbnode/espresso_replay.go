@@ -0,0 +1,219 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package arbnode
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	tagged_base64 "github.com/EspressoSystems/espresso-sequencer-go/tagged-base64"
+	espressoTypes "github.com/EspressoSystems/espresso-sequencer-go/types"
+
+	"github.com/offchainlabs/nitro/arbutil"
+)
+
+// Maximum number of messages replayed in a single call, as every message may need several query service requests
+const espressoReplayMaxMessages = 1000
+
+// EspressoReplayEntry compares what's stored for a message with what's derived again from a HotShot query node
+type EspressoReplayEntry struct {
+	Pos    arbutil.MessageIndex `json:"pos"`
+	TxHash string               `json:"txHash,omitempty"`
+	// HotShot block height of the stored justification and of the transaction on the query node
+	StoredHeight  *uint64 `json:"storedHeight,omitempty"`
+	DerivedHeight *uint64 `json:"derivedHeight,omitempty"`
+	// Differences between the stored and the derived justification, empty if they match
+	Diffs []string `json:"diffs,omitempty"`
+	// Set if the message couldn't be replayed
+	Error string `json:"error,omitempty"`
+}
+
+// EspressoReplayReport is the outcome of replaying the justification pipeline for a range of messages
+type EspressoReplayReport struct {
+	GeneratedAt uint64                `json:"generatedAt"`
+	From        arbutil.MessageIndex  `json:"from"`
+	To          arbutil.MessageIndex  `json:"to"`
+	Checked     uint64                `json:"checked"`
+	Mismatches  uint64                `json:"mismatches"`
+	Entries     []EspressoReplayEntry `json:"entries"`
+}
+
+// ReplayEspresso re-derives the HotShot block, namespace proof and merkle justification of every message in
+// [from, to] from the query service, and diffs them against the submission records and justifications stored
+// for the messages. Nothing is written, so it can be run against a live node to audit it after an incident.
+// Messages without a stored justification or submission record are skipped.
+func (s *TransactionStreamer) ReplayEspresso(ctx context.Context, from arbutil.MessageIndex, to arbutil.MessageIndex) (*EspressoReplayReport, error) {
+	if s.espressoClient == nil || s.lightClientReader == nil {
+		return nil, errors.New("espresso isn't configured")
+	}
+	if to < from {
+		return nil, fmt.Errorf("invalid range [%d, %d]", from, to)
+	}
+	if to-from >= espressoReplayMaxMessages {
+		return nil, fmt.Errorf("range [%d, %d] exceeds the limit of %d messages", from, to, espressoReplayMaxMessages)
+	}
+	count, err := s.GetMessageCount()
+	if err != nil {
+		return nil, err
+	}
+	if to >= count {
+		return nil, fmt.Errorf("message %d doesn't exist, the message count is %d", to, count)
+	}
+	report := &EspressoReplayReport{
+		// #nosec G115
+		GeneratedAt: uint64(time.Now().Unix()),
+		From:        from,
+		To:          to,
+	}
+	// Messages submitted in the same transaction share the re-derived justification
+	derived := make(map[uint64]*EspressoJustification)
+	for pos := from; pos <= to; pos++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		entry, err := s.replayEspressoMessage(ctx, pos, derived)
+		if err != nil {
+			return nil, err
+		}
+		if entry == nil {
+			continue
+		}
+		report.Checked++
+		if len(entry.Diffs) > 0 || entry.Error != "" {
+			report.Mismatches++
+		}
+		report.Entries = append(report.Entries, *entry)
+	}
+	return report, nil
+}
+
+// replayEspressoMessage replays the message at pos, returning nil if nothing is stored to compare against.
+// Errors of the query service are reported in the entry, only database errors are returned.
+func (s *TransactionStreamer) replayEspressoMessage(ctx context.Context, pos arbutil.MessageIndex, derived map[uint64]*EspressoJustification) (*EspressoReplayEntry, error) {
+	stored, err := s.GetEspressoJustification(pos)
+	if err != nil {
+		return nil, err
+	}
+	record, err := s.GetEspressoSubmissionRecord(pos)
+	if err != nil {
+		return nil, err
+	}
+	if stored == nil && (record == nil || record.TxHash == "") {
+		return nil, nil
+	}
+	entry := &EspressoReplayEntry{Pos: pos}
+	if stored != nil {
+		height := stored.HotShotHeight
+		entry.StoredHeight = &height
+	}
+
+	var height uint64
+	if record != nil && record.TxHash != "" {
+		entry.TxHash = record.TxHash
+		hash, err := tagged_base64.Parse(record.TxHash)
+		if err != nil {
+			entry.Error = fmt.Sprintf("invalid stored transaction hash: %v", err)
+			return entry, nil
+		}
+		data, err := s.espressoClient.FetchTransactionByHash(ctx, hash)
+		if err != nil {
+			entry.Error = fmt.Sprintf("failed to fetch the transaction: %v", err)
+			return entry, nil
+		}
+		height = data.BlockHeight
+	} else {
+		height = stored.HotShotHeight
+	}
+	entry.DerivedHeight = &height
+
+	// Fetched from the query service directly, so that the audit doesn't trust the block cache
+	namespace := s.chainConfig.ChainID.Uint64()
+	header, err := s.espressoClient.FetchHeaderByHeight(ctx, height)
+	if err != nil {
+		entry.Error = fmt.Sprintf("failed to fetch the header: %v", err)
+		return entry, nil
+	}
+	data, err := s.espressoClient.FetchTransactionsInBlock(ctx, height, namespace)
+	if err != nil {
+		entry.Error = fmt.Sprintf("failed to fetch the namespace: %v", err)
+		return entry, nil
+	}
+	if err := checkEspressoNamespace(height, namespace, espressoBlock{Header: header, Namespace: data}); err != nil {
+		entry.Diffs = append(entry.Diffs, fmt.Sprintf("namespace proof: %v", err))
+	}
+	found, err := s.espressoNamespaceContainsMessage(pos, data.Transactions)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		entry.Diffs = append(entry.Diffs, fmt.Sprintf("message not in the namespace of block %d", height))
+	}
+
+	justification, ok := derived[height]
+	if !ok {
+		justification, err = s.fetchEspressoJustification(ctx, height, header)
+		if err != nil {
+			entry.Error = fmt.Sprintf("failed to derive the justification: %v", err)
+			return entry, nil
+		}
+		derived[height] = justification
+	}
+	entry.Diffs = append(entry.Diffs, diffEspressoJustifications(stored, justification)...)
+	return entry, nil
+}
+
+// espressoNamespaceContainsMessage returns whether one of the transactions includes the message at pos as stored
+func (s *TransactionStreamer) espressoNamespaceContainsMessage(pos arbutil.MessageIndex, transactions []espressoTypes.Bytes) (bool, error) {
+	ours, err := s.espressoMessageBytes(pos)
+	if err != nil {
+		return false, err
+	}
+	for _, payload := range transactions {
+		if chunk, err := parseEspressoChunk(payload); err == nil {
+			if chunk.Pos == pos && chunk.last() {
+				reason, err := s.checkEspressoNamespaceChunk(chunk)
+				if err != nil {
+					return false, err
+				}
+				if reason == "" {
+					return true, nil
+				}
+			}
+			continue
+		}
+		_, indices, messages, err := s.espressoPayloadCodec().ParsePayload(payload)
+		if err != nil {
+			continue
+		}
+		for i, index := range indices {
+			if arbutil.MessageIndex(index) == pos && bytes.Equal(messages[i], ours) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// diffEspressoJustifications lists the differences between a stored and a derived justification. The block
+// merkle proof depends on the light client snapshot it was fetched against, so it's only compared when both
+// were proven against the same snapshot.
+func diffEspressoJustifications(stored *EspressoJustification, derived *EspressoJustification) []string {
+	if stored == nil {
+		return []string{"no justification stored"}
+	}
+	var diffs []string
+	if stored.HotShotHeight != derived.HotShotHeight {
+		diffs = append(diffs, fmt.Sprintf("hotshot height: stored %d, derived %d", stored.HotShotHeight, derived.HotShotHeight))
+	}
+	if !bytes.Equal(stored.Header, derived.Header) {
+		diffs = append(diffs, "header differs")
+	}
+	if stored.RootHeight == derived.RootHeight && !bytes.Equal(stored.Proof, derived.Proof) {
+		diffs = append(diffs, fmt.Sprintf("block merkle proof differs at root height %d", stored.RootHeight))
+	}
+	return diffs
+}
@@ -0,0 +1,37 @@
+package arbnode
+
+import (
+	"context"
+	"testing"
+)
+
+func TestDiffEspressoJustifications(t *testing.T) {
+	stored := &EspressoJustification{HotShotHeight: 5, Header: []byte("header"), RootHeight: 9, Proof: []byte("proof")}
+	same := *stored
+	if diffs := diffEspressoJustifications(stored, &same); len(diffs) != 0 {
+		Fail(t, "unexpected diffs of identical justifications", diffs)
+	}
+
+	// A proof against a later snapshot isn't a difference
+	later := same
+	later.RootHeight, later.Proof = 12, []byte("other proof")
+	if diffs := diffEspressoJustifications(stored, &later); len(diffs) != 0 {
+		Fail(t, "proofs against different snapshots compared", diffs)
+	}
+
+	changed := same
+	changed.HotShotHeight, changed.Header, changed.Proof = 6, []byte("other header"), []byte("other proof")
+	if diffs := diffEspressoJustifications(stored, &changed); len(diffs) != 3 {
+		Fail(t, "unexpected diffs", diffs)
+	}
+	if diffs := diffEspressoJustifications(nil, &same); len(diffs) != 1 {
+		Fail(t, "missing justification not reported", diffs)
+	}
+}
+
+func TestReplayEspressoWithoutEspresso(t *testing.T) {
+	streamer := newTestImportStreamer(t)
+	if _, err := streamer.ReplayEspresso(context.Background(), 0, 1); err == nil {
+		Fail(t, "replay without espresso succeeded")
+	}
+}
@@ -0,0 +1,296 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package arbnode
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	espressoClient "github.com/EspressoSystems/espresso-sequencer-go/client"
+	espressoTypes "github.com/EspressoSystems/espresso-sequencer-go/types"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+	flag "github.com/spf13/pflag"
+)
+
+var (
+	espressoClientRetryCounter       = metrics.NewRegisteredCounter("arb/espresso/client/retries", nil)
+	espressoClientBackoffCounter     = metrics.NewRegisteredCounter("arb/espresso/client/backoff_rejections", nil)
+	espressoClientBackoffGauge       = metrics.NewRegisteredGauge("arb/espresso/client/backoff", nil)
+	espressoClientRateLimitedCounter = metrics.NewRegisteredCounter("arb/espresso/client/rate_limited", nil)
+)
+
+// errEspressoClientBackoff is returned without contacting HotShot while the client backs off after failed requests
+var errEspressoClientBackoff = errors.New("backing off hotshot requests after failures")
+
+type EspressoClientRetryConfig struct {
+	MaxAttempts           uint64        `koanf:"max-attempts" reload:"hot"`
+	InitialBackoff        time.Duration `koanf:"initial-backoff" reload:"hot"`
+	MaxBackoff            time.Duration `koanf:"max-backoff" reload:"hot"`
+	Jitter                float64       `koanf:"jitter" reload:"hot"`
+	MaxConcurrentRequests uint64        `koanf:"max-concurrent-requests" reload:"hot"`
+	// Applies to each HotShot API endpoint separately
+	RequestsPerSecond float64 `koanf:"requests-per-second" reload:"hot"`
+}
+
+var DefaultEspressoClientRetryConfig = EspressoClientRetryConfig{
+	MaxAttempts:           3,
+	InitialBackoff:        200 * time.Millisecond,
+	MaxBackoff:            30 * time.Second,
+	Jitter:                0.2,
+	MaxConcurrentRequests: 16,
+	RequestsPerSecond:     0,
+}
+
+func EspressoClientRetryConfigAddOptions(prefix string, f *flag.FlagSet) {
+	f.Uint64(prefix+".max-attempts", DefaultEspressoClientRetryConfig.MaxAttempts, "maximum number of attempts of a hotshot request, including the first one (0 = the default)")
+	f.Duration(prefix+".initial-backoff", DefaultEspressoClientRetryConfig.InitialBackoff, "how long hotshot requests are held back after a failed request, doubled on every consecutive failure (0 = no backoff)")
+	f.Duration(prefix+".max-backoff", DefaultEspressoClientRetryConfig.MaxBackoff, "maximum time hotshot requests are held back after consecutive failures")
+	f.Float64(prefix+".jitter", DefaultEspressoClientRetryConfig.Jitter, "fraction of the backoff randomly added or removed, so that nodes don't retry in lockstep")
+	f.Uint64(prefix+".max-concurrent-requests", DefaultEspressoClientRetryConfig.MaxConcurrentRequests, "maximum number of hotshot requests in flight at once (0 = unlimited)")
+	f.Float64(prefix+".requests-per-second", DefaultEspressoClientRetryConfig.RequestsPerSecond, "maximum rate of requests to each hotshot api endpoint, requests above it wait for their turn (0 = unlimited)")
+}
+
+func (c *EspressoClientRetryConfig) Validate() error {
+	if c.MaxBackoff < c.InitialBackoff {
+		return fmt.Errorf("espresso client-retry max-backoff %v is shorter than the initial-backoff %v", c.MaxBackoff, c.InitialBackoff)
+	}
+	if c.Jitter < 0 || c.Jitter > 1 {
+		return fmt.Errorf("espresso client-retry jitter %v must be between 0 and 1", c.Jitter)
+	}
+	if c.RequestsPerSecond < 0 {
+		return fmt.Errorf("espresso client-retry requests-per-second %v can't be negative", c.RequestsPerSecond)
+	}
+	return nil
+}
+
+// maxAttempts returns the maximum number of attempts of a request, the default if it isn't set
+func (c *EspressoClientRetryConfig) maxAttempts() uint64 {
+	if c.MaxAttempts == 0 {
+		return DefaultEspressoClientRetryConfig.MaxAttempts
+	}
+	return c.MaxAttempts
+}
+
+// espressoRetryClient applies the retry policy to the requests of the streamer to HotShot. Consecutive failures
+// open a backoff window growing exponentially, during which new requests fail without reaching HotShot, so the
+// loops polling at a fixed interval don't keep hammering the query nodes during an outage. A request failing
+// while it isn't backing off is retried once the window closes, up to the maximum number of attempts.
+type espressoRetryClient struct {
+	client espressoQueryClient
+	config func() *EspressoClientRetryConfig
+
+	mutex        sync.Mutex
+	failures     uint64
+	backoffUntil time.Time
+	inFlight     uint64
+	// Closed and replaced whenever a request completes, to wake up the requests waiting for a slot
+	released chan struct{}
+	// Per endpoint, the earliest time the next request may be sent
+	nextRequest map[string]time.Time
+}
+
+var _ espressoQueryClient = (*espressoRetryClient)(nil)
+
+func newEspressoRetryClient(client espressoQueryClient, config func() *EspressoClientRetryConfig) *espressoRetryClient {
+	return &espressoRetryClient{
+		client:      client,
+		config:      config,
+		released:    make(chan struct{}),
+		nextRequest: make(map[string]time.Time),
+	}
+}
+
+// espressoRequestAnswered returns whether err was returned by a query node that answered the request,
+// which isn't a sign of an outage: the transaction or block asked for isn't known yet
+func espressoRequestAnswered(err error) bool {
+	return strings.Contains(err.Error(), "status 404")
+}
+
+// backoffRemaining returns how long the requests are still held back
+func (c *espressoRetryClient) backoffRemaining(now time.Time) time.Duration {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if now.Before(c.backoffUntil) {
+		return c.backoffUntil.Sub(now)
+	}
+	return 0
+}
+
+func (c *espressoRetryClient) recordSuccess() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.failures = 0
+	espressoClientBackoffGauge.Update(0)
+}
+
+// recordFailure extends the backoff window after a failed request and returns its length
+func (c *espressoRetryClient) recordFailure(now time.Time) time.Duration {
+	config := c.config()
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.failures++
+	backoff := config.InitialBackoff
+	for i := uint64(1); i < c.failures && backoff < config.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	backoff = min(backoff, config.MaxBackoff)
+	if config.Jitter > 0 {
+		backoff += time.Duration((rand.Float64()*2 - 1) * config.Jitter * float64(backoff))
+	}
+	if until := now.Add(backoff); until.After(c.backoffUntil) {
+		c.backoffUntil = until
+	}
+	espressoClientBackoffGauge.Update(backoff.Milliseconds())
+	return backoff
+}
+
+// acquire waits for a request slot and the turn of the endpoint under the rate limit
+func (c *espressoRetryClient) acquire(ctx context.Context, method string) error {
+	for {
+		config := c.config()
+		c.mutex.Lock()
+		if config.MaxConcurrentRequests == 0 || c.inFlight < config.MaxConcurrentRequests {
+			c.inFlight++
+			c.mutex.Unlock()
+			break
+		}
+		released := c.released
+		c.mutex.Unlock()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-released:
+		}
+	}
+	config := c.config()
+	if config.RequestsPerSecond <= 0 {
+		return nil
+	}
+	now := time.Now()
+	c.mutex.Lock()
+	slot := c.nextRequest[method]
+	if slot.Before(now) {
+		slot = now
+	}
+	c.nextRequest[method] = slot.Add(time.Duration(float64(time.Second) / config.RequestsPerSecond))
+	c.mutex.Unlock()
+	if wait := slot.Sub(now); wait > 0 {
+		espressoClientRateLimitedCounter.Inc(1)
+		select {
+		case <-ctx.Done():
+			c.release()
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+	return nil
+}
+
+func (c *espressoRetryClient) release() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.inFlight--
+	close(c.released)
+	c.released = make(chan struct{})
+}
+
+func espressoRetryCall[T any](ctx context.Context, c *espressoRetryClient, method string, call func(context.Context) (T, error)) (T, error) {
+	var zero T
+	for attempt := uint64(1); ; attempt++ {
+		if wait := c.backoffRemaining(time.Now()); wait > 0 {
+			if attempt == 1 {
+				espressoClientBackoffCounter.Inc(1)
+				return zero, fmt.Errorf("%w: %v left", errEspressoClientBackoff, wait)
+			}
+			select {
+			case <-ctx.Done():
+				return zero, ctx.Err()
+			case <-time.After(wait):
+			}
+		}
+		if err := c.acquire(ctx, method); err != nil {
+			return zero, err
+		}
+		res, err := call(ctx)
+		c.release()
+		if err == nil || espressoRequestAnswered(err) {
+			c.recordSuccess()
+			return res, err
+		}
+		if ctx.Err() != nil {
+			return zero, err
+		}
+		backoff := c.recordFailure(time.Now())
+		if attempt >= c.config().maxAttempts() {
+			return zero, err
+		}
+		espressoClientRetryCounter.Inc(1)
+		log.Debug("hotshot request failed, retrying", "method", method, "attempt", attempt, "backoff", backoff, "err", err)
+	}
+}
+
+func (c *espressoRetryClient) FetchLatestBlockHeight(ctx context.Context) (uint64, error) {
+	return espressoRetryCall(ctx, c, "FetchLatestBlockHeight", func(ctx context.Context) (uint64, error) {
+		return c.client.FetchLatestBlockHeight(ctx)
+	})
+}
+
+func (c *espressoRetryClient) FetchHeaderByHeight(ctx context.Context, blockHeight uint64) (espressoTypes.HeaderImpl, error) {
+	return espressoRetryCall(ctx, c, "FetchHeaderByHeight", func(ctx context.Context) (espressoTypes.HeaderImpl, error) {
+		return c.client.FetchHeaderByHeight(ctx, blockHeight)
+	})
+}
+
+func (c *espressoRetryClient) FetchHeadersByRange(ctx context.Context, from uint64, until uint64) ([]espressoTypes.HeaderImpl, error) {
+	return espressoRetryCall(ctx, c, "FetchHeadersByRange", func(ctx context.Context) ([]espressoTypes.HeaderImpl, error) {
+		return c.client.FetchHeadersByRange(ctx, from, until)
+	})
+}
+
+func (c *espressoRetryClient) FetchTransactionByHash(ctx context.Context, hash *espressoTypes.TaggedBase64) (espressoTypes.TransactionQueryData, error) {
+	return espressoRetryCall(ctx, c, "FetchTransactionByHash", func(ctx context.Context) (espressoTypes.TransactionQueryData, error) {
+		return c.client.FetchTransactionByHash(ctx, hash)
+	})
+}
+
+func (c *espressoRetryClient) FetchBlockMerkleProof(ctx context.Context, rootHeight uint64, hotshotHeight uint64) (espressoTypes.HotShotBlockMerkleProof, error) {
+	return espressoRetryCall(ctx, c, "FetchBlockMerkleProof", func(ctx context.Context) (espressoTypes.HotShotBlockMerkleProof, error) {
+		return c.client.FetchBlockMerkleProof(ctx, rootHeight, hotshotHeight)
+	})
+}
+
+func (c *espressoRetryClient) FetchTransactionsInBlock(ctx context.Context, blockHeight uint64, namespace uint64) (espressoClient.TransactionsInBlock, error) {
+	return espressoRetryCall(ctx, c, "FetchTransactionsInBlock", func(ctx context.Context) (espressoClient.TransactionsInBlock, error) {
+		return c.client.FetchTransactionsInBlock(ctx, blockHeight, namespace)
+	})
+}
+
+// SubmitTransaction may safely be retried, as HotShot identifies transactions by their commitment
+func (c *espressoRetryClient) SubmitTransaction(ctx context.Context, tx espressoTypes.Transaction) (*espressoTypes.TaggedBase64, error) {
+	return espressoRetryCall(ctx, c, "SubmitTransaction", func(ctx context.Context) (*espressoTypes.TaggedBase64, error) {
+		return c.client.SubmitTransaction(ctx, tx)
+	})
+}
+
+// setEspressoClient sets the client of the streamer to HotShot, behind the retry policy
+func (s *TransactionStreamer) setEspressoClient(client *espressoMultiClient) {
+	client.setBuilderUrls(s.config().Espresso.BuilderUrls)
+	s.espressoClient = newEspressoRetryClient(client, func() *EspressoClientRetryConfig { return &s.config().Espresso.ClientRetry })
+}
+
+// espressoMultiClient returns the client spreading the requests of the streamer over the query nodes, if any
+func (s *TransactionStreamer) espressoMultiClient() (*espressoMultiClient, bool) {
+	client := s.espressoClient
+	if retryClient, ok := client.(*espressoRetryClient); ok {
+		client = retryClient.client
+	}
+	multiClient, ok := client.(*espressoMultiClient)
+	return multiClient, ok
+}
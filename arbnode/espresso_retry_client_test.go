@@ -0,0 +1,101 @@
+package arbnode
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	espressoTypes "github.com/EspressoSystems/espresso-sequencer-go/types"
+)
+
+type flakyEspressoClient struct {
+	countingEspressoClient
+	failures int
+	calls    int
+}
+
+func (c *flakyEspressoClient) FetchLatestBlockHeight(ctx context.Context) (uint64, error) {
+	c.calls++
+	if c.calls <= c.failures {
+		return 0, errors.New("connection refused")
+	}
+	return 42, nil
+}
+
+func (c *flakyEspressoClient) FetchHeadersByRange(ctx context.Context, from uint64, until uint64) ([]espressoTypes.HeaderImpl, error) {
+	c.rangeCalls++
+	return nil, fmt.Errorf("request failed with status 404 and body %s", "not found")
+}
+
+func TestEspressoRetryClient(t *testing.T) {
+	ctx := context.Background()
+	config := DefaultEspressoClientRetryConfig
+	config.InitialBackoff = 10 * time.Millisecond
+	config.MaxBackoff = 40 * time.Millisecond
+	config.Jitter = 0
+	flaky := &flakyEspressoClient{failures: 2}
+	client := newEspressoRetryClient(flaky, func() *EspressoClientRetryConfig { return &config })
+
+	// Retried after backing off until it succeeds
+	height, err := client.FetchLatestBlockHeight(ctx)
+	Require(t, err)
+	if height != 42 || flaky.calls != 3 {
+		Fail(t, "unexpected result after retries", height, flaky.calls)
+	}
+
+	// A query node answering that a block isn't known yet isn't retried
+	if _, err := client.FetchHeadersByRange(ctx, 1, 2); err == nil || flaky.rangeCalls != 1 || client.backoffRemaining(time.Now()) != 0 {
+		Fail(t, "not found answer retried or backed off", err, flaky.rangeCalls)
+	}
+
+	// Failing all its attempts opens a backoff window rejecting requests without reaching hotshot
+	flaky.calls, flaky.failures = 0, 10
+	if _, err := client.FetchLatestBlockHeight(ctx); err == nil || errors.Is(err, errEspressoClientBackoff) {
+		Fail(t, "expected the request to fail", err)
+	}
+	if flaky.calls != 3 {
+		Fail(t, "unexpected number of attempts", flaky.calls)
+	}
+	if _, err := client.FetchLatestBlockHeight(ctx); !errors.Is(err, errEspressoClientBackoff) {
+		Fail(t, "request not rejected while backing off", err)
+	}
+	if flaky.calls != 3 {
+		Fail(t, "hotshot reached while backing off", flaky.calls)
+	}
+	// The backoff doubled on every failure, up to the maximum
+	if remaining := client.backoffRemaining(time.Now()); remaining <= 20*time.Millisecond || remaining > config.MaxBackoff {
+		Fail(t, "unexpected backoff", remaining)
+	}
+	time.Sleep(config.MaxBackoff)
+	flaky.calls, flaky.failures = 0, 0
+	_, err = client.FetchLatestBlockHeight(ctx)
+	Require(t, err)
+	if client.backoffRemaining(time.Now()) != 0 || client.failures != 0 {
+		Fail(t, "backoff not reset after a success")
+	}
+}
+
+func TestEspressoRetryClientRateLimit(t *testing.T) {
+	ctx := context.Background()
+	config := DefaultEspressoClientRetryConfig
+	config.RequestsPerSecond = 50
+	client := newEspressoRetryClient(&flakyEspressoClient{}, func() *EspressoClientRetryConfig { return &config })
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		_, err := client.FetchLatestBlockHeight(ctx)
+		Require(t, err)
+	}
+	// The first request is sent right away, the next ones 20ms apart
+	if elapsed := time.Since(start); elapsed < 80*time.Millisecond {
+		Fail(t, "requests not rate limited", elapsed)
+	}
+	// Endpoints are limited separately
+	start = time.Now()
+	_, err := client.FetchHeaderByHeight(ctx, 1)
+	Require(t, err)
+	if elapsed := time.Since(start); elapsed >= 20*time.Millisecond {
+		Fail(t, "request held back by another endpoint's limit", elapsed)
+	}
+}
@@ -0,0 +1,177 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package arbnode
+
+import (
+	"context"
+	"math/big"
+	"sort"
+	"time"
+
+	"github.com/ethereum/go-ethereum/metrics"
+
+	"github.com/offchainlabs/nitro/arbutil"
+)
+
+var espressoShadowFinalityLatencyHistogram = metrics.NewRegisteredHistogram("arb/streamer/espresso/shadow/finality_latency", nil, metrics.NewBoundedHistogramSample())
+
+// Number of most recent messages covered by the shadow report when no limit is given
+const defaultEspressoShadowReportMessages = 1000
+
+// espressoShadowMode returns whether the messages are submitted to espresso without local progress ever
+// depending on their finality: sequencing isn't held back by the pending queue, the batch poster doesn't
+// wait for the messages to be finalized and the deadline fallback isn't applied
+func (s *TransactionStreamer) espressoShadowMode() bool {
+	return s.config().Espresso.ShadowMode
+}
+
+// recordEspressoShadowFinality measures the time from the sequencing of the messages at positions to their
+// finalization by HotShot, now
+func (s *TransactionStreamer) recordEspressoShadowFinality(positions []arbutil.MessageIndex, now time.Time) {
+	for _, pos := range positions {
+		msg, err := s.GetMessage(pos)
+		if err != nil {
+			continue
+		}
+		// #nosec G115
+		sequencedAt := time.Unix(int64(msg.Message.Header.Timestamp), 0)
+		espressoShadowFinalityLatencyHistogram.Update(now.Sub(sequencedAt).Milliseconds())
+	}
+}
+
+// EspressoLatencySummary summarizes the latencies of messages, in the unit of the report it belongs to
+type EspressoLatencySummary struct {
+	Count uint64  `json:"count"`
+	Mean  float64 `json:"mean"`
+	P50   uint64  `json:"p50"`
+	P90   uint64  `json:"p90"`
+	Max   uint64  `json:"max"`
+}
+
+func summarizeEspressoLatencies(latencies []uint64) *EspressoLatencySummary {
+	if len(latencies) == 0 {
+		return nil
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	var sum uint64
+	for _, latency := range latencies {
+		sum += latency
+	}
+	return &EspressoLatencySummary{
+		Count: uint64(len(latencies)),
+		Mean:  float64(sum) / float64(len(latencies)),
+		P50:   latencies[len(latencies)/2],
+		P90:   latencies[len(latencies)*9/10],
+		Max:   latencies[len(latencies)-1],
+	}
+}
+
+// EspressoShadowReport compares the finality latency of messages on espresso against the production one,
+// for the messages in [From, To). Latencies are measured from the message timestamp, to the finalization of
+// the message by HotShot for espresso, and to the parent chain block of the batch including the message for
+// production. Messages without both are only counted in their own summary.
+type EspressoShadowReport struct {
+	ShadowMode bool                 `json:"shadowMode"`
+	From       arbutil.MessageIndex `json:"from"`
+	To         arbutil.MessageIndex `json:"to"`
+	// Messages finalized by HotShot and included in a posted batch
+	Finalized uint64 `json:"finalized"`
+	Posted    uint64 `json:"posted"`
+	// Messages both finalized and posted, and how many of them were finalized by HotShot first
+	Compared          uint64                  `json:"compared"`
+	FasterOnEspresso  uint64                  `json:"fasterOnEspresso"`
+	EspressoLatency   *EspressoLatencySummary `json:"espressoLatency,omitempty"`
+	ProductionLatency *EspressoLatencySummary `json:"productionLatency,omitempty"`
+}
+
+// EspressoShadowReport builds the report of the limit most recent messages
+func (s *TransactionStreamer) EspressoShadowReport(ctx context.Context, limit uint64) (*EspressoShadowReport, error) {
+	if limit == 0 {
+		limit = defaultEspressoShadowReportMessages
+	}
+	count, err := s.GetMessageCount()
+	if err != nil {
+		return nil, err
+	}
+	from := s.espressoMigrationActivationPos()
+	if uint64(count) > limit && count-arbutil.MessageIndex(limit) > from {
+		from = count - arbutil.MessageIndex(limit)
+	}
+	report := &EspressoShadowReport{ShadowMode: s.espressoShadowMode(), From: from, To: max(from, count)}
+	var espressoLatencies, productionLatencies []uint64
+	// The timestamps of the parent chain blocks batches were posted in
+	blockTimes := make(map[uint64]uint64)
+	for pos := report.From; pos < report.To; pos++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		msg, err := s.GetMessage(pos)
+		if err != nil {
+			return nil, err
+		}
+		sequencedAt := msg.Message.Header.Timestamp
+
+		var finalizedAt, postedAt *uint64
+		record, err := s.GetEspressoSubmissionRecord(pos)
+		if err != nil {
+			return nil, err
+		}
+		if record != nil && record.Status == EspressoSubmissionFinalized {
+			report.Finalized++
+			finalizedAt = &record.UpdatedAt
+			espressoLatencies = append(espressoLatencies, saturatingLatency(sequencedAt, record.UpdatedAt))
+		}
+		postedAt, err = s.batchPostedAt(ctx, pos, blockTimes)
+		if err != nil {
+			return nil, err
+		}
+		if postedAt != nil {
+			report.Posted++
+			productionLatencies = append(productionLatencies, saturatingLatency(sequencedAt, *postedAt))
+		}
+		if finalizedAt != nil && postedAt != nil {
+			report.Compared++
+			if *finalizedAt < *postedAt {
+				report.FasterOnEspresso++
+			}
+		}
+	}
+	report.EspressoLatency = summarizeEspressoLatencies(espressoLatencies)
+	report.ProductionLatency = summarizeEspressoLatencies(productionLatencies)
+	return report, nil
+}
+
+func saturatingLatency(from uint64, to uint64) uint64 {
+	if to < from {
+		return 0
+	}
+	return to - from
+}
+
+// batchPostedAt returns the timestamp of the parent chain block of the batch including the message at pos,
+// or nil if the message wasn't posted yet or batches aren't read
+func (s *TransactionStreamer) batchPostedAt(ctx context.Context, pos arbutil.MessageIndex, blockTimes map[uint64]uint64) (*uint64, error) {
+	if s.inboxReader == nil || s.inboxReader.tracker == nil || s.inboxReader.l1Reader == nil {
+		return nil, nil
+	}
+	tracker := s.inboxReader.tracker
+	batch, found, err := tracker.FindInboxBatchContainingMessage(pos)
+	if err != nil || !found {
+		return nil, err
+	}
+	block, err := tracker.GetBatchParentChainBlock(batch)
+	if err != nil {
+		return nil, err
+	}
+	blockTime, ok := blockTimes[block]
+	if !ok {
+		header, err := s.inboxReader.l1Reader.Client().HeaderByNumber(ctx, new(big.Int).SetUint64(block))
+		if err != nil {
+			return nil, err
+		}
+		blockTime = header.Time
+		blockTimes[block] = blockTime
+	}
+	return &blockTime, nil
+}
@@ -0,0 +1,61 @@
+package arbnode
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/offchainlabs/nitro/arbos/arbostypes"
+	"github.com/offchainlabs/nitro/arbutil"
+	"github.com/offchainlabs/nitro/execution"
+)
+
+func TestEspressoShadowMode(t *testing.T) {
+	streamer := newTestImportStreamer(t)
+	streamer.exec = &headOnlyExecution{}
+	config := streamer.config()
+	config.Espresso.MaxPendingMessages = 1
+	config.Espresso.SubmissionDeadline = time.Hour
+	// #nosec G115
+	now := uint64(time.Now().Unix())
+	sequence := func(pos arbutil.MessageIndex) error {
+		msg := arbostypes.MessageWithMetadata{
+			Message: &arbostypes.L1IncomingMessage{Header: &arbostypes.L1IncomingMessageHeader{Timestamp: now - 10}},
+		}
+		return streamer.WriteMessageFromSequencer(pos, msg, execution.MessageResult{})
+	}
+	Require(t, sequence(0))
+	Require(t, streamer.SubmitEspressoTransactionPos(0, streamer.db.NewBatch()))
+	if err := sequence(1); !errors.Is(err, ErrEspressoPendingQueueFull) {
+		Fail(t, "sequencing not held back by the full pending queue", err)
+	}
+
+	config.Espresso.ShadowMode = true
+	Require(t, sequence(1))
+	Require(t, sequence(2))
+	// No deadline is set for messages queued in shadow mode
+	config.Espresso.MaxPendingMessages = 0
+	Require(t, streamer.SubmitEspressoTransactionPos(1, streamer.db.NewBatch()))
+	if has, err := streamer.db.Has(dbKey(espressoDeadlinePrefix, 1)); err != nil || has {
+		Fail(t, "deadline set in shadow mode", err)
+	}
+
+	batch := streamer.db.NewBatch()
+	Require(t, streamer.setEspressoSubmissionStatus(batch, []arbutil.MessageIndex{0, 1}, EspressoSubmissionFinalized, nil))
+	Require(t, batch.Write())
+	report, err := streamer.EspressoShadowReport(context.Background(), 0)
+	Require(t, err)
+	if !report.ShadowMode || report.From != 0 || report.To != 3 || report.Finalized != 2 || report.Posted != 0 {
+		Fail(t, "unexpected shadow report", report)
+	}
+	if report.EspressoLatency == nil || report.EspressoLatency.Count != 2 || report.EspressoLatency.Max < 10 || report.ProductionLatency != nil {
+		Fail(t, "unexpected shadow latencies", report.EspressoLatency, report.ProductionLatency)
+	}
+
+	report, err = streamer.EspressoShadowReport(context.Background(), 1)
+	Require(t, err)
+	if report.From != 2 || report.Finalized != 0 || report.EspressoLatency != nil {
+		Fail(t, "unexpected limited shadow report", report)
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package arbnode
+
+import (
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rlp"
+
+	"github.com/offchainlabs/nitro/util/dbutil"
+)
+
+// espressoShutdownRecord is written once the espresso loops were drained on shutdown, and deleted on startup,
+// so that a missing record on startup means the previous run was cut off, possibly in the middle of a submission
+type espressoShutdownRecord struct {
+	StoppedAt     uint64
+	SubmittedHash string
+	Submitted     uint64
+	Pending       uint64
+}
+
+func (s *TransactionStreamer) getEspressoShutdownRecord() (*espressoShutdownRecord, error) {
+	data, err := s.db.Get(espressoCleanShutdownKey)
+	if err != nil {
+		if dbutil.IsErrNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var record espressoShutdownRecord
+	if err := rlp.DecodeBytes(data, &record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// recordEspressoCleanShutdown writes the clean shutdown marker with a summary of the drained espresso state
+func (s *TransactionStreamer) recordEspressoCleanShutdown(submitted int, pending int) error {
+	hash, err := s.getEspressoSubmittedHash()
+	if err != nil {
+		return err
+	}
+	record := espressoShutdownRecord{
+		// #nosec G115
+		StoppedAt: uint64(time.Now().Unix()),
+		// #nosec G115
+		Submitted: uint64(submitted),
+		// #nosec G115
+		Pending: uint64(pending),
+	}
+	if hash != nil {
+		record.SubmittedHash = hash.String()
+	}
+	data, err := rlp.EncodeToBytes(record)
+	if err != nil {
+		return err
+	}
+	return s.db.Put(espressoCleanShutdownKey, data)
+}
+
+// checkEspressoShutdown consumes the clean shutdown marker of the previous run. If it's missing while a
+// transaction is in flight, the submission may have been cut off before its state was stored, which is
+// reported until the in-flight transaction was reconciled with HotShot.
+func (s *TransactionStreamer) checkEspressoShutdown() error {
+	record, err := s.getEspressoShutdownRecord()
+	if err != nil {
+		return err
+	}
+	if record != nil {
+		// #nosec G115
+		stoppedAt := time.Unix(int64(record.StoppedAt), 0).UTC()
+		log.Info("espresso loops were shut down cleanly", "stoppedAt", stoppedAt, "submittedHash", record.SubmittedHash, "submitted", record.Submitted, "pending", record.Pending)
+		return s.db.Delete(espressoCleanShutdownKey)
+	}
+	submitted, err := s.getEspressoSubmittedPos()
+	if err != nil {
+		return err
+	}
+	if len(submitted) > 0 {
+		log.Warn("espresso loops weren't shut down cleanly with a transaction in flight, it will be reconciled with hotshot", "submitted", len(submitted))
+		s.espressoUncleanShutdown.Store(true)
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package arbnode
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/rawdb"
+
+	"github.com/offchainlabs/nitro/arbutil"
+)
+
+func TestEspressoCleanShutdownMarker(t *testing.T) {
+	config := TestTransactionStreamerConfig
+	config.Espresso.ShutdownTimeout = time.Second
+	streamer := &TransactionStreamer{
+		db:                 rawdb.NewMemoryDatabase(),
+		config:             func() *TransactionStreamerConfig { return &config },
+		espressoSwitchSlot: make(chan struct{}, 1),
+	}
+	streamer.StopWaiter.Start(context.Background(), streamer)
+	defer streamer.StopWaiter.StopAndWait()
+
+	Require(t, streamer.SubmitEspressoTransactionPos(3, streamer.db.NewBatch()))
+	streamer.stopEspresso()
+	record, err := streamer.getEspressoShutdownRecord()
+	Require(t, err)
+	if record == nil || record.Pending != 1 || record.Submitted != 0 {
+		Fail(t, "unexpected clean shutdown record", record)
+	}
+
+	// The marker is consumed on startup
+	Require(t, streamer.checkEspressoShutdown())
+	if streamer.espressoUncleanShutdown.Load() {
+		Fail(t, "clean shutdown reported as unclean")
+	}
+	record, err = streamer.getEspressoShutdownRecord()
+	Require(t, err)
+	if record != nil {
+		Fail(t, "clean shutdown marker not consumed", record)
+	}
+
+	// Without the marker, a transaction in flight may have been cut off
+	batch := streamer.db.NewBatch()
+	Require(t, streamer.setEspressoSubmittedPos(batch, []arbutil.MessageIndex{3}))
+	Require(t, batch.Write())
+	Require(t, streamer.checkEspressoShutdown())
+	if !streamer.espressoUncleanShutdown.Load() {
+		Fail(t, "unclean shutdown not detected")
+	}
+}
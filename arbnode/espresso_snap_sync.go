@@ -0,0 +1,119 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package arbnode
+
+import (
+	"context"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rlp"
+
+	"github.com/offchainlabs/nitro/arbutil"
+	"github.com/offchainlabs/nitro/util/dbutil"
+)
+
+// How long the bootstrap of a snap synced node waits for HotShot's latest block height
+const espressoSnapSyncHeightTimeout = 10 * time.Second
+
+func (s *TransactionStreamer) getEspressoSnapSyncPos() (*arbutil.MessageIndex, error) {
+	data, err := s.db.Get(espressoSnapSyncPosKey)
+	if err != nil {
+		if dbutil.IsErrNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var pos arbutil.MessageIndex
+	if err := rlp.DecodeBytes(data, &pos); err != nil {
+		return nil, err
+	}
+	return &pos, nil
+}
+
+// bootstrapEspressoSnapSync derives the espresso state of a node snap synced above genesis, which doesn't have
+// the messages before the snapshot. Those messages were posted in batches, so they're taken as confirmed and
+// justified: the confirmed position, the justification backfill and the justified watermark start from the
+// snapshot. The HotShot height they were finalized by comes from the snapshot metadata, or is bounded by the
+// latest HotShot height otherwise. The state is derived once, on the first start after the snap sync.
+func (s *TransactionStreamer) bootstrapEspressoSnapSync(ctx context.Context) error {
+	syncPos, err := s.getEspressoSnapSyncPos()
+	if err != nil {
+		return err
+	}
+	if syncPos == nil && s.snapSyncConfig != nil && s.snapSyncConfig.Enabled && s.snapSyncConfig.PrevBatchMessageCount > 0 {
+		pos := arbutil.MessageIndex(s.snapSyncConfig.PrevBatchMessageCount)
+		if err := s.writeEspressoSnapSyncState(ctx, pos); err != nil {
+			return err
+		}
+		syncPos = &pos
+	}
+	if syncPos != nil {
+		s.espressoWatermarkMutex.Lock()
+		s.espressoSnapSyncPos = *syncPos
+		s.espressoWatermarkMutex.Unlock()
+	}
+	return nil
+}
+
+func (s *TransactionStreamer) writeEspressoSnapSyncState(ctx context.Context, syncPos arbutil.MessageIndex) error {
+	s.espressoTxnsStateInsertionMutex.Lock()
+	defer s.espressoTxnsStateInsertionMutex.Unlock()
+	batch := s.db.NewBatch()
+	data, err := rlp.EncodeToBytes(syncPos)
+	if err != nil {
+		return err
+	}
+	if err := batch.Put(espressoSnapSyncPosKey, data); err != nil {
+		return err
+	}
+	lastBefore := syncPos - 1
+	if s.isEspressoActiveAt(lastBefore) {
+		lastConfirmed, err := s.getLastConfirmedPos()
+		if err != nil {
+			return err
+		}
+		if lastConfirmed == nil || *lastConfirmed < lastBefore {
+			if err := s.setEspressoLastConfirmedPos(batch, &lastBefore); err != nil {
+				return err
+			}
+		}
+		backfillPos, err := s.getEspressoJustificationBackfillPos()
+		if err != nil {
+			return err
+		}
+		if backfillPos < syncPos {
+			if err := s.setEspressoJustificationBackfillPos(batch, syncPos); err != nil {
+				return err
+			}
+		}
+		finalizedHeight, err := s.getEspressoLastFinalizedHeight()
+		if err != nil {
+			return err
+		}
+		if finalizedHeight == nil {
+			height := s.snapSyncConfig.EspressoFinalizedHeight
+			if height == 0 && s.espressoClient != nil {
+				heightCtx, cancel := context.WithTimeout(ctx, espressoSnapSyncHeightTimeout)
+				height, err = s.espressoClient.FetchLatestBlockHeight(heightCtx)
+				cancel()
+				if err != nil {
+					// Only the status and the audit trail of submissions rely on it, until the next finality sets it
+					log.Warn("failed to get the latest hotshot height for the snap synced espresso state", "err", err)
+					height = 0
+				}
+			}
+			if height > 0 {
+				if err := s.setEspressoLastFinalizedHeight(batch, height); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if err := batch.Write(); err != nil {
+		return err
+	}
+	log.Info("bootstrapped the espresso state of the snap synced node", "syncPos", syncPos, "espressoActive", s.isEspressoActiveAt(lastBefore))
+	return nil
+}
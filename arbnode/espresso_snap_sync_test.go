@@ -0,0 +1,76 @@
+package arbnode
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/core/rawdb"
+
+	"github.com/offchainlabs/nitro/arbos/arbostypes"
+	"github.com/offchainlabs/nitro/arbutil"
+)
+
+func TestEspressoSnapSyncBootstrap(t *testing.T) {
+	ctx := context.Background()
+	db := rawdb.NewMemoryDatabase()
+	snapSync := SnapSyncConfig{Enabled: true, PrevBatchMessageCount: 3, EspressoFinalizedHeight: 70}
+	newStreamer := func(snapSync *SnapSyncConfig) *TransactionStreamer {
+		return newTestStreamer(t, db, nil, WithSnapSyncConfig(snapSync))
+	}
+	streamer := newStreamer(&snapSync)
+	var messages []arbostypes.MessageWithMetadata
+	for i := uint64(0); i < 5; i++ {
+		messages = append(messages, arbostypes.MessageWithMetadata{
+			Message: &arbostypes.L1IncomingMessage{Header: &arbostypes.L1IncomingMessageHeader{Timestamp: i}},
+		})
+	}
+	Require(t, streamer.AddMessages(0, true, messages))
+
+	expectState := func(streamer *TransactionStreamer) {
+		t.Helper()
+		lastConfirmed, err := streamer.getLastConfirmedPos()
+		Require(t, err)
+		if lastConfirmed == nil || *lastConfirmed != 2 {
+			Fail(t, "unexpected last confirmed position", lastConfirmed)
+		}
+		backfillPos, err := streamer.getEspressoJustificationBackfillPos()
+		Require(t, err)
+		if backfillPos != 3 {
+			Fail(t, "unexpected justification backfill position", backfillPos)
+		}
+		height, err := streamer.getEspressoLastFinalizedHeight()
+		Require(t, err)
+		if height == nil || *height != 70 {
+			Fail(t, "unexpected last finalized height", height)
+		}
+		// The messages before the snapshot are justified, the ones after it aren't
+		index, err := streamer.MaxEspressoFinalizedIndex()
+		Require(t, err)
+		if index == nil || *index != 2 {
+			Fail(t, "unexpected max finalized index", index)
+		}
+		watermark, err := streamer.EspressoJustifiedWatermark()
+		Require(t, err)
+		if watermark != 3 {
+			Fail(t, "unexpected justified watermark", watermark)
+		}
+	}
+	Require(t, streamer.bootstrapEspressoSnapSync(ctx))
+	expectState(streamer)
+
+	// Later starts keep the derived state, even without the snap sync config
+	lastConfirmed := arbutil.MessageIndex(4)
+	Require(t, streamer.setEspressoLastConfirmedPos(db, &lastConfirmed))
+	restarted := newStreamer(&DefaultSnapSyncConfig)
+	Require(t, restarted.bootstrapEspressoSnapSync(ctx))
+	confirmed, err := restarted.getLastConfirmedPos()
+	Require(t, err)
+	if confirmed == nil || *confirmed != 4 {
+		Fail(t, "snap sync state derived again", confirmed)
+	}
+	index, err := restarted.MaxEspressoFinalizedIndex()
+	Require(t, err)
+	if index == nil || *index != 2 {
+		Fail(t, "snap sync position not loaded", index)
+	}
+}
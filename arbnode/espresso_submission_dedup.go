@@ -0,0 +1,130 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package arbnode
+
+import (
+	"context"
+	"time"
+
+	espressoTypes "github.com/EspressoSystems/espresso-sequencer-go/types"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/rlp"
+
+	"github.com/offchainlabs/nitro/util/dbutil"
+)
+
+var espressoSubmissionDedupedCounter = metrics.NewRegisteredCounter("arb/espresso/submission/deduped", nil)
+
+// Maximum number of recent submissions remembered, regardless of the dedup window
+const espressoRecentSubmissionsLimit = 32
+
+// espressoRecentSubmission is a payload that was recently sent to the ordering layer
+type espressoRecentSubmission struct {
+	PayloadHash common.Hash
+	TxHash      string
+	SubmittedAt uint64
+}
+
+func (s *TransactionStreamer) getEspressoRecentSubmissions() ([]espressoRecentSubmission, error) {
+	data, err := s.db.Get(espressoRecentSubmissionsKey)
+	if err != nil {
+		if dbutil.IsErrNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var submissions []espressoRecentSubmission
+	if err := rlp.DecodeBytes(data, &submissions); err != nil {
+		return nil, err
+	}
+	return submissions, nil
+}
+
+// recentEspressoSubmission returns the submission of the payload with payloadHash within the dedup window, if any
+func (s *TransactionStreamer) recentEspressoSubmission(payloadHash common.Hash, now time.Time) (*espressoRecentSubmission, error) {
+	window := s.config().Espresso.SubmissionDedupWindow
+	if window <= 0 {
+		return nil, nil
+	}
+	submissions, err := s.getEspressoRecentSubmissions()
+	if err != nil {
+		return nil, err
+	}
+	// #nosec G115
+	cutoff := uint64(now.Add(-window).Unix())
+	for i := len(submissions) - 1; i >= 0; i-- {
+		if submissions[i].PayloadHash == payloadHash && submissions[i].SubmittedAt > cutoff {
+			return &submissions[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// recordEspressoSubmission remembers that the payload with payloadHash is being submitted, dropping the
+// submissions that fell out of the dedup window
+func (s *TransactionStreamer) recordEspressoSubmission(payloadHash common.Hash, txHash *espressoTypes.TaggedBase64, now time.Time) error {
+	window := s.config().Espresso.SubmissionDedupWindow
+	if window <= 0 {
+		return nil
+	}
+	submissions, err := s.getEspressoRecentSubmissions()
+	if err != nil {
+		return err
+	}
+	// #nosec G115
+	cutoff := uint64(now.Add(-window).Unix())
+	// #nosec G115
+	submittedAt := uint64(now.Unix())
+	kept := submissions[:0]
+	for _, submission := range submissions {
+		if submission.SubmittedAt > cutoff {
+			kept = append(kept, submission)
+		}
+	}
+	kept = append(kept, espressoRecentSubmission{PayloadHash: payloadHash, TxHash: txHash.String(), SubmittedAt: submittedAt})
+	if len(kept) > espressoRecentSubmissionsLimit {
+		kept = kept[len(kept)-espressoRecentSubmissionsLimit:]
+	}
+	data, err := rlp.EncodeToBytes(kept)
+	if err != nil {
+		return err
+	}
+	return s.db.Put(espressoRecentSubmissionsKey, data)
+}
+
+// submitEspressoPayload submits payload, unless the identical payload was already submitted within the dedup window,
+// e.g. by an attempt that failed ambiguously. A skipped submission returns the expected hash and true, its inclusion
+// is polled like any other submission, and it's resubmitted after the resubmission timeout if it never arrived.
+func (s *TransactionStreamer) submitEspressoPayload(ctx context.Context, provider FinalityProvider, hash *espressoTypes.TaggedBase64, payload []byte) (*espressoTypes.TaggedBase64, bool, error) {
+	payloadHash := crypto.Keccak256Hash(payload)
+	now := time.Now()
+	recent, err := s.recentEspressoSubmission(payloadHash, now)
+	if err != nil {
+		return nil, false, err
+	}
+	if recent != nil && recent.TxHash == hash.String() {
+		espressoSubmissionDedupedCounter.Inc(1)
+		log.Info("skipping the submission of a payload that was just submitted", "hash", recent.TxHash, "payloadHash", payloadHash, "submittedAt", recent.SubmittedAt)
+		return hash, true, nil
+	}
+	fee, err := s.checkEspressoFee(ctx, payload, now)
+	if err != nil {
+		return nil, false, err
+	}
+	// Recorded before submitting, so that a retry after an ambiguous error is deduped as well
+	if err := s.recordEspressoSubmission(payloadHash, hash, now); err != nil {
+		return nil, false, err
+	}
+	submittedHash, err := provider.Submit(ctx, payload)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := s.addEspressoFeeSpent(fee, now); err != nil {
+		log.Warn("failed to record the espresso transaction fee", "fee", fee, "err", err)
+	}
+	return submittedHash, false, nil
+}
@@ -0,0 +1,65 @@
+package arbnode
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestEspressoSubmissionDedup(t *testing.T) {
+	ctx := context.Background()
+	config := TestTransactionStreamerConfig
+	config.Espresso.SubmissionDedupWindow = time.Minute
+	streamer := &TransactionStreamer{
+		db:     rawdb.NewMemoryDatabase(),
+		config: func() *TransactionStreamerConfig { return &config },
+	}
+	provider := &testFinalityProvider{}
+	payload := []byte("payload")
+	hash, err := provider.TransactionHash(payload)
+	Require(t, err)
+
+	_, deduped, err := streamer.submitEspressoPayload(ctx, provider, hash, payload)
+	Require(t, err)
+	if deduped || provider.submits != 1 {
+		Fail(t, "first submission not sent", deduped, provider.submits)
+	}
+	_, deduped, err = streamer.submitEspressoPayload(ctx, provider, hash, payload)
+	Require(t, err)
+	if !deduped || provider.submits != 1 {
+		Fail(t, "identical submission within the window sent again", deduped, provider.submits)
+	}
+
+	// Another payload isn't affected
+	other := []byte("other")
+	otherHash, err := provider.TransactionHash(other)
+	Require(t, err)
+	_, deduped, err = streamer.submitEspressoPayload(ctx, provider, otherHash, other)
+	Require(t, err)
+	if deduped || provider.submits != 2 {
+		Fail(t, "different payload deduped", deduped, provider.submits)
+	}
+
+	// Submissions outside of the window are forgotten
+	recent, err := streamer.recentEspressoSubmission(crypto.Keccak256Hash(payload), time.Now().Add(2*time.Minute))
+	Require(t, err)
+	if recent != nil {
+		Fail(t, "submission remembered after the dedup window", recent)
+	}
+	Require(t, streamer.recordEspressoSubmission(crypto.Keccak256Hash(other), otherHash, time.Now().Add(2*time.Minute)))
+	submissions, err := streamer.getEspressoRecentSubmissions()
+	Require(t, err)
+	if len(submissions) != 1 {
+		Fail(t, "expired submissions not pruned", len(submissions))
+	}
+
+	config.Espresso.SubmissionDedupWindow = 0
+	_, deduped, err = streamer.submitEspressoPayload(ctx, provider, otherHash, other)
+	Require(t, err)
+	if deduped {
+		Fail(t, "deduped while disabled")
+	}
+}
@@ -0,0 +1,209 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package arbnode
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/log"
+
+	"github.com/offchainlabs/nitro/arbos/arbostypes"
+	"github.com/offchainlabs/nitro/arbutil"
+)
+
+const (
+	// Messages are submitted in increasing position order
+	EspressoSubmissionOrderPosition = "position"
+	// Messages reading new delayed messages are submitted before the others
+	EspressoSubmissionOrderDelayedFirst = "delayed-first"
+	// Smaller messages are submitted before larger ones, so that a large message doesn't hold back the others
+	EspressoSubmissionOrderSmallestFirst = "smallest-first"
+
+	// Maximum number of pending messages an order policy is applied to, the ones after them are never
+	// included in the next transaction anyway
+	espressoSubmissionOrderWindow = 1024
+)
+
+// EspressoPendingMessages gives access to the messages pending espresso submission
+type EspressoPendingMessages interface {
+	// Message returns the message at pos
+	Message(pos arbutil.MessageIndex) (*arbostypes.MessageWithMetadata, error)
+	// PayloadSize returns the number of bytes the message at pos takes in an espresso transaction
+	PayloadSize(pos arbutil.MessageIndex) (int, error)
+}
+
+// EspressoSubmissionOrderer decides which pending messages are submitted to espresso first.
+// The messages included in a transaction are still confirmed in position order: a message finalized before
+// an earlier pending message is only confirmed once the earlier one is.
+type EspressoSubmissionOrderer interface {
+	// Order returns the pending positions in the order they should be included in espresso transactions.
+	// pending is in increasing position order, and the result must only contain positions from it, at most once.
+	// The positions left out stay queued.
+	Order(pending []arbutil.MessageIndex, messages EspressoPendingMessages) ([]arbutil.MessageIndex, error)
+}
+
+// espressoSubmissionOrder returns the configured submission order, position order if it isn't set
+func espressoSubmissionOrder(config *EspressoStreamerConfig) (string, error) {
+	switch config.SubmissionOrder {
+	case "":
+		return EspressoSubmissionOrderPosition, nil
+	case EspressoSubmissionOrderPosition, EspressoSubmissionOrderDelayedFirst, EspressoSubmissionOrderSmallestFirst:
+		return config.SubmissionOrder, nil
+	default:
+		return "", fmt.Errorf("invalid espresso submission order %q, expected %q, %q or %q", config.SubmissionOrder, EspressoSubmissionOrderPosition, EspressoSubmissionOrderDelayedFirst, EspressoSubmissionOrderSmallestFirst)
+	}
+}
+
+// delayedFirstOrderer submits the messages reading new delayed messages first, as they're usually waited on
+// by users forcing their transactions through L1
+type delayedFirstOrderer struct{}
+
+func (delayedFirstOrderer) Order(pending []arbutil.MessageIndex, messages EspressoPendingMessages) ([]arbutil.MessageIndex, error) {
+	delayed := make(map[arbutil.MessageIndex]bool, len(pending))
+	for _, pos := range pending {
+		msg, err := messages.Message(pos)
+		if err != nil {
+			return nil, err
+		}
+		var prevDelayedRead uint64
+		if pos > 0 {
+			prev, err := messages.Message(pos - 1)
+			if err != nil {
+				return nil, err
+			}
+			prevDelayedRead = prev.DelayedMessagesRead
+		}
+		delayed[pos] = msg.DelayedMessagesRead > prevDelayedRead
+	}
+	ordered := slices.Clone(pending)
+	sort.SliceStable(ordered, func(i, j int) bool { return delayed[ordered[i]] && !delayed[ordered[j]] })
+	return ordered, nil
+}
+
+// smallestFirstOrderer submits the smaller messages first, so that as many messages as possible fit in a transaction
+type smallestFirstOrderer struct{}
+
+func (smallestFirstOrderer) Order(pending []arbutil.MessageIndex, messages EspressoPendingMessages) ([]arbutil.MessageIndex, error) {
+	sizes := make(map[arbutil.MessageIndex]int, len(pending))
+	for _, pos := range pending {
+		size, err := messages.PayloadSize(pos)
+		if err != nil {
+			return nil, err
+		}
+		sizes[pos] = size
+	}
+	ordered := slices.Clone(pending)
+	sort.SliceStable(ordered, func(i, j int) bool { return sizes[ordered[i]] < sizes[ordered[j]] })
+	return ordered, nil
+}
+
+// SetEspressoSubmissionOrderer replaces the configured submission order with a custom one, it must be called
+// before Start
+func (s *TransactionStreamer) SetEspressoSubmissionOrderer(orderer EspressoSubmissionOrderer) {
+	if s.Started() {
+		panic("trying to set espresso submission orderer after start")
+	}
+	s.espressoOrderer = orderer
+}
+
+// espressoSubmissionOrderer returns the orderer of the pending messages, nil for position order
+func (s *TransactionStreamer) espressoSubmissionOrderer() EspressoSubmissionOrderer {
+	if s.espressoOrderer != nil {
+		return s.espressoOrderer
+	}
+	// Validated with the config
+	order, _ := espressoSubmissionOrder(&s.config().Espresso)
+	switch order {
+	case EspressoSubmissionOrderDelayedFirst:
+		return delayedFirstOrderer{}
+	case EspressoSubmissionOrderSmallestFirst:
+		return smallestFirstOrderer{}
+	default:
+		return nil
+	}
+}
+
+// streamerPendingMessages reads the pending messages for the orderers
+type streamerPendingMessages struct {
+	s *TransactionStreamer
+}
+
+func (m streamerPendingMessages) Message(pos arbutil.MessageIndex) (*arbostypes.MessageWithMetadata, error) {
+	return m.s.GetMessage(pos)
+}
+
+func (m streamerPendingMessages) PayloadSize(pos arbutil.MessageIndex) (int, error) {
+	data, err := m.s.espressoMessageBytes(pos)
+	return len(data), err
+}
+
+// orderEspressoPendingTxns applies the submission order to the pending positions. The pending positions are
+// returned as they are if the orderer fails or returns an invalid order, so that submission isn't held back.
+func (s *TransactionStreamer) orderEspressoPendingTxns(pending []arbutil.MessageIndex) []arbutil.MessageIndex {
+	orderer := s.espressoSubmissionOrderer()
+	if orderer == nil || len(pending) < 2 {
+		return pending
+	}
+	window := pending[:min(len(pending), espressoSubmissionOrderWindow)]
+	ordered, err := orderer.Order(slices.Clone(window), streamerPendingMessages{s})
+	if err != nil {
+		log.Warn("failed to order the pending espresso messages, submitting them in position order", "err", err)
+		return pending
+	}
+	seen := make(map[arbutil.MessageIndex]struct{}, len(ordered))
+	for _, pos := range ordered {
+		if _, ok := seen[pos]; ok || !slices.Contains(window, pos) {
+			log.Warn("invalid order of the pending espresso messages, submitting them in position order", "pos", pos)
+			return pending
+		}
+		seen[pos] = struct{}{}
+	}
+	if len(ordered) == 0 {
+		return nil
+	}
+	return ordered
+}
+
+// nextEspressoLastConfirmedPos returns the last confirmed position once the submitted positions are finalized,
+// or nil if it doesn't move. Messages are confirmed in position order, so that every message up to the last
+// confirmed position is settled: when messages were submitted ahead of pending ones, only the messages before
+// the first pending one are confirmed, and once it's finalized too the confirmation extends over the messages
+// finalized ahead of it. The caller must hold the espressoTxnsStateInsertionMutex.
+func (s *TransactionStreamer) nextEspressoLastConfirmedPos(submittedPos []arbutil.MessageIndex) (*arbutil.MessageIndex, error) {
+	last := slices.Max(submittedPos)
+	prev, err := s.getLastConfirmedPos()
+	if err != nil {
+		return nil, err
+	}
+	pendingPos, err := s.getEspressoPendingTxnsPos()
+	if err != nil {
+		return nil, err
+	}
+	for _, pos := range pendingPos {
+		if prev != nil && pos <= *prev {
+			// Requeued after it was confirmed
+			continue
+		}
+		if pos > last {
+			break
+		}
+		if pos == 0 || (prev != nil && pos-1 <= *prev) {
+			return nil, nil
+		}
+		confirmed := pos - 1
+		return &confirmed, nil
+	}
+	for {
+		record, err := s.GetEspressoSubmissionRecord(last + 1)
+		if err != nil {
+			return nil, err
+		}
+		if record == nil || record.Status != EspressoSubmissionFinalized {
+			return &last, nil
+		}
+		last++
+	}
+}
@@ -0,0 +1,110 @@
+package arbnode
+
+import (
+	"reflect"
+	"testing"
+
+	espressoTypes "github.com/EspressoSystems/espresso-sequencer-go/types"
+
+	"github.com/offchainlabs/nitro/arbos/arbostypes"
+	"github.com/offchainlabs/nitro/arbutil"
+)
+
+type reverseOrderer struct {
+	duplicate bool
+}
+
+func (o reverseOrderer) Order(pending []arbutil.MessageIndex, messages EspressoPendingMessages) ([]arbutil.MessageIndex, error) {
+	var ordered []arbutil.MessageIndex
+	for i := len(pending) - 1; i >= 0; i-- {
+		ordered = append(ordered, pending[i])
+	}
+	if o.duplicate {
+		ordered = append(ordered, pending[0])
+	}
+	return ordered, nil
+}
+
+func TestEspressoSubmissionOrder(t *testing.T) {
+	streamer := newTestImportStreamer(t)
+	// Message 2 reads a delayed message, and message 1 is the largest
+	delayedRead := []uint64{0, 0, 1, 1}
+	var messages []arbostypes.MessageWithMetadata
+	for i, read := range delayedRead {
+		l2Msg := []byte{byte(i)}
+		if i == 1 {
+			l2Msg = make([]byte, 100)
+		}
+		messages = append(messages, arbostypes.MessageWithMetadata{
+			Message:             &arbostypes.L1IncomingMessage{Header: &arbostypes.L1IncomingMessageHeader{}, L2msg: l2Msg},
+			DelayedMessagesRead: read,
+		})
+	}
+	Require(t, streamer.AddMessages(0, true, messages))
+	pending := []arbutil.MessageIndex{0, 1, 2, 3}
+
+	if ordered := streamer.orderEspressoPendingTxns(pending); !reflect.DeepEqual(ordered, pending) {
+		Fail(t, "position order changed the pending positions", ordered)
+	}
+	streamer.config().Espresso.SubmissionOrder = EspressoSubmissionOrderDelayedFirst
+	if ordered := streamer.orderEspressoPendingTxns(pending); !reflect.DeepEqual(ordered, []arbutil.MessageIndex{2, 0, 1, 3}) {
+		Fail(t, "unexpected delayed first order", ordered)
+	}
+	streamer.config().Espresso.SubmissionOrder = EspressoSubmissionOrderSmallestFirst
+	if ordered := streamer.orderEspressoPendingTxns(pending); !reflect.DeepEqual(ordered, []arbutil.MessageIndex{0, 2, 3, 1}) {
+		Fail(t, "unexpected smallest first order", ordered)
+	}
+
+	// A custom orderer takes precedence, and an invalid order falls back to position order
+	streamer.SetEspressoSubmissionOrderer(reverseOrderer{})
+	if ordered := streamer.orderEspressoPendingTxns(pending); !reflect.DeepEqual(ordered, []arbutil.MessageIndex{3, 2, 1, 0}) {
+		Fail(t, "unexpected custom order", ordered)
+	}
+	streamer.SetEspressoSubmissionOrderer(reverseOrderer{duplicate: true})
+	if ordered := streamer.orderEspressoPendingTxns(pending); !reflect.DeepEqual(ordered, pending) {
+		Fail(t, "invalid order not rejected", ordered)
+	}
+
+	streamer.config().Espresso.SubmissionOrder = "random"
+	if err := streamer.config().Validate(); err == nil {
+		Fail(t, "invalid submission order accepted")
+	}
+}
+
+func TestEspressoOutOfOrderConfirmation(t *testing.T) {
+	streamer := newTestImportStreamer(t)
+	tx := espressoTypes.Transaction{Payload: []byte("payload"), Namespace: 412346}
+	hash, err := espressoTransactionHash(&tx)
+	Require(t, err)
+
+	batch := streamer.db.NewBatch()
+	Require(t, streamer.setEspressoPendingTxnsPos(batch, []arbutil.MessageIndex{1, 2, 3}))
+	Require(t, streamer.setEspressoSubmissionStatus(batch, []arbutil.MessageIndex{1, 2, 3}, EspressoSubmissionPending, nil))
+	Require(t, batch.Write())
+
+	// Message 3 is submitted and finalized ahead of the others, which only confirms message 0
+	Require(t, streamer.persistEspressoSubmission([]arbutil.MessageIndex{3}, hash, tx.Payload))
+	pending, err := streamer.getEspressoPendingTxnsPos()
+	Require(t, err)
+	if !reflect.DeepEqual(pending, []arbutil.MessageIndex{1, 2}) {
+		Fail(t, "unexpected pending positions", pending)
+	}
+	confirmed, err := streamer.nextEspressoLastConfirmedPos([]arbutil.MessageIndex{3})
+	Require(t, err)
+	if confirmed == nil || *confirmed != 0 {
+		Fail(t, "unexpected confirmation ahead of pending messages", confirmed)
+	}
+	batch = streamer.db.NewBatch()
+	Require(t, streamer.setEspressoLastConfirmedPos(batch, confirmed))
+	Require(t, streamer.setEspressoSubmissionStatus(batch, []arbutil.MessageIndex{3}, EspressoSubmissionFinalized, hash))
+	Require(t, streamer.cleanEspressoSubmittedData(batch))
+	Require(t, batch.Write())
+
+	// Once the earlier messages are finalized, the confirmation extends over message 3
+	Require(t, streamer.persistEspressoSubmission([]arbutil.MessageIndex{1, 2}, hash, tx.Payload))
+	confirmed, err = streamer.nextEspressoLastConfirmedPos([]arbutil.MessageIndex{1, 2})
+	Require(t, err)
+	if confirmed == nil || *confirmed != 3 {
+		Fail(t, "unexpected confirmation after the pending messages", confirmed)
+	}
+}
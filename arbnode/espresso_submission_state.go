@@ -0,0 +1,403 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package arbnode
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	tagged_base64 "github.com/EspressoSystems/espresso-sequencer-go/tagged-base64"
+	espressoTypes "github.com/EspressoSystems/espresso-sequencer-go/types"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/rlp"
+
+	"github.com/offchainlabs/nitro/arbutil"
+	"github.com/offchainlabs/nitro/util/dbutil"
+)
+
+var espressoResubmissionCounter = metrics.NewRegisteredCounter("arb/espresso/submission/resubmitted", nil)
+
+// EspressoSubmissionStatus is the state of a message in the espresso submission pipeline.
+// Messages move Pending -> Submitted -> Finalized. A submitted transaction that fails
+// verification moves its messages to Failed, and they are queued again for submission.
+// Messages that miss their submission deadline are Expired and aren't submitted anymore. Messages whose
+// transaction repeatedly fails to be built are DeadLettered, until they're requeued.
+type EspressoSubmissionStatus uint8
+
+const (
+	EspressoSubmissionPending EspressoSubmissionStatus = iota
+	EspressoSubmissionSubmitted
+	EspressoSubmissionFinalized
+	EspressoSubmissionFailed
+	EspressoSubmissionExpired
+	EspressoSubmissionDeadLettered
+)
+
+func (st EspressoSubmissionStatus) String() string {
+	switch st {
+	case EspressoSubmissionPending:
+		return "pending"
+	case EspressoSubmissionSubmitted:
+		return "submitted"
+	case EspressoSubmissionFinalized:
+		return "finalized"
+	case EspressoSubmissionFailed:
+		return "failed"
+	case EspressoSubmissionExpired:
+		return "expired"
+	case EspressoSubmissionDeadLettered:
+		return "dead-lettered"
+	default:
+		return fmt.Sprintf("unknown(%d)", uint8(st))
+	}
+}
+
+// EspressoSubmissionRecord is the persisted submission state of a single message.
+// QueuedAt is when the message was first queued for submission, and is kept across retries.
+// Attempts counts how many times the message was submitted.
+type EspressoSubmissionRecord struct {
+	Status    EspressoSubmissionStatus
+	TxHash    string
+	UpdatedAt uint64
+	QueuedAt  uint64 `rlp:"optional"`
+	Attempts  uint64 `rlp:"optional"`
+}
+
+// espressoTransactionHash computes the hash HotShot assigns to a transaction, which allows
+// the submission to be persisted before the transaction is sent.
+func espressoTransactionHash(tx *espressoTypes.Transaction) (*espressoTypes.TaggedBase64, error) {
+	commit := tx.Commit()
+	return tagged_base64.New("TX", commit[:])
+}
+
+func (s *TransactionStreamer) setEspressoSubmissionStatus(batch ethdb.KeyValueWriter, positions []arbutil.MessageIndex, status EspressoSubmissionStatus, hash *espressoTypes.TaggedBase64) error {
+	updatedAt := time.Now()
+	// #nosec G115
+	now := uint64(updatedAt.Unix())
+	for _, pos := range positions {
+		record := EspressoSubmissionRecord{
+			Status:    status,
+			UpdatedAt: now,
+			QueuedAt:  now,
+		}
+		if hash != nil {
+			record.TxHash = hash.String()
+		}
+		prev, err := s.GetEspressoSubmissionRecord(pos)
+		if err != nil {
+			return err
+		}
+		if prev != nil {
+			if prev.QueuedAt != 0 {
+				record.QueuedAt = prev.QueuedAt
+			}
+			record.Attempts = prev.Attempts
+		}
+		if status == EspressoSubmissionSubmitted && (prev == nil || prev.Status != EspressoSubmissionSubmitted) {
+			record.Attempts++
+		}
+		// Latencies are measured from the first submission, resubmissions are only counted in the record
+		if status == EspressoSubmissionSubmitted && record.Attempts == 1 && (prev == nil || prev.Status != EspressoSubmissionSubmitted) {
+			if err := s.recordMessageTelemetry(batch, pos, messageSubmittedStage, updatedAt); err != nil {
+				return err
+			}
+		}
+		if status == EspressoSubmissionFinalized {
+			if err := s.recordMessageTelemetry(batch, pos, messageFinalizedStage, updatedAt); err != nil {
+				return err
+			}
+		}
+		recordBytes, err := rlp.EncodeToBytes(record)
+		if err != nil {
+			return err
+		}
+		if err := batch.Put(dbKey(espressoSubmissionPrefix, uint64(pos)), recordBytes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *TransactionStreamer) getEspressoLastFinalizedHeight() (*uint64, error) {
+	data, err := s.db.Get(espressoLastFinalizedHeight)
+	if err != nil {
+		if dbutil.IsErrNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var height uint64
+	if err := rlp.DecodeBytes(data, &height); err != nil {
+		return nil, err
+	}
+	return &height, nil
+}
+
+func (s *TransactionStreamer) setEspressoLastFinalizedHeight(batch ethdb.KeyValueWriter, height uint64) error {
+	heightBytes, err := rlp.EncodeToBytes(height)
+	if err != nil {
+		return err
+	}
+	return batch.Put(espressoLastFinalizedHeight, heightBytes)
+}
+
+// EspressoStatus is a snapshot of the espresso submission pipeline.
+type EspressoStatus struct {
+	PendingPositions          []arbutil.MessageIndex `json:"pendingPositions"`
+	SubmittedPositions        []arbutil.MessageIndex `json:"submittedPositions"`
+	SubmittedTxHash           *string                `json:"submittedTxHash"`
+	LastConfirmedPos          *arbutil.MessageIndex  `json:"lastConfirmedPos"`
+	LastFinalizedHotShotBlock *uint64                `json:"lastFinalizedHotShotBlock"`
+	OldestPendingQueuedAt     *uint64                `json:"oldestPendingQueuedAt"`
+	HotShotDown               bool                   `json:"hotShotDown"`
+}
+
+// GetEspressoStatus returns the current state of the espresso submission pipeline.
+func (s *TransactionStreamer) GetEspressoStatus() (*EspressoStatus, error) {
+	status := &EspressoStatus{
+		HotShotDown: s.IsHotShotDown(),
+	}
+	var err error
+	status.PendingPositions, err = s.getEspressoPendingTxnsPos()
+	if err != nil {
+		return nil, err
+	}
+	status.SubmittedPositions, err = s.getEspressoSubmittedPos()
+	if err != nil {
+		return nil, err
+	}
+	hash, err := s.getEspressoSubmittedHash()
+	if err != nil {
+		return nil, err
+	}
+	if hash != nil {
+		hashStr := hash.String()
+		status.SubmittedTxHash = &hashStr
+	}
+	status.LastConfirmedPos, err = s.getLastConfirmedPos()
+	if err != nil {
+		return nil, err
+	}
+	status.LastFinalizedHotShotBlock, err = s.getEspressoLastFinalizedHeight()
+	if err != nil {
+		return nil, err
+	}
+	// Submitted messages were queued before the pending ones
+	var oldest *arbutil.MessageIndex
+	if len(status.SubmittedPositions) > 0 {
+		oldest = &status.SubmittedPositions[0]
+	} else if len(status.PendingPositions) > 0 {
+		oldest = &status.PendingPositions[0]
+	}
+	if oldest != nil {
+		record, err := s.GetEspressoSubmissionRecord(*oldest)
+		if err != nil {
+			return nil, err
+		}
+		if record != nil && record.QueuedAt != 0 {
+			status.OldestPendingQueuedAt = &record.QueuedAt
+		}
+	}
+	return status, nil
+}
+
+// GetEspressoSubmissionRecord returns the submission state of the message at pos,
+// or nil if the message was never queued for espresso submission.
+func (s *TransactionStreamer) GetEspressoSubmissionRecord(pos arbutil.MessageIndex) (*EspressoSubmissionRecord, error) {
+	data, err := s.db.Get(dbKey(espressoSubmissionPrefix, uint64(pos)))
+	if err != nil {
+		if dbutil.IsErrNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var record EspressoSubmissionRecord
+	if err := rlp.DecodeBytes(data, &record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// requeueEspressoSubmittedTxns moves the submitted messages back to the front of the pending queue.
+// The caller must hold the espressoTxnsStateInsertionMutex.
+func (s *TransactionStreamer) requeueEspressoSubmittedTxns(batch ethdb.Batch, submittedPos []arbutil.MessageIndex, status EspressoSubmissionStatus) error {
+	pendingTxnsPos, err := s.getEspressoPendingTxnsPos()
+	if err != nil {
+		return err
+	}
+	if err := s.cleanEspressoSubmittedData(batch); err != nil {
+		return err
+	}
+	if err := s.setEspressoSubmissionStatus(batch, submittedPos, status, nil); err != nil {
+		return err
+	}
+	requeued := make([]arbutil.MessageIndex, 0, len(submittedPos)+len(pendingTxnsPos))
+	requeued = append(requeued, submittedPos...)
+	requeued = append(requeued, pendingTxnsPos...)
+	return s.setEspressoPendingTxnsPos(batch, requeued)
+}
+
+// dropReorgedEspressoState removes the messages at or past count from the submission pipeline when they're
+// reorged out. The positions are reused by the messages replacing them, which would otherwise inherit the state
+// of the reorged ones:
+//   - An in-flight transaction including any of them is abandoned, as its finality would justify the reorged
+//     messages, and the rest of its messages are queued for submission again.
+//   - Queued positions are dropped, the replacing messages are queued again when they're sequenced.
+//   - The last confirmed position is rewound before count, so that the replacing messages aren't treated as
+//     confirmed before they're submitted, and their justifications, block lookups and dead letters are deleted.
+//
+// The espressoTxnsStateInsertionMutex must be held until the batch is written, so that the espresso loop doesn't
+// change the state read here before it's replaced.
+func (s *TransactionStreamer) dropReorgedEspressoState(batch ethdb.Batch, count arbutil.MessageIndex) error {
+	before := func(positions []arbutil.MessageIndex) []arbutil.MessageIndex {
+		kept := make([]arbutil.MessageIndex, 0, len(positions))
+		for _, pos := range positions {
+			if pos < count {
+				kept = append(kept, pos)
+			}
+		}
+		return kept
+	}
+	pendingTxnsPos, err := s.getEspressoPendingTxnsPos()
+	if err != nil {
+		return err
+	}
+	submittedPos, err := s.getEspressoSubmittedPos()
+	if err != nil {
+		return err
+	}
+	pending := before(pendingTxnsPos)
+	requeued := false
+	if submitted := before(submittedPos); len(submitted) < len(submittedPos) {
+		log.Warn("abandoning the in-flight espresso transaction, it includes reorged messages", "count", count, "requeued", len(submitted))
+		if err := s.cleanEspressoSubmittedData(batch); err != nil {
+			return err
+		}
+		if err := s.setEspressoSubmissionStatus(batch, submitted, EspressoSubmissionPending, nil); err != nil {
+			return err
+		}
+		pending = append(submitted, pending...)
+		requeued = len(submitted) > 0
+	}
+	if requeued || len(pending) < len(pendingTxnsPos) {
+		if err := s.setEspressoPendingTxnsPos(batch, pending); err != nil {
+			return err
+		}
+	}
+	lastConfirmed, err := s.getLastConfirmedPos()
+	if err != nil {
+		return err
+	}
+	if lastConfirmed != nil && *lastConfirmed >= count {
+		lastKept := count - 1
+		if err := s.setEspressoLastConfirmedPos(batch, &lastKept); err != nil {
+			return err
+		}
+	}
+	if err := s.deleteEspressoBlockLookupStartingAt(batch, count); err != nil {
+		return err
+	}
+	if err := deleteStartingAt(s.db, batch, espressoDeadLetterPrefix, uint64ToKey(uint64(count))); err != nil {
+		return err
+	}
+	return deleteStartingAt(s.db, batch, espressoJustificationPrefix, uint64ToKey(uint64(count)))
+}
+
+// requeueIfInclusionTimedOut puts the submitted messages back at the head of the pending queue if their
+// transaction hasn't been included in a HotShot block within the resubmission timeout, e.g. because the
+// builder dropped it. They are then submitted again in a fresh transaction. Returns true if requeued.
+func (s *TransactionStreamer) requeueIfInclusionTimedOut(submittedPos []arbutil.MessageIndex) (bool, error) {
+	config := s.config().Espresso
+	if config.ResubmissionTimeout == 0 || len(submittedPos) == 0 {
+		return false, nil
+	}
+	record, err := s.GetEspressoSubmissionRecord(submittedPos[0])
+	if err != nil || record == nil || record.Status != EspressoSubmissionSubmitted {
+		return false, err
+	}
+	// #nosec G115
+	submittedAt := time.Unix(int64(record.UpdatedAt), 0)
+	if time.Since(submittedAt) < config.ResubmissionTimeout {
+		return false, nil
+	}
+	if record.Attempts > config.MaxResubmissions {
+		log.Error("espresso transaction still not included after the maximum number of resubmissions", "hash", record.TxHash, "attempts", record.Attempts, "submittedAt", submittedAt)
+		return false, nil
+	}
+	log.Warn("espresso transaction not included within the resubmission timeout, resubmitting", "hash", record.TxHash, "attempts", record.Attempts, "submittedAt", submittedAt)
+	espressoResubmissionCounter.Inc(1)
+	s.espressoTxnsStateInsertionMutex.Lock()
+	defer s.espressoTxnsStateInsertionMutex.Unlock()
+	batch := s.newEspressoStateBatch()
+	if err := s.requeueEspressoSubmittedTxns(batch, submittedPos, EspressoSubmissionPending); err != nil {
+		return false, err
+	}
+	return true, s.writeEspressoStateBatch(batch, espressoIntentRequeue)
+}
+
+// reconcileEspressoSubmission is run before the first submission after startup, and after
+// any failed submission attempt. Since the submission state is persisted before the transaction
+// is sent, an in-flight transaction may or may not have reached HotShot. If HotShot doesn't know
+// about it, the same payload is submitted again.
+func (s *TransactionStreamer) reconcileEspressoSubmission(ctx context.Context) error {
+	submittedPos, err := s.getEspressoSubmittedPos()
+	if err != nil {
+		return err
+	}
+	if len(submittedPos) == 0 {
+		return nil
+	}
+	payload, err := s.getEspressoSubmittedPayload()
+	if err != nil {
+		return err
+	}
+	if payload == nil {
+		log.Warn("espresso submission is missing its payload, requeueing messages", "positions", len(submittedPos))
+		s.espressoTxnsStateInsertionMutex.Lock()
+		defer s.espressoTxnsStateInsertionMutex.Unlock()
+		batch := s.newEspressoStateBatch()
+		if err := s.requeueEspressoSubmittedTxns(batch, submittedPos, EspressoSubmissionPending); err != nil {
+			return err
+		}
+		return s.writeEspressoStateBatch(batch, espressoIntentRequeue)
+	}
+	provider := s.finality()
+	hash, err := s.getEspressoSubmittedHash()
+	if err != nil {
+		return err
+	}
+	if hash == nil {
+		hash, err = provider.TransactionHash(payload)
+		if err != nil {
+			return err
+		}
+	}
+	err = provider.FindTransaction(ctx, hash)
+	if err == nil {
+		log.Info("in-flight espresso transaction found on hotshot", "hash", hash.String())
+		return nil
+	}
+	log.Warn("in-flight espresso transaction not found on hotshot, submitting again", "hash", hash.String(), "err", err)
+	newHash, deduped, err := s.submitEspressoPayload(ctx, provider, hash, payload)
+	if err != nil {
+		return fmt.Errorf("failed to resubmit the in-flight espresso transaction: %w", err)
+	}
+	if deduped || newHash.String() == hash.String() {
+		return nil
+	}
+	log.Warn("hotshot returned an unexpected transaction hash", "expected", hash.String(), "got", newHash.String())
+	s.espressoTxnsStateInsertionMutex.Lock()
+	defer s.espressoTxnsStateInsertionMutex.Unlock()
+	batch := s.newEspressoStateBatch()
+	if err := s.setEspressoSubmittedHash(batch, newHash); err != nil {
+		return err
+	}
+	if err := s.setEspressoSubmissionStatus(batch, submittedPos, EspressoSubmissionSubmitted, newHash); err != nil {
+		return err
+	}
+	return s.writeEspressoStateBatch(batch, espressoIntentRehash)
+}
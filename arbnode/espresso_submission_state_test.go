@@ -0,0 +1,342 @@
+package arbnode
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	espressoTypes "github.com/EspressoSystems/espresso-sequencer-go/types"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/rlp"
+
+	"github.com/offchainlabs/nitro/arbutil"
+)
+
+func TestEspressoSubmissionRequeue(t *testing.T) {
+	streamer := &TransactionStreamer{
+		db: rawdb.NewMemoryDatabase(),
+	}
+	tx := espressoTypes.Transaction{Payload: []byte("payload"), Namespace: 412346}
+	hash, err := espressoTransactionHash(&tx)
+	Require(t, err)
+
+	submitted := []arbutil.MessageIndex{3, 4}
+	batch := streamer.db.NewBatch()
+	Require(t, streamer.setEspressoPendingTxnsPos(batch, []arbutil.MessageIndex{5, 6}))
+	Require(t, streamer.setEspressoSubmittedPos(batch, submitted))
+	Require(t, streamer.setEspressoSubmittedHash(batch, hash))
+	Require(t, streamer.setEspressoSubmittedPayload(batch, tx.Payload))
+	Require(t, streamer.setEspressoSubmissionStatus(batch, submitted, EspressoSubmissionSubmitted, hash))
+	Require(t, batch.Write())
+
+	record, err := streamer.GetEspressoSubmissionRecord(4)
+	Require(t, err)
+	if record == nil || record.Status != EspressoSubmissionSubmitted || record.TxHash != hash.String() {
+		Fail(t, "unexpected submission record", record)
+	}
+
+	batch = streamer.db.NewBatch()
+	Require(t, streamer.requeueEspressoSubmittedTxns(batch, submitted, EspressoSubmissionFailed))
+	Require(t, batch.Write())
+
+	pending, err := streamer.getEspressoPendingTxnsPos()
+	Require(t, err)
+	if !reflect.DeepEqual(pending, []arbutil.MessageIndex{3, 4, 5, 6}) {
+		Fail(t, "unexpected pending positions", pending)
+	}
+	submittedAfter, err := streamer.getEspressoSubmittedPos()
+	Require(t, err)
+	if len(submittedAfter) != 0 {
+		Fail(t, "submitted positions not cleared", submittedAfter)
+	}
+	payload, err := streamer.getEspressoSubmittedPayload()
+	Require(t, err)
+	if payload != nil {
+		Fail(t, "submitted payload not cleared")
+	}
+	record, err = streamer.GetEspressoSubmissionRecord(3)
+	Require(t, err)
+	if record.Status != EspressoSubmissionFailed {
+		Fail(t, "expected failed status, got", record.Status)
+	}
+}
+
+func TestDropReorgedEspressoState(t *testing.T) {
+	streamer := &TransactionStreamer{
+		db: rawdb.NewMemoryDatabase(),
+	}
+	tx := espressoTypes.Transaction{Payload: []byte("payload"), Namespace: 412346}
+	hash, err := espressoTransactionHash(&tx)
+	Require(t, err)
+
+	submitted := []arbutil.MessageIndex{4, 5, 6}
+	lastConfirmed := arbutil.MessageIndex(5)
+	batch := streamer.db.NewBatch()
+	Require(t, streamer.setEspressoPendingTxnsPos(batch, []arbutil.MessageIndex{7, 8}))
+	Require(t, streamer.setEspressoSubmittedPos(batch, submitted))
+	Require(t, streamer.setEspressoSubmittedHash(batch, hash))
+	Require(t, streamer.setEspressoSubmittedPayload(batch, tx.Payload))
+	Require(t, streamer.setEspressoSubmissionStatus(batch, submitted, EspressoSubmissionSubmitted, hash))
+	Require(t, streamer.setEspressoLastConfirmedPos(batch, &lastConfirmed))
+	for _, pos := range []arbutil.MessageIndex{3, 5} {
+		Require(t, streamer.setEspressoJustification(batch, pos, &EspressoJustification{HotShotHeight: uint64(pos)}))
+	}
+	Require(t, batch.Put(dbKey(espressoDeadLetterPrefix, 8), []byte{}))
+	Require(t, batch.Write())
+	drop := func(count arbutil.MessageIndex) {
+		t.Helper()
+		batch := streamer.db.NewBatch()
+		Require(t, streamer.dropReorgedEspressoState(batch, count))
+		Require(t, batch.Write())
+	}
+	has := func(key []byte) bool {
+		t.Helper()
+		has, err := streamer.db.Has(key)
+		Require(t, err)
+		return has
+	}
+
+	// A reorg past the in-flight transaction only drops the queued messages it reorged out
+	drop(8)
+	pending, err := streamer.getEspressoPendingTxnsPos()
+	Require(t, err)
+	if !reflect.DeepEqual(pending, []arbutil.MessageIndex{7}) {
+		Fail(t, "unexpected pending positions", pending)
+	}
+	submittedAfter, err := streamer.getEspressoSubmittedPos()
+	Require(t, err)
+	if !reflect.DeepEqual(submittedAfter, submitted) {
+		Fail(t, "in-flight transaction abandoned by a later reorg", submittedAfter)
+	}
+	if has(dbKey(espressoDeadLetterPrefix, 8)) {
+		Fail(t, "dead letter of a reorged message kept")
+	}
+
+	// The in-flight transaction includes reorged messages, it's abandoned and its kept messages are requeued
+	drop(5)
+	pending, err = streamer.getEspressoPendingTxnsPos()
+	Require(t, err)
+	if !reflect.DeepEqual(pending, []arbutil.MessageIndex{4}) {
+		Fail(t, "unexpected pending positions", pending)
+	}
+	submittedAfter, err = streamer.getEspressoSubmittedPos()
+	Require(t, err)
+	if len(submittedAfter) != 0 {
+		Fail(t, "in-flight transaction including reorged messages not abandoned", submittedAfter)
+	}
+	payload, err := streamer.getEspressoSubmittedPayload()
+	Require(t, err)
+	if payload != nil {
+		Fail(t, "submitted payload not cleared")
+	}
+	record, err := streamer.GetEspressoSubmissionRecord(4)
+	Require(t, err)
+	if record == nil || record.Status != EspressoSubmissionPending {
+		Fail(t, "requeued message not pending", record)
+	}
+
+	// The replacing messages aren't confirmed or justified
+	confirmed, err := streamer.getLastConfirmedPos()
+	Require(t, err)
+	if confirmed == nil || *confirmed != 4 {
+		Fail(t, "last confirmed position not rewound before the reorg", confirmed)
+	}
+	if has(dbKey(espressoJustificationPrefix, 5)) || has(messageLookupKey(espressoBlockLookupPrefix, 5, 5)) {
+		Fail(t, "justification of a reorged message kept")
+	}
+	if !has(dbKey(espressoJustificationPrefix, 3)) || !has(messageLookupKey(espressoBlockLookupPrefix, 3, 3)) {
+		Fail(t, "justification before the reorg deleted")
+	}
+}
+
+func TestEspressoStatusKeepsQueuedAt(t *testing.T) {
+	streamer := &TransactionStreamer{
+		db: rawdb.NewMemoryDatabase(),
+	}
+	queued, err := rlp.EncodeToBytes(EspressoSubmissionRecord{Status: EspressoSubmissionPending, UpdatedAt: 100, QueuedAt: 100})
+	Require(t, err)
+	Require(t, streamer.db.Put(dbKey(espressoSubmissionPrefix, 7), queued))
+
+	tx := espressoTypes.Transaction{Payload: []byte("payload"), Namespace: 412346}
+	hash, err := espressoTransactionHash(&tx)
+	Require(t, err)
+	batch := streamer.db.NewBatch()
+	Require(t, streamer.setEspressoPendingTxnsPos(batch, []arbutil.MessageIndex{8}))
+	Require(t, streamer.setEspressoSubmittedPos(batch, []arbutil.MessageIndex{7}))
+	Require(t, streamer.setEspressoSubmittedHash(batch, hash))
+	Require(t, streamer.setEspressoSubmissionStatus(batch, []arbutil.MessageIndex{7}, EspressoSubmissionSubmitted, hash))
+	Require(t, streamer.setEspressoLastFinalizedHeight(batch, 42))
+	Require(t, batch.Write())
+
+	record, err := streamer.GetEspressoSubmissionRecord(7)
+	Require(t, err)
+	if record.Status != EspressoSubmissionSubmitted || record.QueuedAt != 100 {
+		Fail(t, "unexpected submission record", record)
+	}
+	status, err := streamer.GetEspressoStatus()
+	Require(t, err)
+	if status.SubmittedTxHash == nil || *status.SubmittedTxHash != hash.String() {
+		Fail(t, "unexpected submitted hash", status.SubmittedTxHash)
+	}
+	if status.LastFinalizedHotShotBlock == nil || *status.LastFinalizedHotShotBlock != 42 {
+		Fail(t, "unexpected last finalized hotshot block", status.LastFinalizedHotShotBlock)
+	}
+	if status.OldestPendingQueuedAt == nil || *status.OldestPendingQueuedAt != 100 {
+		Fail(t, "unexpected oldest queued time", status.OldestPendingQueuedAt)
+	}
+	if !reflect.DeepEqual(status.PendingPositions, []arbutil.MessageIndex{8}) {
+		Fail(t, "unexpected pending positions", status.PendingPositions)
+	}
+}
+
+func TestEspressoResubmissionOnInclusionTimeout(t *testing.T) {
+	config := TestTransactionStreamerConfig
+	config.Espresso.ResubmissionTimeout = time.Minute
+	config.Espresso.MaxResubmissions = 1
+	streamer := &TransactionStreamer{
+		db:     rawdb.NewMemoryDatabase(),
+		config: func() *TransactionStreamerConfig { return &config },
+	}
+	tx := espressoTypes.Transaction{Payload: []byte("payload"), Namespace: 412346}
+	hash, err := espressoTransactionHash(&tx)
+	Require(t, err)
+	submitted := []arbutil.MessageIndex{3, 4}
+	submit := func() {
+		batch := streamer.db.NewBatch()
+		Require(t, streamer.setEspressoSubmittedPos(batch, submitted))
+		Require(t, streamer.setEspressoSubmittedHash(batch, hash))
+		Require(t, streamer.setEspressoSubmissionStatus(batch, submitted, EspressoSubmissionSubmitted, hash))
+		Require(t, batch.Write())
+	}
+
+	submit()
+	requeued, err := streamer.requeueIfInclusionTimedOut(submitted)
+	Require(t, err)
+	if requeued {
+		Fail(t, "requeued before the resubmission timeout")
+	}
+
+	// Move the submission time past the timeout
+	expire := func() {
+		record, err := streamer.GetEspressoSubmissionRecord(3)
+		Require(t, err)
+		// #nosec G115
+		record.UpdatedAt = uint64(time.Now().Add(-2 * time.Minute).Unix())
+		data, err := rlp.EncodeToBytes(record)
+		Require(t, err)
+		Require(t, streamer.db.Put(dbKey(espressoSubmissionPrefix, 3), data))
+	}
+	expire()
+	requeued, err = streamer.requeueIfInclusionTimedOut(submitted)
+	Require(t, err)
+	if !requeued {
+		Fail(t, "not requeued after the resubmission timeout")
+	}
+	pending, err := streamer.getEspressoPendingTxnsPos()
+	Require(t, err)
+	if !reflect.DeepEqual(pending, submitted) {
+		Fail(t, "unexpected pending positions", pending)
+	}
+
+	// The second submission exhausts the allowed resubmissions
+	submit()
+	record, err := streamer.GetEspressoSubmissionRecord(3)
+	Require(t, err)
+	if record.Attempts != 2 {
+		Fail(t, "expected 2 attempts, got", record.Attempts)
+	}
+	expire()
+	requeued, err = streamer.requeueIfInclusionTimedOut(submitted)
+	Require(t, err)
+	if requeued {
+		Fail(t, "requeued after the maximum number of resubmissions")
+	}
+}
+
+func TestEspressoShutdownDrainsSubmission(t *testing.T) {
+	config := TestTransactionStreamerConfig
+	config.Espresso.ShutdownTimeout = time.Second
+	streamer := &TransactionStreamer{
+		db:                 rawdb.NewMemoryDatabase(),
+		config:             func() *TransactionStreamerConfig { return &config },
+		espressoSwitchSlot: make(chan struct{}, 1),
+	}
+	streamer.StopWaiter.Start(context.Background(), streamer)
+	defer streamer.StopWaiter.StopAndWait()
+
+	// An espressoSwitch iteration is in flight
+	streamer.espressoSwitchSlot <- struct{}{}
+	var drained atomic.Bool
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		drained.Store(true)
+		<-streamer.espressoSwitchSlot
+	}()
+	streamer.stopEspresso()
+	if !drained.Load() {
+		Fail(t, "shutdown didn't wait for the in-flight submission")
+	}
+	if !streamer.espressoStopping.Load() {
+		Fail(t, "new submissions not stopped")
+	}
+	select {
+	case streamer.espressoSwitchSlot <- struct{}{}:
+	default:
+		Fail(t, "shutdown didn't release the espresso switch")
+	}
+}
+
+func TestStepEspressoWaitsForEspressoSwitch(t *testing.T) {
+	streamer := &TransactionStreamer{
+		db:                 rawdb.NewMemoryDatabase(),
+		config:             func() *TransactionStreamerConfig { return &TestTransactionStreamerConfig },
+		espressoSwitchSlot: make(chan struct{}, 1),
+	}
+
+	// An espressoSwitch iteration is in flight
+	streamer.espressoSwitchSlot <- struct{}{}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := streamer.StepEspresso(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		Fail(t, "step didn't wait for the in-flight iteration", err)
+	}
+	if streamer.espressoSubmissionReconciled {
+		Fail(t, "step changed the state while an iteration was in flight")
+	}
+	select {
+	case streamer.espressoSwitchSlot <- struct{}{}:
+		Fail(t, "step released the espresso switch it didn't take")
+	default:
+	}
+}
+
+func TestEspressoQueueWakesSubmissionLoop(t *testing.T) {
+	config := TestTransactionStreamerConfig
+	config.Espresso.LongPollInterval = time.Minute
+	streamer := &TransactionStreamer{
+		db:                          rawdb.NewMemoryDatabase(),
+		config:                      func() *TransactionStreamerConfig { return &config },
+		newSovereignTxNotifier:      make(chan struct{}, 1),
+		espressoTxnsPollingInterval: time.Second,
+	}
+	if interval := streamer.espressoIdleInterval(); interval != time.Minute {
+		Fail(t, "expected the long-poll interval while idle, got", interval)
+	}
+
+	Require(t, streamer.SubmitEspressoTransactionPos(3, streamer.db.NewBatch()))
+	select {
+	case <-streamer.newSovereignTxNotifier:
+	default:
+		Fail(t, "queueing a message didn't wake up the submission loop")
+	}
+
+	batch := streamer.db.NewBatch()
+	Require(t, streamer.setEspressoSubmittedPos(batch, []arbutil.MessageIndex{3}))
+	Require(t, batch.Write())
+	if interval := streamer.espressoIdleInterval(); interval != time.Second {
+		Fail(t, "expected the polling interval while a transaction is in flight, got", interval)
+	}
+}
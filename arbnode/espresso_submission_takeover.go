@@ -0,0 +1,152 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package arbnode
+
+import (
+	"context"
+	"time"
+
+	espressoTypes "github.com/EspressoSystems/espresso-sequencer-go/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+
+	"github.com/offchainlabs/nitro/arbutil"
+)
+
+var espressoAdoptedSubmissionCounter = metrics.NewRegisteredCounter("arb/espresso/submission/adopted", nil)
+
+// recordEspressoSubmissionAudit records a submission in the coordinator's audit trail before it's sent to HotShot.
+// Without a coordinator no other sequencer can take over, and nothing is recorded.
+func (s *TransactionStreamer) recordEspressoSubmissionAudit(ctx context.Context, submittedPos []arbutil.MessageIndex, hash *espressoTypes.TaggedBase64, payload []byte) error {
+	if s.coordinator == nil {
+		return nil
+	}
+	var hotShotHeight uint64
+	finalized, err := s.getEspressoLastFinalizedHeight()
+	if err != nil {
+		return err
+	}
+	if finalized != nil {
+		hotShotHeight = *finalized
+	}
+	return s.coordinator.RecordEspressoSubmission(ctx, &EspressoSubmissionAudit{
+		Sequencer:     s.coordinator.config.Url(),
+		FirstPos:      submittedPos[0],
+		MsgCount:      uint64(len(submittedPos)),
+		PayloadHash:   crypto.Keccak256Hash(payload),
+		TxHash:        hash.String(),
+		HotShotHeight: hotShotHeight,
+		// #nosec G115
+		Timestamp: uint64(time.Now().Unix()),
+	})
+}
+
+// adoptStaleEspressoSubmission is run when this node becomes the chosen sequencer. If the previously chosen
+// sequencer died after sending a transaction to HotShot, but before its submission was replicated, the messages
+// at the head of the pending queue are already in the namespace. The audit trail identifies the payload of such
+// a transaction by its hash, and if it's found in the namespace it becomes the in-flight submission instead of
+// the messages being submitted again.
+// Returns true if a submission was adopted.
+func (s *TransactionStreamer) adoptStaleEspressoSubmission(ctx context.Context) (bool, error) {
+	submittedPos, err := s.getEspressoSubmittedPos()
+	if err != nil {
+		return false, err
+	}
+	if len(submittedPos) > 0 {
+		// The in-flight submission is already known, and is reconciled with HotShot
+		return false, nil
+	}
+	pendingPos, err := s.getEspressoPendingTxnsPos()
+	if err != nil {
+		return false, err
+	}
+	if len(pendingPos) == 0 {
+		return false, nil
+	}
+	trail, err := s.coordinator.EspressoSubmissionAuditTrail(ctx)
+	if err != nil {
+		return false, err
+	}
+	myUrl := s.coordinator.config.Url()
+	var candidates []EspressoSubmissionAudit
+	for i := len(trail) - 1; i >= 0; i-- {
+		audit := trail[i]
+		if audit.Sequencer == myUrl || audit.FirstPos != pendingPos[0] || audit.MsgCount == 0 || audit.MsgCount > uint64(len(pendingPos)) {
+			continue
+		}
+		candidates = append(candidates, audit)
+	}
+	if len(candidates) == 0 {
+		return false, nil
+	}
+
+	latest, err := s.espressoClient.FetchLatestBlockHeight(ctx)
+	if err != nil {
+		return false, err
+	}
+	from := candidates[0].HotShotHeight
+	for _, audit := range candidates {
+		from = min(from, audit.HotShotHeight)
+	}
+	maxBlocks := s.config().Espresso.NamespaceScanMaxBlocks
+	if maxBlocks > 0 && latest > maxBlocks && from < latest-maxBlocks {
+		from = latest - maxBlocks
+	}
+	namespace := s.chainConfig.ChainID.Uint64()
+	for height := from; height < latest; height++ {
+		resp, err := s.fetchEspressoNamespace(ctx, height, namespace)
+		if err != nil {
+			return false, err
+		}
+		for _, payload := range resp.Transactions {
+			payloadHash := crypto.Keccak256Hash(payload)
+			for _, audit := range candidates {
+				if payloadHash != audit.PayloadHash || height < audit.HotShotHeight {
+					continue
+				}
+				return s.adoptEspressoSubmission(audit, pendingPos[:audit.MsgCount], payload, height)
+			}
+		}
+	}
+	log.Info("no stale espresso submission found in the namespace", "candidates", len(candidates), "from", from, "to", latest)
+	return false, nil
+}
+
+// adoptEspressoSubmission persists a transaction found in the namespace as the in-flight submission of positions.
+// The audit trail isn't trusted: the payload is only adopted if it contains exactly our messages at positions.
+func (s *TransactionStreamer) adoptEspressoSubmission(audit EspressoSubmissionAudit, positions []arbutil.MessageIndex, payload []byte, height uint64) (bool, error) {
+	_, indices, _, err := s.espressoPayloadCodec().ParsePayload(payload)
+	if err != nil || len(indices) != len(positions) {
+		log.Warn("not adopting stale espresso submission with unexpected positions", "sequencer", audit.Sequencer, "height", height, "err", err)
+		return false, nil
+	}
+	for i, index := range indices {
+		if arbutil.MessageIndex(index) != positions[i] {
+			log.Warn("not adopting stale espresso submission with unexpected positions", "sequencer", audit.Sequencer, "height", height, "pos", index, "expected", positions[i])
+			return false, nil
+		}
+	}
+	reason, err := s.checkEspressoNamespaceTransaction(payload)
+	if err != nil {
+		return false, err
+	}
+	if reason != "" {
+		log.Warn("not adopting stale espresso submission", "sequencer", audit.Sequencer, "height", height, "reason", reason)
+		return false, nil
+	}
+	hash, err := espressoTransactionHash(&espressoTypes.Transaction{
+		Payload:   payload,
+		Namespace: s.chainConfig.ChainID.Uint64(),
+	})
+	if err != nil {
+		return false, err
+	}
+	if err := s.persistEspressoSubmission(positions, hash, payload); err != nil {
+		return false, err
+	}
+	espressoAdoptedSubmissionCounter.Inc(1)
+	log.Info("adopted stale espresso submission of the previous chosen sequencer", "sequencer", audit.Sequencer, "hash", hash.String(), "height", height, "firstPos", positions[0], "count", len(positions))
+	return true, nil
+}
@@ -0,0 +1,117 @@
+package arbnode
+
+import (
+	"context"
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+
+	espressoClient "github.com/EspressoSystems/espresso-sequencer-go/client"
+	espressoTypes "github.com/EspressoSystems/espresso-sequencer-go/types"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+
+	"github.com/offchainlabs/nitro/arbutil"
+	"github.com/offchainlabs/nitro/util/redisutil"
+)
+
+// namespaceEspressoClient serves fixed namespace transactions per block height
+type namespaceEspressoClient struct {
+	*countingEspressoClient
+	latest       uint64
+	transactions map[uint64][]espressoTypes.Bytes
+}
+
+func (c *namespaceEspressoClient) FetchLatestBlockHeight(ctx context.Context) (uint64, error) {
+	return c.latest, nil
+}
+
+func (c *namespaceEspressoClient) FetchTransactionsInBlock(ctx context.Context, blockHeight uint64, namespace uint64) (espressoClient.TransactionsInBlock, error) {
+	return espressoClient.TransactionsInBlock{Transactions: c.transactions[blockHeight]}, nil
+}
+
+func newTestEspressoCoordinator(t *testing.T, redisUrl string, myUrl string) *SeqCoordinator {
+	config := TestSeqCoordinatorConfig
+	config.RedisUrl = redisUrl
+	config.MyUrl = myUrl
+	redisCoordinator, err := redisutil.NewRedisCoordinator(config.RedisUrl)
+	Require(t, err)
+	coordinator := &SeqCoordinator{
+		RedisCoordinator: *redisCoordinator,
+		config:           config,
+	}
+	atomicTimeWrite(&coordinator.lockoutUntil, time.Now().Add(time.Hour))
+	return coordinator
+}
+
+func TestEspressoStaleSubmissionTakeover(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	redisUrl := redisutil.CreateTestRedis(ctx, t)
+	oldChosen := newTestEspressoCoordinator(t, redisUrl, "old")
+	newChosen := newTestEspressoCoordinator(t, redisUrl, "new")
+
+	config := TestTransactionStreamerConfig
+	client := &namespaceEspressoClient{
+		countingEspressoClient: &countingEspressoClient{namespaceHeight: make(map[uint64]int)},
+		latest:                 5,
+		transactions:           make(map[uint64][]espressoTypes.Bytes),
+	}
+	streamer := &TransactionStreamer{
+		db:                     rawdb.NewMemoryDatabase(),
+		config:                 func() *TransactionStreamerConfig { return &config },
+		chainConfig:            &params.ChainConfig{ChainID: big.NewInt(412346)},
+		newSovereignTxNotifier: make(chan struct{}, 1),
+		coordinator:            newChosen,
+		espressoClient:         client,
+	}
+	for pos := uint64(3); pos <= 5; pos++ {
+		Require(t, streamer.db.Put(dbKey(messagePrefix, pos), []byte{byte(pos)}))
+		Require(t, streamer.SubmitEspressoTransactionPos(arbutil.MessageIndex(pos), streamer.db.NewBatch()))
+	}
+
+	// The previous chosen sequencer recorded a submission of the first two messages and died
+	raw, _ := buildRawHotShotPayload([]arbutil.MessageIndex{3, 4}, streamer.espressoMessageBytes, 200*1024)
+	payload, err := signHotShotPayload(raw, func([]byte) ([]byte, error) { return nil, nil })
+	Require(t, err)
+	Require(t, oldChosen.RecordEspressoSubmission(ctx, &EspressoSubmissionAudit{
+		Sequencer:     "old",
+		FirstPos:      3,
+		MsgCount:      2,
+		PayloadHash:   crypto.Keccak256Hash(payload),
+		HotShotHeight: 1,
+	}))
+
+	// Not in the namespace yet, the messages are submitted normally
+	adopted, err := streamer.adoptStaleEspressoSubmission(ctx)
+	Require(t, err)
+	if adopted {
+		Fail(t, "adopted a submission that isn't in the namespace")
+	}
+
+	client.transactions[3] = []espressoTypes.Bytes{[]byte("foreign"), payload}
+	adopted, err = streamer.adoptStaleEspressoSubmission(ctx)
+	Require(t, err)
+	if !adopted {
+		Fail(t, "stale submission wasn't adopted")
+	}
+	submitted, err := streamer.getEspressoSubmittedPos()
+	Require(t, err)
+	if !reflect.DeepEqual(submitted, []arbutil.MessageIndex{3, 4}) {
+		Fail(t, "unexpected submitted positions", submitted)
+	}
+	pending, err := streamer.getEspressoPendingTxnsPos()
+	Require(t, err)
+	if !reflect.DeepEqual(pending, []arbutil.MessageIndex{5}) {
+		Fail(t, "unexpected pending positions", pending)
+	}
+	hash, err := espressoTransactionHash(&espressoTypes.Transaction{Payload: payload, Namespace: 412346})
+	Require(t, err)
+	record, err := streamer.GetEspressoSubmissionRecord(3)
+	Require(t, err)
+	if record == nil || record.Status != EspressoSubmissionSubmitted || record.TxHash != hash.String() {
+		Fail(t, "unexpected submission record", record)
+	}
+}
@@ -0,0 +1,32 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package arbnode
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/metrics"
+
+	"github.com/offchainlabs/nitro/arbutil"
+)
+
+var feedBlockHashIgnoredCounter = metrics.NewRegisteredCounter("arb/streamer/feed_blockhash/ignored", nil)
+
+// trustedFeedBlockHash returns the block hash received from the feed for the message at pos if it may be adopted.
+// In the trustless replica mode the feed operator isn't trusted, so a feed block hash is only adopted once the
+// message has been justified by an Espresso confirmation verified by this node. Otherwise nil is returned,
+// and the block hash is computed locally instead.
+func (s *TransactionStreamer) trustedFeedBlockHash(pos arbutil.MessageIndex, blockHash *common.Hash) (*common.Hash, error) {
+	if blockHash == nil || !s.config().Espresso.TrustlessReplica {
+		return blockHash, nil
+	}
+	lastConfirmed, err := s.getLastConfirmedPos()
+	if err != nil {
+		return nil, err
+	}
+	if lastConfirmed != nil && pos <= *lastConfirmed {
+		return blockHash, nil
+	}
+	feedBlockHashIgnoredCounter.Inc(1)
+	return nil, nil
+}
@@ -0,0 +1,48 @@
+package arbnode
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+
+	"github.com/offchainlabs/nitro/arbutil"
+)
+
+func TestTrustedFeedBlockHash(t *testing.T) {
+	config := TestTransactionStreamerConfig
+	streamer := &TransactionStreamer{
+		db:     rawdb.NewMemoryDatabase(),
+		config: func() *TransactionStreamerConfig { return &config },
+	}
+	feedHash := common.HexToHash("0x1234")
+
+	// The feed is trusted unless running as a trustless replica
+	hash, err := streamer.trustedFeedBlockHash(5, &feedHash)
+	Require(t, err)
+	if hash == nil || *hash != feedHash {
+		Fail(t, "feed block hash not adopted", hash)
+	}
+
+	config.Espresso.TrustlessReplica = true
+	hash, err = streamer.trustedFeedBlockHash(5, &feedHash)
+	Require(t, err)
+	if hash != nil {
+		Fail(t, "feed block hash adopted without an espresso confirmation", hash)
+	}
+
+	lastConfirmed := arbutil.MessageIndex(5)
+	batch := streamer.db.NewBatch()
+	Require(t, streamer.setEspressoLastConfirmedPos(batch, &lastConfirmed))
+	Require(t, batch.Write())
+	hash, err = streamer.trustedFeedBlockHash(5, &feedHash)
+	Require(t, err)
+	if hash == nil || *hash != feedHash {
+		Fail(t, "feed block hash of a confirmed message not adopted", hash)
+	}
+	hash, err = streamer.trustedFeedBlockHash(6, &feedHash)
+	Require(t, err)
+	if hash != nil {
+		Fail(t, "feed block hash of an unconfirmed message adopted", hash)
+	}
+}
@@ -95,6 +95,13 @@ func ParseHotShotPayload(payload []byte) (signature []byte, indices []uint64, me
 	signature = payload[currentPos : currentPos+int(signatureSize)]
 	currentPos += int(signatureSize)
 
+	// The signature covers the payload as submitted, so compressed messages are only decompressed now
+	payload, err = decompressEspressoPayload(payload[currentPos:])
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	currentPos = 0
+
 	indices = []uint64{}
 	messages = [][]byte{}
 
@@ -0,0 +1,103 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package arbnode
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/rlp"
+
+	"github.com/offchainlabs/nitro/util/dbutil"
+)
+
+var (
+	ErrEspressoMerkleProofInvalid = errors.New("espresso block merkle proof doesn't verify against the light client root")
+	ErrEspressoVerificationHalt   = errors.New("halting after repeated espresso verification failures, the query service may be compromised")
+
+	espressoVerificationFailureCounter = metrics.NewRegisteredCounter("arb/espresso/verification/failures", nil)
+	espressoVerificationHaltCounter    = metrics.NewRegisteredCounter("arb/espresso/verification/halted", nil)
+)
+
+// The checks of the data fetched from the query service whose failures count towards the halt
+const (
+	espressoCheckMerkleProof    = "merkle-proof"
+	espressoCheckHeader         = "header"
+	espressoCheckNamespaceProof = "namespace-proof"
+)
+
+// EspressoVerificationHalt is stored when the node halts on repeated espresso verification failures, for post-mortem
+type EspressoVerificationHalt struct {
+	// Consecutive verification failures when the node halted
+	Failures uint64 `json:"failures"`
+	// The check that failed last
+	Check string `json:"check"`
+	// The last verification error
+	Error string `json:"error"`
+	// Unix time of the halt
+	HaltedAt uint64 `json:"haltedAt"`
+}
+
+// recordEspressoVerificationSuccess resets the consecutive verification failures once a justification was fully
+// verified
+func (s *TransactionStreamer) recordEspressoVerificationSuccess() {
+	s.espressoVerificationFailures.Store(0)
+}
+
+// recordEspressoVerificationFailure counts a header or proof fetched from the query service that failed
+// verification. Failures are retried like any other error, until the configured number of consecutive failures is
+// reached: the node then stops rather than retrying forever against a query service that may be compromised, and
+// the failure is stored for post-mortem.
+func (s *TransactionStreamer) recordEspressoVerificationFailure(check string, err error) {
+	espressoVerificationFailureCounter.Inc(1)
+	failures := s.espressoVerificationFailures.Add(1)
+	threshold := s.config().Espresso.VerificationFailureThreshold
+	if threshold == 0 || failures < threshold || !s.espressoVerificationHalted.CompareAndSwap(false, true) {
+		return
+	}
+	espressoVerificationHaltCounter.Inc(1)
+	halt := EspressoVerificationHalt{
+		Failures: failures,
+		Check:    check,
+		Error:    err.Error(),
+		// #nosec G115
+		HaltedAt: uint64(time.Now().Unix()),
+	}
+	if data, encodeErr := rlp.EncodeToBytes(&halt); encodeErr != nil {
+		log.Error("failed to encode the espresso verification halt", "err", encodeErr)
+	} else if putErr := s.db.Put(espressoVerificationHaltKey, data); putErr != nil {
+		log.Error("failed to store the espresso verification halt", "err", putErr)
+	}
+	haltErr := fmt.Errorf("%w: %d consecutive failures, last %s check failed: %w", ErrEspressoVerificationHalt, failures, check, err)
+	if s.fatalErrChan == nil {
+		log.Error("espresso verification kept failing", "err", haltErr)
+		return
+	}
+	select {
+	case s.fatalErrChan <- haltErr:
+	default:
+		// Another fatal error is already stopping the node
+		log.Error("espresso verification kept failing", "err", haltErr)
+	}
+}
+
+// GetEspressoVerificationHalt returns the last halt on repeated espresso verification failures, or nil if the node
+// never halted on them
+func (s *TransactionStreamer) GetEspressoVerificationHalt() (*EspressoVerificationHalt, error) {
+	data, err := s.db.Get(espressoVerificationHaltKey)
+	if err != nil {
+		if dbutil.IsErrNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var halt EspressoVerificationHalt
+	if err := rlp.DecodeBytes(data, &halt); err != nil {
+		return nil, err
+	}
+	return &halt, nil
+}
@@ -0,0 +1,68 @@
+package arbnode
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestEspressoVerificationHalt(t *testing.T) {
+	fatalErrChan := make(chan error, 2)
+	streamer := newTestStreamer(t, nil, func(c *TransactionStreamerConfig) {
+		c.Espresso.VerificationFailureThreshold = 3
+	}, WithFatalErrChan(fatalErrChan))
+
+	expectHalt := func(expected bool) {
+		t.Helper()
+		select {
+		case err := <-fatalErrChan:
+			if !expected {
+				Fail(t, "unexpected halt", err)
+			}
+			if !errors.Is(err, ErrEspressoVerificationHalt) || !errors.Is(err, ErrEspressoMerkleProofInvalid) {
+				Fail(t, "unexpected halt error", err)
+			}
+		default:
+			if expected {
+				Fail(t, "repeated verification failures didn't halt the node")
+			}
+		}
+	}
+
+	// A successful verification resets the failures
+	streamer.recordEspressoVerificationFailure(espressoCheckHeader, ErrEspressoHeaderMismatch)
+	streamer.recordEspressoVerificationFailure(espressoCheckHeader, ErrEspressoHeaderMismatch)
+	streamer.recordEspressoVerificationSuccess()
+	streamer.recordEspressoVerificationFailure(espressoCheckNamespaceProof, ErrEspressoNamespaceProofInvalid)
+	streamer.recordEspressoVerificationFailure(espressoCheckNamespaceProof, ErrEspressoNamespaceProofInvalid)
+	expectHalt(false)
+	halt, err := streamer.GetEspressoVerificationHalt()
+	Require(t, err)
+	if halt != nil {
+		Fail(t, "halt stored before the threshold", halt)
+	}
+
+	streamer.recordEspressoVerificationFailure(espressoCheckMerkleProof, ErrEspressoMerkleProofInvalid)
+	expectHalt(true)
+	halt, err = streamer.GetEspressoVerificationHalt()
+	Require(t, err)
+	if halt == nil || halt.Failures != 3 || halt.Check != espressoCheckMerkleProof || halt.Error != ErrEspressoMerkleProofInvalid.Error() {
+		Fail(t, "unexpected stored halt", halt)
+	}
+
+	// The node only halts once
+	streamer.recordEspressoVerificationFailure(espressoCheckMerkleProof, ErrEspressoMerkleProofInvalid)
+	expectHalt(false)
+}
+
+func TestEspressoVerificationHaltDisabled(t *testing.T) {
+	fatalErrChan := make(chan error, 1)
+	streamer := newTestStreamer(t, nil, nil, WithFatalErrChan(fatalErrChan))
+	for i := 0; i < 100; i++ {
+		streamer.recordEspressoVerificationFailure(espressoCheckHeader, ErrEspressoHeaderMismatch)
+	}
+	select {
+	case err := <-fatalErrChan:
+		Fail(t, "halted without a threshold", err)
+	default:
+	}
+}
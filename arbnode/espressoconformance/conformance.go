@@ -0,0 +1,346 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+// Package espressoconformance is a conformance suite for the espresso integration of a node. It runs the
+// submission pipeline of a node against a mock HotShot through submission, finality, resubmission, restarts
+// and reorgs, and checks the invariants of the pipeline after every step, so that forks changing the
+// integration can check that they still hold.
+package espressoconformance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/params"
+
+	"github.com/offchainlabs/nitro/arbnode"
+	"github.com/offchainlabs/nitro/arbos/arbostypes"
+	"github.com/offchainlabs/nitro/arbutil"
+	"github.com/offchainlabs/nitro/execution"
+	"github.com/offchainlabs/nitro/util/testhelpers"
+)
+
+// ChainID of the nodes the suite runs, it's also the namespace of their transactions
+const ChainID = 412346
+
+// Node is the part of a node the suite exercises. It's implemented by the transaction streamer, other builds
+// implement it to run the suite against their own pipeline.
+type Node interface {
+	Start(ctx context.Context) error
+	StopAndWait()
+	SetFinalityProvider(provider arbnode.FinalityProvider)
+	AddMessages(pos arbutil.MessageIndex, messagesAreConfirmed bool, messages []arbostypes.MessageWithMetadata) error
+	ReorgTo(count arbutil.MessageIndex) error
+	GetMessageCount() (arbutil.MessageIndex, error)
+	SubmitEspressoTransactionPos(pos arbutil.MessageIndex, batch ethdb.Batch) error
+	// StepEspresso runs a single iteration of the submission loop: the in-flight transaction is reconciled
+	// after a restart and polled for finality, then the pending messages are submitted
+	StepEspresso(ctx context.Context) error
+	GetEspressoStatus() (*arbnode.EspressoStatus, error)
+	GetEspressoSubmissionRecord(pos arbutil.MessageIndex) (*arbnode.EspressoSubmissionRecord, error)
+	GetEspressoJustification(pos arbutil.MessageIndex) (*arbnode.EspressoJustification, error)
+}
+
+var _ Node = (*arbnode.TransactionStreamer)(nil)
+
+// States of a message in the pipeline of a node
+const (
+	pending   = "pending"
+	submitted = "submitted"
+)
+
+// NewNodeFunc builds an unstarted node storing its state in db. A node built again on the same db must
+// carry on with the state it had, as it does after a restart.
+type NewNodeFunc func(db ethdb.Database, exec execution.ExecutionSequencer, config arbnode.TransactionStreamerConfigFetcher) (Node, error)
+
+// NewTransactionStreamer builds a node from the transaction streamer of this build
+func NewTransactionStreamer(db ethdb.Database, exec execution.ExecutionSequencer, config arbnode.TransactionStreamerConfigFetcher) (Node, error) {
+	streamer, err := arbnode.NewTransactionStreamerWithOptions(
+		db,
+		&params.ChainConfig{ChainID: big.NewInt(ChainID)},
+		arbnode.WithExecution(exec),
+		arbnode.WithConfig(config),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return streamer, nil
+}
+
+// Config returns the streamer config the suite runs nodes with
+func Config() arbnode.TransactionStreamerConfig {
+	config := arbnode.TestTransactionStreamerConfig
+	config.ReorgResequencePolicy = string(arbnode.ReorgDropAll)
+	// Transactions HotShot doesn't know are submitted again on the next poll
+	config.Espresso.ResubmissionTimeout = time.Nanosecond
+	config.Espresso.MaxResubmissions = 10
+	return config
+}
+
+// Run runs every scenario of the suite against the nodes newNode builds
+func Run(t *testing.T, newNode NewNodeFunc) {
+	scenarios := []struct {
+		name string
+		run  func(h *Harness)
+	}{
+		{"SubmitFinalize", testSubmitFinalize},
+		{"DroppedTransaction", testDroppedTransaction},
+		{"PayloadMismatch", testPayloadMismatch},
+		{"Restart", testRestart},
+		{"Reorg", testReorg},
+	}
+	for _, scenario := range scenarios {
+		t.Run(scenario.name, func(t *testing.T) {
+			h := NewHarness(t, newNode)
+			defer h.Stop()
+			scenario.run(h)
+		})
+	}
+}
+
+// Harness runs a node against a mock HotShot, checking the invariants after every step
+type Harness struct {
+	t       *testing.T
+	ctx     context.Context
+	cancel  context.CancelFunc
+	newNode NewNodeFunc
+	db      ethdb.Database
+	config  arbnode.TransactionStreamerConfig
+
+	Exec    *MockExecution
+	HotShot *MockHotShot
+	Node    Node
+	// Highest message count the node had, the state of messages past the current count must be gone
+	maxCount arbutil.MessageIndex
+}
+
+// NewHarness starts a node built by newNode on an empty database
+func NewHarness(t *testing.T, newNode NewNodeFunc) *Harness {
+	ctx, cancel := context.WithCancel(context.Background())
+	h := &Harness{
+		t:       t,
+		ctx:     ctx,
+		cancel:  cancel,
+		newNode: newNode,
+		db:      rawdb.NewMemoryDatabase(),
+		config:  Config(),
+		Exec:    &MockExecution{},
+		HotShot: NewMockHotShot(ChainID),
+	}
+	h.start()
+	return h
+}
+
+func (h *Harness) start() {
+	node, err := h.newNode(h.db, h.Exec, func() *arbnode.TransactionStreamerConfig { return &h.config })
+	testhelpers.RequireImpl(h.t, err)
+	node.SetFinalityProvider(h.HotShot)
+	testhelpers.RequireImpl(h.t, node.Start(h.ctx))
+	h.Node = node
+}
+
+// Stop stops the node
+func (h *Harness) Stop() {
+	if h.Node != nil {
+		h.Node.StopAndWait()
+		h.Node = nil
+	}
+	h.cancel()
+}
+
+// Restart stops the node and builds it again on its database, as if it crashed after the last step
+func (h *Harness) Restart() {
+	h.Node.StopAndWait()
+	h.start()
+	h.CheckInvariants()
+}
+
+// AddMessages adds messages until the node has count messages
+func (h *Harness) AddMessages(count arbutil.MessageIndex) {
+	current, err := h.Node.GetMessageCount()
+	testhelpers.RequireImpl(h.t, err)
+	var messages []arbostypes.MessageWithMetadata
+	for pos := current; pos < count; pos++ {
+		messages = append(messages, arbostypes.MessageWithMetadata{
+			Message: &arbostypes.L1IncomingMessage{
+				Header: &arbostypes.L1IncomingMessageHeader{
+					Kind: arbostypes.L1MessageType_L2Message,
+					// #nosec G115
+					Timestamp: uint64(pos),
+				},
+				L2msg: []byte(fmt.Sprintf("message %d", pos)),
+			},
+			DelayedMessagesRead: 1,
+		})
+	}
+	testhelpers.RequireImpl(h.t, h.Node.AddMessages(current, true, messages))
+	h.maxCount = max(h.maxCount, count)
+	h.CheckInvariants()
+}
+
+// Queue queues the messages in [from, to) for submission
+func (h *Harness) Queue(from arbutil.MessageIndex, to arbutil.MessageIndex) {
+	for pos := from; pos < to; pos++ {
+		testhelpers.RequireImpl(h.t, h.Node.SubmitEspressoTransactionPos(pos, h.db.NewBatch()))
+	}
+	h.CheckInvariants()
+}
+
+// Step runs an iteration of the submission loop, it returns the error the node returned
+func (h *Harness) Step() error {
+	err := h.Node.StepEspresso(h.ctx)
+	h.CheckInvariants()
+	return err
+}
+
+// MustStep runs an iteration of the submission loop, which must succeed
+func (h *Harness) MustStep() {
+	testhelpers.RequireImpl(h.t, h.Step())
+}
+
+// Finalize finalizes the submitted transactions on HotShot and polls them for finality
+func (h *Harness) Finalize() uint64 {
+	height := h.HotShot.Advance()
+	h.MustStep()
+	return height
+}
+
+// ReorgTo reorgs the node to count messages
+func (h *Harness) ReorgTo(count arbutil.MessageIndex) {
+	testhelpers.RequireImpl(h.t, h.Node.ReorgTo(count))
+	h.CheckInvariants()
+}
+
+// RequireStatus requires the messages in [from, to) to have status
+func (h *Harness) RequireStatus(from arbutil.MessageIndex, to arbutil.MessageIndex, status arbnode.EspressoSubmissionStatus) {
+	for pos := from; pos < to; pos++ {
+		record, err := h.Node.GetEspressoSubmissionRecord(pos)
+		testhelpers.RequireImpl(h.t, err)
+		if record == nil || record.Status != status {
+			testhelpers.FailImpl(h.t, "message", pos, "expected to be", status, "got", record)
+		}
+	}
+}
+
+// CheckInvariants fails the test if the node broke an invariant of the pipeline
+func (h *Harness) CheckInvariants() {
+	h.t.Helper()
+	if err := CheckInvariants(h.Node, h.HotShot, h.maxCount); err != nil {
+		testhelpers.FailImpl(h.t, err)
+	}
+}
+
+// CheckInvariants checks the invariants of the submission pipeline of node against what was submitted to
+// hotshot. maxCount is the highest message count the node had, the state of messages past its current count
+// must have been removed by the reorgs.
+//   - No transaction is submitted again while HotShot knows it.
+//   - A message is either pending, submitted or neither, and its submission record agrees.
+//   - Finalized messages are justified by the block HotShot finalized their transaction in.
+//   - Justification heights don't decrease with the position of the messages.
+//   - Nothing is kept for messages past the message count.
+func CheckInvariants(node Node, hotshot *MockHotShot, maxCount arbutil.MessageIndex) error {
+	for _, submission := range hotshot.Submissions() {
+		if submission.Duplicate {
+			return fmt.Errorf("transaction %s submitted again while hotshot knows it", submission.Hash)
+		}
+	}
+	count, err := node.GetMessageCount()
+	if err != nil {
+		return err
+	}
+	status, err := node.GetEspressoStatus()
+	if err != nil {
+		return err
+	}
+	states := make(map[arbutil.MessageIndex]string)
+	for _, positions := range []struct {
+		state     string
+		positions []arbutil.MessageIndex
+	}{{pending, status.PendingPositions}, {submitted, status.SubmittedPositions}} {
+		for _, pos := range positions.positions {
+			if pos >= count {
+				return fmt.Errorf("message %d is %s past the message count %d", pos, positions.state, count)
+			}
+			if state, ok := states[pos]; ok {
+				return fmt.Errorf("message %d is both %s and %s", pos, state, positions.state)
+			}
+			states[pos] = positions.state
+		}
+	}
+	if len(status.SubmittedPositions) > 0 && status.SubmittedTxHash == nil {
+		return errors.New("messages are submitted without a transaction hash")
+	}
+	if status.LastConfirmedPos != nil && *status.LastConfirmedPos >= count {
+		return fmt.Errorf("last confirmed message %d is past the message count %d", *status.LastConfirmedPos, count)
+	}
+
+	var lastHeight uint64
+	for pos := arbutil.MessageIndex(0); pos < max(count, maxCount); pos++ {
+		record, err := node.GetEspressoSubmissionRecord(pos)
+		if err != nil {
+			return err
+		}
+		justification, err := node.GetEspressoJustification(pos)
+		if err != nil {
+			return err
+		}
+		if pos >= count {
+			if record != nil || justification != nil {
+				return fmt.Errorf("reorged message %d still has a submission record or justification", pos)
+			}
+			continue
+		}
+		state := states[pos]
+		if err := checkSubmissionRecord(pos, state, record, justification, status, hotshot); err != nil {
+			return err
+		}
+		if justification != nil {
+			if justification.HotShotHeight < lastHeight {
+				return fmt.Errorf("message %d is justified at height %d, before the height %d of a previous message", pos, justification.HotShotHeight, lastHeight)
+			}
+			lastHeight = justification.HotShotHeight
+		}
+	}
+	return nil
+}
+
+func checkSubmissionRecord(pos arbutil.MessageIndex, state string, record *arbnode.EspressoSubmissionRecord, justification *arbnode.EspressoJustification, status *arbnode.EspressoStatus, hotshot *MockHotShot) error {
+	if record == nil {
+		if state != "" || justification != nil {
+			return fmt.Errorf("message %d has no submission record, but is %q or justified", pos, state)
+		}
+		return nil
+	}
+	switch record.Status {
+	case arbnode.EspressoSubmissionPending, arbnode.EspressoSubmissionFailed:
+		if state != pending {
+			return fmt.Errorf("message %d is %v, but not in the pending queue", pos, record.Status)
+		}
+	case arbnode.EspressoSubmissionSubmitted:
+		if state != submitted || record.TxHash != *status.SubmittedTxHash {
+			return fmt.Errorf("message %d is submitted in %s, but the in-flight transaction is %v", pos, record.TxHash, status.SubmittedTxHash)
+		}
+	case arbnode.EspressoSubmissionFinalized:
+		if state != "" {
+			return fmt.Errorf("finalized message %d is still %s", pos, state)
+		}
+		if justification == nil {
+			return fmt.Errorf("finalized message %d has no justification", pos)
+		}
+		height, ok := hotshot.FinalizedHeight(record.TxHash)
+		if !ok || height != justification.HotShotHeight {
+			return fmt.Errorf("message %d is justified at height %d, its transaction %s wasn't finalized there", pos, justification.HotShotHeight, record.TxHash)
+		}
+	default:
+		if state != "" {
+			return fmt.Errorf("message %d is %v, but still %s", pos, record.Status, state)
+		}
+	}
+	return nil
+}
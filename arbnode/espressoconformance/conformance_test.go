@@ -0,0 +1,9 @@
+package espressoconformance
+
+import (
+	"testing"
+)
+
+func TestTransactionStreamerConformance(t *testing.T) {
+	Run(t, NewTransactionStreamer)
+}
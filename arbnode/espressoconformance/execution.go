@@ -0,0 +1,73 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package espressoconformance
+
+import (
+	"encoding/binary"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/crypto"
+
+	"github.com/offchainlabs/nitro/arbos/arbostypes"
+	"github.com/offchainlabs/nitro/arbutil"
+	"github.com/offchainlabs/nitro/execution"
+)
+
+// MockExecution stands in for the execution client of the node, so that stored messages can be reorged.
+// Messages are digested without being executed, their block hashes are derived from their positions.
+type MockExecution struct {
+	execution.ExecutionSequencer
+
+	mutex sync.Mutex
+	head  arbutil.MessageIndex
+}
+
+func mockResult(pos arbutil.MessageIndex) *execution.MessageResult {
+	var key [8]byte
+	binary.BigEndian.PutUint64(key[:], uint64(pos))
+	return &execution.MessageResult{BlockHash: crypto.Keccak256Hash(key[:])}
+}
+
+func (e *MockExecution) DigestMessage(pos arbutil.MessageIndex, msg *arbostypes.MessageWithMetadata, msgForPrefetch *arbostypes.MessageWithMetadata) (*execution.MessageResult, error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.head = pos
+	return mockResult(pos), nil
+}
+
+func (e *MockExecution) DigestMessages(pos arbutil.MessageIndex, msgs []*arbostypes.MessageWithMetadata, msgForPrefetch *arbostypes.MessageWithMetadata) ([]*execution.MessageResult, error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	results := make([]*execution.MessageResult, 0, len(msgs))
+	for i := range msgs {
+		// #nosec G115
+		results = append(results, mockResult(pos+arbutil.MessageIndex(i)))
+	}
+	// #nosec G115
+	e.head = pos + arbutil.MessageIndex(len(msgs)) - 1
+	return results, nil
+}
+
+func (e *MockExecution) Reorg(count arbutil.MessageIndex, newMessages []arbostypes.MessageWithMetadataAndBlockHash, oldMessages []*arbostypes.MessageWithMetadata) ([]*execution.MessageResult, error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	results := make([]*execution.MessageResult, 0, len(newMessages))
+	for i := range newMessages {
+		// #nosec G115
+		results = append(results, mockResult(count+arbutil.MessageIndex(i)))
+	}
+	// #nosec G115
+	e.head = count + arbutil.MessageIndex(len(newMessages)) - 1
+	return results, nil
+}
+
+func (e *MockExecution) HeadMessageNumber() (arbutil.MessageIndex, error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.head, nil
+}
+
+func (e *MockExecution) ResultAtPos(pos arbutil.MessageIndex) (*execution.MessageResult, error) {
+	return mockResult(pos), nil
+}
@@ -0,0 +1,179 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package espressoconformance
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	tagged_base64 "github.com/EspressoSystems/espresso-sequencer-go/tagged-base64"
+	espressoTypes "github.com/EspressoSystems/espresso-sequencer-go/types"
+
+	"github.com/offchainlabs/nitro/arbnode"
+)
+
+// Submission is a transaction a node sent to the mock HotShot
+type Submission struct {
+	Hash    string
+	Payload []byte
+	// Set if the transaction was already known when it was submitted
+	Duplicate bool
+	// Set if the transaction was dropped instead of being included
+	Dropped bool
+}
+
+type mockTransaction struct {
+	payload []byte
+	// Height of the block the transaction was finalized in, 0 while it isn't finalized
+	height   uint64
+	mismatch bool
+}
+
+// MockHotShot is an in-memory ordering layer implementing arbnode.FinalityProvider. Submitted transactions are
+// finalized in a new block by Advance, and faults are injected for the next submissions with DropNext and
+// MismatchNext. Every submission is recorded, so that the suite can check the invariants against them.
+type MockHotShot struct {
+	namespace uint64
+
+	mutex        sync.Mutex
+	height       uint64
+	transactions map[string]*mockTransaction
+	// Heights the transactions were finalized at, kept after a mismatch was reported
+	finalized    map[string]uint64
+	submissions  []Submission
+	dropNext     int
+	mismatchNext int
+}
+
+var _ arbnode.FinalityProvider = (*MockHotShot)(nil)
+
+func NewMockHotShot(namespace uint64) *MockHotShot {
+	return &MockHotShot{
+		namespace:    namespace,
+		transactions: make(map[string]*mockTransaction),
+		finalized:    make(map[string]uint64),
+	}
+}
+
+func (h *MockHotShot) TransactionHash(payload []byte) (*espressoTypes.TaggedBase64, error) {
+	tx := espressoTypes.Transaction{Payload: payload, Namespace: h.namespace}
+	commit := tx.Commit()
+	return tagged_base64.New("TX", commit[:])
+}
+
+func (h *MockHotShot) Submit(ctx context.Context, payload []byte) (*espressoTypes.TaggedBase64, error) {
+	hash, err := h.TransactionHash(payload)
+	if err != nil {
+		return nil, err
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	submission := Submission{Hash: hash.String(), Payload: bytes.Clone(payload)}
+	if _, ok := h.transactions[submission.Hash]; ok {
+		submission.Duplicate = true
+	} else if h.dropNext > 0 {
+		h.dropNext--
+		submission.Dropped = true
+	} else {
+		tx := &mockTransaction{payload: submission.Payload}
+		if h.mismatchNext > 0 {
+			h.mismatchNext--
+			tx.mismatch = true
+		}
+		h.transactions[submission.Hash] = tx
+	}
+	h.submissions = append(h.submissions, submission)
+	return hash, nil
+}
+
+func (h *MockHotShot) FindTransaction(ctx context.Context, hash *espressoTypes.TaggedBase64) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if _, ok := h.transactions[hash.String()]; !ok {
+		return fmt.Errorf("transaction %s not found", hash.String())
+	}
+	return nil
+}
+
+func (h *MockHotShot) Finality(ctx context.Context, hash *espressoTypes.TaggedBase64, payload []byte) (*arbnode.Finality, error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	tx, ok := h.transactions[hash.String()]
+	if !ok {
+		return nil, fmt.Errorf("%w (hash: %s)", arbnode.ErrFinalityTransactionNotFound, hash.String())
+	}
+	if tx.height == 0 {
+		return nil, nil
+	}
+	if tx.mismatch || !bytes.Equal(tx.payload, payload) {
+		// The transaction is consumed, so that the messages can be submitted again with the same payload
+		delete(h.transactions, hash.String())
+		return nil, fmt.Errorf("%w (height: %d)", arbnode.ErrFinalityPayloadMismatch, tx.height)
+	}
+	justification, err := mockJustification(tx.height)
+	if err != nil {
+		return nil, err
+	}
+	return &arbnode.Finality{Height: tx.height, Justification: justification}, nil
+}
+
+// mockJustification is a stand-in for the header and block merkle proof of a block, it isn't verifiable
+func mockJustification(height uint64) (*arbnode.EspressoJustification, error) {
+	header, err := json.Marshal(map[string]uint64{"height": height})
+	if err != nil {
+		return nil, err
+	}
+	return &arbnode.EspressoJustification{
+		HotShotHeight: height,
+		Header:        header,
+		RootHeight:    height + 1,
+		Proof:         []byte("{}"),
+	}, nil
+}
+
+// Advance finalizes every included transaction in a new block, and returns its height
+func (h *MockHotShot) Advance() uint64 {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.height++
+	for hash, tx := range h.transactions {
+		if tx.height == 0 {
+			tx.height = h.height
+			h.finalized[hash] = h.height
+		}
+	}
+	return h.height
+}
+
+// DropNext makes the next n transactions submitted get lost, as if the builder dropped them
+func (h *MockHotShot) DropNext(n int) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.dropNext = n
+}
+
+// MismatchNext makes the next n transactions submitted get finalized with another payload
+func (h *MockHotShot) MismatchNext(n int) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.mismatchNext = n
+}
+
+// Submissions returns every transaction submitted so far
+func (h *MockHotShot) Submissions() []Submission {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return append([]Submission(nil), h.submissions...)
+}
+
+// FinalizedHeight returns the height the transaction with hash was finalized at, and false if it wasn't
+func (h *MockHotShot) FinalizedHeight(hash string) (uint64, bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	height, ok := h.finalized[hash]
+	return height, ok
+}
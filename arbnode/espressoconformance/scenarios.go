@@ -0,0 +1,150 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package espressoconformance
+
+import (
+	"errors"
+
+	"github.com/offchainlabs/nitro/arbnode"
+	"github.com/offchainlabs/nitro/arbutil"
+	"github.com/offchainlabs/nitro/util/testhelpers"
+)
+
+func (h *Harness) requireSubmissions(count int) []Submission {
+	submissions := h.HotShot.Submissions()
+	if len(submissions) != count {
+		testhelpers.FailImpl(h.t, "expected", count, "submissions, got", len(submissions))
+	}
+	return submissions
+}
+
+func (h *Harness) requireJustifiedAt(from arbutil.MessageIndex, to arbutil.MessageIndex, height uint64) {
+	for pos := from; pos < to; pos++ {
+		justification, err := h.Node.GetEspressoJustification(pos)
+		testhelpers.RequireImpl(h.t, err)
+		if justification == nil || justification.HotShotHeight != height {
+			testhelpers.FailImpl(h.t, "message", pos, "expected to be justified at height", height, "got", justification)
+		}
+	}
+}
+
+// Queued messages are submitted in a single transaction, and justified once it's finalized
+func testSubmitFinalize(h *Harness) {
+	h.AddMessages(6)
+	h.Queue(1, 6)
+	h.MustStep()
+	h.RequireStatus(1, 6, arbnode.EspressoSubmissionSubmitted)
+	h.requireSubmissions(1)
+	// Nothing changes until the transaction is finalized
+	h.MustStep()
+	h.RequireStatus(1, 6, arbnode.EspressoSubmissionSubmitted)
+
+	height := h.Finalize()
+	h.RequireStatus(1, 6, arbnode.EspressoSubmissionFinalized)
+	h.requireJustifiedAt(1, 6, height)
+	h.requireSubmissions(1)
+}
+
+// A transaction the builder dropped is submitted again once the resubmission timeout elapsed
+func testDroppedTransaction(h *Harness) {
+	h.AddMessages(4)
+	h.HotShot.DropNext(1)
+	h.Queue(1, 4)
+	h.MustStep()
+	h.RequireStatus(1, 4, arbnode.EspressoSubmissionSubmitted)
+	h.HotShot.Advance()
+	h.MustStep()
+	submissions := h.requireSubmissions(2)
+	if !submissions[0].Dropped || submissions[1].Dropped {
+		testhelpers.FailImpl(h.t, "dropped transaction wasn't submitted again", submissions)
+	}
+
+	height := h.Finalize()
+	h.RequireStatus(1, 4, arbnode.EspressoSubmissionFinalized)
+	h.requireJustifiedAt(1, 4, height)
+	record, err := h.Node.GetEspressoSubmissionRecord(1)
+	testhelpers.RequireImpl(h.t, err)
+	if record.Attempts < 2 {
+		testhelpers.FailImpl(h.t, "resubmission not counted", record)
+	}
+}
+
+// Messages of a transaction finalized with another payload aren't justified by it, they're submitted again
+func testPayloadMismatch(h *Harness) {
+	h.AddMessages(3)
+	h.HotShot.MismatchNext(1)
+	h.Queue(1, 3)
+	h.MustStep()
+	h.HotShot.Advance()
+	if err := h.Step(); !errors.Is(err, arbnode.ErrFinalityPayloadMismatch) {
+		testhelpers.FailImpl(h.t, "payload mismatch not reported", err)
+	}
+	h.RequireStatus(1, 3, arbnode.EspressoSubmissionFailed)
+
+	h.MustStep()
+	h.RequireStatus(1, 3, arbnode.EspressoSubmissionSubmitted)
+	height := h.Finalize()
+	h.RequireStatus(1, 3, arbnode.EspressoSubmissionFinalized)
+	h.requireJustifiedAt(1, 3, height)
+	h.requireSubmissions(2)
+}
+
+// A transaction in flight when the node restarts is only submitted again if HotShot doesn't know it
+func testRestart(h *Harness) {
+	h.AddMessages(4)
+	h.Queue(1, 4)
+	h.MustStep()
+	h.Restart()
+	h.MustStep()
+	h.requireSubmissions(1)
+	height := h.Finalize()
+	h.RequireStatus(1, 4, arbnode.EspressoSubmissionFinalized)
+	h.requireJustifiedAt(1, 4, height)
+
+	// The node crashed after persisting the submission, before HotShot got the transaction
+	h.AddMessages(6)
+	h.HotShot.DropNext(1)
+	h.Queue(4, 6)
+	h.MustStep()
+	h.Restart()
+	h.MustStep()
+	submissions := h.requireSubmissions(3)
+	if submissions[2].Dropped || submissions[2].Hash != submissions[1].Hash {
+		testhelpers.FailImpl(h.t, "in-flight transaction not submitted again after the restart", submissions)
+	}
+	height = h.Finalize()
+	h.RequireStatus(4, 6, arbnode.EspressoSubmissionFinalized)
+	h.requireJustifiedAt(4, 6, height)
+}
+
+// Reorged messages are dropped from the pipeline, and an in-flight transaction including any of them
+// doesn't justify the messages at their positions
+func testReorg(h *Harness) {
+	h.AddMessages(8)
+	h.Queue(1, 4)
+	h.MustStep()
+	h.Queue(4, 8)
+	h.ReorgTo(6)
+	height := h.Finalize()
+	h.RequireStatus(1, 4, arbnode.EspressoSubmissionFinalized)
+	h.requireJustifiedAt(1, 4, height)
+	h.RequireStatus(4, 6, arbnode.EspressoSubmissionSubmitted)
+	height = h.Finalize()
+	h.RequireStatus(4, 6, arbnode.EspressoSubmissionFinalized)
+	h.requireJustifiedAt(4, 6, height)
+
+	h.AddMessages(9)
+	h.Queue(6, 9)
+	h.MustStep()
+	h.RequireStatus(6, 9, arbnode.EspressoSubmissionSubmitted)
+	h.ReorgTo(7)
+	h.RequireStatus(6, 7, arbnode.EspressoSubmissionPending)
+	// The abandoned transaction is still finalized by HotShot
+	h.HotShot.Advance()
+	h.MustStep()
+	h.RequireStatus(6, 7, arbnode.EspressoSubmissionSubmitted)
+	height = h.Finalize()
+	h.RequireStatus(6, 7, arbnode.EspressoSubmissionFinalized)
+	h.requireJustifiedAt(6, 7, height)
+}
@@ -0,0 +1,84 @@
+package arbnode
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"github.com/offchainlabs/nitro/arbos/arbostypes"
+	"github.com/offchainlabs/nitro/arbutil"
+	"github.com/offchainlabs/nitro/execution"
+)
+
+func batchTestBlockHash(pos arbutil.MessageIndex) common.Hash {
+	return common.BigToHash(new(big.Int).SetUint64(uint64(pos) + 1))
+}
+
+type batchCountingExecution struct {
+	execution.ExecutionSequencer
+	head    arbutil.MessageIndex
+	single  int
+	batches []int
+}
+
+func (e *batchCountingExecution) HeadMessageNumber() (arbutil.MessageIndex, error) {
+	return e.head, nil
+}
+
+func (e *batchCountingExecution) DigestMessage(pos arbutil.MessageIndex, msg *arbostypes.MessageWithMetadata, msgForPrefetch *arbostypes.MessageWithMetadata) (*execution.MessageResult, error) {
+	e.single++
+	e.head = pos
+	return &execution.MessageResult{BlockHash: batchTestBlockHash(pos)}, nil
+}
+
+func (e *batchCountingExecution) DigestMessages(pos arbutil.MessageIndex, msgs []*arbostypes.MessageWithMetadata, msgForPrefetch *arbostypes.MessageWithMetadata) ([]*execution.MessageResult, error) {
+	e.batches = append(e.batches, len(msgs))
+	var results []*execution.MessageResult
+	for i := range msgs {
+		// #nosec G115
+		e.head = pos + arbutil.MessageIndex(i)
+		results = append(results, &execution.MessageResult{BlockHash: batchTestBlockHash(e.head)})
+	}
+	return results, nil
+}
+
+func TestExecuteMessageBatches(t *testing.T) {
+	ctx := context.Background()
+	streamer := newTestImportStreamer(t)
+	config := streamer.config()
+	config.ExecuteBatchThreshold = 3
+	config.ExecuteBatchSize = 4
+	exec := &batchCountingExecution{}
+	streamer.exec = exec
+	var messages []arbostypes.MessageWithMetadata
+	for i := uint64(0); i < 10; i++ {
+		messages = append(messages, arbostypes.MessageWithMetadata{
+			Message: &arbostypes.L1IncomingMessage{Header: &arbostypes.L1IncomingMessageHeader{Timestamp: i}},
+		})
+	}
+	Require(t, streamer.AddMessages(0, true, messages))
+
+	for streamer.ExecuteNextMsg(ctx, exec) {
+	}
+	if exec.head != 9 {
+		Fail(t, "not all messages executed, head", exec.head)
+	}
+	// Batches are executed while more than 3 messages are left, then messages are executed one at a time
+	if len(exec.batches) != 2 || exec.batches[0] != 4 || exec.batches[1] != 4 || exec.single != 1 {
+		Fail(t, "unexpected executions", exec.batches, exec.single)
+	}
+	for count := arbutil.MessageIndex(2); count <= 10; count++ {
+		result, err := streamer.ResultAtCount(count)
+		Require(t, err)
+		if result.BlockHash != batchTestBlockHash(count-1) {
+			Fail(t, "unexpected result stored at count", count)
+		}
+		blockHash, err := streamer.getStoredBlockHash(count - 1)
+		Require(t, err)
+		if blockHash == nil || *blockHash != result.BlockHash {
+			Fail(t, "missing block hash not repaired at count", count)
+		}
+	}
+}
@@ -0,0 +1,257 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package arbnode
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+	flag "github.com/spf13/pflag"
+
+	"github.com/offchainlabs/nitro/arbos/arbostypes"
+	"github.com/offchainlabs/nitro/arbutil"
+	"github.com/offchainlabs/nitro/util/containers"
+)
+
+var (
+	executionPipelineHitCounter                  = metrics.NewRegisteredCounter("arb/streamer/execution_pipeline/hit", nil)
+	executionPipelineMissCounter                 = metrics.NewRegisteredCounter("arb/streamer/execution_pipeline/miss", nil)
+	executionPipelineInvalidJustificationCounter = metrics.NewRegisteredCounter("arb/streamer/execution_pipeline/invalid_justification", nil)
+)
+
+// errExecutionPipelineSkipped is the result of a message the pipeline didn't prepare, because of a reorg: it's read
+// when it's executed
+var errExecutionPipelineSkipped = errors.New("message not prepared by the execution pipeline")
+
+// Number of HotShot heights whose justifications were verified that are remembered, as the messages finalized in
+// the same HotShot block share a justification
+const executionPipelineVerifiedHeights = 64
+
+type ExecutionPipelineConfig struct {
+	Lookahead            uint64 `koanf:"lookahead" reload:"hot"`
+	Workers              int    `koanf:"workers" reload:"hot"`
+	VerifyJustifications bool   `koanf:"verify-justifications" reload:"hot"`
+}
+
+var DefaultExecutionPipelineConfig = ExecutionPipelineConfig{
+	Lookahead:            0,
+	Workers:              4,
+	VerifyJustifications: false,
+}
+
+func ExecutionPipelineConfigAddOptions(prefix string, f *flag.FlagSet) {
+	f.Uint64(prefix+".lookahead", DefaultExecutionPipelineConfig.Lookahead, "number of upcoming messages read, decoded and checked in the background while a message is executed, speeding up catching up (0 = read each message when it's executed)")
+	f.Int(prefix+".workers", DefaultExecutionPipelineConfig.Workers, "number of background workers preparing the upcoming messages")
+	f.Bool(prefix+".verify-justifications", DefaultExecutionPipelineConfig.VerifyJustifications, "verify the stored espresso justifications of the upcoming messages against the hotshot light client, logging the messages whose justification doesn't verify")
+}
+
+func (c *ExecutionPipelineConfig) Validate() error {
+	if c.Lookahead > 0 && c.Workers <= 0 {
+		return errors.New("execution-pipeline workers must be positive while the lookahead is set")
+	}
+	return nil
+}
+
+type prefetchedMessage struct {
+	pos        arbutil.MessageIndex
+	generation uint64
+	done       chan struct{}
+	msg        *arbostypes.MessageWithMetadataAndBlockHash
+	err        error
+}
+
+// executionPipeline prepares the messages following the one being executed in background workers: they're read,
+// decoded, which may read their batch from the parent chain to fill in the batch gas cost, and optionally have
+// their espresso justification verified. The pipeline is reset on reorgs, the messages prepared before are
+// dropped. Workers read with the reorgMutex read lock, and give way to a pending reorg instead of waiting for it
+// while the message they prepare is awaited under the same lock. A message read before a reset is discarded.
+type executionPipeline struct {
+	mutex   sync.Mutex
+	entries map[arbutil.MessageIndex]*prefetchedMessage
+	queue   []*prefetchedMessage
+	// First position that wasn't scheduled since the last reset
+	next    arbutil.MessageIndex
+	workers int
+	// Incremented on every reset
+	generation uint64
+
+	verifiedMutex  sync.Mutex
+	verifiedHeight *containers.LruCache[uint64, struct{}]
+}
+
+func newExecutionPipeline() *executionPipeline {
+	return &executionPipeline{
+		entries:        make(map[arbutil.MessageIndex]*prefetchedMessage),
+		verifiedHeight: containers.NewLruCache[uint64, struct{}](executionPipelineVerifiedHeights),
+	}
+}
+
+func (p *executionPipeline) reset() {
+	if p == nil {
+		return
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.entries = make(map[arbutil.MessageIndex]*prefetchedMessage)
+	p.queue = nil
+	p.next = 0
+	p.generation++
+}
+
+// take removes the entry of pos from the pipeline, it's nil if pos wasn't scheduled
+func (p *executionPipeline) take(pos arbutil.MessageIndex) *prefetchedMessage {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	entry := p.entries[pos]
+	delete(p.entries, pos)
+	return entry
+}
+
+// peek returns the message at pos if it's already prepared
+func (p *executionPipeline) peek(pos arbutil.MessageIndex) *arbostypes.MessageWithMetadataAndBlockHash {
+	if p == nil {
+		return nil
+	}
+	p.mutex.Lock()
+	entry := p.entries[pos]
+	p.mutex.Unlock()
+	if entry == nil {
+		return nil
+	}
+	select {
+	case <-entry.done:
+		return entry.msg
+	default:
+		return nil
+	}
+}
+
+// schedule prepares the messages from pos, up to the lookahead and the message count, dropping the messages
+// before pos, and starts the workers needed
+func (p *executionPipeline) schedule(s *TransactionStreamer, pos arbutil.MessageIndex, msgCount arbutil.MessageIndex, config *ExecutionPipelineConfig) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for entryPos := range p.entries {
+		if entryPos < pos {
+			delete(p.entries, entryPos)
+		}
+	}
+	end := min(msgCount, pos+arbutil.MessageIndex(config.Lookahead))
+	for next := max(p.next, pos); next < end; next++ {
+		entry := &prefetchedMessage{pos: next, generation: p.generation, done: make(chan struct{})}
+		p.entries[next] = entry
+		p.queue = append(p.queue, entry)
+	}
+	p.next = max(p.next, end)
+	for p.workers < config.Workers && len(p.queue) > 0 {
+		if err := s.LaunchThreadSafe(func(ctx context.Context) { p.work(ctx, s) }); err != nil {
+			// The streamer is stopping, the messages are read when they're executed
+			p.entries = make(map[arbutil.MessageIndex]*prefetchedMessage)
+			p.queue = nil
+			p.next = 0
+			return
+		}
+		p.workers++
+	}
+}
+
+func (p *executionPipeline) work(ctx context.Context, s *TransactionStreamer) {
+	for ctx.Err() == nil {
+		p.mutex.Lock()
+		if len(p.queue) == 0 {
+			p.workers--
+			p.mutex.Unlock()
+			return
+		}
+		entry := p.queue[0]
+		p.queue = p.queue[1:]
+		p.mutex.Unlock()
+
+		p.prepare(s, entry)
+		if entry.err == nil && s.config().ExecutionPipeline.VerifyJustifications {
+			p.verifyJustification(s, entry.pos)
+		}
+		close(entry.done)
+	}
+	p.mutex.Lock()
+	p.workers--
+	p.mutex.Unlock()
+}
+
+// prepare reads the message of entry, unless a reorg is pending or reset the pipeline since it was scheduled
+func (p *executionPipeline) prepare(s *TransactionStreamer, entry *prefetchedMessage) {
+	// The message may be awaited with the read lock held, waiting for a pending reorg here would deadlock
+	if !s.reorgMutex.TryRLock() {
+		entry.err = errExecutionPipelineSkipped
+		return
+	}
+	defer s.reorgMutex.RUnlock()
+	msg, err := s.getMessageWithMetadataAndBlockHash(entry.pos)
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.generation != entry.generation {
+		entry.err = errExecutionPipelineSkipped
+		return
+	}
+	entry.msg, entry.err = msg, err
+}
+
+// verifyJustification verifies the stored espresso justification of the message at pos, if it has one
+func (p *executionPipeline) verifyJustification(s *TransactionStreamer, pos arbutil.MessageIndex) {
+	finality := s.espressoFinalityForFeed(pos, 1)[0]
+	if finality == nil || finality.Justification == nil {
+		return
+	}
+	p.verifiedMutex.Lock()
+	verified := p.verifiedHeight.Contains(finality.HotShotHeight)
+	p.verifiedMutex.Unlock()
+	if verified {
+		return
+	}
+	if err := s.verifyEspressoFeedJustification(finality); err != nil {
+		executionPipelineInvalidJustificationCounter.Inc(1)
+		log.Error("stored espresso justification of a message to execute doesn't verify", "pos", pos, "height", finality.HotShotHeight, "err", err)
+		return
+	}
+	p.verifiedMutex.Lock()
+	p.verifiedHeight.Add(finality.HotShotHeight, struct{}{})
+	p.verifiedMutex.Unlock()
+}
+
+// nextMessageToExecute returns the message at pos, which is about to be executed, and schedules the messages
+// following it to be prepared while it is
+func (s *TransactionStreamer) nextMessageToExecute(ctx context.Context, pos arbutil.MessageIndex, msgCount arbutil.MessageIndex) (*arbostypes.MessageWithMetadataAndBlockHash, error) {
+	config := s.config().ExecutionPipeline
+	if config.Lookahead == 0 {
+		return s.getMessageWithMetadataAndBlockHash(pos)
+	}
+	entry := s.executionPipeline.take(pos)
+	s.executionPipeline.schedule(s, pos+1, msgCount, &config)
+	if entry == nil {
+		executionPipelineMissCounter.Inc(1)
+		return s.getMessageWithMetadataAndBlockHash(pos)
+	}
+	select {
+	case <-entry.done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if errors.Is(entry.err, errExecutionPipelineSkipped) {
+		executionPipelineMissCounter.Inc(1)
+		return s.getMessageWithMetadataAndBlockHash(pos)
+	}
+	executionPipelineHitCounter.Inc(1)
+	return entry.msg, entry.err
+}
+
+// messageForPrefetch returns the message at pos for the execution client to prefetch
+func (s *TransactionStreamer) messageForPrefetch(pos arbutil.MessageIndex) (*arbostypes.MessageWithMetadata, error) {
+	if msg := s.executionPipeline.peek(pos); msg != nil {
+		return &msg.MessageWithMeta, nil
+	}
+	return s.GetMessage(pos)
+}
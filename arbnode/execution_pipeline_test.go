@@ -0,0 +1,166 @@
+package arbnode
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+
+	"github.com/offchainlabs/nitro/arbos/arbostypes"
+	"github.com/offchainlabs/nitro/arbutil"
+)
+
+// gatedDatabase blocks the reads of key until gate is closed, signaling reading when one starts
+type gatedDatabase struct {
+	ethdb.Database
+	key     []byte
+	reading chan struct{}
+	gate    chan struct{}
+}
+
+func newGatedDatabase(db ethdb.Database, key []byte) *gatedDatabase {
+	return &gatedDatabase{Database: db, key: key, reading: make(chan struct{}, 1), gate: make(chan struct{})}
+}
+
+func (d *gatedDatabase) Get(key []byte) ([]byte, error) {
+	if bytes.Equal(key, d.key) {
+		select {
+		case d.reading <- struct{}{}:
+		default:
+		}
+		<-d.gate
+	}
+	return d.Database.Get(key)
+}
+
+func TestExecutionPipeline(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	streamer := newTestImportStreamer(t)
+	config := streamer.config()
+	config.ExecutionPipeline = ExecutionPipelineConfig{Lookahead: 3, Workers: 2}
+	// Messages are read back from the database
+	config.RecentMessageCacheSize = 0
+	streamer.recentMessages = newRecentMessageCache(0)
+	var messages []arbostypes.MessageWithMetadata
+	for i := uint64(0); i < 10; i++ {
+		messages = append(messages, arbostypes.MessageWithMetadata{
+			Message: &arbostypes.L1IncomingMessage{Header: &arbostypes.L1IncomingMessageHeader{Timestamp: i}},
+		})
+	}
+	Require(t, streamer.AddMessages(0, true, messages))
+	streamer.StopWaiter.Start(ctx, streamer)
+	defer streamer.StopWaiter.StopAndWait()
+
+	next := func(pos arbutil.MessageIndex) {
+		t.Helper()
+		msg, err := streamer.nextMessageToExecute(ctx, pos, 10)
+		Require(t, err)
+		if msg.MessageWithMeta.Message.Header.Timestamp != uint64(pos) {
+			Fail(t, "unexpected message at", pos, "got", msg.MessageWithMeta.Message.Header.Timestamp)
+		}
+	}
+	hits := executionPipelineHitCounter.Snapshot().Count()
+	misses := executionPipelineMissCounter.Snapshot().Count()
+	for pos := arbutil.MessageIndex(0); pos < 10; pos++ {
+		next(pos)
+	}
+	if executionPipelineMissCounter.Snapshot().Count()-misses != 1 {
+		Fail(t, "only the first message should have been read when executed")
+	}
+	if executionPipelineHitCounter.Snapshot().Count()-hits != 9 {
+		Fail(t, "the following messages should have been prepared in the background")
+	}
+
+	// The prepared messages are dropped on a reorg
+	next(2)
+	streamer.executionPipeline.reset()
+	misses = executionPipelineMissCounter.Snapshot().Count()
+	next(3)
+	if executionPipelineMissCounter.Snapshot().Count()-misses != 1 {
+		Fail(t, "message prepared before the reset was used")
+	}
+	msg, err := streamer.nextMessageToExecute(ctx, 4, 10)
+	Require(t, err)
+	prefetch, err := streamer.messageForPrefetch(5)
+	Require(t, err)
+	if prefetch.Message.Header.Timestamp != 5 || msg.MessageWithMeta.Message.Header.Timestamp != 4 {
+		Fail(t, "unexpected messages", msg.MessageWithMeta.Message.Header.Timestamp, prefetch.Message.Header.Timestamp)
+	}
+}
+
+func TestExecutionPipelineReorgDuringPrefetch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	streamer := newTestImportStreamer(t)
+	config := streamer.config()
+	config.ExecutionPipeline = ExecutionPipelineConfig{Lookahead: 1, Workers: 1}
+	config.RecentMessageCacheSize = 0
+	streamer.recentMessages = newRecentMessageCache(0)
+	Require(t, streamer.AddMessages(0, true, testChainMessages(0, 6)))
+	streamer.StopWaiter.Start(ctx, streamer)
+	defer streamer.StopWaiter.StopAndWait()
+	db := streamer.db
+	// prefetch executes pos, and returns the entry of the following message once it's being read
+	prefetch := func(pos arbutil.MessageIndex) (*gatedDatabase, *prefetchedMessage) {
+		t.Helper()
+		gated := newGatedDatabase(db, dbKey(messagePrefix, uint64(pos+1)))
+		streamer.db = gated
+		_, err := streamer.nextMessageToExecute(ctx, pos, 6)
+		Require(t, err)
+		<-gated.reading
+		streamer.executionPipeline.mutex.Lock()
+		defer streamer.executionPipeline.mutex.Unlock()
+		return gated, streamer.executionPipeline.entries[pos+1]
+	}
+
+	// A message read while the pipeline is reset is discarded
+	gated, entry := prefetch(0)
+	streamer.executionPipeline.reset()
+	close(gated.gate)
+	<-entry.done
+	if entry.msg != nil || !errors.Is(entry.err, errExecutionPipelineSkipped) {
+		Fail(t, "message read before the reset published", entry.msg, entry.err)
+	}
+
+	// A reorg waits for the message being read
+	gated, entry = prefetch(2)
+	reorged := make(chan struct{})
+	go func() {
+		streamer.reorgMutex.Lock()
+		streamer.executionPipeline.reset()
+		streamer.reorgMutex.Unlock()
+		close(reorged)
+	}()
+	select {
+	case <-reorged:
+		Fail(t, "reorg didn't wait for the message being read")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(gated.gate)
+	<-reorged
+	<-entry.done
+	if entry.err != nil || entry.msg.MessageWithMeta.Message.Header.Timestamp != 3 {
+		Fail(t, "unexpected message read before the reorg", entry.msg, entry.err)
+	}
+	// The message is read again once it's executed after the reorg
+	misses := executionPipelineMissCounter.Snapshot().Count()
+	msg, err := streamer.nextMessageToExecute(ctx, 3, 6)
+	Require(t, err)
+	if msg.MessageWithMeta.Message.Header.Timestamp != 3 || executionPipelineMissCounter.Snapshot().Count()-misses != 1 {
+		Fail(t, "message prepared before the reorg used")
+	}
+
+	// A worker gives way to a pending reorg instead of waiting for it
+	streamer.db = db
+	streamer.reorgMutex.Lock()
+	entry = &prefetchedMessage{pos: 5, done: make(chan struct{})}
+	streamer.executionPipeline.prepare(streamer, entry)
+	streamer.reorgMutex.Unlock()
+	if !errors.Is(entry.err, errExecutionPipelineSkipped) {
+		Fail(t, "message read while a reorg was pending", entry.err)
+	}
+}
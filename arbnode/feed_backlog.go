@@ -0,0 +1,125 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package arbnode
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+	flag "github.com/spf13/pflag"
+
+	"github.com/offchainlabs/nitro/arbos/arbostypes"
+	"github.com/offchainlabs/nitro/arbutil"
+	m "github.com/offchainlabs/nitro/broadcaster/message"
+)
+
+const (
+	// The messages of the last batches, as tracked by the inbox tracker
+	FeedBacklogSourceInboxTracker = "inbox-tracker"
+	// The last messages stored by the streamer, including the ones sequenced through espresso that aren't in a
+	// batch yet
+	FeedBacklogSourceStreamer = "streamer"
+)
+
+// FeedBacklogConfig configures which messages the feed backlog is populated with on startup and when the
+// sequencer acquires the lockout
+type FeedBacklogConfig struct {
+	Source   string        `koanf:"source" reload:"hot"`
+	Messages uint64        `koanf:"messages" reload:"hot"`
+	MaxAge   time.Duration `koanf:"max-age" reload:"hot"`
+}
+
+var DefaultFeedBacklogConfig = FeedBacklogConfig{
+	Source:   FeedBacklogSourceInboxTracker,
+	Messages: 10_000,
+	MaxAge:   0,
+}
+
+func FeedBacklogConfigAddOptions(prefix string, f *flag.FlagSet) {
+	f.String(prefix+".source", DefaultFeedBacklogConfig.Source, "where the feed backlog is populated from: \""+FeedBacklogSourceInboxTracker+"\" for the messages of the last batches, or \""+FeedBacklogSourceStreamer+"\" for the last messages stored by the streamer, including the ones not posted in a batch yet")
+	f.Uint64(prefix+".messages", DefaultFeedBacklogConfig.Messages, "maximum number of the last messages the feed backlog is populated with when populated from the streamer")
+	f.Duration(prefix+".max-age", DefaultFeedBacklogConfig.MaxAge, "maximum age of the messages the feed backlog is populated with when populated from the streamer, by their header timestamp (0 = no limit)")
+}
+
+func (c *FeedBacklogConfig) Validate() error {
+	switch c.Source {
+	case "", FeedBacklogSourceInboxTracker:
+	case FeedBacklogSourceStreamer:
+		if c.Messages == 0 {
+			return fmt.Errorf("feed backlog messages must be positive with the %q source", FeedBacklogSourceStreamer)
+		}
+	default:
+		return fmt.Errorf("invalid feed backlog source %q, expected %q or %q", c.Source, FeedBacklogSourceInboxTracker, FeedBacklogSourceStreamer)
+	}
+	return nil
+}
+
+// PopulateFeedBacklog broadcasts the recent messages, so that the feed backlog holds them for clients connecting
+// before new messages are sequenced. Without an inbox reader they're always read from the streamer.
+func (s *TransactionStreamer) PopulateFeedBacklog() error {
+	if s.broadcastServer == nil {
+		return nil
+	}
+	config := s.config().FeedBacklog
+	if config.Source == FeedBacklogSourceStreamer || s.inboxReader == nil {
+		return s.populateFeedBacklogFromStreamer(&config, time.Now())
+	}
+	return s.inboxReader.tracker.PopulateFeedBacklog(s.broadcastServer)
+}
+
+// feedBacklogMessages returns the position of the first message the feed backlog is populated with and the
+// messages from it, at most config.Messages of them, no older than config.MaxAge at now
+func (s *TransactionStreamer) feedBacklogMessages(config *FeedBacklogConfig, now time.Time) (arbutil.MessageIndex, []*arbostypes.MessageWithMetadata, error) {
+	count, err := s.GetMessageCount()
+	if err != nil {
+		return 0, nil, err
+	}
+	start := count - arbutil.MessageIndex(min(uint64(count), config.Messages))
+	messages, err := s.GetMessages(start, count)
+	if err != nil {
+		return 0, nil, fmt.Errorf("error getting messages from %v: %w", start, err)
+	}
+	if config.MaxAge > 0 {
+		// #nosec G115
+		cutoff := uint64(now.Add(-config.MaxAge).Unix())
+		for len(messages) > 0 && messages[0].Message.Header.Timestamp < cutoff {
+			messages = messages[1:]
+			start++
+		}
+	}
+	return start, messages, nil
+}
+
+// populateFeedBacklogFromStreamer populates the feed backlog from the messages stored by the streamer, along with
+// their block hashes and espresso justifications
+func (s *TransactionStreamer) populateFeedBacklogFromStreamer(config *FeedBacklogConfig, now time.Time) error {
+	start, messages, err := s.feedBacklogMessages(config, now)
+	if err != nil || len(messages) == 0 {
+		return err
+	}
+	withBlockHashes := make([]arbostypes.MessageWithMetadataAndBlockHash, 0, len(messages))
+	for i, message := range messages {
+		// #nosec G115
+		pos := start + arbutil.MessageIndex(i)
+		var blockHash *common.Hash
+		if msgResult, err := s.ResultAtCount(pos + 1); err == nil {
+			blockHash = &msgResult.BlockHash
+		}
+		withBlockHashes = append(withBlockHashes, arbostypes.MessageWithMetadataAndBlockHash{
+			MessageWithMeta: *message,
+			BlockHash:       blockHash,
+		})
+	}
+	var finality []*m.EspressoFinality
+	if s.broadcastServer.EmitsEspressoFinality() {
+		finality = s.espressoFinalityForFeed(start, len(messages))
+	}
+	if err := s.broadcastServer.BroadcastMessagesWithEspressoFinality(withBlockHashes, start, finality); err != nil {
+		return fmt.Errorf("error broadcasting the feed backlog from %v: %w", start, err)
+	}
+	log.Info("populated the feed backlog from the streamer", "start", start, "messages", len(messages))
+	return nil
+}
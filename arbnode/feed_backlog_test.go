@@ -0,0 +1,41 @@
+package arbnode
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFeedBacklogMessages(t *testing.T) {
+	streamer := newTestImportStreamer(t)
+	Require(t, streamer.AddMessages(0, true, testChainMessages(0, 10)))
+	config := FeedBacklogConfig{Source: FeedBacklogSourceStreamer, Messages: 4}
+	Require(t, config.Validate())
+
+	start, messages, err := streamer.feedBacklogMessages(&config, time.Unix(100, 0))
+	Require(t, err)
+	if start != 6 || len(messages) != 4 || messages[0].Message.Header.Timestamp != 6 {
+		Fail(t, "unexpected backlog limited by count", start, len(messages))
+	}
+
+	// Messages with timestamps 8 and 9 are at most 2 seconds old
+	config.MaxAge = 2 * time.Second
+	start, messages, err = streamer.feedBacklogMessages(&config, time.Unix(10, 0))
+	Require(t, err)
+	if start != 8 || len(messages) != 2 {
+		Fail(t, "unexpected backlog limited by age", start, len(messages))
+	}
+	config.Messages = 100
+	_, messages, err = streamer.feedBacklogMessages(&config, time.Unix(1000, 0))
+	Require(t, err)
+	if len(messages) != 0 {
+		Fail(t, "backlog holds messages older than the max age", len(messages))
+	}
+
+	config.Messages = 0
+	if config.Validate() == nil {
+		Fail(t, "empty backlog from the streamer accepted")
+	}
+	if (&FeedBacklogConfig{Source: "batches"}).Validate() == nil {
+		Fail(t, "invalid backlog source accepted")
+	}
+}
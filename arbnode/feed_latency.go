@@ -0,0 +1,68 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package arbnode
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/metrics"
+
+	"github.com/offchainlabs/nitro/arbutil"
+	"github.com/offchainlabs/nitro/util/containers"
+)
+
+var (
+	feedReceiveLatencyHistogram = metrics.NewRegisteredHistogram("arb/feed/latency/receive", nil, metrics.NewBoundedHistogramSample())
+	feedExecuteLatencyHistogram = metrics.NewRegisteredHistogram("arb/feed/latency/execute", nil, metrics.NewBoundedHistogramSample())
+)
+
+// Enough to cover the messages received but not yet executed by a replica that keeps up with the feed
+const feedLatencyTrackerSize = 10_000
+
+// feedLatencyTracker records, in milliseconds, how long after the sequencer broadcast a message
+// it was received from the feed and executed. Only messages carrying a sequencer timestamp are measured.
+type feedLatencyTracker struct {
+	mutex       sync.Mutex
+	sequencedAt *containers.LruCache[arbutil.MessageIndex, time.Time]
+}
+
+func newFeedLatencyTracker() *feedLatencyTracker {
+	return &feedLatencyTracker{
+		sequencedAt: containers.NewLruCache[arbutil.MessageIndex, time.Time](feedLatencyTrackerSize),
+	}
+}
+
+func (t *feedLatencyTracker) received(pos arbutil.MessageIndex, sequencedAtMillis uint64) {
+	if t == nil {
+		return
+	}
+	// #nosec G115
+	sequencedAt := time.UnixMilli(int64(sequencedAtMillis))
+	// A negative latency means the clocks are out of sync, so it can't be measured
+	if latency := time.Since(sequencedAt); latency >= 0 {
+		feedReceiveLatencyHistogram.Update(latency.Milliseconds())
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.sequencedAt.Add(pos, sequencedAt)
+}
+
+func (t *feedLatencyTracker) executed(pos arbutil.MessageIndex) {
+	if t == nil {
+		return
+	}
+	t.mutex.Lock()
+	sequencedAt, ok := t.sequencedAt.Get(pos)
+	if ok {
+		t.sequencedAt.Remove(pos)
+	}
+	t.mutex.Unlock()
+	if !ok {
+		return
+	}
+	if latency := time.Since(sequencedAt); latency >= 0 {
+		feedExecuteLatencyHistogram.Update(latency.Milliseconds())
+	}
+}
@@ -0,0 +1,30 @@
+package arbnode
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFeedLatencyTracker(t *testing.T) {
+	tracker := newFeedLatencyTracker()
+	receivedBefore := feedReceiveLatencyHistogram.Snapshot().Count()
+	executedBefore := feedExecuteLatencyHistogram.Snapshot().Count()
+
+	// #nosec G115
+	sequencedAt := uint64(time.Now().Add(-time.Second).UnixMilli())
+	tracker.received(5, sequencedAt)
+	tracker.executed(5)
+	// Executing again, or executing a message without a sequencer timestamp, isn't measured
+	tracker.executed(5)
+	tracker.executed(6)
+
+	if received := feedReceiveLatencyHistogram.Snapshot().Count() - receivedBefore; received != 1 {
+		Fail(t, "expected 1 receive latency sample, got", received)
+	}
+	if executed := feedExecuteLatencyHistogram.Snapshot().Count() - executedBefore; executed != 1 {
+		Fail(t, "expected 1 execute latency sample, got", executed)
+	}
+	if maxLatency := feedExecuteLatencyHistogram.Snapshot().Max(); maxLatency < time.Second.Milliseconds() {
+		Fail(t, "execute latency should be at least a second, got", maxLatency)
+	}
+}
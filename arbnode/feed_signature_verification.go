@@ -0,0 +1,125 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package arbnode
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+	flag "github.com/spf13/pflag"
+
+	m "github.com/offchainlabs/nitro/broadcaster/message"
+	"github.com/offchainlabs/nitro/util/contracts"
+	"github.com/offchainlabs/nitro/util/signature"
+)
+
+var (
+	ErrFeedSignature = errors.New("feed message isn't signed by an allowed sequencer key")
+
+	feedSignatureVerifiedCounter = metrics.NewRegisteredCounter("arb/streamer/feed_signature/verified", nil)
+	feedSignatureRejectedCounter = metrics.NewRegisteredCounter("arb/streamer/feed_signature/rejected", nil)
+)
+
+// FeedSignatureConfig configures the verification of the sequencer signatures of feed messages before they're
+// stored, on top of the verification done by the feed clients, so that a replica fed from any source rejects spoofed
+// messages. It's fixed at startup.
+type FeedSignatureConfig struct {
+	Enable           bool     `koanf:"enable"`
+	AllowedAddresses []string `koanf:"allowed-addresses"`
+	// Also accept the batch posters and sequencers registered in the sequencer inbox contract
+	AcceptSequencer bool `koanf:"accept-sequencer"`
+}
+
+var DefaultFeedSignatureConfig = FeedSignatureConfig{
+	Enable:           false,
+	AllowedAddresses: []string{},
+	AcceptSequencer:  true,
+}
+
+func FeedSignatureConfigAddOptions(prefix string, f *flag.FlagSet) {
+	f.Bool(prefix+".enable", DefaultFeedSignatureConfig.Enable, "verify the sequencer signature of every feed message before storing it, rejecting unsigned messages and messages signed by another key")
+	f.StringSlice(prefix+".allowed-addresses", DefaultFeedSignatureConfig.AllowedAddresses, "addresses of the sequencer keys feed messages may be signed by")
+	f.Bool(prefix+".accept-sequencer", DefaultFeedSignatureConfig.AcceptSequencer, "also accept feed messages signed by a batch poster or sequencer registered in the sequencer inbox contract on the parent chain")
+}
+
+func (c *FeedSignatureConfig) Validate() error {
+	if !c.Enable {
+		return nil
+	}
+	if len(c.AllowedAddresses) == 0 && !c.AcceptSequencer {
+		return errors.New("feed signature verification is enabled without allowed addresses nor accepting the registered sequencers")
+	}
+	for _, address := range c.AllowedAddresses {
+		if !common.IsHexAddress(address) {
+			return fmt.Errorf("feed signature allowed address %q is not a valid address", address)
+		}
+	}
+	return nil
+}
+
+// SetFeedAddressVerifier sets the registry of the batch posters and sequencers accepted as feed message signers, it
+// must be called before Start
+func (s *TransactionStreamer) SetFeedAddressVerifier(verifier contracts.AddressVerifierInterface) {
+	if s.Started() {
+		panic("trying to set feed address verifier after start")
+	}
+	s.feedAddressVerifier = verifier
+}
+
+// initFeedSignatureVerifier sets up the verifier of the feed message signatures if the verification is enabled
+func (s *TransactionStreamer) initFeedSignatureVerifier() error {
+	config := &s.config().FeedSignature
+	if !config.Enable {
+		return nil
+	}
+	verifierConfig := signature.VerifierConfig{
+		AllowedAddresses: config.AllowedAddresses,
+		AcceptSequencer:  config.AcceptSequencer,
+	}
+	if s.feedAddressVerifier == nil && len(config.AllowedAddresses) > 0 {
+		// The registered sequencers can't be read, only the allowed addresses are accepted
+		verifierConfig.AcceptSequencer = false
+	}
+	verifier, err := signature.NewVerifier(&verifierConfig, s.feedAddressVerifier)
+	if err != nil {
+		return fmt.Errorf("feed signature verification: %w", err)
+	}
+	s.feedSignatureVerifier = verifier
+	return nil
+}
+
+// verifyFeedSignatures checks the sequencer signature of every feed message, the messages are only accepted if all
+// of them are signed by an allowed key
+func (s *TransactionStreamer) verifyFeedSignatures(feedMessages []*m.BroadcastFeedMessage) error {
+	if !s.config().FeedSignature.Enable {
+		return nil
+	}
+	if s.feedSignatureVerifier == nil {
+		return errors.New("feed signature verification is enabled but the streamer isn't started")
+	}
+	ctx, err := s.GetContextSafe()
+	if err != nil {
+		return err
+	}
+	chainId := s.chainConfig.ChainID.Uint64()
+	for _, feedMessage := range feedMessages {
+		hash, err := feedMessage.Hash(chainId)
+		if err != nil {
+			return fmt.Errorf("feed message %d: %w", feedMessage.SequenceNumber, err)
+		}
+		if err := s.feedSignatureVerifier.VerifyHash(ctx, feedMessage.Signature, hash); err != nil {
+			feedSignatureRejectedCounter.Inc(1)
+			log.Warn("rejecting feed messages with an invalid signature", "pos", feedMessage.SequenceNumber, "err", err)
+			if errors.Is(err, signature.ErrSignatureNotVerified) {
+				return fmt.Errorf("feed message %d: %w: %w", feedMessage.SequenceNumber, ErrFeedSignature, err)
+			}
+			return fmt.Errorf("feed message %d: %w", feedMessage.SequenceNumber, err)
+		}
+		feedSignatureVerifiedCounter.Inc(1)
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package arbnode
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+
+	"github.com/offchainlabs/nitro/arbutil"
+	m "github.com/offchainlabs/nitro/broadcaster/message"
+)
+
+func TestFeedSignatureVerification(t *testing.T) {
+	sequencerKey, err := crypto.GenerateKey()
+	Require(t, err)
+	otherKey, err := crypto.GenerateKey()
+	Require(t, err)
+	streamer := newTestStreamer(t, nil, func(config *TransactionStreamerConfig) {
+		config.FeedSignature = FeedSignatureConfig{
+			Enable:           true,
+			AllowedAddresses: []string{crypto.PubkeyToAddress(sequencerKey.PublicKey).Hex()},
+		}
+	})
+	Require(t, streamer.config().FeedSignature.Validate())
+	streamer.StopWaiter.Start(context.Background(), streamer)
+	defer streamer.StopWaiter.StopAndWait()
+	Require(t, streamer.initFeedSignatureVerifier())
+
+	messages := testChainMessages(0, 2)
+	feedMessage := func(pos arbutil.MessageIndex, signer func([]byte) ([]byte, error)) *m.BroadcastFeedMessage {
+		t.Helper()
+		feedMessage := &m.BroadcastFeedMessage{SequenceNumber: pos, Message: messages[pos]}
+		if signer != nil {
+			hash, err := feedMessage.Hash(streamer.chainConfig.ChainID.Uint64())
+			Require(t, err)
+			feedMessage.Signature, err = signer(hash.Bytes())
+			Require(t, err)
+		}
+		return feedMessage
+	}
+	sequencerSigner := func(hash []byte) ([]byte, error) { return crypto.Sign(hash, sequencerKey) }
+	otherSigner := func(hash []byte) ([]byte, error) { return crypto.Sign(hash, otherKey) }
+
+	Require(t, streamer.verifyFeedSignatures([]*m.BroadcastFeedMessage{feedMessage(0, sequencerSigner), feedMessage(1, sequencerSigner)}))
+	for _, feedMessages := range [][]*m.BroadcastFeedMessage{
+		{feedMessage(0, sequencerSigner), feedMessage(1, nil)},
+		{feedMessage(0, otherSigner)},
+	} {
+		if err := streamer.verifyFeedSignatures(feedMessages); !errors.Is(err, ErrFeedSignature) {
+			Fail(t, "expected ErrFeedSignature, got", err)
+		}
+	}
+	// A signature over another position doesn't verify
+	moved := feedMessage(0, sequencerSigner)
+	moved.SequenceNumber = 1
+	if err := streamer.verifyFeedSignatures([]*m.BroadcastFeedMessage{moved}); !errors.Is(err, ErrFeedSignature) {
+		Fail(t, "expected ErrFeedSignature for a replayed signature, got", err)
+	}
+
+	if err := streamer.AddBroadcastMessages([]*m.BroadcastFeedMessage{feedMessage(0, otherSigner)}); !errors.Is(err, ErrFeedSignature) {
+		Fail(t, "expected ErrFeedSignature adding feed messages, got", err)
+	}
+	count, err := streamer.GetMessageCount()
+	Require(t, err)
+	if count != 0 {
+		Fail(t, "spoofed feed message stored", count)
+	}
+}
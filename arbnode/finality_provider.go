@@ -0,0 +1,144 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package arbnode
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	espressoTypes "github.com/EspressoSystems/espresso-sequencer-go/types"
+)
+
+var (
+	// ErrFinalityTransactionNotFound is returned by FinalityProvider.Finality while the ordering layer doesn't
+	// know the submitted transaction, which is resubmitted after the resubmission timeout
+	ErrFinalityTransactionNotFound = errors.New("submitted transaction not found")
+	// ErrFinalityPayloadMismatch is returned by FinalityProvider.Finality if the finalized transaction doesn't
+	// contain the submitted payload, its messages are queued for submission again
+	ErrFinalityPayloadMismatch = errors.New("finalized transaction doesn't contain the submitted payload")
+)
+
+// Finality describes where a submitted transaction was finalized
+type Finality struct {
+	// Height of the block of the ordering layer the transaction was finalized in
+	Height uint64
+	// Proof of the finality stored with the transaction's messages, nil if the provider has none
+	Justification *EspressoJustification
+}
+
+// FinalityProvider is the ordering layer the streamer submits payloads to and awaits their finality from.
+// The streamer persists the submission, the pending queue and the finalized state itself, so a provider
+// only needs to implement the exchange with the ordering layer. Transactions are identified by tagged base64
+// hashes, providers of other ordering layers can wrap their hashes with tagged_base64.New.
+// All methods are called from the espresso submission loop.
+type FinalityProvider interface {
+	// TransactionHash returns the hash Submit will return for payload, so that the submission can be persisted
+	// before it's sent
+	TransactionHash(payload []byte) (*espressoTypes.TaggedBase64, error)
+	// Submit sends payload to the ordering layer and returns the hash of the transaction
+	Submit(ctx context.Context, payload []byte) (*espressoTypes.TaggedBase64, error)
+	// FindTransaction returns nil if the ordering layer knows the transaction, whether it's finalized or not
+	FindTransaction(ctx context.Context, hash *espressoTypes.TaggedBase64) error
+	// Finality returns where the transaction with hash and payload was finalized. It returns nil without an error
+	// while there's nothing new to check, an error wrapping ErrFinalityTransactionNotFound while the transaction
+	// isn't known, and an error wrapping ErrFinalityPayloadMismatch if the finalized transaction has another payload.
+	Finality(ctx context.Context, hash *espressoTypes.TaggedBase64, payload []byte) (*Finality, error)
+}
+
+// hotShotFinalityProvider is the default provider, which submits transactions to the chain's namespace on HotShot
+type hotShotFinalityProvider struct {
+	streamer *TransactionStreamer
+}
+
+var _ FinalityProvider = hotShotFinalityProvider{}
+
+func (p hotShotFinalityProvider) transaction(payload []byte) espressoTypes.Transaction {
+	return espressoTypes.Transaction{
+		Payload:   payload,
+		Namespace: p.streamer.chainConfig.ChainID.Uint64(),
+	}
+}
+
+func (p hotShotFinalityProvider) TransactionHash(payload []byte) (*espressoTypes.TaggedBase64, error) {
+	tx := p.transaction(payload)
+	return espressoTransactionHash(&tx)
+}
+
+func (p hotShotFinalityProvider) Submit(ctx context.Context, payload []byte) (*espressoTypes.TaggedBase64, error) {
+	// Note: same key should not be used for two namespaces for this to work
+	return p.streamer.espressoClient.SubmitTransaction(ctx, p.transaction(payload))
+}
+
+func (p hotShotFinalityProvider) FindTransaction(ctx context.Context, hash *espressoTypes.TaggedBase64) error {
+	_, err := p.streamer.espressoClient.FetchTransactionByHash(ctx, hash)
+	return err
+}
+
+func (p hotShotFinalityProvider) Finality(ctx context.Context, hash *espressoTypes.TaggedBase64, payload []byte) (*Finality, error) {
+	s := p.streamer
+	var streamHeight uint64
+	if s.espressoHeaderStream != nil && s.espressoHeaderStream.Connected() {
+		streamHeight = s.espressoHeaderStream.LatestHeight()
+		if streamHeight == s.espressoPolledStreamHeight {
+			// No new hotshot block since the transaction was last looked up
+			return nil, nil
+		}
+	}
+
+	data, err := s.espressoClient.FetchTransactionByHash(ctx, hash)
+	if err != nil {
+		s.espressoPolledStreamHeight = streamHeight
+		return nil, fmt.Errorf("%w (hash: %s): %w", ErrFinalityTransactionNotFound, hash.String(), err)
+	}
+
+	height := data.BlockHeight
+	namespace := s.chainConfig.ChainID.Uint64()
+	// In a large namespace, the transaction's own proof spares fetching every transaction of the namespace
+	header, err := s.fetchEspressoHeader(ctx, height)
+	if err != nil {
+		return nil, fmt.Errorf("could not get the header (height: %d): %w", height, err)
+	}
+	if s.usePartialEspressoProof(namespace, header, data) {
+		return s.partialEspressoFinality(ctx, namespace, header, data, payload)
+	}
+	espressoFullNamespaceFetchCounter.Inc(1)
+	blocks, err := s.fetchEspressoBlocks(ctx, []uint64{height}, namespace)
+	if err != nil {
+		return nil, fmt.Errorf("could not get the block (height: %d): %w", height, err)
+	}
+	header = blocks[0].Header
+
+	// Verify the namespace proof against the header before anything is derived from the block
+	if err := s.verifyEspressoNamespace(height, namespace, blocks[0]); err != nil {
+		return nil, err
+	}
+
+	// Verify the merkle proof of the header
+	justification, err := s.fetchEspressoJustification(ctx, height, header)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only a proven block can show that the transaction has another payload
+	if !validateIfPayloadIsInBlock(payload, blocks[0].Namespace.Transactions) {
+		return nil, fmt.Errorf("%w (height: %d)", ErrFinalityPayloadMismatch, height)
+	}
+	return &Finality{Height: height, Justification: justification}, nil
+}
+
+// SetFinalityProvider replaces HotShot as the ordering layer messages are submitted to, it must be called before Start
+func (s *TransactionStreamer) SetFinalityProvider(provider FinalityProvider) {
+	if s.Started() {
+		panic("trying to set finality provider after start")
+	}
+	s.finalityProvider = provider
+}
+
+func (s *TransactionStreamer) finality() FinalityProvider {
+	if s.finalityProvider == nil {
+		return hotShotFinalityProvider{streamer: s}
+	}
+	return s.finalityProvider
+}
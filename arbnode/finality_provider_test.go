@@ -0,0 +1,96 @@
+package arbnode
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	espressoTypes "github.com/EspressoSystems/espresso-sequencer-go/types"
+
+	"github.com/offchainlabs/nitro/arbutil"
+)
+
+type testFinalityProvider struct {
+	finality *Finality
+	err      error
+	submits  int
+}
+
+func (p *testFinalityProvider) TransactionHash(payload []byte) (*espressoTypes.TaggedBase64, error) {
+	tx := espressoTypes.Transaction{Payload: payload, Namespace: 1}
+	return espressoTransactionHash(&tx)
+}
+
+func (p *testFinalityProvider) Submit(ctx context.Context, payload []byte) (*espressoTypes.TaggedBase64, error) {
+	p.submits++
+	return p.TransactionHash(payload)
+}
+
+func (p *testFinalityProvider) FindTransaction(ctx context.Context, hash *espressoTypes.TaggedBase64) error {
+	return nil
+}
+
+func (p *testFinalityProvider) Finality(ctx context.Context, hash *espressoTypes.TaggedBase64, payload []byte) (*Finality, error) {
+	return p.finality, p.err
+}
+
+func TestFinalityProvider(t *testing.T) {
+	ctx := context.Background()
+	streamer := newTestImportStreamer(t)
+	provider := &testFinalityProvider{}
+	streamer.SetFinalityProvider(provider)
+
+	submitted := []arbutil.MessageIndex{3, 4}
+	submit := func() {
+		payload := []byte("payload")
+		hash, err := provider.TransactionHash(payload)
+		Require(t, err)
+		batch := streamer.db.NewBatch()
+		Require(t, streamer.setEspressoSubmittedPos(batch, submitted))
+		Require(t, streamer.setEspressoSubmittedHash(batch, hash))
+		Require(t, streamer.setEspressoSubmittedPayload(batch, payload))
+		Require(t, streamer.setEspressoSubmissionStatus(batch, submitted, EspressoSubmissionSubmitted, hash))
+		Require(t, batch.Write())
+	}
+
+	// A payload mismatch queues the messages for submission again
+	submit()
+	provider.err = ErrFinalityPayloadMismatch
+	if err := streamer.pollSubmittedTransactionForFinality(ctx); err == nil {
+		Fail(t, "payload mismatch not reported")
+	}
+	pending, err := streamer.getEspressoPendingTxnsPos()
+	Require(t, err)
+	if !reflect.DeepEqual(pending, submitted) {
+		Fail(t, "unexpected pending positions", pending)
+	}
+
+	// Nothing is updated while the provider has nothing new
+	Require(t, streamer.setEspressoPendingTxnsPos(streamer.db, nil))
+	submit()
+	provider.err = nil
+	Require(t, streamer.pollSubmittedTransactionForFinality(ctx))
+	positions, err := streamer.getEspressoSubmittedPos()
+	Require(t, err)
+	if !reflect.DeepEqual(positions, submitted) {
+		Fail(t, "submission cleared without finality", positions)
+	}
+
+	provider.finality = &Finality{Height: 7}
+	Require(t, streamer.pollSubmittedTransactionForFinality(ctx))
+	lastConfirmed, err := streamer.getLastConfirmedPos()
+	Require(t, err)
+	if lastConfirmed == nil || *lastConfirmed != 4 {
+		Fail(t, "unexpected last confirmed position", lastConfirmed)
+	}
+	height, err := streamer.getEspressoLastFinalizedHeight()
+	Require(t, err)
+	if height == nil || *height != 7 {
+		Fail(t, "unexpected last finalized height", height)
+	}
+	record, err := streamer.GetEspressoSubmissionRecord(4)
+	Require(t, err)
+	if record.Status != EspressoSubmissionFinalized {
+		Fail(t, "unexpected submission status", record.Status)
+	}
+}
@@ -14,6 +14,7 @@ import (
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/event"
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/metrics"
 	"github.com/ethereum/go-ethereum/rlp"
@@ -31,6 +32,8 @@ import (
 var (
 	inboxLatestBatchGauge        = metrics.NewRegisteredGauge("arb/inbox/latest/batch", nil)
 	inboxLatestBatchMessageGauge = metrics.NewRegisteredGauge("arb/inbox/latest/batch/message", nil)
+
+	delayedMessageSubscriberDroppedCounter = metrics.NewRegisteredCounter("arb/inbox/delayed/subscribers/dropped", nil)
 )
 
 type InboxTracker struct {
@@ -43,6 +46,28 @@ type InboxTracker struct {
 
 	batchMetaMutex sync.Mutex
 	batchMeta      *containers.LruCache[uint64, BatchMetadata]
+
+	delayedMessageSubsMutex sync.Mutex
+	delayedMessageSubs      map[*delayedMessageSubscriber]struct{}
+}
+
+// ErrDelayedMessageSubscriberTooSlow is returned on the subscription of a subscriber that was dropped because its
+// channel was full when a delayed message was sent
+var ErrDelayedMessageSubscriberTooSlow = errors.New("delayed message subscriber dropped, its channel was full")
+
+type delayedMessageSubscriber struct {
+	ch      chan<- DelayedMessageEvent
+	dropped chan struct{}
+}
+
+// DelayedMessageEvent is sent to subscribers when a new delayed message is read from the parent chain.
+type DelayedMessageEvent struct {
+	SeqNum                 uint64         `json:"seqNum"`
+	Kind                   uint8          `json:"kind"`
+	Sender                 common.Address `json:"sender"`
+	BlockNumber            uint64         `json:"blockNumber"`
+	ParentChainBlockNumber uint64         `json:"parentChainBlockNumber"`
+	Timestamp              uint64         `json:"timestamp"`
 }
 
 func NewInboxTracker(db ethdb.Database, txStreamer *TransactionStreamer, dapReaders []daprovider.Reader, snapSyncConfig SnapSyncConfig) (*InboxTracker, error) {
@@ -293,12 +318,17 @@ func (t *InboxTracker) PopulateFeedBacklog(broadcastServer *broadcaster.Broadcas
 	if err != nil {
 		return fmt.Errorf("error getting tx streamer message count: %w", err)
 	}
-	var feedMessages []*m.BroadcastFeedMessage
-	for seqNum := startMessage; seqNum < messageCount; seqNum++ {
-		message, err := t.txStreamer.GetMessage(seqNum)
+	var messages []*arbostypes.MessageWithMetadata
+	if startMessage < messageCount {
+		messages, err = t.txStreamer.GetMessages(startMessage, messageCount)
 		if err != nil {
-			return fmt.Errorf("error getting message %v: %w", seqNum, err)
+			return fmt.Errorf("error getting messages from %v: %w", startMessage, err)
 		}
+	}
+	var feedMessages []*m.BroadcastFeedMessage
+	for i, message := range messages {
+		// #nosec G115
+		seqNum := startMessage + arbutil.MessageIndex(i)
 
 		msgResult, err := t.txStreamer.ResultAtCount(seqNum + 1)
 		var blockHash *common.Hash
@@ -505,7 +535,74 @@ func (t *InboxTracker) AddDelayedMessages(messages []*DelayedInboxMessage, hardR
 		pos++
 	}
 
-	return t.setDelayedCountReorgAndWriteBatch(batch, pos, true)
+	err = t.setDelayedCountReorgAndWriteBatch(batch, pos, true)
+	if err != nil {
+		return err
+	}
+	t.notifyDelayedMessages(messages)
+	return nil
+}
+
+// notifyDelayedMessages sends the events of the new delayed messages without blocking the inbox reader: a
+// subscriber whose channel is full is dropped
+func (t *InboxTracker) notifyDelayedMessages(messages []*DelayedInboxMessage) {
+	t.delayedMessageSubsMutex.Lock()
+	defer t.delayedMessageSubsMutex.Unlock()
+	if len(t.delayedMessageSubs) == 0 {
+		return
+	}
+	for _, message := range messages {
+		header := message.Message.Header
+		seqNum, err := header.SeqNum()
+		if err != nil {
+			continue
+		}
+		ev := DelayedMessageEvent{
+			SeqNum:                 seqNum,
+			Kind:                   header.Kind,
+			Sender:                 header.Poster,
+			BlockNumber:            header.BlockNumber,
+			ParentChainBlockNumber: message.ParentChainBlockNumber,
+			Timestamp:              header.Timestamp,
+		}
+		for sub := range t.delayedMessageSubs {
+			select {
+			case sub.ch <- ev:
+			default:
+				log.Warn("dropping a delayed message subscriber, its channel is full", "seqNum", seqNum)
+				delayedMessageSubscriberDroppedCounter.Inc(1)
+				delete(t.delayedMessageSubs, sub)
+				close(sub.dropped)
+			}
+		}
+	}
+}
+
+// SubscribeDelayedMessages registers a channel that receives an event for every new delayed message.
+// Events are only sent after the messages are written to the database. Sending never blocks: if the
+// channel is full when an event is sent, the subscriber is dropped and the subscription fails with
+// ErrDelayedMessageSubscriberTooSlow.
+func (t *InboxTracker) SubscribeDelayedMessages(ch chan<- DelayedMessageEvent) event.Subscription {
+	sub := &delayedMessageSubscriber{ch: ch, dropped: make(chan struct{})}
+	t.delayedMessageSubsMutex.Lock()
+	if t.delayedMessageSubs == nil {
+		t.delayedMessageSubs = make(map[*delayedMessageSubscriber]struct{})
+	}
+	t.delayedMessageSubs[sub] = struct{}{}
+	t.delayedMessageSubsMutex.Unlock()
+	return event.NewSubscription(func(quit <-chan struct{}) error {
+		defer func() {
+			t.delayedMessageSubsMutex.Lock()
+			delete(t.delayedMessageSubs, sub)
+			t.delayedMessageSubsMutex.Unlock()
+		}()
+		select {
+		case <-quit:
+			return nil
+		case <-sub.dropped:
+			return ErrDelayedMessageSubscriberTooSlow
+		}
+	})
 }
 
 // All-in-one delayed message count adjuster. Can go forwards or backwards.
@@ -1,9 +1,15 @@
 package arbnode
 
 import (
+	"errors"
+	"math/big"
 	"testing"
+	"time"
 
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/rawdb"
+
+	"github.com/offchainlabs/nitro/arbos/arbostypes"
 	"github.com/offchainlabs/nitro/util/containers"
 )
 
@@ -56,3 +62,62 @@ func TestDeleteBatchMetadata(t *testing.T) {
 	}
 
 }
+
+func TestDelayedMessageSubscriberDropped(t *testing.T) {
+	tracker := &InboxTracker{}
+	delayedMessages := func(from, to uint64) []*DelayedInboxMessage {
+		var messages []*DelayedInboxMessage
+		for seqNum := from; seqNum < to; seqNum++ {
+			requestId := common.BigToHash(new(big.Int).SetUint64(seqNum))
+			messages = append(messages, &DelayedInboxMessage{
+				Message: &arbostypes.L1IncomingMessage{Header: &arbostypes.L1IncomingMessageHeader{RequestId: &requestId}},
+			})
+		}
+		return messages
+	}
+	fast := make(chan DelayedMessageEvent, 3)
+	fastSub := tracker.SubscribeDelayedMessages(fast)
+	defer fastSub.Unsubscribe()
+	slow := make(chan DelayedMessageEvent, 1)
+	slowSub := tracker.SubscribeDelayedMessages(slow)
+	defer slowSub.Unsubscribe()
+
+	// The slow subscriber doesn't hold back the inbox reader or the other subscribers
+	tracker.notifyDelayedMessages(delayedMessages(0, 3))
+	for seqNum := uint64(0); seqNum < 3; seqNum++ {
+		if ev := <-fast; ev.SeqNum != seqNum {
+			Fail(t, "unexpected delayed message event", ev.SeqNum, "expected", seqNum)
+		}
+	}
+	select {
+	case err := <-slowSub.Err():
+		if !errors.Is(err, ErrDelayedMessageSubscriberTooSlow) {
+			Fail(t, "unexpected error dropping the slow subscriber", err)
+		}
+	case <-time.After(5 * time.Second):
+		Fail(t, "slow subscriber not dropped")
+	}
+	if ev := <-slow; ev.SeqNum != 0 {
+		Fail(t, "unexpected delayed message event before the slow subscriber was dropped", ev.SeqNum)
+	}
+
+	// Only the remaining subscriber receives the next events
+	tracker.notifyDelayedMessages(delayedMessages(3, 4))
+	if ev := <-fast; ev.SeqNum != 3 {
+		Fail(t, "unexpected delayed message event", ev.SeqNum)
+	}
+	select {
+	case ev := <-slow:
+		Fail(t, "dropped subscriber received an event", ev.SeqNum)
+	default:
+	}
+
+	// An unsubscribed subscriber is removed
+	fastSub.Unsubscribe()
+	tracker.delayedMessageSubsMutex.Lock()
+	remaining := len(tracker.delayedMessageSubs)
+	tracker.delayedMessageSubsMutex.Unlock()
+	if remaining != 0 {
+		Fail(t, "subscribers left after unsubscribing", remaining)
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package arbnode
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/rlp"
+
+	"github.com/offchainlabs/nitro/arbutil"
+	m "github.com/offchainlabs/nitro/broadcaster/message"
+	"github.com/offchainlabs/nitro/execution"
+	"github.com/offchainlabs/nitro/util/dbutil"
+)
+
+var (
+	ErrKillSwitchEngaged       = errors.New("sequencing is paused by the chain's kill switch")
+	ErrKillSwitchNotConfigured = errors.New("no kill switch owner is configured")
+	ErrKillSwitchSignature     = errors.New("kill switch message isn't signed by the kill switch owner")
+
+	killSwitchEngagedGauge    = metrics.NewRegisteredGauge("arb/streamer/kill_switch/engaged", nil)
+	killSwitchPositionGauge   = metrics.NewRegisteredGauge("arb/streamer/kill_switch/position", nil)
+	killSwitchRejectedCounter = metrics.NewRegisteredCounter("arb/streamer/kill_switch/rejected", nil)
+)
+
+// loadKillSwitch restores the last honored kill switch message, so that a restart doesn't release it
+func (s *TransactionStreamer) loadKillSwitch() error {
+	data, err := s.db.Get(killSwitchKey)
+	if err != nil {
+		if dbutil.IsErrNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	var killSwitch m.KillSwitchMessage
+	if err := rlp.DecodeBytes(data, &killSwitch); err != nil {
+		return err
+	}
+	s.setKillSwitch(&killSwitch)
+	if killSwitch.Engaged {
+		log.Warn("kill switch engaged, sequencing and espresso submission are paused", "position", killSwitch.Position, "nonce", killSwitch.Nonce)
+	}
+	return nil
+}
+
+func (s *TransactionStreamer) setKillSwitch(killSwitch *m.KillSwitchMessage) {
+	s.killSwitch.Store(killSwitch)
+	if killSwitch.Engaged {
+		killSwitchEngagedGauge.Update(1)
+	} else {
+		killSwitchEngagedGauge.Update(0)
+	}
+	// #nosec G115
+	killSwitchPositionGauge.Update(int64(killSwitch.Position))
+}
+
+// verifyKillSwitch checks that the message is signed by the configured kill switch owner
+func (s *TransactionStreamer) verifyKillSwitch(killSwitch *m.KillSwitchMessage) error {
+	owner := s.config().KillSwitchOwner
+	if owner == "" {
+		return ErrKillSwitchNotConfigured
+	}
+	if killSwitch.Nonce == 0 {
+		return errors.New("kill switch nonces start at 1")
+	}
+	pubkey, err := crypto.SigToPub(killSwitch.Hash(s.chainConfig.ChainID.Uint64()).Bytes(), killSwitch.Signature)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrKillSwitchSignature, err)
+	}
+	if signer := crypto.PubkeyToAddress(*pubkey); signer != common.HexToAddress(owner) {
+		return fmt.Errorf("%w (signer: %v)", ErrKillSwitchSignature, signer)
+	}
+	return nil
+}
+
+// AddKillSwitchMessage honors a kill switch message received from the feed or over RPC. Messages whose nonce isn't
+// above the nonce of the last honored message are ignored, as the feeds pass on the same message several times.
+// Honored messages are persisted and broadcast to the node's own feed, so they reach every replica.
+func (s *TransactionStreamer) AddKillSwitchMessage(killSwitch *m.KillSwitchMessage) error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	if err := s.verifyKillSwitch(killSwitch); err != nil {
+		killSwitchRejectedCounter.Inc(1)
+		return err
+	}
+	s.killSwitchMutex.Lock()
+	defer s.killSwitchMutex.Unlock()
+	if current := s.killSwitch.Load(); current != nil && killSwitch.Nonce <= current.Nonce {
+		log.Debug("ignoring kill switch message that was already honored", "nonce", killSwitch.Nonce, "lastNonce", current.Nonce)
+		return nil
+	}
+	data, err := rlp.EncodeToBytes(killSwitch)
+	if err != nil {
+		return err
+	}
+	if err := s.db.Put(killSwitchKey, data); err != nil {
+		return err
+	}
+	s.setKillSwitch(killSwitch)
+	if killSwitch.Engaged {
+		log.Error("kill switch engaged, pausing sequencing and espresso submission", "position", killSwitch.Position, "nonce", killSwitch.Nonce)
+	} else {
+		log.Warn("kill switch released, resuming sequencing and espresso submission", "nonce", killSwitch.Nonce)
+	}
+	if s.broadcastServer != nil {
+		s.broadcastServer.BroadcastKillSwitch(killSwitch)
+	}
+	return nil
+}
+
+// KillSwitch returns the last honored kill switch message, or nil if none was received
+func (s *TransactionStreamer) KillSwitch() *m.KillSwitchMessage {
+	return s.killSwitch.Load()
+}
+
+// killSwitchPosition returns the position from which sequencing and espresso submission are paused,
+// and false if the kill switch isn't engaged
+func (s *TransactionStreamer) killSwitchPosition() (arbutil.MessageIndex, bool) {
+	killSwitch := s.killSwitch.Load()
+	if killSwitch == nil || !killSwitch.Engaged {
+		return 0, false
+	}
+	return killSwitch.Position, true
+}
+
+// expectKillSwitchReleased returns an error asking the sequencer to retry while the kill switch is engaged at or
+// before pos. Messages before the kill switch's position are still sequenced and executed.
+func (s *TransactionStreamer) expectKillSwitchReleased(pos arbutil.MessageIndex) error {
+	position, engaged := s.killSwitchPosition()
+	if engaged && pos >= position {
+		return fmt.Errorf("%w: %w (position %d)", execution.ErrRetrySequencer, ErrKillSwitchEngaged, position)
+	}
+	return nil
+}
+
+// beforeKillSwitch returns the leading positions of the pending queue that are before an engaged kill switch
+func (s *TransactionStreamer) beforeKillSwitch(positions []arbutil.MessageIndex) []arbutil.MessageIndex {
+	position, engaged := s.killSwitchPosition()
+	if !engaged {
+		return positions
+	}
+	for i, pos := range positions {
+		if pos >= position {
+			return positions[:i]
+		}
+	}
+	return positions
+}
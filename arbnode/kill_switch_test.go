@@ -0,0 +1,59 @@
+package arbnode
+
+import (
+	"crypto/ecdsa"
+	"errors"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+
+	"github.com/offchainlabs/nitro/arbutil"
+	m "github.com/offchainlabs/nitro/broadcaster/message"
+	"github.com/offchainlabs/nitro/execution"
+)
+
+func TestKillSwitch(t *testing.T) {
+	owner, err := crypto.GenerateKey()
+	Require(t, err)
+	other, err := crypto.GenerateKey()
+	Require(t, err)
+	setOwner := func(config *TransactionStreamerConfig) {
+		config.KillSwitchOwner = crypto.PubkeyToAddress(owner.PublicKey).Hex()
+	}
+	streamer := newTestStreamer(t, nil, setOwner)
+
+	sign := func(killSwitch m.KillSwitchMessage, key *ecdsa.PrivateKey) *m.KillSwitchMessage {
+		signature, err := crypto.Sign(killSwitch.Hash(streamer.chainConfig.ChainID.Uint64()).Bytes(), key)
+		Require(t, err)
+		killSwitch.Signature = signature
+		return &killSwitch
+	}
+
+	err = streamer.AddKillSwitchMessage(sign(m.KillSwitchMessage{Nonce: 1, Position: 10, Engaged: true}, other))
+	if !errors.Is(err, ErrKillSwitchSignature) {
+		Fail(t, "kill switch signed by another key was honored", err)
+	}
+	Require(t, streamer.AddKillSwitchMessage(sign(m.KillSwitchMessage{Nonce: 1, Position: 10, Engaged: true}, owner)))
+	Require(t, streamer.expectKillSwitchReleased(9))
+	err = streamer.expectKillSwitchReleased(10)
+	if !errors.Is(err, ErrKillSwitchEngaged) || !errors.Is(err, execution.ErrRetrySequencer) {
+		Fail(t, "sequencing wasn't paused at the kill switch position", err)
+	}
+	pending := streamer.beforeKillSwitch([]arbutil.MessageIndex{8, 9, 10, 11})
+	if len(pending) != 2 || pending[1] != 9 {
+		Fail(t, "unexpected positions submitted before the kill switch", pending)
+	}
+
+	Require(t, streamer.AddKillSwitchMessage(sign(m.KillSwitchMessage{Nonce: 2}, owner)))
+	Require(t, streamer.expectKillSwitchReleased(10))
+	// Replaying the message engaging the kill switch doesn't undo the release
+	Require(t, streamer.AddKillSwitchMessage(sign(m.KillSwitchMessage{Nonce: 1, Position: 10, Engaged: true}, owner)))
+	Require(t, streamer.expectKillSwitchReleased(10))
+
+	Require(t, streamer.AddKillSwitchMessage(sign(m.KillSwitchMessage{Nonce: 3, Position: 20, Engaged: true}, owner)))
+	restarted := newTestStreamer(t, streamer.db, setOwner)
+	Require(t, restarted.loadKillSwitch())
+	if err := restarted.expectKillSwitchReleased(20); !errors.Is(err, ErrKillSwitchEngaged) {
+		Fail(t, "kill switch not restored after a restart", err)
+	}
+}
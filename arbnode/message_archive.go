@@ -0,0 +1,254 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package arbnode
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/rpc"
+	flag "github.com/spf13/pflag"
+
+	"github.com/offchainlabs/nitro/arbos/arbostypes"
+	"github.com/offchainlabs/nitro/arbutil"
+	"github.com/offchainlabs/nitro/util/dbutil"
+)
+
+var (
+	messageArchiveHitCounter     = metrics.NewRegisteredCounter("arb/streamer/archive/hit", nil)
+	messageArchiveFetchCounter   = metrics.NewRegisteredCounter("arb/streamer/archive/fetch", nil)
+	messageArchiveFailureCounter = metrics.NewRegisteredCounter("arb/streamer/archive/failure", nil)
+)
+
+// Largest encoded message read from an archive
+const messageArchiveMaxMessageSize = 64 * 1024 * 1024
+
+var ErrArchivedMessageMismatch = errors.New("archived message doesn't match the digest of the pruned message")
+
+// MessageArchive is a remote store serving the encoded messages pruned from the local database, so that
+// reads of old messages, e.g. for resequencing or validation, don't fail on nodes with pruned history
+type MessageArchive interface {
+	// GetMessage returns the RLP encoded MessageWithMetadata at pos
+	GetMessage(ctx context.Context, pos arbutil.MessageIndex) ([]byte, error)
+}
+
+type MessageArchiveConfig struct {
+	URL             string                `koanf:"url"`
+	RPCURL          string                `koanf:"rpc-url"`
+	S3              MessageBackupS3Config `koanf:"s3"`
+	Timeout         time.Duration         `koanf:"timeout" reload:"hot"`
+	CacheSize       int                   `koanf:"cache-size"`
+	AllowUnverified bool                  `koanf:"allow-unverified" reload:"hot"`
+}
+
+var DefaultMessageArchiveConfig = MessageArchiveConfig{
+	URL:             "",
+	RPCURL:          "",
+	Timeout:         10 * time.Second,
+	CacheSize:       1024,
+	AllowUnverified: false,
+}
+
+func MessageArchiveConfigAddOptions(prefix string, f *flag.FlagSet) {
+	f.String(prefix+".url", DefaultMessageArchiveConfig.URL, "base url of an http message archive serving the encoded message at <url>/<position>, read when a message was pruned from the local database")
+	f.String(prefix+".rpc-url", DefaultMessageArchiveConfig.RPCURL, "rpc url of another node whose arb namespace serves the messages pruned from the local database")
+	f.Bool(prefix+".s3.enable", DefaultMessageArchiveConfig.S3.Enable, "read the messages pruned from the local database from the objects <object-prefix><position> of an AWS S3 bucket")
+	f.String(prefix+".s3.access-key", DefaultMessageArchiveConfig.S3.AccessKey, "S3 access key")
+	f.String(prefix+".s3.secret-key", DefaultMessageArchiveConfig.S3.SecretKey, "S3 secret key")
+	f.String(prefix+".s3.region", DefaultMessageArchiveConfig.S3.Region, "S3 region")
+	f.String(prefix+".s3.bucket", DefaultMessageArchiveConfig.S3.Bucket, "S3 bucket")
+	f.String(prefix+".s3.object-prefix", DefaultMessageArchiveConfig.S3.ObjectPrefix, "prefix of the S3 objects")
+	f.Duration(prefix+".timeout", DefaultMessageArchiveConfig.Timeout, "timeout of a message archive read")
+	f.Int(prefix+".cache-size", DefaultMessageArchiveConfig.CacheSize, "number of messages read from the archive kept in memory")
+	f.Bool(prefix+".allow-unverified", DefaultMessageArchiveConfig.AllowUnverified, "accept archived messages that were pruned before their digests were kept, which can't be checked for integrity")
+}
+
+func (c *MessageArchiveConfig) Validate() error {
+	configured := 0
+	for _, enabled := range []bool{c.URL != "", c.RPCURL != "", c.S3.Enable} {
+		if enabled {
+			configured++
+		}
+	}
+	if configured > 1 {
+		return errors.New("at most one of the message archive url, rpc-url and s3 can be configured")
+	}
+	return nil
+}
+
+// newMessageArchive creates the archive configured, or returns nil if none is
+func newMessageArchive(config *MessageArchiveConfig) (MessageArchive, error) {
+	switch {
+	case config.URL != "":
+		return &httpMessageArchive{url: strings.TrimSuffix(config.URL, "/"), client: &http.Client{}}, nil
+	case config.RPCURL != "":
+		client, err := rpc.Dial(config.RPCURL)
+		if err != nil {
+			return nil, err
+		}
+		return &rpcMessageArchive{client: client}, nil
+	case config.S3.Enable:
+		client, err := newS3Client(&config.S3)
+		if err != nil {
+			return nil, err
+		}
+		return &s3MessageArchive{client: client, bucket: config.S3.Bucket, objectPrefix: config.S3.ObjectPrefix}, nil
+	}
+	return nil, nil
+}
+
+type httpMessageArchive struct {
+	url    string
+	client *http.Client
+}
+
+func (a *httpMessageArchive) GetMessage(ctx context.Context, pos arbutil.MessageIndex) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, a.url+"/"+strconv.FormatUint(uint64(pos), 10), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("message archive returned status %s", resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, messageArchiveMaxMessageSize))
+}
+
+type rpcMessageArchive struct {
+	client *rpc.Client
+}
+
+func (a *rpcMessageArchive) GetMessage(ctx context.Context, pos arbutil.MessageIndex) ([]byte, error) {
+	var data hexutil.Bytes
+	if err := a.client.CallContext(ctx, &data, "arb_messageData", hexutil.Uint64(pos)); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+type s3MessageArchive struct {
+	client       *s3.Client
+	bucket       string
+	objectPrefix string
+}
+
+func (a *s3MessageArchive) GetMessage(ctx context.Context, pos arbutil.MessageIndex) ([]byte, error) {
+	object, err := a.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(a.bucket),
+		Key:    aws.String(a.objectPrefix + strconv.FormatUint(uint64(pos), 10)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer object.Body.Close()
+	return io.ReadAll(io.LimitReader(object.Body, messageArchiveMaxMessageSize))
+}
+
+// SetMessageArchive sets the remote store read when a message was pruned from the local database,
+// replacing the one configured
+func (s *TransactionStreamer) SetMessageArchive(archive MessageArchive) {
+	if s.Started() {
+		panic("trying to set message archive after start")
+	}
+	s.messageArchive = archive
+}
+
+// GetEncodedMessage returns the message at pos as stored, RLP encoded, reading it from the archive if it was
+// pruned. Another node's archive reads it through the RPC API.
+func (s *TransactionStreamer) GetEncodedMessage(pos arbutil.MessageIndex) ([]byte, error) {
+	data, err := s.db.Get(dbKey(messagePrefix, uint64(pos)))
+	if err != nil && s.messageArchive != nil && dbutil.IsErrNotFound(err) {
+		return s.getArchivedMessage(pos, err)
+	}
+	return data, err
+}
+
+// recordPrunedMessages prepares the messages in [from, to) for pruning: the digests of the messages are kept,
+// so that they can be checked when read back from an archive, and the messages are removed from the lookup
+// indexes, whose entries are only found through the messages.
+func (s *TransactionStreamer) recordPrunedMessages(ctx context.Context, batch ethdb.KeyValueWriter, from arbutil.MessageIndex, to arbutil.MessageIndex) error {
+	return s.forEachStoredMessage(ctx, from, to, func(pos arbutil.MessageIndex, data []byte, msg *arbostypes.MessageWithMetadata) error {
+		if err := batch.Put(dbKey(prunedMessageDigestPrefix, uint64(pos)), crypto.Keccak256(data)); err != nil {
+			return err
+		}
+		return deleteMessageLookupEntries(batch, pos, msg)
+	})
+}
+
+// getArchivedMessage reads the encoded message at pos from the archive, after it wasn't found locally with
+// notFound. The message is checked against the digest kept when it was pruned, and against the message count.
+func (s *TransactionStreamer) getArchivedMessage(pos arbutil.MessageIndex, notFound error) ([]byte, error) {
+	count, err := s.GetMessageCount()
+	if err != nil {
+		return nil, err
+	}
+	if pos >= count {
+		return nil, notFound
+	}
+	s.messageArchiveMutex.Lock()
+	data, ok := s.messageArchiveCache.Get(pos)
+	s.messageArchiveMutex.Unlock()
+	if ok {
+		messageArchiveHitCounter.Inc(1)
+		return data, nil
+	}
+	data, err = s.fetchArchivedMessage(pos)
+	if err != nil {
+		messageArchiveFailureCounter.Inc(1)
+		return nil, fmt.Errorf("message %d was pruned and couldn't be read from the archive: %w", pos, err)
+	}
+	messageArchiveFetchCounter.Inc(1)
+	s.messageArchiveMutex.Lock()
+	s.messageArchiveCache.Add(pos, data)
+	s.messageArchiveMutex.Unlock()
+	return data, nil
+}
+
+func (s *TransactionStreamer) fetchArchivedMessage(pos arbutil.MessageIndex) ([]byte, error) {
+	config := &s.config().Archive
+	digest, err := s.db.Get(dbKey(prunedMessageDigestPrefix, uint64(pos)))
+	if err != nil {
+		if !dbutil.IsErrNotFound(err) {
+			return nil, err
+		}
+		if !config.AllowUnverified {
+			return nil, errors.New("no digest was kept to verify the archived message")
+		}
+		digest = nil
+	}
+	ctx := context.Background()
+	if streamerCtx, err := s.GetContextSafe(); err == nil {
+		ctx = streamerCtx
+	}
+	if config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, config.Timeout)
+		defer cancel()
+	}
+	data, err := s.messageArchive.GetMessage(ctx, pos)
+	if err != nil {
+		return nil, err
+	}
+	if digest != nil && crypto.Keccak256Hash(data) != common.BytesToHash(digest) {
+		return nil, ErrArchivedMessageMismatch
+	}
+	return data, nil
+}
@@ -0,0 +1,88 @@
+package arbnode
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/rlp"
+
+	"github.com/offchainlabs/nitro/arbos/arbostypes"
+	"github.com/offchainlabs/nitro/arbutil"
+	"github.com/offchainlabs/nitro/validator"
+)
+
+type testMessageArchive struct {
+	messages map[arbutil.MessageIndex][]byte
+	reads    int
+}
+
+func (a *testMessageArchive) GetMessage(ctx context.Context, pos arbutil.MessageIndex) ([]byte, error) {
+	a.reads++
+	data, ok := a.messages[pos]
+	if !ok {
+		return nil, errors.New("not archived")
+	}
+	return data, nil
+}
+
+func TestMessageArchive(t *testing.T) {
+	streamer := newTestImportStreamer(t)
+	var messages []arbostypes.MessageWithMetadata
+	for i := uint64(0); i < 6; i++ {
+		messages = append(messages, arbostypes.MessageWithMetadata{
+			Message: &arbostypes.L1IncomingMessage{Header: &arbostypes.L1IncomingMessageHeader{Timestamp: i}},
+		})
+	}
+	Require(t, streamer.AddMessages(0, true, messages))
+	archive := &testMessageArchive{messages: make(map[arbutil.MessageIndex][]byte)}
+	for pos := arbutil.MessageIndex(0); pos < 6; pos++ {
+		data, err := streamer.GetEncodedMessage(pos)
+		Require(t, err)
+		archive.messages[pos] = data
+	}
+	streamer.UpdateLatestConfirmed(4, validator.GoGlobalState{})
+	_, err := streamer.PruneMessagesBefore(context.Background(), 4)
+	Require(t, err)
+	if _, err := streamer.GetMessage(2); err == nil {
+		Fail(t, "pruned message read without an archive")
+	}
+
+	streamer.SetMessageArchive(archive)
+	msg, err := streamer.GetMessage(2)
+	Require(t, err)
+	if msg.Message.Header.Timestamp != 2 {
+		Fail(t, "unexpected archived message", msg.Message.Header.Timestamp)
+	}
+	// Messages still stored locally aren't read from the archive
+	reads := archive.reads
+	_, err = streamer.GetMessage(4)
+	Require(t, err)
+	if archive.reads != reads {
+		Fail(t, "stored message read from the archive")
+	}
+	// Nor are messages beyond the message count
+	if _, err := streamer.GetMessage(6); err == nil || archive.reads != reads {
+		Fail(t, "message beyond the message count read from the archive", err)
+	}
+
+	// A tampered message is rejected
+	tampered := messages[3]
+	tampered.DelayedMessagesRead = 100
+	data, err := rlp.EncodeToBytes(tampered)
+	Require(t, err)
+	archive.messages[3] = data
+	if _, err := streamer.GetMessage(3); !errors.Is(err, ErrArchivedMessageMismatch) {
+		Fail(t, "tampered archived message not rejected", err)
+	}
+
+	// Messages pruned without a digest are only read if unverified messages are allowed
+	Require(t, streamer.db.Delete(dbKey(prunedMessageDigestPrefix, 1)))
+	if _, err := streamer.GetMessage(1); err == nil {
+		Fail(t, "unverifiable archived message accepted")
+	}
+	config := streamer.config()
+	config.Archive.AllowUnverified = true
+	_, err = streamer.GetMessage(1)
+	Require(t, err)
+}
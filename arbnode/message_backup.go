@@ -0,0 +1,298 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package arbnode
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsConfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rlp"
+	flag "github.com/spf13/pflag"
+
+	"github.com/offchainlabs/nitro/arbutil"
+	"github.com/offchainlabs/nitro/util/dbutil"
+	"github.com/offchainlabs/nitro/util/stopwaiter"
+)
+
+// MessageBackupStore is the remote storage the backup agent ships message ranges to.
+type MessageBackupStore interface {
+	PutObject(ctx context.Context, name string, data []byte) error
+}
+
+type MessageBackupS3Config struct {
+	Enable       bool   `koanf:"enable"`
+	AccessKey    string `koanf:"access-key"`
+	SecretKey    string `koanf:"secret-key"`
+	Region       string `koanf:"region"`
+	Bucket       string `koanf:"bucket"`
+	ObjectPrefix string `koanf:"object-prefix"`
+}
+
+type MessageBackupConfig struct {
+	Enable       bool                  `koanf:"enable"`
+	Interval     time.Duration         `koanf:"interval" reload:"hot"`
+	MaxRangeSize uint64                `koanf:"max-range-size" reload:"hot"`
+	Directory    string                `koanf:"directory"`
+	S3           MessageBackupS3Config `koanf:"s3"`
+}
+
+type MessageBackupConfigFetcher func() *MessageBackupConfig
+
+var DefaultMessageBackupConfig = MessageBackupConfig{
+	Enable:       false,
+	Interval:     time.Minute,
+	MaxRangeSize: 10_000,
+	Directory:    "",
+}
+
+func (c *MessageBackupConfig) Validate() error {
+	if !c.Enable {
+		return nil
+	}
+	if (c.Directory == "") == !c.S3.Enable {
+		return errors.New("message backup requires exactly one of directory or s3 to be configured")
+	}
+	if c.MaxRangeSize == 0 {
+		return errors.New("message backup max-range-size must be greater than 0")
+	}
+	return nil
+}
+
+func MessageBackupConfigAddOptions(prefix string, f *flag.FlagSet) {
+	f.Bool(prefix+".enable", DefaultMessageBackupConfig.Enable, "enable continuously backing up newly committed messages to remote storage")
+	f.Duration(prefix+".interval", DefaultMessageBackupConfig.Interval, "how long to wait before checking for new messages to back up")
+	f.Uint64(prefix+".max-range-size", DefaultMessageBackupConfig.MaxRangeSize, "maximum number of messages to include in a single backup object")
+	f.String(prefix+".directory", DefaultMessageBackupConfig.Directory, "directory (e.g. a mounted remote filesystem) to write backup objects to")
+	f.Bool(prefix+".s3.enable", DefaultMessageBackupConfig.S3.Enable, "write backup objects to an AWS S3 bucket")
+	f.String(prefix+".s3.access-key", DefaultMessageBackupConfig.S3.AccessKey, "S3 access key")
+	f.String(prefix+".s3.secret-key", DefaultMessageBackupConfig.S3.SecretKey, "S3 secret key")
+	f.String(prefix+".s3.region", DefaultMessageBackupConfig.S3.Region, "S3 region")
+	f.String(prefix+".s3.bucket", DefaultMessageBackupConfig.S3.Bucket, "S3 bucket")
+	f.String(prefix+".s3.object-prefix", DefaultMessageBackupConfig.S3.ObjectPrefix, "prefix to add to S3 objects")
+}
+
+type directoryBackupStore struct {
+	dir string
+}
+
+func (d *directoryBackupStore) PutObject(ctx context.Context, name string, data []byte) error {
+	path := filepath.Join(d.dir, name)
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		return err
+	}
+	// Rename so that a partially written object is never visible under its final name
+	return os.Rename(tmpPath, path)
+}
+
+type s3BackupStore struct {
+	uploader     *manager.Uploader
+	bucket       string
+	objectPrefix string
+}
+
+func newS3Client(config *MessageBackupS3Config) (*s3.Client, error) {
+	cfg, err := awsConfig.LoadDefaultConfig(context.TODO(), awsConfig.WithRegion(config.Region), func(options *awsConfig.LoadOptions) error {
+		if config.AccessKey != "" && config.SecretKey != "" {
+			options.Credentials = credentials.NewStaticCredentialsProvider(config.AccessKey, config.SecretKey, "")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s3.NewFromConfig(cfg), nil
+}
+
+func newS3BackupStore(config *MessageBackupS3Config) (*s3BackupStore, error) {
+	client, err := newS3Client(config)
+	if err != nil {
+		return nil, err
+	}
+	return &s3BackupStore{
+		uploader:     manager.NewUploader(client),
+		bucket:       config.Bucket,
+		objectPrefix: config.ObjectPrefix,
+	}, nil
+}
+
+func (s *s3BackupStore) PutObject(ctx context.Context, name string, data []byte) error {
+	_, err := s.uploader.Upload(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.objectPrefix + name),
+		Body:   bytes.NewReader(data),
+	})
+	return err
+}
+
+// messageBackupCheckpoint is persisted after each successful upload.
+// ReorgCount is the streamer's reorg history count when the range was read,
+// and is used to detect reorgs that invalidated already uploaded messages.
+type messageBackupCheckpoint struct {
+	NextPos    uint64
+	Sequence   uint64
+	ReorgCount uint64
+}
+
+// MessageBackupAgent ships newly committed message ranges to remote storage in the export format.
+// Objects are named by an increasing sequence number, so a restore replays them in order on top
+// of the latest snapshot, with later objects overriding earlier ones after a reorg.
+type MessageBackupAgent struct {
+	stopwaiter.StopWaiter
+	streamer *TransactionStreamer
+	store    MessageBackupStore
+	config   MessageBackupConfigFetcher
+}
+
+func NewMessageBackupAgent(streamer *TransactionStreamer, config MessageBackupConfigFetcher) (*MessageBackupAgent, error) {
+	cfg := config()
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	var store MessageBackupStore
+	if cfg.S3.Enable {
+		s3Store, err := newS3BackupStore(&cfg.S3)
+		if err != nil {
+			return nil, err
+		}
+		store = s3Store
+	} else {
+		if err := os.MkdirAll(cfg.Directory, 0700); err != nil {
+			return nil, err
+		}
+		store = &directoryBackupStore{dir: cfg.Directory}
+	}
+	return &MessageBackupAgent{
+		streamer: streamer,
+		store:    store,
+		config:   config,
+	}, nil
+}
+
+func (a *MessageBackupAgent) Start(ctxIn context.Context) {
+	a.StopWaiter.Start(ctxIn, a)
+	a.CallIteratively(a.backupLoop)
+}
+
+func (a *MessageBackupAgent) readCheckpoint() (*messageBackupCheckpoint, error) {
+	data, err := a.streamer.db.Get(messageBackupCheckpointKey)
+	if err != nil {
+		if dbutil.IsErrNotFound(err) {
+			return &messageBackupCheckpoint{}, nil
+		}
+		return nil, err
+	}
+	var checkpoint messageBackupCheckpoint
+	if err := rlp.DecodeBytes(data, &checkpoint); err != nil {
+		return nil, err
+	}
+	return &checkpoint, nil
+}
+
+func (a *MessageBackupAgent) writeCheckpoint(checkpoint *messageBackupCheckpoint) error {
+	data, err := rlp.EncodeToBytes(checkpoint)
+	if err != nil {
+		return err
+	}
+	return a.streamer.db.Put(messageBackupCheckpointKey, data)
+}
+
+// rewindForReorgs moves the checkpoint back to the earliest position affected by
+// reorgs that happened since it was written.
+func (a *MessageBackupAgent) rewindForReorgs(checkpoint *messageBackupCheckpoint, reorgCount uint64) error {
+	if reorgCount <= checkpoint.ReorgCount {
+		return nil
+	}
+	newReorgs := reorgCount - checkpoint.ReorgCount
+	records, err := a.streamer.GetReorgHistory(newReorgs)
+	if err != nil {
+		return err
+	}
+	if uint64(len(records)) < newReorgs {
+		log.Warn("reorg history incomplete, re-uploading all messages", "missingRecords", newReorgs-uint64(len(records)))
+		checkpoint.NextPos = 0
+		return nil
+	}
+	for _, record := range records {
+		if record.Position < checkpoint.NextPos {
+			checkpoint.NextPos = record.Position
+		}
+	}
+	return nil
+}
+
+// uploadNextRange returns true if a range was uploaded.
+func (a *MessageBackupAgent) uploadNextRange(ctx context.Context) (bool, error) {
+	config := a.config()
+	checkpoint, err := a.readCheckpoint()
+	if err != nil {
+		return false, err
+	}
+	reorgCount, err := a.streamer.getReorgHistoryCount()
+	if err != nil {
+		return false, err
+	}
+	if err := a.rewindForReorgs(checkpoint, reorgCount); err != nil {
+		return false, err
+	}
+	msgCount, err := a.streamer.GetMessageCount()
+	if err != nil {
+		return false, err
+	}
+	start := arbutil.MessageIndex(checkpoint.NextPos)
+	if msgCount < start {
+		start = msgCount
+	}
+	if msgCount == start {
+		return false, nil
+	}
+	end := msgCount
+	if end-start > arbutil.MessageIndex(config.MaxRangeSize) {
+		end = start + arbutil.MessageIndex(config.MaxRangeSize)
+	}
+	export, err := a.streamer.ExportMessageRange(start, end)
+	if err != nil {
+		return false, err
+	}
+	data, err := EncodeMessageExport(export)
+	if err != nil {
+		return false, err
+	}
+	name := fmt.Sprintf("%020d-messages-%d-%d.rlp", checkpoint.Sequence, start, end)
+	if err := a.store.PutObject(ctx, name, data); err != nil {
+		return false, fmt.Errorf("failed to upload backup object %v: %w", name, err)
+	}
+	log.Debug("uploaded message backup", "object", name, "start", start, "end", end)
+	return true, a.writeCheckpoint(&messageBackupCheckpoint{
+		NextPos:    uint64(end),
+		Sequence:   checkpoint.Sequence + 1,
+		ReorgCount: reorgCount,
+	})
+}
+
+func (a *MessageBackupAgent) backupLoop(ctx context.Context) time.Duration {
+	uploaded, err := a.uploadNextRange(ctx)
+	if err != nil {
+		if ctx.Err() != nil {
+			return 0
+		}
+		log.Warn("error backing up messages", "err", err)
+		return a.config().Interval
+	}
+	if uploaded {
+		return 0
+	}
+	return a.config().Interval
+}
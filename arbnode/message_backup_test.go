@@ -0,0 +1,70 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package arbnode
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/core/rawdb"
+
+	"github.com/offchainlabs/nitro/arbutil"
+)
+
+func TestMessageBackupAgentUploadsRanges(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db := rawdb.NewMemoryDatabase()
+	streamerConfig := TestTransactionStreamerConfig
+	streamer := &TransactionStreamer{
+		db:     db,
+		config: func() *TransactionStreamerConfig { return &streamerConfig },
+	}
+	for i := uint64(0); i < 5; i++ {
+		Require(t, db.Put(dbKey(messagePrefix, i), []byte{byte(i)}))
+	}
+	Require(t, setMessageCount(db, 5))
+
+	backupConfig := DefaultMessageBackupConfig
+	backupConfig.Enable = true
+	backupConfig.MaxRangeSize = 3
+	backupConfig.Directory = t.TempDir()
+	agent, err := NewMessageBackupAgent(streamer, func() *MessageBackupConfig { return &backupConfig })
+	Require(t, err)
+
+	for _, expectUpload := range []bool{true, true, false} {
+		uploaded, err := agent.uploadNextRange(ctx)
+		Require(t, err)
+		if uploaded != expectUpload {
+			Fail(t, "expected upload", expectUpload, "got", uploaded)
+		}
+	}
+
+	data, err := os.ReadFile(filepath.Join(backupConfig.Directory, "00000000000000000001-messages-3-5.rlp"))
+	Require(t, err)
+	export, err := DecodeMessageExport(data)
+	Require(t, err)
+	if export.Start != 3 || export.End() != 5 {
+		Fail(t, "unexpected export range", export.Start, export.End())
+	}
+	if !bytes.Equal(export.Messages[1].Message, []byte{4}) {
+		Fail(t, "unexpected exported message", export.Messages[1].Message)
+	}
+
+	// A reorg back to position 1 should cause the agent to re-upload from there
+	batch := db.NewBatch()
+	Require(t, streamer.recordReorg(batch, ReorgSourceConfirmed, arbutil.MessageIndex(1), 4, 0))
+	Require(t, batch.Write())
+	uploaded, err := agent.uploadNextRange(ctx)
+	Require(t, err)
+	if !uploaded {
+		Fail(t, "expected re-upload after reorg")
+	}
+	_, err = os.Stat(filepath.Join(backupConfig.Directory, "00000000000000000002-messages-1-4.rlp"))
+	Require(t, err)
+}
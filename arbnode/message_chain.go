@@ -0,0 +1,337 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package arbnode
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/rlp"
+
+	"github.com/offchainlabs/nitro/arbos/arbostypes"
+	"github.com/offchainlabs/nitro/arbutil"
+	m "github.com/offchainlabs/nitro/broadcaster/message"
+	"github.com/offchainlabs/nitro/util/dbutil"
+)
+
+var (
+	messageChainGauge           = metrics.NewRegisteredGauge("arb/streamer/message_chain/count", nil)
+	messageChainMismatchCounter = metrics.NewRegisteredCounter("arb/streamer/message_chain/mismatch", nil)
+)
+
+const (
+	// Maximum number of intervals the chain is caught up by in one iteration
+	messageChainCatchUpIntervals = 16
+	messageChainCatchUpInterval  = time.Second
+	// Number of accumulators received from the feed kept until the local ones are computed
+	feedMessageChainCacheSize = 64
+)
+
+// The message chain is a rolling hash over the whole message history: the accumulator over the messages before
+// count is keccak256(accumulator before count-1, count-1, encoded message at count-1), starting from the zero
+// hash. Nodes with identical histories have identical accumulators, so comparing them is enough to prove it.
+// The accumulators at the multiples of the configured interval are stored. The tip of the chain is extended
+// as messages are written, and caught up in the background when it's behind, e.g. after a restart or a reorg.
+
+type messageChainTip struct {
+	count arbutil.MessageIndex
+	acc   common.Hash
+}
+
+func messageChainStep(acc common.Hash, pos arbutil.MessageIndex, encoded []byte) common.Hash {
+	return crypto.Keccak256Hash(acc[:], uint64ToKey(uint64(pos)), encoded)
+}
+
+// GetMessageChainAccumulator returns the accumulator over the messages before count, or nil if it isn't stored.
+// Only the accumulators at the multiples of the message chain interval are stored.
+func (s *TransactionStreamer) GetMessageChainAccumulator(count arbutil.MessageIndex) (*common.Hash, error) {
+	data, err := s.db.Get(dbKey(messageChainPrefix, uint64(count)))
+	if err != nil {
+		if dbutil.IsErrNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	acc := common.BytesToHash(data)
+	return &acc, nil
+}
+
+func setMessageChainAccumulator(batch ethdb.KeyValueWriter, count arbutil.MessageIndex, acc common.Hash) error {
+	return batch.Put(dbKey(messageChainPrefix, uint64(count)), acc[:])
+}
+
+// latestMessageChainCheckpoint returns the latest stored accumulator at or before count, or the empty chain
+func (s *TransactionStreamer) latestMessageChainCheckpoint(count arbutil.MessageIndex, interval uint64) (*messageChainTip, error) {
+	for checkpoint := uint64(count) / interval * interval; checkpoint > 0; checkpoint -= interval {
+		acc, err := s.GetMessageChainAccumulator(arbutil.MessageIndex(checkpoint))
+		if err != nil {
+			return nil, err
+		}
+		if acc != nil {
+			return &messageChainTip{count: arbutil.MessageIndex(checkpoint), acc: *acc}, nil
+		}
+	}
+	return &messageChainTip{}, nil
+}
+
+// extendMessageChain adds the messages being written at pos to the chain if its tip is at pos, storing the
+// accumulators completing an interval in batch. The returned function moves the tip once batch is written.
+// Must be called while holding the insertionMutex.
+func (s *TransactionStreamer) extendMessageChain(pos arbutil.MessageIndex, messages []arbostypes.MessageWithMetadataAndBlockHash, batch ethdb.KeyValueWriter) (func(), error) {
+	interval := s.config().MessageChainInterval
+	if interval == 0 || len(messages) == 0 {
+		return func() {}, nil
+	}
+	s.messageChainMutex.Lock()
+	tip := s.messageChainTip
+	generation := s.messageChainGeneration
+	s.messageChainMutex.Unlock()
+	if tip == nil || tip.count != pos {
+		// Caught up in the background
+		return func() {}, nil
+	}
+	acc := tip.acc
+	var checkpoints []messageChainTip
+	for i, msg := range messages {
+		// #nosec G115
+		msgPos := pos + arbutil.MessageIndex(i)
+		encoded, err := rlp.EncodeToBytes(msg.MessageWithMeta)
+		if err != nil {
+			return nil, err
+		}
+		acc = messageChainStep(acc, msgPos, encoded)
+		if uint64(msgPos+1)%interval == 0 {
+			if err := setMessageChainAccumulator(batch, msgPos+1, acc); err != nil {
+				return nil, err
+			}
+			checkpoints = append(checkpoints, messageChainTip{count: msgPos + 1, acc: acc})
+		}
+	}
+	// #nosec G115
+	newTip := &messageChainTip{count: pos + arbutil.MessageIndex(len(messages)), acc: acc}
+	return func() {
+		s.messageChainMutex.Lock()
+		defer s.messageChainMutex.Unlock()
+		if s.messageChainGeneration == generation && s.messageChainTip == tip {
+			s.messageChainTip = newTip
+			// #nosec G115
+			messageChainGauge.Update(int64(newTip.count))
+		}
+		for _, checkpoint := range checkpoints {
+			s.compareFeedMessageChain(checkpoint.count, checkpoint.acc)
+		}
+	}, nil
+}
+
+// advanceMessageChain catches the tip of the chain up with the stored messages, it's meant to be called iteratively
+func (s *TransactionStreamer) advanceMessageChain(ctx context.Context) time.Duration {
+	interval := s.config().MessageChainInterval
+	if interval == 0 {
+		return messageChainCatchUpInterval
+	}
+	count, err := s.GetMessageCount()
+	if err != nil {
+		log.Warn("message chain failed to get the message count", "err", err)
+		return messageChainCatchUpInterval
+	}
+	s.messageChainMutex.Lock()
+	prevTip := s.messageChainTip
+	generation := s.messageChainGeneration
+	s.messageChainMutex.Unlock()
+	tip := prevTip
+	if tip == nil {
+		tip, err = s.latestMessageChainCheckpoint(count, interval)
+		if err != nil {
+			log.Warn("message chain failed to find the latest accumulator", "err", err)
+			return messageChainCatchUpInterval
+		}
+	}
+	end := min(count, tip.count+arbutil.MessageIndex(interval*messageChainCatchUpIntervals))
+	batch := s.db.NewBatch()
+	acc := tip.acc
+	var checkpoints []messageChainTip
+	for pos := tip.count; pos < end; pos++ {
+		if ctx.Err() != nil {
+			return 0
+		}
+		encoded, err := s.GetEncodedMessage(pos)
+		if err != nil {
+			log.Warn("message chain failed to read the message", "pos", pos, "err", err)
+			return messageChainCatchUpInterval
+		}
+		acc = messageChainStep(acc, pos, encoded)
+		if uint64(pos+1)%interval == 0 {
+			if err := setMessageChainAccumulator(batch, pos+1, acc); err != nil {
+				log.Warn("message chain failed to store the accumulator", "count", pos+1, "err", err)
+				return messageChainCatchUpInterval
+			}
+			checkpoints = append(checkpoints, messageChainTip{count: pos + 1, acc: acc})
+		}
+	}
+
+	s.messageChainMutex.Lock()
+	defer s.messageChainMutex.Unlock()
+	if s.messageChainGeneration != generation || s.messageChainTip != prevTip {
+		// A reorg or a write moved the tip meanwhile
+		return 0
+	}
+	if err := batch.Write(); err != nil {
+		log.Warn("message chain failed to write the accumulators", "err", err)
+		return messageChainCatchUpInterval
+	}
+	s.messageChainTip = &messageChainTip{count: max(tip.count, end), acc: acc}
+	// #nosec G115
+	messageChainGauge.Update(int64(s.messageChainTip.count))
+	for _, checkpoint := range checkpoints {
+		s.compareFeedMessageChain(checkpoint.count, checkpoint.acc)
+	}
+	if end < count {
+		return 0
+	}
+	return messageChainCatchUpInterval
+}
+
+// resetMessageChain drops the accumulators past count after a reorg to count was written
+func (s *TransactionStreamer) resetMessageChain(count arbutil.MessageIndex) {
+	if s.config().MessageChainInterval == 0 {
+		return
+	}
+	s.messageChainMutex.Lock()
+	defer s.messageChainMutex.Unlock()
+	s.messageChainGeneration++
+	if s.messageChainTip != nil && s.messageChainTip.count > count {
+		s.messageChainTip = nil
+	}
+	batch := s.db.NewBatch()
+	if err := deleteStartingAt(s.db, batch, messageChainPrefix, uint64ToKey(uint64(count)+1)); err != nil {
+		log.Error("failed to delete the reorged message chain accumulators", "count", count, "err", err)
+		return
+	}
+	if err := batch.Write(); err != nil {
+		log.Error("failed to delete the reorged message chain accumulators", "count", count, "err", err)
+	}
+}
+
+// compareFeedMessageChain compares the local accumulator over the messages before count with the one received
+// from the feed, if any. Must be called while holding the messageChainMutex.
+func (s *TransactionStreamer) compareFeedMessageChain(count arbutil.MessageIndex, acc common.Hash) {
+	feedAcc, ok := s.feedMessageChain.Get(count)
+	if !ok {
+		return
+	}
+	s.feedMessageChain.Remove(count)
+	if feedAcc != acc {
+		messageChainMismatchCounter.Inc(1)
+		log.Error("message history differs from the feed's", "count", count, "local", acc, "feed", feedAcc)
+	}
+}
+
+// recordFeedMessageChain compares the accumulator received from the feed with the local one, or keeps it until
+// the local one is computed
+func (s *TransactionStreamer) recordFeedMessageChain(count arbutil.MessageIndex, feedAcc common.Hash) {
+	if s.config().MessageChainInterval == 0 {
+		return
+	}
+	acc, err := s.GetMessageChainAccumulator(count)
+	if err != nil {
+		log.Warn("failed to read the message chain accumulator", "count", count, "err", err)
+		return
+	}
+	s.messageChainMutex.Lock()
+	defer s.messageChainMutex.Unlock()
+	s.feedMessageChain.Add(count, feedAcc)
+	if acc != nil {
+		s.compareFeedMessageChain(count, *acc)
+	}
+}
+
+// attachMessageChain is the broadcast filter adding the accumulators completing an interval to the feed
+func (s *TransactionStreamer) attachMessageChain(msg *m.BroadcastFeedMessage) *m.BroadcastFeedMessage {
+	interval := s.config().MessageChainInterval
+	if interval == 0 || msg.MessageChain != nil || uint64(msg.SequenceNumber+1)%interval != 0 {
+		return msg
+	}
+	acc, err := s.GetMessageChainAccumulator(msg.SequenceNumber + 1)
+	if err != nil {
+		log.Warn("failed to read the message chain accumulator", "count", msg.SequenceNumber+1, "err", err)
+	}
+	msg.MessageChain = acc
+	return msg
+}
+
+// MessageChainVerification is the result of recomputing the message chain over a range of messages
+type MessageChainVerification struct {
+	From arbutil.MessageIndex `json:"from"`
+	To   arbutil.MessageIndex `json:"to"`
+	// Accumulator over the messages before To
+	Accumulator common.Hash `json:"accumulator"`
+	// Number of stored accumulators checked
+	Checked uint64 `json:"checked"`
+	// Count of the first stored accumulator that doesn't match the messages, if any
+	MismatchAt *arbutil.MessageIndex `json:"mismatchAt,omitempty"`
+}
+
+// VerifyMessageChain recomputes the message chain over the messages in [from, to), from the stored accumulator
+// at or before from, and checks the stored accumulators against it. It stops at the first mismatch.
+func (s *TransactionStreamer) VerifyMessageChain(ctx context.Context, from, to arbutil.MessageIndex) (*MessageChainVerification, error) {
+	interval := s.config().MessageChainInterval
+	if interval == 0 {
+		return nil, fmt.Errorf("the message chain is disabled")
+	}
+	count, err := s.GetMessageCount()
+	if err != nil {
+		return nil, err
+	}
+	if to > count || from > to {
+		return nil, fmt.Errorf("invalid message range [%d, %d) with %d messages", from, to, count)
+	}
+	start := arbutil.MessageIndex(uint64(from) / interval * interval)
+	var acc common.Hash
+	if start > 0 {
+		stored, err := s.GetMessageChainAccumulator(start)
+		if err != nil {
+			return nil, err
+		}
+		if stored == nil {
+			return nil, fmt.Errorf("no message chain accumulator stored at %d", start)
+		}
+		acc = *stored
+	}
+	res := &MessageChainVerification{From: start, To: to}
+	for pos := start; pos < to; pos++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		encoded, err := s.GetEncodedMessage(pos)
+		if err != nil {
+			return nil, err
+		}
+		acc = messageChainStep(acc, pos, encoded)
+		if uint64(pos+1)%interval != 0 {
+			continue
+		}
+		stored, err := s.GetMessageChainAccumulator(pos + 1)
+		if err != nil {
+			return nil, err
+		}
+		if stored == nil {
+			continue
+		}
+		res.Checked++
+		if *stored != acc {
+			mismatch := pos + 1
+			res.MismatchAt = &mismatch
+			res.To = mismatch
+			break
+		}
+	}
+	res.Accumulator = acc
+	return res, nil
+}
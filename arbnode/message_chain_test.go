@@ -0,0 +1,89 @@
+package arbnode
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"github.com/offchainlabs/nitro/arbos/arbostypes"
+	"github.com/offchainlabs/nitro/arbutil"
+	m "github.com/offchainlabs/nitro/broadcaster/message"
+)
+
+func testChainMessages(from, to uint64) []arbostypes.MessageWithMetadata {
+	var messages []arbostypes.MessageWithMetadata
+	for i := from; i < to; i++ {
+		messages = append(messages, arbostypes.MessageWithMetadata{
+			Message:             &arbostypes.L1IncomingMessage{Header: &arbostypes.L1IncomingMessageHeader{Timestamp: i}},
+			DelayedMessagesRead: 1,
+		})
+	}
+	return messages
+}
+
+func TestMessageChain(t *testing.T) {
+	ctx := context.Background()
+	streamer := newTestImportStreamer(t)
+	streamer.config().MessageChainInterval = 2
+	Require(t, streamer.AddMessages(0, true, testChainMessages(0, 5)))
+
+	// The tip isn't loaded yet, so the chain is caught up in the background
+	if acc, err := streamer.GetMessageChainAccumulator(2); err != nil || acc != nil {
+		Fail(t, "accumulator stored before catching up", acc, err)
+	}
+	streamer.advanceMessageChain(ctx)
+	var expected common.Hash
+	for pos := arbutil.MessageIndex(0); pos < 4; pos++ {
+		encoded, err := streamer.GetEncodedMessage(pos)
+		Require(t, err)
+		expected = messageChainStep(expected, pos, encoded)
+		if pos%2 == 1 {
+			acc, err := streamer.GetMessageChainAccumulator(pos + 1)
+			Require(t, err)
+			if acc == nil || *acc != expected {
+				Fail(t, "unexpected accumulator at", pos+1, acc, expected)
+			}
+		}
+	}
+
+	// Once caught up, the chain is extended as messages are written
+	Require(t, streamer.AddMessages(5, true, testChainMessages(5, 6)))
+	acc6, err := streamer.GetMessageChainAccumulator(6)
+	Require(t, err)
+	if acc6 == nil {
+		Fail(t, "accumulator not stored on write")
+	}
+	res, err := streamer.VerifyMessageChain(ctx, 1, 6)
+	Require(t, err)
+	if res.MismatchAt != nil || res.Checked != 3 || res.Accumulator != *acc6 {
+		Fail(t, "unexpected verification", res)
+	}
+
+	// The feed's accumulators are compared with the local ones
+	mismatches := messageChainMismatchCounter.Snapshot().Count()
+	streamer.recordFeedMessageChain(6, *acc6)
+	streamer.recordFeedMessageChain(8, common.Hash{1})
+	Require(t, streamer.AddMessages(6, true, testChainMessages(6, 8)))
+	if messageChainMismatchCounter.Snapshot().Count() != mismatches+1 {
+		Fail(t, "feed mismatch not detected")
+	}
+	feedMsg := streamer.attachMessageChain(&m.BroadcastFeedMessage{SequenceNumber: 5})
+	if feedMsg.MessageChain == nil || *feedMsg.MessageChain != *acc6 {
+		Fail(t, "accumulator not attached to the feed message", feedMsg.MessageChain)
+	}
+
+	// A reorg drops the accumulators past it
+	Require(t, streamer.ReorgToAndEndBatch(streamer.db.NewBatch(), 5))
+	if acc, err := streamer.GetMessageChainAccumulator(6); err != nil || acc != nil {
+		Fail(t, "accumulator kept after reorg", acc, err)
+	}
+
+	// A corrupted accumulator is reported
+	Require(t, setMessageChainAccumulator(streamer.db, 4, common.Hash{2}))
+	res, err = streamer.VerifyMessageChain(ctx, 0, 5)
+	Require(t, err)
+	if res.MismatchAt == nil || *res.MismatchAt != 4 {
+		Fail(t, "corrupted accumulator not detected", res)
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package arbnode
+
+import (
+	"reflect"
+
+	"github.com/offchainlabs/nitro/arbos/arbostypes"
+	"github.com/offchainlabs/nitro/arbutil"
+)
+
+// MessageComparator is a rule tolerating a benign difference between a message stored at a position and a message
+// added at the same position, so that the added message is treated as a duplicate rather than as a reorg
+type MessageComparator interface {
+	// StripBenignDifference is called with copies of a stored and an added message that aren't byte for byte equal.
+	// It clears the fields the rule tolerates differences in on both copies, replacing the fields of the copies
+	// rather than modifying what they point to. It returns whether the added message should overwrite the stored
+	// one if the messages turn out to be duplicates, e.g. because the added message carries more data.
+	StripBenignDifference(pos arbutil.MessageIndex, stored *arbostypes.MessageWithMetadata, added *arbostypes.MessageWithMetadata) bool
+}
+
+// batchGasCostComparator tolerates a batch gas cost missing from either message. The batch gas cost is a cache
+// of data read from L1, which is kept if the added message has it.
+type batchGasCostComparator struct{}
+
+func (batchGasCostComparator) StripBenignDifference(pos arbutil.MessageIndex, stored *arbostypes.MessageWithMetadata, added *arbostypes.MessageWithMetadata) bool {
+	if stored.Message == nil || added.Message == nil {
+		return false
+	}
+	if stored.Message.BatchGasCost != nil && added.Message.BatchGasCost != nil {
+		return false
+	}
+	update := added.Message.BatchGasCost != nil
+	stored.Message.BatchGasCost = nil
+	added.Message.BatchGasCost = nil
+	return update
+}
+
+// AddMessageComparator registers an additional rule tolerating benign differences between stored and added
+// messages, it must be called before Start
+func (s *TransactionStreamer) AddMessageComparator(comparator MessageComparator) {
+	if s.Started() {
+		panic("trying to add message comparator after start")
+	}
+	s.messageComparators = append(s.messageComparators, comparator)
+}
+
+// isDuplicateMessage returns whether the added message only differs from the stored message at pos in ways
+// tolerated by the message comparators, and if so whether the added message should overwrite the stored one
+func (s *TransactionStreamer) isDuplicateMessage(pos arbutil.MessageIndex, stored *arbostypes.MessageWithMetadata, added *arbostypes.MessageWithMetadata) (bool, bool) {
+	storedCopy := copyMessageWithMetadata(stored)
+	addedCopy := copyMessageWithMetadata(added)
+	update := false
+	for _, comparator := range s.messageComparators {
+		if comparator.StripBenignDifference(pos, &storedCopy, &addedCopy) {
+			update = true
+		}
+	}
+	if !reflect.DeepEqual(storedCopy, addedCopy) {
+		return false, false
+	}
+	return true, update
+}
@@ -0,0 +1,79 @@
+package arbnode
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+
+	"github.com/offchainlabs/nitro/arbos/arbostypes"
+	"github.com/offchainlabs/nitro/arbutil"
+)
+
+// timestampComparator tolerates differing header timestamps, keeping the later one
+type timestampComparator struct{}
+
+func (timestampComparator) StripBenignDifference(pos arbutil.MessageIndex, stored *arbostypes.MessageWithMetadata, added *arbostypes.MessageWithMetadata) bool {
+	if stored.Message == nil || added.Message == nil || stored.Message.Header == nil || added.Message.Header == nil {
+		return false
+	}
+	update := added.Message.Header.Timestamp > stored.Message.Header.Timestamp
+	storedHeader := *stored.Message.Header
+	addedHeader := *added.Message.Header
+	storedHeader.Timestamp = 0
+	addedHeader.Timestamp = 0
+	stored.Message.Header = &storedHeader
+	added.Message.Header = &addedHeader
+	return update
+}
+
+func TestMessageComparators(t *testing.T) {
+	streamer := newTestImportStreamer(t)
+	message := func(timestamp uint64, batchGasCost *uint64) arbostypes.MessageWithMetadataAndBlockHash {
+		return arbostypes.MessageWithMetadataAndBlockHash{
+			MessageWithMeta: arbostypes.MessageWithMetadata{
+				Message: &arbostypes.L1IncomingMessage{
+					Header:       &arbostypes.L1IncomingMessageHeader{Timestamp: timestamp},
+					BatchGasCost: batchGasCost,
+				},
+				DelayedMessagesRead: 1,
+			},
+		}
+	}
+	Require(t, streamer.AddMessages(0, true, []arbostypes.MessageWithMetadata{message(1, nil).MessageWithMeta}))
+
+	gasCost := uint64(10)
+	var batch ethdb.Batch
+	dups, reorg, _, err := streamer.countDuplicateMessages(0, []arbostypes.MessageWithMetadataAndBlockHash{message(1, &gasCost)}, &batch)
+	Require(t, err)
+	if dups != 1 || reorg || batch == nil {
+		Fail(t, "message only differing in its batch gas cost not treated as an updated duplicate", dups, reorg)
+	}
+
+	later := []arbostypes.MessageWithMetadataAndBlockHash{message(2, nil)}
+	dups, reorg, _, err = streamer.countDuplicateMessages(0, later, nil)
+	Require(t, err)
+	if dups != 0 || !reorg {
+		Fail(t, "message with another timestamp treated as a duplicate without a comparator", dups, reorg)
+	}
+
+	streamer.AddMessageComparator(timestampComparator{})
+	batch = nil
+	dups, reorg, _, err = streamer.countDuplicateMessages(0, later, &batch)
+	Require(t, err)
+	if dups != 1 || reorg || batch == nil {
+		Fail(t, "custom comparator not applied", dups, reorg)
+	}
+	Require(t, batch.Write())
+	stored, err := streamer.GetMessage(0)
+	Require(t, err)
+	if stored.Message.Header.Timestamp != 2 {
+		Fail(t, "stored message not updated", stored.Message.Header.Timestamp)
+	}
+
+	// Both rules apply to the same pair of messages
+	dups, reorg, _, err = streamer.countDuplicateMessages(0, []arbostypes.MessageWithMetadataAndBlockHash{message(1, &gasCost)}, nil)
+	Require(t, err)
+	if dups != 1 || reorg {
+		Fail(t, "differences tolerated by two comparators treated as a reorg", dups, reorg)
+	}
+}
@@ -0,0 +1,371 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package arbnode
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/rlp"
+
+	"github.com/offchainlabs/nitro/arbos/arbostypes"
+	"github.com/offchainlabs/nitro/arbutil"
+	"github.com/offchainlabs/nitro/util/dbutil"
+)
+
+// Number of compared messages between checks of the context
+const messageDiffContextCheckInterval = 1024
+
+// MessageDiffSource is a sequence of messages in increasing position order, such as a streamer database
+// or a message export, that DiffMessages compares position by position
+type MessageDiffSource interface {
+	// Next returns the next message, or io.EOF after the last one
+	Next() (*MessageExportEntry, error)
+}
+
+// DatabaseDiffSource reads the messages of a streamer database
+type DatabaseDiffSource struct {
+	db    ethdb.Database
+	iter  ethdb.Iterator
+	count arbutil.MessageIndex
+}
+
+// NewDatabaseDiffSource reads the messages of a streamer database from start up to its message count.
+// Pruned messages are skipped. Block hashes are read as stored, without the trustless replica filter.
+// Close must be called once the source isn't used anymore.
+func NewDatabaseDiffSource(db ethdb.Database, start arbutil.MessageIndex) (*DatabaseDiffSource, error) {
+	var count arbutil.MessageIndex
+	data, err := db.Get(messageCountKey)
+	if err != nil && !dbutil.IsErrNotFound(err) {
+		return nil, err
+	}
+	if err == nil {
+		if err := rlp.DecodeBytes(data, &count); err != nil {
+			return nil, err
+		}
+	}
+	return &DatabaseDiffSource{
+		db:    db,
+		iter:  db.NewIterator(messagePrefix, uint64ToKey(uint64(start))),
+		count: count,
+	}, nil
+}
+
+func (d *DatabaseDiffSource) Next() (*MessageExportEntry, error) {
+	for d.iter.Next() {
+		key := d.iter.Key()
+		if len(key) != len(messagePrefix)+8 {
+			continue
+		}
+		pos := binary.BigEndian.Uint64(key[len(messagePrefix):])
+		if pos >= uint64(d.count) {
+			break
+		}
+		entry := &MessageExportEntry{
+			Pos:     pos,
+			Message: common.CopyBytes(d.iter.Value()),
+		}
+		data, err := d.db.Get(dbKey(blockHashInputFeedPrefix, pos))
+		if err != nil && !dbutil.IsErrNotFound(err) {
+			return nil, err
+		}
+		if err == nil {
+			var blockHashDBVal blockHashDBValue
+			if err := rlp.DecodeBytes(data, &blockHashDBVal); err != nil {
+				return nil, err
+			}
+			entry.BlockHash = blockHashDBVal.BlockHash
+		}
+		return entry, nil
+	}
+	if err := d.iter.Error(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+func (d *DatabaseDiffSource) Close() {
+	d.iter.Release()
+}
+
+type messageStreamDiffSource struct {
+	r         *bufio.Reader
+	next      uint64
+	remaining uint64
+}
+
+// NewMessageStreamDiffSource reads the messages of a message stream written by ExportMessages
+func NewMessageStreamDiffSource(r io.Reader) (MessageDiffSource, error) {
+	br := bufio.NewReader(r)
+	start, count, err := readMessageStreamHeader(br)
+	if err != nil {
+		return nil, err
+	}
+	return &messageStreamDiffSource{r: br, next: uint64(start), remaining: count}, nil
+}
+
+func (m *messageStreamDiffSource) Next() (*MessageExportEntry, error) {
+	if m.remaining == 0 {
+		return nil, io.EOF
+	}
+	entry, err := readMessageStreamEntry(m.r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read message %d of the message stream: %w", m.next, err)
+	}
+	if entry.Pos != m.next {
+		return nil, fmt.Errorf("%w: entry has position %d, expected %d", ErrMessageStreamCorrupt, entry.Pos, m.next)
+	}
+	m.next++
+	m.remaining--
+	return entry, nil
+}
+
+type messageExportDiffSource struct {
+	export *MessageExportRange
+	next   int
+}
+
+// NewMessageExportDiffSource reads the messages of a message export range, such as a message backup object
+func NewMessageExportDiffSource(export *MessageExportRange) MessageDiffSource {
+	return &messageExportDiffSource{export: export}
+}
+
+func (m *messageExportDiffSource) Next() (*MessageExportEntry, error) {
+	if m.next >= len(m.export.Messages) {
+		return nil, io.EOF
+	}
+	entry := &m.export.Messages[m.next]
+	m.next++
+	return entry, nil
+}
+
+// MessageFieldDiff is a field of a message that differs between the two compared sources
+type MessageFieldDiff struct {
+	Field string `json:"field"`
+	A     string `json:"a"`
+	B     string `json:"b"`
+}
+
+// MessageDiffRange is a range of consecutive positions whose messages differ between the two sources.
+// Fields holds the field-level diff of the first message of the range, where the divergence starts.
+type MessageDiffRange struct {
+	Start  uint64             `json:"start"`
+	End    uint64             `json:"end"`
+	Fields []MessageFieldDiff `json:"fields"`
+}
+
+// MessageDiffReport is the result of comparing two message sources. Positions held by only one of the
+// sources aren't compared, and are only reflected by the position ranges of the sources.
+type MessageDiffReport struct {
+	// Position ranges [start, end) of the messages read from each source, nil if a source is empty
+	A *MessageDiffPositions `json:"a"`
+	B *MessageDiffPositions `json:"b"`
+	// Number of positions held by both sources
+	Compared uint64             `json:"compared"`
+	Ranges   []MessageDiffRange `json:"ranges"`
+	// Set when the comparison stopped after the maximum number of divergent ranges
+	Truncated bool `json:"truncated"`
+}
+
+type MessageDiffPositions struct {
+	Start uint64 `json:"start"`
+	End   uint64 `json:"end"`
+}
+
+func addMessageDiffPosition(positions **MessageDiffPositions, pos uint64) {
+	if *positions == nil {
+		*positions = &MessageDiffPositions{Start: pos, End: pos + 1}
+		return
+	}
+	(*positions).End = pos + 1
+}
+
+// messageDiffFields decodes a message into its named fields, in a fixed order
+func messageDiffFields(entry *MessageExportEntry) ([][2]string, error) {
+	var msg arbostypes.MessageWithMetadata
+	if err := rlp.DecodeBytes(entry.Message, &msg); err != nil {
+		return nil, err
+	}
+	fields := [][2]string{{"delayedMessagesRead", fmt.Sprint(msg.DelayedMessagesRead)}}
+	if msg.Message == nil {
+		return append(fields, [2]string{"message", "nil"}), nil
+	}
+	l1Msg := msg.Message
+	if header := l1Msg.Header; header == nil {
+		fields = append(fields, [2]string{"header", "nil"})
+	} else {
+		requestId, l1BaseFee := "nil", "nil"
+		if header.RequestId != nil {
+			requestId = header.RequestId.Hex()
+		}
+		if header.L1BaseFee != nil {
+			l1BaseFee = header.L1BaseFee.String()
+		}
+		fields = append(fields,
+			[2]string{"header.kind", fmt.Sprint(header.Kind)},
+			[2]string{"header.poster", header.Poster.Hex()},
+			[2]string{"header.blockNumber", fmt.Sprint(header.BlockNumber)},
+			[2]string{"header.timestamp", fmt.Sprint(header.Timestamp)},
+			[2]string{"header.requestId", requestId},
+			[2]string{"header.l1BaseFee", l1BaseFee},
+		)
+	}
+	fields = append(fields, [2]string{"l2Msg", fmt.Sprintf("%d bytes, hash %v", len(l1Msg.L2msg), crypto.Keccak256Hash(l1Msg.L2msg))})
+	batchGasCost := "nil"
+	if l1Msg.BatchGasCost != nil {
+		batchGasCost = fmt.Sprint(*l1Msg.BatchGasCost)
+	}
+	return append(fields, [2]string{"batchGasCost", batchGasCost}), nil
+}
+
+// diffMessageEntries returns the fields that differ between two messages at the same position, or nil if they match.
+// Block hashes are only compared when both sources have one, as they're only stored for messages from the feed.
+func diffMessageEntries(a *MessageExportEntry, b *MessageExportEntry) []MessageFieldDiff {
+	var diffs []MessageFieldDiff
+	if !bytes.Equal(a.Message, b.Message) {
+		aFields, aErr := messageDiffFields(a)
+		bFields, bErr := messageDiffFields(b)
+		if aErr != nil || bErr != nil || len(aFields) != len(bFields) {
+			describe := func(fields [][2]string, err error) string {
+				if err != nil {
+					return fmt.Sprintf("undecodable: %v", err)
+				}
+				return fmt.Sprint(fields)
+			}
+			diffs = append(diffs, MessageFieldDiff{Field: "message", A: describe(aFields, aErr), B: describe(bFields, bErr)})
+		} else {
+			for i := range aFields {
+				if aFields[i] != bFields[i] {
+					diffs = append(diffs, MessageFieldDiff{Field: aFields[i][0], A: aFields[i][1], B: bFields[i][1]})
+				}
+			}
+			if len(diffs) == 0 {
+				// Same message with a different encoding
+				diffs = append(diffs, MessageFieldDiff{Field: "encoding", A: fmt.Sprintf("%x", a.Message), B: fmt.Sprintf("%x", b.Message)})
+			}
+		}
+	}
+	if a.BlockHash != nil && b.BlockHash != nil && *a.BlockHash != *b.BlockHash {
+		diffs = append(diffs, MessageFieldDiff{Field: "blockHash", A: a.BlockHash.Hex(), B: b.BlockHash.Hex()})
+	}
+	return diffs
+}
+
+func nextDiffEntry(source MessageDiffSource, positions **MessageDiffPositions) (*MessageExportEntry, error) {
+	entry, err := source.Next()
+	if errors.Is(err, io.EOF) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if *positions != nil && entry.Pos < (*positions).End {
+		return nil, fmt.Errorf("message source went back from position %d to %d", (*positions).End-1, entry.Pos)
+	}
+	addMessageDiffPosition(positions, entry.Pos)
+	return entry, nil
+}
+
+// DiffMessages compares the messages of a and b at the positions both of them hold, and reports the ranges of
+// consecutive positions where they diverge with the field-level diff of the first message of each range.
+// It's used to investigate replica divergence, e.g. after an upgrade of the espresso integration.
+// The comparison stops after maxRanges divergent ranges, 0 means no limit.
+func DiffMessages(ctx context.Context, a MessageDiffSource, b MessageDiffSource, maxRanges int) (*MessageDiffReport, error) {
+	report := &MessageDiffReport{Ranges: []MessageDiffRange{}}
+	aEntry, err := nextDiffEntry(a, &report.A)
+	if err != nil {
+		return nil, err
+	}
+	bEntry, err := nextDiffEntry(b, &report.B)
+	if err != nil {
+		return nil, err
+	}
+	var current *MessageDiffRange
+	for aEntry != nil && bEntry != nil {
+		if aEntry.Pos < bEntry.Pos {
+			if aEntry, err = nextDiffEntry(a, &report.A); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if bEntry.Pos < aEntry.Pos {
+			if bEntry, err = nextDiffEntry(b, &report.B); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		report.Compared++
+		if report.Compared%messageDiffContextCheckInterval == 0 && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		pos := aEntry.Pos
+		if diffs := diffMessageEntries(aEntry, bEntry); len(diffs) > 0 {
+			if current != nil && current.End == pos {
+				current.End = pos + 1
+			} else {
+				if maxRanges > 0 && len(report.Ranges) >= maxRanges {
+					report.Truncated = true
+					return report, nil
+				}
+				report.Ranges = append(report.Ranges, MessageDiffRange{Start: pos, End: pos + 1, Fields: diffs})
+				current = &report.Ranges[len(report.Ranges)-1]
+			}
+		}
+		if aEntry, err = nextDiffEntry(a, &report.A); err != nil {
+			return nil, err
+		}
+		if bEntry, err = nextDiffEntry(b, &report.B); err != nil {
+			return nil, err
+		}
+	}
+	// Read the rest of the longer source for its position range
+	for aEntry != nil {
+		if aEntry, err = nextDiffEntry(a, &report.A); err != nil {
+			return nil, err
+		}
+	}
+	for bEntry != nil {
+		if bEntry, err = nextDiffEntry(b, &report.B); err != nil {
+			return nil, err
+		}
+	}
+	return report, nil
+}
+
+// WriteMessageDiffReport writes a report in a human readable form, for command line tools
+func WriteMessageDiffReport(w io.Writer, report *MessageDiffReport) error {
+	describe := func(positions *MessageDiffPositions) string {
+		if positions == nil {
+			return "no messages"
+		}
+		return fmt.Sprintf("messages [%d, %d)", positions.Start, positions.End)
+	}
+	if _, err := fmt.Fprintf(w, "a: %s\nb: %s\ncompared %d messages, %d divergent ranges\n", describe(report.A), describe(report.B), report.Compared, len(report.Ranges)); err != nil {
+		return err
+	}
+	for _, r := range report.Ranges {
+		if _, err := fmt.Fprintf(w, "diverged at [%d, %d):\n", r.Start, r.End); err != nil {
+			return err
+		}
+		for _, field := range r.Fields {
+			if _, err := fmt.Fprintf(w, "  %s: %s != %s\n", field.Field, field.A, field.B); err != nil {
+				return err
+			}
+		}
+	}
+	if report.Truncated {
+		if _, err := fmt.Fprintln(w, "stopped after the maximum number of divergent ranges"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
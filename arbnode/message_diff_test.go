@@ -0,0 +1,69 @@
+package arbnode
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/offchainlabs/nitro/arbos/arbostypes"
+)
+
+func TestDiffMessages(t *testing.T) {
+	ctx := context.Background()
+	newMessages := func(count uint64, diverged func(i uint64) bool) []arbostypes.MessageWithMetadata {
+		var messages []arbostypes.MessageWithMetadata
+		for i := uint64(0); i < count; i++ {
+			timestamp := i
+			if diverged(i) {
+				timestamp += 100
+			}
+			messages = append(messages, arbostypes.MessageWithMetadata{
+				Message:             &arbostypes.L1IncomingMessage{Header: &arbostypes.L1IncomingMessageHeader{Timestamp: timestamp}},
+				DelayedMessagesRead: 1,
+			})
+		}
+		return messages
+	}
+	a := newTestImportStreamer(t)
+	Require(t, a.AddMessages(0, true, newMessages(10, func(uint64) bool { return false })))
+	b := newTestImportStreamer(t)
+	Require(t, b.AddMessages(0, true, newMessages(8, func(i uint64) bool { return i == 2 || i == 3 || i == 6 })))
+
+	aSource, err := NewDatabaseDiffSource(a.db, 0)
+	Require(t, err)
+	defer aSource.Close()
+	var stream bytes.Buffer
+	Require(t, b.ExportMessages(&stream, 1, 8))
+	bSource, err := NewMessageStreamDiffSource(&stream)
+	Require(t, err)
+
+	report, err := DiffMessages(ctx, aSource, bSource, 0)
+	Require(t, err)
+	if report.A.Start != 0 || report.A.End != 10 || report.B.Start != 1 || report.B.End != 8 || report.Compared != 7 {
+		Fail(t, "unexpected positions", report.A, report.B, report.Compared)
+	}
+	if len(report.Ranges) != 2 || report.Ranges[0].Start != 2 || report.Ranges[0].End != 4 || report.Ranges[1].Start != 6 || report.Ranges[1].End != 7 {
+		Fail(t, "unexpected divergent ranges", report.Ranges)
+	}
+	fields := report.Ranges[0].Fields
+	if len(fields) != 1 || fields[0].Field != "header.timestamp" || fields[0].A != "2" || fields[0].B != "102" {
+		Fail(t, "unexpected field diff", fields)
+	}
+	var out strings.Builder
+	Require(t, WriteMessageDiffReport(&out, report))
+	if !strings.Contains(out.String(), "header.timestamp: 2 != 102") {
+		Fail(t, "unexpected report", out.String())
+	}
+
+	export, err := b.ExportMessageRange(0, 8)
+	Require(t, err)
+	aSource, err = NewDatabaseDiffSource(a.db, 0)
+	Require(t, err)
+	defer aSource.Close()
+	report, err = DiffMessages(ctx, aSource, NewMessageExportDiffSource(export), 1)
+	Require(t, err)
+	if len(report.Ranges) != 1 || !report.Truncated {
+		Fail(t, "diff not truncated to the first range", report.Ranges, report.Truncated)
+	}
+}
@@ -0,0 +1,75 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package arbnode
+
+import (
+	"bytes"
+	"encoding/binary"
+	"runtime"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/rlp"
+
+	"github.com/offchainlabs/nitro/arbos/arbostypes"
+	"github.com/offchainlabs/nitro/arbutil"
+)
+
+// Each duplicate check worker compares at least this many messages, smaller batches are compared on the calling
+// goroutine as the workers would cost more than they save
+const duplicateCheckMessagesPerWorker = 64
+
+// readStoredMessages returns the stored encodings of up to limit consecutive messages starting at pos with a single
+// iterator, stopping at the first message that isn't stored
+func (s *TransactionStreamer) readStoredMessages(pos arbutil.MessageIndex, limit int) ([][]byte, error) {
+	iter := s.db.NewIterator(messagePrefix, uint64ToKey(uint64(pos)))
+	defer iter.Release()
+	stored := make([][]byte, 0, limit)
+	for len(stored) < limit && iter.Next() {
+		key := iter.Key()
+		if len(key) != len(messagePrefix)+8 || binary.BigEndian.Uint64(key[len(messagePrefix):]) != uint64(pos)+uint64(len(stored)) {
+			break
+		}
+		stored = append(stored, common.CopyBytes(iter.Value()))
+	}
+	return stored, iter.Error()
+}
+
+type encodedMessageMatch struct {
+	equal bool
+	err   error
+}
+
+func duplicateCheckWorkers(messages int) int {
+	return max(1, min(runtime.GOMAXPROCS(0), messages/duplicateCheckMessagesPerWorker))
+}
+
+// matchEncodedMessages returns for each stored encoding whether it's byte for byte the encoding of the message at
+// the same index, splitting the messages between workers. The results are per message, so that the caller still
+// stops at the first divergence and ignores the errors after it.
+func matchEncodedMessages(stored [][]byte, messages []arbostypes.MessageWithMetadataAndBlockHash, workers int) []encodedMessageMatch {
+	matches := make([]encodedMessageMatch, len(stored))
+	match := func(start, end int) {
+		for i := start; i < end; i++ {
+			want, err := rlp.EncodeToBytes(messages[i].MessageWithMeta)
+			matches[i] = encodedMessageMatch{equal: err == nil && bytes.Equal(stored[i], want), err: err}
+		}
+	}
+	if workers <= 1 || len(stored) < 2 {
+		match(0, len(stored))
+		return matches
+	}
+	chunk := (len(stored) + workers - 1) / workers
+	var wg sync.WaitGroup
+	for start := 0; start < len(stored); start += chunk {
+		end := min(start+chunk, len(stored))
+		wg.Add(1)
+		go func(start, end int) {
+			defer wg.Done()
+			match(start, end)
+		}(start, end)
+	}
+	wg.Wait()
+	return matches
+}
@@ -0,0 +1,77 @@
+package arbnode
+
+import (
+	"fmt"
+	"runtime"
+	"testing"
+
+	"github.com/offchainlabs/nitro/arbos/arbostypes"
+)
+
+func withBlockHashes(messages []arbostypes.MessageWithMetadata) []arbostypes.MessageWithMetadataAndBlockHash {
+	res := make([]arbostypes.MessageWithMetadataAndBlockHash, 0, len(messages))
+	for _, msg := range messages {
+		res = append(res, arbostypes.MessageWithMetadataAndBlockHash{MessageWithMeta: msg})
+	}
+	return res
+}
+
+func TestCountDuplicateMessagesParallel(t *testing.T) {
+	streamer := newTestImportStreamer(t)
+	Require(t, streamer.AddMessages(0, true, testChainMessages(0, 1000)))
+
+	// Past the stored messages
+	messages := withBlockHashes(testChainMessages(100, 1100))
+	dups, reorg, _, err := streamer.countDuplicateMessages(100, messages, nil)
+	Require(t, err)
+	if dups != 900 || reorg {
+		Fail(t, "unexpected duplicates of a batch going past the stored messages", dups, reorg)
+	}
+
+	// The first divergence is reported, even with later ones checked by other workers
+	messages = withBlockHashes(testChainMessages(0, 1000))
+	messages[700].MessageWithMeta.Message = &arbostypes.L1IncomingMessage{Header: &arbostypes.L1IncomingMessageHeader{Timestamp: 12345}}
+	messages[300].MessageWithMeta.DelayedMessagesRead = 2
+	dups, reorg, oldMsg, err := streamer.countDuplicateMessages(0, messages, nil)
+	Require(t, err)
+	if dups != 300 || !reorg || oldMsg == nil || oldMsg.Message.Header.Timestamp != 300 {
+		Fail(t, "unexpected first divergence", dups, reorg, oldMsg)
+	}
+	for _, workers := range []int{1, 3, 16} {
+		stored, err := streamer.readStoredMessages(0, len(messages))
+		Require(t, err)
+		matches := matchEncodedMessages(stored, messages, workers)
+		for i, match := range matches {
+			if match.err != nil || match.equal != (i != 300 && i != 700) {
+				Fail(t, "unexpected match with", workers, "workers at", i, match.equal, match.err)
+			}
+		}
+	}
+}
+
+func BenchmarkCountDuplicateMessages(b *testing.B) {
+	const messageCount = 4096
+	streamer := newTestStreamer(b, nil, nil)
+	if err := streamer.AddMessages(0, true, testChainMessages(0, messageCount)); err != nil {
+		b.Fatal(err)
+	}
+	messages := withBlockHashes(testChainMessages(0, messageCount))
+	stored, err := streamer.readStoredMessages(0, messageCount)
+	if err != nil {
+		b.Fatal(err)
+	}
+	for _, workers := range []int{1, runtime.GOMAXPROCS(0)} {
+		b.Run(fmt.Sprintf("compare/workers=%d", workers), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				matchEncodedMessages(stored, messages, workers)
+			}
+		})
+	}
+	b.Run("count", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if dups, _, _, err := streamer.countDuplicateMessages(0, messages, nil); err != nil || dups != messageCount {
+				b.Fatal("unexpected duplicates", dups, err)
+			}
+		}
+	})
+}
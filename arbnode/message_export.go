@@ -0,0 +1,278 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package arbnode
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/rlp"
+
+	"github.com/offchainlabs/nitro/arbos/arbostypes"
+	"github.com/offchainlabs/nitro/arbutil"
+	"github.com/offchainlabs/nitro/util/dbutil"
+)
+
+const messageExportVersion uint64 = 1
+
+const (
+	messageStreamVersion uint64 = 1
+	// Upper bound on the size of an entry of a message stream, larger entries are rejected as corrupt
+	messageStreamMaxEntrySize = 1 << 30
+	// Number of imported messages added to the database at once
+	messageImportChunkSize = 1024
+)
+
+var (
+	messageStreamMagic = []byte("NITROMSG")
+	messageStreamTable = crc32.MakeTable(crc32.Castagnoli)
+
+	ErrMessageStreamCorrupt = errors.New("corrupt message stream")
+)
+
+// MessageExportEntry is a single message in the export format.
+// Message holds the RLP encoded MessageWithMetadata exactly as stored in the database.
+type MessageExportEntry struct {
+	Pos       uint64
+	Message   []byte
+	BlockHash *common.Hash `rlp:"nil"`
+}
+
+// MessageExportRange is a contiguous range of messages in the export format.
+type MessageExportRange struct {
+	Version  uint64
+	Start    uint64
+	Messages []MessageExportEntry
+}
+
+func (r *MessageExportRange) End() uint64 {
+	return r.Start + uint64(len(r.Messages))
+}
+
+func (s *TransactionStreamer) readBlockHashInputFeed(pos arbutil.MessageIndex) (*common.Hash, error) {
+	data, err := s.db.Get(dbKey(blockHashInputFeedPrefix, uint64(pos)))
+	if err != nil {
+		if dbutil.IsErrNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var blockHashDBVal blockHashDBValue
+	err = rlp.DecodeBytes(data, &blockHashDBVal)
+	if err != nil {
+		return nil, err
+	}
+	return s.trustedFeedBlockHash(pos, blockHashDBVal.BlockHash)
+}
+
+func (s *TransactionStreamer) exportMessageEntry(pos arbutil.MessageIndex) (*MessageExportEntry, error) {
+	msgBytes, err := s.db.Get(dbKey(messagePrefix, uint64(pos)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read message %d for export: %w", pos, err)
+	}
+	blockHash, err := s.readBlockHashInputFeed(pos)
+	if err != nil {
+		return nil, err
+	}
+	return &MessageExportEntry{
+		Pos:       uint64(pos),
+		Message:   msgBytes,
+		BlockHash: blockHash,
+	}, nil
+}
+
+// ExportMessageRange returns the messages in [start, end) in the export format.
+func (s *TransactionStreamer) ExportMessageRange(start arbutil.MessageIndex, end arbutil.MessageIndex) (*MessageExportRange, error) {
+	if end < start {
+		return nil, fmt.Errorf("invalid export range [%d, %d)", start, end)
+	}
+	export := &MessageExportRange{
+		Version:  messageExportVersion,
+		Start:    uint64(start),
+		Messages: make([]MessageExportEntry, 0, end-start),
+	}
+	for pos := start; pos < end; pos++ {
+		entry, err := s.exportMessageEntry(pos)
+		if err != nil {
+			return nil, err
+		}
+		export.Messages = append(export.Messages, *entry)
+	}
+	return export, nil
+}
+
+func EncodeMessageExport(export *MessageExportRange) ([]byte, error) {
+	return rlp.EncodeToBytes(export)
+}
+
+func DecodeMessageExport(data []byte) (*MessageExportRange, error) {
+	var export MessageExportRange
+	if err := rlp.DecodeBytes(data, &export); err != nil {
+		return nil, err
+	}
+	if export.Version != messageExportVersion {
+		return nil, fmt.Errorf("unsupported message export version %d", export.Version)
+	}
+	for i, entry := range export.Messages {
+		if entry.Pos != export.Start+uint64(i) {
+			return nil, fmt.Errorf("non-contiguous message export: entry %d has position %d, expected %d", i, entry.Pos, export.Start+uint64(i))
+		}
+	}
+	return &export, nil
+}
+
+// ExportMessages writes the messages in [from, to) to w as a message stream, which ImportMessages reads to seed
+// the message database of another node. The stream starts with a magic, the version, the first position and
+// the number of messages, followed by one RLP encoded MessageExportEntry per message, each prefixed with its
+// length and followed by its CRC-32C checksum.
+func (s *TransactionStreamer) ExportMessages(w io.Writer, from arbutil.MessageIndex, to arbutil.MessageIndex) error {
+	if to < from {
+		return fmt.Errorf("invalid export range [%d, %d)", from, to)
+	}
+	bw := bufio.NewWriter(w)
+	header := make([]byte, 0, len(messageStreamMagic)+24)
+	header = append(header, messageStreamMagic...)
+	header = binary.BigEndian.AppendUint64(header, messageStreamVersion)
+	header = binary.BigEndian.AppendUint64(header, uint64(from))
+	header = binary.BigEndian.AppendUint64(header, uint64(to-from))
+	if _, err := bw.Write(header); err != nil {
+		return err
+	}
+	for pos := from; pos < to; pos++ {
+		entry, err := s.exportMessageEntry(pos)
+		if err != nil {
+			return err
+		}
+		data, err := rlp.EncodeToBytes(entry)
+		if err != nil {
+			return err
+		}
+		record := make([]byte, 0, len(data)+8)
+		// #nosec G115
+		record = binary.BigEndian.AppendUint32(record, uint32(len(data)))
+		record = append(record, data...)
+		record = binary.BigEndian.AppendUint32(record, crc32.Checksum(data, messageStreamTable))
+		if _, err := bw.Write(record); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// readMessageStreamHeader returns the first position and the number of messages of a message stream
+func readMessageStreamHeader(r io.Reader) (arbutil.MessageIndex, uint64, error) {
+	header := make([]byte, len(messageStreamMagic)+24)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, 0, fmt.Errorf("failed to read the message stream header: %w", err)
+	}
+	if !bytes.Equal(header[:len(messageStreamMagic)], messageStreamMagic) {
+		return 0, 0, fmt.Errorf("%w: not a message stream", ErrMessageStreamCorrupt)
+	}
+	header = header[len(messageStreamMagic):]
+	if version := binary.BigEndian.Uint64(header); version != messageStreamVersion {
+		return 0, 0, fmt.Errorf("unsupported message stream version %d", version)
+	}
+	return arbutil.MessageIndex(binary.BigEndian.Uint64(header[8:])), binary.BigEndian.Uint64(header[16:]), nil
+}
+
+func readMessageStreamEntry(r io.Reader) (*MessageExportEntry, error) {
+	var size [4]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return nil, err
+	}
+	length := binary.BigEndian.Uint32(size[:])
+	if length > messageStreamMaxEntrySize {
+		return nil, fmt.Errorf("%w: entry of %d bytes", ErrMessageStreamCorrupt, length)
+	}
+	data := make([]byte, length+4)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	checksum := binary.BigEndian.Uint32(data[length:])
+	data = data[:length]
+	if crc32.Checksum(data, messageStreamTable) != checksum {
+		return nil, fmt.Errorf("%w: checksum mismatch", ErrMessageStreamCorrupt)
+	}
+	var entry MessageExportEntry
+	if err := rlp.DecodeBytes(data, &entry); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrMessageStreamCorrupt, err)
+	}
+	return &entry, nil
+}
+
+// ImportMessages reads a message stream written by ExportMessages and adds its messages to the database as
+// confirmed messages. The stream must start at or before the current message count, and messages already
+// stored must match the imported ones. Returns the message count after the import.
+func (s *TransactionStreamer) ImportMessages(r io.Reader) (arbutil.MessageIndex, error) {
+	if s.readOnly {
+		return 0, ErrReadOnly
+	}
+	br := bufio.NewReader(r)
+	start, count, err := readMessageStreamHeader(br)
+	if err != nil {
+		return 0, err
+	}
+
+	msgCount, err := s.GetMessageCount()
+	if err != nil {
+		return 0, err
+	}
+	if start > msgCount {
+		return 0, fmt.Errorf("message stream starts at %d, after the message count %d", start, msgCount)
+	}
+
+	pos := start
+	var messages []arbostypes.MessageWithMetadataAndBlockHash
+	for i := uint64(0); i < count; i++ {
+		entry, err := readMessageStreamEntry(br)
+		if err != nil {
+			return 0, fmt.Errorf("failed to read message %d of the message stream: %w", uint64(start)+i, err)
+		}
+		if entry.Pos != uint64(start)+i {
+			return 0, fmt.Errorf("%w: entry %d has position %d", ErrMessageStreamCorrupt, i, entry.Pos)
+		}
+		var message arbostypes.MessageWithMetadata
+		if err := rlp.DecodeBytes(entry.Message, &message); err != nil {
+			return 0, fmt.Errorf("%w: message %d: %w", ErrMessageStreamCorrupt, entry.Pos, err)
+		}
+		messages = append(messages, arbostypes.MessageWithMetadataAndBlockHash{
+			MessageWithMeta: message,
+			BlockHash:       entry.BlockHash,
+		})
+		if len(messages) == messageImportChunkSize || i+1 == count {
+			if err := s.addImportedMessages(pos, messages); err != nil {
+				return 0, err
+			}
+			// #nosec G115
+			pos += arbutil.MessageIndex(len(messages))
+			messages = messages[:0]
+		}
+	}
+	return s.GetMessageCount()
+}
+
+func (s *TransactionStreamer) addImportedMessages(pos arbutil.MessageIndex, messages []arbostypes.MessageWithMetadataAndBlockHash) error {
+	if err := s.lockInsertion(); err != nil {
+		return err
+	}
+	defer s.insertionMutex.Unlock()
+	_, reorg, _, err := s.countDuplicateMessages(pos, messages, nil)
+	if err != nil {
+		return err
+	}
+	if reorg {
+		return fmt.Errorf("imported messages starting at %d conflict with the stored messages", pos)
+	}
+	if s.exec != nil {
+		// #nosec G115
+		s.exec.MarkFeedStart(pos + arbutil.MessageIndex(len(messages)))
+	}
+	return s.addMessagesAndEndBatchImpl(pos, true, messages, nil)
+}
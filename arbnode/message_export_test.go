@@ -0,0 +1,81 @@
+package arbnode
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/params"
+
+	"github.com/offchainlabs/nitro/arbos/arbostypes"
+)
+
+// newTestStreamer returns a streamer over db, or a new memory database if it's nil, running with a copy of the test
+// config changed by configure if it's set, and with the options
+func newTestStreamer(t testing.TB, db ethdb.Database, configure func(*TransactionStreamerConfig), opts ...TransactionStreamerOption) *TransactionStreamer {
+	t.Helper()
+	if db == nil {
+		db = rawdb.NewMemoryDatabase()
+	}
+	config := TestTransactionStreamerConfig
+	if configure != nil {
+		configure(&config)
+	}
+	opts = append([]TransactionStreamerOption{WithConfig(func() *TransactionStreamerConfig { return &config })}, opts...)
+	streamer, err := NewTransactionStreamerWithOptions(db, &params.ChainConfig{ChainID: big.NewInt(412346)}, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return streamer
+}
+
+func newTestImportStreamer(t *testing.T) *TransactionStreamer {
+	return newTestStreamer(t, nil, nil)
+}
+
+func TestExportImportMessages(t *testing.T) {
+	source := newTestImportStreamer(t)
+	var messages []arbostypes.MessageWithMetadata
+	for i := uint64(0); i < 5; i++ {
+		messages = append(messages, arbostypes.MessageWithMetadata{
+			Message:             &arbostypes.L1IncomingMessage{Header: &arbostypes.L1IncomingMessageHeader{Timestamp: i}},
+			DelayedMessagesRead: 1,
+		})
+	}
+	Require(t, source.AddMessages(0, true, messages))
+
+	var stream bytes.Buffer
+	Require(t, source.ExportMessages(&stream, 0, 5))
+	data := stream.Bytes()
+
+	target := newTestImportStreamer(t)
+	count, err := target.ImportMessages(bytes.NewReader(data))
+	Require(t, err)
+	if count != 5 {
+		Fail(t, "unexpected message count after import", count)
+	}
+	msg, err := target.GetMessage(3)
+	Require(t, err)
+	if msg.Message.Header.Timestamp != 3 {
+		Fail(t, "unexpected imported message", msg.Message.Header.Timestamp)
+	}
+	// Importing the same messages again is a no-op
+	_, err = target.ImportMessages(bytes.NewReader(data))
+	Require(t, err)
+
+	// A partial stream after the message count leaves a gap
+	stream.Reset()
+	Require(t, source.ExportMessages(&stream, 3, 5))
+	if _, err := newTestImportStreamer(t).ImportMessages(&stream); err == nil {
+		Fail(t, "imported messages after a gap")
+	}
+
+	corrupt := bytes.Clone(data)
+	corrupt[len(corrupt)-6] ^= 0xff
+	if _, err := newTestImportStreamer(t).ImportMessages(bytes.NewReader(corrupt)); !errors.Is(err, ErrMessageStreamCorrupt) {
+		Fail(t, "expected a corrupt stream, got", err)
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package arbnode
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/log"
+
+	"github.com/offchainlabs/nitro/arbos/arbostypes"
+	"github.com/offchainlabs/nitro/arbutil"
+)
+
+// ErrMessageIteratorReorged is returned by MessageIterator.Next when a reorg changed messages the iterator
+// already returned. The iterator is rewound to the first changed message, which Position returns.
+var ErrMessageIteratorReorged = errors.New("messages returned by the iterator were reorged")
+
+// messageSignal wakes any number of waiters when new messages are written, its zero value is ready to use
+type messageSignal struct {
+	mutex sync.Mutex
+	ch    chan struct{}
+}
+
+// wait returns a channel that's closed on the next notify
+func (m *messageSignal) wait() <-chan struct{} {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.ch == nil {
+		m.ch = make(chan struct{})
+	}
+	return m.ch
+}
+
+func (m *messageSignal) notify() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.ch != nil {
+		close(m.ch)
+		m.ch = nil
+	}
+}
+
+// MessageIterator yields the canonical messages of the streamer in order, for consumers like indexers
+// that follow the message stream. It isn't safe for concurrent use.
+type MessageIterator struct {
+	streamer *TransactionStreamer
+	start    arbutil.MessageIndex
+	next     arbutil.MessageIndex
+	// Number of reorgs recorded when the iterator last checked for reorgs
+	reorgCount uint64
+}
+
+// MessageIterator returns an iterator over the messages starting at start
+func (s *TransactionStreamer) MessageIterator(start arbutil.MessageIndex) (*MessageIterator, error) {
+	reorgCount, err := s.getReorgHistoryCount()
+	if err != nil {
+		return nil, err
+	}
+	return &MessageIterator{
+		streamer:   s,
+		start:      start,
+		next:       start,
+		reorgCount: reorgCount,
+	}, nil
+}
+
+// Position returns the position of the message the next call to Next returns
+func (it *MessageIterator) Position() arbutil.MessageIndex {
+	return it.next
+}
+
+// checkReorgs rewinds the iterator to the earliest position changed by the reorgs recorded since the last check
+func (it *MessageIterator) checkReorgs() error {
+	reorgCount, err := it.streamer.getReorgHistoryCount()
+	if err != nil {
+		return err
+	}
+	if reorgCount <= it.reorgCount {
+		return nil
+	}
+	newReorgs := reorgCount - it.reorgCount
+	records, err := it.streamer.GetReorgHistory(newReorgs)
+	if err != nil {
+		return err
+	}
+	it.reorgCount = reorgCount
+	rewindTo := it.next
+	if uint64(len(records)) < newReorgs {
+		log.Warn("reorg history incomplete, rewinding message iterator to its start", "start", it.start, "missingRecords", newReorgs-uint64(len(records)))
+		rewindTo = min(rewindTo, it.start)
+	}
+	for _, record := range records {
+		rewindTo = min(rewindTo, arbutil.MessageIndex(record.Position))
+	}
+	if rewindTo < it.next {
+		it.next = rewindTo
+		return ErrMessageIteratorReorged
+	}
+	return nil
+}
+
+// Next blocks until the message at the iterator's position is stored, and returns it with its position.
+// If a reorg changed messages that were already returned, ErrMessageIteratorReorged is returned instead,
+// and the following calls continue from the first changed message.
+func (it *MessageIterator) Next(ctx context.Context) (arbutil.MessageIndex, *arbostypes.MessageWithMetadata, error) {
+	s := it.streamer
+	for {
+		// Taken before reading the message count, so that a message written in between isn't missed
+		newMessage := s.newMessageSignal.wait()
+		if err := it.checkReorgs(); err != nil {
+			return 0, nil, err
+		}
+		count, err := s.GetMessageCount()
+		if err != nil {
+			return 0, nil, err
+		}
+		if it.next < count {
+			pos := it.next
+			msg, err := s.GetMessage(pos)
+			// A reorg between reading the count and the message may have removed or replaced it
+			if reorgErr := it.checkReorgs(); reorgErr != nil {
+				return 0, nil, reorgErr
+			}
+			if err != nil {
+				return 0, nil, err
+			}
+			it.next++
+			return pos, msg, nil
+		}
+		select {
+		case <-newMessage:
+		case <-ctx.Done():
+			return 0, nil, ctx.Err()
+		}
+	}
+}
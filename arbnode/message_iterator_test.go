@@ -0,0 +1,71 @@
+package arbnode
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/offchainlabs/nitro/arbos/arbostypes"
+)
+
+func TestMessageIterator(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	streamer := newTestImportStreamer(t)
+	message := func(i uint64) arbostypes.MessageWithMetadata {
+		return arbostypes.MessageWithMetadata{
+			Message:             &arbostypes.L1IncomingMessage{Header: &arbostypes.L1IncomingMessageHeader{Timestamp: i}},
+			DelayedMessagesRead: 1,
+		}
+	}
+	Require(t, streamer.AddMessages(0, true, []arbostypes.MessageWithMetadata{message(0), message(1), message(2)}))
+
+	iter, err := streamer.MessageIterator(1)
+	Require(t, err)
+	expectNext := func(expected uint64) {
+		t.Helper()
+		pos, msg, err := iter.Next(ctx)
+		Require(t, err)
+		if uint64(pos) != expected || msg.Message.Header.Timestamp != expected {
+			Fail(t, "unexpected message at", pos, "expected", expected)
+		}
+	}
+	expectNext(1)
+	expectNext(2)
+
+	// Next blocks until the next message is written
+	added := make(chan error, 1)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		added <- streamer.AddMessages(3, true, []arbostypes.MessageWithMetadata{message(3)})
+	}()
+	expectNext(3)
+	Require(t, <-added)
+
+	waitCtx, waitCancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer waitCancel()
+	if _, _, err := iter.Next(waitCtx); !errors.Is(err, context.DeadlineExceeded) {
+		Fail(t, "expected Next to block without new messages, got", err)
+	}
+
+	// A reorg of a returned message rewinds the iterator
+	batch := streamer.db.NewBatch()
+	Require(t, streamer.recordReorg(batch, ReorgSourceFeed, 2, 2, 0))
+	Require(t, batch.Write())
+	if _, _, err := iter.Next(ctx); !errors.Is(err, ErrMessageIteratorReorged) {
+		Fail(t, "expected a reorg error, got", err)
+	}
+	if iter.Position() != 2 {
+		Fail(t, "iterator not rewound to the reorg", iter.Position())
+	}
+	expectNext(2)
+	expectNext(3)
+
+	// Reorgs after the iterator's position don't affect it
+	batch = streamer.db.NewBatch()
+	Require(t, streamer.recordReorg(batch, ReorgSourceFeed, 4, 0, 0))
+	Require(t, batch.Write())
+	Require(t, streamer.AddMessages(4, true, []arbostypes.MessageWithMetadata{message(4)}))
+	expectNext(4)
+}
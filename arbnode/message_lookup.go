@@ -0,0 +1,258 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package arbnode
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"math"
+	"time"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/rlp"
+
+	"github.com/offchainlabs/nitro/arbos/arbostypes"
+	"github.com/offchainlabs/nitro/arbutil"
+	"github.com/offchainlabs/nitro/util/dbutil"
+)
+
+var messageLookupBackfillGauge = metrics.NewRegisteredGauge("arb/streamer/lookup/backfill", nil)
+
+const (
+	// Number of messages added to the lookup indexes per backfill iteration
+	messageLookupBackfillBatch = 1000
+	// How long to wait before retrying a failed backfill iteration
+	messageLookupBackfillRetryInterval = 10 * time.Second
+)
+
+// Messages are indexed by their header timestamp and L1 block number. An index entry has no value, its key is
+// the prefix, the timestamp or block number and the message position, so that the messages in a range of
+// timestamps or blocks are found with a single iteration. The entries of reorged and pruned messages are deleted
+// along with the messages, and the messages stored before the indexes were kept are backfilled in the background.
+
+// messageLookupBackfill is the range of messages stored before they were added to the lookup indexes
+type messageLookupBackfill struct {
+	Next arbutil.MessageIndex
+	End  arbutil.MessageIndex
+}
+
+func messageLookupKey(prefix []byte, value uint64, pos arbutil.MessageIndex) []byte {
+	return append(dbKey(prefix, value), uint64ToKey(uint64(pos))...)
+}
+
+// parseMessageLookupKey returns the indexed value and the message position of a lookup index entry
+func parseMessageLookupKey(prefix []byte, key []byte) (uint64, arbutil.MessageIndex, bool) {
+	key = bytes.TrimPrefix(key, prefix)
+	if len(key) != 16 {
+		return 0, 0, false
+	}
+	return binary.BigEndian.Uint64(key[:8]), arbutil.MessageIndex(binary.BigEndian.Uint64(key[8:])), true
+}
+
+// writeMessageLookup adds the message at pos to the lookup indexes
+func writeMessageLookup(batch ethdb.KeyValueWriter, pos arbutil.MessageIndex, msg *arbostypes.MessageWithMetadata) error {
+	if msg.Message == nil || msg.Message.Header == nil {
+		return nil
+	}
+	header := msg.Message.Header
+	if err := batch.Put(messageLookupKey(timestampLookupPrefix, header.Timestamp, pos), []byte{}); err != nil {
+		return err
+	}
+	return batch.Put(messageLookupKey(l1BlockLookupPrefix, header.BlockNumber, pos), []byte{})
+}
+
+// deleteMessageLookup removes the stored messages in [from, to) from the lookup indexes
+func (s *TransactionStreamer) deleteMessageLookup(ctx context.Context, batch ethdb.KeyValueWriter, from arbutil.MessageIndex, to arbutil.MessageIndex) error {
+	return s.forEachStoredMessage(ctx, from, to, func(pos arbutil.MessageIndex, _ []byte, msg *arbostypes.MessageWithMetadata) error {
+		return deleteMessageLookupEntries(batch, pos, msg)
+	})
+}
+
+func deleteMessageLookupEntries(batch ethdb.KeyValueWriter, pos arbutil.MessageIndex, msg *arbostypes.MessageWithMetadata) error {
+	if msg.Message == nil || msg.Message.Header == nil {
+		return nil
+	}
+	header := msg.Message.Header
+	if err := batch.Delete(messageLookupKey(timestampLookupPrefix, header.Timestamp, pos)); err != nil {
+		return err
+	}
+	return batch.Delete(messageLookupKey(l1BlockLookupPrefix, header.BlockNumber, pos))
+}
+
+// forEachStoredMessage calls fn with the encoded and decoded stored messages in [from, to), in order. The
+// messages are decoded as stored, without filling in their batch gas cost.
+func (s *TransactionStreamer) forEachStoredMessage(ctx context.Context, from arbutil.MessageIndex, to arbutil.MessageIndex, fn func(arbutil.MessageIndex, []byte, *arbostypes.MessageWithMetadata) error) error {
+	iter := s.db.NewIterator(messagePrefix, uint64ToKey(uint64(from)))
+	defer iter.Release()
+	for iter.Next() {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		pos := arbutil.MessageIndex(binary.BigEndian.Uint64(bytes.TrimPrefix(iter.Key(), messagePrefix)))
+		if pos >= to {
+			break
+		}
+		var msg arbostypes.MessageWithMetadata
+		if err := rlp.DecodeBytes(iter.Value(), &msg); err != nil {
+			return err
+		}
+		if err := fn(pos, iter.Value(), &msg); err != nil {
+			return err
+		}
+	}
+	return iter.Error()
+}
+
+// findMessageLookup returns the positions of the stored messages indexed under a value in [from, to], ordered by
+// value then position. At most limit positions are returned, or all of them if limit is 0.
+func (s *TransactionStreamer) findMessageLookup(prefix []byte, from uint64, to uint64, limit int) ([]arbutil.MessageIndex, error) {
+	first := s.firstStoredMessage()
+	count, err := s.GetMessageCount()
+	if err != nil {
+		return nil, err
+	}
+	iter := s.db.NewIterator(prefix, uint64ToKey(from))
+	defer iter.Release()
+	var positions []arbutil.MessageIndex
+	for iter.Next() && (limit == 0 || len(positions) < limit) {
+		value, pos, ok := parseMessageLookupKey(prefix, iter.Key())
+		if ok && value > to {
+			break
+		}
+		// Entries of messages pruned concurrently with the backfill may be left behind
+		if !ok || pos < first || pos >= count {
+			continue
+		}
+		positions = append(positions, pos)
+	}
+	return positions, iter.Error()
+}
+
+// FindMessageByTimestamp returns the position of the message with the lowest header timestamp at or after
+// timestamp, the first one if several have it, or nil if there's none. As message timestamps are
+// non-decreasing, this is the first message at or after timestamp.
+func (s *TransactionStreamer) FindMessageByTimestamp(timestamp uint64) (*arbutil.MessageIndex, error) {
+	positions, err := s.findMessageLookup(timestampLookupPrefix, timestamp, math.MaxUint64, 1)
+	if err != nil || len(positions) == 0 {
+		return nil, err
+	}
+	return &positions[0], nil
+}
+
+// FindMessagesByL1Block returns the positions of the messages with the L1 block number in their header, in order
+func (s *TransactionStreamer) FindMessagesByL1Block(blockNumber uint64) ([]arbutil.MessageIndex, error) {
+	return s.findMessageLookup(l1BlockLookupPrefix, blockNumber, blockNumber, 0)
+}
+
+func (s *TransactionStreamer) getMessageLookupBackfill() (*messageLookupBackfill, error) {
+	data, err := s.db.Get(messageLookupBackfillKey)
+	if err != nil {
+		if dbutil.IsErrNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var backfill messageLookupBackfill
+	if err := rlp.DecodeBytes(data, &backfill); err != nil {
+		return nil, err
+	}
+	return &backfill, nil
+}
+
+func setMessageLookupBackfill(batch ethdb.KeyValueWriter, backfill messageLookupBackfill) error {
+	data, err := rlp.EncodeToBytes(backfill)
+	if err != nil {
+		return err
+	}
+	return batch.Put(messageLookupBackfillKey, data)
+}
+
+// initMessageLookupBackfill records the messages to backfill into the lookup indexes the first time the streamer
+// is started with them, and returns whether a backfill is pending. Messages written by this process are already
+// indexed, indexing them again is harmless.
+func (s *TransactionStreamer) initMessageLookupBackfill() (bool, error) {
+	backfill, err := s.getMessageLookupBackfill()
+	if err != nil {
+		return false, err
+	}
+	if backfill == nil {
+		count, err := s.GetMessageCount()
+		if err != nil {
+			return false, err
+		}
+		backfill = &messageLookupBackfill{Next: s.firstStoredMessage(), End: count}
+		if err := setMessageLookupBackfill(s.db, *backfill); err != nil {
+			return false, err
+		}
+	}
+	return backfill.Next < backfill.End, nil
+}
+
+// backfillMessageLookup adds the next batch of messages stored before the lookup indexes were kept to them, and
+// returns whether the backfill is done. The insertion mutex is held, so that reorged messages aren't indexed.
+func (s *TransactionStreamer) backfillMessageLookup(ctx context.Context) (bool, error) {
+	if err := s.lockInsertion(); err != nil {
+		return false, err
+	}
+	defer s.insertionMutex.Unlock()
+	backfill, err := s.getMessageLookupBackfill()
+	if err != nil {
+		return false, err
+	}
+	if backfill == nil || backfill.Next >= backfill.End {
+		return true, nil
+	}
+	count, err := s.GetMessageCount()
+	if err != nil {
+		return false, err
+	}
+	// The messages replacing reorged ones were indexed when they were written
+	end := min(backfill.End, count)
+	start := max(backfill.Next, s.firstStoredMessage())
+	next := backfill.End
+	if start < end {
+		next = min(end, start+messageLookupBackfillBatch)
+	}
+	batch := s.db.NewBatch()
+	err = s.forEachStoredMessage(ctx, start, next, func(pos arbutil.MessageIndex, _ []byte, msg *arbostypes.MessageWithMetadata) error {
+		return writeMessageLookup(batch, pos, msg)
+	})
+	if err != nil {
+		return false, err
+	}
+	backfill.Next = next
+	if err := setMessageLookupBackfill(batch, *backfill); err != nil {
+		return false, err
+	}
+	if err := batch.Write(); err != nil {
+		return false, err
+	}
+	// #nosec G115
+	messageLookupBackfillGauge.Update(int64(next))
+	return next >= backfill.End, nil
+}
+
+func (s *TransactionStreamer) runMessageLookupBackfill(ctx context.Context) {
+	log.Info("backfilling the message lookup indexes")
+	for ctx.Err() == nil {
+		done, err := s.backfillMessageLookup(ctx)
+		if done {
+			log.Info("backfilled the message lookup indexes")
+			return
+		}
+		if err == nil {
+			continue
+		}
+		if ctx.Err() == nil {
+			log.Warn("error backfilling the message lookup indexes", "err", err)
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(messageLookupBackfillRetryInterval):
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package arbnode
+
+import (
+	"context"
+	"testing"
+
+	"github.com/offchainlabs/nitro/arbos/arbostypes"
+	"github.com/offchainlabs/nitro/arbutil"
+	"github.com/offchainlabs/nitro/validator"
+)
+
+func testLookupMessages(timestamps []uint64, blocks []uint64) []arbostypes.MessageWithMetadata {
+	var messages []arbostypes.MessageWithMetadata
+	for i := range timestamps {
+		messages = append(messages, arbostypes.MessageWithMetadata{
+			Message: &arbostypes.L1IncomingMessage{
+				Header: &arbostypes.L1IncomingMessageHeader{Timestamp: timestamps[i], BlockNumber: blocks[i]},
+			},
+		})
+	}
+	return messages
+}
+
+func requireLookup(t *testing.T, streamer *TransactionStreamer, timestamp uint64, expected *arbutil.MessageIndex) {
+	t.Helper()
+	pos, err := streamer.FindMessageByTimestamp(timestamp)
+	Require(t, err)
+	if (pos == nil) != (expected == nil) || (pos != nil && *pos != *expected) {
+		Fail(t, "unexpected message found for timestamp", timestamp, "got", pos, "expected", expected)
+	}
+}
+
+func requireBlockLookup(t *testing.T, streamer *TransactionStreamer, block uint64, expected ...arbutil.MessageIndex) {
+	t.Helper()
+	positions, err := streamer.FindMessagesByL1Block(block)
+	Require(t, err)
+	if len(positions) != len(expected) {
+		Fail(t, "unexpected messages found for block", block, "got", positions, "expected", expected)
+	}
+	for i := range positions {
+		if positions[i] != expected[i] {
+			Fail(t, "unexpected messages found for block", block, "got", positions, "expected", expected)
+		}
+	}
+}
+
+func TestMessageLookup(t *testing.T) {
+	streamer := newTestImportStreamer(t)
+	messages := testLookupMessages([]uint64{10, 20, 20, 30, 40, 50}, []uint64{1, 1, 2, 2, 2, 3})
+	Require(t, streamer.AddMessages(0, true, messages))
+
+	pos := func(p arbutil.MessageIndex) *arbutil.MessageIndex { return &p }
+	requireLookup(t, streamer, 0, pos(0))
+	requireLookup(t, streamer, 20, pos(1))
+	requireLookup(t, streamer, 21, pos(3))
+	requireLookup(t, streamer, 50, pos(5))
+	requireLookup(t, streamer, 51, nil)
+	requireBlockLookup(t, streamer, 1, 0, 1)
+	requireBlockLookup(t, streamer, 2, 2, 3, 4)
+	requireBlockLookup(t, streamer, 4)
+
+	// Dropped messages are removed from the indexes along with them
+	batch := streamer.db.NewBatch()
+	Require(t, streamer.deleteMessageLookup(context.Background(), batch, 4, 6))
+	Require(t, deleteStartingAt(streamer.db, batch, messagePrefix, uint64ToKey(4)))
+	Require(t, setMessageCount(batch, 4))
+	Require(t, batch.Write())
+	requireLookup(t, streamer, 31, nil)
+	requireBlockLookup(t, streamer, 2, 2, 3)
+	requireBlockLookup(t, streamer, 3)
+
+	// Pruned messages aren't found anymore
+	streamer.UpdateLatestConfirmed(2, validator.GoGlobalState{})
+	pruned, err := streamer.PruneMessagesBefore(context.Background(), 2)
+	Require(t, err)
+	if pruned != 2 {
+		Fail(t, "unexpected pruned count", pruned)
+	}
+	requireLookup(t, streamer, 0, pos(2))
+	requireBlockLookup(t, streamer, 1)
+	for _, prefix := range [][]byte{timestampLookupPrefix, l1BlockLookupPrefix} {
+		iter := streamer.db.NewIterator(prefix, nil)
+		for iter.Next() {
+			if _, p, _ := parseMessageLookupKey(prefix, iter.Key()); p < 2 || p >= 4 {
+				Fail(t, "lookup index entry left behind for message", p)
+			}
+		}
+		iter.Release()
+	}
+}
+
+func TestMessageLookupBackfill(t *testing.T) {
+	ctx := context.Background()
+	streamer := newTestImportStreamer(t)
+	count := messageLookupBackfillBatch + 10
+	timestamps := make([]uint64, count)
+	blocks := make([]uint64, count)
+	for i := range timestamps {
+		// #nosec G115
+		timestamps[i] = uint64(i)
+		// #nosec G115
+		blocks[i] = uint64(i / 100)
+	}
+	Require(t, streamer.AddMessages(0, true, testLookupMessages(timestamps, blocks)))
+
+	// Drop the indexes, as if the messages were stored before they were kept
+	batch := streamer.db.NewBatch()
+	Require(t, deleteStartingAt(streamer.db, batch, timestampLookupPrefix, nil))
+	Require(t, deleteStartingAt(streamer.db, batch, l1BlockLookupPrefix, nil))
+	Require(t, batch.Write())
+	requireLookup(t, streamer, 5, nil)
+
+	pending, err := streamer.initMessageLookupBackfill()
+	Require(t, err)
+	if !pending {
+		Fail(t, "backfill not pending")
+	}
+	done, err := streamer.backfillMessageLookup(ctx)
+	Require(t, err)
+	if done {
+		Fail(t, "backfill done after a single batch")
+	}
+	first, last := arbutil.MessageIndex(5), arbutil.MessageIndex(count-1)
+	requireLookup(t, streamer, 5, &first)
+	requireLookup(t, streamer, uint64(last), nil)
+	done, err = streamer.backfillMessageLookup(ctx)
+	Require(t, err)
+	if !done {
+		Fail(t, "backfill not done")
+	}
+	requireLookup(t, streamer, uint64(last), &last)
+	requireBlockLookup(t, streamer, 10, 1000, 1001, 1002, 1003, 1004, 1005, 1006, 1007, 1008, 1009)
+
+	// The backfill isn't started again
+	pending, err = streamer.initMessageLookupBackfill()
+	Require(t, err)
+	if pending {
+		Fail(t, "backfill pending again")
+	}
+}
@@ -133,6 +133,17 @@ func (m *MessagePruner) deleteOldMessagesFromDB(ctx context.Context, messageCoun
 		log.Info("Pruned expected block hashes:", "first pruned key", prunedKeysRange[0], "last pruned key", prunedKeysRange[len(prunedKeysRange)-1])
 	}
 
+	if messageCount > 1 {
+		batch := m.transactionStreamer.db.NewBatch()
+		err = m.transactionStreamer.recordPrunedMessages(ctx, batch, 1, messageCount-1)
+		if err == nil {
+			err = batch.Write()
+		}
+		if err != nil {
+			return fmt.Errorf("error recording the messages to prune: %w", err)
+		}
+	}
+
 	prunedKeysRange, err = deleteFromLastPrunedUptoEndKey(ctx, m.transactionStreamer.db, messagePrefix, &m.cachedPrunedMessages, uint64(messageCount))
 	if err != nil {
 		return fmt.Errorf("error deleting last batch messages: %w", err)
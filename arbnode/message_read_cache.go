@@ -0,0 +1,145 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package arbnode
+
+import (
+	"encoding/binary"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/rlp"
+
+	"github.com/offchainlabs/nitro/arbos/arbostypes"
+	"github.com/offchainlabs/nitro/arbutil"
+)
+
+var (
+	messageReadCacheHitCounter  = metrics.NewRegisteredCounter("arb/streamer/readcache/hit", nil)
+	messageReadCacheMissCounter = metrics.NewRegisteredCounter("arb/streamer/readcache/miss", nil)
+)
+
+// Each slot starts with the message position plus one, so that zeroed slots are empty, and the data length
+const messageReadCacheSlotHeader = 8 + 4
+
+// messageReadCache keeps the encoded messages of the most recent positions in a ring of fixed size slots,
+// backed by an anonymous memory mapping where supported. Reads of recent messages, which are most of the
+// reads of ExecuteNextMsg and RPC on a busy chain, are served without a database lookup, and the cached
+// data isn't scanned by the garbage collector. Messages larger than a slot aren't cached.
+// The cache only holds messages that were written to the database, and a nil cache caches nothing.
+type messageReadCache struct {
+	mutex    sync.RWMutex
+	data     []byte
+	slots    uint64
+	slotSize uint64
+	unmap    func() error
+}
+
+func newMessageReadCache(slots uint64, slotSize uint64) (*messageReadCache, error) {
+	// #nosec G115
+	data, unmap, err := mapMessageReadCache(int(slots * slotSize))
+	if err != nil {
+		return nil, err
+	}
+	return &messageReadCache{
+		data:     data,
+		slots:    slots,
+		slotSize: slotSize,
+		unmap:    unmap,
+	}, nil
+}
+
+func (c *messageReadCache) slot(pos arbutil.MessageIndex) []byte {
+	start := (uint64(pos) % c.slots) * c.slotSize
+	return c.data[start : start+c.slotSize]
+}
+
+// get returns a copy of the encoded message at pos if it's cached
+func (c *messageReadCache) get(pos arbutil.MessageIndex) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	if c.data == nil {
+		return nil, false
+	}
+	slot := c.slot(pos)
+	if binary.BigEndian.Uint64(slot) != uint64(pos)+1 {
+		messageReadCacheMissCounter.Inc(1)
+		return nil, false
+	}
+	size := binary.BigEndian.Uint32(slot[8:])
+	messageReadCacheHitCounter.Inc(1)
+	return append([]byte(nil), slot[messageReadCacheSlotHeader:messageReadCacheSlotHeader+size]...), true
+}
+
+func (c *messageReadCache) add(pos arbutil.MessageIndex, data []byte) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.data == nil {
+		return
+	}
+	slot := c.slot(pos)
+	if uint64(len(data)) > c.slotSize-messageReadCacheSlotHeader {
+		// Drop the older message in the slot, which isn't among the most recent anymore
+		binary.BigEndian.PutUint64(slot, 0)
+		return
+	}
+	binary.BigEndian.PutUint64(slot, uint64(pos)+1)
+	// #nosec G115
+	binary.BigEndian.PutUint32(slot[8:], uint32(len(data)))
+	copy(slot[messageReadCacheSlotHeader:], data)
+}
+
+// addMessages caches messages written to the database starting at pos
+func (c *messageReadCache) addMessages(pos arbutil.MessageIndex, messages []arbostypes.MessageWithMetadataAndBlockHash) {
+	if c == nil {
+		return
+	}
+	for i, msg := range messages {
+		data, err := rlp.EncodeToBytes(msg.MessageWithMeta)
+		if err != nil {
+			log.Warn("failed to encode message for the read cache", "pos", pos, "err", err)
+			return
+		}
+		// #nosec G115
+		c.add(pos+arbutil.MessageIndex(i), data)
+	}
+}
+
+// truncate drops all cached messages at count or later
+func (c *messageReadCache) truncate(count arbutil.MessageIndex) {
+	if c == nil {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.data == nil {
+		return
+	}
+	for i := uint64(0); i < c.slots; i++ {
+		slot := c.data[i*c.slotSize:]
+		tag := binary.BigEndian.Uint64(slot)
+		if tag != 0 && tag-1 >= uint64(count) {
+			binary.BigEndian.PutUint64(slot, 0)
+		}
+	}
+}
+
+// close releases the mapping, reads after closing are misses
+func (c *messageReadCache) close() {
+	if c == nil {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.data == nil {
+		return
+	}
+	c.data = nil
+	if err := c.unmap(); err != nil {
+		log.Warn("failed to unmap the message read cache", "err", err)
+	}
+}
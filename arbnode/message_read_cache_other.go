@@ -0,0 +1,11 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+//go:build !unix
+
+package arbnode
+
+// Without mmap support the cache is kept on the heap
+func mapMessageReadCache(size int) ([]byte, func() error, error) {
+	return make([]byte, size), func() error { return nil }, nil
+}
@@ -0,0 +1,97 @@
+package arbnode
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/core/rawdb"
+
+	"github.com/offchainlabs/nitro/arbutil"
+)
+
+func TestMessageReadCache(t *testing.T) {
+	cache, err := newMessageReadCache(4, 64)
+	Require(t, err)
+	defer cache.close()
+
+	for pos := arbutil.MessageIndex(0); pos < 6; pos++ {
+		cache.add(pos, []byte{byte(pos), byte(pos)})
+	}
+	// Only the most recent messages are kept
+	if _, ok := cache.get(1); ok {
+		Fail(t, "evicted message still cached")
+	}
+	for pos := arbutil.MessageIndex(2); pos < 6; pos++ {
+		data, ok := cache.get(pos)
+		if !ok || !bytes.Equal(data, []byte{byte(pos), byte(pos)}) {
+			Fail(t, "unexpected cached message", pos, data, ok)
+		}
+	}
+
+	// A message too large for its slot replaces the older message in the slot
+	cache.add(6, make([]byte, 64))
+	if _, ok := cache.get(2); ok {
+		Fail(t, "message replaced by a large message still cached")
+	}
+	if _, ok := cache.get(6); ok {
+		Fail(t, "message larger than a slot was cached")
+	}
+
+	cache.truncate(4)
+	if _, ok := cache.get(4); ok {
+		Fail(t, "truncated message still cached")
+	}
+	if _, ok := cache.get(3); !ok {
+		Fail(t, "message before the truncation point dropped")
+	}
+
+	cache.close()
+	if _, ok := cache.get(3); ok {
+		Fail(t, "closed cache returned a message")
+	}
+	var nilCache *messageReadCache
+	if _, ok := nilCache.get(3); ok {
+		Fail(t, "nil cache returned a message")
+	}
+}
+
+const benchmarkMessages = 1024
+
+func benchmarkMessageData() []byte {
+	return bytes.Repeat([]byte{0xab}, 512)
+}
+
+func BenchmarkMessageReadCache(b *testing.B) {
+	cache, err := newMessageReadCache(benchmarkMessages, 1024)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer cache.close()
+	data := benchmarkMessageData()
+	for pos := arbutil.MessageIndex(0); pos < benchmarkMessages; pos++ {
+		cache.add(pos, data)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, ok := cache.get(arbutil.MessageIndex(i % benchmarkMessages)); !ok {
+			b.Fatal("message not cached")
+		}
+	}
+}
+
+func BenchmarkMessageReadDb(b *testing.B) {
+	db := rawdb.NewMemoryDatabase()
+	data := benchmarkMessageData()
+	for pos := uint64(0); pos < benchmarkMessages; pos++ {
+		if err := db.Put(dbKey(messagePrefix, pos), data); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		// #nosec G115
+		if _, err := db.Get(dbKey(messagePrefix, uint64(i%benchmarkMessages))); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
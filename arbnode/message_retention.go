@@ -0,0 +1,176 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package arbnode
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+	flag "github.com/spf13/pflag"
+
+	"github.com/offchainlabs/nitro/arbutil"
+	"github.com/offchainlabs/nitro/validator"
+)
+
+var messageRetentionPrunedGauge = metrics.NewRegisteredGauge("arb/streamer/retention/pruned", nil)
+
+// How long to wait before checking again whether the message retention was enabled
+const messageRetentionDisabledInterval = time.Minute
+
+// MessageRetentionConfig configures the background pruning of old messages of the transaction streamer.
+// Only messages confirmed on the parent chain are ever pruned, and of those the most recent RetainCount
+// messages and the messages younger than RetainAge are kept.
+type MessageRetentionConfig struct {
+	PruneInterval time.Duration `koanf:"prune-interval" reload:"hot"`
+	RetainCount   uint64        `koanf:"retain-count" reload:"hot"`
+	RetainAge     time.Duration `koanf:"retain-age" reload:"hot"`
+}
+
+var DefaultMessageRetentionConfig = MessageRetentionConfig{
+	PruneInterval: 0,
+	RetainCount:   0,
+	RetainAge:     0,
+}
+
+func MessageRetentionConfigAddOptions(prefix string, f *flag.FlagSet) {
+	f.Duration(prefix+".prune-interval", DefaultMessageRetentionConfig.PruneInterval, "interval between prunings of old messages confirmed on the parent chain (0 = disabled)")
+	f.Uint64(prefix+".retain-count", DefaultMessageRetentionConfig.RetainCount, "number of most recent messages never pruned (0 = prune up to the confirmed message)")
+	f.Duration(prefix+".retain-age", DefaultMessageRetentionConfig.RetainAge, "messages with a timestamp younger than this are never pruned (0 = prune up to the confirmed message)")
+}
+
+// UpdateLatestConfirmed raises the watermark below which messages may be pruned
+func (s *TransactionStreamer) UpdateLatestConfirmed(count arbutil.MessageIndex, _ validator.GoGlobalState) {
+	for {
+		current := s.pruneWatermark.Load()
+		if uint64(count) <= current || s.pruneWatermark.CompareAndSwap(current, uint64(count)) {
+			return
+		}
+	}
+}
+
+// firstStoredMessage returns the position of the first message that wasn't pruned
+func (s *TransactionStreamer) firstStoredMessage() arbutil.MessageIndex {
+	iter := s.db.NewIterator(messagePrefix, nil)
+	defer iter.Release()
+	if !iter.Next() {
+		return 0
+	}
+	return arbutil.MessageIndex(binary.BigEndian.Uint64(bytes.TrimPrefix(iter.Key(), messagePrefix)))
+}
+
+// safePruneCount returns the message count up to which messages can be pruned without affecting other users of
+// the database: messages must be confirmed on the parent chain, so that they can't be reorged, and the messages
+// of the last two batches are kept as they're used to populate the feed backlog on startup.
+func (s *TransactionStreamer) safePruneCount() (arbutil.MessageIndex, error) {
+	count := arbutil.MessageIndex(s.pruneWatermark.Load())
+	if s.inboxReader != nil {
+		batchCount, err := s.inboxReader.tracker.GetBatchCount()
+		if err != nil {
+			return 0, err
+		}
+		if batchCount < 2 {
+			return 0, nil
+		}
+		backlogStart, err := s.inboxReader.tracker.GetBatchMessageCount(batchCount - 2)
+		if err != nil {
+			return 0, err
+		}
+		count = min(count, backlogStart)
+	}
+	return count, nil
+}
+
+// PruneMessagesBefore deletes the messages, expected block hashes and results before count. The count is
+// clamped to the confirmed watermark and the feed backlog, and the count actually pruned up to is returned.
+func (s *TransactionStreamer) PruneMessagesBefore(ctx context.Context, count arbutil.MessageIndex) (arbutil.MessageIndex, error) {
+	if s.readOnly {
+		return 0, ErrReadOnly
+	}
+	safeCount, err := s.safePruneCount()
+	if err != nil {
+		return 0, err
+	}
+	count = min(count, safeCount)
+	first := s.firstStoredMessage()
+	if count <= first {
+		return first, nil
+	}
+	// No lock is needed: reorgs only rewrite messages after the confirmed watermark
+	batch := s.db.NewBatch()
+	if err := s.recordPrunedMessages(ctx, batch, first, count); err != nil {
+		return 0, fmt.Errorf("error recording the messages to prune: %w", err)
+	}
+	if err := batch.Write(); err != nil {
+		return 0, fmt.Errorf("error recording the messages to prune: %w", err)
+	}
+	for _, prefix := range [][]byte{messageResultPrefix, blockHashInputFeedPrefix, messagePrefix, messageTelemetryPrefix} {
+		if _, err := deleteFromRange(ctx, s.db, prefix, uint64(first), uint64(count)); err != nil {
+			return 0, fmt.Errorf("error pruning messages with prefix %q: %w", prefix, err)
+		}
+	}
+	s.recentMessages.pruned(count)
+	// #nosec G115
+	messageRetentionPrunedGauge.Update(int64(count))
+	log.Info("pruned messages", "from", first, "to", count)
+	return count, nil
+}
+
+// messageRetentionCount returns the count up to which messages may be pruned according to the retention config
+func (s *TransactionStreamer) messageRetentionCount(config *MessageRetentionConfig) (arbutil.MessageIndex, error) {
+	count, err := s.safePruneCount()
+	if err != nil {
+		return 0, err
+	}
+	if config.RetainCount > 0 {
+		msgCount, err := s.GetMessageCount()
+		if err != nil {
+			return 0, err
+		}
+		if uint64(msgCount) <= config.RetainCount {
+			return 0, nil
+		}
+		count = min(count, msgCount-arbutil.MessageIndex(config.RetainCount))
+	}
+	if config.RetainAge > 0 {
+		// #nosec G115
+		cutoff := uint64(time.Now().Add(-config.RetainAge).Unix())
+		// Find the first message at or after the cutoff, message timestamps are non-decreasing
+		low, high := s.firstStoredMessage(), count
+		for low < high {
+			mid := low + (high-low)/2
+			msg, err := s.GetMessage(mid)
+			if err != nil {
+				return 0, err
+			}
+			if msg.Message.Header.Timestamp < cutoff {
+				low = mid + 1
+			} else {
+				high = mid
+			}
+		}
+		count = low
+	}
+	return count, nil
+}
+
+func (s *TransactionStreamer) pruneMessages(ctx context.Context) time.Duration {
+	config := s.config().Retention
+	if config.PruneInterval == 0 {
+		return messageRetentionDisabledInterval
+	}
+	count, err := s.messageRetentionCount(&config)
+	if err != nil {
+		log.Warn("failed to determine messages to prune", "err", err)
+		return config.PruneInterval
+	}
+	if _, err := s.PruneMessagesBefore(ctx, count); err != nil && ctx.Err() == nil {
+		log.Error("error pruning messages", "err", err)
+	}
+	return config.PruneInterval
+}
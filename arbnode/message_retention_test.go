@@ -0,0 +1,69 @@
+package arbnode
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/offchainlabs/nitro/arbos/arbostypes"
+	"github.com/offchainlabs/nitro/validator"
+)
+
+func TestPruneMessagesBefore(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	streamer := newTestStreamer(t, nil, nil)
+
+	// One message per hour, the last one an hour ago
+	now := time.Now()
+	var messages []arbostypes.MessageWithMetadata
+	for i := 10; i > 0; i-- {
+		messages = append(messages, arbostypes.MessageWithMetadata{
+			Message: &arbostypes.L1IncomingMessage{
+				// #nosec G115
+				Header: &arbostypes.L1IncomingMessageHeader{Timestamp: uint64(now.Add(-time.Duration(i) * time.Hour).Unix())},
+			},
+		})
+	}
+	Require(t, streamer.AddMessages(0, true, messages))
+
+	// Nothing is pruned before messages are confirmed
+	pruned, err := streamer.PruneMessagesBefore(ctx, 5)
+	Require(t, err)
+	if pruned != 0 {
+		Fail(t, "pruned unconfirmed messages up to", pruned)
+	}
+
+	streamer.UpdateLatestConfirmed(4, validator.GoGlobalState{})
+	pruned, err = streamer.PruneMessagesBefore(ctx, 5)
+	Require(t, err)
+	if pruned != 4 {
+		Fail(t, "expected pruning to be clamped to the confirmed count, pruned up to", pruned)
+	}
+	if _, err := streamer.GetMessage(3); err == nil {
+		Fail(t, "pruned message still stored")
+	}
+	_, err = streamer.GetMessage(4)
+	Require(t, err)
+	// The watermark never decreases
+	streamer.UpdateLatestConfirmed(2, validator.GoGlobalState{})
+	if streamer.pruneWatermark.Load() != 4 {
+		Fail(t, "confirmed watermark decreased")
+	}
+
+	streamer.UpdateLatestConfirmed(10, validator.GoGlobalState{})
+	retention := MessageRetentionConfig{RetainCount: 3}
+	count, err := streamer.messageRetentionCount(&retention)
+	Require(t, err)
+	if count != 7 {
+		Fail(t, "unexpected retain-by-count prune count", count)
+	}
+	// Messages 6 to 9 are younger than four and a half hours
+	retention = MessageRetentionConfig{RetainAge: 4*time.Hour + 30*time.Minute}
+	count, err = streamer.messageRetentionCount(&retention)
+	Require(t, err)
+	if count != 6 {
+		Fail(t, "unexpected retain-by-age prune count", count)
+	}
+}
@@ -0,0 +1,50 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package arbnode
+
+import (
+	"context"
+	"errors"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"github.com/offchainlabs/nitro/arbos/arbostypes"
+	"github.com/offchainlabs/nitro/arbutil"
+	m "github.com/offchainlabs/nitro/broadcaster/message"
+)
+
+// MessageStreamEvent is an event of the canonical message stream followed by indexers: either the next message,
+// along with its block hash and espresso finality when they're known, or a reorg of messages already streamed,
+// after which the stream continues from Position
+type MessageStreamEvent struct {
+	Position         arbutil.MessageIndex            `json:"position"`
+	Message          *arbostypes.MessageWithMetadata `json:"message,omitempty"`
+	BlockHash        *common.Hash                    `json:"blockHash,omitempty"`
+	EspressoFinality *m.EspressoFinality             `json:"espressoFinality,omitempty"`
+	Reorg            bool                            `json:"reorg,omitempty"`
+	// Set on the last event of a subscription that ended because the messages couldn't be read
+	Error string `json:"error,omitempty"`
+}
+
+// NextEvent blocks until the message at the iterator's position is stored, and returns it as a stream event. If a
+// reorg changed messages that were already returned, a reorg event at the first changed message is returned instead.
+func (it *MessageIterator) NextEvent(ctx context.Context) (*MessageStreamEvent, error) {
+	pos, msg, err := it.Next(ctx)
+	if errors.Is(err, ErrMessageIteratorReorged) {
+		return &MessageStreamEvent{Position: it.Position(), Reorg: true}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	blockHash, err := it.streamer.getStoredBlockHash(pos)
+	if err != nil {
+		return nil, err
+	}
+	return &MessageStreamEvent{
+		Position:         pos,
+		Message:          msg,
+		BlockHash:        blockHash,
+		EspressoFinality: it.streamer.espressoFinalityForFeed(pos, 1)[0],
+	}, nil
+}
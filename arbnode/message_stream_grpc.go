@@ -0,0 +1,338 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package arbnode
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+	flag "github.com/spf13/pflag"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/offchainlabs/nitro/arbos/arbostypes"
+	"github.com/offchainlabs/nitro/arbutil"
+	m "github.com/offchainlabs/nitro/broadcaster/message"
+)
+
+// The gRPC service of message_stream.proto, serving the message iterator's events to indexers. The tree doesn't
+// depend on grpc-go, so the service implements the gRPC protocol over HTTP/2 itself: a unary request and a stream
+// of length prefixed protobuf messages, ended by the grpc-status trailer. The messages are encoded with protowire.
+
+var messageStreamActiveGauge = metrics.NewRegisteredGauge("arb/streamer/message_stream/active", nil)
+
+const messageStreamMethodPath = "/nitro.messagestream.v1.MessageStream/StreamMessages"
+
+// Status codes of the gRPC protocol
+const (
+	grpcStatusOK              = 0
+	grpcStatusCanceled        = 1
+	grpcStatusInvalidArgument = 3
+	grpcStatusUnimplemented   = 12
+	grpcStatusInternal        = 13
+	grpcStatusUnavailable     = 14
+)
+
+// Prefix of every gRPC message: whether it's compressed, and its length
+const grpcFramePrefixSize = 5
+
+// maxStreamMessagesRequestSize bounds the request read from the client, which only holds a position
+const maxStreamMessagesRequestSize = 1024
+
+type MessageStreamServerConfig struct {
+	Enable bool   `koanf:"enable"`
+	Addr   string `koanf:"addr"`
+	Port   uint64 `koanf:"port"`
+}
+
+var DefaultMessageStreamServerConfig = MessageStreamServerConfig{
+	Enable: false,
+	Addr:   "127.0.0.1",
+	Port:   9645,
+}
+
+func MessageStreamServerConfigAddOptions(prefix string, f *flag.FlagSet) {
+	f.Bool(prefix+".enable", DefaultMessageStreamServerConfig.Enable, "serve the canonical messages, their reorgs and espresso justifications over the gRPC service of arbnode/message_stream.proto, over HTTP/2 without TLS")
+	f.String(prefix+".addr", DefaultMessageStreamServerConfig.Addr, "address the message stream gRPC service listens on")
+	f.Uint64(prefix+".port", DefaultMessageStreamServerConfig.Port, "port the message stream gRPC service listens on")
+}
+
+func (c *MessageStreamServerConfig) Validate() error {
+	if c.Enable && c.Port > 65535 {
+		return fmt.Errorf("invalid message stream port %d", c.Port)
+	}
+	return nil
+}
+
+// startMessageStreamServer listens for the message stream gRPC service if it's enabled, and serves it until the
+// streamer is stopped
+func (s *TransactionStreamer) startMessageStreamServer() error {
+	config := &s.config().MessageStream
+	if !config.Enable {
+		return nil
+	}
+	listener, err := net.Listen("tcp", net.JoinHostPort(config.Addr, strconv.FormatUint(config.Port, 10)))
+	if err != nil {
+		return fmt.Errorf("failed to listen for the message stream: %w", err)
+	}
+	server := &http.Server{
+		Handler:           h2c.NewHandler(&messageStreamHandler{streamer: s}, &http2.Server{}),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	err = s.LaunchThreadSafe(func(ctx context.Context) {
+		go func() {
+			<-ctx.Done()
+			// The open streams end with the streamer's context
+			if err := server.Close(); err != nil {
+				log.Warn("error closing the message stream server", "err", err)
+			}
+		}()
+		err := server.Serve(listener)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Error("error serving the message stream", "err", err)
+		}
+	})
+	if err != nil {
+		listener.Close()
+		return err
+	}
+	log.Info("serving the message stream", "addr", listener.Addr())
+	return nil
+}
+
+type messageStreamHandler struct {
+	streamer *TransactionStreamer
+}
+
+func (h *messageStreamHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.ProtoMajor != 2 {
+		http.Error(w, "the message stream is served over HTTP/2", http.StatusHTTPVersionNotSupported)
+		return
+	}
+	if r.Method != http.MethodPost || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		http.Error(w, "expected a gRPC request", http.StatusUnsupportedMediaType)
+		return
+	}
+	w.Header().Set("Content-Type", "application/grpc")
+	w.WriteHeader(http.StatusOK)
+	code, message := h.serve(r.Context(), w, r)
+	w.Header().Set(http.TrailerPrefix+"Grpc-Status", strconv.Itoa(code))
+	if message != "" {
+		w.Header().Set(http.TrailerPrefix+"Grpc-Message", encodeGRPCMessage(message))
+	}
+}
+
+// serve streams the requested messages until the client goes away, the streamer is stopped or the messages can't be
+// read, and returns the status ending the stream
+func (h *messageStreamHandler) serve(ctx context.Context, w http.ResponseWriter, r *http.Request) (int, string) {
+	if r.URL.Path != messageStreamMethodPath {
+		return grpcStatusUnimplemented, "unknown method " + r.URL.Path
+	}
+	request, err := readGRPCFrame(r.Body, maxStreamMessagesRequestSize)
+	if err != nil {
+		return grpcStatusInvalidArgument, err.Error()
+	}
+	from, err := parseStreamMessagesRequest(request)
+	if err != nil {
+		return grpcStatusInvalidArgument, err.Error()
+	}
+	s := h.streamer
+	streamerCtx, err := s.GetContextSafe()
+	if err != nil {
+		return grpcStatusUnavailable, err.Error()
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stopCancel := context.AfterFunc(streamerCtx, cancel)
+	defer stopCancel()
+	iter, err := s.MessageIterator(arbutil.MessageIndex(from))
+	if err != nil {
+		return grpcStatusInternal, err.Error()
+	}
+	messageStreamActiveGauge.Inc(1)
+	defer messageStreamActiveGauge.Dec(1)
+	flusher, _ := w.(http.Flusher)
+	for {
+		ev, err := iter.NextEvent(ctx)
+		if err != nil {
+			if streamerCtx.Err() != nil {
+				return grpcStatusUnavailable, "the node is stopping"
+			}
+			if ctx.Err() != nil {
+				return grpcStatusCanceled, ctx.Err().Error()
+			}
+			log.Warn("failed to read the message stream", "pos", iter.Position(), "err", err)
+			return grpcStatusInternal, err.Error()
+		}
+		if _, err := w.Write(appendGRPCFrame(nil, appendMessageStreamEvent(nil, ev))); err != nil {
+			return grpcStatusCanceled, err.Error()
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+}
+
+// readGRPCFrame reads a gRPC message of at most maxSize bytes
+func readGRPCFrame(r io.Reader, maxSize uint32) ([]byte, error) {
+	var prefix [grpcFramePrefixSize]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		return nil, fmt.Errorf("failed to read the request: %w", err)
+	}
+	if prefix[0] != 0 {
+		return nil, errors.New("compressed requests aren't supported")
+	}
+	size := binary.BigEndian.Uint32(prefix[1:])
+	if size > maxSize {
+		return nil, fmt.Errorf("request of %d bytes exceeds the limit of %d bytes", size, maxSize)
+	}
+	message := make([]byte, size)
+	if _, err := io.ReadFull(r, message); err != nil {
+		return nil, fmt.Errorf("failed to read the request: %w", err)
+	}
+	return message, nil
+}
+
+func appendGRPCFrame(b []byte, message []byte) []byte {
+	b = append(b, 0)
+	b = binary.BigEndian.AppendUint32(b, uint32(len(message)))
+	return append(b, message...)
+}
+
+// encodeGRPCMessage percent encodes the status message as the gRPC protocol requires
+func encodeGRPCMessage(message string) string {
+	var sb strings.Builder
+	for i := 0; i < len(message); i++ {
+		c := message[i]
+		if c < ' ' || c > '~' || c == '%' {
+			fmt.Fprintf(&sb, "%%%02X", c)
+		} else {
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String()
+}
+
+// parseStreamMessagesRequest returns the position of a StreamMessagesRequest, skipping unknown fields
+func parseStreamMessagesRequest(b []byte) (uint64, error) {
+	var from uint64
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return 0, protowire.ParseError(n)
+		}
+		b = b[n:]
+		if num == 1 && typ == protowire.VarintType {
+			from, n = protowire.ConsumeVarint(b)
+		} else {
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return 0, protowire.ParseError(n)
+		}
+		b = b[n:]
+	}
+	return from, nil
+}
+
+// The protobuf encoding of the messages of message_stream.proto. Fields holding their default value are omitted,
+// like proto3 does.
+
+func appendVarintField(b []byte, num protowire.Number, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+func appendBytesField(b []byte, num protowire.Number, v []byte) []byte {
+	if len(v) == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, v)
+}
+
+// appendMessageField appends an encoded message, even empty, so that the field is present
+func appendMessageField(b []byte, num protowire.Number, message []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, message)
+}
+
+func appendMessageStreamEvent(b []byte, ev *MessageStreamEvent) []byte {
+	b = appendVarintField(b, 1, uint64(ev.Position))
+	if ev.Reorg {
+		b = appendVarintField(b, 2, 1)
+	}
+	if ev.Message != nil {
+		var message []byte
+		message = appendMessageField(message, 1, appendMessageWithMetadata(nil, ev.Message))
+		if ev.BlockHash != nil {
+			message = appendBytesField(message, 2, ev.BlockHash.Bytes())
+		}
+		b = appendMessageField(b, 3, message)
+	}
+	if ev.EspressoFinality != nil {
+		b = appendMessageField(b, 4, appendEspressoFinality(nil, ev.EspressoFinality))
+	}
+	return b
+}
+
+func appendMessageWithMetadata(b []byte, msg *arbostypes.MessageWithMetadata) []byte {
+	if msg.Message != nil {
+		b = appendMessageField(b, 1, appendL1IncomingMessage(nil, msg.Message))
+	}
+	return appendVarintField(b, 2, msg.DelayedMessagesRead)
+}
+
+func appendL1IncomingMessage(b []byte, msg *arbostypes.L1IncomingMessage) []byte {
+	if header := msg.Header; header != nil {
+		var encoded []byte
+		encoded = appendVarintField(encoded, 1, uint64(header.Kind))
+		encoded = appendBytesField(encoded, 2, header.Poster.Bytes())
+		encoded = appendVarintField(encoded, 3, header.BlockNumber)
+		encoded = appendVarintField(encoded, 4, header.Timestamp)
+		if header.RequestId != nil {
+			encoded = appendBytesField(encoded, 5, header.RequestId.Bytes())
+		}
+		if header.L1BaseFee != nil {
+			encoded = appendBytesField(encoded, 6, header.L1BaseFee.Bytes())
+		}
+		b = appendMessageField(b, 1, encoded)
+	}
+	b = appendBytesField(b, 2, msg.L2msg)
+	if msg.BatchGasCost != nil {
+		// Optional, so present even when zero
+		b = protowire.AppendTag(b, 3, protowire.VarintType)
+		b = protowire.AppendVarint(b, *msg.BatchGasCost)
+	}
+	return b
+}
+
+func appendEspressoFinality(b []byte, finality *m.EspressoFinality) []byte {
+	b = appendVarintField(b, 1, finality.HotShotHeight)
+	b = appendBytesField(b, 2, []byte(finality.TxHash))
+	b = appendVarintField(b, 3, finality.Namespace)
+	if justification := finality.Justification; justification != nil {
+		var encoded []byte
+		encoded = appendBytesField(encoded, 1, justification.Header)
+		encoded = appendVarintField(encoded, 2, justification.RootHeight)
+		encoded = appendBytesField(encoded, 3, []byte(justification.BlockMerkleRoot))
+		encoded = appendBytesField(encoded, 4, justification.Proof)
+		b = appendMessageField(b, 4, encoded)
+	}
+	return b
+}
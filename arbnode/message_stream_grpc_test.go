@@ -0,0 +1,138 @@
+package arbnode
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// testProtoMessage holds the varint and length delimited fields of an encoded protobuf message
+type testProtoMessage struct {
+	varints map[protowire.Number]uint64
+	bytes   map[protowire.Number][]byte
+}
+
+func decodeTestProtoMessage(t *testing.T, b []byte) testProtoMessage {
+	t.Helper()
+	msg := testProtoMessage{varints: make(map[protowire.Number]uint64), bytes: make(map[protowire.Number][]byte)}
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			Fail(t, "invalid tag", protowire.ParseError(n))
+		}
+		b = b[n:]
+		switch typ {
+		case protowire.VarintType:
+			msg.varints[num], n = protowire.ConsumeVarint(b)
+		case protowire.BytesType:
+			msg.bytes[num], n = protowire.ConsumeBytes(b)
+		default:
+			Fail(t, "unexpected wire type", num, typ)
+		}
+		if n < 0 {
+			Fail(t, "invalid field", num, protowire.ParseError(n))
+		}
+		b = b[n:]
+	}
+	return msg
+}
+
+func startTestMessageStream(t *testing.T, ctx context.Context, server *httptest.Server, path string, from uint64) *http.Response {
+	t.Helper()
+	request := protowire.AppendTag(nil, 1, protowire.VarintType)
+	request = protowire.AppendVarint(request, from)
+	httpRequest, err := http.NewRequestWithContext(ctx, http.MethodPost, server.URL+path, bytes.NewReader(appendGRPCFrame(nil, request)))
+	Require(t, err)
+	httpRequest.Header.Set("Content-Type", "application/grpc")
+	response, err := server.Client().Do(httpRequest)
+	Require(t, err)
+	return response
+}
+
+func TestMessageStreamGRPC(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	streamer := newTestImportStreamer(t)
+	streamer.StopWaiter.Start(ctx, streamer)
+	Require(t, streamer.AddMessages(0, true, testChainMessages(0, 3)))
+	server := httptest.NewUnstartedServer(&messageStreamHandler{streamer: streamer})
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+
+	response := startTestMessageStream(t, ctx, server, messageStreamMethodPath, 1)
+	defer response.Body.Close()
+	if response.ProtoMajor != 2 || response.Header.Get("Content-Type") != "application/grpc" {
+		Fail(t, "unexpected response", response.Proto, response.Header)
+	}
+	for _, pos := range []uint64{1, 2} {
+		frame, err := readGRPCFrame(response.Body, 1<<20)
+		Require(t, err)
+		ev := decodeTestProtoMessage(t, frame)
+		if ev.varints[1] != pos || ev.varints[2] != 0 {
+			Fail(t, "unexpected event", pos, ev.varints)
+		}
+		withBlockHash := decodeTestProtoMessage(t, ev.bytes[3])
+		withMeta := decodeTestProtoMessage(t, withBlockHash.bytes[1])
+		if withMeta.varints[2] != 1 {
+			Fail(t, "unexpected delayed messages read", pos, withMeta.varints)
+		}
+		message := decodeTestProtoMessage(t, withMeta.bytes[1])
+		header := decodeTestProtoMessage(t, message.bytes[1])
+		// The test messages hold their position as their timestamp
+		if header.varints[4] != pos {
+			Fail(t, "unexpected message timestamp", pos, header.varints)
+		}
+	}
+
+	// Stopping the node ends the stream with a status telling the client why
+	streamer.StopAndWait()
+	if _, err := io.ReadAll(response.Body); err != nil {
+		Fail(t, "stream didn't end cleanly", err)
+	}
+	if status := response.Trailer.Get("Grpc-Status"); status != strconv.Itoa(grpcStatusUnavailable) {
+		Fail(t, "unexpected status ending the stream", status, response.Trailer)
+	}
+}
+
+func TestMessageStreamGRPCUnknownMethod(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	streamer := newTestImportStreamer(t)
+	server := httptest.NewUnstartedServer(&messageStreamHandler{streamer: streamer})
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+
+	response := startTestMessageStream(t, ctx, server, "/nitro.messagestream.v1.MessageStream/Unknown", 0)
+	defer response.Body.Close()
+	if _, err := io.ReadAll(response.Body); err != nil {
+		Fail(t, "response didn't end cleanly", err)
+	}
+	if status := response.Trailer.Get("Grpc-Status"); status != strconv.Itoa(grpcStatusUnimplemented) {
+		Fail(t, "unexpected status", status, response.Trailer)
+	}
+}
+
+func TestParseStreamMessagesRequest(t *testing.T) {
+	// Unknown fields are skipped
+	request := protowire.AppendTag(nil, 2, protowire.BytesType)
+	request = protowire.AppendBytes(request, []byte("unknown"))
+	request = protowire.AppendTag(request, 1, protowire.VarintType)
+	request = protowire.AppendVarint(request, 42)
+	from, err := parseStreamMessagesRequest(request)
+	Require(t, err)
+	if from != 42 {
+		Fail(t, "unexpected position", from)
+	}
+	if _, err := parseStreamMessagesRequest(request[:len(request)-1]); err == nil {
+		Fail(t, "truncated request parsed")
+	}
+}
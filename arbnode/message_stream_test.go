@@ -0,0 +1,51 @@
+package arbnode
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestMessageStreamEvents(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	streamer := newTestImportStreamer(t)
+	Require(t, streamer.AddMessages(0, true, testChainMessages(0, 3)))
+	batch := streamer.db.NewBatch()
+	_, err := streamer.repairBlockHash(1, common.HexToHash("0x01"), batch)
+	Require(t, err)
+	Require(t, batch.Write())
+
+	iter, err := streamer.MessageIterator(1)
+	Require(t, err)
+	ev, err := iter.NextEvent(ctx)
+	Require(t, err)
+	if ev.Reorg || ev.Position != 1 || ev.Message == nil || ev.Message.Message.Header.Timestamp != 1 {
+		Fail(t, "unexpected message event", ev)
+	}
+	if ev.BlockHash == nil || *ev.BlockHash != common.HexToHash("0x01") {
+		Fail(t, "block hash missing from the message event", ev.BlockHash)
+	}
+	ev, err = iter.NextEvent(ctx)
+	Require(t, err)
+	if ev.Position != 2 || ev.BlockHash != nil || ev.EspressoFinality != nil {
+		Fail(t, "unexpected message event", ev)
+	}
+
+	// A reorg of a streamed message is notified before the stream continues from it
+	batch = streamer.db.NewBatch()
+	Require(t, streamer.recordReorg(batch, ReorgSourceFeed, 2, 1, 0))
+	Require(t, batch.Write())
+	ev, err = iter.NextEvent(ctx)
+	Require(t, err)
+	if !ev.Reorg || ev.Position != 2 || ev.Message != nil {
+		Fail(t, "expected a reorg event at 2, got", ev)
+	}
+	ev, err = iter.NextEvent(ctx)
+	Require(t, err)
+	if ev.Reorg || ev.Position != 2 {
+		Fail(t, "stream didn't continue from the reorg", ev)
+	}
+}
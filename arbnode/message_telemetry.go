@@ -0,0 +1,162 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package arbnode
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+
+	"github.com/offchainlabs/nitro/arbutil"
+)
+
+// messageLifecycleStage is a point of the lifecycle of a message whose time is kept in its telemetry
+type messageLifecycleStage byte
+
+const (
+	messageSequencedStage messageLifecycleStage = iota
+	messageSubmittedStage
+	messageFinalizedStage
+	messageExecutedStage
+	messagePostedStage
+)
+
+// Maximum number of messages covered by a telemetry query
+const maxMessageTelemetryRange = 10_000
+
+func messageTelemetryKey(pos arbutil.MessageIndex, stage messageLifecycleStage) []byte {
+	return append(dbKey(messageTelemetryPrefix, uint64(pos)), byte(stage))
+}
+
+// recordMessageTelemetry stores that the message at pos reached stage at the given time, if telemetry is enabled.
+// Each stage has its own key, so that stages reached concurrently don't overwrite each other.
+func (s *TransactionStreamer) recordMessageTelemetry(batch ethdb.KeyValueWriter, pos arbutil.MessageIndex, stage messageLifecycleStage, at time.Time) error {
+	if !s.config().MessageTelemetry {
+		return nil
+	}
+	// #nosec G115
+	return batch.Put(messageTelemetryKey(pos, stage), binary.BigEndian.AppendUint64(nil, uint64(at.UnixMilli())))
+}
+
+// recordPostedMessagesTelemetry stores that the messages in [from, to) were posted in a batch at the given time
+func (s *TransactionStreamer) recordPostedMessagesTelemetry(from, to arbutil.MessageIndex, at time.Time) error {
+	if !s.config().MessageTelemetry {
+		return nil
+	}
+	batch := s.db.NewBatch()
+	for pos := from; pos < to; pos++ {
+		if err := s.recordMessageTelemetry(batch, pos, messagePostedStage, at); err != nil {
+			return err
+		}
+	}
+	return batch.Write()
+}
+
+// MessageTelemetry is the time, in unix milliseconds, a message reached each stage of its lifecycle.
+// Stages the message didn't reach, or reached while telemetry was disabled, are nil.
+type MessageTelemetry struct {
+	Sequenced *uint64 `json:"sequenced,omitempty"`
+	Submitted *uint64 `json:"submitted,omitempty"`
+	Finalized *uint64 `json:"finalized,omitempty"`
+	Executed  *uint64 `json:"executed,omitempty"`
+	Posted    *uint64 `json:"posted,omitempty"`
+}
+
+func (t *MessageTelemetry) stage(stage messageLifecycleStage) **uint64 {
+	switch stage {
+	case messageSequencedStage:
+		return &t.Sequenced
+	case messageSubmittedStage:
+		return &t.Submitted
+	case messageFinalizedStage:
+		return &t.Finalized
+	case messageExecutedStage:
+		return &t.Executed
+	case messagePostedStage:
+		return &t.Posted
+	default:
+		return nil
+	}
+}
+
+// GetMessageTelemetry returns the telemetry of the messages in [from, to), indexed from from
+func (s *TransactionStreamer) GetMessageTelemetry(ctx context.Context, from, to arbutil.MessageIndex) ([]MessageTelemetry, error) {
+	if to < from {
+		return nil, fmt.Errorf("invalid message range [%d, %d)", from, to)
+	}
+	if to-from > maxMessageTelemetryRange {
+		return nil, fmt.Errorf("message range [%d, %d) covers more than %d messages", from, to, maxMessageTelemetryRange)
+	}
+	telemetry := make([]MessageTelemetry, to-from)
+	iter := s.db.NewIterator(messageTelemetryPrefix, uint64ToKey(uint64(from)))
+	defer iter.Release()
+	for iter.Next() {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		key := bytes.TrimPrefix(iter.Key(), messageTelemetryPrefix)
+		if len(key) != 9 || len(iter.Value()) != 8 {
+			return nil, fmt.Errorf("invalid message telemetry entry %x", iter.Key())
+		}
+		pos := arbutil.MessageIndex(binary.BigEndian.Uint64(key))
+		if pos >= to {
+			break
+		}
+		at := binary.BigEndian.Uint64(iter.Value())
+		if stage := telemetry[pos-from].stage(messageLifecycleStage(key[8])); stage != nil {
+			*stage = &at
+		}
+	}
+	return telemetry, iter.Error()
+}
+
+// MessageLatencyBreakdown summarizes, in milliseconds, the time messages took between the stages of their
+// lifecycle. Each summary only covers the messages that reached both of its stages.
+type MessageLatencyBreakdown struct {
+	From                 arbutil.MessageIndex    `json:"from"`
+	To                   arbutil.MessageIndex    `json:"to"`
+	SequencedToSubmitted *EspressoLatencySummary `json:"sequencedToSubmitted,omitempty"`
+	SubmittedToFinalized *EspressoLatencySummary `json:"submittedToFinalized,omitempty"`
+	SequencedToFinalized *EspressoLatencySummary `json:"sequencedToFinalized,omitempty"`
+	SequencedToExecuted  *EspressoLatencySummary `json:"sequencedToExecuted,omitempty"`
+	SequencedToPosted    *EspressoLatencySummary `json:"sequencedToPosted,omitempty"`
+	FinalizedToPosted    *EspressoLatencySummary `json:"finalizedToPosted,omitempty"`
+}
+
+// MessageLatencyBreakdown builds the latency breakdown of the messages in [from, to)
+func (s *TransactionStreamer) MessageLatencyBreakdown(ctx context.Context, from, to arbutil.MessageIndex) (*MessageLatencyBreakdown, error) {
+	telemetry, err := s.GetMessageTelemetry(ctx, from, to)
+	if err != nil {
+		return nil, err
+	}
+	latencies := func(start func(*MessageTelemetry) *uint64, end func(*MessageTelemetry) *uint64) *EspressoLatencySummary {
+		var res []uint64
+		for i := range telemetry {
+			startAt, endAt := start(&telemetry[i]), end(&telemetry[i])
+			if startAt != nil && endAt != nil {
+				res = append(res, saturatingLatency(*startAt, *endAt))
+			}
+		}
+		return summarizeEspressoLatencies(res)
+	}
+	sequenced := func(t *MessageTelemetry) *uint64 { return t.Sequenced }
+	submitted := func(t *MessageTelemetry) *uint64 { return t.Submitted }
+	finalized := func(t *MessageTelemetry) *uint64 { return t.Finalized }
+	executed := func(t *MessageTelemetry) *uint64 { return t.Executed }
+	posted := func(t *MessageTelemetry) *uint64 { return t.Posted }
+	return &MessageLatencyBreakdown{
+		From:                 from,
+		To:                   to,
+		SequencedToSubmitted: latencies(sequenced, submitted),
+		SubmittedToFinalized: latencies(submitted, finalized),
+		SequencedToFinalized: latencies(sequenced, finalized),
+		SequencedToExecuted:  latencies(sequenced, executed),
+		SequencedToPosted:    latencies(sequenced, posted),
+		FinalizedToPosted:    latencies(finalized, posted),
+	}, nil
+}
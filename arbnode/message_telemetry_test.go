@@ -0,0 +1,55 @@
+package arbnode
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/offchainlabs/nitro/arbutil"
+)
+
+func TestMessageTelemetry(t *testing.T) {
+	ctx := context.Background()
+	streamer := newTestImportStreamer(t)
+	sequencedAt := time.UnixMilli(1_000_000)
+
+	// Nothing is stored while telemetry is disabled
+	Require(t, streamer.recordMessageTelemetry(streamer.db, 0, messageSequencedStage, sequencedAt))
+	telemetry, err := streamer.GetMessageTelemetry(ctx, 0, 1)
+	Require(t, err)
+	if telemetry[0].Sequenced != nil {
+		Fail(t, "telemetry stored while disabled")
+	}
+
+	streamer.config().MessageTelemetry = true
+	for pos := arbutil.MessageIndex(0); pos < 4; pos++ {
+		Require(t, streamer.recordMessageTelemetry(streamer.db, pos, messageSequencedStage, sequencedAt))
+		// #nosec G115
+		Require(t, streamer.recordMessageTelemetry(streamer.db, pos, messageExecutedStage, sequencedAt.Add(time.Duration(pos+1)*time.Millisecond)))
+	}
+	Require(t, streamer.recordMessageTelemetry(streamer.db, 1, messageSubmittedStage, sequencedAt.Add(100*time.Millisecond)))
+	Require(t, streamer.recordMessageTelemetry(streamer.db, 1, messageFinalizedStage, sequencedAt.Add(2100*time.Millisecond)))
+	Require(t, streamer.recordPostedMessagesTelemetry(0, 2, sequencedAt.Add(5*time.Second)))
+
+	telemetry, err = streamer.GetMessageTelemetry(ctx, 1, 3)
+	Require(t, err)
+	if len(telemetry) != 2 || telemetry[0].Submitted == nil || *telemetry[0].Submitted != 1_000_100 || telemetry[1].Posted != nil {
+		Fail(t, "unexpected telemetry", telemetry)
+	}
+
+	breakdown, err := streamer.MessageLatencyBreakdown(ctx, 0, 4)
+	Require(t, err)
+	if breakdown.SequencedToExecuted == nil || breakdown.SequencedToExecuted.Count != 4 || breakdown.SequencedToExecuted.Max != 4 {
+		Fail(t, "unexpected execution latency", breakdown.SequencedToExecuted)
+	}
+	if breakdown.SubmittedToFinalized == nil || breakdown.SubmittedToFinalized.Count != 1 || breakdown.SubmittedToFinalized.Max != 2000 {
+		Fail(t, "unexpected finality latency", breakdown.SubmittedToFinalized)
+	}
+	if breakdown.SequencedToPosted == nil || breakdown.SequencedToPosted.Count != 2 || breakdown.FinalizedToPosted.Max != 2900 {
+		Fail(t, "unexpected posting latency", breakdown.SequencedToPosted, breakdown.FinalizedToPosted)
+	}
+
+	if _, err := streamer.GetMessageTelemetry(ctx, 0, maxMessageTelemetryRange+1); err == nil {
+		Fail(t, "oversized range accepted")
+	}
+}
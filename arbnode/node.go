@@ -84,6 +84,7 @@ type Config struct {
 	DelayedSequencer    DelayedSequencerConfig      `koanf:"delayed-sequencer" reload:"hot"`
 	BatchPoster         BatchPosterConfig           `koanf:"batch-poster" reload:"hot"`
 	MessagePruner       MessagePrunerConfig         `koanf:"message-pruner" reload:"hot"`
+	MessageBackup       MessageBackupConfig         `koanf:"message-backup" reload:"hot"`
 	BlockValidator      staker.BlockValidatorConfig `koanf:"block-validator" reload:"hot"`
 	Feed                broadcastclient.FeedConfig  `koanf:"feed" reload:"hot"`
 	Staker              staker.L1ValidatorConfig    `koanf:"staker" reload:"hot"`
@@ -105,6 +106,9 @@ func (c *Config) Validate() error {
 	if c.DelayedSequencer.Enable && !c.Sequencer {
 		return errors.New("cannot enable delayed sequencer without enabling sequencer")
 	}
+	if err := c.DelayedSequencer.Validate(); err != nil {
+		return err
+	}
 	if c.InboxReader.ReadMode != "latest" {
 		if c.Sequencer {
 			return errors.New("cannot enable inboxreader in safe or finalized mode along with sequencer")
@@ -130,6 +134,31 @@ func (c *Config) Validate() error {
 	if err := c.Staker.Validate(); err != nil {
 		return err
 	}
+	if err := c.MessageBackup.Validate(); err != nil {
+		return err
+	}
+	if err := c.TransactionStreamer.Validate(); err != nil {
+		return err
+	}
+	return c.validateEspresso()
+}
+
+// validateEspresso checks the espresso settings of the batch poster and the transaction streamer against each other
+func (c *Config) validateEspresso() error {
+	if c.BatchPoster.EspressoQueryUrl == "" {
+		return nil
+	}
+	pollingInterval := c.BatchPoster.EspressoTxnsPollingInterval
+	espresso := &c.TransactionStreamer.Espresso
+	if espresso.UnreachableThreshold != 0 && espresso.UnreachableThreshold <= pollingInterval {
+		return fmt.Errorf("transaction-streamer.espresso.unreachable-threshold %v must be longer than batch-poster.espresso-txns-polling-interval %v, or hotshot is reported down between two polls", espresso.UnreachableThreshold, pollingInterval)
+	}
+	if espresso.ResubmissionTimeout != 0 && espresso.ResubmissionTimeout <= pollingInterval {
+		return fmt.Errorf("transaction-streamer.espresso.resubmission-timeout %v must be longer than batch-poster.espresso-txns-polling-interval %v, or transactions are resubmitted before their inclusion is polled", espresso.ResubmissionTimeout, pollingInterval)
+	}
+	if espresso.LongPollInterval != 0 && espresso.LongPollInterval < pollingInterval {
+		return fmt.Errorf("transaction-streamer.espresso.long-poll-interval %v is shorter than batch-poster.espresso-txns-polling-interval %v, set it to 0 to disable long polling", espresso.LongPollInterval, pollingInterval)
+	}
 	return nil
 }
 
@@ -150,6 +179,7 @@ func ConfigAddOptions(prefix string, f *flag.FlagSet, feedInputEnable bool, feed
 	DelayedSequencerConfigAddOptions(prefix+".delayed-sequencer", f)
 	BatchPosterConfigAddOptions(prefix+".batch-poster", f)
 	MessagePrunerConfigAddOptions(prefix+".message-pruner", f)
+	MessageBackupConfigAddOptions(prefix+".message-backup", f)
 	staker.BlockValidatorConfigAddOptions(prefix+".block-validator", f)
 	broadcastclient.FeedConfigAddOptions(prefix+".feed", f, feedInputEnable, feedOutputEnable)
 	staker.L1ValidatorConfigAddOptions(prefix+".staker", f)
@@ -168,6 +198,7 @@ var ConfigDefault = Config{
 	DelayedSequencer:    DefaultDelayedSequencerConfig,
 	BatchPoster:         DefaultBatchPosterConfig,
 	MessagePruner:       DefaultMessagePrunerConfig,
+	MessageBackup:       DefaultMessageBackupConfig,
 	BlockValidator:      staker.DefaultBlockValidatorConfig,
 	Feed:                broadcastclient.FeedConfigDefault,
 	Staker:              staker.DefaultL1ValidatorConfig,
@@ -264,6 +295,7 @@ type Node struct {
 	DelayedSequencer        *DelayedSequencer
 	BatchPoster             *BatchPoster
 	MessagePruner           *MessagePruner
+	MessageBackupAgent      *MessageBackupAgent
 	BlockValidator          *staker.BlockValidator
 	StatelessBlockValidator *staker.StatelessBlockValidator
 	Staker                  *staker.Staker
@@ -283,14 +315,17 @@ type SnapSyncConfig struct {
 	PrevDelayedRead       uint64
 	BatchCount            uint64
 	DelayedCount          uint64
+	// HotShot block height the messages before PrevBatchMessageCount were finalized by, queried from HotShot if 0
+	EspressoFinalizedHeight uint64
 }
 
 var DefaultSnapSyncConfig = SnapSyncConfig{
-	Enabled:               false,
-	PrevBatchMessageCount: 0,
-	BatchCount:            0,
-	DelayedCount:          0,
-	PrevDelayedRead:       0,
+	Enabled:                 false,
+	PrevBatchMessageCount:   0,
+	BatchCount:              0,
+	DelayedCount:            0,
+	PrevDelayedRead:         0,
+	EspressoFinalizedHeight: 0,
 }
 
 type ConfigFetcher interface {
@@ -457,6 +492,12 @@ func createNodeImpl(
 	if err != nil {
 		return nil, err
 	}
+	if config.TransactionStreamer.Espresso.Attestation.Scheme == EspressoAttestationSchemeECDSA {
+		if dataSigner == nil {
+			return nil, errors.New("cannot attest espresso payloads without a data signer")
+		}
+		txStreamer.SetEspressoAttestationSigner(NewEspressoECDSAAttestationSigner(dataSigner))
+	}
 	var coordinator *SeqCoordinator
 	var bpVerifier *contracts.AddressVerifier
 	if deployInfo != nil && l1client != nil {
@@ -467,6 +508,7 @@ func createNodeImpl(
 			return nil, err
 		}
 		bpVerifier = contracts.NewAddressVerifier(seqInboxCaller)
+		txStreamer.SetFeedAddressVerifier(bpVerifier)
 	}
 
 	if config.SeqCoordinator.Enable {
@@ -477,6 +519,13 @@ func createNodeImpl(
 	} else if config.Sequencer && !config.Dangerous.NoSequencerCoordinator {
 		return nil, errors.New("sequencer must be enabled with coordinator, unless dangerous.no-sequencer-coordinator set")
 	}
+	var messageBackupAgent *MessageBackupAgent
+	if config.MessageBackup.Enable {
+		messageBackupAgent, err = NewMessageBackupAgent(txStreamer, func() *MessageBackupConfig { return &configFetcher.Get().MessageBackup })
+		if err != nil {
+			return nil, err
+		}
+	}
 	dbs := []ethdb.Database{arbDb}
 	maintenanceRunner, err := NewMaintenanceRunner(func() *MaintenanceConfig { return &configFetcher.Get().Maintenance }, coordinator, dbs, exec)
 	if err != nil {
@@ -518,6 +567,7 @@ func createNodeImpl(
 			DelayedSequencer:        nil,
 			BatchPoster:             nil,
 			MessagePruner:           nil,
+			MessageBackupAgent:      messageBackupAgent,
 			BlockValidator:          nil,
 			StatelessBlockValidator: nil,
 			Staker:                  nil,
@@ -680,7 +730,8 @@ func createNodeImpl(
 			}
 		}
 
-		var confirmedNotifiers []staker.LatestConfirmedNotifier
+		// The streamer prunes messages below the confirmed watermark if its retention is configured
+		confirmedNotifiers := []staker.LatestConfirmedNotifier{txStreamer}
 		if config.MessagePruner.Enable {
 			messagePruner = NewMessagePruner(txStreamer, inboxTracker, func() *MessagePrunerConfig { return &configFetcher.Get().MessagePruner })
 			confirmedNotifiers = append(confirmedNotifiers, messagePruner)
@@ -756,6 +807,7 @@ func createNodeImpl(
 		DelayedSequencer:        delayedSequencer,
 		BatchPoster:             batchPoster,
 		MessagePruner:           messagePruner,
+		MessageBackupAgent:      messageBackupAgent,
 		BlockValidator:          blockValidator,
 		StatelessBlockValidator: statelessBlockValidator,
 		Staker:                  stakerObj,
@@ -796,6 +848,43 @@ func CreateNode(
 		return nil, err
 	}
 	var apis []rpc.API
+	if currentNode.TxStreamer != nil {
+		apis = append(apis, rpc.API{
+			Namespace: "arb",
+			Version:   "1.0",
+			Service:   &TransactionStreamerAPI{streamer: currentNode.TxStreamer},
+			Public:    false,
+		})
+		apis = append(apis, rpc.API{
+			Namespace: "espresso",
+			Version:   "1.0",
+			Service:   &EspressoAPI{streamer: currentNode.TxStreamer},
+			Public:    false,
+		})
+		apis = append(apis, rpc.API{
+			Namespace:     "espressoadmin",
+			Version:       "1.0",
+			Service:       &EspressoAdminAPI{streamer: currentNode.TxStreamer},
+			Public:        false,
+			Authenticated: true,
+		})
+	}
+	if currentNode.BroadcastClients != nil {
+		apis = append(apis, rpc.API{
+			Namespace: "arb",
+			Version:   "1.0",
+			Service:   &BroadcastClientsAPI{clients: currentNode.BroadcastClients},
+			Public:    false,
+		})
+	}
+	if currentNode.InboxTracker != nil {
+		apis = append(apis, rpc.API{
+			Namespace: "arb",
+			Version:   "1.0",
+			Service:   &InboxTrackerAPI{tracker: currentNode.InboxTracker},
+			Public:    false,
+		})
+	}
 	if currentNode.BlockValidator != nil {
 		apis = append(apis, rpc.API{
 			Namespace: "arb",
@@ -861,10 +950,10 @@ func (n *Node) Start(ctx context.Context) error {
 			return fmt.Errorf("error initializing feed broadcast server: %w", err)
 		}
 	}
-	if n.InboxTracker != nil && n.BroadcastServer != nil {
+	if n.BroadcastServer != nil {
 		// Even if the sequencer coordinator will populate this backlog,
 		// we want to make sure it's populated before any clients connect.
-		err = n.InboxTracker.PopulateFeedBacklog(n.BroadcastServer)
+		err = n.TxStreamer.PopulateFeedBacklog()
 		if err != nil {
 			return fmt.Errorf("error populating feed backlog on startup: %w", err)
 		}
@@ -903,6 +992,9 @@ func (n *Node) Start(ctx context.Context) error {
 	if n.MessagePruner != nil {
 		n.MessagePruner.Start(ctx)
 	}
+	if n.MessageBackupAgent != nil {
+		n.MessageBackupAgent.Start(ctx)
+	}
 	if n.Staker != nil {
 		err = n.Staker.Initialize(ctx)
 		if err != nil {
@@ -977,6 +1069,9 @@ func (n *Node) StopAndWait() {
 	if n.MessagePruner != nil && n.MessagePruner.Started() {
 		n.MessagePruner.StopAndWait()
 	}
+	if n.MessageBackupAgent != nil && n.MessageBackupAgent.Started() {
+		n.MessageBackupAgent.StopAndWait()
+	}
 	if n.BroadcastServer != nil && n.BroadcastServer.Started() {
 		n.BroadcastServer.StopAndWait()
 	}
@@ -1050,6 +1145,19 @@ func (n *Node) WriteMessageFromSequencer(pos arbutil.MessageIndex, msgWithMeta a
 	return n.TxStreamer.WriteMessageFromSequencer(pos, msgWithMeta, msgResult)
 }
 
+// SetEspressoSubmissionDeadline tags a sequenced message with the time it must be included in a HotShot block by
+func (n *Node) SetEspressoSubmissionDeadline(pos arbutil.MessageIndex, deadline time.Time) error {
+	return n.TxStreamer.SetEspressoSubmissionDeadline(pos, deadline)
+}
+
+func (n *Node) CommitSequencedMessages() (time.Duration, error) {
+	return n.TxStreamer.CommitSequencedMessages()
+}
+
+func (n *Node) WaitSequencedMessage(ctx context.Context, pos arbutil.MessageIndex) error {
+	return n.TxStreamer.WaitSequencedMessage(ctx, pos)
+}
+
 func (n *Node) ExpectChosenSequencer() error {
 	return n.TxStreamer.ExpectChosenSequencer()
 }
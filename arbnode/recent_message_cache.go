@@ -0,0 +1,144 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package arbnode
+
+import (
+	"sync/atomic"
+
+	"github.com/ethereum/go-ethereum/metrics"
+
+	"github.com/offchainlabs/nitro/arbos/arbostypes"
+	"github.com/offchainlabs/nitro/arbutil"
+)
+
+var (
+	recentMessageCacheHitCounter  = metrics.NewRegisteredCounter("arb/streamer/recentcache/hit", nil)
+	recentMessageCacheMissCounter = metrics.NewRegisteredCounter("arb/streamer/recentcache/miss", nil)
+)
+
+type recentMessage struct {
+	pos arbutil.MessageIndex
+	// The block hash as stored, before the trustless replica filter is applied
+	msg arbostypes.MessageWithMetadataAndBlockHash
+}
+
+// recentMessageCache keeps the decoded messages of the most recently written positions in a ring indexed by
+// position, so that ExecuteNextMsg doesn't read back from the database the messages that were just written by
+// the sequencer or the feed. Slots are swapped atomically and readers never block writers. Messages are only
+// added on the write path, under the streamer's insertion mutex once their batch is written: a reader adding the
+// message it read could put back a message a reorg just dropped. Dropping a message only makes the next read
+// miss, so it's done wherever a stored message changes. A nil cache caches nothing.
+type recentMessageCache struct {
+	slots []atomic.Pointer[recentMessage]
+}
+
+func newRecentMessageCache(size uint64) *recentMessageCache {
+	if size == 0 {
+		return nil
+	}
+	return &recentMessageCache{slots: make([]atomic.Pointer[recentMessage], size)}
+}
+
+func (c *recentMessageCache) slot(pos arbutil.MessageIndex) *atomic.Pointer[recentMessage] {
+	return &c.slots[uint64(pos)%uint64(len(c.slots))]
+}
+
+// copyMessageWithMetadata copies msg deeply enough for the copy to be filled in without affecting msg
+func copyMessageWithMetadata(msg *arbostypes.MessageWithMetadata) arbostypes.MessageWithMetadata {
+	res := *msg
+	if msg.Message != nil {
+		l1Msg := *msg.Message
+		res.Message = &l1Msg
+	}
+	return res
+}
+
+// get returns a copy of the message at pos if it's cached
+func (c *recentMessageCache) get(pos arbutil.MessageIndex) (*arbostypes.MessageWithMetadataAndBlockHash, bool) {
+	if c == nil {
+		return nil, false
+	}
+	entry := c.slot(pos).Load()
+	if entry == nil || entry.pos != pos {
+		recentMessageCacheMissCounter.Inc(1)
+		return nil, false
+	}
+	recentMessageCacheHitCounter.Inc(1)
+	return &arbostypes.MessageWithMetadataAndBlockHash{
+		MessageWithMeta: copyMessageWithMetadata(&entry.msg.MessageWithMeta),
+		BlockHash:       entry.msg.BlockHash,
+	}, true
+}
+
+func (c *recentMessageCache) add(pos arbutil.MessageIndex, msg *arbostypes.MessageWithMetadataAndBlockHash) {
+	if c == nil {
+		return
+	}
+	l1Msg := msg.MessageWithMeta.Message
+	if l1Msg == nil || l1Msg.Header == nil {
+		return
+	}
+	// Batch posting reports are completed with the batch gas cost when they're read, which must not be skipped
+	if l1Msg.Header.Kind == arbostypes.L1MessageType_BatchPostingReport && l1Msg.BatchGasCost == nil {
+		c.slot(pos).Store(nil)
+		return
+	}
+	c.slot(pos).Store(&recentMessage{
+		pos: pos,
+		msg: arbostypes.MessageWithMetadataAndBlockHash{
+			MessageWithMeta: copyMessageWithMetadata(&msg.MessageWithMeta),
+			BlockHash:       msg.BlockHash,
+		},
+	})
+}
+
+// drop drops the cached message at pos
+func (c *recentMessageCache) drop(pos arbutil.MessageIndex) {
+	if c == nil {
+		return
+	}
+	slot := c.slot(pos)
+	if entry := slot.Load(); entry != nil && entry.pos == pos {
+		slot.CompareAndSwap(entry, nil)
+	}
+}
+
+// addMessages caches messages written to the database starting at pos
+func (c *recentMessageCache) addMessages(pos arbutil.MessageIndex, messages []arbostypes.MessageWithMetadataAndBlockHash) {
+	if c == nil {
+		return
+	}
+	// Only the last messages fit in the ring
+	start := 0
+	if len(messages) > len(c.slots) {
+		start = len(messages) - len(c.slots)
+	}
+	for i := start; i < len(messages); i++ {
+		// #nosec G115
+		c.add(pos+arbutil.MessageIndex(i), &messages[i])
+	}
+}
+
+// invalidate drops the cached messages that aren't in [start, end)
+func (c *recentMessageCache) invalidate(start arbutil.MessageIndex, end arbutil.MessageIndex) {
+	if c == nil {
+		return
+	}
+	for i := range c.slots {
+		entry := c.slots[i].Load()
+		if entry != nil && (entry.pos < start || entry.pos >= end) {
+			c.slots[i].CompareAndSwap(entry, nil)
+		}
+	}
+}
+
+// truncate drops all cached messages at count or later
+func (c *recentMessageCache) truncate(count arbutil.MessageIndex) {
+	c.invalidate(0, count)
+}
+
+// pruned drops all cached messages before count
+func (c *recentMessageCache) pruned(count arbutil.MessageIndex) {
+	c.invalidate(count, ^arbutil.MessageIndex(0))
+}
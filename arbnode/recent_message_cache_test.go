@@ -0,0 +1,99 @@
+package arbnode
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"github.com/offchainlabs/nitro/arbos/arbostypes"
+)
+
+func recentTestMessage(timestamp uint64) arbostypes.MessageWithMetadataAndBlockHash {
+	return arbostypes.MessageWithMetadataAndBlockHash{
+		MessageWithMeta: arbostypes.MessageWithMetadata{
+			Message: &arbostypes.L1IncomingMessage{Header: &arbostypes.L1IncomingMessageHeader{Timestamp: timestamp}},
+		},
+		BlockHash: &common.Hash{byte(timestamp)},
+	}
+}
+
+func TestRecentMessageCache(t *testing.T) {
+	cache := newRecentMessageCache(4)
+	var messages []arbostypes.MessageWithMetadataAndBlockHash
+	for i := uint64(0); i < 6; i++ {
+		messages = append(messages, recentTestMessage(i))
+	}
+	cache.addMessages(0, messages)
+
+	// Only the last four messages fit in the ring
+	if _, ok := cache.get(1); ok {
+		Fail(t, "message evicted from the ring still cached")
+	}
+	msg, ok := cache.get(5)
+	if !ok || msg.MessageWithMeta.Message.Header.Timestamp != 5 || *msg.BlockHash != (common.Hash{5}) {
+		Fail(t, "unexpected cached message", msg)
+	}
+	// Readers get copies that can be filled in
+	gas := uint64(1)
+	msg.MessageWithMeta.Message.BatchGasCost = &gas
+	if msg, _ = cache.get(5); msg.MessageWithMeta.Message.BatchGasCost != nil {
+		Fail(t, "cached message modified through a copy")
+	}
+
+	cache.truncate(4)
+	if _, ok := cache.get(4); ok {
+		Fail(t, "reorged message still cached")
+	}
+	if _, ok := cache.get(3); !ok {
+		Fail(t, "message before the reorg dropped")
+	}
+	cache.drop(2)
+	if _, ok := cache.get(2); ok {
+		Fail(t, "dropped message still cached")
+	}
+	cache.drop(7)
+	if _, ok := cache.get(3); !ok {
+		Fail(t, "message sharing a slot with the dropped position dropped")
+	}
+	cache.pruned(4)
+	if _, ok := cache.get(3); ok {
+		Fail(t, "pruned message still cached")
+	}
+
+	report := recentTestMessage(7)
+	report.MessageWithMeta.Message.Header.Kind = arbostypes.L1MessageType_BatchPostingReport
+	cache.add(7, &report)
+	if _, ok := cache.get(7); ok {
+		Fail(t, "batch posting report without a batch gas cost cached")
+	}
+
+	var disabled *recentMessageCache
+	disabled.add(0, &messages[0])
+	if _, ok := disabled.get(0); ok {
+		Fail(t, "disabled cache returned a message")
+	}
+}
+
+func TestStreamerRecentMessages(t *testing.T) {
+	streamer := newTestImportStreamer(t)
+	var messages []arbostypes.MessageWithMetadata
+	for i := uint64(0); i < 3; i++ {
+		messages = append(messages, recentTestMessage(i).MessageWithMeta)
+	}
+	Require(t, streamer.AddMessages(0, true, messages))
+	// Messages written are served from the cache without a database read
+	Require(t, streamer.db.Delete(dbKey(messagePrefix, 2)))
+	msg, err := streamer.getMessageWithMetadataAndBlockHash(2)
+	Require(t, err)
+	if msg.MessageWithMeta.Message.Header.Timestamp != 2 {
+		Fail(t, "unexpected message", msg.MessageWithMeta.Message.Header.Timestamp)
+	}
+
+	// Messages read from the database aren't cached, a reorg could drop them before the read returns
+	streamer.recentMessages.truncate(0)
+	_, err = streamer.getMessageWithMetadataAndBlockHash(1)
+	Require(t, err)
+	if _, ok := streamer.recentMessages.get(1); ok {
+		Fail(t, "message read from the database cached")
+	}
+}
@@ -0,0 +1,254 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package arbnode
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/offchainlabs/nitro/arbutil"
+)
+
+// An in-flight transaction not found on HotShot is only reported once it's older than this,
+// since a fresh submission may not have been indexed by the query service yet
+const recoveryOrphanedSubmissionAge = time.Minute
+
+type RecoverySeverity string
+
+const (
+	RecoverySeverityInfo     RecoverySeverity = "info"
+	RecoverySeverityWarning  RecoverySeverity = "warning"
+	RecoverySeverityCritical RecoverySeverity = "critical"
+)
+
+// RecoveryAction identifies the suggested remedy of a finding, so that runbooks can be keyed on it
+type RecoveryAction string
+
+const (
+	// Wait for the espresso submission loop to reconcile the in-flight transaction with HotShot,
+	// or restart the node, which reconciles it before the first submission
+	RecoveryActionEspressoReconcile RecoveryAction = "espresso-reconcile"
+	// The in-flight submission can't be reconciled, its messages must be moved back to the pending queue
+	RecoveryActionEspressoRequeue RecoveryAction = "espresso-requeue"
+	// The espresso queues reference messages that were reorged out and must be dropped from them
+	RecoveryActionEspressoDropStale RecoveryAction = "espresso-drop-stale"
+	// Check the connectivity to the HotShot query nodes
+	RecoveryActionCheckHotShot RecoveryAction = "check-hotshot"
+	// Execution is ahead of the streamer and must be reorged back to the message count
+	RecoveryActionReorgExecution RecoveryAction = "reorg-execution"
+	// Batches were read without their messages, the inbox must be read again from the last good batch
+	RecoveryActionInboxResync RecoveryAction = "inbox-resync"
+	// Check the connectivity to the coordinator's redis
+	RecoveryActionCheckRedis RecoveryAction = "check-redis"
+	// Hand off the chosen sequencer role, e.g. with seq-coordinator-manager, until the node caught up
+	RecoveryActionCoordinatorHandoff RecoveryAction = "coordinator-handoff"
+	// The in-memory state drifted from the database, restart the node to reload it
+	RecoveryActionRestart RecoveryAction = "restart"
+)
+
+// RecoveryFinding is a single inconsistency detected in the node state
+type RecoveryFinding struct {
+	Component string           `json:"component"`
+	Issue     string           `json:"issue"`
+	Severity  RecoverySeverity `json:"severity"`
+	Details   string           `json:"details"`
+	Action    RecoveryAction   `json:"action"`
+}
+
+// RecoveryReport is a snapshot of the streamer, inbox, coordinator and espresso state
+// together with the inconsistencies detected between them
+type RecoveryReport struct {
+	GeneratedAt             uint64                `json:"generatedAt"`
+	MessageCount            arbutil.MessageIndex  `json:"messageCount"`
+	ExecutedMessageCount    *arbutil.MessageIndex `json:"executedMessageCount,omitempty"`
+	BatchCount              *uint64               `json:"batchCount,omitempty"`
+	BatchMessageCount       *arbutil.MessageIndex `json:"batchMessageCount,omitempty"`
+	CoordinatorMessageCount *arbutil.MessageIndex `json:"coordinatorMessageCount,omitempty"`
+	CurrentlyChosen         *bool                 `json:"currentlyChosen,omitempty"`
+	Espresso                *EspressoStatus       `json:"espresso"`
+	Findings                []RecoveryFinding     `json:"findings"`
+}
+
+func (r *RecoveryReport) add(component string, issue string, severity RecoverySeverity, action RecoveryAction, details string, args ...interface{}) {
+	r.Findings = append(r.Findings, RecoveryFinding{
+		Component: component,
+		Issue:     issue,
+		Severity:  severity,
+		Details:   fmt.Sprintf(details, args...),
+		Action:    action,
+	})
+}
+
+// DiagnoseRecovery inspects the node state for inconsistencies and suggests a recovery action for each of them.
+// It only reads state, and may be run while the node is stuck.
+func (s *TransactionStreamer) DiagnoseRecovery(ctx context.Context) (*RecoveryReport, error) {
+	msgCount, err := s.GetMessageCount()
+	if err != nil {
+		return nil, err
+	}
+	report := &RecoveryReport{
+		// #nosec G115
+		GeneratedAt:  uint64(time.Now().Unix()),
+		MessageCount: msgCount,
+		Findings:     []RecoveryFinding{},
+	}
+	if err := s.diagnoseExecution(report); err != nil {
+		return nil, err
+	}
+	if err := s.diagnoseInbox(report); err != nil {
+		return nil, err
+	}
+	s.diagnoseCoordinator(report)
+	if err := s.diagnoseEspresso(ctx, report); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+func (s *TransactionStreamer) diagnoseExecution(report *RecoveryReport) error {
+	if s.exec == nil {
+		return nil
+	}
+	head, err := s.exec.HeadMessageNumber()
+	if err != nil {
+		report.add("execution", "head-unavailable", RecoverySeverityWarning, RecoveryActionRestart, "failed to read the execution head: %v", err)
+		return nil
+	}
+	executed := head + 1
+	report.ExecutedMessageCount = &executed
+	if executed > report.MessageCount {
+		report.add("execution", "execution-ahead", RecoverySeverityCritical, RecoveryActionReorgExecution,
+			"execution has %d messages but the streamer only %d", executed, report.MessageCount)
+	}
+	return nil
+}
+
+func (s *TransactionStreamer) diagnoseInbox(report *RecoveryReport) error {
+	if s.inboxReader == nil {
+		return nil
+	}
+	tracker := s.inboxReader.tracker
+	batchCount, err := tracker.GetBatchCount()
+	if err != nil {
+		return err
+	}
+	report.BatchCount = &batchCount
+	if batchCount == 0 {
+		return nil
+	}
+	batchMsgCount, err := tracker.GetBatchMessageCount(batchCount - 1)
+	if err != nil {
+		return err
+	}
+	report.BatchMessageCount = &batchMsgCount
+	if batchMsgCount > report.MessageCount {
+		report.add("inbox", "batch-messages-missing", RecoverySeverityCritical, RecoveryActionInboxResync,
+			"batch %d ends at message %d but the streamer only has %d messages", batchCount-1, batchMsgCount, report.MessageCount)
+	}
+	return nil
+}
+
+func (s *TransactionStreamer) diagnoseCoordinator(report *RecoveryReport) {
+	if s.coordinator == nil {
+		return
+	}
+	chosen := s.coordinator.CurrentlyChosen()
+	report.CurrentlyChosen = &chosen
+	remoteCount, err := s.coordinator.GetRemoteMsgCount()
+	if err != nil {
+		report.add("coordinator", "redis-unavailable", RecoverySeverityWarning, RecoveryActionCheckRedis, "failed to read the coordinator message count: %v", err)
+		return
+	}
+	report.CoordinatorMessageCount = &remoteCount
+	if chosen && remoteCount > report.MessageCount {
+		report.add("coordinator", "chosen-sequencer-behind", RecoverySeverityCritical, RecoveryActionCoordinatorHandoff,
+			"the chosen sequencer has %d messages but the coordinator has %d", report.MessageCount, remoteCount)
+	}
+}
+
+func (s *TransactionStreamer) diagnoseEspresso(ctx context.Context, report *RecoveryReport) error {
+	status, err := s.GetEspressoStatus()
+	if err != nil {
+		return err
+	}
+	report.Espresso = status
+	msgCount := report.MessageCount
+
+	if s.espressoUnreachable.Load() {
+		report.add("espresso", "hotshot-unreachable", RecoverySeverityWarning, RecoveryActionCheckHotShot,
+			"the hotshot query service has been unreachable for longer than %v", s.config().Espresso.UnreachableThreshold)
+	}
+	// #nosec G115
+	if pending := int64(len(status.PendingPositions)); pending != s.espressoPendingCount.Load() {
+		report.add("espresso", "pending-count-drift", RecoverySeverityWarning, RecoveryActionRestart,
+			"%d positions are queued but %d are accounted for sequencer backpressure", pending, s.espressoPendingCount.Load())
+	}
+	queued := make(map[arbutil.MessageIndex]bool, len(status.SubmittedPositions)+len(status.PendingPositions))
+	for _, positions := range [][]arbutil.MessageIndex{status.SubmittedPositions, status.PendingPositions} {
+		for _, pos := range positions {
+			if queued[pos] {
+				report.add("espresso", "position-queued-twice", RecoverySeverityCritical, RecoveryActionEspressoRequeue,
+					"message %d is queued for submission more than once", pos)
+			}
+			queued[pos] = true
+			if pos >= msgCount {
+				report.add("espresso", "stale-position", RecoverySeverityCritical, RecoveryActionEspressoDropStale,
+					"message %d is queued for submission but the streamer only has %d messages", pos, msgCount)
+			}
+		}
+	}
+	if status.LastConfirmedPos != nil && *status.LastConfirmedPos >= msgCount {
+		report.add("espresso", "confirmed-position-stale", RecoverySeverityCritical, RecoveryActionEspressoDropStale,
+			"message %d was confirmed on hotshot but the streamer only has %d messages", *status.LastConfirmedPos, msgCount)
+	}
+
+	if len(status.SubmittedPositions) == 0 {
+		return nil
+	}
+	if s.espressoUncleanShutdown.Load() {
+		report.add("espresso", "unclean-shutdown", RecoverySeverityWarning, RecoveryActionEspressoReconcile,
+			"the previous run wasn't shut down cleanly with %d messages in flight, they weren't reconciled with hotshot yet", len(status.SubmittedPositions))
+	}
+	if status.SubmittedTxHash == nil {
+		report.add("espresso", "submitted-hash-missing", RecoverySeverityCritical, RecoveryActionEspressoRequeue,
+			"%d messages are in flight without a transaction hash", len(status.SubmittedPositions))
+		return nil
+	}
+	payload, err := s.getEspressoSubmittedPayload()
+	if err != nil {
+		return err
+	}
+	if payload == nil {
+		report.add("espresso", "submitted-payload-missing", RecoverySeverityWarning, RecoveryActionEspressoReconcile,
+			"in-flight transaction %s is missing its payload, its messages are requeued on reconciliation", *status.SubmittedTxHash)
+	}
+	record, err := s.GetEspressoSubmissionRecord(status.SubmittedPositions[0])
+	if err != nil {
+		return err
+	}
+	if record != nil && record.Attempts > s.config().Espresso.MaxResubmissions {
+		report.add("espresso", "resubmissions-exhausted", RecoverySeverityCritical, RecoveryActionEspressoRequeue,
+			"transaction %s was resubmitted %d times without being included", *status.SubmittedTxHash, record.Attempts)
+	}
+	if s.espressoClient == nil {
+		return nil
+	}
+	hash, err := s.getEspressoSubmittedHash()
+	if err != nil || hash == nil {
+		return err
+	}
+	if _, err := s.espressoClient.FetchTransactionByHash(ctx, hash); err != nil {
+		var submittedAt time.Time
+		if record != nil {
+			// #nosec G115
+			submittedAt = time.Unix(int64(record.UpdatedAt), 0)
+		}
+		if time.Since(submittedAt) >= recoveryOrphanedSubmissionAge {
+			report.add("espresso", "submitted-hash-orphaned", RecoverySeverityWarning, RecoveryActionEspressoReconcile,
+				"in-flight transaction %s submitted at %v is unknown to hotshot: %v", hash.String(), submittedAt.UTC(), err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package arbnode
+
+import (
+	"context"
+	"testing"
+
+	tagged_base64 "github.com/EspressoSystems/espresso-sequencer-go/tagged-base64"
+	"github.com/ethereum/go-ethereum/rlp"
+
+	"github.com/offchainlabs/nitro/arbos/arbostypes"
+	"github.com/offchainlabs/nitro/arbutil"
+)
+
+func TestDiagnoseRecovery(t *testing.T) {
+	ctx := context.Background()
+	streamer := newTestImportStreamer(t)
+	streamer.espressoClient = &countingEspressoClient{}
+	var messages []arbostypes.MessageWithMetadata
+	for i := uint64(0); i < 3; i++ {
+		messages = append(messages, arbostypes.MessageWithMetadata{
+			Message: &arbostypes.L1IncomingMessage{Header: &arbostypes.L1IncomingMessageHeader{Timestamp: i}},
+		})
+	}
+	Require(t, streamer.AddMessages(0, true, messages))
+
+	report, err := streamer.DiagnoseRecovery(ctx)
+	Require(t, err)
+	if len(report.Findings) != 0 {
+		Fail(t, "unexpected findings in a consistent state", report.Findings)
+	}
+
+	// Messages 3 and 4 were reorged out while queued, and the in-flight transaction is unknown to hotshot
+	hash, err := tagged_base64.New("TX", []byte{1, 2, 3})
+	Require(t, err)
+	batch := streamer.db.NewBatch()
+	Require(t, streamer.setEspressoSubmittedPos(batch, []arbutil.MessageIndex{2, 3}))
+	Require(t, streamer.setEspressoSubmittedHash(batch, hash))
+	Require(t, streamer.setEspressoPendingTxnsPos(batch, []arbutil.MessageIndex{4}))
+	confirmed := arbutil.MessageIndex(5)
+	Require(t, streamer.setEspressoLastConfirmedPos(batch, &confirmed))
+	record, err := rlp.EncodeToBytes(EspressoSubmissionRecord{Status: EspressoSubmissionSubmitted, TxHash: hash.String(), UpdatedAt: 1})
+	Require(t, err)
+	Require(t, batch.Put(dbKey(espressoSubmissionPrefix, 2), record))
+	Require(t, batch.Write())
+
+	report, err = streamer.DiagnoseRecovery(ctx)
+	Require(t, err)
+	issues := make(map[string]int)
+	for _, finding := range report.Findings {
+		issues[finding.Issue]++
+	}
+	expected := map[string]int{
+		"stale-position":            2,
+		"confirmed-position-stale":  1,
+		"submitted-payload-missing": 1,
+		"submitted-hash-orphaned":   1,
+	}
+	if len(issues) != len(expected) {
+		Fail(t, "unexpected findings", report.Findings)
+	}
+	for issue, count := range expected {
+		if issues[issue] != count {
+			Fail(t, "unexpected number of findings for", issue, issues[issue])
+		}
+	}
+}
@@ -0,0 +1,200 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package arbnode
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/rlp"
+
+	"github.com/offchainlabs/nitro/arbutil"
+	"github.com/offchainlabs/nitro/util/dbutil"
+)
+
+const (
+	ReorgSourceConfirmed = "confirmed"
+	ReorgSourceFeed      = "feed"
+)
+
+var (
+	reorgConfirmedCounter   = metrics.NewRegisteredCounter("arb/streamer/reorg/confirmed", nil)
+	reorgFeedCounter        = metrics.NewRegisteredCounter("arb/streamer/reorg/feed", nil)
+	reorgResequencedCounter = metrics.NewRegisteredCounter("arb/streamer/reorg/resequenced", nil)
+	reorgDroppedCounter     = metrics.NewRegisteredCounter("arb/streamer/reorg/dropped", nil)
+	reorgLastDepthGauge     = metrics.NewRegisteredGauge("arb/streamer/reorg/last_depth", nil)
+	reorgLastTimestampGauge = metrics.NewRegisteredGauge("arb/streamer/reorg/last_timestamp", nil)
+	reorgDepthHistogram     = metrics.NewRegisteredHistogram("arb/streamer/reorg/depth", nil, metrics.NewBoundedHistogramSample())
+)
+
+// ReorgRecord describes a single reorg seen by the TransactionStreamer.
+// Confirmed records are written when the reorg is applied to the database,
+// feed records when the feed disagrees with the database (the feed is never
+// allowed to reorg confirmed messages, so those are detections only).
+type ReorgRecord struct {
+	Timestamp   uint64 `json:"timestamp"`
+	Source      string `json:"source"`
+	Position    uint64 `json:"position"`
+	Depth       uint64 `json:"depth"`
+	Resequenced uint64 `json:"resequenced"`
+}
+
+func (s *TransactionStreamer) getReorgHistoryCount() (uint64, error) {
+	data, err := s.db.Get(reorgHistoryCountKey)
+	if err != nil {
+		if dbutil.IsErrNotFound(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	var count uint64
+	err = rlp.DecodeBytes(data, &count)
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// updateReorgMetrics counts a reorg of depth messages, of which resequenced were sequenced again. The messages
+// removed by a confirmed reorg that weren't resequenced are dropped, feed reorgs are detections only.
+func updateReorgMetrics(source string, depth uint64, resequenced uint64) {
+	if source == ReorgSourceConfirmed {
+		reorgConfirmedCounter.Inc(1)
+		if depth > resequenced {
+			// #nosec G115
+			reorgDroppedCounter.Inc(int64(depth - resequenced))
+		}
+	} else {
+		reorgFeedCounter.Inc(1)
+	}
+	// #nosec G115
+	reorgResequencedCounter.Inc(int64(resequenced))
+	// #nosec G115
+	reorgLastDepthGauge.Update(int64(depth))
+	// #nosec G115
+	reorgDepthHistogram.Update(int64(depth))
+	reorgLastTimestampGauge.Update(time.Now().Unix())
+}
+
+// recordReorg appends a reorg record to the history in the given batch and updates metrics.
+// Records beyond the configured history size are pruned from the tail.
+func (s *TransactionStreamer) recordReorg(batch ethdb.KeyValueWriter, source string, pos arbutil.MessageIndex, depth uint64, resequenced uint64) error {
+	updateReorgMetrics(source, depth, resequenced)
+
+	count, err := s.getReorgHistoryCount()
+	if err != nil {
+		return err
+	}
+	// The count is always advanced, so that consumers can tell that a reorg
+	// happened even if its record isn't kept.
+	countBytes, err := rlp.EncodeToBytes(count + 1)
+	if err != nil {
+		return err
+	}
+	if err := batch.Put(reorgHistoryCountKey, countBytes); err != nil {
+		return err
+	}
+	historySize := s.config().ReorgHistorySize
+	if historySize == 0 {
+		return s.pruneReorgHistory(batch, count+1)
+	}
+	record := ReorgRecord{
+		// #nosec G115
+		Timestamp:   uint64(time.Now().Unix()),
+		Source:      source,
+		Position:    uint64(pos),
+		Depth:       depth,
+		Resequenced: resequenced,
+	}
+	recordBytes, err := rlp.EncodeToBytes(record)
+	if err != nil {
+		return err
+	}
+	if err := batch.Put(dbKey(reorgHistoryPrefix, count), recordBytes); err != nil {
+		return err
+	}
+	if count+1 > historySize {
+		return s.pruneReorgHistory(batch, count+1-historySize)
+	}
+	return nil
+}
+
+// pruneReorgHistory deletes the reorg records below end. Every older record is deleted rather than only the one
+// falling out of the history, as a hot reload may have lowered the history size.
+func (s *TransactionStreamer) pruneReorgHistory(batch ethdb.KeyValueWriter, end uint64) error {
+	endKey := dbKey(reorgHistoryPrefix, end)
+	iter := s.db.NewIterator(reorgHistoryPrefix, nil)
+	defer iter.Release()
+	for iter.Next() {
+		if bytes.Compare(iter.Key(), endKey) >= 0 {
+			break
+		}
+		if err := batch.Delete(iter.Key()); err != nil {
+			return err
+		}
+	}
+	return iter.Error()
+}
+
+// feedReorgDepth returns the number of stored messages the feed disagrees with from pos
+func (s *TransactionStreamer) feedReorgDepth(pos arbutil.MessageIndex) (uint64, error) {
+	msgCount, err := s.GetMessageCount()
+	if err != nil {
+		return 0, err
+	}
+	if msgCount > pos {
+		return uint64(msgCount - pos), nil
+	}
+	return 0, nil
+}
+
+// recordFeedReorg records a reorg detected on the feed. Failures are only logged,
+// as the history is informational and must not interfere with message insertion.
+func (s *TransactionStreamer) recordFeedReorg(pos arbutil.MessageIndex) {
+	depth, err := s.feedReorgDepth(pos)
+	if err != nil {
+		log.Warn("failed to get message count for reorg history", "err", err)
+		return
+	}
+	batch := s.db.NewBatch()
+	if err := s.recordReorg(batch, ReorgSourceFeed, pos, depth, 0); err != nil {
+		log.Warn("failed to record feed reorg", "pos", pos, "err", err)
+		return
+	}
+	if err := batch.Write(); err != nil {
+		log.Warn("failed to record feed reorg", "pos", pos, "err", err)
+	}
+}
+
+// GetReorgHistory returns up to limit of the most recent reorg records, newest first.
+// A limit of 0 returns all stored records.
+func (s *TransactionStreamer) GetReorgHistory(limit uint64) ([]ReorgRecord, error) {
+	count, err := s.getReorgHistoryCount()
+	if err != nil {
+		return nil, err
+	}
+	var records []ReorgRecord
+	for i := count; i > 0; i-- {
+		if limit != 0 && uint64(len(records)) >= limit {
+			break
+		}
+		data, err := s.db.Get(dbKey(reorgHistoryPrefix, i-1))
+		if err != nil {
+			if dbutil.IsErrNotFound(err) {
+				// Older records have been pruned
+				break
+			}
+			return nil, err
+		}
+		var record ReorgRecord
+		if err := rlp.DecodeBytes(data, &record); err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
@@ -0,0 +1,119 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package arbnode
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/core/rawdb"
+
+	"github.com/offchainlabs/nitro/arbutil"
+)
+
+func TestReorgHistoryPruning(t *testing.T) {
+	config := TestTransactionStreamerConfig
+	config.ReorgHistorySize = 3
+	streamer := &TransactionStreamer{
+		db:     rawdb.NewMemoryDatabase(),
+		config: func() *TransactionStreamerConfig { return &config },
+	}
+
+	for i := uint64(1); i <= 5; i++ {
+		batch := streamer.db.NewBatch()
+		Require(t, streamer.recordReorg(batch, ReorgSourceConfirmed, arbutil.MessageIndex(i*10), i, i-1))
+		Require(t, batch.Write())
+	}
+
+	records, err := streamer.GetReorgHistory(0)
+	Require(t, err)
+	if len(records) != 3 {
+		Fail(t, "expected 3 records, got", len(records))
+	}
+	for i, record := range records {
+		expectedDepth := uint64(5 - i)
+		if record.Depth != expectedDepth {
+			Fail(t, "record", i, "expected depth", expectedDepth, "got", record.Depth)
+		}
+		if record.Source != ReorgSourceConfirmed {
+			Fail(t, "unexpected source", record.Source)
+		}
+	}
+
+	records, err = streamer.GetReorgHistory(1)
+	Require(t, err)
+	if len(records) != 1 || records[0].Position != 50 {
+		Fail(t, "unexpected limited history", records)
+	}
+
+	// Lowering the history size prunes every record beyond it, not only the oldest one
+	config.ReorgHistorySize = 1
+	batch := streamer.db.NewBatch()
+	Require(t, streamer.recordReorg(batch, ReorgSourceConfirmed, 60, 6, 0))
+	Require(t, batch.Write())
+	for i := uint64(0); i < 5; i++ {
+		if has, err := streamer.db.Has(dbKey(reorgHistoryPrefix, i)); err != nil || has {
+			Fail(t, "reorg record beyond the lowered history size kept", i, err)
+		}
+	}
+	records, err = streamer.GetReorgHistory(0)
+	Require(t, err)
+	if len(records) != 1 || records[0].Position != 60 {
+		Fail(t, "unexpected history after lowering its size", records)
+	}
+
+	// Disabling the history deletes the kept records
+	config.ReorgHistorySize = 0
+	batch = streamer.db.NewBatch()
+	Require(t, streamer.recordReorg(batch, ReorgSourceConfirmed, 70, 7, 0))
+	Require(t, batch.Write())
+	records, err = streamer.GetReorgHistory(0)
+	Require(t, err)
+	if len(records) != 0 {
+		Fail(t, "history kept after disabling it", records)
+	}
+}
+
+func TestFeedReorgsRecordedWhileLogRateLimited(t *testing.T) {
+	streamer := newTestImportStreamer(t)
+	msg := &testChainMessages(0, 1)[0]
+	for i := 0; i < 3; i++ {
+		streamer.logReorg(arbutil.MessageIndex(i), msg, msg, false)
+	}
+	records, err := streamer.GetReorgHistory(0)
+	Require(t, err)
+	if len(records) != 3 {
+		Fail(t, "rate limited feed reorgs missing from the history", len(records))
+	}
+	for i, record := range records {
+		if record.Source != ReorgSourceFeed || record.Position != uint64(2-i) {
+			Fail(t, "unexpected feed reorg record", i, record)
+		}
+	}
+}
+
+func TestReorgMetrics(t *testing.T) {
+	streamer := &TransactionStreamer{
+		db:     rawdb.NewMemoryDatabase(),
+		config: func() *TransactionStreamerConfig { return &TestTransactionStreamerConfig },
+	}
+	dropped := reorgDroppedCounter.Snapshot().Count()
+	resequenced := reorgResequencedCounter.Snapshot().Count()
+	feed := reorgFeedCounter.Snapshot().Count()
+
+	batch := streamer.db.NewBatch()
+	Require(t, streamer.recordReorg(batch, ReorgSourceConfirmed, 10, 5, 2))
+	Require(t, streamer.recordReorg(batch, ReorgSourceFeed, 12, 3, 0))
+	if got := reorgDroppedCounter.Snapshot().Count() - dropped; got != 3 {
+		Fail(t, "unexpected dropped messages", got)
+	}
+	if got := reorgResequencedCounter.Snapshot().Count() - resequenced; got != 2 {
+		Fail(t, "unexpected resequenced messages", got)
+	}
+	if got := reorgFeedCounter.Snapshot().Count() - feed; got != 1 {
+		Fail(t, "unexpected feed reorgs", got)
+	}
+	if reorgLastTimestampGauge.Snapshot().Value() == 0 {
+		Fail(t, "last reorg timestamp not set")
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package arbnode
+
+import (
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/log"
+
+	"github.com/offchainlabs/nitro/arbos/arbostypes"
+	"github.com/offchainlabs/nitro/arbutil"
+)
+
+// ReorgResequencePolicy selects which of the messages removed by a reorg are sequenced again
+type ReorgResequencePolicy string
+
+const (
+	// Delayed messages are resequenced in order, and other messages are replayed through the sequencer
+	ReorgResequenceAll ReorgResequencePolicy = "resequence-all"
+	// Only delayed messages are resequenced, other messages are dropped
+	ReorgResequenceDelayedOnly ReorgResequencePolicy = "resequence-delayed-only"
+	// No message is resequenced
+	ReorgDropAll ReorgResequencePolicy = "drop-all"
+)
+
+func parseReorgResequencePolicy(policy string) (ReorgResequencePolicy, error) {
+	switch ReorgResequencePolicy(policy) {
+	case "":
+		return ReorgResequenceAll, nil
+	case ReorgResequenceAll, ReorgResequenceDelayedOnly, ReorgDropAll:
+		return ReorgResequencePolicy(policy), nil
+	default:
+		return "", fmt.Errorf("invalid reorg resequence policy %q, expected %q, %q or %q", policy, ReorgResequenceAll, ReorgResequenceDelayedOnly, ReorgDropAll)
+	}
+}
+
+// ReorgHook lets the sequencer operator decide for each reorg which of the removed messages are sequenced
+// again, e.g. to drop the messages of a sovereign sequencer instead of replaying them
+type ReorgHook interface {
+	// ResequencePolicy is called with the messages removed by a reorg to count, up to max-reorg-resequence-depth
+	// of them, and the configured policy, and returns the policy applied to them. It's called with the insertion
+	// mutex held, and must not call back into the streamer.
+	ResequencePolicy(count arbutil.MessageIndex, removed []*arbostypes.MessageWithMetadata, configured ReorgResequencePolicy) ReorgResequencePolicy
+}
+
+// SetReorgHook sets the hook consulted on every reorg, it must be called before Start
+func (s *TransactionStreamer) SetReorgHook(hook ReorgHook) {
+	if s.Started() {
+		panic("trying to set reorg hook after start")
+	}
+	s.reorgHook = hook
+}
+
+// configuredReorgResequencePolicy returns the configured policy, resequencing everything as before the
+// policy existed if a reloaded config holds an invalid one
+func (s *TransactionStreamer) configuredReorgResequencePolicy() ReorgResequencePolicy {
+	policy, err := parseReorgResequencePolicy(s.config().ReorgResequencePolicy)
+	if err != nil {
+		log.Error("falling back to resequencing all reorged messages", "err", err)
+		return ReorgResequenceAll
+	}
+	return policy
+}
+
+// reorgResequencePolicy returns the policy applied to the messages removed by a reorg to count
+func (s *TransactionStreamer) reorgResequencePolicy(count arbutil.MessageIndex, removed []*arbostypes.MessageWithMetadata) ReorgResequencePolicy {
+	configured := s.configuredReorgResequencePolicy()
+	if s.reorgHook == nil || len(removed) == 0 {
+		return configured
+	}
+	policy, err := parseReorgResequencePolicy(string(s.reorgHook.ResequencePolicy(count, removed, configured)))
+	if err != nil {
+		log.Error("reorg hook returned an invalid policy, using the configured one", "configured", configured, "err", err)
+		return configured
+	}
+	return policy
+}
@@ -0,0 +1,76 @@
+package arbnode
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"github.com/offchainlabs/nitro/arbos/arbostypes"
+	"github.com/offchainlabs/nitro/arbutil"
+)
+
+type fixedReorgHook struct {
+	policy ReorgResequencePolicy
+	calls  int
+}
+
+func (h *fixedReorgHook) ResequencePolicy(count arbutil.MessageIndex, removed []*arbostypes.MessageWithMetadata, configured ReorgResequencePolicy) ReorgResequencePolicy {
+	h.calls++
+	return h.policy
+}
+
+func TestReorgResequencePolicy(t *testing.T) {
+	streamer := newTestImportStreamer(t)
+	delayedId := common.BigToHash(common.Big1)
+	delayed := &arbostypes.MessageWithMetadata{
+		Message: &arbostypes.L1IncomingMessage{
+			Header: &arbostypes.L1IncomingMessageHeader{Kind: arbostypes.L1MessageType_EndOfBlock, RequestId: &delayedId},
+		},
+		DelayedMessagesRead: 2,
+	}
+	sequenced := &arbostypes.MessageWithMetadata{
+		Message: &arbostypes.L1IncomingMessage{
+			Header: &arbostypes.L1IncomingMessageHeader{Kind: arbostypes.L1MessageType_L2Message},
+		},
+		DelayedMessagesRead: 2,
+	}
+	removed := []*arbostypes.MessageWithMetadata{sequenced, delayed, sequenced}
+
+	for _, tc := range []struct {
+		policy   ReorgResequencePolicy
+		expected int
+	}{
+		{ReorgResequenceAll, 3},
+		{ReorgResequenceDelayedOnly, 1},
+		{ReorgDropAll, 0},
+	} {
+		got := streamer.selectMessagesToResequence(context.Background(), 1, removed, tc.policy)
+		if len(got) != tc.expected {
+			Fail(t, "policy", tc.policy, "resequenced", len(got), "messages, expected", tc.expected)
+		}
+		if tc.policy == ReorgResequenceDelayedOnly && got[0] != delayed {
+			Fail(t, "delayed only policy resequenced a sequenced message")
+		}
+	}
+
+	if policy := streamer.reorgResequencePolicy(1, removed); policy != ReorgResequenceAll {
+		Fail(t, "unexpected default policy", policy)
+	}
+	hook := &fixedReorgHook{policy: ReorgResequenceDelayedOnly}
+	streamer.SetReorgHook(hook)
+	if policy := streamer.reorgResequencePolicy(1, removed); policy != ReorgResequenceDelayedOnly {
+		Fail(t, "hook policy not applied", policy)
+	}
+	hook.policy = "replay-some"
+	if policy := streamer.reorgResequencePolicy(1, removed); policy != ReorgResequenceAll {
+		Fail(t, "invalid hook policy not replaced by the configured one", policy)
+	}
+	if policy := streamer.reorgResequencePolicy(1, nil); policy != ReorgResequenceAll || hook.calls != 2 {
+		Fail(t, "hook called without removed messages", hook.calls)
+	}
+
+	if _, err := parseReorgResequencePolicy("replay-some"); err == nil {
+		Fail(t, "invalid policy accepted")
+	}
+}
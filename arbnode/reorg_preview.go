@@ -0,0 +1,199 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package arbnode
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+
+	"github.com/offchainlabs/nitro/arbos/arbostypes"
+	"github.com/offchainlabs/nitro/arbutil"
+	"github.com/offchainlabs/nitro/execution"
+	"github.com/offchainlabs/nitro/util/dbutil"
+)
+
+// Maximum number of messages listed in a reorg preview when no limit is given, all messages are still counted
+const defaultReorgPreviewLimit = 10_000
+
+// ReorgPreviewClass classifies a message that a reorg would remove
+type ReorgPreviewClass string
+
+const (
+	// A delayed message that would be resequenced at the same position after the reorg
+	ReorgPreviewDelayedResequencable ReorgPreviewClass = "delayed-resequencable"
+	// A message that was finalized on HotShot, which replicas may already have acted on
+	ReorgPreviewEspressoFinalized ReorgPreviewClass = "espresso-finalized"
+	// A delayed message that can't be resequenced, it's read again from the delayed inbox by a later batch
+	ReorgPreviewDelayedDropped ReorgPreviewClass = "delayed-dropped"
+	// A message only known to the sequencer, which is lost unless it's replayed through the sequencer
+	ReorgPreviewSequencerOnly ReorgPreviewClass = "sequencer-only"
+)
+
+// ReorgPreviewMessage describes a message that a reorg would remove
+type ReorgPreviewMessage struct {
+	Pos   arbutil.MessageIndex `json:"pos"`
+	Class ReorgPreviewClass    `json:"class"`
+	// Hash of the message as stored in the database
+	MessageHash common.Hash `json:"messageHash"`
+	// Hash of the block the message produced, if it was executed
+	BlockHash *common.Hash `json:"blockHash,omitempty"`
+	// Whether the message would be resequenced under the configured policy
+	Resequenced bool `json:"resequenced"`
+}
+
+// ReorgPreview describes what a reorg to Count would remove, without applying it
+type ReorgPreview struct {
+	Count        arbutil.MessageIndex         `json:"count"`
+	MessageCount arbutil.MessageIndex         `json:"messageCount"`
+	Policy       ReorgResequencePolicy        `json:"policy"`
+	Counts       map[ReorgPreviewClass]uint64 `json:"counts"`
+	Resequenced  uint64                       `json:"resequenced"`
+	Dropped      uint64                       `json:"dropped"`
+	Messages     []ReorgPreviewMessage        `json:"messages"`
+	// Set when more messages would be removed than are listed
+	Truncated bool `json:"truncated"`
+}
+
+// storedBlockHash returns the block hash stored for the message at pos, without asking execution for it
+func (s *TransactionStreamer) storedBlockHash(pos arbutil.MessageIndex) (*common.Hash, error) {
+	data, err := s.db.Get(dbKey(messageResultPrefix, uint64(pos)))
+	if err != nil {
+		if dbutil.IsErrNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var result execution.MessageResult
+	if err := rlp.DecodeBytes(data, &result); err != nil {
+		return nil, err
+	}
+	return &result.BlockHash, nil
+}
+
+func (s *TransactionStreamer) isEspressoFinalized(pos arbutil.MessageIndex) (bool, error) {
+	record, err := s.GetEspressoSubmissionRecord(pos)
+	if err != nil {
+		return false, err
+	}
+	if record != nil && record.Status == EspressoSubmissionFinalized {
+		return true, nil
+	}
+	// Messages received from the feed carry their justification instead
+	return s.db.Has(dbKey(espressoJustificationPrefix, uint64(pos)))
+}
+
+// PreviewReorg walks the messages a reorg to count would remove, classifies them, and determines which of them
+// would be resequenced under the configured policy, without mutating any state. The reorg hook isn't consulted.
+// At most limit messages are listed, 0 means the default limit, but all of them are counted.
+func (s *TransactionStreamer) PreviewReorg(ctx context.Context, count arbutil.MessageIndex, limit uint64) (*ReorgPreview, error) {
+	if count == 0 {
+		return nil, errors.New("cannot reorg out init message")
+	}
+	if limit == 0 {
+		limit = defaultReorgPreviewLimit
+	}
+	msgCount, err := s.GetMessageCount()
+	if err != nil {
+		return nil, err
+	}
+	if count > msgCount {
+		return nil, fmt.Errorf("reorg target %d is after the message count %d", count, msgCount)
+	}
+	preview := &ReorgPreview{
+		Count:        count,
+		MessageCount: msgCount,
+		Policy:       s.configuredReorgResequencePolicy(),
+		Counts:       make(map[ReorgPreviewClass]uint64),
+		Messages:     []ReorgPreviewMessage{},
+	}
+	if count == msgCount {
+		return preview, nil
+	}
+
+	// The same messages as considered by reorg
+	resequenceEnd := msgCount
+	config := s.config()
+	// #nosec G115
+	if maxEnd := count + arbutil.MessageIndex(config.MaxReorgResequenceDepth); config.MaxReorgResequenceDepth >= 0 && maxEnd < resequenceEnd {
+		resequenceEnd = maxEnd
+	}
+	resequenced := make(map[*arbostypes.MessageWithMetadata]bool)
+	delayedResequencable := make(map[*arbostypes.MessageWithMetadata]bool)
+	var candidates []*arbostypes.MessageWithMetadata
+	if count < resequenceEnd {
+		lastDelayedSeqNum, err := s.getPrevPrevDelayedRead(count)
+		if err != nil {
+			return nil, err
+		}
+		candidates, err = s.GetMessages(count, resequenceEnd)
+		if err != nil {
+			return nil, err
+		}
+		for _, msg := range s.selectMessagesToResequence(ctx, lastDelayedSeqNum, candidates, preview.Policy) {
+			resequenced[msg] = true
+		}
+		for _, msg := range s.selectMessagesToResequence(ctx, lastDelayedSeqNum, candidates, ReorgResequenceDelayedOnly) {
+			delayedResequencable[msg] = true
+		}
+	}
+
+	for pos := count; pos < msgCount; pos++ {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		var msg *arbostypes.MessageWithMetadata
+		if pos < resequenceEnd {
+			msg = candidates[pos-count]
+		} else if msg, err = s.GetMessage(pos); err != nil {
+			return nil, err
+		}
+		finalized, err := s.isEspressoFinalized(pos)
+		if err != nil {
+			return nil, err
+		}
+		isDelayed := msg.Message != nil && msg.Message.Header != nil && msg.Message.Header.RequestId != nil
+		var class ReorgPreviewClass
+		switch {
+		case delayedResequencable[msg]:
+			class = ReorgPreviewDelayedResequencable
+		case finalized:
+			class = ReorgPreviewEspressoFinalized
+		case isDelayed:
+			class = ReorgPreviewDelayedDropped
+		default:
+			class = ReorgPreviewSequencerOnly
+		}
+		preview.Counts[class]++
+		if resequenced[msg] {
+			preview.Resequenced++
+		} else {
+			preview.Dropped++
+		}
+		if uint64(len(preview.Messages)) >= limit {
+			preview.Truncated = true
+			continue
+		}
+		stored, err := s.db.Get(dbKey(messagePrefix, uint64(pos)))
+		if err != nil {
+			return nil, err
+		}
+		blockHash, err := s.storedBlockHash(pos)
+		if err != nil {
+			return nil, err
+		}
+		preview.Messages = append(preview.Messages, ReorgPreviewMessage{
+			Pos:         pos,
+			Class:       class,
+			MessageHash: crypto.Keccak256Hash(stored),
+			BlockHash:   blockHash,
+			Resequenced: resequenced[msg],
+		})
+	}
+	return preview, nil
+}
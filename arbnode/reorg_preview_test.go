@@ -0,0 +1,64 @@
+package arbnode
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"github.com/offchainlabs/nitro/arbos/arbostypes"
+	"github.com/offchainlabs/nitro/arbutil"
+)
+
+func TestPreviewReorg(t *testing.T) {
+	ctx := context.Background()
+	streamer := newTestImportStreamer(t)
+	message := func(requestId *common.Hash, delayedRead uint64) arbostypes.MessageWithMetadata {
+		return arbostypes.MessageWithMetadata{
+			Message: &arbostypes.L1IncomingMessage{
+				Header: &arbostypes.L1IncomingMessageHeader{Kind: arbostypes.L1MessageType_L2Message, RequestId: requestId},
+			},
+			DelayedMessagesRead: delayedRead,
+		}
+	}
+	delayedId := common.BigToHash(common.Big1)
+	Require(t, streamer.AddMessages(0, true, []arbostypes.MessageWithMetadata{
+		message(nil, 1),
+		message(nil, 1),
+		message(&delayedId, 2),
+		message(nil, 2),
+		message(nil, 2),
+	}))
+	batch := streamer.db.NewBatch()
+	Require(t, streamer.setEspressoSubmissionStatus(batch, []arbutil.MessageIndex{4}, EspressoSubmissionFinalized, nil))
+	Require(t, batch.Write())
+
+	preview, err := streamer.PreviewReorg(ctx, 2, 2)
+	Require(t, err)
+	if preview.Policy != ReorgResequenceAll || preview.Resequenced != 3 || preview.Dropped != 0 {
+		Fail(t, "unexpected resequencing", preview.Policy, preview.Resequenced, preview.Dropped)
+	}
+	expected := map[ReorgPreviewClass]uint64{
+		ReorgPreviewDelayedResequencable: 1,
+		ReorgPreviewSequencerOnly:        1,
+		ReorgPreviewEspressoFinalized:    1,
+	}
+	for class, count := range expected {
+		if preview.Counts[class] != count {
+			Fail(t, "unexpected count of", class, preview.Counts[class])
+		}
+	}
+	if len(preview.Messages) != 2 || !preview.Truncated || preview.Messages[0].Class != ReorgPreviewDelayedResequencable {
+		Fail(t, "unexpected listed messages", preview.Messages, preview.Truncated)
+	}
+
+	// Previewing doesn't change the stored messages
+	count, err := streamer.GetMessageCount()
+	Require(t, err)
+	if count != 5 {
+		Fail(t, "preview changed the message count", count)
+	}
+	if _, err := streamer.PreviewReorg(ctx, 6, 0); err == nil {
+		Fail(t, "previewed a reorg after the message count")
+	}
+}
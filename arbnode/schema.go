@@ -12,6 +12,20 @@ var (
 	parentChainBlockNumberPrefix []byte = []byte("p") // maps a delayed sequence number to a parent chain block number
 	sequencerBatchMetaPrefix     []byte = []byte("s") // maps a batch sequence number to BatchMetadata
 	delayedSequencedPrefix       []byte = []byte("a") // maps a delayed message count to the first sequencer batch sequence number with this delayed count
+	reorgHistoryPrefix           []byte = []byte("o") // maps a reorg sequence number to a ReorgRecord
+	espressoSubmissionPrefix     []byte = []byte("q") // maps a message sequence number to its EspressoSubmissionRecord
+	escapeHatchPrefix            []byte = []byte("h") // maps a message sequence number sequenced without espresso confirmation to its EscapeHatchRecord
+	espressoPendingPrefix        []byte = []byte("n") // contains the message sequence numbers waiting to be submitted to espresso
+	espressoJustificationPrefix  []byte = []byte("j") // maps a message sequence number to its EspressoJustification
+	espressoDeadlinePrefix       []byte = []byte("x") // maps a message sequence number to the unix time it must be included in a hotshot block by
+	broadcasterSpillPrefix       []byte = []byte("f") // maps a message sequence number to a feed message spilled from the broadcaster queue
+	timestampLookupPrefix        []byte = []byte("t") // contains the header timestamps followed by the message sequence numbers of the messages with them
+	l1BlockLookupPrefix          []byte = []byte("l") // contains the header L1 block numbers followed by the message sequence numbers of the messages with them
+	prunedMessageDigestPrefix    []byte = []byte("g") // maps a pruned message sequence number to the keccak256 hash of the encoded message
+	messageTelemetryPrefix       []byte = []byte("k") // maps a message sequence number followed by a lifecycle stage to the unix milliseconds the message reached it
+	messageChainPrefix           []byte = []byte("c") // maps a message count at a multiple of the message chain interval to the accumulator over the messages before it
+	espressoBlockLookupPrefix    []byte = []byte("w") // contains the hotshot block heights followed by the message sequence numbers finalized in them
+	espressoDeadLetterPrefix     []byte = []byte("y") // maps a message sequence number moved out of the espresso pending queue to its EspressoDeadLetter
 
 	messageCountKey              []byte = []byte("_messageCount")                 // contains the current message count
 	delayedMessageCountKey       []byte = []byte("_delayedMessageCount")          // contains the current delayed message count
@@ -20,9 +34,26 @@ var (
 	espressoSubmittedPos         []byte = []byte("_espressoSubmittedPos")         // contains the current message indices of the last submitted txns
 	espressoSubmittedHash        []byte = []byte("_espressoSubmittedHash")        // contains the hash of the last submitted txn
 	espressoSubmittedPayload     []byte = []byte("_espressoSubmittedPayload")     // contains the payload of the last submitted espresso txn
-	espressoPendingTxnsPositions []byte = []byte("_espressoPendingTxnsPositions") // contains the index of the pending txns that need to be submitted to espresso
+	espressoPendingTxnsPositions []byte = []byte("_espressoPendingTxnsPositions") // legacy: contained the indices of all pending txns, migrated to espressoPendingPrefix
 	espressoLastConfirmedPos     []byte = []byte("_espressoLastConfirmedPos")     // contains the position of the last confirmed message
 	espressoSkipVerificationPos  []byte = []byte("_espressoSkipVerificationPos")  // contains the position of the latest message that should skip the validation due to hotshot liveness failure
+	espressoLastFinalizedHeight  []byte = []byte("_espressoLastFinalizedHeight")  // contains the hotshot block height the last confirmed messages were finalized in
+	reorgHistoryCountKey         []byte = []byte("_reorgHistoryCount")            // contains the number of reorg records ever written
+	messageBackupCheckpointKey   []byte = []byte("_messageBackupCheckpoint")      // contains the message backup agent's upload checkpoint
+	espressoActivationPos        []byte = []byte("_espressoActivationPos")        // contains the position of the first message sequenced through espresso
+	escapeHatchEpochKey          []byte = []byte("_escapeHatchEpoch")             // contains the number of times the escape hatch was activated
+	espressoBackfillPos          []byte = []byte("_espressoBackfillPos")          // contains the position the espresso justification backfill continues from
+	espressoRevalidationPos      []byte = []byte("_espressoRevalidationPos")      // contains the position below which the espresso justifications were validated against finalized roots
+	espressoRecentSubmissionsKey []byte = []byte("_espressoRecentSubmissions")    // contains the content hashes of the recently submitted espresso payloads
+	espressoFeeSpendKey          []byte = []byte("_espressoFeeSpend")             // contains the estimated espresso fees spent in the current budget period
+	espressoChunkProgressKey     []byte = []byte("_espressoChunkProgress")        // contains the reassembly of the large message whose chunks are being finalized
+	killSwitchKey                []byte = []byte("_killSwitch")                   // contains the last honored kill switch message
+	messageLookupBackfillKey     []byte = []byte("_messageLookupBackfill")        // contains the range of messages stored before they were added to the lookup indexes
+	espressoCleanShutdownKey     []byte = []byte("_espressoCleanShutdown")        // contains the espresso state drained on the last clean shutdown, deleted on startup
+	espressoSnapSyncPosKey       []byte = []byte("_espressoSnapSyncPos")          // contains the message count the node was snap synced from, the espresso state before it isn't stored
+	espressoStateJournalKey      []byte = []byte("_espressoStateJournal")         // contains the intent record of the espresso state transition being applied
+	espressoVerificationHaltKey  []byte = []byte("_espressoVerificationHalt")     // contains the last halt on repeated espresso verification failures
+	espressoDerivationStateKey   []byte = []byte("_espressoDerivationState")      // contains the hotshot block height the derivation of the chain from espresso continues from
 )
 
 const currentDbSchemaVersion uint64 = 1
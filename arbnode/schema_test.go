@@ -0,0 +1,48 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package arbnode
+
+import (
+	"testing"
+
+	"github.com/offchainlabs/nitro/arbnode/dataposter/storage"
+)
+
+// The prefixes of the message database must not collide with each other, nor with the tables other components
+// keep in the same database. The input feed block hashes already shared the batch poster table's prefix and are
+// left out.
+func TestSchemaPrefixesDistinct(t *testing.T) {
+	prefixes := map[string]string{
+		storage.BlockValidatorPrefix: "block validator table",
+		storage.StakerPrefix:         "staker table",
+	}
+	for name, prefix := range map[string][]byte{
+		"message":                   messagePrefix,
+		"message result":            messageResultPrefix,
+		"legacy delayed message":    legacyDelayedMessagePrefix,
+		"rlp delayed message":       rlpDelayedMessagePrefix,
+		"parent chain block number": parentChainBlockNumberPrefix,
+		"sequencer batch meta":      sequencerBatchMetaPrefix,
+		"delayed sequenced":         delayedSequencedPrefix,
+		"reorg history":             reorgHistoryPrefix,
+		"espresso submission":       espressoSubmissionPrefix,
+		"escape hatch":              escapeHatchPrefix,
+		"espresso pending":          espressoPendingPrefix,
+		"espresso justification":    espressoJustificationPrefix,
+		"espresso deadline":         espressoDeadlinePrefix,
+		"broadcaster spill":         broadcasterSpillPrefix,
+		"timestamp lookup":          timestampLookupPrefix,
+		"l1 block lookup":           l1BlockLookupPrefix,
+		"pruned message digest":     prunedMessageDigestPrefix,
+		"message telemetry":         messageTelemetryPrefix,
+		"message chain":             messageChainPrefix,
+		"espresso block lookup":     espressoBlockLookupPrefix,
+		"espresso dead letter":      espressoDeadLetterPrefix,
+	} {
+		if other, ok := prefixes[string(prefix)]; ok {
+			Fail(t, "prefix", string(prefix), "of the", name, "collides with the", other)
+		}
+		prefixes[string(prefix)] = name
+	}
+}
@@ -17,6 +17,7 @@ import (
 	"github.com/go-redis/redis/v8"
 	flag "github.com/spf13/pflag"
 
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/metrics"
 
@@ -889,6 +890,58 @@ func (c *SeqCoordinator) SequencingMessage(pos arbutil.MessageIndex, msg *arbost
 	return nil
 }
 
+// Maximum number of espresso submissions kept in the audit trail
+const espressoSubmissionAuditTrailSize = 64
+
+// EspressoSubmissionAudit is an entry of the espresso submission audit trail. The chosen sequencer records
+// it before sending a transaction to HotShot, so that if it dies before the submission is replicated, the
+// next chosen sequencer can find the transaction in the namespace instead of submitting the messages again.
+type EspressoSubmissionAudit struct {
+	Sequencer   string               `json:"sequencer"`
+	FirstPos    arbutil.MessageIndex `json:"firstPos"`
+	MsgCount    uint64               `json:"msgCount"`
+	PayloadHash common.Hash          `json:"payloadHash"`
+	TxHash      string               `json:"txHash"`
+	// HotShot height before the submission, the transaction can't be in an earlier block
+	HotShotHeight uint64 `json:"hotShotHeight"`
+	Timestamp     uint64 `json:"timestamp"`
+}
+
+// RecordEspressoSubmission appends an entry to the espresso submission audit trail
+func (c *SeqCoordinator) RecordEspressoSubmission(ctx context.Context, audit *EspressoSubmissionAudit) error {
+	if !c.CurrentlyChosen() {
+		return fmt.Errorf("%w: not main sequencer", execution.ErrRetrySequencer)
+	}
+	data, err := json.Marshal(audit)
+	if err != nil {
+		return err
+	}
+	pipe := c.Client.TxPipeline()
+	pipe.RPush(ctx, redisutil.ESPRESSO_SUBMISSIONS_KEY, data)
+	pipe.LTrim(ctx, redisutil.ESPRESSO_SUBMISSIONS_KEY, -espressoSubmissionAuditTrailSize, -1)
+	pipe.Expire(ctx, redisutil.ESPRESSO_SUBMISSIONS_KEY, c.config.SeqNumDuration)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// EspressoSubmissionAuditTrail returns the recorded espresso submissions, oldest first
+func (c *SeqCoordinator) EspressoSubmissionAuditTrail(ctx context.Context) ([]EspressoSubmissionAudit, error) {
+	entries, err := c.Client.LRange(ctx, redisutil.ESPRESSO_SUBMISSIONS_KEY, 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	trail := make([]EspressoSubmissionAudit, 0, len(entries))
+	for _, entry := range entries {
+		var audit EspressoSubmissionAudit
+		if err := json.Unmarshal([]byte(entry), &audit); err != nil {
+			log.Warn("ignoring malformed espresso submission audit entry", "err", err)
+			continue
+		}
+		trail = append(trail, audit)
+	}
+	return trail, nil
+}
+
 // Returns true if the wanting the lockout key was released.
 // The seq coordinator is internally marked as disliking the lockout regardless, so you might want to call SeekLockout on error.
 func (c *SeqCoordinator) AvoidLockout(ctx context.Context) bool {
@@ -0,0 +1,251 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package arbnode
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+	flag "github.com/spf13/pflag"
+
+	"github.com/offchainlabs/nitro/arbos/arbostypes"
+	"github.com/offchainlabs/nitro/arbutil"
+)
+
+var (
+	ErrSequencerGroupCommit = errors.New("failed to commit the staged sequencer messages")
+
+	sequencerGroupCommitCounter        = metrics.NewRegisteredCounter("arb/streamer/group_commit/commits", nil)
+	sequencerGroupCommitFailureCounter = metrics.NewRegisteredCounter("arb/streamer/group_commit/failures", nil)
+	sequencerGroupCommitSizeHistogram  = metrics.NewRegisteredHistogram("arb/streamer/group_commit/messages", nil, metrics.NewBoundedHistogramSample())
+)
+
+// With the group commit, the messages written by the sequencer are staged in a single database batch, which is
+// committed once it's held for the max delay or holds the max number of messages, instead of committing every
+// message on its own. WriteMessageFromSequencer returns once the message is staged, with the execution engine's
+// lock held, and the engine waits for the group to be committed with WaitSequencedMessage after releasing it:
+// the sequenced block is only reported once its message is stored, and the writers of a group that fails to be
+// committed get the error. Their messages were already executed by the sequencer, so a failed commit is fatal.
+// Groups are only committed on the sequencer's path, so that a commit never makes a sequencer write find the
+// insertionMutex taken: by the write filling the group or finding it due, and by CommitSequencedMessages, which
+// the execution engine calls between writes. Staged messages are broadcast and readable once they're committed.
+// The group is committed before anything else takes the insertionMutex, so that the other writers see every
+// message, and before a force inclusion or an espresso submission reads a staged message.
+
+// SequencerGroupCommitConfig configures the group commit of the messages written by the sequencer
+type SequencerGroupCommitConfig struct {
+	Enable      bool          `koanf:"enable" reload:"hot"`
+	MaxDelay    time.Duration `koanf:"max-delay" reload:"hot"`
+	MaxMessages uint64        `koanf:"max-messages" reload:"hot"`
+}
+
+var DefaultSequencerGroupCommitConfig = SequencerGroupCommitConfig{
+	Enable:      false,
+	MaxDelay:    5 * time.Millisecond,
+	MaxMessages: 64,
+}
+
+func SequencerGroupCommitConfigAddOptions(prefix string, f *flag.FlagSet) {
+	f.Bool(prefix+".enable", DefaultSequencerGroupCommitConfig.Enable, "commit the messages written by the sequencer in groups instead of one database write per message; a sequenced block is reported once its group is committed, and the messages of the last group may be lost in a crash after they were executed")
+	f.Duration(prefix+".max-delay", DefaultSequencerGroupCommitConfig.MaxDelay, "maximum time a sequenced message is staged before its group is committed")
+	f.Uint64(prefix+".max-messages", DefaultSequencerGroupCommitConfig.MaxMessages, "maximum number of sequenced messages staged in a group before it's committed")
+}
+
+func (c *SequencerGroupCommitConfig) Validate() error {
+	if !c.Enable {
+		return nil
+	}
+	if c.MaxDelay <= 0 {
+		return errors.New("sequencer group commit max-delay must be positive")
+	}
+	if c.MaxMessages == 0 {
+		return errors.New("sequencer group commit max-messages must be positive")
+	}
+	return nil
+}
+
+// sequencerGroup holds the sequenced messages staged for the next group commit, along with the results and
+// telemetry written for them. done is closed once the group is committed, err is the error of the commit.
+type sequencerGroup struct {
+	batch    ethdb.Batch
+	pos      arbutil.MessageIndex
+	messages []arbostypes.MessageWithMetadataAndBlockHash
+	openedAt time.Time
+	done     chan struct{}
+	err      error
+}
+
+// finish records the result of the commit of the group, and releases its writers
+func (g *sequencerGroup) finish(err error) {
+	g.err = err
+	close(g.done)
+}
+
+// How long the execution engine waits before calling CommitSequencedMessages again while the group commit is
+// disabled, to notice it's enabled
+const sequencerGroupCommitDisabledPoll = time.Second
+
+// lockInsertion takes the insertionMutex, committing the staged sequencer messages first
+func (s *TransactionStreamer) lockInsertion() error {
+	s.insertionMutex.Lock()
+	if err := s.commitSequencerGroup(); err != nil {
+		s.insertionMutex.Unlock()
+		return err
+	}
+	return nil
+}
+
+// commitStagedSequencerMessages commits the staged sequencer messages if the message at pos is one of them, so
+// that it's readable
+func (s *TransactionStreamer) commitStagedSequencerMessages(pos arbutil.MessageIndex) error {
+	if uint64(pos) >= s.sequencerGroupEnd.Load() {
+		return nil
+	}
+	count, err := s.GetMessageCount()
+	if err != nil {
+		return err
+	}
+	if pos < count {
+		return nil
+	}
+	if err := s.lockInsertion(); err != nil {
+		return err
+	}
+	s.insertionMutex.Unlock()
+	return nil
+}
+
+// sequencedMessageCount returns the message count including the staged sequencer messages, the insertionMutex
+// must be held
+func (s *TransactionStreamer) sequencedMessageCount() (arbutil.MessageIndex, error) {
+	if group := s.sequencerGroup; group != nil {
+		// #nosec G115
+		return group.pos + arbutil.MessageIndex(len(group.messages)), nil
+	}
+	return s.GetMessageCount()
+}
+
+// openSequencerGroup returns the batch the sequenced message at pos is staged in, opening a group if there's
+// none. The insertionMutex must be held.
+func (s *TransactionStreamer) openSequencerGroup(pos arbutil.MessageIndex) ethdb.Batch {
+	if s.sequencerGroup == nil {
+		s.sequencerGroup = &sequencerGroup{batch: s.db.NewBatch(), pos: pos, openedAt: time.Now(), done: make(chan struct{})}
+		s.lastSequencerGroup.Store(s.sequencerGroup)
+	}
+	return s.sequencerGroup.batch
+}
+
+// stageSequencerMessage adds the message to the open group, and commits the group once it's full or was held for
+// the max delay. The insertionMutex must be held.
+func (s *TransactionStreamer) stageSequencerMessage(msg arbostypes.MessageWithMetadataAndBlockHash, config *SequencerGroupCommitConfig) error {
+	group := s.sequencerGroup
+	group.messages = append(group.messages, msg)
+	// #nosec G115
+	s.sequencerGroupEnd.Store(uint64(group.pos) + uint64(len(group.messages)))
+	if uint64(len(group.messages)) >= config.MaxMessages || time.Since(group.openedAt) >= config.MaxDelay {
+		return s.commitSequencerGroup()
+	}
+	return nil
+}
+
+// CommitSequencedMessages commits the staged sequencer messages once they were held for the max delay, and returns
+// how long until it should be called again. It's called by the execution engine while no sequencer write is in
+// progress, so that staged messages are committed while the sequencer is idle. A failed commit is returned along
+// with the positions of the messages lost.
+func (s *TransactionStreamer) CommitSequencedMessages() (time.Duration, error) {
+	config := s.config().SequencerGroupCommit
+	wait := config.MaxDelay
+	if !config.Enable {
+		wait = sequencerGroupCommitDisabledPoll
+	}
+	// Like the sequencer writes, this is called with the execution engine's lock held, which reorgs take after the
+	// insertionMutex. Another writer holding the insertionMutex committed the group when taking it, if not the group
+	// is committed on the next call.
+	if !s.insertionMutex.TryLock() {
+		return wait, nil
+	}
+	defer s.insertionMutex.Unlock()
+	group := s.sequencerGroup
+	if group == nil {
+		return wait, nil
+	}
+	// A group may be staged from before the group commit was disabled
+	if config.Enable {
+		if remaining := config.MaxDelay - time.Since(group.openedAt); remaining > 0 {
+			return remaining, nil
+		}
+	}
+	return wait, s.commitSequencerGroup()
+}
+
+// commitSequencerGroup writes the staged sequencer messages and broadcasts them. The insertionMutex must be held.
+func (s *TransactionStreamer) commitSequencerGroup() error {
+	if s.sequencerGroupErr != nil {
+		return s.sequencerGroupErr
+	}
+	group := s.sequencerGroup
+	if group == nil {
+		return nil
+	}
+	s.sequencerGroup = nil
+	s.sequencerGroupEnd.Store(0)
+	if len(group.messages) == 0 {
+		group.finish(nil)
+		return nil
+	}
+	if err := s.writeMessages(group.pos, group.messages, group.batch); err != nil {
+		sequencerGroupCommitFailureCounter.Inc(1)
+		// #nosec G115
+		last := group.pos + arbutil.MessageIndex(len(group.messages)) - 1
+		s.sequencerGroupErr = fmt.Errorf("%w: messages %d to %d were executed but not stored: %w", ErrSequencerGroupCommit, group.pos, last, err)
+		log.Error("failed to commit the staged sequencer messages", "pos", group.pos, "last", last, "err", err)
+		group.finish(s.sequencerGroupErr)
+		// The sequencer already executed the messages, the node must be restarted to resync its execution
+		if s.fatalErrChan != nil {
+			select {
+			case s.fatalErrChan <- s.sequencerGroupErr:
+			default:
+			}
+		}
+		return s.sequencerGroupErr
+	}
+	sequencerGroupCommitCounter.Inc(1)
+	sequencerGroupCommitSizeHistogram.Update(int64(len(group.messages)))
+	group.finish(nil)
+	s.broadcastMessages(group.messages, group.pos)
+	return nil
+}
+
+// WaitSequencedMessage waits for the message written by the sequencer at pos to be stored, and returns the error of
+// the commit of its group if it failed. It returns right away for a message that wasn't staged. It's called by the
+// execution engine after WriteMessageFromSequencer, without holding its lock, as the group is committed by the
+// following sequencer writes or CommitSequencedMessages.
+func (s *TransactionStreamer) WaitSequencedMessage(ctx context.Context, pos arbutil.MessageIndex) error {
+	group := s.lastSequencerGroup.Load()
+	// A message before the last group was committed with an earlier group, as no group is opened after a failed
+	// commit
+	if group == nil || pos < group.pos {
+		return nil
+	}
+	select {
+	case <-group.done:
+		return group.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// flushSequencerGroup commits the staged sequencer messages on shutdown
+func (s *TransactionStreamer) flushSequencerGroup() {
+	s.insertionMutex.Lock()
+	defer s.insertionMutex.Unlock()
+	if err := s.commitSequencerGroup(); err != nil {
+		log.Error("failed to commit the staged sequencer messages on shutdown", "err", err)
+	}
+}
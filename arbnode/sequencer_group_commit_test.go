@@ -0,0 +1,161 @@
+package arbnode
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+
+	"github.com/offchainlabs/nitro/arbos/arbostypes"
+	"github.com/offchainlabs/nitro/arbutil"
+	"github.com/offchainlabs/nitro/execution"
+)
+
+func TestSequencerGroupCommit(t *testing.T) {
+	streamer := newTestImportStreamer(t)
+	streamer.exec = &headOnlyExecution{}
+	config := streamer.config()
+	config.SequencerGroupCommit = SequencerGroupCommitConfig{Enable: true, MaxDelay: time.Hour, MaxMessages: 3}
+	Require(t, config.SequencerGroupCommit.Validate())
+	sequence := func(pos arbutil.MessageIndex) error {
+		msg := arbostypes.MessageWithMetadata{
+			Message: &arbostypes.L1IncomingMessage{Header: &arbostypes.L1IncomingMessageHeader{Timestamp: uint64(pos)}},
+		}
+		return streamer.WriteMessageFromSequencer(pos, msg, execution.MessageResult{})
+	}
+	expectCount := func(expected arbutil.MessageIndex) {
+		t.Helper()
+		count, err := streamer.GetMessageCount()
+		Require(t, err)
+		if count != expected {
+			Fail(t, "unexpected committed message count", count, "expected", expected)
+		}
+	}
+
+	// Messages are staged until the group is full, their writers wait for the commit
+	Require(t, sequence(0))
+	Require(t, sequence(1))
+	expectCount(0)
+	if err := streamer.WriteMessageFromSequencer(0, arbostypes.MessageWithMetadata{}, execution.MessageResult{}); err == nil {
+		Fail(t, "staged position written again")
+	}
+	waited := make(chan error, 1)
+	go func() { waited <- streamer.WaitSequencedMessage(context.Background(), 0) }()
+	select {
+	case err := <-waited:
+		Fail(t, "wait for a staged message returned before the commit", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	Require(t, sequence(2))
+	expectCount(3)
+	Require(t, <-waited)
+	Require(t, streamer.WaitSequencedMessage(context.Background(), 2))
+
+	// Other writers see the staged messages
+	Require(t, sequence(3))
+	count, err := streamer.GetMessageCountSync(t)
+	Require(t, err)
+	if count != 4 {
+		Fail(t, "staged message not committed before taking the insertion lock", count)
+	}
+
+	// Between writes, a group is only committed once it's due
+	Require(t, sequence(4))
+	wait, err := streamer.CommitSequencedMessages()
+	Require(t, err)
+	if wait <= 0 || wait > time.Hour {
+		Fail(t, "unexpected wait until the group is due", wait)
+	}
+	expectCount(4)
+	config.SequencerGroupCommit.MaxDelay = 50 * time.Millisecond
+	time.Sleep(60 * time.Millisecond)
+	wait, err = streamer.CommitSequencedMessages()
+	Require(t, err)
+	if wait != 50*time.Millisecond {
+		Fail(t, "unexpected wait after the commit", wait)
+	}
+	expectCount(5)
+
+	// A write finding the group due commits it
+	Require(t, sequence(5))
+	time.Sleep(60 * time.Millisecond)
+	Require(t, sequence(6))
+	expectCount(7)
+
+	// A staged message is committed before it's queued for espresso submission
+	config.SequencerGroupCommit.MaxDelay = time.Hour
+	Require(t, sequence(7))
+	expectCount(7)
+	Require(t, streamer.SubmitEspressoTransactionPos(7, streamer.db.NewBatch()))
+	expectCount(8)
+
+	// A group staged before the group commit is disabled is committed before the next message
+	Require(t, sequence(8))
+	config.SequencerGroupCommit.Enable = false
+	Require(t, sequence(9))
+	expectCount(10)
+	msg, err := streamer.GetMessage(8)
+	Require(t, err)
+	if msg.Message.Header.Timestamp != 8 {
+		Fail(t, "unexpected message committed at 8", msg.Message.Header.Timestamp)
+	}
+	wait, err = streamer.CommitSequencedMessages()
+	Require(t, err)
+	if wait != sequencerGroupCommitDisabledPoll {
+		Fail(t, "unexpected wait with the group commit disabled", wait)
+	}
+}
+
+var errTestBatchWrite = errors.New("batch write failed")
+
+// failingBatchDatabase fails to write its batches
+type failingBatchDatabase struct {
+	ethdb.Database
+}
+
+func (d failingBatchDatabase) NewBatch() ethdb.Batch {
+	return failingBatch{d.Database.NewBatch()}
+}
+
+type failingBatch struct {
+	ethdb.Batch
+}
+
+func (failingBatch) Write() error {
+	return errTestBatchWrite
+}
+
+func TestSequencerGroupCommitFailure(t *testing.T) {
+	streamer := newTestImportStreamer(t)
+	streamer.exec = &headOnlyExecution{}
+	config := streamer.config()
+	config.SequencerGroupCommit = SequencerGroupCommitConfig{Enable: true, MaxDelay: time.Hour, MaxMessages: 2}
+	sequence := func(pos arbutil.MessageIndex) error {
+		msg := arbostypes.MessageWithMetadata{
+			Message: &arbostypes.L1IncomingMessage{Header: &arbostypes.L1IncomingMessageHeader{Timestamp: uint64(pos)}},
+		}
+		return streamer.WriteMessageFromSequencer(pos, msg, execution.MessageResult{})
+	}
+
+	// The write committing the group gets the error, with the positions of the lost messages
+	streamer.db = failingBatchDatabase{streamer.db}
+	Require(t, sequence(0))
+	err := sequence(1)
+	if !errors.Is(err, ErrSequencerGroupCommit) || !errors.Is(err, errTestBatchWrite) || !strings.Contains(err.Error(), "messages 0 to 1") {
+		Fail(t, "failed group commit not returned to the write committing it", err)
+	}
+	// And so does every later write
+	if err := sequence(2); !errors.Is(err, ErrSequencerGroupCommit) {
+		Fail(t, "failed group commit not returned to a later write", err)
+	}
+	if _, err := streamer.CommitSequencedMessages(); err != nil {
+		Fail(t, "failed group commit reported again without a staged group", err)
+	}
+	// The writers of the lost messages get the error when waiting for them
+	if err := streamer.WaitSequencedMessage(context.Background(), 0); !errors.Is(err, ErrSequencerGroupCommit) {
+		Fail(t, "failed group commit not returned to the writers of the group", err)
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package arbnode
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+
+	"github.com/offchainlabs/nitro/execution"
+)
+
+var (
+	ErrStartupReplayPending = errors.New("justified messages from before the restart are still being executed")
+
+	startupReplayRemainingGauge = metrics.NewRegisteredGauge("arb/streamer/startup_replay/remaining", nil)
+)
+
+// initStartupReplay records the count of messages that already carry an espresso justification but may not have
+// been executed before the restart. Sequencing is held back until they're executed and broadcast, so that a
+// restarted chosen sequencer can't fork ahead of its own backlog.
+func (s *TransactionStreamer) initStartupReplay() error {
+	if s.exec == nil {
+		s.startupReplayed.Store(true)
+		return nil
+	}
+	lastConfirmed, err := s.getLastConfirmedPos()
+	if err != nil {
+		return err
+	}
+	count, err := s.GetMessageCount()
+	if err != nil {
+		return err
+	}
+	if lastConfirmed == nil || count == 0 {
+		s.startupReplayed.Store(true)
+		return nil
+	}
+	target := min(*lastConfirmed+1, count)
+	s.startupReplayTarget = target
+	log.Info("holding back sequencing until the justified messages are executed", "target", target, "messageCount", count)
+	return nil
+}
+
+// expectStartupReplayed returns an error asking the sequencer to retry while messages justified before the restart
+// are still being executed. Messages are broadcast as soon as they're executed, so it also waits for their broadcast.
+func (s *TransactionStreamer) expectStartupReplayed() error {
+	if s.startupReplayed.Load() {
+		return nil
+	}
+	head, err := s.exec.HeadMessageNumber()
+	if err != nil {
+		return err
+	}
+	executed := head + 1
+	if executed < s.startupReplayTarget {
+		// #nosec G115
+		startupReplayRemainingGauge.Update(int64(s.startupReplayTarget - executed))
+		return fmt.Errorf("%w: %w (executed %d of %d)", execution.ErrRetrySequencer, ErrStartupReplayPending, executed, s.startupReplayTarget)
+	}
+	startupReplayRemainingGauge.Update(0)
+	s.startupReplayed.Store(true)
+	log.Info("justified messages from before the restart executed, accepting new sequenced messages", "executed", executed)
+	return nil
+}
+
+// StartupReplayed returns whether the messages justified before the restart were executed
+func (s *TransactionStreamer) StartupReplayed() bool {
+	return s.startupReplayed.Load()
+}
@@ -0,0 +1,49 @@
+package arbnode
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/rlp"
+
+	"github.com/offchainlabs/nitro/arbutil"
+	"github.com/offchainlabs/nitro/execution"
+)
+
+type headOnlyExecution struct {
+	execution.ExecutionSequencer
+	head arbutil.MessageIndex
+}
+
+func (e *headOnlyExecution) HeadMessageNumber() (arbutil.MessageIndex, error) {
+	return e.head, nil
+}
+
+func TestStartupReplay(t *testing.T) {
+	streamer := newTestImportStreamer(t)
+	Require(t, streamer.initStartupReplay())
+	if !streamer.StartupReplayed() {
+		Fail(t, "sequencing held back without an execution client")
+	}
+
+	exec := &headOnlyExecution{head: 2}
+	streamer = newTestImportStreamer(t)
+	streamer.exec = exec
+	count, err := rlp.EncodeToBytes(uint64(10))
+	Require(t, err)
+	Require(t, streamer.db.Put(messageCountKey, count))
+	lastConfirmed := arbutil.MessageIndex(6)
+	Require(t, streamer.setEspressoLastConfirmedPos(streamer.db, &lastConfirmed))
+	Require(t, streamer.initStartupReplay())
+	if streamer.startupReplayTarget != 7 {
+		Fail(t, "unexpected startup replay target", streamer.startupReplayTarget)
+	}
+	if err := streamer.expectStartupReplayed(); !errors.Is(err, execution.ErrRetrySequencer) || !errors.Is(err, ErrStartupReplayPending) {
+		Fail(t, "sequencing not held back while justified messages are unexecuted", err)
+	}
+	exec.head = 6
+	Require(t, streamer.expectStartupReplayed())
+	if !streamer.StartupReplayed() {
+		Fail(t, "sequencing still held back after the justified messages were executed")
+	}
+}
@@ -0,0 +1,258 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package arbnode
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	tagged_base64 "github.com/EspressoSystems/espresso-sequencer-go/tagged-base64"
+	espressoTypes "github.com/EspressoSystems/espresso-sequencer-go/types"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+
+	"github.com/offchainlabs/nitro/arbutil"
+)
+
+// Version of the StreamerCheckpoint record, bumped whenever its fields change meaning
+const streamerCheckpointVersion uint64 = 1
+
+// StreamerCheckpointEspresso is the espresso submission state captured by a checkpoint
+type StreamerCheckpointEspresso struct {
+	PendingPositions          []arbutil.MessageIndex `json:"pendingPositions"`
+	SubmittedPositions        []arbutil.MessageIndex `json:"submittedPositions"`
+	SubmittedTxHash           *string                `json:"submittedTxHash"`
+	SubmittedPayload          hexutil.Bytes          `json:"submittedPayload"`
+	LastConfirmedPos          *arbutil.MessageIndex  `json:"lastConfirmedPos"`
+	SkipVerificationPos       *arbutil.MessageIndex  `json:"skipVerificationPos"`
+	LastFinalizedHotShotBlock *uint64                `json:"lastFinalizedHotShotBlock"`
+}
+
+// StreamerCheckpoint is a consistent snapshot of the streamer state, taken while no messages are inserted
+// and no espresso state is updated
+type StreamerCheckpoint struct {
+	Version   uint64 `json:"version"`
+	CreatedAt uint64 `json:"createdAt"`
+
+	MessageCount arbutil.MessageIndex `json:"messageCount"`
+	// Hash of the last message as stored in the database, which identifies the history the checkpoint was taken on
+	LastMessageHash common.Hash `json:"lastMessageHash"`
+
+	Espresso StreamerCheckpointEspresso `json:"espresso"`
+
+	// Position and length of the feed messages queued until the messages before them arrive. The queue
+	// is only kept in memory, so it's recorded for reference and dropped on restore.
+	BroadcasterQueuePos arbutil.MessageIndex `json:"broadcasterQueuePos"`
+	BroadcasterQueueLen uint64               `json:"broadcasterQueueLen"`
+}
+
+func (s *TransactionStreamer) lastMessageHash(count arbutil.MessageIndex) (common.Hash, error) {
+	if count == 0 {
+		return common.Hash{}, nil
+	}
+	data, err := s.db.Get(dbKey(messagePrefix, uint64(count-1)))
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return crypto.Keccak256Hash(data), nil
+}
+
+// Checkpoint atomically captures the message count, the espresso submission state and the broadcaster
+// queue position. It can be called while the node runs.
+func (s *TransactionStreamer) Checkpoint() (*StreamerCheckpoint, error) {
+	if err := s.lockInsertion(); err != nil {
+		return nil, err
+	}
+	defer s.insertionMutex.Unlock()
+	s.espressoTxnsStateInsertionMutex.Lock()
+	defer s.espressoTxnsStateInsertionMutex.Unlock()
+
+	// #nosec G115
+	createdAt := uint64(time.Now().Unix())
+	checkpoint := &StreamerCheckpoint{
+		Version:   streamerCheckpointVersion,
+		CreatedAt: createdAt,
+	}
+	var err error
+	checkpoint.MessageCount, err = s.GetMessageCount()
+	if err != nil {
+		return nil, err
+	}
+	checkpoint.LastMessageHash, err = s.lastMessageHash(checkpoint.MessageCount)
+	if err != nil {
+		return nil, err
+	}
+
+	espresso := &checkpoint.Espresso
+	espresso.PendingPositions, err = s.getEspressoPendingTxnsPos()
+	if err != nil {
+		return nil, err
+	}
+	espresso.SubmittedPositions, err = s.getEspressoSubmittedPos()
+	if err != nil {
+		return nil, err
+	}
+	hash, err := s.getEspressoSubmittedHash()
+	if err != nil {
+		return nil, err
+	}
+	if hash != nil {
+		hashStr := hash.String()
+		espresso.SubmittedTxHash = &hashStr
+	}
+	espresso.SubmittedPayload, err = s.getEspressoSubmittedPayload()
+	if err != nil {
+		return nil, err
+	}
+	espresso.LastConfirmedPos, err = s.getLastConfirmedPos()
+	if err != nil {
+		return nil, err
+	}
+	espresso.SkipVerificationPos, err = s.getSkipVerificationPos()
+	if err != nil {
+		return nil, err
+	}
+	espresso.LastFinalizedHotShotBlock, err = s.getEspressoLastFinalizedHeight()
+	if err != nil {
+		return nil, err
+	}
+
+	checkpoint.BroadcasterQueuePos = arbutil.MessageIndex(s.broadcasterQueuedMessagesPos.Load())
+	checkpoint.BroadcasterQueueLen = uint64(s.broadcasterQueueLen())
+	return checkpoint, nil
+}
+
+func (c *StreamerCheckpoint) validate() error {
+	if c.Version != streamerCheckpointVersion {
+		return fmt.Errorf("unsupported checkpoint version %d, expected %d", c.Version, streamerCheckpointVersion)
+	}
+	positions := append(append([]arbutil.MessageIndex{}, c.Espresso.PendingPositions...), c.Espresso.SubmittedPositions...)
+	if c.Espresso.LastConfirmedPos != nil {
+		positions = append(positions, *c.Espresso.LastConfirmedPos)
+	}
+	if c.Espresso.SkipVerificationPos != nil {
+		positions = append(positions, *c.Espresso.SkipVerificationPos)
+	}
+	for _, pos := range positions {
+		if pos >= c.MessageCount {
+			return fmt.Errorf("checkpoint references message %d beyond its message count %d", pos, c.MessageCount)
+		}
+	}
+	return nil
+}
+
+// RestoreFromCheckpoint reorgs the streamer back to the checkpoint's message count and replaces the espresso
+// submission state with the checkpointed one. The messages up to the checkpoint must still be stored unchanged.
+// Restoring to an earlier message count needs execution, and so a started streamer.
+func (s *TransactionStreamer) RestoreFromCheckpoint(ctx context.Context, checkpoint *StreamerCheckpoint) error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	if err := checkpoint.validate(); err != nil {
+		return err
+	}
+	var submittedHash *espressoTypes.TaggedBase64
+	if checkpoint.Espresso.SubmittedTxHash != nil {
+		var err error
+		submittedHash, err = tagged_base64.Parse(*checkpoint.Espresso.SubmittedTxHash)
+		if err != nil {
+			return fmt.Errorf("invalid submitted transaction hash in checkpoint: %w", err)
+		}
+	}
+
+	// Keep the espresso loop from acting on the state while it's replaced
+	select {
+	case s.espressoSwitchSlot <- struct{}{}:
+		defer func() { <-s.espressoSwitchSlot }()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if err := s.lockInsertion(); err != nil {
+		return err
+	}
+	defer s.insertionMutex.Unlock()
+
+	msgCount, err := s.GetMessageCount()
+	if err != nil {
+		return err
+	}
+	if checkpoint.MessageCount > msgCount {
+		return fmt.Errorf("checkpoint message count %d is after the stored message count %d", checkpoint.MessageCount, msgCount)
+	}
+	lastHash, err := s.lastMessageHash(checkpoint.MessageCount)
+	if err != nil {
+		return err
+	}
+	if lastHash != checkpoint.LastMessageHash {
+		return fmt.Errorf("stored message %d doesn't match the checkpoint, the checkpoint was taken on a different history", checkpoint.MessageCount-1)
+	}
+
+	s.espressoTxnsStateInsertionMutex.Lock()
+	defer s.espressoTxnsStateInsertionMutex.Unlock()
+	batch := s.db.NewBatch()
+	if checkpoint.MessageCount < msgCount {
+		if !s.Started() {
+			return errors.New("restoring to an earlier message count requires a started streamer")
+		}
+		if err := s.reorg(batch, checkpoint.MessageCount, nil); err != nil {
+			return err
+		}
+	}
+
+	espresso := &checkpoint.Espresso
+	if err := s.setEspressoPendingTxnsPos(batch, espresso.PendingPositions); err != nil {
+		return err
+	}
+	if err := s.setEspressoSubmittedPos(batch, espresso.SubmittedPositions); err != nil {
+		return err
+	}
+	if err := s.setEspressoSubmittedHash(batch, submittedHash); err != nil {
+		return err
+	}
+	if err := s.setEspressoSubmittedPayload(batch, espresso.SubmittedPayload); err != nil {
+		return err
+	}
+	if espresso.LastConfirmedPos != nil {
+		err = s.setEspressoLastConfirmedPos(batch, espresso.LastConfirmedPos)
+	} else {
+		err = batch.Delete(espressoLastConfirmedPos)
+	}
+	if err != nil {
+		return err
+	}
+	if espresso.SkipVerificationPos != nil {
+		err = s.setSkipVerificationPos(batch, espresso.SkipVerificationPos)
+	} else {
+		err = batch.Delete(espressoSkipVerificationPos)
+	}
+	if err != nil {
+		return err
+	}
+	if espresso.LastFinalizedHotShotBlock != nil {
+		err = s.setEspressoLastFinalizedHeight(batch, *espresso.LastFinalizedHotShotBlock)
+	} else {
+		err = batch.Delete(espressoLastFinalizedHeight)
+	}
+	if err != nil {
+		return err
+	}
+	if err := batch.Write(); err != nil {
+		return err
+	}
+
+	s.broadcasterQueuedMessages = nil
+	s.broadcasterQueuedMessagesPos.Store(0)
+	s.broadcasterQueuedMessagesActiveReorg = false
+	if err := s.clearBroadcasterQueueSpill(); err != nil {
+		log.Warn("failed to delete spilled feed messages", "err", err)
+	}
+	// The restored in-flight submission has to be looked up again on HotShot
+	s.espressoSubmissionReconciled = false
+	log.Warn("restored streamer from checkpoint", "messageCount", checkpoint.MessageCount, "previousMessageCount", msgCount, "createdAt", checkpoint.CreatedAt)
+	return nil
+}
@@ -0,0 +1,83 @@
+package arbnode
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	tagged_base64 "github.com/EspressoSystems/espresso-sequencer-go/tagged-base64"
+
+	"github.com/offchainlabs/nitro/arbos/arbostypes"
+	"github.com/offchainlabs/nitro/arbutil"
+)
+
+func TestStreamerCheckpoint(t *testing.T) {
+	ctx := context.Background()
+	streamer := newTestImportStreamer(t)
+	var messages []arbostypes.MessageWithMetadata
+	for i := uint64(0); i < 4; i++ {
+		messages = append(messages, arbostypes.MessageWithMetadata{
+			Message:             &arbostypes.L1IncomingMessage{Header: &arbostypes.L1IncomingMessageHeader{Timestamp: i}},
+			DelayedMessagesRead: 1,
+		})
+	}
+	Require(t, streamer.AddMessages(0, true, messages))
+	hash, err := tagged_base64.New("TX", []byte{1, 2, 3})
+	Require(t, err)
+	confirmed := arbutil.MessageIndex(1)
+	batch := streamer.db.NewBatch()
+	Require(t, streamer.setEspressoSubmittedPos(batch, []arbutil.MessageIndex{2}))
+	Require(t, streamer.setEspressoSubmittedHash(batch, hash))
+	Require(t, streamer.setEspressoSubmittedPayload(batch, []byte("payload")))
+	Require(t, streamer.setEspressoLastConfirmedPos(batch, &confirmed))
+	Require(t, streamer.appendEspressoPendingTxnPos(batch, 3))
+	Require(t, batch.Write())
+
+	checkpoint, err := streamer.Checkpoint()
+	Require(t, err)
+	if checkpoint.MessageCount != 4 || len(checkpoint.Espresso.PendingPositions) != 1 || len(checkpoint.Espresso.SubmittedPositions) != 1 {
+		Fail(t, "unexpected checkpoint", checkpoint)
+	}
+	// The checkpoint is handed to ops tooling as JSON
+	encoded, err := json.Marshal(checkpoint)
+	Require(t, err)
+	var decoded StreamerCheckpoint
+	Require(t, json.Unmarshal(encoded, &decoded))
+
+	// Progress made after the checkpoint is undone by restoring it
+	batch = streamer.db.NewBatch()
+	Require(t, streamer.cleanEspressoSubmittedData(batch))
+	Require(t, streamer.setEspressoPendingTxnsPos(batch, nil))
+	confirmed = 3
+	Require(t, streamer.setEspressoLastConfirmedPos(batch, &confirmed))
+	Require(t, batch.Write())
+	Require(t, streamer.RestoreFromCheckpoint(ctx, &decoded))
+	restored, err := streamer.Checkpoint()
+	Require(t, err)
+	restored.CreatedAt = checkpoint.CreatedAt
+	restoredJson, err := json.Marshal(restored)
+	Require(t, err)
+	if string(restoredJson) != string(encoded) {
+		Fail(t, "restored state doesn't match the checkpoint", string(restoredJson), string(encoded))
+	}
+	if streamer.espressoPendingCount.Load() != 1 {
+		Fail(t, "pending count not restored", streamer.espressoPendingCount.Load())
+	}
+
+	// Checkpoints of another history, of missing messages or of another version are rejected
+	other := decoded
+	other.LastMessageHash[0] ^= 1
+	if err := streamer.RestoreFromCheckpoint(ctx, &other); err == nil {
+		Fail(t, "restored a checkpoint of a different history")
+	}
+	other = decoded
+	other.MessageCount = 5
+	if err := streamer.RestoreFromCheckpoint(ctx, &other); err == nil {
+		Fail(t, "restored a checkpoint beyond the stored messages")
+	}
+	other = decoded
+	other.Version++
+	if err := streamer.RestoreFromCheckpoint(ctx, &other); err == nil {
+		Fail(t, "restored a checkpoint of an unsupported version")
+	}
+}
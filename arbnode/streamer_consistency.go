@@ -0,0 +1,228 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package arbnode
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/rlp"
+	flag "github.com/spf13/pflag"
+
+	"github.com/offchainlabs/nitro/arbutil"
+	"github.com/offchainlabs/nitro/execution"
+	"github.com/offchainlabs/nitro/util/dbutil"
+)
+
+var (
+	consistencyExecutionLeadGauge = metrics.NewRegisteredGauge("arb/streamer/consistency/execution_lead", nil)
+	consistencyValidatedLeadGauge = metrics.NewRegisteredGauge("arb/streamer/consistency/validated_lead", nil)
+	consistencyDivergenceCounter  = metrics.NewRegisteredCounter("arb/streamer/consistency/divergences", nil)
+	consistencyReorgCounter       = metrics.NewRegisteredCounter("arb/streamer/consistency/reorgs", nil)
+)
+
+// How long to wait before checking again whether the consistency check was enabled
+const consistencyCheckDisabledInterval = time.Minute
+
+// StreamerConsistencyCheckConfig configures the periodic comparison of the message count of the streamer with
+// the execution engine's head and the validator's progress
+type StreamerConsistencyCheckConfig struct {
+	Interval            time.Duration `koanf:"interval" reload:"hot"`
+	MaxExecutionLag     uint64        `koanf:"max-execution-lag" reload:"hot"`
+	MaxExecutionLead    uint64        `koanf:"max-execution-lead" reload:"hot"`
+	MaxValidatedLead    uint64        `koanf:"max-validated-lead" reload:"hot"`
+	AncestorSearchDepth uint64        `koanf:"ancestor-search-depth" reload:"hot"`
+	AutoReorg           bool          `koanf:"auto-reorg" reload:"hot"`
+}
+
+var DefaultStreamerConsistencyCheckConfig = StreamerConsistencyCheckConfig{
+	Interval:            30 * time.Second,
+	MaxExecutionLag:     10_000,
+	MaxExecutionLead:    0,
+	MaxValidatedLead:    0,
+	AncestorSearchDepth: 1024,
+	AutoReorg:           false,
+}
+
+func StreamerConsistencyCheckConfigAddOptions(prefix string, f *flag.FlagSet) {
+	f.Duration(prefix+".interval", DefaultStreamerConsistencyCheckConfig.Interval, "interval between comparisons of the message count with the execution engine's head and the validated count (0 = disabled)")
+	f.Uint64(prefix+".max-execution-lag", DefaultStreamerConsistencyCheckConfig.MaxExecutionLag, "number of messages the execution engine may be behind the message count before it's reported")
+	f.Uint64(prefix+".max-execution-lead", DefaultStreamerConsistencyCheckConfig.MaxExecutionLead, "number of messages the execution engine may have executed past the message count before it's reported as diverged")
+	f.Uint64(prefix+".max-validated-lead", DefaultStreamerConsistencyCheckConfig.MaxValidatedLead, "number of messages the validator may have validated past the message count before it's reported as diverged")
+	f.Uint64(prefix+".ancestor-search-depth", DefaultStreamerConsistencyCheckConfig.AncestorSearchDepth, "maximum number of messages searched back for the last one on which the message results and the execution engine agree")
+	f.Bool(prefix+".auto-reorg", DefaultStreamerConsistencyCheckConfig.AutoReorg, "reorg to the last message on which the message results and the execution engine agree when they diverge, instead of only reporting it")
+}
+
+func (c *StreamerConsistencyCheckConfig) Validate() error {
+	if c.Interval > 0 && c.AutoReorg && c.AncestorSearchDepth == 0 {
+		return errors.New("consistency check ancestor-search-depth must be positive while auto-reorg is enabled")
+	}
+	return nil
+}
+
+// ConsistencyReport is the result of a comparison of the streamer with the execution engine and the validator
+type ConsistencyReport struct {
+	MessageCount   arbutil.MessageIndex  `json:"messageCount"`
+	ExecutedCount  arbutil.MessageIndex  `json:"executedCount"`
+	ValidatedCount *arbutil.MessageIndex `json:"validatedCount,omitempty"`
+	// Position of the last executed message whose result differs from the one stored by the streamer, if any
+	MismatchAt *arbutil.MessageIndex `json:"mismatchAt,omitempty"`
+}
+
+// diverged returns the reason the report shows a split between consensus and execution, empty if there's none
+func (r *ConsistencyReport) diverged(config *StreamerConsistencyCheckConfig) string {
+	if r.MismatchAt != nil {
+		return "message result mismatch"
+	}
+	if r.ExecutedCount > r.MessageCount && uint64(r.ExecutedCount-r.MessageCount) > config.MaxExecutionLead {
+		return "execution ahead of the message count"
+	}
+	return ""
+}
+
+func (s *TransactionStreamer) storedMessageResult(pos arbutil.MessageIndex) (*execution.MessageResult, error) {
+	data, err := s.db.Get(dbKey(messageResultPrefix, uint64(pos)))
+	if err != nil {
+		if dbutil.IsErrNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var result execution.MessageResult
+	if err := rlp.DecodeBytes(data, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// messageResultMatches returns whether the result stored by the streamer for the message at pos matches the
+// execution engine's, a missing stored result is assumed to match
+func (s *TransactionStreamer) messageResultMatches(pos arbutil.MessageIndex) (bool, common.Hash, error) {
+	stored, err := s.storedMessageResult(pos)
+	if err != nil || stored == nil {
+		return true, common.Hash{}, err
+	}
+	executed, err := s.exec.ResultAtPos(pos)
+	if err != nil {
+		return false, common.Hash{}, err
+	}
+	return executed.BlockHash == stored.BlockHash, executed.BlockHash, nil
+}
+
+// CheckConsistency compares the message count with the execution engine's head and the validated count, and the
+// last executed message's result with the one stored by the streamer
+func (s *TransactionStreamer) CheckConsistency() (*ConsistencyReport, error) {
+	if s.exec == nil {
+		return nil, ErrNoExecution
+	}
+	// Reorgs hold the lock exclusively, so the execution engine's head can only move forward meanwhile
+	s.reorgMutex.RLock()
+	defer s.reorgMutex.RUnlock()
+	head, err := s.exec.HeadMessageNumber()
+	if err != nil {
+		return nil, err
+	}
+	count, err := s.GetMessageCount()
+	if err != nil {
+		return nil, err
+	}
+	// The messages staged by the sequencer group commit were already executed
+	if staged := arbutil.MessageIndex(s.sequencerGroupEnd.Load()); staged > count {
+		count = staged
+	}
+	report := &ConsistencyReport{MessageCount: count, ExecutedCount: head + 1}
+	if s.validator != nil {
+		validated := s.validator.GetValidated()
+		report.ValidatedCount = &validated
+	}
+	if last := min(count, report.ExecutedCount); last > 0 {
+		matches, executedHash, err := s.messageResultMatches(last - 1)
+		if err != nil {
+			return nil, err
+		}
+		if !matches {
+			mismatch := last - 1
+			report.MismatchAt = &mismatch
+			log.Warn("message result differs from the execution engine's", "pos", mismatch, "executedBlockHash", executedHash)
+		}
+	}
+	return report, nil
+}
+
+// findConsistentAncestor returns the count of messages up to which the stored results and the execution engine
+// agree, searching back at most depth messages from the last one before count
+func (s *TransactionStreamer) findConsistentAncestor(count arbutil.MessageIndex, depth uint64) (arbutil.MessageIndex, error) {
+	s.reorgMutex.RLock()
+	defer s.reorgMutex.RUnlock()
+	for pos := count; pos > 1 && uint64(count-pos) < depth; pos-- {
+		matches, _, err := s.messageResultMatches(pos - 1)
+		if err != nil {
+			return 0, err
+		}
+		if matches {
+			return pos, nil
+		}
+	}
+	return 0, fmt.Errorf("no message on which the message results and the execution engine agree within %d messages of %d", depth, count)
+}
+
+// checkConsistency reports divergences between the streamer, the execution engine and the validator, and reorgs
+// to the last message they agree on if enabled. It's meant to be called iteratively.
+func (s *TransactionStreamer) checkConsistency(ctx context.Context) time.Duration {
+	config := s.config().ConsistencyCheck
+	if config.Interval == 0 || s.exec == nil {
+		return consistencyCheckDisabledInterval
+	}
+	report, err := s.CheckConsistency()
+	if err != nil {
+		log.Warn("failed to check the consistency with the execution engine", "err", err)
+		return config.Interval
+	}
+	// #nosec G115
+	consistencyExecutionLeadGauge.Update(int64(report.ExecutedCount) - int64(report.MessageCount))
+	if report.MessageCount > report.ExecutedCount && uint64(report.MessageCount-report.ExecutedCount) > config.MaxExecutionLag {
+		log.Warn("execution engine is lagging behind the message count", "messageCount", report.MessageCount, "executedCount", report.ExecutedCount, "maxLag", config.MaxExecutionLag)
+	}
+	if report.ValidatedCount != nil {
+		validated := *report.ValidatedCount
+		// #nosec G115
+		consistencyValidatedLeadGauge.Update(int64(validated) - int64(report.MessageCount))
+		if validated > report.MessageCount && uint64(validated-report.MessageCount) > config.MaxValidatedLead {
+			consistencyDivergenceCounter.Inc(1)
+			log.Error("validator is ahead of the message count", "messageCount", report.MessageCount, "validatedCount", validated, "maxLead", config.MaxValidatedLead)
+		}
+	}
+	reason := report.diverged(&config)
+	if reason == "" {
+		return config.Interval
+	}
+	consistencyDivergenceCounter.Inc(1)
+	log.Error("consensus and execution diverged", "reason", reason, "messageCount", report.MessageCount, "executedCount", report.ExecutedCount, "mismatchAt", report.MismatchAt)
+	if !config.AutoReorg {
+		return config.Interval
+	}
+	ancestor := report.MessageCount
+	if report.MismatchAt != nil {
+		ancestor, err = s.findConsistentAncestor(*report.MismatchAt, config.AncestorSearchDepth)
+		if err != nil {
+			log.Error("not reorging to resolve the divergence", "err", err)
+			return config.Interval
+		}
+	}
+	if ctx.Err() != nil {
+		return 0
+	}
+	log.Warn("reorging to the last message consensus and execution agree on", "count", ancestor, "messageCount", report.MessageCount, "executedCount", report.ExecutedCount)
+	if err := s.ReorgTo(ancestor); err != nil {
+		log.Error("failed to reorg to resolve the divergence", "count", ancestor, "err", err)
+		return config.Interval
+	}
+	consistencyReorgCounter.Inc(1)
+	return config.Interval
+}
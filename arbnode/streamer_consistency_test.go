@@ -0,0 +1,71 @@
+package arbnode
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"github.com/offchainlabs/nitro/arbutil"
+	"github.com/offchainlabs/nitro/execution"
+)
+
+type resultsExecution struct {
+	headOnlyExecution
+	blockHashes map[arbutil.MessageIndex]common.Hash
+}
+
+func (e *resultsExecution) ResultAtPos(pos arbutil.MessageIndex) (*execution.MessageResult, error) {
+	return &execution.MessageResult{BlockHash: e.blockHashes[pos]}, nil
+}
+
+func TestStreamerConsistencyCheck(t *testing.T) {
+	streamer := newTestImportStreamer(t)
+	Require(t, streamer.AddMessages(0, true, testChainMessages(0, 6)))
+	exec := &resultsExecution{headOnlyExecution: headOnlyExecution{head: 5}, blockHashes: map[arbutil.MessageIndex]common.Hash{}}
+	streamer.exec = exec
+	batch := streamer.db.NewBatch()
+	for pos := arbutil.MessageIndex(0); pos < 6; pos++ {
+		hash := common.Hash{byte(pos)}
+		exec.blockHashes[pos] = hash
+		Require(t, streamer.storeResult(pos, execution.MessageResult{BlockHash: hash}, batch))
+	}
+	Require(t, batch.Write())
+	config := DefaultStreamerConsistencyCheckConfig
+
+	report, err := streamer.CheckConsistency()
+	Require(t, err)
+	if report.MessageCount != 6 || report.ExecutedCount != 6 || report.MismatchAt != nil || report.diverged(&config) != "" {
+		Fail(t, "unexpected report of consistent state", report)
+	}
+
+	// The execution engine executed past the message count
+	exec.head = 7
+	report, err = streamer.CheckConsistency()
+	Require(t, err)
+	if report.diverged(&config) == "" {
+		Fail(t, "execution lead not reported", report)
+	}
+	config.MaxExecutionLead = 2
+	if report.diverged(&config) != "" {
+		Fail(t, "execution lead within the threshold reported", report)
+	}
+
+	// The execution engine computed other blocks from message 3
+	exec.head = 5
+	for pos := arbutil.MessageIndex(3); pos < 6; pos++ {
+		exec.blockHashes[pos] = common.Hash{0xff, byte(pos)}
+	}
+	report, err = streamer.CheckConsistency()
+	Require(t, err)
+	if report.MismatchAt == nil || *report.MismatchAt != 5 {
+		Fail(t, "result mismatch not reported", report)
+	}
+	ancestor, err := streamer.findConsistentAncestor(*report.MismatchAt, config.AncestorSearchDepth)
+	Require(t, err)
+	if ancestor != 3 {
+		Fail(t, "unexpected consistent ancestor", ancestor)
+	}
+	if _, err := streamer.findConsistentAncestor(*report.MismatchAt, 1); err == nil {
+		Fail(t, "ancestor found beyond the search depth")
+	}
+}
@@ -0,0 +1,165 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package arbnode
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+	flag "github.com/spf13/pflag"
+
+	"github.com/offchainlabs/nitro/arbutil"
+	"github.com/offchainlabs/nitro/util/dbutil"
+)
+
+var (
+	loadSheddingActiveGauge         = metrics.NewRegisteredGauge("arb/streamer/loadshedding/active", nil)
+	loadSheddingActivationCounter   = metrics.NewRegisteredCounter("arb/streamer/loadshedding/activations", nil)
+	loadSheddingDeferredCounter     = metrics.NewRegisteredCounter("arb/streamer/loadshedding/deferred", nil)
+	loadSheddingWriteLatencyGauge   = metrics.NewRegisteredGauge("arb/streamer/loadshedding/db_write_latency", nil)
+	loadSheddingHeapAllocationGauge = metrics.NewRegisteredGauge("arb/streamer/loadshedding/heap_alloc", nil)
+)
+
+const (
+	// How long to wait before checking again whether load shedding was enabled
+	loadSheddingDisabledInterval = time.Minute
+	// Pressure must fall below this share of the thresholds before feed messages are processed again,
+	// so that the mode doesn't flap around the thresholds
+	loadSheddingRecoveryRatio = 0.8
+	// Weight of the latest database write in the moving average of the write latency
+	loadSheddingLatencyWeight = 0.2
+)
+
+// StreamerLoadSheddingConfig configures deferring feed messages while the node is under resource pressure,
+// so that confirmed messages are ingested and executed first
+type StreamerLoadSheddingConfig struct {
+	DbWriteLatency time.Duration `koanf:"db-write-latency" reload:"hot"`
+	HeapLimit      uint64        `koanf:"heap-limit" reload:"hot"`
+	CheckInterval  time.Duration `koanf:"check-interval" reload:"hot"`
+}
+
+var DefaultStreamerLoadSheddingConfig = StreamerLoadSheddingConfig{
+	DbWriteLatency: 0,
+	HeapLimit:      0,
+	CheckInterval:  5 * time.Second,
+}
+
+func StreamerLoadSheddingConfigAddOptions(prefix string, f *flag.FlagSet) {
+	f.Duration(prefix+".db-write-latency", DefaultStreamerLoadSheddingConfig.DbWriteLatency, "average latency of message database writes above which feed messages are only queued, until confirmed messages are ingested and executed (0 = ignore write latency)")
+	f.Uint64(prefix+".heap-limit", DefaultStreamerLoadSheddingConfig.HeapLimit, "allocated heap size in bytes above which feed messages are only queued, until confirmed messages are ingested and executed (0 = ignore memory)")
+	f.Duration(prefix+".check-interval", DefaultStreamerLoadSheddingConfig.CheckInterval, "interval between checks of the database write latency and memory against the load shedding thresholds")
+}
+
+func (c *StreamerLoadSheddingConfig) enabled() bool {
+	return c.DbWriteLatency > 0 || c.HeapLimit > 0
+}
+
+// loadShedder tracks the resource pressure of the streamer
+type loadShedder struct {
+	// Whether feed messages are only queued
+	active atomic.Bool
+
+	mutex sync.Mutex
+	// Moving average of the message database write latency
+	writeLatency time.Duration
+}
+
+func (l *loadShedder) observeWrite(latency time.Duration) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.writeLatency == 0 {
+		l.writeLatency = latency
+		return
+	}
+	l.writeLatency += time.Duration(loadSheddingLatencyWeight * float64(latency-l.writeLatency))
+}
+
+func (l *loadShedder) averageWriteLatency() time.Duration {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.writeLatency
+}
+
+// LoadSheddingActive returns whether feed messages are only queued because of resource pressure
+func (s *TransactionStreamer) LoadSheddingActive() bool {
+	return s.loadShedding.active.Load()
+}
+
+// updateLoadShedding compares the write latency and heap size against the thresholds, and switches the load
+// shedding on or off. It returns whether the queued feed messages should be added now that it was switched off.
+func (s *TransactionStreamer) updateLoadShedding(config *StreamerLoadSheddingConfig, writeLatency time.Duration, heapAlloc uint64) bool {
+	over := (config.DbWriteLatency > 0 && writeLatency >= config.DbWriteLatency) ||
+		(config.HeapLimit > 0 && heapAlloc >= config.HeapLimit)
+	relieved := (config.DbWriteLatency == 0 || float64(writeLatency) < loadSheddingRecoveryRatio*float64(config.DbWriteLatency)) &&
+		(config.HeapLimit == 0 || float64(heapAlloc) < loadSheddingRecoveryRatio*float64(config.HeapLimit))
+	if !config.enabled() {
+		over, relieved = false, true
+	}
+	active := s.loadShedding.active.Load()
+	switch {
+	case !active && over:
+		s.loadShedding.active.Store(true)
+		loadSheddingActiveGauge.Update(1)
+		loadSheddingActivationCounter.Inc(1)
+		log.Warn("resource pressure, only queueing feed messages until confirmed messages catch up", "dbWriteLatency", writeLatency, "heapAlloc", heapAlloc)
+	case active && relieved:
+		s.loadShedding.active.Store(false)
+		loadSheddingActiveGauge.Update(0)
+		log.Info("resource pressure subsided, processing feed messages again", "dbWriteLatency", writeLatency, "heapAlloc", heapAlloc)
+		return true
+	}
+	return false
+}
+
+// addQueuedBroadcastMessages adds the feed messages that were queued while load shedding was active
+func (s *TransactionStreamer) addQueuedBroadcastMessages() error {
+	if err := s.lockInsertion(); err != nil {
+		return err
+	}
+	defer s.insertionMutex.Unlock()
+	if s.broadcasterQueuedMessagesActiveReorg || len(s.broadcasterQueuedMessages) == 0 {
+		return nil
+	}
+	pos := arbutil.MessageIndex(s.broadcasterQueuedMessagesPos.Load())
+	if pos > 0 {
+		if _, err := s.GetMessage(pos - 1); err != nil {
+			if dbutil.IsErrNotFound(err) {
+				return nil
+			}
+			return err
+		}
+	}
+	return s.addBroadcasterQueue(pos)
+}
+
+func (s *TransactionStreamer) checkLoadShedding(ctx context.Context) time.Duration {
+	config := &s.config().LoadShedding
+	writeLatency := s.loadShedding.averageWriteLatency()
+	loadSheddingWriteLatencyGauge.Update(writeLatency.Microseconds())
+	var heapAlloc uint64
+	if config.HeapLimit > 0 {
+		var stats runtime.MemStats
+		runtime.ReadMemStats(&stats)
+		heapAlloc = stats.HeapAlloc
+		// #nosec G115
+		loadSheddingHeapAllocationGauge.Update(int64(heapAlloc))
+	}
+	if s.updateLoadShedding(config, writeLatency, heapAlloc) {
+		if err := s.addQueuedBroadcastMessages(); err != nil {
+			log.Warn("failed to add the feed messages queued during load shedding", "err", err)
+		}
+	}
+	if !config.enabled() {
+		return loadSheddingDisabledInterval
+	}
+	if config.CheckInterval <= 0 {
+		return DefaultStreamerLoadSheddingConfig.CheckInterval
+	}
+	return config.CheckInterval
+}
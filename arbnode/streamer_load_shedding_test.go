@@ -0,0 +1,59 @@
+package arbnode
+
+import (
+	"testing"
+	"time"
+
+	"github.com/offchainlabs/nitro/arbos/arbostypes"
+	m "github.com/offchainlabs/nitro/broadcaster/message"
+)
+
+func TestStreamerLoadShedding(t *testing.T) {
+	streamer := newTestImportStreamer(t)
+	message := func(timestamp uint64) arbostypes.MessageWithMetadata {
+		return arbostypes.MessageWithMetadata{
+			Message:             &arbostypes.L1IncomingMessage{Header: &arbostypes.L1IncomingMessageHeader{Timestamp: timestamp}},
+			DelayedMessagesRead: 1,
+		}
+	}
+	Require(t, streamer.AddMessages(0, true, []arbostypes.MessageWithMetadata{message(0), message(1)}))
+
+	config := &StreamerLoadSheddingConfig{DbWriteLatency: 100 * time.Millisecond}
+	if streamer.updateLoadShedding(config, 50*time.Millisecond, 0) || streamer.LoadSheddingActive() {
+		Fail(t, "load shedding activated below the threshold")
+	}
+	streamer.updateLoadShedding(config, 150*time.Millisecond, 0)
+	if !streamer.LoadSheddingActive() {
+		Fail(t, "load shedding not activated above the threshold")
+	}
+
+	// Feed messages are only queued while load shedding is active
+	Require(t, streamer.AddBroadcastMessages([]*m.BroadcastFeedMessage{{SequenceNumber: 2, Message: message(2)}}))
+	count, err := streamer.GetMessageCount()
+	Require(t, err)
+	if count != 2 {
+		Fail(t, "feed message added during load shedding", count)
+	}
+
+	// Pressure just below the threshold doesn't end the load shedding
+	if streamer.updateLoadShedding(config, 90*time.Millisecond, 0) {
+		Fail(t, "load shedding ended above the recovery threshold")
+	}
+	if !streamer.updateLoadShedding(config, 50*time.Millisecond, 0) || streamer.LoadSheddingActive() {
+		Fail(t, "load shedding not ended after the pressure subsided")
+	}
+	Require(t, streamer.addQueuedBroadcastMessages())
+	count, err = streamer.GetMessageCount()
+	Require(t, err)
+	if count != 3 {
+		Fail(t, "queued feed message not added after load shedding", count)
+	}
+
+	// The moving average follows the write latency
+	var shedder loadShedder
+	shedder.observeWrite(100 * time.Millisecond)
+	shedder.observeWrite(200 * time.Millisecond)
+	if latency := shedder.averageWriteLatency(); latency != 120*time.Millisecond {
+		Fail(t, "unexpected average write latency", latency)
+	}
+}
@@ -0,0 +1,301 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package arbnode
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+	flag "github.com/spf13/pflag"
+)
+
+var (
+	watchdogStalledCounter    = metrics.NewRegisteredCounter("arb/streamer/watchdog/stalled", nil)
+	watchdogRestartCounter    = metrics.NewRegisteredCounter("arb/streamer/watchdog/restarts", nil)
+	watchdogEscalationCounter = metrics.NewRegisteredCounter("arb/streamer/watchdog/escalations", nil)
+)
+
+// How long to wait before checking again whether the watchdog was enabled
+const watchdogDisabledInterval = time.Minute
+
+// StreamerWatchdogConfig configures the watchdog restarting the streamer loops that stop making progress
+type StreamerWatchdogConfig struct {
+	StallTimeout   time.Duration `koanf:"stall-timeout" reload:"hot"`
+	CheckInterval  time.Duration `koanf:"check-interval" reload:"hot"`
+	MaxRestarts    uint64        `koanf:"max-restarts" reload:"hot"`
+	RestartTimeout time.Duration `koanf:"restart-timeout" reload:"hot"`
+	DiagnosticsDir string        `koanf:"diagnostics-dir" reload:"hot"`
+}
+
+var DefaultStreamerWatchdogConfig = StreamerWatchdogConfig{
+	StallTimeout:   0,
+	CheckInterval:  30 * time.Second,
+	MaxRestarts:    3,
+	RestartTimeout: 30 * time.Second,
+	DiagnosticsDir: "",
+}
+
+func StreamerWatchdogConfigAddOptions(prefix string, f *flag.FlagSet) {
+	f.Duration(prefix+".stall-timeout", DefaultStreamerWatchdogConfig.StallTimeout, "how long the message execution or espresso submission loop may make no progress despite pending work before it's restarted (0 = disabled)")
+	f.Duration(prefix+".check-interval", DefaultStreamerWatchdogConfig.CheckInterval, "interval between checks of the progress of the streamer loops")
+	f.Uint64(prefix+".max-restarts", DefaultStreamerWatchdogConfig.MaxRestarts, "number of restarts of a stalled loop without progress in between, after which the node is stopped with a fatal error")
+	f.Duration(prefix+".restart-timeout", DefaultStreamerWatchdogConfig.RestartTimeout, "how long to wait for a stalled loop to stop when it's restarted, before the node is stopped with a fatal error")
+	f.String(prefix+".diagnostics-dir", DefaultStreamerWatchdogConfig.DiagnosticsDir, "directory the goroutine stacks and the recovery report are written to when a loop stalls (empty = only log the recovery report)")
+}
+
+func (c *StreamerWatchdogConfig) Validate() error {
+	if c.StallTimeout == 0 {
+		return nil
+	}
+	if c.CheckInterval >= c.StallTimeout {
+		return fmt.Errorf("watchdog check-interval %v must be shorter than the stall-timeout %v, or stalls aren't detected in time", c.CheckInterval, c.StallTimeout)
+	}
+	if c.RestartTimeout <= 0 {
+		return errors.New("watchdog restart-timeout must be positive while the watchdog is enabled")
+	}
+	return nil
+}
+
+// watchedLoop runs a streamer loop like stopwaiter.CallIterativelyWith, on a context that the watchdog cancels
+// to restart the loop when it stops making progress
+type watchedLoop struct {
+	name    string
+	iterate func(context.Context, struct{}) time.Duration
+	trigger <-chan struct{}
+	// Resets the state of the loop before it's started again, called from the loop's thread
+	onRestart func()
+	// Returns a value that changes whenever the loop makes progress, and whether the loop has pending work
+	progress func() (any, bool, error)
+
+	mutex  sync.Mutex
+	cancel context.CancelFunc
+	// Closed once the current run of the loop returned
+	done chan struct{}
+
+	// Only accessed from the watchdog loop
+	lastMarker   any
+	lastProgress time.Time
+	restarts     uint64
+}
+
+func (l *watchedLoop) iterateUntilDone(ctx context.Context) {
+	for {
+		interval := l.iterate(ctx, struct{}{})
+		if ctx.Err() != nil {
+			return
+		}
+		if interval == 0 {
+			continue
+		}
+		timer := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		case <-l.trigger:
+			timer.Stop()
+		}
+	}
+}
+
+func (l *watchedLoop) run(ctx context.Context) {
+	for ctx.Err() == nil {
+		runCtx, cancel := context.WithCancel(ctx)
+		done := make(chan struct{})
+		l.mutex.Lock()
+		l.cancel, l.done = cancel, done
+		l.mutex.Unlock()
+		l.iterateUntilDone(runCtx)
+		cancel()
+		close(done)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Warn("restarting stalled streamer loop", "loop", l.name)
+		if l.onRestart != nil {
+			l.onRestart()
+		}
+	}
+}
+
+// restart cancels the current run of the loop, and waits for it to return so that the loop starts over
+func (l *watchedLoop) restart(timeout time.Duration) error {
+	l.mutex.Lock()
+	cancel, done := l.cancel, l.done
+	l.mutex.Unlock()
+	if cancel == nil {
+		return fmt.Errorf("streamer loop %s isn't running", l.name)
+	}
+	cancel()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+		return nil
+	case <-timer.C:
+		return fmt.Errorf("streamer loop %s didn't stop within %v of being restarted", l.name, timeout)
+	}
+}
+
+// startWatchedLoop launches a loop that the watchdog restarts when it stops making progress, it must be called from Start
+func (s *TransactionStreamer) startWatchedLoop(loop *watchedLoop) error {
+	s.watchedLoops = append(s.watchedLoops, loop)
+	return s.LaunchThreadSafe(loop.run)
+}
+
+func (s *TransactionStreamer) executionProgress() (any, bool, error) {
+	msgCount, err := s.GetMessageCount()
+	if err != nil {
+		return nil, false, err
+	}
+	head, err := s.exec.HeadMessageNumber()
+	if err != nil {
+		return nil, false, err
+	}
+	return head, head+1 < msgCount, nil
+}
+
+// Submitting a transaction and confirming its messages are the progress of the espresso loop,
+// messages being queued aren't
+type espressoProgressMarker struct {
+	submittedHash string
+	lastConfirmed uint64
+}
+
+func (s *TransactionStreamer) espressoProgress() (any, bool, error) {
+	status, err := s.GetEspressoStatus()
+	if err != nil {
+		return nil, false, err
+	}
+	var marker espressoProgressMarker
+	if status.SubmittedTxHash != nil {
+		marker.submittedHash = *status.SubmittedTxHash
+	}
+	if status.LastConfirmedPos != nil {
+		marker.lastConfirmed = uint64(*status.LastConfirmedPos) + 1
+	}
+	pending := len(status.PendingPositions) > 0 || len(status.SubmittedPositions) > 0
+	// Nothing can be submitted while HotShot is down, which is handled by the escape hatch and not by a restart
+	if s.espressoUnreachable.Load() || status.HotShotDown {
+		pending = false
+	}
+	return marker, pending, nil
+}
+
+// captureStallDiagnostics logs the recovery report, and writes it with the goroutine stacks to the diagnostics
+// directory if one is configured
+func (s *TransactionStreamer) captureStallDiagnostics(ctx context.Context, loop *watchedLoop, stalledFor time.Duration) {
+	report, err := s.DiagnoseRecovery(ctx)
+	if err != nil {
+		log.Warn("failed to diagnose the stalled streamer loop", "loop", loop.name, "err", err)
+	} else {
+		for _, finding := range report.Findings {
+			log.Warn("stalled streamer loop diagnostics", "loop", loop.name, "component", finding.Component, "issue", finding.Issue, "severity", finding.Severity, "action", finding.Action, "details", finding.Details)
+		}
+	}
+	dir := s.config().Watchdog.DiagnosticsDir
+	if dir == "" {
+		return
+	}
+	stacks := make([]byte, 1<<20)
+	for {
+		n := runtime.Stack(stacks, true)
+		if n < len(stacks) {
+			stacks = stacks[:n]
+			break
+		}
+		stacks = make([]byte, 2*len(stacks))
+	}
+	reportJson, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		reportJson = []byte(err.Error())
+	}
+	contents := fmt.Sprintf("loop %s made no progress for %v\n\nrecovery report:\n%s\n\ngoroutines:\n%s", loop.name, stalledFor, reportJson, stacks)
+	path := filepath.Join(dir, fmt.Sprintf("streamer-stall-%s-%d.txt", loop.name, time.Now().Unix()))
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		log.Warn("failed to write the stalled streamer loop diagnostics", "path", path, "err", err)
+		return
+	}
+	log.Warn("wrote the stalled streamer loop diagnostics", "loop", loop.name, "path", path)
+}
+
+func (s *TransactionStreamer) escalateStalledLoop(ctx context.Context, err error) {
+	watchdogEscalationCounter.Inc(1)
+	if s.fatalErrChan == nil {
+		log.Error("streamer loop couldn't be recovered", "err", err)
+		return
+	}
+	select {
+	case s.fatalErrChan <- err:
+	case <-ctx.Done():
+	}
+}
+
+// checkWatchedLoops restarts the loops that had pending work but made no progress for the stall timeout.
+// A loop that stays stalled after the configured number of restarts, or doesn't stop when restarted,
+// is reported as a fatal error.
+func (s *TransactionStreamer) checkWatchedLoops(ctx context.Context, now time.Time) {
+	config := &s.config().Watchdog
+	for _, loop := range s.watchedLoops {
+		marker, pending, err := loop.progress()
+		if err != nil {
+			log.Warn("failed to check the progress of a streamer loop", "loop", loop.name, "err", err)
+			continue
+		}
+		progressed := marker != loop.lastMarker
+		if progressed || !pending {
+			loop.restarts = 0
+		}
+		if progressed || !pending || loop.lastProgress.IsZero() {
+			loop.lastMarker = marker
+			loop.lastProgress = now
+			continue
+		}
+		stalledFor := now.Sub(loop.lastProgress)
+		if stalledFor < config.StallTimeout {
+			continue
+		}
+		watchdogStalledCounter.Inc(1)
+		log.Error("streamer loop made no progress despite pending work", "loop", loop.name, "stalledFor", stalledFor, "restarts", loop.restarts)
+		s.captureStallDiagnostics(ctx, loop, stalledFor)
+		// Give the loop another stall timeout after the restart or escalation
+		loop.lastProgress = now
+		if loop.restarts >= config.MaxRestarts {
+			s.escalateStalledLoop(ctx, fmt.Errorf("streamer loop %s made no progress for %v after %d restarts", loop.name, stalledFor, loop.restarts))
+			continue
+		}
+		if err := loop.restart(config.RestartTimeout); err != nil {
+			s.escalateStalledLoop(ctx, err)
+			continue
+		}
+		loop.restarts++
+		watchdogRestartCounter.Inc(1)
+	}
+}
+
+func (s *TransactionStreamer) watchLoops(ctx context.Context) time.Duration {
+	config := &s.config().Watchdog
+	if config.StallTimeout == 0 {
+		// Forget the progress seen before the watchdog was disabled
+		for _, loop := range s.watchedLoops {
+			loop.lastProgress = time.Time{}
+		}
+		return watchdogDisabledInterval
+	}
+	s.checkWatchedLoops(ctx, time.Now())
+	if config.CheckInterval <= 0 {
+		return DefaultStreamerWatchdogConfig.CheckInterval
+	}
+	return config.CheckInterval
+}
@@ -0,0 +1,91 @@
+package arbnode
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestStreamerWatchdog(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	fatalErrChan := make(chan error, 1)
+	streamer := newTestStreamer(t, nil, func(c *TransactionStreamerConfig) {
+		c.Watchdog = StreamerWatchdogConfig{StallTimeout: time.Minute, MaxRestarts: 1, RestartTimeout: 100 * time.Millisecond}
+	}, WithFatalErrChan(fatalErrChan))
+	streamer.StopWaiter.Start(ctx, streamer)
+	defer streamer.StopWaiter.StopAndWait()
+
+	var restarts atomic.Int32
+	marker := 0
+	Require(t, streamer.startWatchedLoop(&watchedLoop{
+		name: "stalled",
+		iterate: func(ctx context.Context, _ struct{}) time.Duration {
+			<-ctx.Done()
+			return 0
+		},
+		onRestart: func() { restarts.Add(1) },
+		progress:  func() (any, bool, error) { return marker, true, nil },
+	}))
+
+	expectFatal := func(expected bool) {
+		t.Helper()
+		select {
+		case err := <-fatalErrChan:
+			if !expected {
+				Fail(t, "unexpected fatal error", err)
+			}
+		default:
+			if expected {
+				Fail(t, "stalled loop not escalated")
+			}
+		}
+	}
+
+	now := time.Now()
+	streamer.checkWatchedLoops(ctx, now)
+	streamer.checkWatchedLoops(ctx, now.Add(30*time.Second))
+	if restarts.Load() != 0 {
+		Fail(t, "loop restarted before the stall timeout")
+	}
+	streamer.checkWatchedLoops(ctx, now.Add(61*time.Second))
+	for i := 0; restarts.Load() == 0; i++ {
+		if i == 100 {
+			Fail(t, "stalled loop not restarted")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	expectFatal(false)
+	streamer.checkWatchedLoops(ctx, now.Add(122*time.Second))
+	expectFatal(true)
+
+	// Progress resets the restart count, so that the loop is restarted again instead of escalated
+	marker = 1
+	streamer.checkWatchedLoops(ctx, now.Add(123*time.Second))
+	streamer.checkWatchedLoops(ctx, now.Add(184*time.Second))
+	expectFatal(false)
+	for i := 0; restarts.Load() < 2; i++ {
+		if i == 100 {
+			Fail(t, "loop not restarted after making progress")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// A loop that doesn't stop when it's restarted is escalated right away
+	stuck := make(chan struct{})
+	defer close(stuck)
+	Require(t, streamer.startWatchedLoop(&watchedLoop{
+		name: "stuck",
+		iterate: func(ctx context.Context, _ struct{}) time.Duration {
+			<-stuck
+			<-ctx.Done()
+			return 0
+		},
+		progress: func() (any, bool, error) { return 0, true, nil },
+	}))
+	streamer.watchedLoops = streamer.watchedLoops[1:]
+	streamer.checkWatchedLoops(ctx, now)
+	streamer.checkWatchedLoops(ctx, now.Add(2*time.Minute))
+	expectFatal(true)
+}
@@ -9,9 +9,10 @@ import (
 	"encoding/binary"
 	"encoding/json"
 	"fmt"
+	"math"
 	"math/big"
 	"os"
-	"reflect"
+	"slices"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -19,10 +20,8 @@ import (
 
 	lightclient "github.com/EspressoSystems/espresso-sequencer-go/light-client"
 	tagged_base64 "github.com/EspressoSystems/espresso-sequencer-go/tagged-base64"
-	"github.com/offchainlabs/nitro/espressocrypto"
 	"github.com/offchainlabs/nitro/util"
 
-	espressoClient "github.com/EspressoSystems/espresso-sequencer-go/client"
 	espressoTypes "github.com/EspressoSystems/espresso-sequencer-go/types"
 
 	"errors"
@@ -30,7 +29,9 @@ import (
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/event"
 	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
 	"github.com/ethereum/go-ethereum/params"
 	"github.com/ethereum/go-ethereum/rlp"
 	flag "github.com/spf13/pflag"
@@ -42,8 +43,11 @@ import (
 	"github.com/offchainlabs/nitro/execution"
 	"github.com/offchainlabs/nitro/staker"
 	"github.com/offchainlabs/nitro/util/arbmath"
+	"github.com/offchainlabs/nitro/util/containers"
+	"github.com/offchainlabs/nitro/util/contracts"
 	"github.com/offchainlabs/nitro/util/dbutil"
 	"github.com/offchainlabs/nitro/util/sharedmetrics"
+	"github.com/offchainlabs/nitro/util/signature"
 	"github.com/offchainlabs/nitro/util/stopwaiter"
 )
 
@@ -61,6 +65,10 @@ type TransactionStreamer struct {
 	fatalErrChan   chan<- error
 	config         TransactionStreamerConfigFetcher
 	snapSyncConfig *SnapSyncConfig
+	// Set at construction by WithReadOnly, nothing is written to db
+	readOnly bool
+	// Whether the chain is derived from espresso instead of the feed and the sequencer, fixed at startup
+	espressoDerivation bool
 
 	insertionMutex                  sync.Mutex // cannot be acquired while reorgMutex is held
 	reorgMutex                      sync.RWMutex
@@ -68,24 +76,145 @@ type TransactionStreamer struct {
 
 	newMessageNotifier     chan struct{}
 	newSovereignTxNotifier chan struct{}
+	// Wakes all message iterators, newMessageNotifier only wakes the execution loop
+	newMessageSignal messageSignal
 
 	nextAllowedFeedReorgLog time.Time
+	feedLatency             *feedLatencyTracker
+	// Exponential moving average of the time to write a sequenced message, in nanoseconds,
+	// only updated while holding the insertionMutex
+	sequencingLatency atomic.Int64
 
 	broadcasterQueuedMessages            []arbostypes.MessageWithMetadataAndBlockHash
 	broadcasterQueuedMessagesPos         atomic.Uint64
 	broadcasterQueuedMessagesActiveReorg bool
+	// Number of queued feed messages spilled to the database, following the ones in memory
+	broadcasterQueueSpilled uint64
 
 	coordinator     *SeqCoordinator
 	broadcastServer *broadcaster.Broadcaster
 	inboxReader     *InboxReader
 	delayedBridge   *DelayedBridge
+	// Decides which messages are resequenced on reorg, the configured policy is applied when nil
+	reorgHook ReorgHook
+
+	// The ordering layer messages are submitted to, HotShot is used when nil
+	finalityProvider FinalityProvider
 
 	// Espresso specific fields. These fields are set from batch poster
-	espressoClient               *espressoClient.Client
+	espressoClient               espressoQueryClient
 	lightClientReader            lightclient.LightClientReaderInterface
+	espressoHeaderVerifier       lightclient.LightClientReaderInterface // separately configured light client headers are verified against
 	espressoTxnsPollingInterval  time.Duration
 	espressoSwitchDelayThreshold uint64
 	espressoMaxTransactionSize   uint64
+	// Optional push-style source of new HotShot blocks, polling is used when nil or disconnected
+	espressoHeaderStream *espressoHeaderStream
+	espressoBlockCache   *espressoBlockCache
+	messageReadCache     *messageReadCache
+	recentMessages       *recentMessageCache
+	// Remote store of the pruned messages, nil if there's none
+	messageArchive      MessageArchive
+	messageArchiveMutex sync.Mutex
+	messageArchiveCache *containers.LruCache[arbutil.MessageIndex, []byte]
+	executionPipeline   *executionPipeline
+	// Encodes messages into Espresso transactions, the default codec is used when nil
+	espressoCodec EspressoPayloadCodec
+	// Encode and verify the justifications by HotShot header version, the default codecs are used when nil
+	espressoJustificationCodecs map[espressoTypes.Version]EspressoJustificationCodec
+	// Orders the pending messages for submission, the configured submission order is used when nil
+	espressoOrderer EspressoSubmissionOrderer
+	// Attest the submitted payloads and verify the attestations of the finalized ones, nil without an attestation scheme
+	espressoAttestationSigner   EspressoAttestationSigner
+	espressoAttestationVerifier EspressoAttestationVerifier
+	// Source of the hot reloadable espresso config, nil if espresso is configured statically
+	batchPosterConfig BatchPosterConfigFetcher
+	// Only accessed from the espressoSwitch loop
+	espressoHotShotUrl           string
+	espressoSubmissionReconciled bool
+	espressoLastReachable        time.Time
+	espressoPolledStreamHeight   uint64
+	// Only accessed from the namespace scanner loop
+	espressoNamespaceScanHeight uint64
+	// Set when the HotShot query service has been unreachable for longer than the configured threshold
+	espressoUnreachable atomic.Bool
+	// Number of positions in the pending espresso queue, kept in memory for the sequencer's backpressure check
+	espressoPendingCount atomic.Int64
+	// Whether the pending espresso queue is also kept in the legacy layout and compared on reads, fixed at construction
+	espressoPendingShadowRead bool
+	// The pending queue in the legacy layout, only accessed while holding the espressoTxnsStateInsertionMutex
+	espressoPendingShadow []arbutil.MessageIndex
+	// Set on shutdown to stop starting new espresso submissions
+	espressoStopping atomic.Bool
+	// Set when the previous run wasn't shut down cleanly with a transaction in flight, until it's reconciled
+	espressoUncleanShutdown atomic.Bool
+	// Position up to which the messages are known to be justified, see EspressoJustifiedWatermark
+	espressoWatermarkMutex sync.Mutex
+	espressoWatermark      arbutil.MessageIndex
+	// Count of messages the node was snap synced from, the messages before it are trusted to be justified
+	espressoSnapSyncPos arbutil.MessageIndex
+	// Migration activation position published in the chain config, nil if it wasn't on startup
+	espressoPublishedActivationPos atomic.Pointer[arbutil.MessageIndex]
+	// Tip of the message hash chain, nil until it's loaded; generation is bumped by every reorg, see message_chain.go
+	messageChainMutex      sync.Mutex
+	messageChainTip        *messageChainTip
+	messageChainGeneration uint64
+	// Accumulators received from the feed whose local ones weren't computed yet, only accessed while holding the messageChainMutex
+	feedMessageChain *containers.LruCache[arbutil.MessageIndex, common.Hash]
+	// Held by an espressoSwitch iteration, and by the shutdown while it drains the in-flight submission
+	espressoSwitchSlot chan struct{}
+	// Whether this node was the chosen sequencer in the previous espressoSwitch iteration
+	espressoWasChosen bool
+	// Only accessed from the checkpoint loop
+	espressoLastCheckpoint arbutil.MessageIndex
+	// The latest peer checkpoint beyond the local state, verified once the node catches up
+	espressoCheckpointMutex   sync.Mutex
+	espressoPendingCheckpoint *m.CheckpointMessage
+	// Count of messages confirmed on the parent chain, below which messages may be pruned
+	pruneWatermark atomic.Uint64
+	// Only accessed from the chain config loop
+	espressoChainParams *espressoChainParams
+	// Max block size of the HotShot chain config, 0 until it's read
+	espressoMaxBlockSize atomic.Uint64
+	// Base fee of the HotShot chain config, nil until it's read
+	espressoBaseFee atomic.Pointer[big.Int]
+	// Estimates the fees of espresso transactions, the base fee estimate is used when nil
+	espressoFeeEstimator EspressoFeeEstimator
+	// Verifies transaction-scoped proofs, the whole namespace is always fetched when nil
+	espressoTxProofVerifier EspressoTransactionProofVerifier
+	espressoDeadlineFeed    event.Feed
+	// Resource pressure under which feed messages are only queued
+	loadShedding loadShedder
+	// Rules tolerating benign differences between stored and added messages, only appended to before Start
+	messageComparators []MessageComparator
+	// Count of justified messages that must be executed after a restart before sequencing resumes, set in Start
+	startupReplayTarget arbutil.MessageIndex
+	startupReplayed     atomic.Bool
+	// The last honored kill switch message, nil if none was received
+	killSwitchMutex sync.Mutex
+	killSwitch      atomic.Pointer[m.KillSwitchMessage]
+	// Next polling interval of the espresso loop with adaptive polling, only accessed from the espresso loop
+	espressoPollInterval time.Duration
+	// Version of the last espresso header justified, as major<<16 | minor, 0 until one is
+	espressoHeaderVersion atomic.Uint32
+	// Consecutive espresso verification failures, and whether the node halted on them
+	espressoVerificationFailures atomic.Uint64
+	espressoVerificationHalted   atomic.Bool
+	// Consecutive failures to build the transaction of the pending queue head, only accessed from the espresso loop
+	espressoHeadFailurePos arbutil.MessageIndex
+	espressoHeadFailures   uint64
+	// Sequenced messages staged for the next group commit and the error of a failed commit, guarded by the
+	// insertionMutex. sequencerGroupEnd is the count of messages including the staged ones, 0 without any, and
+	// lastSequencerGroup the latest group opened, which is kept once it's committed for its writers to wait on.
+	sequencerGroup     *sequencerGroup
+	sequencerGroupErr  error
+	sequencerGroupEnd  atomic.Uint64
+	lastSequencerGroup atomic.Pointer[sequencerGroup]
+	// Verifier of the sequencer signatures of feed messages, set in Start if the verification is enabled
+	feedAddressVerifier   contracts.AddressVerifierInterface
+	feedSignatureVerifier *signature.Verifier
+	// The loops restarted by the watchdog, only appended to in Start
+	watchedLoops []*watchedLoop
 	// Public these fields for testing
 	HotshotDown                bool
 	UseEscapeHatch             bool
@@ -95,9 +224,199 @@ type TransactionStreamer struct {
 type TransactionStreamerConfig struct {
 	MaxBroadcasterQueueSize int           `koanf:"max-broadcaster-queue-size"`
 	MaxReorgResequenceDepth int64         `koanf:"max-reorg-resequence-depth" reload:"hot"`
+	ReorgResequencePolicy   string        `koanf:"reorg-resequence-policy" reload:"hot"`
 	ExecuteMessageLoopDelay time.Duration `koanf:"execute-message-loop-delay" reload:"hot"`
+	ExecuteBatchThreshold   uint64        `koanf:"execute-batch-threshold" reload:"hot"`
+	ExecuteBatchSize        uint64        `koanf:"execute-batch-size" reload:"hot"`
 	UserDataAttestationFile string        `koanf:"user-data-attestation-file"`
 	QuoteFile               string        `koanf:"quote-file"`
+	ReorgHistorySize        uint64        `koanf:"reorg-history-size" reload:"hot"`
+	ReadCacheMessages       uint64        `koanf:"read-cache-messages"`
+	ReadCacheSlotSize       uint64        `koanf:"read-cache-slot-size"`
+	RecentMessageCacheSize  uint64        `koanf:"recent-message-cache-size"`
+	// Background pruning of old messages
+	Retention MessageRetentionConfig `koanf:"retention" reload:"hot"`
+	// Remote store the pruned messages are read from
+	Archive MessageArchiveConfig `koanf:"archive" reload:"hot"`
+	// Preparing the upcoming messages in the background while a message is executed
+	ExecutionPipeline ExecutionPipelineConfig `koanf:"execution-pipeline" reload:"hot"`
+	// Restarts of the loops that stop making progress
+	Watchdog StreamerWatchdogConfig `koanf:"watchdog" reload:"hot"`
+	// Deferring feed messages while the node is under resource pressure
+	LoadShedding StreamerLoadSheddingConfig `koanf:"load-shedding" reload:"hot"`
+	// Comparison of the message count with the execution engine and the validator
+	ConsistencyCheck StreamerConsistencyCheckConfig `koanf:"consistency-check" reload:"hot"`
+	// Messages the feed backlog is populated with
+	FeedBacklog FeedBacklogConfig `koanf:"feed-backlog" reload:"hot"`
+	// Address of the chain owner whose kill switch messages pause sequencing and espresso submission
+	KillSwitchOwner string `koanf:"kill-switch-owner" reload:"hot"`
+	// Verification of the sequencer signatures of feed messages, fixed at startup
+	FeedSignature FeedSignatureConfig `koanf:"feed-signature"`
+	// Committing the messages written by the sequencer in groups
+	SequencerGroupCommit SequencerGroupCommitConfig `koanf:"sequencer-group-commit" reload:"hot"`
+	// Persists when each message reached the stages of its lifecycle
+	MessageTelemetry bool `koanf:"message-telemetry" reload:"hot"`
+	// Interval of the message hash chain accumulators kept to audit the message history, see message_chain.go
+	MessageChainInterval uint64 `koanf:"message-chain-interval"`
+	// gRPC service streaming the canonical messages to indexers, see message_stream.proto
+	MessageStream MessageStreamServerConfig `koanf:"message-stream"`
+	// Espresso specific flags
+	Espresso EspressoStreamerConfig `koanf:"espresso" reload:"hot"`
+}
+
+type EspressoStreamerConfig struct {
+	MigrationActivationPos uint64        `koanf:"migration-activation-pos"`
+	UnreachableThreshold   time.Duration `koanf:"unreachable-threshold" reload:"hot"`
+	NamespaceScanInterval  time.Duration `koanf:"namespace-scan-interval" reload:"hot"`
+	NamespaceScanMaxBlocks uint64        `koanf:"namespace-scan-max-blocks" reload:"hot"`
+	ResubmissionTimeout    time.Duration `koanf:"resubmission-timeout" reload:"hot"`
+	MaxResubmissions       uint64        `koanf:"max-resubmissions" reload:"hot"`
+	ShutdownTimeout        time.Duration `koanf:"shutdown-timeout" reload:"hot"`
+	TrustlessReplica       bool          `koanf:"trustless-replica" reload:"hot"`
+	LongPollInterval       time.Duration `koanf:"long-poll-interval" reload:"hot"`
+	MaxPendingMessages     uint64        `koanf:"max-pending-messages" reload:"hot"`
+	CheckpointInterval     time.Duration `koanf:"checkpoint-interval" reload:"hot"`
+	SubmissionDeadline     time.Duration `koanf:"submission-deadline" reload:"hot"`
+	DeadlineFallback       string        `koanf:"deadline-fallback" reload:"hot"`
+	// Keeps the legacy layout of the pending queue next to the per-position keys, comparing both on every read
+	PendingQueueShadowRead bool `koanf:"pending-queue-shadow-read"`
+	// Interval between polls of the HotShot chain config the submission size limit is adapted to
+	ChainConfigPollInterval time.Duration `koanf:"chain-config-poll-interval" reload:"hot"`
+	// Interval between iterations of the backfill of justifications for messages confirmed before they were kept
+	JustificationBackfillInterval time.Duration                    `koanf:"justification-backfill-interval" reload:"hot"`
+	HeaderVerification            EspressoHeaderVerificationConfig `koanf:"header-verification" reload:"hot"`
+	// Interval between iterations of the revalidation of justifications against light client roots finalized on L1
+	JustificationRevalidationInterval time.Duration `koanf:"justification-revalidation-interval" reload:"hot"`
+	// How long a submitted payload is remembered, so that submitting the same payload again is skipped
+	SubmissionDedupWindow time.Duration             `koanf:"submission-dedup-window" reload:"hot"`
+	Fees                  EspressoFeeConfig         `koanf:"fees" reload:"hot"`
+	Compression           EspressoCompressionConfig `koanf:"compression" reload:"hot"`
+	// Size of the chain's namespace in a block above which finality is proven with the transaction's own proof
+	PartialProofMinSize uint64 `koanf:"partial-proof-min-size" reload:"hot"`
+	// Verifies the espresso justifications of feed messages against the light client before queueing them
+	FeedJustificationVerification bool `koanf:"feed-justification-verification" reload:"hot"`
+	// Submits the messages to espresso and records their finality without waiting for it
+	ShadowMode  bool                      `koanf:"shadow-mode" reload:"hot"`
+	ClientRetry EspressoClientRetryConfig `koanf:"client-retry" reload:"hot"`
+	// Polling interval of the submission loop adapted to the expected inclusion latency
+	AdaptivePolling EspressoAdaptivePollingConfig `koanf:"adaptive-polling" reload:"hot"`
+	// Which pending messages are submitted first, see EspressoSubmissionOrderer
+	SubmissionOrder string `koanf:"submission-order" reload:"hot"`
+	// Envelope of the attestation of the submitted payloads, fixed at startup
+	Attestation EspressoAttestationConfig `koanf:"attestation"`
+	// Builders every transaction is also submitted to, fixed at startup
+	BuilderUrls []string `koanf:"builder-urls"`
+	// Consecutive failed verifications of fetched headers and proofs after which the node halts
+	VerificationFailureThreshold uint64 `koanf:"verification-failure-threshold" reload:"hot"`
+	// Oldest HotShot header version justifications are written and accepted for, as major.minor
+	MinHeaderVersion string `koanf:"min-header-version" reload:"hot"`
+	// Reading the chain from the namespace instead of the feed and the sequencer
+	Derivation EspressoDerivationConfig `koanf:"derivation" reload:"hot"`
+	// Consecutive failures to build the transaction of a message after which it's moved to the dead-letter table
+	MaxSubmissionFailures uint64 `koanf:"max-submission-failures" reload:"hot"`
+}
+
+var DefaultEspressoStreamerConfig = EspressoStreamerConfig{
+	UnreachableThreshold:   10 * time.Minute,
+	NamespaceScanInterval:  0,
+	NamespaceScanMaxBlocks: 1000,
+	ResubmissionTimeout:    2 * time.Minute,
+	MaxResubmissions:       5,
+	ShutdownTimeout:        10 * time.Second,
+	MaxPendingMessages:     50_000,
+	DeadlineFallback:       EspressoDeadlineFallbackDrop,
+	HeaderVerification:     DefaultEspressoHeaderVerificationConfig,
+	SubmissionDedupWindow:  30 * time.Second,
+	Fees:                   DefaultEspressoFeeConfig,
+	Compression:            DefaultEspressoCompressionConfig,
+	PartialProofMinSize:    1024 * 1024,
+	ClientRetry:            DefaultEspressoClientRetryConfig,
+	AdaptivePolling:        DefaultEspressoAdaptivePollingConfig,
+	Derivation:             DefaultEspressoDerivationConfig,
+	SubmissionOrder:        EspressoSubmissionOrderPosition,
+	Attestation:            DefaultEspressoAttestationConfig,
+}
+
+func EspressoStreamerConfigAddOptions(prefix string, f *flag.FlagSet) {
+	f.Uint64(prefix+".migration-activation-pos", DefaultEspressoStreamerConfig.MigrationActivationPos, "position of the first message to be sequenced through espresso when migrating from centralized sequencing, which the chain owner must publish as espresso.migrationActivationPos in the chain config first (0 = the published position, or espresso from genesis if none is published)")
+	f.Duration(prefix+".unreachable-threshold", DefaultEspressoStreamerConfig.UnreachableThreshold, "how long the hotshot query service may be unreachable before falling back to treating hotshot as down (0 = never)")
+	f.Duration(prefix+".namespace-scan-interval", DefaultEspressoStreamerConfig.NamespaceScanInterval, "interval between scans of new hotshot blocks for transactions in the chain's namespace that weren't submitted by this node (0 = disabled)")
+	f.Uint64(prefix+".namespace-scan-max-blocks", DefaultEspressoStreamerConfig.NamespaceScanMaxBlocks, "maximum number of recent hotshot blocks to scan in one namespace scan, older blocks are skipped")
+	f.Duration(prefix+".resubmission-timeout", DefaultEspressoStreamerConfig.ResubmissionTimeout, "how long to wait for a submitted transaction to be included in a hotshot block before submitting its messages again (0 = wait forever)")
+	f.Uint64(prefix+".max-resubmissions", DefaultEspressoStreamerConfig.MaxResubmissions, "maximum number of times messages are resubmitted after the resubmission timeout")
+	f.Duration(prefix+".shutdown-timeout", DefaultEspressoStreamerConfig.ShutdownTimeout, "how long to wait on shutdown for the in-flight espresso submission to be drained and polled for finality (0 = don't wait)")
+	f.Bool(prefix+".trustless-replica", DefaultEspressoStreamerConfig.TrustlessReplica, "only adopt block hashes from the feed for messages whose espresso justification was verified locally, and compute all other block hashes locally")
+	f.Uint64(prefix+".max-pending-messages", DefaultEspressoStreamerConfig.MaxPendingMessages, "maximum number of messages waiting to be submitted to espresso, the sequencer is asked to retry while the queue is full (0 = unlimited)")
+	f.Duration(prefix+".justification-backfill-interval", DefaultEspressoStreamerConfig.JustificationBackfillInterval, "interval between iterations of the background job storing espresso justifications for confirmed messages that don't have one yet (0 = disabled)")
+	f.Duration(prefix+".justification-revalidation-interval", DefaultEspressoStreamerConfig.JustificationRevalidationInterval, "interval between iterations of the background job checking the stored espresso justifications again once the light client finalized their root on L1, fetching again and rewriting the ones a hotshot reorg invalidated (0 = disabled)")
+	EspressoHeaderVerificationConfigAddOptions(prefix+".header-verification", f)
+	EspressoFeeConfigAddOptions(prefix+".fees", f)
+	EspressoCompressionConfigAddOptions(prefix+".compression", f)
+	EspressoClientRetryConfigAddOptions(prefix+".client-retry", f)
+	EspressoAdaptivePollingConfigAddOptions(prefix+".adaptive-polling", f)
+	EspressoDerivationConfigAddOptions(prefix+".derivation", f)
+	EspressoAttestationConfigAddOptions(prefix+".attestation", f)
+	f.StringSlice(prefix+".builder-urls", DefaultEspressoStreamerConfig.BuilderUrls, "urls of espresso builders serving the submit api, every espresso transaction is submitted to all of them and to the hotshot query service simultaneously and the first successful submission is accepted, to keep transactions included while some builders are flaky")
+	f.Uint64(prefix+".partial-proof-min-size", DefaultEspressoStreamerConfig.PartialProofMinSize, "size in bytes of the chain's namespace in a hotshot block from which the finality of a transaction is proven with the proof the query service returns along with the transaction, instead of fetching the whole namespace; only used with a transaction proof verifier and a query service returning such proofs (0 = always fetch the whole namespace)")
+	f.Bool(prefix+".feed-justification-verification", DefaultEspressoStreamerConfig.FeedJustificationVerification, "verify the espresso justifications carried by feed messages against the hotshot light client before queueing the messages, rejecting messages whose justification doesn't verify; messages without a justification are queued as before")
+	f.Bool(prefix+".shadow-mode", DefaultEspressoStreamerConfig.ShadowMode, "submit every message to espresso and record its finality, without ever holding back sequencing or batch posting for it nor applying the deadline fallback, to compare the espresso finality latency against the production one before migrating")
+	f.Duration(prefix+".chain-config-poll-interval", DefaultEspressoStreamerConfig.ChainConfigPollInterval, "interval between polls of the hotshot chain config, lowering the espresso transaction size limit to fit the max block size and alerting when the config changes (0 = disabled)")
+	f.Duration(prefix+".checkpoint-interval", DefaultEspressoStreamerConfig.CheckpointInterval, "interval between signed checkpoints of the last executed batch broadcast over the feed, received checkpoints are verified against the local state while it's set (0 = disabled)")
+	f.Duration(prefix+".submission-deadline", DefaultEspressoStreamerConfig.SubmissionDeadline, "how long after being queued a message must be included in a hotshot block before it goes through the deadline fallback, unless the sequencer set its own deadline (0 = no default deadline)")
+	f.String(prefix+".deadline-fallback", DefaultEspressoStreamerConfig.DeadlineFallback, "what happens to a message that missed its espresso submission deadline: \"escape-hatch\" posts it without espresso verification, \"drop\" stops submitting it and only notifies subscribers")
+	f.Bool(prefix+".pending-queue-shadow-read", DefaultEspressoStreamerConfig.PendingQueueShadowRead, "keep writing the pending espresso queue in its legacy single-key layout next to the per-position keys, and log any difference between them on every read; disable once a soak period saw no mismatches to drop the legacy layout")
+	f.Duration(prefix+".submission-dedup-window", DefaultEspressoStreamerConfig.SubmissionDedupWindow, "how long the content hash of a submitted espresso payload is kept, an identical payload submitted within this window, e.g. when retrying after an ambiguous error, isn't sent to the namespace again (0 = disabled)")
+	f.String(prefix+".submission-order", DefaultEspressoStreamerConfig.SubmissionOrder, "order in which the pending messages are included in espresso transactions: \"position\" in increasing position, \"delayed-first\" to submit the messages reading new delayed messages first, or \"smallest-first\" to fit as many messages as possible in each transaction; messages are still confirmed in position order")
+	f.Uint64(prefix+".verification-failure-threshold", DefaultEspressoStreamerConfig.VerificationFailureThreshold, "number of consecutive failed verifications of the headers and proofs fetched from the hotshot query service after which the node halts with a fatal error instead of retrying, as the query service may be compromised; the failure is stored for post-mortem (0 = never halt)")
+	f.String(prefix+".min-header-version", DefaultEspressoStreamerConfig.MinHeaderVersion, "oldest hotshot header version, as major.minor, espresso justifications are written for and feed justifications are accepted with; headers of every supported version at or above it are verified side by side while hotshot is upgraded (empty = any supported version)")
+	f.Uint64(prefix+".max-submission-failures", DefaultEspressoStreamerConfig.MaxSubmissionFailures, "number of consecutive times building the espresso transaction of the message at the head of the pending queue may fail, e.g. because the message can't be read or encoded, before the message is moved to the dead-letter table and the queue continues past it; dead-lettered messages are listed and requeued through the espresso admin api (0 = retry forever)")
+	f.Duration(prefix+".long-poll-interval", DefaultEspressoStreamerConfig.LongPollInterval, "how long the espresso submission loop waits for newly queued messages while no transaction is in flight, instead of polling on the espresso-txns-polling-interval (0 = disabled)")
+}
+
+func (c *EspressoStreamerConfig) Validate() error {
+	if _, err := espressoDeadlineFallback(c); err != nil {
+		return err
+	}
+	if _, err := espressoSubmissionOrder(c); err != nil {
+		return err
+	}
+	if err := c.HeaderVerification.Validate(); err != nil {
+		return err
+	}
+	if err := c.Fees.Validate(); err != nil {
+		return err
+	}
+	if err := c.Compression.Validate(); err != nil {
+		return err
+	}
+	if err := c.ClientRetry.Validate(); err != nil {
+		return err
+	}
+	if err := c.AdaptivePolling.Validate(); err != nil {
+		return err
+	}
+	if err := c.Derivation.Validate(); err != nil {
+		return err
+	}
+	if c.Derivation.Enable && c.Attestation.Scheme == EspressoAttestationSchemeNone {
+		return errors.New("the espresso derivation requires an attestation scheme to tell the sequencer's payloads apart")
+	}
+	if err := c.Attestation.Validate(); err != nil {
+		return err
+	}
+	if _, err := parseEspressoHeaderVersion(c.MinHeaderVersion); err != nil {
+		return err
+	}
+	if c.NamespaceScanInterval > 0 && c.NamespaceScanMaxBlocks == 0 {
+		return errors.New("espresso namespace-scan-max-blocks must be positive while the namespace scan is enabled")
+	}
+	if c.ResubmissionTimeout > 0 && c.MaxResubmissions == 0 {
+		return errors.New("espresso resubmission-timeout is set but max-resubmissions is 0, set resubmission-timeout to 0 to disable resubmissions")
+	}
+	if c.SubmissionDedupWindow > 0 && c.ResubmissionTimeout > 0 && c.SubmissionDedupWindow >= c.ResubmissionTimeout {
+		return fmt.Errorf("espresso submission-dedup-window %v must be shorter than the resubmission-timeout %v, or resubmissions are skipped as duplicates", c.SubmissionDedupWindow, c.ResubmissionTimeout)
+	}
+	return nil
 }
 
 type TransactionStreamerConfigFetcher func() *TransactionStreamerConfig
@@ -105,25 +424,118 @@ type TransactionStreamerConfigFetcher func() *TransactionStreamerConfig
 var DefaultTransactionStreamerConfig = TransactionStreamerConfig{
 	MaxBroadcasterQueueSize: 50_000,
 	MaxReorgResequenceDepth: 1024,
+	ReorgResequencePolicy:   string(ReorgResequenceAll),
 	ExecuteMessageLoopDelay: time.Millisecond * 100,
+	ExecuteBatchThreshold:   1000,
+	ExecuteBatchSize:        64,
 	QuoteFile:               "",
 	UserDataAttestationFile: "",
+	ReorgHistorySize:        1000,
+	ReadCacheMessages:       0,
+	ReadCacheSlotSize:       4096,
+	RecentMessageCacheSize:  256,
+	Retention:               DefaultMessageRetentionConfig,
+	Archive:                 DefaultMessageArchiveConfig,
+	ExecutionPipeline:       DefaultExecutionPipelineConfig,
+	Watchdog:                DefaultStreamerWatchdogConfig,
+	LoadShedding:            DefaultStreamerLoadSheddingConfig,
+	ConsistencyCheck:        DefaultStreamerConsistencyCheckConfig,
+	FeedBacklog:             DefaultFeedBacklogConfig,
+	FeedSignature:           DefaultFeedSignatureConfig,
+	SequencerGroupCommit:    DefaultSequencerGroupCommitConfig,
+	MessageStream:           DefaultMessageStreamServerConfig,
+	Espresso:                DefaultEspressoStreamerConfig,
 }
 
 var TestTransactionStreamerConfig = TransactionStreamerConfig{
 	MaxBroadcasterQueueSize: 10_000,
 	MaxReorgResequenceDepth: 128 * 1024,
 	ExecuteMessageLoopDelay: time.Millisecond,
+	ReorgHistorySize:        1000,
+	RecentMessageCacheSize:  256,
 }
 
 func TransactionStreamerConfigAddOptions(prefix string, f *flag.FlagSet) {
-	f.Int(prefix+".max-broadcaster-queue-size", DefaultTransactionStreamerConfig.MaxBroadcasterQueueSize, "maximum cache of pending broadcaster messages")
+	f.Int(prefix+".max-broadcaster-queue-size", DefaultTransactionStreamerConfig.MaxBroadcasterQueueSize, "maximum number of pending broadcaster messages kept in memory, further messages are spilled to the database until the queue is drained (0 = unlimited)")
 	f.Int64(prefix+".max-reorg-resequence-depth", DefaultTransactionStreamerConfig.MaxReorgResequenceDepth, "maximum number of messages to attempt to resequence on reorg (0 = never resequence, -1 = always resequence)")
+	f.String(prefix+".reorg-resequence-policy", DefaultTransactionStreamerConfig.ReorgResequencePolicy, "which messages removed by a reorg are sequenced again: \"resequence-all\", \"resequence-delayed-only\" to drop the messages that didn't come from the delayed inbox, or \"drop-all\"")
 	f.Duration(prefix+".execute-message-loop-delay", DefaultTransactionStreamerConfig.ExecuteMessageLoopDelay, "delay when polling calls to execute messages")
+	f.Uint64(prefix+".execute-batch-threshold", DefaultTransactionStreamerConfig.ExecuteBatchThreshold, "number of messages the execution engine is behind above which messages are executed in batches, sharing the work of the engine between them (0 = always execute one message at a time)")
+	f.Uint64(prefix+".execute-batch-size", DefaultTransactionStreamerConfig.ExecuteBatchSize, "maximum number of messages executed in a batch while catching up")
 	f.String(prefix+".user-data-attestation-file", DefaultTransactionStreamerConfig.UserDataAttestationFile, "specifies the file containing the user data attestation")
 	f.String(prefix+".quote-file", DefaultTransactionStreamerConfig.QuoteFile, "specifies the file containing the quote")
+	f.Uint64(prefix+".reorg-history-size", DefaultTransactionStreamerConfig.ReorgHistorySize, "maximum number of past reorgs to keep in the database (0 = don't keep history)")
+	f.Uint64(prefix+".read-cache-messages", DefaultTransactionStreamerConfig.ReadCacheMessages, "number of most recent messages kept in a memory mapped read cache, for chains with very high message throughput (0 = disabled)")
+	f.Uint64(prefix+".read-cache-slot-size", DefaultTransactionStreamerConfig.ReadCacheSlotSize, "size in bytes of a message read cache slot, larger messages are read from the database")
+	f.Uint64(prefix+".recent-message-cache-size", DefaultTransactionStreamerConfig.RecentMessageCacheSize, "number of most recently written or read messages kept decoded in memory, so that they're executed without being read back from the database (0 = disabled)")
+	f.Bool(prefix+".message-telemetry", DefaultTransactionStreamerConfig.MessageTelemetry, "store the time each message was sequenced, submitted to espresso, finalized by hotshot, executed and posted in a batch, to query the latencies between these stages over message ranges")
+	f.Uint64(prefix+".message-chain-interval", DefaultTransactionStreamerConfig.MessageChainInterval, "number of messages between the stored accumulators of a rolling hash over the message history, which are sent over the feed so that replicas can check they hold the same history; must be the same on the sequencer and its replicas (0 = disabled)")
+	MessageRetentionConfigAddOptions(prefix+".retention", f)
+	MessageArchiveConfigAddOptions(prefix+".archive", f)
+	ExecutionPipelineConfigAddOptions(prefix+".execution-pipeline", f)
+	StreamerWatchdogConfigAddOptions(prefix+".watchdog", f)
+	StreamerLoadSheddingConfigAddOptions(prefix+".load-shedding", f)
+	StreamerConsistencyCheckConfigAddOptions(prefix+".consistency-check", f)
+	FeedBacklogConfigAddOptions(prefix+".feed-backlog", f)
+	FeedSignatureConfigAddOptions(prefix+".feed-signature", f)
+	SequencerGroupCommitConfigAddOptions(prefix+".sequencer-group-commit", f)
+	MessageStreamServerConfigAddOptions(prefix+".message-stream", f)
+	f.String(prefix+".kill-switch-owner", DefaultTransactionStreamerConfig.KillSwitchOwner, "address of the chain owner allowed to sign kill switch messages, which pause sequencing and espresso submission from a position on every node of the chain (empty = kill switch messages are ignored)")
+	EspressoStreamerConfigAddOptions(prefix+".espresso", f)
+}
+
+// Validate checks the transaction streamer config, including the combinations of settings that are inconsistent
+func (c *TransactionStreamerConfig) Validate() error {
+	if c.MaxBroadcasterQueueSize < 0 {
+		return fmt.Errorf("max-broadcaster-queue-size %d must not be negative", c.MaxBroadcasterQueueSize)
+	}
+	if c.MaxReorgResequenceDepth < -1 {
+		return fmt.Errorf("max-reorg-resequence-depth %d is invalid, use -1 to always resequence", c.MaxReorgResequenceDepth)
+	}
+	if _, err := parseReorgResequencePolicy(c.ReorgResequencePolicy); err != nil {
+		return err
+	}
+	if c.ReadCacheMessages > 0 && c.ReadCacheSlotSize <= messageReadCacheSlotHeader {
+		return fmt.Errorf("message read cache slot size %d is too small, it must be larger than %d bytes", c.ReadCacheSlotSize, messageReadCacheSlotHeader)
+	}
+	if c.ExecuteBatchThreshold > 0 && c.ExecuteBatchSize == 0 {
+		return errors.New("execute-batch-size must be positive while execute-batch-threshold is set")
+	}
+	if err := c.Archive.Validate(); err != nil {
+		return err
+	}
+	if err := c.ExecutionPipeline.Validate(); err != nil {
+		return err
+	}
+	if err := c.Watchdog.Validate(); err != nil {
+		return err
+	}
+	if err := c.ConsistencyCheck.Validate(); err != nil {
+		return err
+	}
+	if err := c.FeedBacklog.Validate(); err != nil {
+		return err
+	}
+	if err := c.FeedSignature.Validate(); err != nil {
+		return err
+	}
+	if err := c.MessageStream.Validate(); err != nil {
+		return err
+	}
+	if err := c.SequencerGroupCommit.Validate(); err != nil {
+		return err
+	}
+	if c.KillSwitchOwner != "" && !common.IsHexAddress(c.KillSwitchOwner) {
+		return fmt.Errorf("kill-switch-owner %q is not a valid address", c.KillSwitchOwner)
+	}
+	if err := c.Espresso.Validate(); err != nil {
+		return err
+	}
+	return nil
 }
 
+// NewTransactionStreamer creates the TransactionStreamer of a full node.
+// See NewTransactionStreamerWithOptions to create one with only some of its dependencies.
 func NewTransactionStreamer(
 	db ethdb.Database,
 	chainConfig *params.ChainConfig,
@@ -132,22 +544,78 @@ func NewTransactionStreamer(
 	fatalErrChan chan<- error,
 	config TransactionStreamerConfigFetcher,
 	snapSyncConfig *SnapSyncConfig,
+) (*TransactionStreamer, error) {
+	return newTransactionStreamer(db, chainConfig, exec, broadcastServer, fatalErrChan, config, snapSyncConfig, false)
+}
+
+func newTransactionStreamer(
+	db ethdb.Database,
+	chainConfig *params.ChainConfig,
+	exec execution.ExecutionSequencer,
+	broadcastServer *broadcaster.Broadcaster,
+	fatalErrChan chan<- error,
+	config TransactionStreamerConfigFetcher,
+	snapSyncConfig *SnapSyncConfig,
+	readOnly bool,
 ) (*TransactionStreamer, error) {
 	streamer := &TransactionStreamer{
-		exec:               exec,
-		chainConfig:        chainConfig,
-		db:                 db,
-		newMessageNotifier: make(chan struct{}, 1),
-		broadcastServer:    broadcastServer,
-		fatalErrChan:       fatalErrChan,
-		config:             config,
-		snapSyncConfig:     snapSyncConfig,
+		exec:                   exec,
+		chainConfig:            chainConfig,
+		db:                     db,
+		newMessageNotifier:     make(chan struct{}, 1),
+		newSovereignTxNotifier: make(chan struct{}, 1),
+		broadcastServer:        broadcastServer,
+		fatalErrChan:           fatalErrChan,
+		config:                 config,
+		snapSyncConfig:         snapSyncConfig,
+		feedLatency:            newFeedLatencyTracker(),
+		espressoBlockCache:     newEspressoBlockCache(espressoBlockCacheSize),
+		espressoSwitchSlot:     make(chan struct{}, 1),
+		recentMessages:         newRecentMessageCache(config().RecentMessageCacheSize),
+		executionPipeline:      newExecutionPipeline(),
+		messageComparators:     []MessageComparator{batchGasCostComparator{}},
+		feedMessageChain:       containers.NewLruCache[arbutil.MessageIndex, common.Hash](feedMessageChainCacheSize),
+		readOnly:               readOnly,
+		espressoDerivation:     config().Espresso.Derivation.Enable,
+	}
+	if broadcastServer != nil {
+		broadcastServer.AddFilter(broadcaster.BroadcastFilterFunc(streamer.attachMessageChain))
 	}
 
-	err := streamer.cleanupInconsistentState()
+	var err error
+	// The database is cleaned up and migrated by the node writing to it
+	if !readOnly {
+		err = streamer.cleanupInconsistentState()
+		if err != nil {
+			return nil, err
+		}
+		err = streamer.replayEspressoStateJournal()
+		if err != nil {
+			return nil, err
+		}
+		err = streamer.migrateEspressoPendingTxnsPos()
+		if err != nil {
+			return nil, err
+		}
+	}
+	streamer.espressoHeaderVerifier, err = newEspressoHeaderVerifier(&config().Espresso.HeaderVerification)
 	if err != nil {
 		return nil, err
 	}
+	if err := config().Validate(); err != nil {
+		return nil, err
+	}
+	streamer.messageArchive, err = newMessageArchive(&config().Archive)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the message archive: %w", err)
+	}
+	streamer.messageArchiveCache = containers.NewLruCache[arbutil.MessageIndex, []byte](config().Archive.CacheSize)
+	if cacheConfig := config(); cacheConfig.ReadCacheMessages > 0 {
+		streamer.messageReadCache, err = newMessageReadCache(cacheConfig.ReadCacheMessages, cacheConfig.ReadCacheSlotSize)
+		if err != nil {
+			return nil, fmt.Errorf("failed to map the message read cache: %w", err)
+		}
+	}
 	return streamer, nil
 }
 
@@ -162,6 +630,9 @@ const (
 	FailedToGetMsgResultFromDB = "Reading message result remotely."
 )
 
+// Counts the message results that weren't stored by the streamer and were read from the execution engine
+var remoteMessageResultCounter = metrics.NewRegisteredCounter("arb/streamer/result/remote", nil)
+
 // Encodes an uint64 as bytes in a lexically sortable manner for database iteration.
 // Generally this is only used for database keys, which need sorted.
 // A shorter RLP encoding is usually used for database values.
@@ -206,6 +677,11 @@ func (s *TransactionStreamer) ChainConfig() *params.ChainConfig {
 	return s.chainConfig
 }
 
+// ReadOnly returns whether the streamer was opened with WithReadOnly
+func (s *TransactionStreamer) ReadOnly() bool {
+	return s.readOnly
+}
+
 func (s *TransactionStreamer) cleanupInconsistentState() error {
 	// If it doesn't exist yet, set the message count to 0
 	hasMessageCount, err := s.db.Has(messageCountKey)
@@ -227,16 +703,25 @@ func (s *TransactionStreamer) ReorgTo(count arbutil.MessageIndex) error {
 }
 
 func (s *TransactionStreamer) ReorgToAndEndBatch(batch ethdb.Batch, count arbutil.MessageIndex) error {
-	s.insertionMutex.Lock()
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	if err := s.lockInsertion(); err != nil {
+		return err
+	}
 	defer s.insertionMutex.Unlock()
+	s.espressoTxnsStateInsertionMutex.Lock()
 	err := s.reorg(batch, count, nil)
-	if err != nil {
-		return err
+	if err == nil {
+		err = batch.Write()
 	}
-	err = batch.Write()
+	s.espressoTxnsStateInsertionMutex.Unlock()
 	if err != nil {
 		return err
 	}
+	s.resetEspressoJustifiedWatermark(count)
+	s.resetMessageChain(count)
+	s.newMessageSignal.notify()
 	return nil
 }
 
@@ -291,40 +776,14 @@ func deleteFromRange(ctx context.Context, db ethdb.Database, prefix []byte, star
 	return prunedKeysRange, nil
 }
 
-// The insertion mutex must be held. This acquires the reorg mutex.
-// Note: oldMessages will be empty if reorgHook is nil
-func (s *TransactionStreamer) reorg(batch ethdb.Batch, count arbutil.MessageIndex, newMessages []arbostypes.MessageWithMetadataAndBlockHash) error {
-	if count == 0 {
-		return errors.New("cannot reorg out init message")
-	}
-	lastDelayedSeqNum, err := s.getPrevPrevDelayedRead(count)
-	if err != nil {
-		return err
+// selectMessagesToResequence returns the messages removed by a reorg that are sequenced again under policy.
+// Delayed messages are only resequenced in order, starting after lastDelayedSeqNum.
+func (s *TransactionStreamer) selectMessagesToResequence(ctx context.Context, lastDelayedSeqNum uint64, candidates []*arbostypes.MessageWithMetadata, policy ReorgResequencePolicy) []*arbostypes.MessageWithMetadata {
+	if policy == ReorgDropAll {
+		return nil
 	}
 	var oldMessages []*arbostypes.MessageWithMetadata
-
-	targetMsgCount, err := s.GetMessageCount()
-	if err != nil {
-		return err
-	}
-	config := s.config()
-	// #nosec G115
-	maxResequenceMsgCount := count + arbutil.MessageIndex(config.MaxReorgResequenceDepth)
-	if config.MaxReorgResequenceDepth >= 0 && maxResequenceMsgCount < targetMsgCount {
-		log.Error(
-			"unable to re-sequence all old messages because there are too many",
-			"reorgingToCount", count,
-			"removingMessages", targetMsgCount-count,
-			"maxReorgResequenceDepth", config.MaxReorgResequenceDepth,
-		)
-		targetMsgCount = maxResequenceMsgCount
-	}
-	for i := count; i < targetMsgCount; i++ {
-		oldMessage, err := s.GetMessage(i)
-		if err != nil {
-			log.Error("unable to lookup old message for re-sequencing", "position", i, "err", err)
-			break
-		}
+	for _, oldMessage := range candidates {
 
 		if oldMessage.Message == nil || oldMessage.Message.Header == nil {
 			continue
@@ -332,6 +791,10 @@ func (s *TransactionStreamer) reorg(batch ethdb.Batch, count arbutil.MessageInde
 
 		header := oldMessage.Message.Header
 
+		if header.RequestId == nil && policy == ReorgResequenceDelayedOnly {
+			continue
+		}
+
 		if header.RequestId != nil {
 			// This is a delayed message
 			delayedSeqNum := header.RequestId.Big().Uint64()
@@ -354,7 +817,7 @@ func (s *TransactionStreamer) reorg(batch ethdb.Batch, count arbutil.MessageInde
 					continue
 				}
 				msgBlockNum := new(big.Int).SetUint64(oldMessage.Message.Header.BlockNumber)
-				delayedInBlock, err := s.delayedBridge.LookupMessagesInRange(s.GetContext(), msgBlockNum, msgBlockNum, nil)
+				delayedInBlock, err := s.delayedBridge.LookupMessagesInRange(ctx, msgBlockNum, msgBlockNum, nil)
 				if err != nil {
 					log.Error("reorg-resequence: failed to serialize old delayed message from database", "err", err)
 					continue
@@ -379,6 +842,54 @@ func (s *TransactionStreamer) reorg(batch ethdb.Batch, count arbutil.MessageInde
 
 		oldMessages = append(oldMessages, oldMessage)
 	}
+	return oldMessages
+}
+
+// The insertion mutex must be held, and the espresso state mutex until the batch is written. This acquires the reorg mutex.
+// Note: oldMessages will be empty if reorgHook is nil
+func (s *TransactionStreamer) reorg(batch ethdb.Batch, count arbutil.MessageIndex, newMessages []arbostypes.MessageWithMetadataAndBlockHash) error {
+	if count == 0 {
+		return errors.New("cannot reorg out init message")
+	}
+	lastDelayedSeqNum, err := s.getPrevPrevDelayedRead(count)
+	if err != nil {
+		return err
+	}
+	var oldMessages []*arbostypes.MessageWithMetadata
+
+	targetMsgCount, err := s.GetMessageCount()
+	if err != nil {
+		return err
+	}
+	oldMsgCount := targetMsgCount
+	config := s.config()
+	// #nosec G115
+	maxResequenceMsgCount := count + arbutil.MessageIndex(config.MaxReorgResequenceDepth)
+	if config.MaxReorgResequenceDepth >= 0 && maxResequenceMsgCount < targetMsgCount {
+		log.Error(
+			"unable to re-sequence all old messages because there are too many",
+			"reorgingToCount", count,
+			"removingMessages", targetMsgCount-count,
+			"maxReorgResequenceDepth", config.MaxReorgResequenceDepth,
+		)
+		targetMsgCount = maxResequenceMsgCount
+	}
+	var oldMessagesToResequence []*arbostypes.MessageWithMetadata
+	if count < targetMsgCount && (s.reorgHook != nil || s.configuredReorgResequencePolicy() != ReorgDropAll) {
+		oldMessagesToResequence, err = s.GetMessages(count, targetMsgCount)
+		if err != nil {
+			log.Error("unable to lookup old messages for re-sequencing", "from", count, "to", targetMsgCount, "err", err)
+		}
+	}
+	policy := s.reorgResequencePolicy(count, oldMessagesToResequence)
+	oldMessages = s.selectMessagesToResequence(s.GetContext(), lastDelayedSeqNum, oldMessagesToResequence, policy)
+	if policy != ReorgResequenceAll && len(oldMessagesToResequence) > 0 {
+		log.Warn("dropping reorged messages instead of resequencing them", "policy", policy, "reorgingToCount", count, "resequencing", len(oldMessages), "dropping", len(oldMessagesToResequence)-len(oldMessages))
+	}
+
+	if s.exec == nil {
+		return ErrNoExecution
+	}
 
 	s.reorgMutex.Lock()
 	defer s.reorgMutex.Unlock()
@@ -412,10 +923,41 @@ func (s *TransactionStreamer) reorg(batch ethdb.Batch, count arbutil.MessageInde
 	if err != nil {
 		return err
 	}
+	s.messageReadCache.truncate(count)
+	s.recentMessages.truncate(count)
+	s.executionPipeline.reset()
+	err = s.deleteMessageLookup(s.GetContext(), batch, count, math.MaxUint64)
+	if err != nil {
+		return err
+	}
 	err = deleteStartingAt(s.db, batch, messagePrefix, uint64ToKey(uint64(count)))
 	if err != nil {
 		return err
 	}
+	err = deleteStartingAt(s.db, batch, espressoSubmissionPrefix, uint64ToKey(uint64(count)))
+	if err != nil {
+		return err
+	}
+	err = deleteStartingAt(s.db, batch, prunedMessageDigestPrefix, uint64ToKey(uint64(count)))
+	if err != nil {
+		return err
+	}
+	err = deleteStartingAt(s.db, batch, escapeHatchPrefix, uint64ToKey(uint64(count)))
+	if err != nil {
+		return err
+	}
+	err = deleteStartingAt(s.db, batch, espressoDeadlinePrefix, uint64ToKey(uint64(count)))
+	if err != nil {
+		return err
+	}
+	err = deleteStartingAt(s.db, batch, messageTelemetryPrefix, uint64ToKey(uint64(count)))
+	if err != nil {
+		return err
+	}
+	err = s.dropReorgedEspressoState(batch, count)
+	if err != nil {
+		return err
+	}
 
 	for i := 0; i < len(messagesResults); i++ {
 		// #nosec G115
@@ -426,6 +968,15 @@ func (s *TransactionStreamer) reorg(batch ethdb.Batch, count arbutil.MessageInde
 		}
 	}
 
+	var depth uint64
+	if oldMsgCount > count {
+		depth = uint64(oldMsgCount - count)
+	}
+	err = s.recordReorg(batch, ReorgSourceConfirmed, count, depth, uint64(len(oldMessages)))
+	if err != nil {
+		return err
+	}
+
 	return setMessageCount(batch, count)
 }
 
@@ -452,13 +1003,26 @@ func dbKey(prefix []byte, pos uint64) []byte {
 
 // Note: if changed to acquire the mutex, some internal users may need to be updated to a non-locking version.
 func (s *TransactionStreamer) GetMessage(seqNum arbutil.MessageIndex) (*arbostypes.MessageWithMetadata, error) {
-	key := dbKey(messagePrefix, uint64(seqNum))
-	data, err := s.db.Get(key)
-	if err != nil {
-		return nil, err
+	if cached, ok := s.recentMessages.get(seqNum); ok {
+		return &cached.MessageWithMeta, nil
+	}
+	var err error
+	data, ok := s.messageReadCache.get(seqNum)
+	if !ok {
+		data, err = s.db.Get(dbKey(messagePrefix, uint64(seqNum)))
+		if err != nil && s.messageArchive != nil && dbutil.IsErrNotFound(err) {
+			data, err = s.getArchivedMessage(seqNum, err)
+		}
+		if err != nil {
+			return nil, err
+		}
 	}
+	return s.decodeMessage(data)
+}
+
+func (s *TransactionStreamer) decodeMessage(data []byte) (*arbostypes.MessageWithMetadata, error) {
 	var message arbostypes.MessageWithMetadata
-	err = rlp.DecodeBytes(data, &message)
+	err := rlp.DecodeBytes(data, &message)
 	if err != nil {
 		return nil, err
 	}
@@ -468,6 +1032,9 @@ func (s *TransactionStreamer) GetMessage(seqNum arbutil.MessageIndex) (*arbostyp
 		if err != nil {
 			return nil, err
 		}
+		if s.inboxReader == nil {
+			return nil, errors.New("batch gas cost unknown without an inbox reader")
+		}
 		data, _, err := s.inboxReader.GetSequencerMessageBytes(ctx, batchNum)
 		return data, err
 	})
@@ -479,33 +1046,104 @@ func (s *TransactionStreamer) GetMessage(seqNum arbutil.MessageIndex) (*arbostyp
 }
 
 func (s *TransactionStreamer) getMessageWithMetadataAndBlockHash(seqNum arbutil.MessageIndex) (*arbostypes.MessageWithMetadataAndBlockHash, error) {
+	if cached, ok := s.recentMessages.get(seqNum); ok {
+		blockHash, err := s.trustedFeedBlockHash(seqNum, cached.BlockHash)
+		if err != nil {
+			return nil, err
+		}
+		cached.BlockHash = blockHash
+		return cached, nil
+	}
 	msg, err := s.GetMessage(seqNum)
 	if err != nil {
 		return nil, err
 	}
 
-	// Get block hash.
-	// To keep it backwards compatible, since it is possible that a message related
-	// to a sequence number exists in the database, but the block hash doesn't.
-	key := dbKey(blockHashInputFeedPrefix, uint64(seqNum))
-	var blockHash *common.Hash
-	data, err := s.db.Get(key)
-	if err == nil {
-		var blockHashDBVal blockHashDBValue
-		err = rlp.DecodeBytes(data, &blockHashDBVal)
+	blockHash, err := s.getStoredBlockHash(seqNum)
+	if err != nil {
+		return nil, err
+	}
+	blockHash, err = s.trustedFeedBlockHash(seqNum, blockHash)
+	if err != nil {
+		return nil, err
+	}
+	return &arbostypes.MessageWithMetadataAndBlockHash{
+		MessageWithMeta: *msg,
+		BlockHash:       blockHash,
+	}, nil
+}
+
+// GetMessages returns the messages in [from, to), reading them with a single database iterator instead of
+// a lookup per message
+func (s *TransactionStreamer) GetMessages(from arbutil.MessageIndex, to arbutil.MessageIndex) ([]*arbostypes.MessageWithMetadata, error) {
+	if to < from {
+		return nil, fmt.Errorf("invalid message range [%d, %d)", from, to)
+	}
+	messages := make([]*arbostypes.MessageWithMetadata, 0, to-from)
+	iter := s.db.NewIterator(messagePrefix, uint64ToKey(uint64(from)))
+	defer iter.Release()
+	pos := from
+	for pos < to && iter.Next() {
+		if binary.BigEndian.Uint64(bytes.TrimPrefix(iter.Key(), messagePrefix)) != uint64(pos) {
+			break
+		}
+		message, err := s.decodeMessage(iter.Value())
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("failed to decode message %d: %w", pos, err)
 		}
-		blockHash = blockHashDBVal.BlockHash
-	} else if !dbutil.IsErrNotFound(err) {
+		messages = append(messages, message)
+		pos++
+	}
+	if err := iter.Error(); err != nil {
 		return nil, err
 	}
+	if pos < to {
+		// Return the database's not found error for the missing message
+		if _, err := s.db.Get(dbKey(messagePrefix, uint64(pos))); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("message %d missing from the database iterator", pos)
+	}
+	return messages, nil
+}
 
-	msgWithBlockHash := arbostypes.MessageWithMetadataAndBlockHash{
-		MessageWithMeta: *msg,
-		BlockHash:       blockHash,
+// GetMessagesWithBlockHash returns the messages in [from, to) with the block hashes received through the feed
+func (s *TransactionStreamer) GetMessagesWithBlockHash(from arbutil.MessageIndex, to arbutil.MessageIndex) ([]*arbostypes.MessageWithMetadataAndBlockHash, error) {
+	messages, err := s.GetMessages(from, to)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*arbostypes.MessageWithMetadataAndBlockHash, 0, len(messages))
+	iter := s.db.NewIterator(blockHashInputFeedPrefix, uint64ToKey(uint64(from)))
+	defer iter.Release()
+	hasNext := iter.Next()
+	for i, message := range messages {
+		// #nosec G115
+		pos := from + arbutil.MessageIndex(i)
+		for hasNext && binary.BigEndian.Uint64(bytes.TrimPrefix(iter.Key(), blockHashInputFeedPrefix)) < uint64(pos) {
+			hasNext = iter.Next()
+		}
+		var blockHash *common.Hash
+		if hasNext && binary.BigEndian.Uint64(bytes.TrimPrefix(iter.Key(), blockHashInputFeedPrefix)) == uint64(pos) {
+			var blockHashDBVal blockHashDBValue
+			if err := rlp.DecodeBytes(iter.Value(), &blockHashDBVal); err != nil {
+				return nil, err
+			}
+			blockHash = blockHashDBVal.BlockHash
+		}
+		blockHash, err = s.trustedFeedBlockHash(pos, blockHash)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, &arbostypes.MessageWithMetadataAndBlockHash{
+			MessageWithMeta: *message,
+			BlockHash:       blockHash,
+		})
 	}
-	return &msgWithBlockHash, nil
+	if err := iter.Error(); err != nil {
+		return nil, err
+	}
+	return result, nil
 }
 
 // Note: if changed to acquire the mutex, some internal users may need to be updated to a non-locking version.
@@ -527,6 +1165,9 @@ func (s *TransactionStreamer) GetProcessedMessageCount() (arbutil.MessageIndex,
 	if err != nil {
 		return 0, err
 	}
+	if s.exec == nil {
+		return 0, ErrNoExecution
+	}
 	digestedHead, err := s.exec.HeadMessageNumber()
 	if err != nil {
 		return 0, err
@@ -553,10 +1194,17 @@ func (s *TransactionStreamer) FeedPendingMessageCount() arbutil.MessageIndex {
 	if pos == 0 {
 		return 0
 	}
-	return arbutil.MessageIndex(pos + uint64(len(s.broadcasterQueuedMessages)))
+	// #nosec G115
+	return arbutil.MessageIndex(pos + uint64(s.broadcasterQueueLen()))
 }
 
 func (s *TransactionStreamer) AddBroadcastMessages(feedMessages []*m.BroadcastFeedMessage) error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	if s.espressoDerivation {
+		return ErrEspressoDerivation
+	}
 	if len(feedMessages) == 0 {
 		return nil
 	}
@@ -577,8 +1225,22 @@ func (s *TransactionStreamer) AddBroadcastMessages(feedMessages []*m.BroadcastFe
 		messages = append(messages, msgWithBlockHash)
 		broadcastAfterPos++
 	}
+	// Verified before taking the lock, as they read the parent chain and the light client
+	if err := s.verifyFeedSignatures(feedMessages); err != nil {
+		return err
+	}
+	if err := s.verifyFeedEspressoFinality(feedMessages); err != nil {
+		return err
+	}
+	for _, feedMessage := range feedMessages {
+		if feedMessage.MessageChain != nil {
+			s.recordFeedMessageChain(feedMessage.SequenceNumber+1, *feedMessage.MessageChain)
+		}
+	}
 
-	s.insertionMutex.Lock()
+	if err := s.lockInsertion(); err != nil {
+		return err
+	}
 	defer s.insertionMutex.Unlock()
 
 	var feedReorg bool
@@ -592,6 +1254,11 @@ func (s *TransactionStreamer) AddBroadcastMessages(feedMessages []*m.BroadcastFe
 	}
 	messages = messages[dups:]
 	broadcastStartPos += arbutil.MessageIndex(dups)
+	for _, feedMessage := range feedMessages[dups:] {
+		if feedMessage.SequencedAt != nil {
+			s.feedLatency.received(feedMessage.SequenceNumber, *feedMessage.SequencedAt)
+		}
+	}
 	if oldMsg != nil {
 		s.logReorg(broadcastStartPos, oldMsg, &messages[0].MessageWithMeta, false)
 	}
@@ -602,44 +1269,44 @@ func (s *TransactionStreamer) AddBroadcastMessages(feedMessages []*m.BroadcastFe
 
 	if len(s.broadcasterQueuedMessages) == 0 || (feedReorg && !s.broadcasterQueuedMessagesActiveReorg) {
 		// Empty cache or feed different from database, save current feed messages until confirmed L1 messages catch up.
-		s.broadcasterQueuedMessages = messages
-		s.broadcasterQueuedMessagesPos.Store(uint64(broadcastStartPos))
-		s.broadcasterQueuedMessagesActiveReorg = feedReorg
+		err = s.resetBroadcasterQueue(broadcastStartPos, messages, feedReorg)
 	} else {
 		broadcasterQueuedMessagesPos := arbutil.MessageIndex(s.broadcasterQueuedMessagesPos.Load())
+		// #nosec G115
+		broadcasterQueueEnd := broadcasterQueuedMessagesPos + arbutil.MessageIndex(s.broadcasterQueueLen())
 		if broadcasterQueuedMessagesPos >= broadcastStartPos {
 			// Feed messages older than cache
-			s.broadcasterQueuedMessages = messages
-			s.broadcasterQueuedMessagesPos.Store(uint64(broadcastStartPos))
-			s.broadcasterQueuedMessagesActiveReorg = feedReorg
-		} else if broadcasterQueuedMessagesPos+arbutil.MessageIndex(len(s.broadcasterQueuedMessages)) == broadcastStartPos {
-			// Feed messages can be added directly to end of cache
-			maxQueueSize := s.config().MaxBroadcasterQueueSize
-			if maxQueueSize == 0 || len(s.broadcasterQueuedMessages) <= maxQueueSize {
-				s.broadcasterQueuedMessages = append(s.broadcasterQueuedMessages, messages...)
-			}
+			err = s.resetBroadcasterQueue(broadcastStartPos, messages, feedReorg)
+		} else if broadcasterQueueEnd == broadcastStartPos {
+			// Feed messages can be added directly to end of cache, or spilled if it's full
+			err = s.appendBroadcasterQueue(messages)
 			broadcastStartPos = broadcasterQueuedMessagesPos
 			// Do not change existing reorg state
 		} else {
-			if len(s.broadcasterQueuedMessages) > 0 {
-				log.Warn(
-					"broadcaster queue jumped positions",
-					"queuedMessages", len(s.broadcasterQueuedMessages),
-					"expectedNextPos", broadcasterQueuedMessagesPos+arbutil.MessageIndex(len(s.broadcasterQueuedMessages)),
-					"gotPos", broadcastStartPos,
-				)
-			}
-			s.broadcasterQueuedMessages = messages
-			s.broadcasterQueuedMessagesPos.Store(uint64(broadcastStartPos))
-			s.broadcasterQueuedMessagesActiveReorg = feedReorg
+			log.Warn(
+				"broadcaster queue jumped positions",
+				"queuedMessages", s.broadcasterQueueLen(),
+				"expectedNextPos", broadcasterQueueEnd,
+				"gotPos", broadcastStartPos,
+			)
+			err = s.resetBroadcasterQueue(broadcastStartPos, messages, feedReorg)
 		}
 	}
+	if err != nil {
+		return fmt.Errorf("error queueing broadcaster messages: %w", err)
+	}
 
 	if s.broadcasterQueuedMessagesActiveReorg || len(s.broadcasterQueuedMessages) == 0 {
 		// Broadcaster never triggered reorg or no messages to add
 		return nil
 	}
 
+	if s.loadShedding.active.Load() {
+		// Keep the messages queued, they're added once the pressure subsides or confirmed messages catch up
+		loadSheddingDeferredCounter.Inc(1)
+		return nil
+	}
+
 	if broadcastStartPos > 0 {
 		_, err := s.GetMessage(broadcastStartPos - 1)
 		if err != nil {
@@ -651,16 +1318,30 @@ func (s *TransactionStreamer) AddBroadcastMessages(feedMessages []*m.BroadcastFe
 		}
 	}
 
-	err = s.addMessagesAndEndBatchImpl(broadcastStartPos, false, nil, nil)
-	if err != nil {
-		return fmt.Errorf("error adding pending broadcaster messages: %w", err)
-	}
+	return s.addBroadcasterQueue(broadcastStartPos)
+}
 
-	return nil
+// addBroadcasterQueue adds the queued feed messages starting at pos, and the spilled ones loaded back into
+// memory after them. The caller must hold the insertionMutex.
+func (s *TransactionStreamer) addBroadcasterQueue(pos arbutil.MessageIndex) error {
+	for {
+		spilled := s.broadcasterQueueSpilled
+		err := s.addMessagesAndEndBatchImpl(pos, false, nil, nil)
+		if err != nil {
+			return fmt.Errorf("error adding pending broadcaster messages: %w", err)
+		}
+		if s.broadcasterQueueSpilled == spilled || s.broadcasterQueuedMessagesActiveReorg || len(s.broadcasterQueuedMessages) == 0 {
+			return nil
+		}
+		pos = arbutil.MessageIndex(s.broadcasterQueuedMessagesPos.Load())
+	}
 }
 
 // AddFakeInitMessage should only be used for testing or running a local dev node
 func (s *TransactionStreamer) AddFakeInitMessage() error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
 	chainConfigJson, err := json.Marshal(s.chainConfig)
 	if err != nil {
 		return fmt.Errorf("failed to serialize chain config: %w", err)
@@ -682,7 +1363,9 @@ func (s *TransactionStreamer) AddFakeInitMessage() error {
 
 // Used in redis tests
 func (s *TransactionStreamer) GetMessageCountSync(t *testing.T) (arbutil.MessageIndex, error) {
-	s.insertionMutex.Lock()
+	if err := s.lockInsertion(); err != nil {
+		return 0, err
+	}
 	defer s.insertionMutex.Unlock()
 	return s.GetMessageCount()
 }
@@ -695,6 +1378,9 @@ func endBatch(batch ethdb.Batch) error {
 }
 
 func (s *TransactionStreamer) AddMessagesAndEndBatch(pos arbutil.MessageIndex, messagesAreConfirmed bool, messages []arbostypes.MessageWithMetadata, batch ethdb.Batch) error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
 	messagesWithBlockHash := make([]arbostypes.MessageWithMetadataAndBlockHash, 0, len(messages))
 	for _, message := range messages {
 		messagesWithBlockHash = append(messagesWithBlockHash, arbostypes.MessageWithMetadataAndBlockHash{
@@ -704,7 +1390,9 @@ func (s *TransactionStreamer) AddMessagesAndEndBatch(pos arbutil.MessageIndex, m
 
 	if messagesAreConfirmed {
 		// Trim confirmed messages from l1pricedataCache
-		s.exec.MarkFeedStart(pos + arbutil.MessageIndex(len(messages)))
+		if s.exec != nil {
+			s.exec.MarkFeedStart(pos + arbutil.MessageIndex(len(messages)))
+		}
 		s.reorgMutex.RLock()
 		dups, _, _, err := s.countDuplicateMessages(pos, messagesWithBlockHash, &batch)
 		s.reorgMutex.RUnlock()
@@ -720,10 +1408,18 @@ func (s *TransactionStreamer) AddMessagesAndEndBatch(pos arbutil.MessageIndex, m
 		// 1: were previously in feed. We saved work
 		// 2: are new (syncing). We wasted very little work.
 	}
-	s.insertionMutex.Lock()
+	if err := s.lockInsertion(); err != nil {
+		return err
+	}
 	defer s.insertionMutex.Unlock()
 
-	return s.addMessagesAndEndBatchImpl(pos, messagesAreConfirmed, messagesWithBlockHash, batch)
+	spilled := s.broadcasterQueueSpilled
+	err := s.addMessagesAndEndBatchImpl(pos, messagesAreConfirmed, messagesWithBlockHash, batch)
+	if err != nil || s.broadcasterQueueSpilled == spilled || s.broadcasterQueuedMessagesActiveReorg || len(s.broadcasterQueuedMessages) == 0 || s.loadShedding.active.Load() {
+		return err
+	}
+	// Feed messages loaded back from the spill are added right away
+	return s.addBroadcasterQueue(arbutil.MessageIndex(s.broadcasterQueuedMessagesPos.Load()))
 }
 
 func (s *TransactionStreamer) getPrevPrevDelayedRead(pos arbutil.MessageIndex) (uint64, error) {
@@ -747,95 +1443,66 @@ func (s *TransactionStreamer) countDuplicateMessages(
 	messages []arbostypes.MessageWithMetadataAndBlockHash,
 	batch *ethdb.Batch,
 ) (uint64, bool, *arbostypes.MessageWithMetadata, error) {
-	var curMsg uint64
-	for {
-		if uint64(len(messages)) == curMsg {
-			break
-		}
-		key := dbKey(messagePrefix, uint64(pos))
-		hasMessage, err := s.db.Has(key)
-		if err != nil {
-			return 0, false, nil, err
-		}
-		if !hasMessage {
-			break
+	stored, err := s.readStoredMessages(pos, len(messages))
+	if err != nil {
+		return 0, false, nil, err
+	}
+	matches := matchEncodedMessages(stored, messages, duplicateCheckWorkers(len(stored)))
+	for curMsg := 0; curMsg < len(stored); curMsg, pos = curMsg+1, pos+1 {
+		if matches[curMsg].err != nil {
+			return 0, false, nil, matches[curMsg].err
 		}
-		haveMessage, err := s.db.Get(key)
-		if err != nil {
-			return 0, false, nil, err
+		if matches[curMsg].equal {
+			continue
 		}
+		// Current message does not exactly match message in database
 		nextMessage := messages[curMsg]
-		wantMessage, err := rlp.EncodeToBytes(nextMessage.MessageWithMeta)
-		if err != nil {
-			return 0, false, nil, err
-		}
-		if !bytes.Equal(haveMessage, wantMessage) {
-			// Current message does not exactly match message in database
-			var dbMessageParsed arbostypes.MessageWithMetadata
-
-			if err := rlp.DecodeBytes(haveMessage, &dbMessageParsed); err != nil {
-				log.Warn("TransactionStreamer: Reorg detected! (failed parsing db message)",
-					"pos", pos,
-					"err", err,
-				)
-				return curMsg, true, nil, nil
-			}
-			var duplicateMessage bool
-			if nextMessage.MessageWithMeta.Message != nil {
-				if dbMessageParsed.Message.BatchGasCost == nil || nextMessage.MessageWithMeta.Message.BatchGasCost == nil {
-					// Remove both of the batch gas costs and see if the messages still differ
-					nextMessageCopy := nextMessage.MessageWithMeta
-					nextMessageCopy.Message = new(arbostypes.L1IncomingMessage)
-					*nextMessageCopy.Message = *nextMessage.MessageWithMeta.Message
-					batchGasCostBkup := dbMessageParsed.Message.BatchGasCost
-					dbMessageParsed.Message.BatchGasCost = nil
-					nextMessageCopy.Message.BatchGasCost = nil
-					if reflect.DeepEqual(dbMessageParsed, nextMessageCopy) {
-						// Actually this isn't a reorg; only the batch gas costs differed
-						duplicateMessage = true
-						// If possible - update the message in the database to add the gas cost cache.
-						if batch != nil && nextMessage.MessageWithMeta.Message.BatchGasCost != nil {
-							if *batch == nil {
-								*batch = s.db.NewBatch()
-							}
-							if err := s.writeMessage(pos, nextMessage, *batch); err != nil {
-								return 0, false, nil, err
-							}
-						}
-					}
-					dbMessageParsed.Message.BatchGasCost = batchGasCostBkup
-				}
+		var dbMessageParsed arbostypes.MessageWithMetadata
+
+		if err := rlp.DecodeBytes(stored[curMsg], &dbMessageParsed); err != nil {
+			log.Warn("TransactionStreamer: Reorg detected! (failed parsing db message)",
+				"pos", pos,
+				"err", err,
+			)
+			return uint64(curMsg), true, nil, nil
+		}
+		duplicateMessage, update := s.isDuplicateMessage(pos, &dbMessageParsed, &nextMessage.MessageWithMeta)
+		if !duplicateMessage {
+			return uint64(curMsg), true, &dbMessageParsed, nil
+		}
+		// Actually this isn't a reorg. If possible - update the message in the database, e.g. to add the gas cost cache.
+		if update && batch != nil {
+			if *batch == nil {
+				*batch = s.db.NewBatch()
 			}
-
-			if !duplicateMessage {
-				return curMsg, true, &dbMessageParsed, nil
+			if err := s.writeMessage(pos, nextMessage, *batch); err != nil {
+				return 0, false, nil, err
 			}
+			// The batch isn't written yet, the updated message is read from the database once it is
+			s.recentMessages.drop(pos)
 		}
-
-		curMsg++
-		pos++
 	}
 
-	return curMsg, false, nil, nil
+	return uint64(len(stored)), false, nil, nil
 }
 
 func (s *TransactionStreamer) logReorg(pos arbutil.MessageIndex, dbMsg *arbostypes.MessageWithMetadata, newMsg *arbostypes.MessageWithMetadata, confirmed bool) {
-	sendLog := confirmed
-	if time.Now().After(s.nextAllowedFeedReorgLog) {
-		sendLog = true
-	}
-	if sendLog {
-		s.nextAllowedFeedReorgLog = time.Now().Add(time.Minute)
-		log.Warn("TransactionStreamer: Reorg detected!",
-			"confirmed", confirmed,
-			"pos", pos,
-			"got-delayed", newMsg.DelayedMessagesRead,
-			"got-header", newMsg.Message.Header,
-			"db-delayed", dbMsg.DelayedMessagesRead,
-			"db-header", dbMsg.Message.Header,
-		)
+	if !confirmed {
+		// Only the log is rate limited, every reorg detected on the feed is recorded in the history
+		s.recordFeedReorg(pos)
 	}
-
+	if !confirmed && time.Now().Before(s.nextAllowedFeedReorgLog) {
+		return
+	}
+	s.nextAllowedFeedReorgLog = time.Now().Add(time.Minute)
+	log.Warn("TransactionStreamer: Reorg detected!",
+		"confirmed", confirmed,
+		"pos", pos,
+		"got-delayed", newMsg.DelayedMessagesRead,
+		"got-header", newMsg.Message.Header,
+		"db-delayed", dbMsg.DelayedMessagesRead,
+		"db-header", dbMsg.Message.Header,
+	)
 }
 
 func (s *TransactionStreamer) addMessagesAndEndBatchImpl(messageStartPos arbutil.MessageIndex, messagesAreConfirmed bool, messages []arbostypes.MessageWithMetadataAndBlockHash, batch ethdb.Batch) error {
@@ -934,14 +1601,17 @@ func (s *TransactionStreamer) addMessagesAndEndBatchImpl(messageStartPos arbutil
 
 	if confirmedReorg {
 		reorgBatch := s.db.NewBatch()
+		s.espressoTxnsStateInsertionMutex.Lock()
 		err := s.reorg(reorgBatch, messageStartPos, messages)
-		if err != nil {
-			return err
+		if err == nil {
+			err = reorgBatch.Write()
 		}
-		err = reorgBatch.Write()
+		s.espressoTxnsStateInsertionMutex.Unlock()
 		if err != nil {
 			return err
 		}
+		s.resetEspressoJustifiedWatermark(messageStartPos)
+		s.resetMessageChain(messageStartPos)
 	}
 	if len(messages) == 0 {
 		return endBatch(batch)
@@ -958,6 +1628,19 @@ func (s *TransactionStreamer) addMessagesAndEndBatchImpl(messageStartPos arbutil
 			s.broadcasterQueuedMessages = s.broadcasterQueuedMessages[cacheClearLen:]
 			// #nosec G115
 			s.broadcasterQueuedMessagesPos.Store(uint64(broadcastStartPos) + uint64(cacheClearLen))
+		} else if s.broadcasterQueueSpilled > 0 {
+			// The messages in memory were all used, the spilled ones following them are loaded back
+			// #nosec G115
+			spillPos := broadcastStartPos + arbutil.MessageIndex(len(s.broadcasterQueuedMessages))
+			// #nosec G115
+			if err := s.refillBroadcasterQueue(spillPos, broadcastStartPos+arbutil.MessageIndex(cacheClearLen)); err != nil {
+				log.Error("failed to load spilled feed messages, dropping them", "pos", spillPos, "err", err)
+				s.broadcasterQueuedMessages = s.broadcasterQueuedMessages[:0]
+				s.broadcasterQueuedMessagesPos.Store(0)
+				if err := s.deleteBroadcasterQueueSpill(); err != nil {
+					log.Error("failed to delete spilled feed messages", "err", err)
+				}
+			}
 		} else {
 			s.broadcasterQueuedMessages = s.broadcasterQueuedMessages[:0]
 			s.broadcasterQueuedMessagesPos.Store(0)
@@ -970,6 +1653,9 @@ func (s *TransactionStreamer) addMessagesAndEndBatchImpl(messageStartPos arbutil
 
 // The caller must hold the insertionMutex
 func (s *TransactionStreamer) ExpectChosenSequencer() error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
 	if s.coordinator != nil {
 		if !s.coordinator.CurrentlyChosen() {
 			return fmt.Errorf("%w: not main sequencer", execution.ErrRetrySequencer)
@@ -983,16 +1669,40 @@ func (s *TransactionStreamer) WriteMessageFromSequencer(
 	msgWithMeta arbostypes.MessageWithMetadata,
 	msgResult execution.MessageResult,
 ) error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	if s.espressoDerivation {
+		return ErrEspressoDerivation
+	}
 
 	if err := s.ExpectChosenSequencer(); err != nil {
 		return err
 	}
+	if err := s.expectStartupReplayed(); err != nil {
+		return err
+	}
+	if err := s.expectKillSwitchReleased(pos); err != nil {
+		return err
+	}
 	if !s.insertionMutex.TryLock() {
 		return execution.ErrSequencerInsertLockTaken
 	}
 	defer s.insertionMutex.Unlock()
+	defer s.recordSequencingLatency(time.Now())
 
-	msgCount, err := s.GetMessageCount()
+	if s.sequencerGroupErr != nil {
+		return s.sequencerGroupErr
+	}
+	groupCommit := s.config().SequencerGroupCommit
+	if !groupCommit.Enable {
+		// A group may be staged from before the group commit was disabled
+		if err := s.commitSequencerGroup(); err != nil {
+			return err
+		}
+	}
+
+	msgCount, err := s.sequencedMessageCount()
 	if err != nil {
 		return err
 	}
@@ -1001,6 +1711,11 @@ func (s *TransactionStreamer) WriteMessageFromSequencer(
 		return fmt.Errorf("wrong pos got %d expected %d", pos, msgCount)
 	}
 
+	// In shadow mode the messages that don't fit in the pending queue are just not submitted
+	if !s.espressoShadowMode() && s.espressoPendingQueueFull() {
+		return fmt.Errorf("%w: %w", execution.ErrRetrySequencer, ErrEspressoPendingQueueFull)
+	}
+
 	if s.coordinator != nil {
 		if err := s.coordinator.SequencingMessage(pos, &msgWithMeta); err != nil {
 			return err
@@ -1011,7 +1726,28 @@ func (s *TransactionStreamer) WriteMessageFromSequencer(
 		MessageWithMeta: msgWithMeta,
 		BlockHash:       &msgResult.BlockHash,
 	}
-	if err := s.writeMessages(pos, []arbostypes.MessageWithMetadataAndBlockHash{msgWithBlockHash}, s.db.NewBatch()); err != nil {
+	// The result is stored with the message, the sequencer's engine already executed it
+	var batch ethdb.Batch
+	if groupCommit.Enable {
+		batch = s.openSequencerGroup(pos)
+	} else {
+		batch = s.db.NewBatch()
+	}
+	if err := s.storeResult(pos, msgResult, batch); err != nil {
+		return err
+	}
+	// The message was executed by the sequencer before being written
+	sequencedAt := time.Now()
+	if err := s.recordMessageTelemetry(batch, pos, messageSequencedStage, sequencedAt); err != nil {
+		return err
+	}
+	if err := s.recordMessageTelemetry(batch, pos, messageExecutedStage, sequencedAt); err != nil {
+		return err
+	}
+	if groupCommit.Enable {
+		return s.stageSequencerMessage(msgWithBlockHash, &groupCommit)
+	}
+	if err := s.writeMessages(pos, []arbostypes.MessageWithMetadataAndBlockHash{msgWithBlockHash}, batch); err != nil {
 		return err
 	}
 
@@ -1019,6 +1755,26 @@ func (s *TransactionStreamer) WriteMessageFromSequencer(
 	return nil
 }
 
+// The weight of a new sample in the sequencing latency average is 1/sequencingLatencySmoothing
+const sequencingLatencySmoothing = 16
+
+// recordSequencingLatency must be called while holding the insertionMutex
+func (s *TransactionStreamer) recordSequencingLatency(start time.Time) {
+	sample := int64(time.Since(start))
+	avg := s.sequencingLatency.Load()
+	if avg == 0 {
+		avg = sample
+	} else {
+		avg += (sample - avg) / sequencingLatencySmoothing
+	}
+	s.sequencingLatency.Store(avg)
+}
+
+// SequencingLatency returns the recent average time to write a sequenced message, 0 before the first one
+func (s *TransactionStreamer) SequencingLatency() time.Duration {
+	return time.Duration(s.sequencingLatency.Load())
+}
+
 // PauseReorgs until a matching call to ResumeReorgs (may be called concurrently)
 func (s *TransactionStreamer) PauseReorgs() {
 	s.reorgMutex.RLock()
@@ -1028,13 +1784,6 @@ func (s *TransactionStreamer) ResumeReorgs() {
 	s.reorgMutex.RUnlock()
 }
 
-func (s *TransactionStreamer) PopulateFeedBacklog() error {
-	if s.broadcastServer == nil {
-		return nil
-	}
-	return s.inboxReader.tracker.PopulateFeedBacklog(s.broadcastServer)
-}
-
 func (s *TransactionStreamer) writeMessage(pos arbutil.MessageIndex, msg arbostypes.MessageWithMetadataAndBlockHash, batch ethdb.Batch) error {
 	// write message with metadata
 	key := dbKey(messagePrefix, uint64(pos))
@@ -1045,6 +1794,9 @@ func (s *TransactionStreamer) writeMessage(pos arbutil.MessageIndex, msg arbosty
 	if err := batch.Put(key, msgBytes); err != nil {
 		return err
 	}
+	if err := writeMessageLookup(batch, pos, &msg.MessageWithMeta); err != nil {
+		return err
+	}
 
 	// write block hash
 	blockHashDBVal := blockHashDBValue{
@@ -1065,11 +1817,47 @@ func (s *TransactionStreamer) broadcastMessages(
 	if s.broadcastServer == nil {
 		return
 	}
-	if err := s.broadcastServer.BroadcastMessages(msgs, pos); err != nil {
+	var finality []*m.EspressoFinality
+	if s.broadcastServer.EmitsEspressoFinality() {
+		finality = s.espressoFinalityForFeed(pos, len(msgs))
+	}
+	if err := s.broadcastServer.BroadcastMessagesWithEspressoFinality(msgs, pos, finality); err != nil {
 		log.Error("failed broadcasting messages", "pos", pos, "err", err)
 	}
 }
 
+// espressoFinalityForFeed returns the espresso finality of the count messages from pos, with nil entries for
+// messages that weren't finalized by HotShot yet
+func (s *TransactionStreamer) espressoFinalityForFeed(pos arbutil.MessageIndex, count int) []*m.EspressoFinality {
+	finality := make([]*m.EspressoFinality, count)
+	for i := range finality {
+		// #nosec G115
+		msgPos := pos + arbutil.MessageIndex(i)
+		record, err := s.GetEspressoSubmissionRecord(msgPos)
+		if err != nil || record == nil || record.Status != EspressoSubmissionFinalized {
+			continue
+		}
+		justification, err := s.GetEspressoJustification(msgPos)
+		if err != nil || justification == nil {
+			continue
+		}
+		finality[i] = &m.EspressoFinality{
+			HotShotHeight: justification.HotShotHeight,
+			TxHash:        record.TxHash,
+			Namespace:     s.chainConfig.ChainID.Uint64(),
+		}
+		if justification.BlockMerkleRoot != "" {
+			finality[i].Justification = &m.EspressoFeedJustification{
+				Header:          justification.Header,
+				RootHeight:      justification.RootHeight,
+				BlockMerkleRoot: justification.BlockMerkleRoot,
+				Proof:           justification.Proof,
+			}
+		}
+	}
+	return finality
+}
+
 // The mutex must be held, and pos must be the latest message count.
 // `batch` may be nil, which initializes a new batch. The batch is closed out in this function.
 func (s *TransactionStreamer) writeMessages(pos arbutil.MessageIndex, messages []arbostypes.MessageWithMetadataAndBlockHash, batch ethdb.Batch) error {
@@ -1088,15 +1876,25 @@ func (s *TransactionStreamer) writeMessages(pos arbutil.MessageIndex, messages [
 	if err != nil {
 		return err
 	}
+	commitMessageChain, err := s.extendMessageChain(pos, messages, batch)
+	if err != nil {
+		return err
+	}
+	writeStart := time.Now()
 	err = batch.Write()
 	if err != nil {
 		return err
 	}
+	commitMessageChain()
+	s.loadShedding.observeWrite(time.Since(writeStart))
+	s.messageReadCache.addMessages(pos, messages)
+	s.recentMessages.addMessages(pos, messages)
 
 	select {
 	case s.newMessageNotifier <- struct{}{}:
 	default:
 	}
+	s.newMessageSignal.notify()
 
 	return nil
 }
@@ -1118,12 +1916,19 @@ func (s *TransactionStreamer) ResultAtCount(count arbutil.MessageIndex) (*execut
 	} else if !dbutil.IsErrNotFound(err) {
 		return nil, err
 	}
+	if s.exec == nil {
+		return nil, ErrNoExecution
+	}
 	log.Info(FailedToGetMsgResultFromDB, "count", count)
+	remoteMessageResultCounter.Inc(1)
 
 	msgResult, err := s.exec.ResultAtPos(pos)
 	if err != nil {
 		return nil, err
 	}
+	if s.readOnly {
+		return msgResult, nil
+	}
 	// Stores result in Consensus DB in a best-effort manner
 	batch := s.db.NewBatch()
 	err = s.storeResult(pos, *msgResult, batch)
@@ -1170,6 +1975,9 @@ func (s *TransactionStreamer) storeResult(
 // exposed for testing
 // return value: true if should be called again immediately
 func (s *TransactionStreamer) ExecuteNextMsg(ctx context.Context, exec execution.ExecutionSequencer) bool {
+	if s.readOnly {
+		return false
+	}
 	if ctx.Err() != nil {
 		return false
 	}
@@ -1193,14 +2001,17 @@ func (s *TransactionStreamer) ExecuteNextMsg(ctx context.Context, exec execution
 	if pos >= msgCount {
 		return false
 	}
-	msgAndBlockHash, err := s.getMessageWithMetadataAndBlockHash(pos)
+	if threshold := s.config().ExecuteBatchThreshold; threshold > 0 && uint64(msgCount-pos) > threshold {
+		return s.executeMessageBatch(ctx, pos, msgCount, prevMessageCount)
+	}
+	msgAndBlockHash, err := s.nextMessageToExecute(ctx, pos, msgCount)
 	if err != nil {
 		log.Error("feedOneMsg failed to readMessage", "err", err, "pos", pos)
 		return false
 	}
 	var msgForPrefetch *arbostypes.MessageWithMetadata
 	if pos+1 < msgCount {
-		msg, err := s.GetMessage(pos + 1)
+		msg, err := s.messageForPrefetch(pos + 1)
 		if err != nil {
 			log.Error("feedOneMsg failed to readMessage", "err", err, "pos", pos+1)
 			return false
@@ -1216,27 +2027,107 @@ func (s *TransactionStreamer) ExecuteNextMsg(ctx context.Context, exec execution
 		logger("feedOneMsg failed to send message to execEngine", "err", err, "pos", pos)
 		return false
 	}
-
-	s.checkResult(msgResult, msgAndBlockHash.BlockHash)
-
-	batch := s.db.NewBatch()
-	err = s.storeResult(pos, *msgResult, batch)
-	if err != nil {
+	if err := s.storeExecutedMessages(pos, []*arbostypes.MessageWithMetadataAndBlockHash{msgAndBlockHash}, []*execution.MessageResult{msgResult}); err != nil {
 		log.Error("feedOneMsg failed to store result", "err", err)
 		return false
 	}
-	err = batch.Write()
-	if err != nil {
+	return pos+1 < msgCount
+}
+
+// executeMessageBatch executes the messages from pos at once, while the execution engine is far behind the
+// message count, so that the engine shares its work between them
+func (s *TransactionStreamer) executeMessageBatch(ctx context.Context, pos arbutil.MessageIndex, msgCount arbutil.MessageIndex, prevMessageCount arbutil.MessageIndex) bool {
+	end := min(msgCount, pos+arbutil.MessageIndex(s.config().ExecuteBatchSize))
+	msgs := make([]*arbostypes.MessageWithMetadataAndBlockHash, 0, end-pos)
+	toDigest := make([]*arbostypes.MessageWithMetadata, 0, end-pos)
+	for msgPos := pos; msgPos < end; msgPos++ {
+		msg, err := s.nextMessageToExecute(ctx, msgPos, msgCount)
+		if err != nil {
+			log.Error("feedOneMsg failed to readMessage", "err", err, "pos", msgPos)
+			return false
+		}
+		msgs = append(msgs, msg)
+		toDigest = append(toDigest, &msg.MessageWithMeta)
+	}
+	var msgForPrefetch *arbostypes.MessageWithMetadata
+	if end < msgCount {
+		msg, err := s.messageForPrefetch(end)
+		if err != nil {
+			log.Error("feedOneMsg failed to readMessage", "err", err, "pos", end)
+			return false
+		}
+		msgForPrefetch = msg
+	}
+	// The results of the messages executed before a failure are stored, the engine's head moved past them
+	msgResults, digestErr := s.exec.DigestMessages(pos, toDigest, msgForPrefetch)
+	if len(msgResults) > len(msgs) {
+		log.Error("execution engine returned more results than messages executed", "pos", pos, "messages", len(msgs), "results", len(msgResults))
+		return false
+	}
+	if err := s.storeExecutedMessages(pos, msgs[:len(msgResults)], msgResults); err != nil {
 		log.Error("feedOneMsg failed to store result", "err", err)
 		return false
 	}
+	if digestErr != nil {
+		logger := log.Warn
+		if prevMessageCount < msgCount {
+			logger = log.Debug
+		}
+		// #nosec G115
+		logger("feedOneMsg failed to send messages to execEngine", "err", digestErr, "pos", pos+arbutil.MessageIndex(len(msgResults)))
+		return false
+	}
+	return end < msgCount
+}
 
-	msgWithBlockHash := arbostypes.MessageWithMetadataAndBlockHash{
-		MessageWithMeta: msgAndBlockHash.MessageWithMeta,
-		BlockHash:       &msgResult.BlockHash,
+// storeExecutedMessages stores the results of the messages executed from pos, repairing their block hashes if
+// they're missing, and broadcasts the messages
+func (s *TransactionStreamer) storeExecutedMessages(pos arbutil.MessageIndex, msgs []*arbostypes.MessageWithMetadataAndBlockHash, msgResults []*execution.MessageResult) error {
+	if len(msgs) == 0 {
+		return nil
 	}
-	s.broadcastMessages([]arbostypes.MessageWithMetadataAndBlockHash{msgWithBlockHash}, pos)
-	return pos+1 < msgCount
+	batch := s.db.NewBatch()
+	executedAt := time.Now()
+	repaired := make([]bool, len(msgs))
+	for i, msg := range msgs {
+		// #nosec G115
+		msgPos := pos + arbutil.MessageIndex(i)
+		s.checkResult(msgResults[i], msg.BlockHash)
+		s.feedLatency.executed(msgPos)
+		if err := s.recordMessageTelemetry(batch, msgPos, messageExecutedStage, executedAt); err != nil {
+			return err
+		}
+		if err := s.storeResult(msgPos, *msgResults[i], batch); err != nil {
+			return err
+		}
+		if msg.BlockHash == nil {
+			var err error
+			repaired[i], err = s.repairBlockHash(msgPos, msgResults[i].BlockHash, batch)
+			if err != nil {
+				log.Warn("feedOneMsg failed to repair block hash", "err", err, "pos", msgPos)
+			}
+		}
+	}
+	if err := batch.Write(); err != nil {
+		return err
+	}
+
+	msgsWithBlockHash := make([]arbostypes.MessageWithMetadataAndBlockHash, 0, len(msgs))
+	for i, msg := range msgs {
+		msgWithBlockHash := arbostypes.MessageWithMetadataAndBlockHash{
+			MessageWithMeta: msg.MessageWithMeta,
+			BlockHash:       &msgResults[i].BlockHash,
+		}
+		if repaired[i] {
+			blockHashRepairedCounter.Inc(1)
+			// The message was cached without its block hash
+			// #nosec G115
+			s.recentMessages.drop(pos + arbutil.MessageIndex(i))
+		}
+		msgsWithBlockHash = append(msgsWithBlockHash, msgWithBlockHash)
+	}
+	s.broadcastMessages(msgsWithBlockHash, pos)
+	return nil
 }
 
 func (s *TransactionStreamer) executeMessages(ctx context.Context, ignored struct{}) time.Duration {
@@ -1267,94 +2158,106 @@ func (s *TransactionStreamer) pollSubmittedTransactionForFinality(ctx context.Co
 		return errors.New("missing the tx hash while the submitted txn position exists")
 	}
 
-	data, err := s.espressoClient.FetchTransactionByHash(ctx, submittedTxHash)
+	submittedPayload, err := s.getEspressoSubmittedPayload()
 	if err != nil {
-		return fmt.Errorf("failed to fetch the submitted transaction hash (hash: %s): %w", submittedTxHash.String(), err)
+		return fmt.Errorf("submitted payload not found: %w", err)
 	}
 
-	height := data.BlockHeight
-
-	header, err := s.espressoClient.FetchHeaderByHeight(ctx, height)
-	if err != nil {
-		return fmt.Errorf("could not get the header (height: %d): %w", height, err)
+	finality, err := s.finality().Finality(ctx, submittedTxHash, submittedPayload)
+	if errors.Is(err, ErrFinalityTransactionNotFound) {
+		requeued, requeueErr := s.requeueIfInclusionTimedOut(submittedTxnPos)
+		if requeueErr != nil {
+			return requeueErr
+		}
+		if requeued {
+			return nil
+		}
+		return fmt.Errorf("failed to fetch the submitted transaction: %w", err)
 	}
-
-	// Verify the merkle proof
-	snapshot, err := s.lightClientReader.FetchMerkleRoot(height, nil)
-	if err != nil {
-		return fmt.Errorf("%w (height: %d): %w", EspressoFetchMerkleRootErr, height, err)
+	if errors.Is(err, ErrFinalityPayloadMismatch) {
+		s.espressoTxnsStateInsertionMutex.Lock()
+		defer s.espressoTxnsStateInsertionMutex.Unlock()
+		batch := s.newEspressoStateBatch()
+		if err := s.requeueEspressoSubmittedTxns(batch, submittedTxnPos, EspressoSubmissionFailed); err != nil {
+			return err
+		}
+		if err := s.writeEspressoStateBatch(batch, espressoIntentRequeue); err != nil {
+			return err
+		}
+		return err
 	}
-
-	if snapshot.Height <= height {
-		return errors.New("snapshot height is less than or equal to transaction height")
+	if err != nil || finality == nil {
+		return err
 	}
-
-	nextHeader, err := s.espressoClient.FetchHeaderByHeight(ctx, snapshot.Height)
-	if err != nil {
-		return fmt.Errorf("error fetching the snapshot header (height: %d): %w", snapshot.Height, err)
+	if err := s.verifyEspressoAttestation(submittedPayload); err != nil {
+		// Submitted again with a fresh attestation, the finalized transaction is ignored
+		s.espressoTxnsStateInsertionMutex.Lock()
+		defer s.espressoTxnsStateInsertionMutex.Unlock()
+		batch := s.newEspressoStateBatch()
+		if err := s.requeueEspressoSubmittedTxns(batch, submittedTxnPos, EspressoSubmissionFailed); err != nil {
+			return err
+		}
+		if err := s.writeEspressoStateBatch(batch, espressoIntentRequeue); err != nil {
+			return err
+		}
+		return err
 	}
 
-	proof, err := s.espressoClient.FetchBlockMerkleProof(ctx, snapshot.Height, height)
-	if err != nil {
-		return fmt.Errorf("error fetching the block merkle proof (height: %d, root height: %d): %w", height, snapshot.Height, err)
-	}
+	// Validation completed. Update the database
+	s.espressoTxnsStateInsertionMutex.Lock()
+	defer s.espressoTxnsStateInsertionMutex.Unlock()
 
-	blockMerkleTreeRoot := nextHeader.Header.GetBlockMerkleTreeRoot()
-	jstHeader, err := json.Marshal(header)
-	if err != nil {
-		return fmt.Errorf("failed to marshal the header: %w", err)
+	batch := s.newEspressoStateBatch()
+	// A chunk of a large message only confirms the message once all its chunks are finalized
+	if chunk, err := parseEspressoChunk(submittedPayload); err == nil {
+		complete, err := s.finalizeEspressoChunk(batch, chunk)
+		if err != nil {
+			return err
+		}
+		if !complete {
+			return s.writeEspressoStateBatch(batch, espressoIntentFinalize)
+		}
+	} else if !errors.Is(err, errEspressoNotChunk) {
+		return err
 	}
-
-	ok := espressocrypto.VerifyMerkleProof(proof.Proof, jstHeader, *blockMerkleTreeRoot, snapshot.Root)
-	if !ok {
-		return fmt.Errorf("error validating merkle proof (height: %d, snapshot height: %d)", height, snapshot.Height)
+	if err := s.cleanEspressoSubmittedData(batch); err != nil {
+		return err
 	}
-
-	// Verify the namespace proof
-	resp, err := s.espressoClient.FetchTransactionsInBlock(ctx, height, s.chainConfig.ChainID.Uint64())
+	lastConfirmedPos, err := s.nextEspressoLastConfirmedPos(submittedTxnPos)
 	if err != nil {
-		return fmt.Errorf("failed to fetch the transactions in block (height: %d): %w", height, err)
+		return err
 	}
-
-	namespaceOk := espressocrypto.VerifyNamespace(
-		s.chainConfig.ChainID.Uint64(),
-		resp.Proof,
-		*header.Header.GetPayloadCommitment(),
-		*header.Header.GetNsTable(),
-		resp.Transactions,
-		resp.VidCommon,
-	)
-
-	if !namespaceOk {
-		return fmt.Errorf("error validating namespace proof (height: %d)", height)
+	if lastConfirmedPos != nil {
+		if err := s.setEspressoLastConfirmedPos(batch, lastConfirmedPos); err != nil {
+			return fmt.Errorf("failed to set the last confirmed position (pos: %d): %w", *lastConfirmedPos, err)
+		}
 	}
-
-	submittedPayload, err := s.getEspressoSubmittedPayload()
-	if err != nil {
-		return fmt.Errorf("submitted payload not found: %w", err)
+	if err := s.setEspressoSubmissionStatus(batch, submittedTxnPos, EspressoSubmissionFinalized, submittedTxHash); err != nil {
+		return err
 	}
-
-	validated := validateIfPayloadIsInBlock(submittedPayload, resp.Transactions)
-	if !validated {
-		return fmt.Errorf("transactions fetched from HotShot doesn't contain the submitted payload")
+	if err := s.setEspressoLastFinalizedHeight(batch, finality.Height); err != nil {
+		return err
 	}
-
-	// Validation completed. Update the database
-	s.espressoTxnsStateInsertionMutex.Lock()
-	defer s.espressoTxnsStateInsertionMutex.Unlock()
-
-	batch := s.db.NewBatch()
-	if err := s.cleanEspressoSubmittedData(batch); err != nil {
-		return nil
+	if finality.Justification != nil {
+		for _, pos := range submittedTxnPos {
+			if err := s.setEspressoJustification(batch, pos, finality.Justification); err != nil {
+				return err
+			}
+		}
 	}
-	lastConfirmedPos := submittedTxnPos[len(submittedTxnPos)-1]
-	if err := s.setEspressoLastConfirmedPos(batch, &lastConfirmedPos); err != nil {
-		return fmt.Errorf("failed to set the last confirmed position (pos: %d): %w", lastConfirmedPos, err)
+	if err := s.deleteEspressoDeadlines(batch, submittedTxnPos); err != nil {
+		return err
 	}
 
-	if err := batch.Write(); err != nil {
+	if err := s.writeEspressoStateBatch(batch, espressoIntentFinalize); err != nil {
 		return fmt.Errorf("failed to write to db: %w", err)
 	}
+	if finality.Justification != nil {
+		s.updateEspressoJustifiedWatermark()
+	}
+	if s.espressoShadowMode() {
+		s.recordEspressoShadowFinality(submittedTxnPos, time.Now())
+	}
 
 	return nil
 }
@@ -1441,31 +2344,14 @@ func (s *TransactionStreamer) getSkipVerificationPos() (*arbutil.MessageIndex, e
 	return &skipPos, nil
 }
 
-func (s *TransactionStreamer) getEspressoPendingTxnsPos() ([]arbutil.MessageIndex, error) {
+func (s *TransactionStreamer) setEspressoSubmittedPos(batch ethdb.KeyValueWriter, pos []arbutil.MessageIndex) error {
+	// if pos is nil, delete the key
+	if pos == nil {
+		err := batch.Delete(espressoSubmittedPos)
+		return err
+	}
 
-	pendingTxnsBytes, err := s.db.Get(espressoPendingTxnsPositions)
-	if err != nil {
-		if dbutil.IsErrNotFound(err) {
-			return nil, nil
-		}
-		return nil, err
-	}
-	var pendingTxnsPos []arbutil.MessageIndex
-	err = rlp.DecodeBytes(pendingTxnsBytes, &pendingTxnsPos)
-	if err != nil {
-		return nil, err
-	}
-	return pendingTxnsPos, nil
-}
-
-func (s *TransactionStreamer) setEspressoSubmittedPos(batch ethdb.KeyValueWriter, pos []arbutil.MessageIndex) error {
-	// if pos is nil, delete the key
-	if pos == nil {
-		err := batch.Delete(espressoSubmittedPos)
-		return err
-	}
-
-	posBytes, err := rlp.EncodeToBytes(pos)
+	posBytes, err := rlp.EncodeToBytes(pos)
 	if err != nil {
 		return err
 	}
@@ -1506,7 +2392,7 @@ func (s *TransactionStreamer) cleanEspressoSubmittedData(batch ethdb.Batch) erro
 
 func (s *TransactionStreamer) setEspressoSubmittedPayload(batch ethdb.KeyValueWriter, payload []byte) error {
 	if payload == nil {
-		err := batch.Delete(espressoSubmittedHash)
+		err := batch.Delete(espressoSubmittedPayload)
 		return err
 	}
 	err := batch.Put(espressoSubmittedPayload, payload)
@@ -1549,25 +2435,25 @@ func (s *TransactionStreamer) setEspressoSubmittedHash(batch ethdb.KeyValueWrite
 	return nil
 }
 
-func (s *TransactionStreamer) setEspressoPendingTxnsPos(batch ethdb.KeyValueWriter, pos []arbutil.MessageIndex) error {
-	if pos == nil {
-		err := batch.Delete(espressoPendingTxnsPositions)
-		return err
+func (s *TransactionStreamer) HasNotSubmitted(pos arbutil.MessageIndex) (bool, error) {
+	if !s.isEspressoActiveAt(pos) {
+		// Messages before the migration activation position are never submitted to espresso
+		return false, nil
 	}
 
-	posBytes, err := rlp.EncodeToBytes(pos)
+	record, err := s.GetEspressoSubmissionRecord(pos)
 	if err != nil {
-		return err
+		return false, err
 	}
-	err = batch.Put(espressoPendingTxnsPositions, posBytes)
-	if err != nil {
-		return err
-
+	if record != nil && record.Status == EspressoSubmissionExpired {
+		// The message missed its deadline and went through the fallback
+		return false, nil
+	}
+	if record != nil && record.Status == EspressoSubmissionDeadLettered {
+		// The message is only queued again by an operator
+		return false, nil
 	}
-	return nil
-}
 
-func (s *TransactionStreamer) HasNotSubmitted(pos arbutil.MessageIndex) (bool, error) {
 	submitted, err := s.getEspressoSubmittedPos()
 	if err != nil {
 		return false, err
@@ -1598,113 +2484,318 @@ func (s *TransactionStreamer) HasNotSubmitted(pos arbutil.MessageIndex) (bool, e
 	return true, nil
 }
 
+// CountUnjustifiedMessages returns how many messages in [start, end) are sequenced through espresso
+// but haven't passed espresso verification, and aren't allowed to skip it through the escape hatch.
+func (s *TransactionStreamer) CountUnjustifiedMessages(start arbutil.MessageIndex, end arbutil.MessageIndex) (uint64, error) {
+	if end <= start {
+		return 0, nil
+	}
+	justifiedEnd := s.espressoMigrationActivationPos()
+	lastConfirmed, err := s.getLastConfirmedPos()
+	if err != nil {
+		return 0, err
+	}
+	if lastConfirmed != nil && *lastConfirmed+1 > justifiedEnd {
+		justifiedEnd = *lastConfirmed + 1
+	}
+	if s.UseEscapeHatch {
+		skip, err := s.getSkipVerificationPos()
+		if err != nil {
+			return 0, err
+		}
+		if skip != nil && *skip+1 > justifiedEnd {
+			justifiedEnd = *skip + 1
+		}
+	}
+	if justifiedEnd < start {
+		justifiedEnd = start
+	}
+	if justifiedEnd >= end {
+		return 0, nil
+	}
+	escapeHatchRanges, err := s.GetEscapeHatchRanges(justifiedEnd, end)
+	if err != nil {
+		return 0, err
+	}
+	unjustified := uint64(end - justifiedEnd)
+	for _, r := range escapeHatchRanges {
+		unjustified -= uint64(r.End - r.Start)
+	}
+	return unjustified, nil
+}
+
 // Append a position to the pending queue. Please ensure this position is valid beforehand.
+// Returns ErrEspressoPendingQueueFull if the queue has reached the configured limit.
 func (s *TransactionStreamer) SubmitEspressoTransactionPos(pos arbutil.MessageIndex, batch ethdb.Batch) error {
-	pendingTxnsPos, err := s.getEspressoPendingTxnsPos()
-	if err != nil {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	// The message is read back from the database when it's submitted
+	if err := s.commitStagedSequencerMessages(pos); err != nil {
 		return err
 	}
+	s.espressoTxnsStateInsertionMutex.Lock()
+	defer s.espressoTxnsStateInsertionMutex.Unlock()
 
-	if pendingTxnsPos == nil {
-		// if the key doesn't exist, create a new array with the pos
-		pendingTxnsPos = []arbutil.MessageIndex{pos}
-	} else {
-		pendingTxnsPos = append(pendingTxnsPos, pos)
+	if s.espressoPendingQueueFull() {
+		return ErrEspressoPendingQueueFull
 	}
-	err = s.setEspressoPendingTxnsPos(batch, pendingTxnsPos)
+	err := s.appendEspressoPendingTxnPos(batch, pos)
 	if err != nil {
 		log.Error("failed to set the pending txns", "err", err)
 		return err
 	}
+	err = s.recordEspressoActivationIfNeeded(batch)
+	if err != nil {
+		return err
+	}
+	err = s.setDefaultEspressoDeadline(batch, pos)
+	if err != nil {
+		return err
+	}
+	err = s.setEspressoSubmissionStatus(batch, []arbutil.MessageIndex{pos}, EspressoSubmissionPending, nil)
+	if err != nil {
+		return err
+	}
 
 	err = batch.Write()
 	if err != nil {
 		return err
 	}
+	s.notifyEspressoSwitch()
 
 	return nil
 }
 
+// espressoIdleInterval returns how long the espresso loop waits when there's nothing to submit.
+// In the long-poll mode the loop is woken up as soon as a message is queued, so while no transaction
+// is waiting for finality it only needs to run for the liveness checks.
+func (s *TransactionStreamer) espressoIdleInterval() time.Duration {
+	longPoll := s.config().Espresso.LongPollInterval
+	if longPoll == 0 {
+		return s.espressoPollDelay()
+	}
+	submitted, err := s.getEspressoSubmittedPos()
+	if err != nil || len(submitted) > 0 {
+		return s.espressoPollDelay()
+	}
+	return longPoll
+}
+
 func (s *TransactionStreamer) submitEspressoTransactions(ctx context.Context) time.Duration {
 
 	pendingTxnsPos, err := s.getEspressoPendingTxnsPos()
 	if err != nil {
 		return s.espressoTxnsPollingInterval
 	}
+	if len(pendingTxnsPos) == 0 {
+		return s.espressoIdleInterval()
+	}
+	// Messages at or past an engaged kill switch stay queued until it's released
+	pendingTxnsPos = s.orderEspressoPendingTxns(s.beforeKillSwitch(pendingTxnsPos))
 
 	if len(pendingTxnsPos) > 0 {
-		fetcher := func(pos arbutil.MessageIndex) ([]byte, error) {
-			msg, err := s.GetMessage(pos)
-			if err != nil {
-				return nil, err
+		codec := s.espressoPayloadCodec()
+		sizeLimit := s.espressoTransactionSizeLimit()
+		payload, msgCnt := codec.BuildPayload(pendingTxnsPos, s.espressoMessageBytes, sizeLimit)
+		if msgCnt > 1 && !slices.IsSorted(pendingTxnsPos[:msgCnt]) {
+			// The order only decides which messages are included, they're included in position order
+			pendingTxnsPos = slices.Clone(pendingTxnsPos[:msgCnt])
+			slices.Sort(pendingTxnsPos)
+			var sortedCnt int
+			payload, sortedCnt = codec.BuildPayload(pendingTxnsPos, s.espressoMessageBytes, sizeLimit)
+			if sortedCnt != msgCnt {
+				log.Error("failed to rebuild the hotshot transaction in position order", "expected", msgCnt, "included", sortedCnt)
+				return s.espressoTxnsPollingInterval
 			}
-			b, err := rlp.EncodeToBytes(msg)
-			if err != nil {
-				return nil, err
-			}
-			return b, nil
 		}
-
-		payload, msgCnt := buildRawHotShotPayload(pendingTxnsPos, fetcher, s.espressoMaxTransactionSize)
 		if msgCnt == 0 {
-			log.Error("failed to build the hotshot transaction: a large message has exceeded the size limit or failed to get a message from storage", "size", s.espressoMaxTransactionSize)
-			return s.espressoTxnsPollingInterval
+			// The first message alone exceeds the size limit, so it's split across several transactions
+			payload, err = s.buildEspressoChunkPayload(pendingTxnsPos[0], sizeLimit)
+			if err != nil {
+				log.Error("failed to build the hotshot transaction", "pos", pendingTxnsPos[0], "size", sizeLimit, "err", err)
+				if s.recordEspressoSubmissionFailure(pendingTxnsPos[0], err) {
+					// Continue with the messages queued after it
+					return 0
+				}
+				return s.espressoTxnsPollingInterval
+			}
+			msgCnt = 1
+		} else {
+			payload = s.compressEspressoPayload(payload)
 		}
+		s.resetEspressoSubmissionFailures()
 
-		payload, err = signHotShotPayload(payload, s.getAttestationQuote)
+		payload, err = codec.SignPayload(payload, s.espressoPayloadSigner())
 		if err != nil {
 			log.Error("failed to sign the hotshot payload", "err", err)
 			return s.espressoTxnsPollingInterval
 		}
 
-		log.Info("submitting transaction to hotshot for finalization")
-
-		// Note: same key should not be used for two namespaces for this to work
-		hash, err := s.espressoClient.SubmitTransaction(ctx, espressoTypes.Transaction{
-			Payload:   payload,
-			Namespace: s.chainConfig.ChainID.Uint64(),
-		})
-
+		provider := s.finality()
+		hash, err := provider.TransactionHash(payload)
 		if err != nil {
-			log.Error("failed to submit transaction to espresso", "err", err)
+			log.Error("failed to compute the espresso transaction hash", "err", err)
 			return s.espressoTxnsPollingInterval
 		}
 
-		s.espressoTxnsStateInsertionMutex.Lock()
-		defer s.espressoTxnsStateInsertionMutex.Unlock()
+		// Keep the messages queued while the fee limits don't allow submitting them
+		if _, err := s.checkEspressoFee(ctx, payload, time.Now()); err != nil {
+			log.Warn("not submitting the espresso transaction", "err", err)
+			return s.espressoTxnsPollingInterval
+		}
 
-		batch := s.db.NewBatch()
+		// Record the submission in the coordinator's audit trail, so that another sequencer
+		// taking over can find the transaction if this node dies before it's replicated.
 		submittedPos := pendingTxnsPos[:msgCnt]
-		err = s.setEspressoSubmittedPos(batch, submittedPos)
+		err = s.recordEspressoSubmissionAudit(ctx, submittedPos, hash, payload)
 		if err != nil {
-			log.Error("failed to set the submitted txn pos", "err", err)
+			log.Warn("failed to record the espresso submission in the audit trail", "err", err)
 			return s.espressoTxnsPollingInterval
 		}
-		pendingTxnsPos = pendingTxnsPos[msgCnt:]
-		err = s.setEspressoPendingTxnsPos(batch, pendingTxnsPos)
+
+		// Persist the submission before sending it, so that after a crash the
+		// in-flight transaction can be reconciled with HotShot.
+		err = s.persistEspressoSubmission(submittedPos, hash, payload)
 		if err != nil {
-			log.Error("failed to set the pending txns", "err", err)
+			log.Error("failed to persist the espresso submission", "err", err)
 			return s.espressoTxnsPollingInterval
 		}
-		err = s.setEspressoSubmittedHash(batch, hash)
+
+		log.Info("submitting transaction to hotshot for finalization")
+
+		submittedHash, deduped, err := s.submitEspressoPayload(ctx, provider, hash, payload)
 		if err != nil {
-			log.Error("failed to set the submitted hash", "err", err)
+			log.Error("failed to submit transaction to espresso", "err", err)
+			s.espressoSubmissionReconciled = false
 			return s.espressoTxnsPollingInterval
 		}
-		err = s.setEspressoSubmittedPayload(batch, payload)
-		if err != nil {
-			log.Error("failed to set the espresso payload", "err", err)
-			return s.espressoTxnsPollingInterval
+		s.resetEspressoPollDelay()
+		if deduped {
+			return s.espressoPollDelay()
+		}
+		if submittedHash.String() != hash.String() {
+			log.Warn("hotshot returned an unexpected transaction hash", "expected", hash.String(), "got", submittedHash.String())
+			s.espressoTxnsStateInsertionMutex.Lock()
+			defer s.espressoTxnsStateInsertionMutex.Unlock()
+			batch := s.newEspressoStateBatch()
+			err = s.setEspressoSubmittedHash(batch, submittedHash)
+			if err != nil {
+				log.Error("failed to set the submitted hash", "err", err)
+				return s.espressoTxnsPollingInterval
+			}
+			err = s.setEspressoSubmissionStatus(batch, submittedPos, EspressoSubmissionSubmitted, submittedHash)
+			if err != nil {
+				log.Error("failed to set the submission status", "err", err)
+				return s.espressoTxnsPollingInterval
+			}
+			err = s.writeEspressoStateBatch(batch, espressoIntentRehash)
+			if err != nil {
+				log.Error("failed to write to db", "err", err)
+				return s.espressoTxnsPollingInterval
+			}
 		}
+	}
 
-		err = batch.Write()
-		if err != nil {
-			log.Error("failed to write to db", "err", err)
-			return s.espressoTxnsPollingInterval
+	return s.espressoPollDelay()
+}
+
+// persistEspressoSubmission atomically moves the submitted positions from the pending queue
+// to the submitted state, along with the transaction's hash and payload.
+func (s *TransactionStreamer) persistEspressoSubmission(submittedPos []arbutil.MessageIndex, hash *espressoTypes.TaggedBase64, payload []byte) error {
+	s.espressoTxnsStateInsertionMutex.Lock()
+	defer s.espressoTxnsStateInsertionMutex.Unlock()
+
+	// Positions may have been appended since the payload was built, so re-read the queue. The submitted
+	// positions aren't necessarily the first pending ones when a submission order is configured.
+	pendingTxnsPos, err := s.getEspressoPendingTxnsPos()
+	if err != nil {
+		return err
+	}
+	remainingPos := slices.DeleteFunc(slices.Clone(pendingTxnsPos), func(pos arbutil.MessageIndex) bool {
+		return slices.Contains(submittedPos, pos)
+	})
+	if len(pendingTxnsPos)-len(remainingPos) != len(submittedPos) {
+		return fmt.Errorf("pending espresso queue changed while building the payload")
+	}
+
+	batch := s.newEspressoStateBatch()
+	if err := s.setEspressoSubmittedPos(batch, submittedPos); err != nil {
+		return err
+	}
+	if err := s.setEspressoPendingTxnsPos(batch, remainingPos); err != nil {
+		return err
+	}
+	if err := s.setEspressoSubmittedHash(batch, hash); err != nil {
+		return err
+	}
+	if err := s.setEspressoSubmittedPayload(batch, payload); err != nil {
+		return err
+	}
+	if err := s.setEspressoSubmissionStatus(batch, submittedPos, EspressoSubmissionSubmitted, hash); err != nil {
+		return err
+	}
+	return s.writeEspressoStateBatch(batch, espressoIntentSubmit)
+}
+
+// monitorEspressoReachability probes the HotShot query service, and switches to the fallback mode
+// if it has been unreachable for longer than the configured threshold. In the fallback mode HotShot
+// is treated as down: with the escape hatch, batches are posted without espresso verification,
+// otherwise messages stay queued and are submitted once HotShot is reachable again.
+// Returns true if HotShot is currently reachable.
+func (s *TransactionStreamer) monitorEspressoReachability(ctx context.Context) bool {
+	var err error
+	// Headers arriving on the stream already prove that hotshot is reachable
+	if s.espressoHeaderStream == nil || !s.espressoHeaderStream.Connected() {
+		_, err = s.espressoClient.FetchLatestBlockHeight(ctx)
+	}
+	now := time.Now()
+	if err == nil {
+		s.espressoLastReachable = now
+		if s.espressoUnreachable.Load() {
+			log.Info("hotshot is reachable again, leaving the fallback mode")
+			s.espressoUnreachable.Store(false)
+			// Submissions may have been attempted while hotshot was unreachable
+			s.espressoSubmissionReconciled = false
+		}
+		return true
+	}
+	if s.espressoLastReachable.IsZero() {
+		s.espressoLastReachable = now
+	}
+	threshold := s.config().Espresso.UnreachableThreshold
+	if threshold > 0 && !s.espressoUnreachable.Load() && now.Sub(s.espressoLastReachable) > threshold {
+		log.Warn("hotshot has been unreachable for too long, entering the fallback mode", "since", s.espressoLastReachable, "err", err)
+		if !s.HotshotDown {
+			s.beginEscapeHatchEpoch()
 		}
+		s.espressoUnreachable.Store(true)
 	}
+	return false
+}
+
+// notifyNewEspressoBlock wakes up the espressoSwitch loop when a new HotShot block arrives on the header stream
+func (s *TransactionStreamer) notifyNewEspressoBlock(height uint64) {
+	s.notifyEspressoSwitch()
+}
+
+// notifyEspressoSwitch wakes up the espressoSwitch loop without waiting for its polling interval
+func (s *TransactionStreamer) notifyEspressoSwitch() {
+	select {
+	case s.newSovereignTxNotifier <- struct{}{}:
+	default:
+	}
+}
 
-	return s.espressoTxnsPollingInterval
+// espressoSubmissionEnabled returns whether the espresso loops run, submitting the queued messages to HotShot
+func (s *TransactionStreamer) espressoSubmissionEnabled() bool {
+	return s.lightClientReader != nil && s.espressoClient != nil
+}
+
+// IsHotShotDown returns true if HotShot is considered down, either because the light client
+// reports it isn't live, or because it has been unreachable for too long.
+func (s *TransactionStreamer) IsHotShotDown() bool {
+	return s.HotshotDown || s.espressoUnreachable.Load()
 }
 
 func (s *TransactionStreamer) checkEspressoLiveness(ctx context.Context) error {
@@ -1724,6 +2815,9 @@ func (s *TransactionStreamer) checkEspressoLiveness(ctx context.Context) error {
 	// If hotshot was previously up, now it is down
 	if !live {
 		log.Warn("enabling the escape hatch, hotshot is down")
+		if !s.espressoUnreachable.Load() {
+			s.beginEscapeHatchEpoch()
+		}
 		s.HotshotDown = true
 	}
 
@@ -1806,10 +2900,84 @@ func getLogLevel(err error) func(string, ...interface{}) {
 	return logLevel
 }
 
+// reloadEspressoConfig applies changes of the hot reloadable espresso config: the HotShot query nodes
+// are replaced without restarting the node, and espresso sequencing follows the TEE verifier address.
+func (s *TransactionStreamer) reloadEspressoConfig() {
+	if s.batchPosterConfig == nil {
+		return
+	}
+	config := s.batchPosterConfig()
+	s.espressoTEEVerifierAddress = common.HexToAddress(config.EspressoTEEVerifierAddress)
+	if config.EspressoQueryUrl == s.espressoHotShotUrl {
+		return
+	}
+	urls := parseHotShotUrls(config.EspressoQueryUrl)
+	multiClient, ok := s.espressoMultiClient()
+	if !ok {
+		return
+	}
+	if err := multiClient.setUrls(urls); err != nil {
+		log.Error("failed to apply the new hotshot urls, keeping the previous ones", "urls", config.EspressoQueryUrl, "err", err)
+		return
+	}
+	if s.espressoHeaderStream != nil {
+		if err := s.espressoHeaderStream.setUrls(urls); err != nil {
+			log.Error("failed to apply the new hotshot urls to the header stream", "urls", config.EspressoQueryUrl, "err", err)
+		}
+	}
+	log.Info("hotshot urls changed", "old", s.espressoHotShotUrl, "new", config.EspressoQueryUrl)
+	s.espressoHotShotUrl = config.EspressoQueryUrl
+	// The in-flight submission may be unknown to the new query nodes
+	s.espressoSubmissionReconciled = false
+}
+
 func (s *TransactionStreamer) espressoSwitch(ctx context.Context, ignored struct{}) time.Duration {
 	retryRate := s.espressoTxnsPollingInterval * 50
+	if s.espressoStopping.Load() {
+		return retryRate
+	}
+	select {
+	case s.espressoSwitchSlot <- struct{}{}:
+		defer func() { <-s.espressoSwitchSlot }()
+	default:
+		// The shutdown is draining the espresso state
+		return retryRate
+	}
+	s.reloadEspressoConfig()
 	enabledEspresso := s.espressoTEEVerifierAddress != common.Address{}
 	if enabledEspresso {
+		if !s.monitorEspressoReachability(ctx) {
+			if ctx.Err() != nil {
+				return 0
+			}
+			return s.espressoTxnsPollingInterval
+		}
+		if !s.espressoSubmissionReconciled {
+			err := s.reconcileEspressoSubmission(ctx)
+			if err != nil {
+				if ctx.Err() != nil {
+					return 0
+				}
+				log.Warn("error reconciling the in-flight espresso submission, will retry", "err", err)
+				return retryRate
+			}
+			s.espressoSubmissionReconciled = true
+			s.espressoUncleanShutdown.Store(false)
+		}
+		if s.coordinator != nil {
+			chosen := s.coordinator.CurrentlyChosen()
+			if chosen && !s.espressoWasChosen {
+				_, err := s.adoptStaleEspressoSubmission(ctx)
+				if err != nil {
+					if ctx.Err() != nil {
+						return 0
+					}
+					log.Warn("error looking for a stale espresso submission to take over, will retry", "err", err)
+					return retryRate
+				}
+			}
+			s.espressoWasChosen = chosen
+		}
 		err := s.checkEspressoLiveness(ctx)
 		if err != nil {
 			if ctx.Err() != nil {
@@ -1824,6 +2992,10 @@ func (s *TransactionStreamer) espressoSwitch(ctx context.Context, ignored struct
 			if ctx.Err() != nil {
 				return 0
 			}
+			if errors.Is(err, ErrFinalityTransactionNotFound) && s.config().Espresso.AdaptivePolling.Enable {
+				// Not included yet, which is expected for a few HotShot blocks after the submission
+				return s.espressoPollDelay()
+			}
 			logLevel := getLogLevel(err)
 			logLevel("error polling finality, will retry", "err", err)
 			return retryRate
@@ -1832,33 +3004,234 @@ func (s *TransactionStreamer) espressoSwitch(ctx context.Context, ignored struct
 		}
 
 		shouldSubmit := s.shouldSubmitEspressoTransaction()
-		if shouldSubmit {
+		if shouldSubmit && !s.espressoStopping.Load() {
 			return s.submitEspressoTransactions(ctx)
 		}
 
-		return s.espressoTxnsPollingInterval
+		return s.espressoPollDelay()
 	} else {
 		return retryRate
 	}
 }
 
+// StepEspresso runs a single iteration of the espresso submission loop against the finality provider: the
+// in-flight submission is reconciled if needed and polled for finality, then the pending messages are submitted.
+// The reachability and liveness checks, which need HotShot and the light client, are skipped. Exposed for testing.
+func (s *TransactionStreamer) StepEspresso(ctx context.Context) error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	// Keep the espresso loop from running an iteration concurrently
+	select {
+	case s.espressoSwitchSlot <- struct{}{}:
+		defer func() { <-s.espressoSwitchSlot }()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if !s.espressoSubmissionReconciled {
+		if err := s.reconcileEspressoSubmission(ctx); err != nil {
+			return err
+		}
+		s.espressoSubmissionReconciled = true
+		s.espressoUncleanShutdown.Store(false)
+	}
+	if err := s.pollSubmittedTransactionForFinality(ctx); err != nil {
+		return err
+	}
+	s.submitEspressoTransactions(ctx)
+	return nil
+}
+
 func (s *TransactionStreamer) shouldSubmitEspressoTransaction() bool {
-	return !s.HotshotDown
+	return !s.IsHotShotDown()
 }
 
 func (s *TransactionStreamer) Start(ctxIn context.Context) error {
 	s.StopWaiter.Start(ctxIn, s)
+	if s.readOnly {
+		// Every loop writes to the database, the node owning it runs them
+		return nil
+	}
 
-	if s.lightClientReader != nil && s.espressoClient != nil {
-		err := stopwaiter.CallIterativelyWith[struct{}](&s.StopWaiterSafe, s.espressoSwitch, s.newSovereignTxNotifier)
+	if err := s.initStartupReplay(); err != nil {
+		return err
+	}
+	if err := s.loadKillSwitch(); err != nil {
+		return err
+	}
+	if err := s.initEspressoAttestation(); err != nil {
+		return err
+	}
+	if err := s.initFeedSignatureVerifier(); err != nil {
+		return err
+	}
+	if err := s.startMessageStreamServer(); err != nil {
+		return err
+	}
+	// The feed messages queued in memory before the spilled ones were lost with the previous run
+	if err := s.deleteBroadcasterQueueSpill(); err != nil {
+		return err
+	}
+	backfillMessageLookup, err := s.initMessageLookupBackfill()
+	if err != nil {
+		return err
+	}
+	if backfillMessageLookup {
+		if err := s.LaunchThreadSafe(s.runMessageLookupBackfill); err != nil {
+			return err
+		}
+	}
+	if err := s.loadPublishedEspressoActivationPos(); err != nil {
+		return err
+	}
+	if err := s.bootstrapEspressoSnapSync(s.GetContext()); err != nil {
+		return err
+	}
+	if err := s.LaunchThreadSafe(s.catchUpEspressoJustifiedWatermark); err != nil {
+		return err
+	}
+
+	if s.espressoSubmissionEnabled() {
+		if err := s.validateEspressoMigration(); err != nil {
+			return err
+		}
+		if err := s.checkEspressoShutdown(); err != nil {
+			return err
+		}
+		err := s.startWatchedLoop(&watchedLoop{
+			name:    "espresso",
+			iterate: s.espressoSwitch,
+			trigger: s.newSovereignTxNotifier,
+			// The in-flight submission is reconciled with HotShot again
+			onRestart: func() { s.espressoSubmissionReconciled = false },
+			progress:  s.espressoProgress,
+		})
 		if err != nil {
 			return err
 		}
+		if multiClient, ok := s.espressoMultiClient(); ok {
+			err = s.CallIterativelySafe(multiClient.healthCheck)
+			if err != nil {
+				return err
+			}
+		}
+		err = s.CallIterativelySafe(s.scanEspressoNamespace)
+		if err != nil {
+			return err
+		}
+		err = s.CallIterativelySafe(s.backfillEspressoJustifications)
+		if err != nil {
+			return err
+		}
+		err = s.CallIterativelySafe(s.revalidateEspressoJustifications)
+		if err != nil {
+			return err
+		}
+		err = s.CallIterativelySafe(s.expireEspressoDeadlines)
+		if err != nil {
+			return err
+		}
+		err = s.CallIterativelySafe(s.pollEspressoChainConfig)
+		if err != nil {
+			return err
+		}
+		if s.espressoHeaderStream != nil {
+			err = s.CallIterativelySafe(s.espressoHeaderStream.run)
+			if err != nil {
+				return err
+			}
+		}
 	} else {
 		log.Warn("light client reader or espresso client not set, skipping espresso verification")
 	}
+	if err := s.startEspressoDerivation(); err != nil {
+		return err
+	}
+	if err := s.CallIterativelySafe(s.espressoCheckpoints); err != nil {
+		return err
+	}
+	if err := s.CallIterativelySafe(s.pruneMessages); err != nil {
+		return err
+	}
+	if err := s.CallIterativelySafe(s.advanceMessageChain); err != nil {
+		return err
+	}
+	if err := s.CallIterativelySafe(s.checkConsistency); err != nil {
+		return err
+	}
+	if err := s.CallIterativelySafe(s.checkLoadShedding); err != nil {
+		return err
+	}
+
+	if s.exec == nil {
+		log.Info("transaction streamer has no execution client, messages won't be executed")
+	} else {
+		err := s.startWatchedLoop(&watchedLoop{
+			name:     "execution",
+			iterate:  s.executeMessages,
+			trigger:  s.newMessageNotifier,
+			progress: s.executionProgress,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	// Started last, as it reads the watched loops without synchronization
+	return s.CallIterativelySafe(s.watchLoops)
+}
+
+// StopAndWait shuts the espresso loops down in order before stopping the streamer, so that a submission
+// is never cut off halfway: new submissions are stopped first, then the in-flight submission is drained
+// and polled for finality one last time, and only then are the remaining loops stopped.
+func (s *TransactionStreamer) StopAndWait() {
+	if s.Started() && !s.readOnly && s.espressoSubmissionEnabled() {
+		s.stopEspresso()
+	}
+	s.flushSequencerGroup()
+	s.StopWaiter.StopAndWait()
+	s.messageReadCache.close()
+}
+
+func (s *TransactionStreamer) stopEspresso() {
+	s.espressoStopping.Store(true)
+	timeout := s.config().Espresso.ShutdownTimeout
+	if timeout == 0 {
+		return
+	}
+	ctx, err := s.GetContextSafe()
+	if err != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
 
-	return stopwaiter.CallIterativelyWith[struct{}](&s.StopWaiterSafe, s.executeMessages, s.newMessageNotifier)
+	select {
+	case s.espressoSwitchSlot <- struct{}{}:
+		defer func() { <-s.espressoSwitchSlot }()
+	case <-ctx.Done():
+		log.Warn("timed out waiting for the in-flight espresso submission on shutdown")
+		return
+	}
+
+	if err := s.pollSubmittedTransactionForFinality(ctx); err != nil {
+		log.Info("in-flight espresso submission not finalized on shutdown, it will be reconciled on restart", "err", err)
+	}
+	pending, err := s.getEspressoPendingTxnsPos()
+	if err != nil {
+		log.Warn("failed to read the pending espresso queue on shutdown", "err", err)
+		return
+	}
+	submitted, err := s.getEspressoSubmittedPos()
+	if err != nil {
+		log.Warn("failed to read the submitted espresso positions on shutdown", "err", err)
+		return
+	}
+	// The espresso state is written as it changes, the marker tells the next run it wasn't cut off
+	if err := s.recordEspressoCleanShutdown(len(submitted), len(pending)); err != nil {
+		log.Warn("failed to record the clean espresso shutdown", "err", err)
+		return
+	}
+	log.Info("espresso loops stopped", "pending", len(pending), "submitted", len(submitted))
 }
 
 /**
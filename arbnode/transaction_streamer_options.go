@@ -0,0 +1,130 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package arbnode
+
+import (
+	"errors"
+	"fmt"
+
+	lightclient "github.com/EspressoSystems/espresso-sequencer-go/light-client"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/params"
+
+	"github.com/offchainlabs/nitro/broadcaster"
+	"github.com/offchainlabs/nitro/execution"
+)
+
+var ErrNoExecution = errors.New("transaction streamer has no execution client")
+
+// ErrReadOnly is returned by the methods of a read-only TransactionStreamer that would write to its database
+var ErrReadOnly = errors.New("transaction streamer is read-only")
+
+// TransactionStreamerOption sets an optional dependency of a TransactionStreamer
+type TransactionStreamerOption func(*transactionStreamerOptions) error
+
+type transactionStreamerOptions struct {
+	exec              execution.ExecutionSequencer
+	broadcastServer   *broadcaster.Broadcaster
+	fatalErrChan      chan<- error
+	config            TransactionStreamerConfigFetcher
+	snapSyncConfig    *SnapSyncConfig
+	hotShotUrls       []string
+	lightClientReader lightclient.LightClientReaderInterface
+	readOnly          bool
+}
+
+// WithExecution sets the execution client messages are executed with. Without it, messages are stored but
+// never executed, and reorgs of stored messages fail with ErrNoExecution.
+func WithExecution(exec execution.ExecutionSequencer) TransactionStreamerOption {
+	return func(o *transactionStreamerOptions) error {
+		o.exec = exec
+		return nil
+	}
+}
+
+// WithBroadcaster sets the feed server new messages are broadcast to
+func WithBroadcaster(broadcastServer *broadcaster.Broadcaster) TransactionStreamerOption {
+	return func(o *transactionStreamerOptions) error {
+		o.broadcastServer = broadcastServer
+		return nil
+	}
+}
+
+// WithFatalErrChan sets the channel fatal errors are reported to
+func WithFatalErrChan(fatalErrChan chan<- error) TransactionStreamerOption {
+	return func(o *transactionStreamerOptions) error {
+		o.fatalErrChan = fatalErrChan
+		return nil
+	}
+}
+
+// WithConfig sets the config fetcher, DefaultTransactionStreamerConfig is used otherwise
+func WithConfig(config TransactionStreamerConfigFetcher) TransactionStreamerOption {
+	return func(o *transactionStreamerOptions) error {
+		o.config = config
+		return nil
+	}
+}
+
+// WithSnapSyncConfig sets the snap sync config, DefaultSnapSyncConfig is used otherwise
+func WithSnapSyncConfig(snapSyncConfig *SnapSyncConfig) TransactionStreamerOption {
+	return func(o *transactionStreamerOptions) error {
+		o.snapSyncConfig = snapSyncConfig
+		return nil
+	}
+}
+
+// WithEspresso sets the HotShot query service urls and the light client reader, which enables the
+// espresso loops of the streamer on Start. Without a TEE verifier address no transactions are submitted.
+func WithEspresso(hotShotUrls []string, lightClientReader lightclient.LightClientReaderInterface) TransactionStreamerOption {
+	return func(o *transactionStreamerOptions) error {
+		if len(hotShotUrls) == 0 {
+			return errors.New("no hotshot urls")
+		}
+		o.hotShotUrls = hotShotUrls
+		o.lightClientReader = lightClientReader
+		return nil
+	}
+}
+
+// WithReadOnly opens the streamer read-only, for inspection tools and RPC-only replicas sharing the database of
+// a running node. Messages and their results can be read, but the methods adding, importing, pruning or reorging
+// messages or changing the espresso state return ErrReadOnly, and Start doesn't launch any of the loops.
+func WithReadOnly() TransactionStreamerOption {
+	return func(o *transactionStreamerOptions) error {
+		o.readOnly = true
+		return nil
+	}
+}
+
+// NewTransactionStreamerWithOptions creates a TransactionStreamer over db with only the dependencies given as
+// options, so that tools like indexers and verifiers can reuse the streamer's storage and Espresso logic
+// without running a full node. The coordinator, validator and inbox readers can still be set afterwards.
+func NewTransactionStreamerWithOptions(db ethdb.Database, chainConfig *params.ChainConfig, opts ...TransactionStreamerOption) (*TransactionStreamer, error) {
+	options := transactionStreamerOptions{
+		config:         func() *TransactionStreamerConfig { return &DefaultTransactionStreamerConfig },
+		snapSyncConfig: &DefaultSnapSyncConfig,
+	}
+	for _, opt := range opts {
+		if err := opt(&options); err != nil {
+			return nil, err
+		}
+	}
+	streamer, err := newTransactionStreamer(db, chainConfig, options.exec, options.broadcastServer, options.fatalErrChan, options.config, options.snapSyncConfig, options.readOnly)
+	if err != nil {
+		return nil, err
+	}
+	if len(options.hotShotUrls) > 0 {
+		client, err := newEspressoMultiClient(options.hotShotUrls)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create the hotshot client: %w", err)
+		}
+		streamer.setEspressoClient(client)
+		streamer.lightClientReader = options.lightClientReader
+		streamer.espressoTxnsPollingInterval = DefaultBatchPosterConfig.EspressoTxnsPollingInterval
+		streamer.espressoSwitchDelayThreshold = DefaultBatchPosterConfig.EspressoSwitchDelayThreshold
+		streamer.espressoMaxTransactionSize = DefaultBatchPosterConfig.EspressoMaxTransactionSize
+	}
+	return streamer, nil
+}
@@ -0,0 +1,84 @@
+package arbnode
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/params"
+
+	"github.com/offchainlabs/nitro/arbos/arbostypes"
+)
+
+func TestTransactionStreamerWithoutExecution(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	_, err := NewTransactionStreamerWithOptions(rawdb.NewMemoryDatabase(), &params.ChainConfig{ChainID: big.NewInt(412346)}, WithEspresso(nil, nil))
+	if err == nil {
+		Fail(t, "espresso without hotshot urls accepted")
+	}
+
+	streamer := newTestStreamer(t, nil, nil)
+	Require(t, streamer.Start(ctx))
+	defer streamer.StopAndWait()
+
+	messages := []arbostypes.MessageWithMetadata{arbostypes.EmptyTestMessageWithMetadata, arbostypes.EmptyTestMessageWithMetadata}
+	Require(t, streamer.AddMessages(0, true, messages))
+	count, err := streamer.GetMessageCount()
+	Require(t, err)
+	if count != 2 {
+		Fail(t, "unexpected message count", count)
+	}
+	_, err = streamer.GetMessage(1)
+	Require(t, err)
+	if _, err := streamer.GetProcessedMessageCount(); !errors.Is(err, ErrNoExecution) {
+		Fail(t, "expected ErrNoExecution, got", err)
+	}
+}
+
+func TestTransactionStreamerReadOnly(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	writer := newTestStreamer(t, nil, nil)
+	Require(t, writer.AddMessages(0, true, testChainMessages(0, 3)))
+
+	reader := newTestStreamer(t, writer.db, nil, WithReadOnly())
+	if !reader.ReadOnly() || writer.ReadOnly() {
+		Fail(t, "unexpected read-only mode")
+	}
+	Require(t, reader.Start(ctx))
+	defer reader.StopAndWait()
+
+	count, err := reader.GetMessageCount()
+	Require(t, err)
+	if count != 3 {
+		Fail(t, "unexpected message count", count)
+	}
+	_, err = reader.GetMessage(2)
+	Require(t, err)
+
+	if err := reader.AddMessages(3, true, testChainMessages(3, 4)); !errors.Is(err, ErrReadOnly) {
+		Fail(t, "expected ErrReadOnly adding messages, got", err)
+	}
+	if err := reader.ReorgTo(1); !errors.Is(err, ErrReadOnly) {
+		Fail(t, "expected ErrReadOnly reorging, got", err)
+	}
+	if _, err := reader.PruneMessagesBefore(ctx, 1); !errors.Is(err, ErrReadOnly) {
+		Fail(t, "expected ErrReadOnly pruning, got", err)
+	}
+	if err := reader.SubmitEspressoTransactionPos(0, db.NewBatch()); !errors.Is(err, ErrReadOnly) {
+		Fail(t, "expected ErrReadOnly submitting to espresso, got", err)
+	}
+
+	// Messages added by the writer are seen by the reader
+	Require(t, writer.AddMessages(3, true, testChainMessages(3, 4)))
+	count, err = reader.GetMessageCount()
+	Require(t, err)
+	if count != 4 {
+		Fail(t, "message added by the writer not seen", count)
+	}
+}
@@ -0,0 +1,166 @@
+package arbnode
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/rlp"
+
+	"github.com/offchainlabs/nitro/arbos/arbostypes"
+	"github.com/offchainlabs/nitro/arbutil"
+	"github.com/offchainlabs/nitro/execution"
+)
+
+func TestGetMessages(t *testing.T) {
+	streamer := newTestImportStreamer(t)
+	var messages []arbostypes.MessageWithMetadata
+	for i := uint64(0); i < 5; i++ {
+		messages = append(messages, arbostypes.MessageWithMetadata{
+			Message: &arbostypes.L1IncomingMessage{Header: &arbostypes.L1IncomingMessageHeader{Timestamp: i}},
+		})
+	}
+	Require(t, streamer.AddMessages(0, true, messages))
+	blockHash := common.Hash{3}
+	data, err := rlp.EncodeToBytes(blockHashDBValue{BlockHash: &blockHash})
+	Require(t, err)
+	Require(t, streamer.db.Put(dbKey(blockHashInputFeedPrefix, 3), data))
+
+	got, err := streamer.GetMessagesWithBlockHash(1, 5)
+	Require(t, err)
+	if len(got) != 4 {
+		Fail(t, "unexpected number of messages", len(got))
+	}
+	for i, msg := range got {
+		if msg.MessageWithMeta.Message.Header.Timestamp != uint64(i+1) {
+			Fail(t, "unexpected message at", i+1, msg.MessageWithMeta.Message.Header.Timestamp)
+		}
+		if (msg.BlockHash != nil) != (i+1 == 3) {
+			Fail(t, "unexpected block hash at", i+1, msg.BlockHash)
+		}
+	}
+
+	empty, err := streamer.GetMessages(5, 5)
+	Require(t, err)
+	if len(empty) != 0 {
+		Fail(t, "unexpected messages in an empty range", len(empty))
+	}
+	if _, err := streamer.GetMessages(3, 6); err == nil {
+		Fail(t, "read messages past the message count")
+	}
+}
+
+func TestTransactionStreamerConfigValidate(t *testing.T) {
+	for name, modify := range map[string]func(*TransactionStreamerConfig){
+		"reorg policy":      func(c *TransactionStreamerConfig) { c.ReorgResequencePolicy = "replay-some" },
+		"read cache slot":   func(c *TransactionStreamerConfig) { c.ReadCacheMessages, c.ReadCacheSlotSize = 16, 8 },
+		"watchdog interval": func(c *TransactionStreamerConfig) { c.Watchdog.StallTimeout = time.Second },
+		"deadline fallback": func(c *TransactionStreamerConfig) { c.Espresso.DeadlineFallback = "retry" },
+	} {
+		config := DefaultTransactionStreamerConfig
+		Require(t, config.Validate())
+		modify(&config)
+		if err := config.Validate(); err == nil {
+			Fail(t, "inconsistent transaction streamer config accepted:", name)
+		}
+	}
+	testConfig := TestTransactionStreamerConfig
+	Require(t, testConfig.Validate())
+
+	batchPoster := DefaultBatchPosterConfig
+	batchPoster.EspressoTEEVerifierAddress = common.Address{1}.Hex()
+	if err := batchPoster.Validate(); err == nil {
+		Fail(t, "espresso sequencing enabled without hotshot url")
+	}
+	batchPoster.EspressoQueryUrl = "http://localhost:41000"
+	batchPoster.LightClientAddress = common.Address{2}.Hex()
+	Require(t, batchPoster.Validate())
+
+	config := ConfigDefault
+	config.BatchPoster = batchPoster
+	Require(t, config.validateEspresso())
+	config.TransactionStreamer.Espresso.UnreachableThreshold = batchPoster.EspressoTxnsPollingInterval / 2
+	if err := config.validateEspresso(); err == nil {
+		Fail(t, "unreachable threshold shorter than the polling interval accepted")
+	}
+}
+
+func TestSequencedMessageResultStored(t *testing.T) {
+	streamer := newTestImportStreamer(t)
+	streamer.exec = &headOnlyExecution{}
+	for pos := arbutil.MessageIndex(0); pos < 2; pos++ {
+		msg := arbostypes.MessageWithMetadata{
+			Message: &arbostypes.L1IncomingMessage{Header: &arbostypes.L1IncomingMessageHeader{Timestamp: uint64(pos)}},
+		}
+		result := execution.MessageResult{BlockHash: common.Hash{byte(pos) + 1}, SendRoot: common.Hash{byte(pos) + 10}}
+		Require(t, streamer.WriteMessageFromSequencer(pos, msg, result))
+	}
+	// Results are read from the streamer's table, not from the execution engine
+	streamer.exec = nil
+	for count := arbutil.MessageIndex(1); count <= 2; count++ {
+		result, err := streamer.ResultAtCount(count)
+		Require(t, err)
+		if result.BlockHash != (common.Hash{byte(count)}) || result.SendRoot != (common.Hash{byte(count) + 9}) {
+			Fail(t, "unexpected result at count", count, result)
+		}
+	}
+}
+
+// unreachableEspressoClient fails to reach hotshot while down is set
+type unreachableEspressoClient struct {
+	countingEspressoClient
+	down bool
+}
+
+func (c *unreachableEspressoClient) FetchLatestBlockHeight(ctx context.Context) (uint64, error) {
+	if c.down {
+		return 0, errors.New("connection refused")
+	}
+	return 42, nil
+}
+
+func TestEspressoReachabilityFallback(t *testing.T) {
+	ctx := context.Background()
+	client := &unreachableEspressoClient{}
+	streamer := newTestImportStreamer(t)
+	streamer.espressoClient = client
+	streamer.config().Espresso.UnreachableThreshold = 50 * time.Millisecond
+	monitor := func(expectReachable bool, expectDown bool) {
+		t.Helper()
+		if reachable := streamer.monitorEspressoReachability(ctx); reachable != expectReachable {
+			Fail(t, "unexpected hotshot reachability", reachable)
+		}
+		if down := streamer.IsHotShotDown(); down != expectDown {
+			Fail(t, "unexpected hotshot down mode", down)
+		}
+	}
+
+	monitor(true, false)
+
+	// HotShot is only considered down once it's unreachable for the threshold
+	client.down = true
+	monitor(false, false)
+	time.Sleep(60 * time.Millisecond)
+	monitor(false, true)
+	epoch, err := streamer.getEscapeHatchEpoch()
+	Require(t, err)
+	if epoch != 1 {
+		Fail(t, "escape hatch epoch not started when hotshot went down", epoch)
+	}
+	monitor(false, true)
+
+	// The fallback mode is left once it's reachable again, and the in-flight submission is reconciled
+	streamer.espressoSubmissionReconciled = true
+	client.down = false
+	monitor(true, false)
+	if streamer.espressoSubmissionReconciled {
+		Fail(t, "in-flight submission not reconciled after hotshot was unreachable")
+	}
+	epoch, err = streamer.getEscapeHatchEpoch()
+	Require(t, err)
+	if epoch != 1 {
+		Fail(t, "unexpected escape hatch epoch after recovering", epoch)
+	}
+}
@@ -71,6 +71,8 @@ type Config struct {
 	SecondaryURL            []string                 `koanf:"secondary-url"`
 	Verify                  signature.VerifierConfig `koanf:"verify"`
 	EnableCompression       bool                     `koanf:"enable-compression" reload:"hot"`
+	// Length of the windows the latency and completeness of the feed sources are compared over
+	SourceSelectionWindow time.Duration `koanf:"source-selection-window"`
 }
 
 func (c *Config) Enable() bool {
@@ -89,6 +91,7 @@ func ConfigAddOptions(prefix string, f *flag.FlagSet) {
 	f.StringSlice(prefix+".secondary-url", DefaultConfig.SecondaryURL, "list of secondary URLs of sequencer feed source. Would be started in the order they appear in the list when primary feeds fails")
 	signature.FeedVerifierConfigAddOptions(prefix+".verify", f)
 	f.Bool(prefix+".enable-compression", DefaultConfig.EnableCompression, "enable per message deflate compression support")
+	f.Duration(prefix+".source-selection-window", DefaultConfig.SourceSelectionWindow, "length of the time windows over which the latency and completeness of every feed url are measured to select the preferred one, whose version of a message wins when feeds disagree (0 = disabled)")
 }
 
 var DefaultConfig = Config{
@@ -101,6 +104,7 @@ var DefaultConfig = Config{
 	SecondaryURL:            []string{},
 	Timeout:                 20 * time.Second,
 	EnableCompression:       true,
+	SourceSelectionWindow:   time.Minute,
 }
 
 var DefaultTestConfig = Config{
@@ -119,6 +123,18 @@ type TransactionStreamerInterface interface {
 	AddBroadcastMessages(feedMessages []*m.BroadcastFeedMessage) error
 }
 
+// CheckpointListener is optionally implemented by the TransactionStreamerInterface to receive the
+// checkpoints of the feed, after their signature was verified
+type CheckpointListener interface {
+	AddBroadcastCheckpoint(checkpoint *m.CheckpointMessage) error
+}
+
+// KillSwitchListener is optionally implemented by the TransactionStreamerInterface to receive the kill switch
+// messages of the feed. They're signed by the chain owner rather than the sequencer, so the listener verifies them.
+type KillSwitchListener interface {
+	AddKillSwitchMessage(killSwitch *m.KillSwitchMessage) error
+}
+
 type BroadcastClient struct {
 	stopwaiter.StopWaiter
 
@@ -401,10 +417,14 @@ func (bc *BroadcastClient) startBackgroundReader(earlyFrameData io.Reader) {
 					log.Debug("received batch item", "count", len(res.Messages), "first seq", res.Messages[0].SequenceNumber)
 				} else if res.ConfirmedSequenceNumberMessage != nil {
 					log.Debug("confirmed sequence number", "seq", res.ConfirmedSequenceNumberMessage.SequenceNumber)
+				} else if res.CheckpointMessage != nil {
+					log.Debug("received checkpoint", "messageCount", res.CheckpointMessage.MessageCount)
+				} else if res.KillSwitchMessage != nil {
+					log.Debug("received kill switch", "nonce", res.KillSwitchMessage.Nonce, "position", res.KillSwitchMessage.Position)
 				} else {
 					log.Debug("received broadcast with no messages populated", "length", len(msg))
 				}
-				if res.Version == 1 {
+				if res.Version == m.V1 || res.Version == m.V2 {
 					if len(res.Messages) > 0 {
 						for _, message := range res.Messages {
 							if message == nil {
@@ -428,6 +448,12 @@ func (bc *BroadcastClient) startBackgroundReader(earlyFrameData io.Reader) {
 					if res.ConfirmedSequenceNumberMessage != nil && bc.confirmedSequenceNumberListener != nil {
 						bc.confirmedSequenceNumberListener <- res.ConfirmedSequenceNumberMessage.SequenceNumber
 					}
+					if res.CheckpointMessage != nil {
+						bc.handleCheckpoint(ctx, res.CheckpointMessage)
+					}
+					if res.KillSwitchMessage != nil {
+						bc.handleKillSwitch(res.KillSwitchMessage)
+					}
 				}
 			}
 		}
@@ -486,6 +512,34 @@ func (bc *BroadcastClient) StopAndWait() {
 	}
 }
 
+func (bc *BroadcastClient) handleCheckpoint(ctx context.Context, checkpoint *m.CheckpointMessage) {
+	listener, ok := bc.txStreamer.(CheckpointListener)
+	if !ok {
+		return
+	}
+	if !bc.config().Verify.Dangerous.AcceptMissing || bc.sigVerifier != nil {
+		err := bc.sigVerifier.VerifyHash(ctx, checkpoint.Signature, checkpoint.Hash(bc.chainId))
+		if err != nil {
+			// Unlike feed messages, checkpoints are only informational, so a bad signature isn't fatal
+			log.Warn("error validating checkpoint signature", "err", err, "messageCount", checkpoint.MessageCount)
+			return
+		}
+	}
+	if err := listener.AddBroadcastCheckpoint(checkpoint); err != nil {
+		log.Warn("error adding checkpoint from sequencer feed", "err", err, "messageCount", checkpoint.MessageCount)
+	}
+}
+
+func (bc *BroadcastClient) handleKillSwitch(killSwitch *m.KillSwitchMessage) {
+	listener, ok := bc.txStreamer.(KillSwitchListener)
+	if !ok {
+		return
+	}
+	if err := listener.AddKillSwitchMessage(killSwitch); err != nil {
+		log.Warn("error adding kill switch from sequencer feed", "err", err, "nonce", killSwitch.Nonce, "position", killSwitch.Position)
+	}
+}
+
 func (bc *BroadcastClient) isValidSignature(ctx context.Context, message *m.BroadcastFeedMessage) error {
 	if bc.config().Verify.Dangerous.AcceptMissing && bc.sigVerifier == nil {
 		// Verifier disabled
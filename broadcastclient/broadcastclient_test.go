@@ -201,6 +201,71 @@ func (ts *dummyTransactionStreamer) AddBroadcastMessages(feedMessages []*m.Broad
 	return nil
 }
 
+type checkpointTransactionStreamer struct {
+	*dummyTransactionStreamer
+	checkpointReceiver chan *m.CheckpointMessage
+}
+
+func (ts *checkpointTransactionStreamer) AddBroadcastCheckpoint(checkpoint *m.CheckpointMessage) error {
+	ts.checkpointReceiver <- checkpoint
+	return nil
+}
+
+func TestReceiveCheckpoint(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	settings := wsbroadcastserver.DefaultTestBroadcasterConfig
+	chainId := uint64(9742)
+
+	privateKey, err := crypto.GenerateKey()
+	Require(t, err)
+	sequencerAddr := crypto.PubkeyToAddress(privateKey.PublicKey)
+	dataSigner := signature.DataSignerFromPrivateKey(privateKey)
+
+	fatalErrChan := make(chan error, 10)
+	b := broadcaster.NewBroadcaster(func() *wsbroadcastserver.BroadcasterConfig { return &settings }, chainId, fatalErrChan, dataSigner)
+	Require(t, b.Initialize())
+	Require(t, b.Start(ctx))
+	defer b.StopAndWait()
+
+	ts := &checkpointTransactionStreamer{
+		dummyTransactionStreamer: NewDummyTransactionStreamer(chainId, &sequencerAddr),
+		checkpointReceiver:       make(chan *m.CheckpointMessage, 2),
+	}
+	broadcastClient, err := newTestBroadcastClient(DefaultTestConfig, b.ListenerAddr(), chainId, 0, ts, nil, fatalErrChan, &sequencerAddr)
+	Require(t, err)
+	broadcastClient.Start(ctx)
+	defer broadcastClient.StopAndWait()
+
+	// Wait for the client to connect, checkpoints aren't kept in the backlog
+	for b.ClientCount() == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	tampered, err := b.NewCheckpointMessage(10, common.Hash{1}, common.Hash{2}, common.Hash{})
+	Require(t, err)
+	tampered.BlockHash = common.Hash{3}
+	b.BroadcastCheckpoint(tampered)
+	checkpoint, err := b.NewCheckpointMessage(20, common.Hash{1}, common.Hash{2}, common.Hash{})
+	Require(t, err)
+	b.BroadcastCheckpoint(checkpoint)
+
+	timer := time.NewTimer(5 * time.Second)
+	defer timer.Stop()
+	select {
+	case received := <-ts.checkpointReceiver:
+		if received.MessageCount != checkpoint.MessageCount {
+			t.Fatal("checkpoint with an invalid signature was accepted")
+		}
+	case err := <-fatalErrChan:
+		t.Fatal(err)
+	case <-timer.C:
+		t.Fatal("no checkpoint received")
+	}
+}
+
 func newTestBroadcastClient(config Config, listenerAddress net.Addr, chainId uint64, currentMessageCount arbutil.MessageIndex, txStreamer TransactionStreamerInterface, confirmedSequenceNumberListener chan arbutil.MessageIndex, feedErrChan chan error, validAddr *common.Address) (*BroadcastClient, error) {
 	port := listenerAddress.(*net.TCPAddr).Port
 	var av contracts.AddressVerifierInterface
@@ -5,6 +5,7 @@ package broadcastclients
 
 import (
 	"context"
+	"fmt"
 	"sync/atomic"
 	"time"
 
@@ -23,31 +24,65 @@ const RECENT_FEED_ITEM_TTL = time.Second * 10
 const MAX_FEED_INACTIVE_TIME = time.Second * 5
 const PRIMARY_FEED_UPTIME = time.Minute * 10
 
+// sourcedFeedMessage is a feed message together with the url of the feed it was received from
+type sourcedFeedMessage struct {
+	m.BroadcastFeedMessage
+	source string
+}
+
 type Router struct {
 	stopwaiter.StopWaiter
-	messageChan                 chan m.BroadcastFeedMessage
+	messageChan                 chan sourcedFeedMessage
 	confirmedSequenceNumberChan chan arbutil.MessageIndex
 
 	forwardTxStreamer       broadcastclient.TransactionStreamerInterface
 	forwardConfirmationChan chan arbutil.MessageIndex
 }
 
-func (r *Router) AddBroadcastMessages(feedMessages []*m.BroadcastFeedMessage) error {
+// sourceRouter passes the messages of a single feed to the router, tagged with the feed's url
+type sourceRouter struct {
+	router *Router
+	source string
+}
+
+func (r *sourceRouter) AddBroadcastMessages(feedMessages []*m.BroadcastFeedMessage) error {
 	for _, feedMessage := range feedMessages {
-		r.messageChan <- *feedMessage
+		r.router.messageChan <- sourcedFeedMessage{BroadcastFeedMessage: *feedMessage, source: r.source}
 	}
 	return nil
 }
 
+// AddKillSwitchMessage passes kill switch messages straight to the transaction streamer, which ignores the copies
+// received from the other feeds by their nonce
+func (r *sourceRouter) AddKillSwitchMessage(killSwitch *m.KillSwitchMessage) error {
+	listener, ok := r.router.forwardTxStreamer.(broadcastclient.KillSwitchListener)
+	if !ok {
+		return nil
+	}
+	return listener.AddKillSwitchMessage(killSwitch)
+}
+
+// recentFeedItem is a message recently forwarded to the transaction streamer
+type recentFeedItem struct {
+	arrived time.Time
+	source  string
+	message *m.BroadcastFeedMessage
+}
+
 type BroadcastClients struct {
 	primaryClients   []*broadcastclient.BroadcastClient
 	secondaryClients []*broadcastclient.BroadcastClient
 	secondaryURL     []string
 	makeClient       func(string, *Router) (*broadcastclient.BroadcastClient, error)
+	chainId          uint64
 
 	primaryRouter   *Router
 	secondaryRouter *Router
 
+	// Measures the feed sources and selects the preferred one, nil if source selection is disabled
+	sourceSelector        *feedSourceSelector
+	sourceSelectionWindow time.Duration
+
 	// Use atomic access
 	connected atomic.Int32
 }
@@ -67,7 +102,7 @@ func NewBroadcastClients(
 	}
 	newStandardRouter := func() *Router {
 		return &Router{
-			messageChan:                 make(chan m.BroadcastFeedMessage, ROUTER_QUEUE_SIZE),
+			messageChan:                 make(chan sourcedFeedMessage, ROUTER_QUEUE_SIZE),
 			confirmedSequenceNumberChan: make(chan arbutil.MessageIndex, ROUTER_QUEUE_SIZE),
 			forwardTxStreamer:           txStreamer,
 			forwardConfirmationChan:     confirmedSequenceNumberListener,
@@ -79,6 +114,17 @@ func NewBroadcastClients(
 		primaryClients:   make([]*broadcastclient.BroadcastClient, 0, len(config.URL)),
 		secondaryClients: make([]*broadcastclient.BroadcastClient, 0, len(config.SecondaryURL)),
 		secondaryURL:     config.SecondaryURL,
+		chainId:          l2ChainId,
+	}
+	if config.SourceSelectionWindow > 0 {
+		clients.sourceSelector = newFeedSourceSelector(time.Now())
+		clients.sourceSelectionWindow = config.SourceSelectionWindow
+		for i, url := range config.URL {
+			clients.sourceSelector.addSource(url, fmt.Sprintf("primary%d", i))
+		}
+		for i, url := range config.SecondaryURL {
+			clients.sourceSelector.addSource(url, fmt.Sprintf("secondary%d", i))
+		}
 	}
 	clients.makeClient = func(url string, router *Router) (*broadcastclient.BroadcastClient, error) {
 		return broadcastclient.NewBroadcastClient(
@@ -86,7 +132,7 @@ func NewBroadcastClients(
 			url,
 			l2ChainId,
 			currentMessageCount,
-			router,
+			&sourceRouter{router: router, source: url},
 			router.confirmedSequenceNumberChan,
 			fatalErrChan,
 			addrVerifier,
@@ -140,8 +186,8 @@ func (bcs *BroadcastClients) Start(ctx context.Context) {
 	}
 
 	var lastConfirmed arbutil.MessageIndex
-	recentFeedItemsNew := make(map[arbutil.MessageIndex]time.Time, RECENT_FEED_INITIAL_MAP_SIZE)
-	recentFeedItemsOld := make(map[arbutil.MessageIndex]time.Time, RECENT_FEED_INITIAL_MAP_SIZE)
+	recentFeedItemsNew := make(map[arbutil.MessageIndex]recentFeedItem, RECENT_FEED_INITIAL_MAP_SIZE)
+	recentFeedItemsOld := make(map[arbutil.MessageIndex]recentFeedItem, RECENT_FEED_INITIAL_MAP_SIZE)
 	bcs.primaryRouter.LaunchThread(func(ctx context.Context) {
 		recentFeedItemsCleanup := time.NewTicker(RECENT_FEED_ITEM_TTL)
 		startSecondaryFeedTimer := time.NewTicker(MAX_FEED_INACTIVE_TIME)
@@ -151,16 +197,29 @@ func (bcs *BroadcastClients) Start(ctx context.Context) {
 		defer startSecondaryFeedTimer.Stop()
 		defer stopSecondaryFeedTimer.Stop()
 		defer primaryFeedIsDownTimer.Stop()
+		var sourceSelectionTimer <-chan time.Time
+		if bcs.sourceSelector != nil {
+			ticker := time.NewTicker(bcs.sourceSelectionWindow)
+			defer ticker.Stop()
+			sourceSelectionTimer = ticker.C
+		}
 
-		msgHandler := func(msg m.BroadcastFeedMessage, router *Router) error {
-			if _, ok := recentFeedItemsNew[msg.SequenceNumber]; ok {
-				return nil
+		msgHandler := func(msg sourcedFeedMessage, router *Router) error {
+			now := time.Now()
+			item, ok := recentFeedItemsNew[msg.SequenceNumber]
+			if !ok {
+				item, ok = recentFeedItemsOld[msg.SequenceNumber]
 			}
-			if _, ok := recentFeedItemsOld[msg.SequenceNumber]; ok {
-				return nil
+			if ok {
+				if !bcs.replacesRecentItem(msg, item, now) {
+					return nil
+				}
+			} else if bcs.sourceSelector != nil {
+				bcs.sourceSelector.observeFirst(msg.source)
 			}
-			recentFeedItemsNew[msg.SequenceNumber] = time.Now()
-			if err := router.forwardTxStreamer.AddBroadcastMessages([]*m.BroadcastFeedMessage{&msg}); err != nil {
+			feedMessage := msg.BroadcastFeedMessage
+			recentFeedItemsNew[msg.SequenceNumber] = recentFeedItem{arrived: now, source: msg.source, message: &feedMessage}
+			if err := router.forwardTxStreamer.AddBroadcastMessages([]*m.BroadcastFeedMessage{&feedMessage}); err != nil {
 				return err
 			}
 			return nil
@@ -185,6 +244,8 @@ func (bcs *BroadcastClients) Start(ctx context.Context) {
 			// Primary feeds have been up and running for PRIMARY_FEED_UPTIME=10 mins without a failure, stop the recently started secondary feed
 			case <-stopSecondaryFeedTimer.C:
 				bcs.stopSecondaryFeed()
+			case <-sourceSelectionTimer:
+				bcs.sourceSelector.rotate(time.Now())
 			default:
 			}
 
@@ -238,6 +299,45 @@ func (bcs *BroadcastClients) Start(ctx context.Context) {
 	})
 }
 
+// replacesRecentItem records a message that was already forwarded from another source, and returns whether it should
+// be forwarded again because it's the preferred source's version of a conflicting message
+func (bcs *BroadcastClients) replacesRecentItem(msg sourcedFeedMessage, item recentFeedItem, now time.Time) bool {
+	if bcs.sourceSelector == nil || item.source == msg.source {
+		return false
+	}
+	bcs.sourceSelector.observeDuplicate(msg.source, now.Sub(item.arrived))
+	if !bcs.sourceSelector.prefers(msg.source, item.source) {
+		return false
+	}
+	hash, err := msg.Message.Hash(msg.SequenceNumber, bcs.chainId)
+	if err != nil {
+		return false
+	}
+	previousHash, err := item.message.Message.Hash(item.message.SequenceNumber, bcs.chainId)
+	if err != nil || hash == previousHash {
+		return false
+	}
+	feedSourceConflictCounter.Inc(1)
+	log.Warn("feed sources delivered conflicting messages, following the preferred source", "sequenceNumber", msg.SequenceNumber, "preferred", msg.source, "other", item.source)
+	return true
+}
+
+// SourceSelectionHistory returns the past selections of the preferred feed source, oldest first
+func (bcs *BroadcastClients) SourceSelectionHistory() []FeedSourceSelection {
+	if bcs.sourceSelector == nil {
+		return nil
+	}
+	return bcs.sourceSelector.History()
+}
+
+// SelectedSource returns the url of the preferred feed source, empty until the first window ended
+func (bcs *BroadcastClients) SelectedSource() string {
+	if bcs.sourceSelector == nil {
+		return ""
+	}
+	return bcs.sourceSelector.Selected()
+}
+
 func (bcs *BroadcastClients) startSecondaryFeed(ctx context.Context) {
 	pos := len(bcs.secondaryClients)
 	if pos < len(bcs.secondaryURL) {
@@ -0,0 +1,186 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package broadcastclients
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+var (
+	feedSourceSwitchCounter   = metrics.NewRegisteredCounter("arb/feed/source/switches", nil)
+	feedSourceConflictCounter = metrics.NewRegisteredCounter("arb/feed/source/conflicts", nil)
+)
+
+// Number of past selections kept for operators
+const feedSourceSelectionHistorySize = 256
+
+// Sources whose completeness differs by less than this are ranked by their lag instead
+const feedSourceCompletenessTolerance = 0.01
+
+// FeedSourceStats is how a feed source performed during a selection window
+type FeedSourceStats struct {
+	URL string `json:"url"`
+	// Messages received from the source
+	Received uint64 `json:"received"`
+	// Messages the source delivered before any other source
+	First uint64 `json:"first"`
+	// Share of the window's messages the source delivered
+	Completeness float64 `json:"completeness"`
+	// Average delay of the source's messages behind their first delivery by any source
+	AverageLag time.Duration `json:"averageLag"`
+}
+
+// FeedSourceSelection is the source preferred after a selection window, with the stats it was chosen on
+type FeedSourceSelection struct {
+	WindowStart time.Time         `json:"windowStart"`
+	WindowEnd   time.Time         `json:"windowEnd"`
+	Messages    uint64            `json:"messages"`
+	Selected    string            `json:"selected"`
+	Sources     []FeedSourceStats `json:"sources"`
+}
+
+type feedSourceWindowStats struct {
+	received uint64
+	first    uint64
+	lagSum   time.Duration
+}
+
+// feedSourceSelector measures the latency and completeness of every feed source per time window, and selects
+// the best one. Messages are still forwarded from whichever source delivers them first, but the selected source's
+// version of a message replaces a conflicting version delivered first by another source.
+type feedSourceSelector struct {
+	mutex       sync.Mutex
+	labels      map[string]string
+	windowStart time.Time
+	messages    uint64
+	window      map[string]*feedSourceWindowStats
+	selected    string
+	history     []FeedSourceSelection
+}
+
+func newFeedSourceSelector(now time.Time) *feedSourceSelector {
+	return &feedSourceSelector{
+		labels:      make(map[string]string),
+		windowStart: now,
+		window:      make(map[string]*feedSourceWindowStats),
+	}
+}
+
+// addSource names the metrics of a source by a label, so that urls don't end up in metric names
+func (s *feedSourceSelector) addSource(url string, label string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.labels[url] = label
+}
+
+func (s *feedSourceSelector) stats(source string) *feedSourceWindowStats {
+	stats, ok := s.window[source]
+	if !ok {
+		stats = &feedSourceWindowStats{}
+		s.window[source] = stats
+	}
+	return stats
+}
+
+// observeFirst records a message delivered by source before any other source
+func (s *feedSourceSelector) observeFirst(source string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.messages++
+	stats := s.stats(source)
+	stats.received++
+	stats.first++
+}
+
+// observeDuplicate records a message source delivered lag after another source
+func (s *feedSourceSelector) observeDuplicate(source string, lag time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	stats := s.stats(source)
+	stats.received++
+	stats.lagSum += lag
+}
+
+// prefers returns whether a message from source replaces a conflicting one delivered first by firstSource
+func (s *feedSourceSelector) prefers(source string, firstSource string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.selected != "" && source == s.selected && firstSource != s.selected
+}
+
+func (s *feedSourceSelector) Selected() string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.selected
+}
+
+// rotate ends the current window, selecting the source with the highest completeness, and among sources
+// of about the same completeness the one with the lowest lag. The selection is kept if no messages arrived.
+func (s *feedSourceSelector) rotate(now time.Time) FeedSourceSelection {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	selection := FeedSourceSelection{
+		WindowStart: s.windowStart,
+		WindowEnd:   now,
+		Messages:    s.messages,
+		Selected:    s.selected,
+	}
+	for url, stats := range s.window {
+		source := FeedSourceStats{URL: url, Received: stats.received, First: stats.first}
+		if s.messages > 0 {
+			source.Completeness = float64(stats.received) / float64(s.messages)
+		}
+		if stats.received > 0 {
+			// #nosec G115
+			source.AverageLag = stats.lagSum / time.Duration(stats.received)
+		}
+		selection.Sources = append(selection.Sources, source)
+		if label, ok := s.labels[url]; ok {
+			metrics.GetOrRegisterGauge(fmt.Sprintf("arb/feed/source/%s/lag", label), nil).Update(source.AverageLag.Milliseconds())
+			metrics.GetOrRegisterGauge(fmt.Sprintf("arb/feed/source/%s/completeness", label), nil).Update(int64(source.Completeness * 100))
+		}
+	}
+	sort.Slice(selection.Sources, func(i, j int) bool {
+		a, b := selection.Sources[i], selection.Sources[j]
+		if a.Completeness-b.Completeness > feedSourceCompletenessTolerance || b.Completeness-a.Completeness > feedSourceCompletenessTolerance {
+			return a.Completeness > b.Completeness
+		}
+		if a.AverageLag != b.AverageLag {
+			return a.AverageLag < b.AverageLag
+		}
+		return a.URL < b.URL
+	})
+	if s.messages > 0 && len(selection.Sources) > 0 {
+		selection.Selected = selection.Sources[0].URL
+	}
+	if selection.Selected != s.selected {
+		if s.selected != "" {
+			feedSourceSwitchCounter.Inc(1)
+		}
+		log.Info("selected feed source", "url", selection.Selected, "previous", s.selected, "messages", selection.Messages)
+		s.selected = selection.Selected
+	}
+
+	s.history = append(s.history, selection)
+	if len(s.history) > feedSourceSelectionHistorySize {
+		s.history = s.history[len(s.history)-feedSourceSelectionHistorySize:]
+	}
+	s.windowStart = now
+	s.messages = 0
+	s.window = make(map[string]*feedSourceWindowStats)
+	return selection
+}
+
+// History returns the past selections, oldest first
+func (s *feedSourceSelector) History() []FeedSourceSelection {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]FeedSourceSelection{}, s.history...)
+}
@@ -0,0 +1,50 @@
+package broadcastclients
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFeedSourceSelection(t *testing.T) {
+	now := time.Now()
+	selector := newFeedSourceSelector(now)
+	selector.addSource("near", "primary0")
+	selector.addSource("far", "primary1")
+	selector.addSource("lossy", "primary2")
+
+	for i := 0; i < 100; i++ {
+		selector.observeFirst("near")
+		selector.observeDuplicate("far", 50*time.Millisecond)
+		if i%2 == 0 {
+			selector.observeDuplicate("lossy", time.Millisecond)
+		}
+	}
+	selection := selector.rotate(now.Add(time.Minute))
+	if selection.Selected != "near" || selector.Selected() != "near" {
+		t.Fatal("unexpected selected source", selection.Selected)
+	}
+	if len(selection.Sources) != 3 || selection.Sources[2].URL != "lossy" || selection.Sources[2].Completeness != 0.5 {
+		t.Fatal("unexpected source ranking", selection.Sources)
+	}
+	if !selector.prefers("near", "far") || selector.prefers("far", "near") || selector.prefers("near", "near") {
+		t.Fatal("unexpected preference between sources")
+	}
+
+	// A window without messages keeps the selection
+	selection = selector.rotate(now.Add(2 * time.Minute))
+	if selection.Selected != "near" {
+		t.Fatal("selection changed without messages", selection.Selected)
+	}
+
+	// The selection follows the source that becomes faster
+	for i := 0; i < 10; i++ {
+		selector.observeFirst("far")
+		selector.observeDuplicate("near", 20*time.Millisecond)
+	}
+	if selector.rotate(now.Add(3*time.Minute)).Selected != "far" {
+		t.Fatal("faster source not selected")
+	}
+	if history := selector.History(); len(history) != 3 || history[0].Selected != "near" || history[2].Selected != "far" {
+		t.Fatal("unexpected selection history", history)
+	}
+}
@@ -193,6 +193,7 @@ func (b *backlog) Get(start, end uint64) (*m.BroadcastMessage, error) {
 			return nil, errOutOfBounds
 		}
 	}
+	bm.Version = m.FeedVersion(bm.Messages)
 	return bm, nil
 }
 
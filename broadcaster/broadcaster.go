@@ -8,6 +8,7 @@ import (
 	"errors"
 	"net"
 	"runtime/debug"
+	"time"
 
 	"github.com/gobwas/ws"
 
@@ -27,6 +28,8 @@ type Broadcaster struct {
 	backlog    backlog.Backlog
 	chainId    uint64
 	dataSigner signature.DataSignerFunc
+	config     wsbroadcastserver.BroadcasterConfigFetcher
+	filters    []BroadcastFilter
 }
 
 func NewBroadcaster(config wsbroadcastserver.BroadcasterConfigFetcher, chainId uint64, feedErrChan chan error, dataSigner signature.DataSignerFunc) *Broadcaster {
@@ -36,6 +39,7 @@ func NewBroadcaster(config wsbroadcastserver.BroadcasterConfigFetcher, chainId u
 		backlog:    bklg,
 		chainId:    chainId,
 		dataSigner: dataSigner,
+		config:     config,
 	}
 }
 
@@ -56,11 +60,19 @@ func (b *Broadcaster) NewBroadcastFeedMessage(
 		}
 	}
 
+	var sequencedAt *uint64
+	if b.config().SequencerTimestamp {
+		// #nosec G115
+		now := uint64(time.Now().UnixMilli())
+		sequencedAt = &now
+	}
+
 	return &m.BroadcastFeedMessage{
 		SequenceNumber: sequenceNumber,
 		Message:        message,
 		BlockHash:      blockHash,
 		Signature:      messageSignature,
+		SequencedAt:    sequencedAt,
 	}, nil
 }
 
@@ -95,6 +107,22 @@ func (b *Broadcaster) BroadcastSingleFeedMessage(bfm *m.BroadcastFeedMessage) {
 func (b *Broadcaster) BroadcastMessages(
 	messagesWithBlockHash []arbostypes.MessageWithMetadataAndBlockHash,
 	seq arbutil.MessageIndex,
+) error {
+	return b.BroadcastMessagesWithEspressoFinality(messagesWithBlockHash, seq, nil)
+}
+
+// EmitsEspressoFinality returns whether the feed is in the V2 format, which carries the Espresso finality of messages
+func (b *Broadcaster) EmitsEspressoFinality() bool {
+	return b.config().FeedVersion >= m.V2
+}
+
+// BroadcastMessagesWithEspressoFinality broadcasts messages along with their Espresso finality, finality[i]
+// belongs to messagesWithBlockHash[i] and may be nil, as may finality itself. The finality is dropped unless
+// the feed is in the V2 format.
+func (b *Broadcaster) BroadcastMessagesWithEspressoFinality(
+	messagesWithBlockHash []arbostypes.MessageWithMetadataAndBlockHash,
+	seq arbutil.MessageIndex,
+	finality []*m.EspressoFinality,
 ) (err error) {
 	defer func() {
 		if r := recover(); r != nil {
@@ -109,6 +137,9 @@ func (b *Broadcaster) BroadcastMessages(
 		if err != nil {
 			return err
 		}
+		if i < len(finality) {
+			bfm.EspressoFinality = finality[i]
+		}
 		feedMessages = append(feedMessages, bfm)
 	}
 
@@ -118,9 +149,23 @@ func (b *Broadcaster) BroadcastMessages(
 }
 
 func (b *Broadcaster) BroadcastFeedMessages(messages []*m.BroadcastFeedMessage) {
+	if len(messages) > 0 {
+		messages = b.filterMessages(messages)
+		if len(messages) == 0 {
+			return
+		}
+	}
+	if !b.EmitsEspressoFinality() {
+		// Relayed messages may carry finality from a V2 feed
+		for _, message := range messages {
+			if message != nil {
+				message.EspressoFinality = nil
+			}
+		}
+	}
 
 	bm := &m.BroadcastMessage{
-		Version:  1,
+		Version:  m.FeedVersion(messages),
 		Messages: messages,
 	}
 
@@ -137,6 +182,46 @@ func (b *Broadcaster) Confirm(seq arbutil.MessageIndex) {
 	})
 }
 
+// NewCheckpointMessage creates a checkpoint of the message count, signed if the broadcaster has a signer
+func (b *Broadcaster) NewCheckpointMessage(
+	messageCount arbutil.MessageIndex,
+	accumulator common.Hash,
+	blockHash common.Hash,
+	justificationHash common.Hash,
+) (*m.CheckpointMessage, error) {
+	checkpoint := &m.CheckpointMessage{
+		MessageCount:      messageCount,
+		Accumulator:       accumulator,
+		BlockHash:         blockHash,
+		JustificationHash: justificationHash,
+	}
+	if b.dataSigner != nil {
+		var err error
+		checkpoint.Signature, err = b.dataSigner(checkpoint.Hash(b.chainId).Bytes())
+		if err != nil {
+			return nil, err
+		}
+	}
+	return checkpoint, nil
+}
+
+func (b *Broadcaster) BroadcastCheckpoint(checkpoint *m.CheckpointMessage) {
+	log.Debug("broadcasting checkpoint", "messageCount", checkpoint.MessageCount)
+	b.server.Broadcast(&m.BroadcastMessage{
+		Version:           1,
+		CheckpointMessage: checkpoint,
+	})
+}
+
+// BroadcastKillSwitch passes a kill switch message on to the feed's clients, it's signed by the chain owner
+func (b *Broadcaster) BroadcastKillSwitch(killSwitch *m.KillSwitchMessage) {
+	log.Debug("broadcasting kill switch", "nonce", killSwitch.Nonce, "position", killSwitch.Position, "engaged", killSwitch.Engaged)
+	b.server.Broadcast(&m.BroadcastMessage{
+		Version:           1,
+		KillSwitchMessage: killSwitch,
+	})
+}
+
 func (b *Broadcaster) ClientCount() int32 {
 	return b.server.ClientCount()
 }
@@ -151,6 +236,9 @@ func (b *Broadcaster) GetCachedMessageCount() int {
 }
 
 func (b *Broadcaster) Initialize() error {
+	if err := validateBroadcastFilters(b.config().Filters); err != nil {
+		return err
+	}
 	return b.server.Initialize()
 }
 
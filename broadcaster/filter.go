@@ -0,0 +1,90 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package broadcaster
+
+import (
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/log"
+
+	m "github.com/offchainlabs/nitro/broadcaster/message"
+)
+
+// BroadcastFilter transforms the messages of a broadcaster before they're broadcast and added to its backlog.
+// Messages dropped by a filter leave a gap in the sequence numbers of the feed, which resets the backlog, so
+// filters dropping messages are only suited to feeds whose consumers don't rely on catching up.
+type BroadcastFilter interface {
+	// FilterMessage returns the message to broadcast, which may be msg modified in place, or nil to drop it
+	FilterMessage(msg *m.BroadcastFeedMessage) *m.BroadcastFeedMessage
+}
+
+// BroadcastFilterFunc adapts a function to a BroadcastFilter
+type BroadcastFilterFunc func(msg *m.BroadcastFeedMessage) *m.BroadcastFeedMessage
+
+func (f BroadcastFilterFunc) FilterMessage(msg *m.BroadcastFeedMessage) *m.BroadcastFeedMessage {
+	return f(msg)
+}
+
+// Filters that can be enabled by name through the filters flag of the broadcaster
+var namedBroadcastFilters = map[string]BroadcastFilter{
+	// Keeps the espresso finality of messages without its justification, for light consumers that trust the feed
+	"strip-justifications": BroadcastFilterFunc(func(msg *m.BroadcastFeedMessage) *m.BroadcastFeedMessage {
+		if msg.EspressoFinality != nil && msg.EspressoFinality.Justification != nil {
+			finality := *msg.EspressoFinality
+			finality.Justification = nil
+			msg.EspressoFinality = &finality
+		}
+		return msg
+	}),
+}
+
+func validateBroadcastFilters(names []string) error {
+	for _, name := range names {
+		if _, ok := namedBroadcastFilters[name]; !ok {
+			return fmt.Errorf("unknown feed output filter %q", name)
+		}
+	}
+	return nil
+}
+
+// AddFilter adds a filter applied to the messages broadcast after the ones configured by name.
+// Filters must be added before the broadcaster is started.
+func (b *Broadcaster) AddFilter(filter BroadcastFilter) {
+	if b.Started() {
+		panic("trying to add broadcast filter after start")
+	}
+	b.filters = append(b.filters, filter)
+}
+
+// filterMessages applies the configured filters to messages, returning the messages left to broadcast
+func (b *Broadcaster) filterMessages(messages []*m.BroadcastFeedMessage) []*m.BroadcastFeedMessage {
+	names := b.config().Filters
+	if len(names) == 0 && len(b.filters) == 0 {
+		return messages
+	}
+	filters := make([]BroadcastFilter, 0, len(names)+len(b.filters))
+	for _, name := range names {
+		filter, ok := namedBroadcastFilters[name]
+		if !ok {
+			// Only reachable through a hot reload, the filters are validated when the broadcaster is initialized
+			log.Warn("ignoring unknown feed output filter", "filter", name)
+			continue
+		}
+		filters = append(filters, filter)
+	}
+	filters = append(filters, b.filters...)
+	filtered := make([]*m.BroadcastFeedMessage, 0, len(messages))
+	for _, message := range messages {
+		for _, filter := range filters {
+			if message == nil {
+				break
+			}
+			message = filter.FilterMessage(message)
+		}
+		if message != nil {
+			filtered = append(filtered, message)
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,51 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package broadcaster
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/offchainlabs/nitro/arbos/arbostypes"
+	"github.com/offchainlabs/nitro/arbutil"
+	m "github.com/offchainlabs/nitro/broadcaster/message"
+	"github.com/offchainlabs/nitro/wsbroadcastserver"
+)
+
+func TestBroadcastFilters(t *testing.T) {
+	config := wsbroadcastserver.DefaultTestBroadcasterConfig
+	config.FeedVersion = m.V2
+	config.Filters = []string{"strip-justifications"}
+	b := NewBroadcaster(func() *wsbroadcastserver.BroadcasterConfig { return &config }, 5555, make(chan error, 10), nil)
+	// Drops the even messages
+	b.AddFilter(BroadcastFilterFunc(func(msg *m.BroadcastFeedMessage) *m.BroadcastFeedMessage {
+		if msg.SequenceNumber%2 == 0 {
+			return nil
+		}
+		return msg
+	}))
+
+	justification := &m.EspressoFeedJustification{Header: json.RawMessage(`{}`), Proof: json.RawMessage(`{}`)}
+	var messages []*m.BroadcastFeedMessage
+	for seq := arbutil.MessageIndex(1); seq <= 4; seq++ {
+		bfm, err := b.NewBroadcastFeedMessage(arbostypes.EmptyTestMessageWithMetadata, seq, nil)
+		Require(t, err)
+		bfm.EspressoFinality = &m.EspressoFinality{HotShotHeight: 7, Justification: justification}
+		messages = append(messages, bfm)
+	}
+	filtered := b.filterMessages(messages)
+	if len(filtered) != 2 || filtered[0].SequenceNumber != 1 || filtered[1].SequenceNumber != 3 {
+		Fail(t, "unexpected filtered messages", filtered)
+	}
+	for _, msg := range filtered {
+		if msg.EspressoFinality == nil || msg.EspressoFinality.HotShotHeight != 7 || msg.EspressoFinality.Justification != nil {
+			Fail(t, "justification not stripped from the finality", msg.SequenceNumber, msg.EspressoFinality)
+		}
+	}
+
+	config.Filters = []string{"unknown"}
+	if err := b.Initialize(); err == nil {
+		Fail(t, "unknown filter accepted")
+	}
+}
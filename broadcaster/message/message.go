@@ -1,13 +1,20 @@
 package message
 
 import (
+	"encoding/binary"
+	"encoding/json"
+
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+
 	"github.com/offchainlabs/nitro/arbos/arbostypes"
 	"github.com/offchainlabs/nitro/arbutil"
 )
 
 const (
 	V1 = 1
+	// V2 feed messages may carry the Espresso finality of the messages
+	V2 = 2
 )
 
 // BroadcastMessage is the base message type for messages to send over the network.
@@ -29,6 +36,8 @@ type BroadcastMessage struct {
 	// TODO better name than messages since there are different types of messages
 	Messages                       []*BroadcastFeedMessage         `json:"messages,omitempty"`
 	ConfirmedSequenceNumberMessage *ConfirmedSequenceNumberMessage `json:"confirmedSequenceNumberMessage,omitempty"`
+	CheckpointMessage              *CheckpointMessage              `json:"checkpointMessage,omitempty"`
+	KillSwitchMessage              *KillSwitchMessage              `json:"killSwitchMessage,omitempty"`
 }
 
 type BroadcastFeedMessage struct {
@@ -36,10 +45,48 @@ type BroadcastFeedMessage struct {
 	Message        arbostypes.MessageWithMetadata `json:"message"`
 	BlockHash      *common.Hash                   `json:"blockHash,omitempty"`
 	Signature      []byte                         `json:"signature"`
+	// Optional unix time in milliseconds at which the sequencer broadcast the message.
+	// It isn't covered by the signature, and is only used to measure propagation latency.
+	SequencedAt *uint64 `json:"sequencedAt,omitempty"`
+	// Optional HotShot finality of the message, only sent in V2 feeds. It isn't covered by the signature,
+	// consumers must verify the justification against HotShot before trusting it.
+	EspressoFinality *EspressoFinality `json:"espressoFinality,omitempty"`
+	// Optional accumulator of the message hash chain over the messages up to and including this one, only sent on
+	// the messages completing an accumulator interval. It isn't covered by the signature, and is only used to
+	// check that the replica holds the same message history.
+	MessageChain *common.Hash `json:"messageChain,omitempty"`
 
 	CumulativeSumMsgSize uint64 `json:"-"`
 }
 
+// EspressoFinality identifies the Espresso transaction and HotShot block a message was finalized in
+type EspressoFinality struct {
+	HotShotHeight uint64 `json:"hotShotHeight"`
+	TxHash        string `json:"txHash"`
+	Namespace     uint64 `json:"namespace"`
+	// Optional proof of the finality of the HotShot block, verifiable against the HotShot light client contract
+	Justification *EspressoFeedJustification `json:"justification,omitempty"`
+}
+
+// EspressoFeedJustification is a block merkle proof of the HotShot header at the finality's height against the
+// block merkle root of the later block at RootHeight, to which the HotShot light client contract commits
+type EspressoFeedJustification struct {
+	Header          json.RawMessage `json:"header"`
+	RootHeight      uint64          `json:"rootHeight"`
+	BlockMerkleRoot string          `json:"blockMerkleRoot"`
+	Proof           json.RawMessage `json:"proof"`
+}
+
+// FeedVersion returns the lowest version of the feed format able to carry messages
+func FeedVersion(messages []*BroadcastFeedMessage) int {
+	for _, message := range messages {
+		if message != nil && message.EspressoFinality != nil {
+			return V2
+		}
+	}
+	return V1
+}
+
 func (m *BroadcastFeedMessage) Size() uint64 {
 	// #nosec G115
 	return uint64(len(m.Signature) + len(m.Message.Message.L2msg) + 160)
@@ -56,3 +103,47 @@ func (m *BroadcastFeedMessage) Hash(chainId uint64) (common.Hash, error) {
 type ConfirmedSequenceNumberMessage struct {
 	SequenceNumber arbutil.MessageIndex `json:"sequenceNumber"`
 }
+
+var checkpointPrefix = []byte("Arbitrum Nitro Feed Checkpoint:")
+
+// CheckpointMessage attests to the state of the signer's node at a message count: the accumulator of the
+// batch ending at the count, the hash of the block of the last message, and the hash of the Espresso
+// justification of the last message, if it has one. Checkpoints aren't kept in the backlog.
+type CheckpointMessage struct {
+	MessageCount      arbutil.MessageIndex `json:"messageCount"`
+	Accumulator       common.Hash          `json:"accumulator"`
+	BlockHash         common.Hash          `json:"blockHash"`
+	JustificationHash common.Hash          `json:"justificationHash"`
+	Signature         []byte               `json:"signature"`
+}
+
+func (c *CheckpointMessage) Hash(chainId uint64) common.Hash {
+	serializedExtraData := make([]byte, 16)
+	binary.BigEndian.PutUint64(serializedExtraData[:8], uint64(c.MessageCount))
+	binary.BigEndian.PutUint64(serializedExtraData[8:], chainId)
+	return crypto.Keccak256Hash(checkpointPrefix, serializedExtraData, c.Accumulator.Bytes(), c.BlockHash.Bytes(), c.JustificationHash.Bytes())
+}
+
+var killSwitchPrefix = []byte("Arbitrum Nitro Kill Switch:")
+
+// KillSwitchMessage is a control message signed by the chain owner. While engaged, nodes honoring it stop
+// sequencing messages and submitting them to Espresso from Position on, and keep executing the messages before.
+// A message is only honored if its nonce is above the nonce of the last honored one, so that a message releasing
+// the kill switch can't be undone by replaying an older one. Kill switch messages aren't kept in the backlog.
+type KillSwitchMessage struct {
+	Nonce     uint64               `json:"nonce"`
+	Position  arbutil.MessageIndex `json:"position"`
+	Engaged   bool                 `json:"engaged"`
+	Signature []byte               `json:"signature"`
+}
+
+func (k *KillSwitchMessage) Hash(chainId uint64) common.Hash {
+	serializedExtraData := make([]byte, 25)
+	binary.BigEndian.PutUint64(serializedExtraData[:8], k.Nonce)
+	binary.BigEndian.PutUint64(serializedExtraData[8:16], uint64(k.Position))
+	binary.BigEndian.PutUint64(serializedExtraData[16:24], chainId)
+	if k.Engaged {
+		serializedExtraData[24] = 1
+	}
+	return crypto.Keccak256Hash(killSwitchPrefix, serializedExtraData)
+}
@@ -102,3 +102,41 @@ func ExampleBroadcastMessage_confirmedseqnum() {
 	fmt.Println(buf.String())
 	// Output: {"version":1,"confirmedSequenceNumberMessage":{"sequenceNumber":1234}}
 }
+
+func ExampleBroadcastMessage_broadcastfeedmessageWithEspressoFinality() {
+	var requestId common.Hash
+	messages := []*BroadcastFeedMessage{
+		{
+			SequenceNumber: 12345,
+			Message: arbostypes.MessageWithMetadata{
+				Message: &arbostypes.L1IncomingMessage{
+					Header: &arbostypes.L1IncomingMessageHeader{
+						Kind:        0,
+						Poster:      [20]byte{},
+						BlockNumber: 0,
+						Timestamp:   0,
+						RequestId:   &requestId,
+						L1BaseFee:   big.NewInt(0),
+					},
+					L2msg: []byte{0xde, 0xad, 0xbe, 0xef},
+				},
+				DelayedMessagesRead: 3333,
+			},
+			Signature: nil,
+			EspressoFinality: &EspressoFinality{
+				HotShotHeight: 42,
+				TxHash:        "TX~abc",
+				Namespace:     412346,
+			},
+		},
+	}
+	msg := BroadcastMessage{
+		Version:  FeedVersion(messages),
+		Messages: messages,
+	}
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	_ = encoder.Encode(msg)
+	fmt.Println(buf.String())
+	// Output: {"version":2,"messages":[{"sequenceNumber":12345,"message":{"message":{"header":{"kind":0,"sender":"0x0000000000000000000000000000000000000000","blockNumber":0,"timestamp":0,"requestId":"0x0000000000000000000000000000000000000000000000000000000000000000","baseFeeL1":0},"l2Msg":"3q2+7w=="},"delayedMessagesRead":3333},"signature":null,"espressoFinality":{"hotShotHeight":42,"txHash":"TX~abc","namespace":412346}}]}
+}
@@ -0,0 +1,58 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package genericconf
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	flag "github.com/spf13/pflag"
+)
+
+// FlagAlias maps a deprecated config key to the key that replaced it.
+type FlagAlias struct {
+	Deprecated string `json:"deprecated"`
+	Current    string `json:"current"`
+	// Whether the deprecated key was ignored because the current key was set too, only set on the aliases in use
+	Overridden bool `json:"overridden,omitempty"`
+}
+
+var (
+	flagAliasesMutex sync.Mutex
+	flagAliases      = make(map[string]string)
+)
+
+// AddDeprecatedFlagAlias marks the already registered deprecated flag as an alias of current.
+// The deprecated flag is hidden from the help output and warns when used on the command line.
+// Values set through the deprecated key, from any config source, are moved to the current key
+// when the config is parsed.
+func AddDeprecatedFlagAlias(f *flag.FlagSet, deprecated string, current string) {
+	if f.Lookup(deprecated) == nil {
+		panic(fmt.Sprintf("deprecated flag %v isn't registered", deprecated))
+	}
+	if f.Lookup(current) == nil {
+		panic(fmt.Sprintf("flag %v isn't registered", current))
+	}
+	if err := f.MarkDeprecated(deprecated, fmt.Sprintf("use --%v instead", current)); err != nil {
+		panic(err)
+	}
+	flagAliasesMutex.Lock()
+	defer flagAliasesMutex.Unlock()
+	flagAliases[deprecated] = current
+}
+
+// DeprecatedFlagAliases returns every registered alias, sorted by the deprecated key.
+func DeprecatedFlagAliases() []FlagAlias {
+	flagAliasesMutex.Lock()
+	defer flagAliasesMutex.Unlock()
+	aliases := make([]FlagAlias, 0, len(flagAliases))
+	for deprecated, current := range flagAliases {
+		aliases = append(aliases, FlagAlias{Deprecated: deprecated, Current: current})
+	}
+	sort.Slice(aliases, func(i, j int) bool {
+		return aliases[i].Deprecated < aliases[j].Deprecated
+	})
+	return aliases
+}
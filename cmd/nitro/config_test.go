@@ -31,6 +31,8 @@ func TestEmptyCliConfig(t *testing.T) {
 	Require(t, err)
 	err = das.FixKeysetCLIParsing("node.data-availability.rpc-aggregator.backends", k)
 	Require(t, err)
+	k, _, err = confighelpers.ApplyDeprecatedFlagAliases(f, k)
+	Require(t, err)
 	var emptyCliNodeConfig NodeConfig
 	err = confighelpers.EndCommonParse(k, &emptyCliNodeConfig)
 	Require(t, err)
@@ -41,6 +43,39 @@ func TestEmptyCliConfig(t *testing.T) {
 	}
 }
 
+func TestDeprecatedFlagAliases(t *testing.T) {
+	f := flag.NewFlagSet("", flag.ContinueOnError)
+	NodeConfigAddOptions(f)
+	k, err := confighelpers.BeginCommonParse(f, []string{
+		"--node.batch-poster.hotshot-url", "http://old:41000",
+		"--execution.sequencer.espresso-finality-node-config.hotshot-url", "http://old:41000",
+		"--execution.sequencer.espresso-finality-node-config.query-url", "http://new:41000",
+	})
+	Require(t, err)
+	err = das.FixKeysetCLIParsing("node.data-availability.rpc-aggregator.backends", k)
+	Require(t, err)
+	k, used, err := confighelpers.ApplyDeprecatedFlagAliases(f, k)
+	Require(t, err)
+	if len(used) != 2 {
+		Fail(t, "expected both deprecated aliases to be reported, got", used)
+	}
+	for _, alias := range used {
+		expectOverridden := alias.Deprecated == "execution.sequencer.espresso-finality-node-config.hotshot-url"
+		if alias.Overridden != expectOverridden {
+			Fail(t, "unexpected override of the deprecated option", alias)
+		}
+	}
+	var config NodeConfig
+	err = confighelpers.EndCommonParse(k, &config)
+	Require(t, err)
+	if config.Node.BatchPoster.EspressoQueryUrl != "http://old:41000" {
+		Fail(t, "deprecated flag value wasn't applied", config.Node.BatchPoster.EspressoQueryUrl)
+	}
+	if config.Execution.Sequencer.EspressoFinalityNodeConfig.QueryUrl != "http://new:41000" {
+		Fail(t, "current flag value should win over the deprecated one", config.Execution.Sequencer.EspressoFinalityNodeConfig.QueryUrl)
+	}
+}
+
 func TestSeqConfig(t *testing.T) {
 	args := strings.Split("--persistent.chain /tmp/data --init.dev-init --node.parent-chain-reader.enable=false --parent-chain.id 5 --chain.id 421613 --node.batch-poster.parent-chain-wallet.pathname /l1keystore --node.batch-poster.parent-chain-wallet.password passphrase --http.addr 0.0.0.0 --ws.addr 0.0.0.0 --node.sequencer --execution.sequencer.enable --node.feed.output.enable --node.feed.output.port 9642", " ")
 	_, _, err := ParseNode(context.Background(), args)
@@ -222,6 +222,7 @@ func mainImpl() int {
 	}
 
 	log.Info("Running Arbitrum nitro node", "revision", vcsRevision, "vcs.time", vcsTime)
+	logDeprecatedOptions(nodeConfig.deprecatedOptions)
 
 	if nodeConfig.Node.Dangerous.NoL1Listener {
 		nodeConfig.Node.ParentChainReader.Enable = false
@@ -698,6 +699,8 @@ type NodeConfig struct {
 	Init             conf.InitConfig                 `koanf:"init"`
 	Rpc              genericconf.RpcConfig           `koanf:"rpc"`
 	BlocksReExecutor blocksreexecutor.Config         `koanf:"blocks-reexecutor"`
+	// Deprecated options the config was set with, logged once the logging is set up
+	deprecatedOptions []genericconf.FlagAlias
 }
 
 var NodeConfigDefault = NodeConfig{
@@ -828,6 +831,17 @@ func (c *NodeConfig) GetReloadInterval() time.Duration {
 	return c.Conf.ReloadInterval
 }
 
+// logDeprecatedOptions warns of the deprecated options in use, so that operators rename them before they're removed
+func logDeprecatedOptions(aliases []genericconf.FlagAlias) {
+	for _, alias := range aliases {
+		if alias.Overridden {
+			log.Warn("deprecated config option is overridden by its replacement, remove it", "deprecated", alias.Deprecated, "current", alias.Current)
+		} else {
+			log.Warn("using deprecated config option, rename it", "deprecated", alias.Deprecated, "current", alias.Current)
+		}
+	}
+}
+
 func ParseNode(ctx context.Context, args []string) (*NodeConfig, *genericconf.WalletConfig, error) {
 	f := flag.NewFlagSet("", flag.ContinueOnError)
 
@@ -859,10 +873,16 @@ func ParseNode(ctx context.Context, args []string) (*NodeConfig, *genericconf.Wa
 		return nil, nil, err
 	}
 
+	k, deprecatedOptions, err := confighelpers.ApplyDeprecatedFlagAliases(f, k)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	var nodeConfig NodeConfig
 	if err := confighelpers.EndCommonParse(k, &nodeConfig); err != nil {
 		return nil, nil, err
 	}
+	nodeConfig.deprecatedOptions = deprecatedOptions
 
 	// Don't print wallet passwords
 	if nodeConfig.Conf.Dump {
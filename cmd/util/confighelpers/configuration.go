@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"os"
 	"reflect"
+	"strconv"
 	"strings"
 	"time"
 
@@ -243,6 +244,68 @@ func BeginCommonParse(f *flag.FlagSet, args []string) (*koanf.Koanf, error) {
 	return k, nil
 }
 
+// flagValueSet returns true if the key was set to a non-default value from any config source
+func flagValueSet(f *flag.FlagSet, k *koanf.Koanf, key string) bool {
+	fl := f.Lookup(key)
+	if fl == nil || !k.Exists(key) {
+		return false
+	}
+	return fl.Changed || !flagValueIsDefault(fl, k, key)
+}
+
+// flagValueIsDefault compares the value of the key to the default of its flag in the type of the flag, as config
+// files spell values differently than the flag's default, e.g. "10m" for "10m0s" or 1e+06 for 1000000
+func flagValueIsDefault(fl *flag.Flag, k *koanf.Koanf, key string) bool {
+	value := fmt.Sprint(k.Get(key))
+	if value == fl.DefValue {
+		return true
+	}
+	switch fl.Value.Type() {
+	case "duration":
+		parsed, err := time.ParseDuration(value)
+		def, defErr := time.ParseDuration(fl.DefValue)
+		return err == nil && defErr == nil && parsed == def
+	case "int", "int8", "int16", "int32", "int64", "uint", "uint8", "uint16", "uint32", "uint64", "float32", "float64":
+		parsed, err := strconv.ParseFloat(value, 64)
+		def, defErr := strconv.ParseFloat(fl.DefValue, 64)
+		return err == nil && defErr == nil && parsed == def
+	case "bool":
+		parsed, err := strconv.ParseBool(value)
+		def, defErr := strconv.ParseBool(fl.DefValue)
+		return err == nil && defErr == nil && parsed == def
+	case "stringSlice":
+		return "["+strings.Join(k.Strings(key), ",")+"]" == fl.DefValue
+	}
+	return false
+}
+
+// ApplyDeprecatedFlagAliases moves values set through deprecated keys to the keys that replaced them,
+// and removes the deprecated keys. If both keys are set, the current key wins. Returns the updated
+// config and the aliases that were in use, so that operators can update their configuration. They're
+// returned rather than logged, as the config is parsed before the logging is set up.
+func ApplyDeprecatedFlagAliases(f *flag.FlagSet, k *koanf.Koanf) (*koanf.Koanf, []genericconf.FlagAlias, error) {
+	values := k.All()
+	var used []genericconf.FlagAlias
+	for _, alias := range genericconf.DeprecatedFlagAliases() {
+		if _, ok := values[alias.Deprecated]; !ok {
+			continue
+		}
+		if flagValueSet(f, k, alias.Deprecated) {
+			alias.Overridden = flagValueSet(f, k, alias.Current)
+			if !alias.Overridden {
+				values[alias.Current] = values[alias.Deprecated]
+			}
+			used = append(used, alias)
+		}
+		delete(values, alias.Deprecated)
+	}
+	newK := koanf.New(".")
+	if err := newK.Load(confmap.Provider(values, "."), nil); err != nil {
+		return nil, nil, fmt.Errorf("error applying deprecated config aliases: %w", err)
+	}
+	return newK, used, nil
+}
+
 func EndCommonParse(k *koanf.Koanf, config interface{}) error {
 	decoderConfig := mapstructure.DecoderConfig{
 		ErrorUnused: true,
@@ -0,0 +1,57 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package confighelpers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/knadh/koanf"
+	"github.com/knadh/koanf/providers/confmap"
+	flag "github.com/spf13/pflag"
+)
+
+func TestFlagValueSet(t *testing.T) {
+	f := flag.NewFlagSet("", flag.ContinueOnError)
+	f.Duration("duration", 10*time.Minute, "")
+	f.Uint64("number", 1000000, "")
+	f.Bool("bool", false, "")
+	f.StringSlice("slice", []string{"a", "b"}, "")
+	f.String("string", "default", "")
+
+	// Values spelled differently than the defaults, as config files do
+	k := koanf.New(".")
+	err := k.Load(confmap.Provider(map[string]interface{}{
+		"duration": "10m",
+		"number":   float64(1000000),
+		"bool":     "false",
+		"slice":    []interface{}{"a", "b"},
+		"string":   "default",
+	}, "."), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"duration", "number", "bool", "slice", "string"} {
+		if flagValueSet(f, k, key) {
+			t.Error("default value reported as set", key, k.Get(key))
+		}
+	}
+
+	k = koanf.New(".")
+	err = k.Load(confmap.Provider(map[string]interface{}{
+		"duration": "5m",
+		"number":   float64(5),
+		"bool":     true,
+		"slice":    []interface{}{"a"},
+		"string":   "other",
+	}, "."), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"duration", "number", "bool", "slice", "string"} {
+		if !flagValueSet(f, k, key) {
+			t.Error("changed value not reported as set", key, k.Get(key))
+		}
+	}
+}
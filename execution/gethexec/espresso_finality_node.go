@@ -43,7 +43,7 @@ func NewEspressoFinalityNode(execEngine *ExecutionEngine, configFetcher Sequence
 		execEngine:      execEngine,
 		config:          configFetcher,
 		namespace:       config.EspressoFinalityNodeConfig.Namespace,
-		espressoClient:  espressoClient.NewClient(config.EspressoFinalityNodeConfig.HotShotUrl),
+		espressoClient:  espressoClient.NewClient(config.EspressoFinalityNodeConfig.QueryUrl),
 		nextSeqBlockNum: config.EspressoFinalityNodeConfig.StartBlock,
 	}
 }
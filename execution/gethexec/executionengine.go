@@ -150,10 +150,27 @@ func (s *ExecutionEngine) backlogL1GasCharged() uint64 {
 //
 //	This function should be thread safe as the functions it calls in the execution engine are thread safe.
 func (s *ExecutionEngine) GetArbOSConfigAtHeight(height uint64) (*params.ChainConfig, error) {
+	chainConfigBytes, err := s.GetArbOSChainConfigJSONAtHeight(height)
+	if err != nil {
+		return nil, err
+	}
+	// Deserialize the chainConfig from bytes
+	var chainConfig *params.ChainConfig
+	err = json.Unmarshal(chainConfigBytes, &chainConfig)
+	if err != nil {
+		log.Error("Error deserializing ArbOS chainConfig from bytes", "err", err)
+		return nil, err
+	}
+	return chainConfig, nil
+}
+
+// GetArbOSChainConfigJSONAtHeight returns the serialized chain config stored in ArbOS at the given block height, or
+// at the current block height if height is 0. Unlike GetArbOSConfigAtHeight, it keeps the fields of the chain config
+// params.ChainConfig doesn't know of.
+func (s *ExecutionEngine) GetArbOSChainConfigJSONAtHeight(height uint64) ([]byte, error) {
 	var (
-		chainConfig *params.ChainConfig
-		state       *state.StateDB
-		err         error
+		state *state.StateDB
+		err   error
 	)
 	// if height was provided, get the ArbOS chainConfig at that height, otherwise get the config at current tip height.
 	if height != 0 {
@@ -181,18 +198,10 @@ func (s *ExecutionEngine) GetArbOSConfigAtHeight(height uint64) (*params.ChainCo
 		log.Error("Error fetching ArbOS chainConfig from ArbOS state", "err", err)
 		return nil, err
 	}
-	// Deserialize the chainConfig from bytes
-	if chainConfigBytes != nil {
-		err = json.Unmarshal(chainConfigBytes, &chainConfig)
-		if err != nil {
-			log.Error("Error deserializing ArbOS chainConfig from bytes", "err", err)
-			return nil, err
-		}
-		return chainConfig, nil
-	} else {
+	if chainConfigBytes == nil {
 		return nil, fmt.Errorf("chain config bytes is nil")
 	}
-	// If we made it here everything has gone well; we can return the chain config struct.
+	return chainConfigBytes, nil
 }
 
 func (s *ExecutionEngine) MarkFeedStart(to arbutil.MessageIndex) {
@@ -461,7 +470,7 @@ func (s *ExecutionEngine) resequenceReorgedMessages(messages []*arbostypes.Messa
 		}
 		hooks := arbos.NoopSequencingHooks()
 		hooks.DiscardInvalidTxsEarly = true
-		_, err = s.sequenceTransactionsWithBlockMutex(msg.Message.Header, txes, hooks)
+		_, err = s.sequenceTransactionsWithBlockMutex(msg.Message.Header, txes, hooks, false)
 		if err != nil {
 			log.Error("failed to re-sequence old user message removed by reorg", "err", err)
 			return
@@ -475,6 +484,13 @@ func (s *ExecutionEngine) sequencerWrapper(sequencerFunc func() (*types.Block, e
 		s.createBlocksMutex.Lock()
 		block, err := sequencerFunc()
 		s.createBlocksMutex.Unlock()
+		if err == nil && block != nil {
+			// The message may be held back for a group commit, which is done by the following sequencer writes or
+			// commitSequencedMessages, so it's waited for without the createBlocksMutex
+			if err := s.waitSequencedBlock(block); err != nil {
+				return nil, err
+			}
+		}
 		if !errors.Is(err, execution.ErrSequencerInsertLockTaken) {
 			return block, err
 		}
@@ -498,10 +514,36 @@ func (s *ExecutionEngine) sequencerWrapper(sequencerFunc func() (*types.Block, e
 	}
 }
 
+// waitSequencedBlock waits for the message of a block the sequencer created to be stored by consensus
+func (s *ExecutionEngine) waitSequencedBlock(block *types.Block) error {
+	ctx, err := s.GetContextSafe()
+	if err != nil {
+		return err
+	}
+	pos, err := s.BlockNumberToMessageIndex(block.NumberU64())
+	if err != nil {
+		return err
+	}
+	return s.consensus.WaitSequencedMessage(ctx, pos)
+}
+
+// commitSequencedMessages commits the sequenced messages consensus holds back for a group commit once they're
+// due. It holds the createBlocksMutex, so that the commit never runs during a sequencer write, which would find
+// the insertion lock taken and be retried after a delay.
+func (s *ExecutionEngine) commitSequencedMessages(ctx context.Context) time.Duration {
+	s.createBlocksMutex.Lock()
+	wait, err := s.consensus.CommitSequencedMessages()
+	s.createBlocksMutex.Unlock()
+	if err != nil {
+		log.Error("failed to commit the sequenced messages", "err", err)
+	}
+	return wait
+}
+
 func (s *ExecutionEngine) SequenceTransactions(header *arbostypes.L1IncomingMessageHeader, txes types.Transactions, hooks *arbos.SequencingHooks) (*types.Block, error) {
 	return s.sequencerWrapper(func() (*types.Block, error) {
 		hooks.TxErrors = nil
-		return s.sequenceTransactionsWithBlockMutex(header, txes, hooks)
+		return s.sequenceTransactionsWithBlockMutex(header, txes, hooks, false)
 	})
 }
 
@@ -543,7 +585,52 @@ func writeAndLog(pprof, trace *bytes.Buffer) {
 	log.Info("Transactions sequencing took longer than 2 seconds, created pprof and trace files", "pprof", pprofFile, "traceFile", traceFile)
 }
 
-func (s *ExecutionEngine) sequenceTransactionsWithBlockMutex(header *arbostypes.L1IncomingMessageHeader, txes types.Transactions, hooks *arbos.SequencingHooks) (*types.Block, error) {
+// heartbeatTimestamp returns the timestamp of the heartbeat following a head block with timestamp headTime, and
+// false if the head block isn't interval old yet. The heartbeats are spaced by the interval from the head block,
+// at a second at least. After a long idle period, the heartbeat gets the latest of these timestamps that isn't in
+// the future, rather than the one an interval after the head block, so that the chain doesn't catch up with
+// backdated heartbeat blocks, one per tick.
+func heartbeatTimestamp(headTime uint64, interval time.Duration, now time.Time) (uint64, bool) {
+	// #nosec G115
+	step := uint64(interval / time.Second)
+	if step == 0 {
+		step = 1
+	}
+	// #nosec G115
+	nowTime := uint64(now.Unix())
+	if headTime+step > nowTime {
+		return 0, false
+	}
+	return headTime + (nowTime-headTime)/step*step, true
+}
+
+// SequenceHeartbeat sequences a message without transactions if the head block is at least interval old.
+// The heartbeat header is derived only from the head block and the interval it's sequenced in, so replicas
+// receiving it through the feed or from Espresso produce the same block, and a heartbeat that was already
+// sequenced isn't repeated.
+func (s *ExecutionEngine) SequenceHeartbeat(interval time.Duration) (*types.Block, error) {
+	return s.sequencerWrapper(func() (*types.Block, error) {
+		lastBlockHeader, err := s.getCurrentHeader()
+		if err != nil {
+			return nil, err
+		}
+		timestamp, due := heartbeatTimestamp(lastBlockHeader.Time, interval, time.Now())
+		if !due {
+			return nil, nil
+		}
+		header := &arbostypes.L1IncomingMessageHeader{
+			Kind:        arbostypes.L1MessageType_L2Message,
+			Poster:      l1pricing.BatchPosterAddress,
+			BlockNumber: types.DeserializeHeaderExtraInformation(lastBlockHeader).L1BlockNumber,
+			Timestamp:   timestamp,
+			RequestId:   nil,
+			L1BaseFee:   nil,
+		}
+		return s.sequenceTransactionsWithBlockMutex(header, nil, arbos.NoopSequencingHooks(), true)
+	})
+}
+
+func (s *ExecutionEngine) sequenceTransactionsWithBlockMutex(header *arbostypes.L1IncomingMessageHeader, txes types.Transactions, hooks *arbos.SequencingHooks, allowEmpty bool) (*types.Block, error) {
 	lastBlockHeader, err := s.getCurrentHeader()
 	if err != nil {
 		return nil, err
@@ -587,7 +674,7 @@ func (s *ExecutionEngine) sequenceTransactionsWithBlockMutex(header *arbostypes.
 			break
 		}
 	}
-	if allTxsErrored {
+	if allTxsErrored && !allowEmpty {
 		return nil, nil
 	}
 
@@ -892,6 +979,29 @@ func (s *ExecutionEngine) DigestMessage(num arbutil.MessageIndex, msg *arbostype
 }
 
 func (s *ExecutionEngine) digestMessageWithBlockMutex(num arbutil.MessageIndex, msg *arbostypes.MessageWithMetadata, msgForPrefetch *arbostypes.MessageWithMetadata) (*execution.MessageResult, error) {
+	results, err := s.digestMessagesWithBlockMutex(num, []*arbostypes.MessageWithMetadata{msg}, msgForPrefetch)
+	if err != nil {
+		return nil, err
+	}
+	return results[0], nil
+}
+
+// DigestMessages creates the blocks of the consecutive messages from num, like DigestMessage does for one.
+// The block creation mutex is held, the scheduled upgrade checked and the new head notified once for all of them,
+// and each message is prefetched while the previous one is executed.
+// If a message fails, the results of the messages before it are returned with the error.
+func (s *ExecutionEngine) DigestMessages(num arbutil.MessageIndex, msgs []*arbostypes.MessageWithMetadata, msgForPrefetch *arbostypes.MessageWithMetadata) ([]*execution.MessageResult, error) {
+	if !s.createBlocksMutex.TryLock() {
+		return nil, errors.New("createBlock mutex held")
+	}
+	defer s.createBlocksMutex.Unlock()
+	return s.digestMessagesWithBlockMutex(num, msgs, msgForPrefetch)
+}
+
+func (s *ExecutionEngine) digestMessagesWithBlockMutex(num arbutil.MessageIndex, msgs []*arbostypes.MessageWithMetadata, msgForPrefetch *arbostypes.MessageWithMetadata) ([]*execution.MessageResult, error) {
+	if len(msgs) == 0 {
+		return nil, nil
+	}
 	currentHeader, err := s.getCurrentHeader()
 	if err != nil {
 		return nil, err
@@ -904,36 +1014,66 @@ func (s *ExecutionEngine) digestMessageWithBlockMutex(num arbutil.MessageIndex,
 		return nil, fmt.Errorf("wrong message number in digest got %d expected %d", num, curMsg+1)
 	}
 
-	startTime := time.Now()
-	if s.prefetchBlock && msgForPrefetch != nil {
-		go func() {
-			_, _, _, err := s.createBlockFromNextMessage(msgForPrefetch, true)
-			if err != nil {
-				return
-			}
-		}()
-	}
+	results := make([]*execution.MessageResult, 0, len(msgs))
+	var block *types.Block
+	var statedb *state.StateDB
+	for i, msg := range msgs {
+		// #nosec G115
+		msgNum := num + arbutil.MessageIndex(i)
+		nextMsg := msgForPrefetch
+		if i+1 < len(msgs) {
+			nextMsg = msgs[i+1]
+		}
 
-	block, statedb, receipts, err := s.createBlockFromNextMessage(msg, false)
-	if err != nil {
-		return nil, err
-	}
+		startTime := time.Now()
+		if s.prefetchBlock && nextMsg != nil {
+			go func() {
+				_, _, _, err := s.createBlockFromNextMessage(nextMsg, true)
+				if err != nil {
+					return
+				}
+			}()
+		}
 
-	err = s.appendBlock(block, statedb, receipts, time.Since(startTime))
-	if err != nil {
+		var receipts types.Receipts
+		block, statedb, receipts, err = s.createBlockFromNextMessage(msg, false)
+		if err != nil {
+			break
+		}
+
+		err = s.appendBlock(block, statedb, receipts, time.Since(startTime))
+		if err != nil {
+			break
+		}
+		s.cacheL1PriceDataOfMsg(msgNum, receipts, block, false)
+
+		var msgResult *execution.MessageResult
+		msgResult, err = s.resultFromHeader(block.Header())
+		if err != nil {
+			break
+		}
+		results = append(results, msgResult)
+	}
+	if len(results) == 0 {
 		return nil, err
 	}
-	s.cacheL1PriceDataOfMsg(num, receipts, block, false)
+	digestErr := err
+	// #nosec G115
+	lastNum := num + arbutil.MessageIndex(len(results)) - 1
+	if digestErr != nil {
+		block = s.bc.GetBlockByNumber(s.MessageIndexToBlockNumber(lastNum))
+		statedb = nil
+	}
 
-	if time.Now().After(s.nextScheduledVersionCheck) {
+	if statedb != nil && time.Now().After(s.nextScheduledVersionCheck) {
 		s.nextScheduledVersionCheck = time.Now().Add(time.Minute)
 		arbState, err := arbosState.OpenSystemArbosState(statedb, nil, true)
 		if err != nil {
-			return nil, err
+			return results, err
 		}
 		version, timestampInt, err := arbState.GetScheduledUpgrade()
 		if err != nil {
-			return nil, err
+			return results, err
 		}
 		var timeUntilUpgrade time.Duration
 		var timestamp time.Time
@@ -961,20 +1101,18 @@ func (s *ExecutionEngine) digestMessageWithBlockMutex(num arbutil.MessageIndex,
 		}
 	}
 
-	sharedmetrics.UpdateSequenceNumberInBlockGauge(num)
-	s.latestBlockMutex.Lock()
-	s.latestBlock = block
-	s.latestBlockMutex.Unlock()
-	select {
-	case s.newBlockNotifier <- struct{}{}:
-	default:
+	sharedmetrics.UpdateSequenceNumberInBlockGauge(lastNum)
+	if block != nil {
+		s.latestBlockMutex.Lock()
+		s.latestBlock = block
+		s.latestBlockMutex.Unlock()
+		select {
+		case s.newBlockNotifier <- struct{}{}:
+		default:
+		}
 	}
 
-	msgResult, err := s.resultFromHeader(block.Header())
-	if err != nil {
-		return nil, err
-	}
-	return msgResult, nil
+	return results, digestErr
 }
 
 func (s *ExecutionEngine) ArbOSVersionForMessageNumber(messageNum arbutil.MessageIndex) (uint64, error) {
@@ -988,6 +1126,9 @@ func (s *ExecutionEngine) ArbOSVersionForMessageNumber(messageNum arbutil.Messag
 
 func (s *ExecutionEngine) Start(ctx_in context.Context) {
 	s.StopWaiter.Start(ctx_in, s)
+	if s.consensus != nil {
+		s.CallIteratively(s.commitSequencedMessages)
+	}
 	s.LaunchThread(func(ctx context.Context) {
 		for {
 			select {
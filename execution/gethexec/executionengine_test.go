@@ -0,0 +1,43 @@
+// Copyright 2021-2024, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package gethexec
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHeartbeatTimestamp(t *testing.T) {
+	const head = 1000
+	interval := 10 * time.Second
+	for _, tc := range []struct {
+		name      string
+		interval  time.Duration
+		now       int64
+		due       bool
+		timestamp uint64
+	}{
+		{name: "recent head", interval: interval, now: head + 9},
+		{name: "due", interval: interval, now: head + 10, due: true, timestamp: head + 10},
+		{name: "due within the interval", interval: interval, now: head + 19, due: true, timestamp: head + 10},
+		// After a long idle period the heartbeat isn't backdated by more than the interval
+		{name: "long idle", interval: interval, now: head + 3600 + 5, due: true, timestamp: head + 3600},
+		{name: "sub-second interval", interval: time.Millisecond, now: head, due: false},
+		{name: "sub-second interval due", interval: time.Millisecond, now: head + 1, due: true, timestamp: head + 1},
+	} {
+		timestamp, due := heartbeatTimestamp(head, tc.interval, time.Unix(tc.now, 0))
+		if due != tc.due || timestamp != tc.timestamp {
+			t.Errorf("%s: got timestamp %d due %v, expected timestamp %d due %v", tc.name, timestamp, due, tc.timestamp, tc.due)
+		}
+	}
+
+	// A heartbeat after a long idle period is followed by the next one an interval later
+	first, _ := heartbeatTimestamp(head, interval, time.Unix(head+3600+5, 0))
+	if _, due := heartbeatTimestamp(first, interval, time.Unix(head+3600+5, 0)); due {
+		t.Error("heartbeat due again right after catching up")
+	}
+	if next, due := heartbeatTimestamp(first, interval, time.Unix(int64(first)+10, 0)); !due || next != first+10 {
+		t.Errorf("got next heartbeat timestamp %d due %v, expected %d", next, due, first+10)
+	}
+}
@@ -339,6 +339,10 @@ func (n *ExecutionNode) GetArbOSConfigAtHeight(height uint64) (*params.ChainConf
 	return config, nil
 }
 
+func (n *ExecutionNode) GetArbOSChainConfigJSONAtHeight(height uint64) ([]byte, error) {
+	return n.ExecEngine.GetArbOSChainConfigJSONAtHeight(height)
+}
+
 func (n *ExecutionNode) MarkFeedStart(to arbutil.MessageIndex) {
 	n.ExecEngine.MarkFeedStart(to)
 }
@@ -415,6 +419,9 @@ func (n *ExecutionNode) StopAndWait() {
 func (n *ExecutionNode) DigestMessage(num arbutil.MessageIndex, msg *arbostypes.MessageWithMetadata, msgForPrefetch *arbostypes.MessageWithMetadata) (*execution.MessageResult, error) {
 	return n.ExecEngine.DigestMessage(num, msg, msgForPrefetch)
 }
+func (n *ExecutionNode) DigestMessages(num arbutil.MessageIndex, msgs []*arbostypes.MessageWithMetadata, msgForPrefetch *arbostypes.MessageWithMetadata) ([]*execution.MessageResult, error) {
+	return n.ExecEngine.DigestMessages(num, msgs, msgForPrefetch)
+}
 func (n *ExecutionNode) Reorg(count arbutil.MessageIndex, newMessages []arbostypes.MessageWithMetadataAndBlockHash, oldMessages []*arbostypes.MessageWithMetadata) ([]*execution.MessageResult, error) {
 	return n.ExecEngine.Reorg(count, newMessages, oldMessages)
 }
@@ -16,6 +16,7 @@ import (
 	"time"
 
 	"github.com/offchainlabs/nitro/arbutil"
+	"github.com/offchainlabs/nitro/cmd/genericconf"
 	"github.com/offchainlabs/nitro/execution"
 	"github.com/offchainlabs/nitro/util/arbmath"
 	"github.com/offchainlabs/nitro/util/containers"
@@ -51,6 +52,7 @@ var (
 	nonceFailureCacheOverflowCounter        = metrics.NewRegisteredGauge("arb/sequencer/noncefailurecache/overflow", nil)
 	blockCreationTimer                      = metrics.NewRegisteredTimer("arb/sequencer/block/creation", nil)
 	successfulBlocksCounter                 = metrics.NewRegisteredCounter("arb/sequencer/block/successful", nil)
+	heartbeatCounter                        = metrics.NewRegisteredCounter("arb/sequencer/block/heartbeat", nil)
 	conditionalTxRejectedBySequencerCounter = metrics.NewRegisteredCounter("arb/sequencer/conditionaltx/rejected", nil)
 	conditionalTxAcceptedBySequencerCounter = metrics.NewRegisteredCounter("arb/sequencer/conditionaltx/accepted", nil)
 	l1GasPriceGauge                         = metrics.NewRegisteredGauge("arb/sequencer/l1gasprice", nil)
@@ -83,6 +85,8 @@ type SequencerConfig struct {
 	EspressoFinalityNodeConfig EspressoFinalityNodeConfig `koanf:"espresso-finality-node-config"`
 	// Espresso Finality Node creates blocks with finalized hotshot transactions
 	EnableEspressoFinalityNode bool `koanf:"enable-espresso-finality-node"`
+	// Interval after which an empty heartbeat message is sequenced when there's no traffic
+	EspressoHeartbeatInterval time.Duration `koanf:"espresso-heartbeat-interval" reload:"hot"`
 }
 
 func (c *SequencerConfig) Validate() error {
@@ -111,17 +115,38 @@ func (c *SequencerConfig) Validate() error {
 	if c.MaxTxDataSize > arbostypes.MaxL2MessageSize-50000 {
 		return errors.New("max-tx-data-size too large for MaxL2MessageSize")
 	}
+	if c.EnableEspressoFinalityNode {
+		if c.EspressoFinalityNodeConfig.QueryUrl == "" {
+			return errors.New("enable-espresso-finality-node requires espresso-finality-node-config.query-url to be set")
+		}
+		if c.EspressoFinalityNodeConfig.Namespace == 0 {
+			return errors.New("espresso-finality-node-config.namespace must be set to the chain's namespace, it can't be 0")
+		}
+	}
+	if c.EspressoHeartbeatInterval < 0 {
+		return errors.New("espresso-heartbeat-interval must not be negative")
+	}
 	return nil
 }
 
 type SequencerConfigFetcher func() *SequencerConfig
 
 type EspressoFinalityNodeConfig struct {
-	HotShotUrl string `koanf:"hotshot-url"`
+	QueryUrl   string `koanf:"query-url"`
 	StartBlock uint64 `koanf:"start-block"`
 	Namespace  uint64 `koanf:"namespace"`
 }
 
+func EspressoFinalityNodeConfigAddOptions(prefix string, f *flag.FlagSet) {
+	f.String(prefix+".query-url", DefaultSequencerConfig.EspressoFinalityNodeConfig.QueryUrl, "url of the hotshot query service the espresso finality node reads the finalized transactions from")
+	f.Uint64(prefix+".start-block", DefaultSequencerConfig.EspressoFinalityNodeConfig.StartBlock, "hotshot block height the espresso finality node starts reading from")
+	f.Uint64(prefix+".namespace", DefaultSequencerConfig.EspressoFinalityNodeConfig.Namespace, "espresso namespace of the chain's transactions")
+
+	// Flags renamed when the espresso flags were regrouped, kept working for existing deployments
+	f.String(prefix+".hotshot-url", DefaultSequencerConfig.EspressoFinalityNodeConfig.QueryUrl, "")
+	genericconf.AddDeprecatedFlagAlias(f, prefix+".hotshot-url", prefix+".query-url")
+}
+
 var DefaultSequencerConfig = SequencerConfig{
 	Enable:                      false,
 	MaxBlockSpeed:               time.Millisecond * 250,
@@ -142,6 +167,7 @@ var DefaultSequencerConfig = SequencerConfig{
 	EnableProfiling:              false,
 
 	EnableEspressoFinalityNode: false,
+	EspressoHeartbeatInterval:  0,
 }
 
 func SequencerConfigAddOptions(prefix string, f *flag.FlagSet) {
@@ -163,6 +189,8 @@ func SequencerConfigAddOptions(prefix string, f *flag.FlagSet) {
 
 	// Espresso specific flags
 	f.Bool(prefix+".enable-espresso-finality-node", DefaultSequencerConfig.EnableEspressoFinalityNode, "enable espresso finality node")
+	EspressoFinalityNodeConfigAddOptions(prefix+".espresso-finality-node-config", f)
+	f.Duration(prefix+".espresso-heartbeat-interval", DefaultSequencerConfig.EspressoHeartbeatInterval, "sequence an empty heartbeat message after this long without blocks, so that the chain keeps anchoring to espresso without traffic (0 = disabled)")
 }
 
 type txQueueItem struct {
@@ -798,6 +826,13 @@ func (s *Sequencer) createBlock(ctx context.Context) (returnValue bool) {
 
 	config := s.config()
 
+	var heartbeatChan <-chan time.Time
+	if config.EspressoHeartbeatInterval > 0 {
+		heartbeatTimer := time.NewTimer(config.EspressoHeartbeatInterval)
+		defer heartbeatTimer.Stop()
+		heartbeatChan = heartbeatTimer.C
+	}
+
 	// Clear out old nonceFailures
 	s.nonceFailures.Resize(config.NonceFailureCacheSize)
 	nextNonceExpiryTimer := s.expireNonceFailures()
@@ -830,6 +865,8 @@ func (s *Sequencer) createBlock(ctx context.Context) (returnValue bool) {
 					s.nonceFailures.Clear()
 				}
 				continue
+			case <-heartbeatChan:
+				return s.sequenceHeartbeat(ctx, config.EspressoHeartbeatInterval)
 			case <-ctx.Done():
 				return false
 			}
@@ -1007,6 +1044,27 @@ func (s *Sequencer) createBlock(ctx context.Context) (returnValue bool) {
 	return madeBlock
 }
 
+// sequenceHeartbeat sequences an empty message if no block was created for the heartbeat interval.
+// Returns true if a heartbeat was sequenced.
+func (s *Sequencer) sequenceHeartbeat(ctx context.Context, interval time.Duration) bool {
+	if s.handleInactive(ctx, nil) {
+		return false
+	}
+	block, err := s.execEngine.SequenceHeartbeat(interval)
+	if err != nil {
+		if !errors.Is(err, execution.ErrRetrySequencer) {
+			log.Error("error sequencing heartbeat", "err", err)
+		}
+		return false
+	}
+	if block == nil {
+		return false
+	}
+	heartbeatCounter.Inc(1)
+	log.Debug("sequenced heartbeat", "l2Block", block.Number())
+	return true
+}
+
 func (s *Sequencer) updateLatestParentChainBlock(header *types.Header) {
 	s.L1BlockAndTimeMutex.Lock()
 	defer s.L1BlockAndTimeMutex.Unlock()
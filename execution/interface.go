@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"testing"
+	"time"
 
 	"github.com/ethereum/go-ethereum/params"
 
@@ -31,6 +32,9 @@ var ErrSequencerInsertLockTaken = errors.New("insert lock taken")
 // always needed
 type ExecutionClient interface {
 	DigestMessage(num arbutil.MessageIndex, msg *arbostypes.MessageWithMetadata, msgForPrefetch *arbostypes.MessageWithMetadata) (*MessageResult, error)
+	// DigestMessages executes the consecutive messages from num at once, used to catch up.
+	// If it fails, the results of the messages executed before the failure are returned with the error.
+	DigestMessages(num arbutil.MessageIndex, msgs []*arbostypes.MessageWithMetadata, msgForPrefetch *arbostypes.MessageWithMetadata) ([]*MessageResult, error)
 	Reorg(count arbutil.MessageIndex, newMessages []arbostypes.MessageWithMetadataAndBlockHash, oldMessages []*arbostypes.MessageWithMetadata) ([]*MessageResult, error)
 	HeadMessageNumber() (arbutil.MessageIndex, error)
 	HeadMessageNumberSync(t *testing.T) (arbutil.MessageIndex, error)
@@ -60,6 +64,9 @@ type ExecutionSequencer interface {
 	Synced() bool
 	FullSyncProgressMap() map[string]interface{}
 	GetArbOSConfigAtHeight(height uint64) (*params.ChainConfig, error)
+	// GetArbOSChainConfigJSONAtHeight returns the serialized chain config stored in ArbOS, with the fields
+	// params.ChainConfig doesn't know of
+	GetArbOSChainConfigJSONAtHeight(height uint64) ([]byte, error)
 }
 
 type FullExecutionClient interface {
@@ -95,6 +102,12 @@ type ConsensusInfo interface {
 
 type ConsensusSequencer interface {
 	WriteMessageFromSequencer(pos arbutil.MessageIndex, msgWithMeta arbostypes.MessageWithMetadata, msgResult MessageResult) error
+	// CommitSequencedMessages commits the written messages held back for a group commit once they're due, and
+	// returns how long until it should be called again. It must not be called during a WriteMessageFromSequencer.
+	CommitSequencedMessages() (time.Duration, error)
+	// WaitSequencedMessage waits for the message written at pos to be stored if it was held back for a group
+	// commit, returning the error of the commit. It must not be called during a WriteMessageFromSequencer.
+	WaitSequencedMessage(ctx context.Context, pos arbutil.MessageIndex) error
 	ExpectChosenSequencer() error
 }
 
@@ -28,10 +28,14 @@ type Relay struct {
 	broadcaster                 *broadcaster.Broadcaster
 	confirmedSequenceNumberChan chan arbutil.MessageIndex
 	messageChan                 chan m.BroadcastFeedMessage
+	checkpointChan              chan *m.CheckpointMessage
+	killSwitchChan              chan *m.KillSwitchMessage
 }
 
 type MessageQueue struct {
-	queue chan m.BroadcastFeedMessage
+	queue       chan m.BroadcastFeedMessage
+	checkpoints chan *m.CheckpointMessage
+	killSwitch  chan *m.KillSwitchMessage
 }
 
 func (q *MessageQueue) AddBroadcastMessages(feedMessages []*m.BroadcastFeedMessage) error {
@@ -42,9 +46,26 @@ func (q *MessageQueue) AddBroadcastMessages(feedMessages []*m.BroadcastFeedMessa
 	return nil
 }
 
+// AddBroadcastCheckpoint relays the checkpoints of the feed, which are already signed by the sequencer
+func (q *MessageQueue) AddBroadcastCheckpoint(checkpoint *m.CheckpointMessage) error {
+	select {
+	case q.checkpoints <- checkpoint:
+	default:
+		// Checkpoints are periodic, a dropped one is superseded by the next
+	}
+	return nil
+}
+
+// AddKillSwitchMessage relays the kill switch messages of the feed, which are signed by the chain owner.
+// Unlike checkpoints they're never dropped.
+func (q *MessageQueue) AddKillSwitchMessage(killSwitch *m.KillSwitchMessage) error {
+	q.killSwitch <- killSwitch
+	return nil
+}
+
 func NewRelay(config *Config, feedErrChan chan error) (*Relay, error) {
 
-	q := MessageQueue{make(chan m.BroadcastFeedMessage, config.Queue)}
+	q := MessageQueue{make(chan m.BroadcastFeedMessage, config.Queue), make(chan *m.CheckpointMessage, 1), make(chan *m.KillSwitchMessage, 1)}
 
 	confirmedSequenceNumberListener := make(chan arbutil.MessageIndex, config.Queue)
 
@@ -72,6 +93,8 @@ func NewRelay(config *Config, feedErrChan chan error) (*Relay, error) {
 		broadcastClients:            clients,
 		confirmedSequenceNumberChan: confirmedSequenceNumberListener,
 		messageChan:                 q.queue,
+		checkpointChan:              q.checkpoints,
+		killSwitchChan:              q.killSwitch,
 	}, nil
 }
 
@@ -89,6 +112,8 @@ func (r *Relay) Start(ctx context.Context) error {
 	r.broadcastClients.Start(ctx)
 
 	r.LaunchThread(func(ctx context.Context) {
+		// Every feed the relay is connected to passes the kill switch messages on, they're only relayed once
+		var lastKillSwitchNonce uint64
 		for {
 			select {
 			case <-ctx.Done():
@@ -98,6 +123,13 @@ func (r *Relay) Start(ctx context.Context) error {
 				r.broadcaster.BroadcastSingleFeedMessage(&msg)
 			case cs := <-r.confirmedSequenceNumberChan:
 				r.broadcaster.Confirm(cs)
+			case checkpoint := <-r.checkpointChan:
+				r.broadcaster.BroadcastCheckpoint(checkpoint)
+			case killSwitch := <-r.killSwitchChan:
+				if killSwitch.Nonce > lastKillSwitchNonce {
+					lastKillSwitchNonce = killSwitch.Nonce
+					r.broadcaster.BroadcastKillSwitch(killSwitch)
+				}
 			}
 		}
 	})
@@ -26,7 +26,7 @@ func createEspressoFinalityNode(t *testing.T, builder *NodeBuilder) (*TestClient
 	execConfig.Sequencer.EnableEspressoFinalityNode = true
 	execConfig.Sequencer.EspressoFinalityNodeConfig.Namespace = builder.chainConfig.ChainID.Uint64()
 	execConfig.Sequencer.EspressoFinalityNodeConfig.StartBlock = 1
-	execConfig.Sequencer.EspressoFinalityNodeConfig.HotShotUrl = hotShotUrl
+	execConfig.Sequencer.EspressoFinalityNodeConfig.QueryUrl = hotShotUrl
 
 	return builder.Build2ndNode(t, &SecondNodeParams{
 		nodeConfig: nodeConfig,
@@ -0,0 +1,82 @@
+package arbtest
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/types"
+
+	"github.com/offchainlabs/nitro/execution"
+)
+
+func TestEspressoHeartbeat(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	builderSeq := NewNodeBuilder(ctx).DefaultConfig(t, false)
+	builderSeq.nodeConfig.Feed.Output = *newBroadcasterConfigTest()
+	builderSeq.execConfig.Sequencer.EspressoHeartbeatInterval = time.Second
+	cleanupSeq := builderSeq.Build(t)
+	defer cleanupSeq()
+	seqInfo, seqNode, seqClient := builderSeq.L2Info, builderSeq.L2.ConsensusNode, builderSeq.L2.Client
+
+	port := seqNode.BroadcastServer.ListenerAddr().(*net.TCPAddr).Port
+	builder := NewNodeBuilder(ctx).DefaultConfig(t, false)
+	builder.nodeConfig.Feed.Input = *newBroadcastClientConfigTest(port)
+	builder.takeOwnership = false
+	cleanup := builder.Build(t)
+	defer cleanup()
+	client := builder.L2.Client
+
+	seqInfo.GenerateAccount("User2")
+	tx := seqInfo.PrepareTx("Owner", "User2", seqInfo.TransferGas, big.NewInt(1e12), nil)
+	Require(t, seqClient.SendTransaction(ctx, tx))
+	receipt, err := builderSeq.L2.EnsureTxSucceeded(tx)
+	Require(t, err)
+
+	// No heartbeat is sequenced while the head block is recent
+	for {
+		block, err := builderSeq.L2.ExecNode.ExecEngine.SequenceHeartbeat(time.Hour)
+		if errors.Is(err, execution.ErrRetrySequencer) {
+			time.Sleep(10 * time.Millisecond)
+			continue
+		}
+		Require(t, err)
+		if block != nil {
+			Fatal(t, "heartbeat sequenced while the head block is recent", block.Number())
+		}
+		break
+	}
+
+	// Without traffic, an empty heartbeat block follows the last block
+	var heartbeat *types.Block
+	err = waitForWith(ctx, 30*time.Second, 100*time.Millisecond, func() bool {
+		heartbeat, err = seqClient.BlockByNumber(ctx, new(big.Int).Add(receipt.BlockNumber, big.NewInt(1)))
+		return err == nil
+	})
+	Require(t, err)
+	last, err := seqClient.HeaderByNumber(ctx, receipt.BlockNumber)
+	Require(t, err)
+	// Only the internal start block transaction
+	if len(heartbeat.Transactions()) != 1 {
+		Fatal(t, "heartbeat block has transactions", len(heartbeat.Transactions()))
+	}
+	if heartbeat.Time() < last.Time+1 {
+		Fatal(t, "heartbeat sequenced before the interval", "heartbeat", heartbeat.Time(), "last", last.Time)
+	}
+
+	// The replica produces the same heartbeat block from the feed
+	var replicated *types.Header
+	err = waitForWith(ctx, 30*time.Second, 100*time.Millisecond, func() bool {
+		replicated, err = client.HeaderByNumber(ctx, heartbeat.Number())
+		return err == nil
+	})
+	Require(t, err)
+	if replicated.Hash() != heartbeat.Hash() {
+		Fatal(t, "replica produced a different heartbeat block", replicated.Hash(), "expected", heartbeat.Hash())
+	}
+}
@@ -33,7 +33,7 @@ func createL1AndL2Node(
 	builder.nodeConfig.BatchPoster.PollInterval = 10 * time.Second
 	builder.nodeConfig.BatchPoster.MaxDelay = -1000 * time.Hour
 	builder.nodeConfig.BatchPoster.LightClientAddress = lightClientAddress
-	builder.nodeConfig.BatchPoster.HotShotUrl = hotShotUrl
+	builder.nodeConfig.BatchPoster.EspressoQueryUrl = hotShotUrl
 	builder.nodeConfig.BatchPoster.EspressoTEEVerifierAddress = verifierAddress
 
 	// validator config
@@ -24,6 +24,9 @@ const WANTS_LOCKOUT_VAL string = "OK"
 const INVALID_VAL string = "INVALID"
 const INVALID_URL string = "<?INVALID-URL?>"
 
+// List of recent espresso submissions. Only written by sequencer holding CHOSEN
+const ESPRESSO_SUBMISSIONS_KEY string = "coordinator.espresso.submissions"
+
 type RedisCoordinator struct {
 	Client redis.UniversalClient
 }
@@ -327,12 +327,14 @@ func (cm *ClientManager) Start(parentCtx context.Context) {
 					// This ensures that only one message is sent with the confirmed sequence number
 					if i == 0 {
 						m.ConfirmedSequenceNumberMessage = bm.ConfirmedSequenceNumberMessage
+						m.CheckpointMessage = bm.CheckpointMessage
+						m.KillSwitchMessage = bm.KillSwitchMessage
 					}
 					clientDeleteList, err = cm.doBroadcast(m)
 					logError(err, "failed to do broadcast")
 				}
 
-				// A message with ConfirmedSequenceNumberMessage could be sent without any messages
+				// A message with ConfirmedSequenceNumberMessage, CheckpointMessage or KillSwitchMessage could be sent without any messages
 				// this section ensures that message is still sent.
 				if len(bm.Messages) == 0 {
 					clientDeleteList, err = cm.doBroadcast(bm)
@@ -69,12 +69,20 @@ type BroadcasterConfig struct {
 	ConnectionLimits   ConnectionLimiterConfig `koanf:"connection-limits" reload:"hot"`
 	ClientDelay        time.Duration           `koanf:"client-delay" reload:"hot"`
 	Backlog            backlog.Config          `koanf:"backlog" reload:"hot"`
+	SequencerTimestamp bool                    `koanf:"sequencer-timestamp" reload:"hot"`
+	// Version of the feed format, V2 adds the Espresso finality of messages
+	FeedVersion int `koanf:"feed-version" reload:"hot"`
+	// Names of the filters applied to the messages before they're broadcast
+	Filters []string `koanf:"filters" reload:"hot"`
 }
 
 func (bc *BroadcasterConfig) Validate() error {
 	if !bc.EnableCompression && bc.RequireCompression {
 		return errors.New("require-compression cannot be true while enable-compression is false")
 	}
+	if bc.FeedVersion != m.V1 && bc.FeedVersion != m.V2 {
+		return fmt.Errorf("invalid feed-version %d, expected %d or %d", bc.FeedVersion, m.V1, m.V2)
+	}
 	return nil
 }
 
@@ -104,6 +112,9 @@ func BroadcasterConfigAddOptions(prefix string, f *flag.FlagSet) {
 	ConnectionLimiterConfigAddOptions(prefix+".connection-limits", f)
 	f.Duration(prefix+".client-delay", DefaultBroadcasterConfig.ClientDelay, "delay the first messages sent to each client by this amount")
 	backlog.AddOptions(prefix+".backlog", f)
+	f.Bool(prefix+".sequencer-timestamp", DefaultBroadcasterConfig.SequencerTimestamp, "include the time messages were broadcast, so replicas can measure feed propagation latency")
+	f.Int(prefix+".feed-version", DefaultBroadcasterConfig.FeedVersion, "version of the feed format to emit: 1, or 2 to include the espresso finality of messages; feed clients older than version 2 ignore version 2 messages, so only enable it once all consumers were upgraded")
+	f.StringSlice(prefix+".filters", DefaultBroadcasterConfig.Filters, "filters applied to the messages before they're broadcast: \"strip-justifications\" to broadcast the espresso finality of messages without its justification")
 }
 
 var DefaultBroadcasterConfig = BroadcasterConfig{
@@ -130,6 +141,8 @@ var DefaultBroadcasterConfig = BroadcasterConfig{
 	ConnectionLimits:   DefaultConnectionLimiterConfig,
 	ClientDelay:        0,
 	Backlog:            backlog.DefaultConfig,
+	SequencerTimestamp: false,
+	FeedVersion:        m.V1,
 }
 
 var DefaultTestBroadcasterConfig = BroadcasterConfig{
@@ -156,6 +169,8 @@ var DefaultTestBroadcasterConfig = BroadcasterConfig{
 	ConnectionLimits:   DefaultConnectionLimiterConfig,
 	ClientDelay:        0,
 	Backlog:            backlog.DefaultTestConfig,
+	SequencerTimestamp: false,
+	FeedVersion:        m.V1,
 }
 
 type WSBroadcastServer struct {